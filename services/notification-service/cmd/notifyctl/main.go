@@ -0,0 +1,125 @@
+// notifyctl is the operator CLI for the notification service.
+//
+//	notifyctl migrate --from redis --to postgres [--batch-size 500] [--read-only=true] [--read-only-ttl 1m]
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/storage"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "migrate":
+		if err := runMigrate(os.Args[2:]); err != nil {
+			log.Fatalf("migrate failed: %v", err)
+		}
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: notifyctl migrate --from <redis|postgres> --to <redis|postgres> [--batch-size N] [--read-only=true|false] [--read-only-ttl D]")
+}
+
+func runMigrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := flags.String("from", "redis", "source backend (redis or postgres)")
+	to := flags.String("to", "postgres", "destination backend (redis or postgres)")
+	batchSize := flags.Int("batch-size", 500, "records read per page")
+	readOnly := flags.Bool("read-only", true, "put the running service into read-only mode for the duration of the copy")
+	readOnlyTTL := flags.Duration("read-only-ttl", time.Minute, "how long read-only mode outlives this command if it stops without clearing it")
+	flags.Parse(args)
+
+	if *from == *to {
+		return fmt.Errorf("--from and --to must differ")
+	}
+	if *readOnlyTTL <= 0 {
+		return fmt.Errorf("--read-only-ttl must be positive")
+	}
+
+	cfg := config.Load()
+	ctx := context.Background()
+
+	source, err := storage.Open(ctx, *from, cfg)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	destination, err := storage.Open(ctx, *to, cfg)
+	if err != nil {
+		return err
+	}
+	defer destination.Close()
+
+	// The flag lives in Redis so every service replica sees it. It is extended while the
+	// copy runs and expires on its own if this command dies before clearing it.
+	if *readOnly {
+		redisClient := storage.NewRedisClient(cfg.RedisURL)
+		defer redisClient.Close()
+
+		readOnlyFlag := storage.NewReadOnlyFlag(redisClient)
+		if err := readOnlyFlag.Enable(ctx, fmt.Sprintf("migrating %s to %s", *from, *to), *readOnlyTTL); err != nil {
+			return fmt.Errorf("failed to enable read-only mode: %w", err)
+		}
+		log.Println("Service switched to read-only mode")
+
+		heartbeatCtx, stopHeartbeat := context.WithCancel(ctx)
+		heartbeatDone := make(chan struct{})
+		go func() {
+			defer close(heartbeatDone)
+			ticker := time.NewTicker(*readOnlyTTL / 3)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-heartbeatCtx.Done():
+					return
+				case <-ticker.C:
+					if err := readOnlyFlag.Extend(heartbeatCtx, *readOnlyTTL); err != nil && heartbeatCtx.Err() == nil {
+						log.Printf("WARNING: failed to extend read-only mode: %v", err)
+					}
+				}
+			}
+		}()
+		defer func() {
+			stopHeartbeat()
+			<-heartbeatDone
+			if err := readOnlyFlag.Disable(ctx); err != nil {
+				log.Printf("ERROR: failed to disable read-only mode, clear it manually: %v", err)
+				return
+			}
+			log.Println("Service read-only mode cleared")
+		}()
+	}
+
+	reports, err := storage.Migrate(ctx, source, destination, *batchSize)
+	output, _ := json.MarshalIndent(reports, "", "  ")
+	fmt.Println(string(output))
+	if err != nil {
+		return err
+	}
+
+	for _, report := range reports {
+		if !report.Verified {
+			return fmt.Errorf("%s verification failed: %d records differ", report.Kind, report.Mismatched)
+		}
+	}
+	log.Println("✓ Migration complete and verified")
+	return nil
+}
@@ -0,0 +1,165 @@
+// Package cache provides a two-tier cache: a bounded in-process L1 with TTLs in front
+// of a shared Redis L2. Concurrent misses for the same key are collapsed into a single
+// load, and every lookup and eviction is reported to the cache metrics.
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"notification-service/internal/telemetry"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Mode controls what Put does once the caller has written the source of truth
+type Mode int
+
+const (
+	// ReadThrough invalidates both tiers on Put; the next Get reloads the value
+	ReadThrough Mode = iota
+	// WriteThrough stores the written value in both tiers on Put
+	WriteThrough
+)
+
+const (
+	TierL1 = "l1"
+	TierL2 = "l2"
+)
+
+// Options configures a cache. Name is used in Redis keys and metric attributes.
+type Options struct {
+	Name       string
+	Mode       Mode
+	L1TTL      time.Duration
+	L1MaxItems int
+	L2TTL      time.Duration
+}
+
+// Loader fetches a value from the source of truth on a cache miss
+type Loader[T any] func(ctx context.Context) (T, error)
+
+// Cache is a two-tier cache of values of type T. The Redis client may be nil, in which
+// case only the in-process tier is used.
+type Cache[T any] struct {
+	opts   Options
+	l1     *memory[T]
+	redis  *redis.Client
+	flight flightGroup[T]
+}
+
+func New[T any](client *redis.Client, opts Options) *Cache[T] {
+	c := &Cache[T]{
+		opts:   opts,
+		redis:  client,
+		flight: flightGroup[T]{name: opts.Name},
+	}
+	c.l1 = newMemory[T](opts.L1MaxItems, opts.L1TTL, func(ctx context.Context, reason string) {
+		telemetry.RecordCacheEviction(ctx, opts.Name, reason)
+	})
+	return c
+}
+
+// Get returns the cached value for key, loading and caching it on a miss. Only one load
+// per key runs at a time in this process; concurrent callers share its result, and each
+// stops waiting when its own ctx ends. A load that races a Put or Delete of the key
+// returns its result without caching it, so the write isn't undone by a stale value.
+func (c *Cache[T]) Get(ctx context.Context, key string, load Loader[T]) (T, error) {
+	if value, ok := c.l1.get(ctx, key); ok {
+		telemetry.RecordCacheLookup(ctx, c.opts.Name, TierL1, true)
+		return value, nil
+	}
+	telemetry.RecordCacheLookup(ctx, c.opts.Name, TierL1, false)
+
+	return c.flight.do(ctx, key, func(ctx context.Context, keep func(store func()) bool) (T, error) {
+		if value, ok := c.getL2(ctx, key); ok {
+			keep(func() { c.l1.set(ctx, key, value) })
+			return value, nil
+		}
+
+		value, err := load(ctx)
+		if err != nil {
+			return value, err
+		}
+		c.setL2(ctx, key, value)
+		if !keep(func() { c.l1.set(ctx, key, value) }) {
+			// The key was written meanwhile; take back the stale copy just stored
+			c.deleteL2(ctx, key)
+		}
+		return value, nil
+	})
+}
+
+// Put is called after the source of truth for key has been written. In WriteThrough mode
+// it stores value in both tiers; in ReadThrough mode it invalidates them.
+func (c *Cache[T]) Put(ctx context.Context, key string, value T) {
+	if c.opts.Mode == ReadThrough {
+		c.Delete(ctx, key)
+		return
+	}
+	c.flight.forget(key)
+	c.setL2(ctx, key, value)
+	c.l1.set(ctx, key, value)
+}
+
+// Delete removes key from both tiers. Other instances keep their L1 copy until it expires.
+func (c *Cache[T]) Delete(ctx context.Context, key string) {
+	c.flight.forget(key)
+	c.l1.delete(key)
+	c.deleteL2(ctx, key)
+}
+
+func (c *Cache[T]) deleteL2(ctx context.Context, key string) {
+	if c.redis == nil {
+		return
+	}
+	if err := c.redis.Del(ctx, c.redisKey(key)).Err(); err != nil {
+		slog.WarnContext(ctx, "Cache failed to delete from Redis", "cache.name", c.opts.Name, "cache.key", key, "error", err)
+	}
+}
+
+func (c *Cache[T]) getL2(ctx context.Context, key string) (T, bool) {
+	var value T
+	if c.redis == nil {
+		return value, false
+	}
+
+	payload, err := c.redis.Get(ctx, c.redisKey(key)).Bytes()
+	if err != nil {
+		// A Redis outage degrades to loading from the source of truth
+		if !errors.Is(err, redis.Nil) {
+			slog.WarnContext(ctx, "Cache failed to read from Redis", "cache.name", c.opts.Name, "cache.key", key, "error", err)
+		}
+		telemetry.RecordCacheLookup(ctx, c.opts.Name, TierL2, false)
+		return value, false
+	}
+	if err := json.Unmarshal(payload, &value); err != nil {
+		telemetry.RecordCacheLookup(ctx, c.opts.Name, TierL2, false)
+		return value, false
+	}
+
+	telemetry.RecordCacheLookup(ctx, c.opts.Name, TierL2, true)
+	return value, true
+}
+
+func (c *Cache[T]) setL2(ctx context.Context, key string, value T) {
+	if c.redis == nil {
+		return
+	}
+
+	payload, err := json.Marshal(value)
+	if err != nil {
+		slog.WarnContext(ctx, "Cache failed to encode value", "cache.name", c.opts.Name, "cache.key", key, "error", err)
+		return
+	}
+	if err := c.redis.Set(ctx, c.redisKey(key), payload, c.opts.L2TTL).Err(); err != nil {
+		slog.WarnContext(ctx, "Cache failed to write to Redis", "cache.name", c.opts.Name, "cache.key", key, "error", err)
+	}
+}
+
+func (c *Cache[T]) redisKey(key string) string {
+	return "cache:" + c.opts.Name + ":" + key
+}
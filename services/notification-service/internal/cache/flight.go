@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+)
+
+type flightCall[T any] struct {
+	done    chan struct{}
+	value   T
+	err     error
+	cancel  context.CancelFunc
+	waiters int
+	// forgotten is set once the key is written or every caller has given up; the load's
+	// result still reaches its callers but is no longer cached
+	forgotten bool
+}
+
+// flightGroup collapses concurrent loads of the same key into one, so a hot key
+// expiring does not send every waiting request to Redis and the source of truth
+type flightGroup[T any] struct {
+	name  string
+	mutex sync.Mutex
+	calls map[string]*flightCall[T]
+}
+
+// do returns the result of the load of key in flight, starting one with fn if there is
+// none. The load runs on its own goroutine with ctx's values but not its cancellation,
+// so the caller that started it giving up doesn't fail the others; it is cancelled once
+// every caller has. A panicking load fails its callers with an error. fn caches its
+// result through keep, which runs store only while the key hasn't been written since
+// the load started, and reports whether it did.
+func (g *flightGroup[T]) do(ctx context.Context, key string, fn func(ctx context.Context, keep func(store func()) bool) (T, error)) (T, error) {
+	g.mutex.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall[T])
+	}
+	call, ok := g.calls[key]
+	if !ok {
+		loadCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		call = &flightCall[T]{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = call
+		go g.run(loadCtx, key, call, fn)
+	}
+	call.waiters++
+	g.mutex.Unlock()
+
+	select {
+	case <-call.done:
+		return call.value, call.err
+	case <-ctx.Done():
+		g.mutex.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			g.forgetLocked(key, call)
+			call.cancel()
+		}
+		g.mutex.Unlock()
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// forget stops the load of key in flight from caching its result and makes the next
+// lookup start a new one; called when the key is written
+func (g *flightGroup[T]) forget(key string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if call, ok := g.calls[key]; ok {
+		g.forgetLocked(key, call)
+	}
+}
+
+func (g *flightGroup[T]) forgetLocked(key string, call *flightCall[T]) {
+	call.forgotten = true
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+}
+
+func (g *flightGroup[T]) run(ctx context.Context, key string, call *flightCall[T], fn func(ctx context.Context, keep func(store func()) bool) (T, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.ErrorContext(ctx, "Cache load panicked", "cache.name", g.name, "cache.key", key, "error", r, "stack", string(debug.Stack()))
+			var zero T
+			call.value, call.err = zero, fmt.Errorf("cache %s load of %q panicked: %v", g.name, key, r)
+		}
+		g.mutex.Lock()
+		g.forgetLocked(key, call)
+		g.mutex.Unlock()
+		call.cancel()
+		close(call.done)
+	}()
+
+	call.value, call.err = fn(ctx, func(store func()) bool {
+		g.mutex.Lock()
+		defer g.mutex.Unlock()
+		if call.forgotten {
+			return false
+		}
+		store()
+		return true
+	})
+}
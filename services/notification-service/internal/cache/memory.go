@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Eviction reasons reported to metrics
+const (
+	EvictionCapacity = "capacity"
+	EvictionExpired  = "expired"
+)
+
+type memoryEntry[T any] struct {
+	key       string
+	value     T
+	expiresAt time.Time
+}
+
+// memory is the L1 tier: an LRU bounded by item count, with a per-entry TTL
+type memory[T any] struct {
+	mutex    sync.Mutex
+	maxItems int
+	ttl      time.Duration
+	order    *list.List
+	items    map[string]*list.Element
+	onEvict  func(ctx context.Context, reason string)
+}
+
+func newMemory[T any](maxItems int, ttl time.Duration, onEvict func(ctx context.Context, reason string)) *memory[T] {
+	return &memory[T]{
+		maxItems: maxItems,
+		ttl:      ttl,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+		onEvict:  onEvict,
+	}
+}
+
+func (m *memory[T]) get(ctx context.Context, key string) (T, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var zero T
+	element, ok := m.items[key]
+	if !ok {
+		return zero, false
+	}
+
+	entry := element.Value.(*memoryEntry[T])
+	if m.ttl > 0 && time.Now().After(entry.expiresAt) {
+		m.remove(element)
+		m.onEvict(ctx, EvictionExpired)
+		return zero, false
+	}
+
+	m.order.MoveToFront(element)
+	return entry.value, true
+}
+
+func (m *memory[T]) set(ctx context.Context, key string, value T) {
+	if m.maxItems <= 0 {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	expiresAt := time.Now().Add(m.ttl)
+	if element, ok := m.items[key]; ok {
+		entry := element.Value.(*memoryEntry[T])
+		entry.value = value
+		entry.expiresAt = expiresAt
+		m.order.MoveToFront(element)
+		return
+	}
+
+	m.items[key] = m.order.PushFront(&memoryEntry[T]{key: key, value: value, expiresAt: expiresAt})
+	for m.order.Len() > m.maxItems {
+		m.remove(m.order.Back())
+		m.onEvict(ctx, EvictionCapacity)
+	}
+}
+
+func (m *memory[T]) delete(key string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if element, ok := m.items[key]; ok {
+		m.remove(element)
+	}
+}
+
+func (m *memory[T]) remove(element *list.Element) {
+	m.order.Remove(element)
+	delete(m.items, element.Value.(*memoryEntry[T]).key)
+}
@@ -7,48 +7,386 @@ import (
 
 type Config struct {
 	// Server configuration
-	Port        string
-	Environment string
+	Port           string
+	Environment    string
+	BindAddresses  string
+	UnixSocketMode string
+	RouterMode     string
+
+	// How long shutdown waits for in-flight work to drain
+	ShutdownTimeoutSeconds int
+
+	// gRPC API on a second port (empty disables it)
+	GRPCPort         string
+	GRPCStatusPollMs int
 
 	// OpenTelemetry configuration
 	OTLPTracesEndpoint  string
 	OTLPMetricsEndpoint string
 	OTLPLogsEndpoint    string
 	ServiceName         string
+	PrometheusEnabled   bool
+
+	// Go runtime metrics (goroutines, GC, heap) and host metrics (CPU, memory, network)
+	RuntimeMetricsEnabled bool
+	HostMetricsEnabled    bool
+
+	// Where telemetry goes: otlp, azuremonitor (the Application Insights resource of the
+	// connection string) or both
+	TelemetryExporter                   string
+	ApplicationInsightsConnectionString string
+	// ApplicationInsightsAuthenticationString turns on Entra ID auth for Application
+	// Insights: "Authorization=AAD", with ";ClientId=<id>" for a user-assigned identity
+	ApplicationInsightsAuthenticationString string
+
+	// Sidecar collector: a base endpoint for signals without their own, a Unix socket
+	// the collector listens on, and how long startup waits for it
+	OTLPEndpoint           string
+	OTLPSocket             string
+	OTLPStartupWaitSeconds int
+
+	// OTLP transport: http/protobuf or grpc, headers sent with every export (such as an
+	// API key) as key=value pairs, and the CA and client certificate files for TLS
+	OTLPProtocol          string
+	OTLPHeaders           string
+	OTLPCertificate       string
+	OTLPClientCertificate string
+	OTLPClientKey         string
+
+	// Logging: the minimum level and the stdout format (text or json)
+	LogLevel  string
+	LogFormat string
 
 	// Redis configuration
-	RedisURL string
+	RedisURL                   string
+	RedisBufferCapacity        int
+	RedisBufferFlushIntervalMs int
 
 	// Event Hub configuration
 	EventHubConnectionString string
 	EventHubName             string
+	// Events of a received batch handled at once per partition
+	EventHubHandlerConcurrency int
+
+	// Event Hub regional failover configuration
+	EventHubSecondaryConnectionString         string
+	EventHubProducerSecondaryConnectionString string
+	EventHubFailoverThreshold                 int
+	EventHubFailbackProbeSeconds              int
+
+	// Event Hub producer configuration (outbound lifecycle events)
+	EventHubProducerConnectionString string
+	EventHubProducerName             string
+	ProducerBatchSize                int
+	ProducerFlushIntervalMs          int
+	ProducerMaxRetries               int
+	ProducerMaxPending               int
+
+	// Service Bus configuration (order events from a queue or topic subscription)
+	ServiceBusConnectionString string
+	ServiceBusQueueName        string
+	ServiceBusTopicName        string
+	ServiceBusSubscriptionName string
+	ServiceBusMaxMessages      int
 
 	// Database configuration
-	DatabaseURL string
+	DatabaseURL              string
+	DatabaseMigrateOnStartup bool
+
+	// Readiness: dependency check results are reused for this long
+	ReadinessCacheSeconds int
 
 	// Email service configuration
-	SMTPHost     string
-	SMTPPort     int
-	SMTPUsername string
-	SMTPPassword string
-	FromEmail    string
+	SMTPHost       string
+	SMTPPort       int
+	SMTPUsername   string
+	SMTPPassword   string
+	FromEmail      string
+	SMTPTLSMode    string
+	SMTPMaxRetries int
+
+	// Secondary SMTP relay; email is routed across both relays by their health when set
+	SMTPSecondaryHost     string
+	SMTPSecondaryPort     int
+	SMTPSecondaryUsername string
+	SMTPSecondaryPassword string
 
 	// SMS service configuration
 	TwilioAccountSID  string
 	TwilioAuthToken   string
 	TwilioPhoneNumber string
+	TwilioAPIBaseURL  string
+
+	// Secondary Twilio account or sender; SMS is routed across both by their health when set
+	TwilioSecondaryAccountSID  string
+	TwilioSecondaryAuthToken   string
+	TwilioSecondaryPhoneNumber string
+
+	// Region (ISO 3166 code, such as US) SMS recipients without a country code are read
+	// in; they are rejected when unset
+	PhoneDefaultRegion string
+
+	// Inbound SMS keywords: the URL Twilio posts replies to, as configured in Twilio and
+	// signed by it, and the brand and contact named in the replies to STOP, START and HELP
+	TwilioInboundWebhookURL string
+	SMSBrandName            string
+	SMSHelpContact          string
+	SMSKeywordReplies       bool
 
 	// Push notification configuration
 	FCMServerKey string
 	APNSKeyID    string
 	APNSTeamID   string
 
+	// Silent (data-only) pushes each device may get an hour, and how many at once
+	SilentPushPerHour int
+	SilentPushBurst   int
+
+	// Registered devices unseen for this many days are pruned, checked this often
+	DeviceStaleDays            int
+	DevicePruneIntervalMinutes int
+
 	// Webhook configuration
-	WebhookRetries int
-	WebhookTimeout int
+	WebhookRetries       int
+	WebhookTimeout       int
+	WebhookSigningSecret string
+
+	// Microsoft Teams incoming webhooks, by channel name ("channel=url,...")
+	TeamsWebhookURLs string
+
+	// Template change events and publishing approval
+	TemplateEventsWebhookURL    string
+	TemplateEventsWebhookSecret string
+	TemplateEventsEventGridKey  string
+	TemplateApprovalRequired    bool
+	TemplateApprovalSecret      string
+
+	// Broadcast approval and delivery configuration
+	BroadcastApprovalThreshold  int
+	BroadcastApprovalTTLMinutes int
+	BroadcastWorkers            int
+
+	// Operational digest configuration
+	DigestSchedule        string
+	DigestRecipients      string
+	DigestTeamsWebhookURL string
+	DigestHourUTC         int
+	// Price of one message per channel, e.g. "sms=0.0079,email=0.0001", for the cost
+	// section of operational digests
+	DigestProviderCosts string
+	DigestCostCurrency  string
+
+	// WebSocket presence configuration
+	PresenceTTLSeconds    int
+	PresenceEventsEnabled bool
+
+	// WebSocket update coalescing (0 disables)
+	WebSocketCoalesceWindowMs int
+
+	// Content screening before send: off, heuristic or hook
+	ContentScreening          string
+	ContentScreeningHookURL   string
+	ContentScreeningTimeoutMs int
+	ContentScreeningFailOpen  bool
+	ContentScreeningBlocklist string
+
+	// Azure AI Content Safety, used when ContentScreening is azure_content_safety
+	ContentSafetyEndpoint      string
+	ContentSafetyKey           string
+	ContentSafetyFlagSeverity  int
+	ContentSafetyBlockSeverity int
+	ContentSafetyBlocklists    string
+
+	// Language detection for choosing template locales (off|heuristic|azure_language),
+	// used when a customer has no preferred language
+	LanguageDetection         string
+	LanguageEndpoint          string
+	LanguageKey               string
+	LanguageMinConfidence     float64
+	LanguageDetectionTTLHours int
+
+	// Machine translation of templates into languages none of their locales speak, with
+	// Azure AI Translator; translations are cached per template version and language
+	TranslationEnabled       bool
+	TranslatorEndpoint       string
+	TranslatorKey            string
+	TranslatorRegion         string
+	TranslationCacheTTLHours int
+
+	// Send-time optimization: non-urgent notifications wait for the customer's most
+	// responsive hour, except for a holdout group sent immediately for comparison
+	SendTimeOptimization   bool
+	SendTimeLookbackDays   int
+	SendTimeMinEvents      int
+	SendTimeMaxDelayHours  int
+	SendTimeHoldoutPercent int
+
+	// Customer preferences are read through an in-process cache; during quiet hours
+	// email, SMS and push notifications are deferred to the end of the window or suppressed
+	PreferencesCacheTTLSeconds int
+	QuietHoursAction           string
+
+	// Notifications scheduled in recipient-local time use the customer's time zone, or
+	// DefaultTimezone; one whose local time has already passed is sent the next day, sent
+	// now or skipped
+	DefaultTimezone         string
+	LocalSchedulePastAction string
+
+	// Tenant blackout calendars (holidays, change freezes) are read through an
+	// in-process cache
+	BlackoutCacheTTLSeconds int
+
+	// Bearer token authentication for the API and WebSocket (Azure AD or any OIDC
+	// issuer); AuthIssuer may list several issuers, comma-separated
+	AuthEnabled       bool
+	AuthIssuer        string
+	AuthAudience      string
+	AuthJWKSURL       string
+	AuthHS256Secret   string
+	AuthCustomerClaim string
+	AuthRolesClaim    string
+	AuthAllowlist     string
+
+	// Per-channel retry policies as JSON, overriding the built-in defaults, and the
+	// longest a throttling provider can hold its channel
+	RetryPolicies              string
+	ProviderThrottleMaxSeconds int
+
+	// Health-weighted routing across a channel's providers: the smoothing factor of
+	// the success rate and latency averages, and the consecutive failures that open a
+	// provider's circuit and for how long
+	ProviderRoutingAlpha       float64
+	ProviderCircuitFailures    int
+	ProviderCircuitOpenSeconds int
+
+	// Scheduled retries of stored notifications: per-priority policies as JSON, how
+	// often due retries are picked up and how many at a time, and the minimum jitter
+	RetryPriorityPolicies    string
+	RetrySchedulerIntervalMs int
+	RetrySchedulerBatchSize  int
+	RetryJitter              float64
+
+	// Per-provider egress proxy overrides; HTTPS_PROXY and NO_PROXY apply otherwise
+	ProviderProxies string
+
+	// Dead-letter stream for notifications whose delivery failed for good
+	DeadLetterMaxEntries int
+
+	// Notifications of a bulk request are created concurrently by this many workers
+	BulkWorkers int
+
+	// Bulk re-drives of failed notifications: the most one job may re-drive, and how long
+	// a job waits for confirmation
+	RedriveMaxNotifications  int
+	RedriveConfirmTTLMinutes int
+
+	// Idempotency-Key claims are kept for this long
+	IdempotencyWindowHours int
+
+	// Content dedupe: notifications repeating the subject and message of one created for
+	// the same customer and channel within the window are suppressed
+	DedupeEnabled       bool
+	DedupeWindowSeconds int
+
+	// Customer digests: low-priority email and WebSocket notifications are held and sent
+	// to each customer as one combined message per interval, or once enough are held.
+	// CUSTOMER_DIGEST_ENABLED applies to customers without a digest preference.
+	CustomerDigestEnabled         bool
+	CustomerDigestIntervalMinutes int
+	CustomerDigestMaxItems        int
+
+	// Per-customer and per-API-key rate limits as group=requests_per_minute[:burst],
+	// where a group is a route prefix under /api/v1 or "default"
+	RateLimitEnabled bool
+	RateLimits       string
+
+	// The API keys producers may present, as comma-separated hex SHA-256 digests; a
+	// request with any other key is refused
+	APIKeys string
+
+	// Delivery statistics are cached in Redis for this long
+	DeliveryStatsCacheTTLSeconds int
+
+	// Queue sizes reported by the queue gauges are reused for this long
+	QueueMetricsCacheSeconds int
+
+	// Email open and click tracking; off unless both are set
+	TrackingBaseURL string
+	TrackingSecret  string
+
+	// Inbound email replies: each email gets a reply-to address at REPLY_TO_DOMAIN signed
+	// with REPLY_TO_SECRET, and the inbound parse webhook needs INBOUND_EMAIL_TOKEN
+	ReplyToDomain           string
+	ReplyToMailbox          string
+	ReplyToSecret           string
+	InboundEmailToken       string
+	EmailReplyRetentionDays int
+
+	// Signing keys for webhooks and WebSocket messages (rotation 0 keeps keys until
+	// they are rotated through the admin API)
+	SigningKeyRotationHours int
+	SigningKeyOverlapHours  int
+	// SigningKeyEncryptionKey is the base64 AES-256 key signing keys are encrypted with
+	SigningKeyEncryptionKey string
+	WebSocketSigningEnabled bool
+
+	// Weighted fair queuing of provider deliveries across tenants
+	TenantFairnessEnabled       bool
+	TenantFairnessMaxInFlight   int
+	TenantMaxInFlight           int
+	TenantTierWeights           string
+	TenantTiers                 string
+	TenantDefaultTier           string
+	TenantStarvationThresholdMs int
+
+	// Data residency: tenants pinned to a region keep their notifications in that
+	// region's Redis and Postgres instead of this deployment's own
+	ServiceRegion      string
+	TenantRegions      string
+	RegionRedisURLs    string
+	RegionDatabaseURLs string
+
+	// Active-active: two regions against geo-replicated Redis and Event Hubs
+	PreferenceConflictWindowSeconds int
+	EventDedupWindowMinutes         int
+
+	// Provider payload sampling (rate 0 disables)
+	ProviderSampleRate           float64
+	ProviderSampleMaxBytes       int
+	ProviderSampleRetentionHours int
+
+	// HTTP payload logging (also switched at runtime through the admin API)
+	PayloadLogEnabled      bool
+	PayloadLogSampleRate   float64
+	PayloadLogMaxBodyBytes int
+	PayloadLogHeaders      string
+	PayloadLogMaskFields   string
+
+	// WebSocket resume and reconnect guidance (replay buffer size 0 disables resume)
+	WebSocketReplayBufferSize   int
+	WebSocketResumeTTLSeconds   int
+	WebSocketReconnectInitialMs int
+	WebSocketReconnectMaxMs     int
+
+	// Dispatch routing policy configuration
+	RoutingPolicy           string
+	RoutingFallbackWaitMs   int
+	RoutingFallbackChannels string
+	RoutingFallbackWorkers  int
+
+	// Scheduled notification dispatch configuration
+	ScheduledDispatchWorkers int
+
+	// Indexed notification metadata keys
+	MetadataIndexMaxKeys int
+
+	// Demo endpoints (synthetic telemetry for presentations)
+	DemoEndpointsEnabled bool
 
 	// Failure injection configuration
 	FailureInjectionEnabled bool
+	FailureInjectionDryRun  bool
+	FaultPoints             string
 	LatencyProbability      float64
 	ErrorProbability        float64
 	LatencyMinMs            int
@@ -58,48 +396,342 @@ type Config struct {
 func Load() *Config {
 	return &Config{
 		// Server
-		Port:        getEnv("PORT", "8080"),
-		Environment: getEnv("ENVIRONMENT", "development"),
+		Port:           getEnv("PORT", "8080"),
+		Environment:    getEnv("ENVIRONMENT", "development"),
+		BindAddresses:  getEnv("BIND_ADDRESSES", ""),
+		UnixSocketMode: getEnv("UNIX_SOCKET_MODE", "0660"),
+		RouterMode:     getEnv("ROUTER_MODE", "gin"),
+
+		ShutdownTimeoutSeconds: getEnvAsInt("SHUTDOWN_TIMEOUT_SECONDS", 10),
+
+		// gRPC API
+		GRPCPort:         getEnv("GRPC_PORT", ""),
+		GRPCStatusPollMs: getEnvAsInt("GRPC_STATUS_POLL_MS", 1000),
 
 		// OpenTelemetry
 		OTLPTracesEndpoint:  getEnv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", ""),
 		OTLPMetricsEndpoint: getEnv("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT", ""),
 		OTLPLogsEndpoint:    getEnv("OTEL_EXPORTER_OTLP_LOGS_ENDPOINT", ""),
 		ServiceName:         getEnv("OTEL_SERVICE_NAME", "notification-service"),
+		PrometheusEnabled:   getEnvAsBool("PROMETHEUS_METRICS_ENABLED", true),
+
+		RuntimeMetricsEnabled: getEnvAsBool("RUNTIME_METRICS_ENABLED", true),
+		HostMetricsEnabled:    getEnvAsBool("HOST_METRICS_ENABLED", true),
+
+		TelemetryExporter:                   getEnv("TELEMETRY_EXPORTER", "otlp"),
+		ApplicationInsightsConnectionString: getEnv("APPLICATIONINSIGHTS_CONNECTION_STRING", ""),
+		ApplicationInsightsAuthenticationString: getEnv("APPLICATIONINSIGHTS_AUTHENTICATION_STRING", ""),
+
+		// Sidecar collector
+		OTLPEndpoint:           getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", ""),
+		OTLPSocket:             getEnv("OTEL_EXPORTER_OTLP_SOCKET", ""),
+		OTLPStartupWaitSeconds: getEnvAsInt("OTEL_EXPORTER_OTLP_STARTUP_WAIT_SECONDS", 0),
+
+		OTLPProtocol:          getEnv("OTEL_EXPORTER_OTLP_PROTOCOL", "http/protobuf"),
+		OTLPHeaders:           getEnv("OTEL_EXPORTER_OTLP_HEADERS", ""),
+		OTLPCertificate:       getEnv("OTEL_EXPORTER_OTLP_CERTIFICATE", ""),
+		OTLPClientCertificate: getEnv("OTEL_EXPORTER_OTLP_CLIENT_CERTIFICATE", ""),
+		OTLPClientKey:         getEnv("OTEL_EXPORTER_OTLP_CLIENT_KEY", ""),
+
+		// Logging
+		LogLevel:  getEnv("LOG_LEVEL", "info"),
+		LogFormat: getEnv("LOG_FORMAT", "text"),
 
 		// Redis
-		RedisURL: getEnv("REDIS_URL", "redis://localhost:6379"),
+		RedisURL:                   getEnv("REDIS_URL", "redis://localhost:6379"),
+		RedisBufferCapacity:        getEnvAsInt("REDIS_BUFFER_CAPACITY", 10000),
+		RedisBufferFlushIntervalMs: getEnvAsInt("REDIS_BUFFER_FLUSH_INTERVAL_MS", 1000),
 
 		// Event Hub
 		EventHubConnectionString: getEnv("EVENT_HUB_CONNECTION_STRING", ""),
 		EventHubName:             getEnv("EVENT_HUB_NAME", "orders"),
+		EventHubHandlerConcurrency: getEnvAsInt("EVENT_HUB_HANDLER_CONCURRENCY", 8),
+
+		// Event Hub failover
+		EventHubSecondaryConnectionString:         getEnv("EVENT_HUB_SECONDARY_CONNECTION_STRING", ""),
+		EventHubProducerSecondaryConnectionString: getEnv("EVENT_HUB_PRODUCER_SECONDARY_CONNECTION_STRING", ""),
+		EventHubFailoverThreshold:                 getEnvAsInt("EVENT_HUB_FAILOVER_THRESHOLD", 5),
+		EventHubFailbackProbeSeconds:              getEnvAsInt("EVENT_HUB_FAILBACK_PROBE_SECONDS", 60),
+
+		// Event Hub producer
+		EventHubProducerConnectionString: getEnv("EVENT_HUB_PRODUCER_CONNECTION_STRING", ""),
+		EventHubProducerName:             getEnv("EVENT_HUB_PRODUCER_NAME", "notification-events"),
+		ProducerBatchSize:                getEnvAsInt("EVENT_HUB_PRODUCER_BATCH_SIZE", 100),
+		ProducerFlushIntervalMs:          getEnvAsInt("EVENT_HUB_PRODUCER_FLUSH_INTERVAL_MS", 1000),
+		ProducerMaxRetries:               getEnvAsInt("EVENT_HUB_PRODUCER_MAX_RETRIES", 5),
+		ProducerMaxPending:               getEnvAsInt("EVENT_HUB_PRODUCER_MAX_PENDING", 10000),
+
+		// Service Bus
+		ServiceBusConnectionString: getEnv("SERVICE_BUS_CONNECTION_STRING", ""),
+		ServiceBusQueueName:        getEnv("SERVICE_BUS_QUEUE_NAME", ""),
+		ServiceBusTopicName:        getEnv("SERVICE_BUS_TOPIC_NAME", ""),
+		ServiceBusSubscriptionName: getEnv("SERVICE_BUS_SUBSCRIPTION_NAME", ""),
+		ServiceBusMaxMessages:      getEnvAsInt("SERVICE_BUS_MAX_MESSAGES", 10),
 
 		// Database
-		DatabaseURL: getEnv("DATABASE_URL", "postgres://localhost/notifications?sslmode=disable"),
+		DatabaseURL:              getEnvAllowEmpty("DATABASE_URL", "postgres://localhost/notifications?sslmode=disable"),
+		DatabaseMigrateOnStartup: getEnvAsBool("DATABASE_MIGRATE_ON_STARTUP", true),
+
+		// Readiness
+		ReadinessCacheSeconds: getEnvAsInt("READINESS_CACHE_SECONDS", 5),
 
 		// Email
-		SMTPHost:     getEnv("SMTP_HOST", "smtp.gmail.com"),
-		SMTPPort:     getEnvAsInt("SMTP_PORT", 587),
-		SMTPUsername: getEnv("SMTP_USERNAME", ""),
-		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
-		FromEmail:    getEnv("FROM_EMAIL", "noreply@example.com"),
+		SMTPHost:       getEnv("SMTP_HOST", "smtp.gmail.com"),
+		SMTPPort:       getEnvAsInt("SMTP_PORT", 587),
+		SMTPUsername:   getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:   getEnv("SMTP_PASSWORD", ""),
+		FromEmail:      getEnv("FROM_EMAIL", "noreply@example.com"),
+		SMTPTLSMode:    getEnv("SMTP_TLS_MODE", "starttls"),
+		SMTPMaxRetries: getEnvAsInt("SMTP_MAX_RETRIES", 3),
+
+		SMTPSecondaryHost:     getEnv("SMTP_SECONDARY_HOST", ""),
+		SMTPSecondaryPort:     getEnvAsInt("SMTP_SECONDARY_PORT", 587),
+		SMTPSecondaryUsername: getEnv("SMTP_SECONDARY_USERNAME", ""),
+		SMTPSecondaryPassword: getEnv("SMTP_SECONDARY_PASSWORD", ""),
 
 		// SMS
 		TwilioAccountSID:  getEnv("TWILIO_ACCOUNT_SID", ""),
 		TwilioAuthToken:   getEnv("TWILIO_AUTH_TOKEN", ""),
 		TwilioPhoneNumber: getEnv("TWILIO_PHONE_NUMBER", ""),
+		TwilioAPIBaseURL:  getEnv("TWILIO_API_BASE_URL", "https://api.twilio.com"),
+
+		TwilioSecondaryAccountSID:  getEnv("TWILIO_SECONDARY_ACCOUNT_SID", ""),
+		TwilioSecondaryAuthToken:   getEnv("TWILIO_SECONDARY_AUTH_TOKEN", ""),
+		TwilioSecondaryPhoneNumber: getEnv("TWILIO_SECONDARY_PHONE_NUMBER", ""),
+
+		PhoneDefaultRegion: getEnv("PHONE_DEFAULT_REGION", ""),
+
+		TwilioInboundWebhookURL: getEnv("TWILIO_INBOUND_WEBHOOK_URL", ""),
+		SMSBrandName:            getEnv("SMS_BRAND_NAME", "Notifications"),
+		SMSHelpContact:          getEnv("SMS_HELP_CONTACT", ""),
+		SMSKeywordReplies:       getEnvAsBool("SMS_KEYWORD_REPLIES", true),
 
 		// Push notifications
 		FCMServerKey: getEnv("FCM_SERVER_KEY", ""),
 		APNSKeyID:    getEnv("APNS_KEY_ID", ""),
 		APNSTeamID:   getEnv("APNS_TEAM_ID", ""),
 
+		SilentPushPerHour: getEnvAsInt("SILENT_PUSH_PER_HOUR", 3),
+		SilentPushBurst:   getEnvAsInt("SILENT_PUSH_BURST", 3),
+
+		DeviceStaleDays:            getEnvAsInt("DEVICE_STALE_DAYS", 90),
+		DevicePruneIntervalMinutes: getEnvAsInt("DEVICE_PRUNE_INTERVAL_MINUTES", 60),
+
 		// Webhooks
-		WebhookRetries: getEnvAsInt("WEBHOOK_RETRIES", 3),
-		WebhookTimeout: getEnvAsInt("WEBHOOK_TIMEOUT", 30),
+		WebhookRetries:       getEnvAsInt("WEBHOOK_RETRIES", 3),
+		WebhookTimeout:       getEnvAsInt("WEBHOOK_TIMEOUT", 30),
+		WebhookSigningSecret: getEnv("WEBHOOK_SIGNING_SECRET", ""),
+
+		// Microsoft Teams
+		TeamsWebhookURLs: getEnv("TEAMS_WEBHOOK_URLS", ""),
+
+		// Template events
+		TemplateEventsWebhookURL:    getEnv("TEMPLATE_EVENTS_WEBHOOK_URL", ""),
+		TemplateEventsWebhookSecret: getEnv("TEMPLATE_EVENTS_WEBHOOK_SECRET", ""),
+		TemplateEventsEventGridKey:  getEnv("TEMPLATE_EVENTS_EVENTGRID_KEY", ""),
+		TemplateApprovalRequired:    getEnvAsBool("TEMPLATE_APPROVAL_REQUIRED", false),
+		TemplateApprovalSecret:      getEnv("TEMPLATE_APPROVAL_SECRET", ""),
+
+		// Broadcast approval
+		BroadcastApprovalThreshold:  getEnvAsInt("BROADCAST_APPROVAL_THRESHOLD", 100),
+		BroadcastApprovalTTLMinutes: getEnvAsInt("BROADCAST_APPROVAL_TTL_MINUTES", 60),
+		BroadcastWorkers:            getEnvAsInt("BROADCAST_WORKERS", 10),
+
+		// Operational digests
+		DigestSchedule:        getEnv("DIGEST_SCHEDULE", ""),
+		DigestRecipients:      getEnv("DIGEST_RECIPIENTS", ""),
+		DigestTeamsWebhookURL: getEnv("DIGEST_TEAMS_WEBHOOK_URL", ""),
+		DigestHourUTC:         getEnvAsInt("DIGEST_HOUR_UTC", 7),
+		DigestProviderCosts:   getEnv("DIGEST_PROVIDER_COSTS", ""),
+		DigestCostCurrency:    getEnv("DIGEST_COST_CURRENCY", "USD"),
+
+		// Presence
+		PresenceTTLSeconds:    getEnvAsInt("PRESENCE_TTL_SECONDS", 60),
+		PresenceEventsEnabled: getEnvAsBool("PRESENCE_EVENTS_ENABLED", false),
+
+		// WebSocket coalescing
+		WebSocketCoalesceWindowMs: getEnvAsInt("WEBSOCKET_COALESCE_WINDOW_MS", 0),
+
+		// Content screening
+		ContentScreening:          getEnv("CONTENT_SCREENING", "off"),
+		ContentScreeningHookURL:   getEnv("CONTENT_SCREENING_HOOK_URL", ""),
+		ContentScreeningTimeoutMs: getEnvAsInt("CONTENT_SCREENING_TIMEOUT_MS", 2000),
+		ContentScreeningFailOpen:  getEnvAsBool("CONTENT_SCREENING_FAIL_OPEN", true),
+		ContentScreeningBlocklist: getEnv("CONTENT_SCREENING_BLOCKLIST", ""),
+
+		// Azure AI Content Safety
+		ContentSafetyEndpoint:      getEnv("CONTENT_SAFETY_ENDPOINT", ""),
+		ContentSafetyKey:           getEnv("CONTENT_SAFETY_KEY", ""),
+		ContentSafetyFlagSeverity:  getEnvAsInt("CONTENT_SAFETY_FLAG_SEVERITY", 2),
+		ContentSafetyBlockSeverity: getEnvAsInt("CONTENT_SAFETY_BLOCK_SEVERITY", 4),
+		ContentSafetyBlocklists:    getEnv("CONTENT_SAFETY_BLOCKLISTS", ""),
+
+		// Language detection
+		LanguageDetection:         getEnv("LANGUAGE_DETECTION", "heuristic"),
+		LanguageEndpoint:          getEnv("LANGUAGE_ENDPOINT", ""),
+		LanguageKey:               getEnv("LANGUAGE_KEY", ""),
+		LanguageMinConfidence:     getEnvAsFloat("LANGUAGE_MIN_CONFIDENCE", 0.8),
+		LanguageDetectionTTLHours: getEnvAsInt("LANGUAGE_DETECTION_TTL_HOURS", 168),
+
+		// Machine translation
+		TranslationEnabled:       getEnvAsBool("TRANSLATION_ENABLED", false),
+		TranslatorEndpoint:       getEnv("TRANSLATOR_ENDPOINT", "https://api.cognitive.microsofttranslator.com"),
+		TranslatorKey:            getEnv("TRANSLATOR_KEY", ""),
+		TranslatorRegion:         getEnv("TRANSLATOR_REGION", ""),
+		TranslationCacheTTLHours: getEnvAsInt("TRANSLATION_CACHE_TTL_HOURS", 720),
+
+		// Send-time optimization
+		SendTimeOptimization:   getEnvAsBool("SEND_TIME_OPTIMIZATION", false),
+		SendTimeLookbackDays:   getEnvAsInt("SEND_TIME_LOOKBACK_DAYS", 30),
+		SendTimeMinEvents:      getEnvAsInt("SEND_TIME_MIN_EVENTS", 5),
+		SendTimeMaxDelayHours:  getEnvAsInt("SEND_TIME_MAX_DELAY_HOURS", 12),
+		SendTimeHoldoutPercent: getEnvAsInt("SEND_TIME_HOLDOUT_PERCENT", 10),
+
+		// Customer preferences
+		PreferencesCacheTTLSeconds: getEnvAsInt("PREFERENCES_CACHE_TTL_SECONDS", 60),
+		QuietHoursAction:           getEnv("QUIET_HOURS_ACTION", "defer"),
+
+		// Recipient-local scheduling
+		DefaultTimezone:         getEnv("DEFAULT_TIMEZONE", "UTC"),
+		LocalSchedulePastAction: getEnv("LOCAL_SCHEDULE_PAST_ACTION", "next_day"),
+
+		// Blackout calendars
+		BlackoutCacheTTLSeconds: getEnvAsInt("BLACKOUT_CACHE_TTL_SECONDS", 30),
+
+		// Authentication
+		AuthEnabled:       getEnvAsBool("AUTH_ENABLED", false),
+		AuthIssuer:        getEnv("AUTH_ISSUER", ""),
+		AuthAudience:      getEnv("AUTH_AUDIENCE", ""),
+		AuthJWKSURL:       getEnv("AUTH_JWKS_URL", ""),
+		AuthHS256Secret:   getEnv("AUTH_HS256_SECRET", ""),
+		AuthCustomerClaim: getEnv("AUTH_CUSTOMER_CLAIM", "customer_id"),
+		AuthRolesClaim:    getEnv("AUTH_ROLES_CLAIM", "roles"),
+		AuthAllowlist:     getEnv("AUTH_ALLOWLIST", "/health,/health/ready,/health/live,/metrics"),
+
+		// Retry policies
+		RetryPolicies:              getEnv("RETRY_POLICIES", ""),
+		ProviderThrottleMaxSeconds: getEnvAsInt("PROVIDER_THROTTLE_MAX_SECONDS", 300),
+
+		// Provider routing
+		ProviderRoutingAlpha:       getEnvAsFloat("PROVIDER_ROUTING_ALPHA", 0.2),
+		ProviderCircuitFailures:    getEnvAsInt("PROVIDER_CIRCUIT_FAILURES", 5),
+		ProviderCircuitOpenSeconds: getEnvAsInt("PROVIDER_CIRCUIT_OPEN_SECONDS", 30),
+
+		// Retry scheduler
+		RetryPriorityPolicies:    getEnv("RETRY_PRIORITY_POLICIES", ""),
+		RetrySchedulerIntervalMs: getEnvAsInt("RETRY_SCHEDULER_INTERVAL_MS", 1000),
+		RetrySchedulerBatchSize:  getEnvAsInt("RETRY_SCHEDULER_BATCH_SIZE", 100),
+		RetryJitter:              getEnvAsFloat("RETRY_JITTER", 0.2),
+
+		// Egress proxies
+		ProviderProxies: getEnv("PROVIDER_PROXIES", ""),
+
+		// Dead letters
+		DeadLetterMaxEntries: getEnvAsInt("DEAD_LETTER_MAX_ENTRIES", 10000),
+
+		// Bulk notifications
+		BulkWorkers: getEnvAsInt("BULK_WORKERS", 10),
+
+		// Bulk re-drives
+		RedriveMaxNotifications:  getEnvAsInt("REDRIVE_MAX_NOTIFICATIONS", 1000),
+		RedriveConfirmTTLMinutes: getEnvAsInt("REDRIVE_CONFIRM_TTL_MINUTES", 15),
+
+		// Idempotency keys
+		IdempotencyWindowHours: getEnvAsInt("IDEMPOTENCY_WINDOW_HOURS", 24),
+
+		// Content dedupe
+		DedupeEnabled:       getEnvAsBool("DEDUPE_ENABLED", false),
+		DedupeWindowSeconds: getEnvAsInt("DEDUPE_WINDOW_SECONDS", 3600),
+
+		// Customer digests
+		CustomerDigestEnabled:         getEnvAsBool("CUSTOMER_DIGEST_ENABLED", false),
+		CustomerDigestIntervalMinutes: getEnvAsInt("CUSTOMER_DIGEST_INTERVAL_MINUTES", 60),
+		CustomerDigestMaxItems:        getEnvAsInt("CUSTOMER_DIGEST_MAX_ITEMS", 20),
+
+		// Rate limiting
+		RateLimitEnabled: getEnvAsBool("RATE_LIMIT_ENABLED", false),
+		RateLimits:       getEnv("RATE_LIMITS", "default=600,notifications/bulk=60:10,notifications/broadcast=10:2"),
+
+		// API keys
+		APIKeys: getEnv("API_KEYS", ""),
+
+		// Delivery statistics
+		DeliveryStatsCacheTTLSeconds: getEnvAsInt("DELIVERY_STATS_CACHE_TTL_SECONDS", 60),
+		QueueMetricsCacheSeconds:     getEnvAsInt("QUEUE_METRICS_CACHE_SECONDS", 15),
+
+		// Engagement tracking
+		TrackingBaseURL: getEnv("TRACKING_BASE_URL", ""),
+		TrackingSecret:  getEnv("TRACKING_SECRET", ""),
+
+		// Inbound email replies
+		ReplyToDomain:           getEnv("REPLY_TO_DOMAIN", ""),
+		ReplyToMailbox:          getEnv("REPLY_TO_MAILBOX", "reply"),
+		ReplyToSecret:           getEnv("REPLY_TO_SECRET", ""),
+		InboundEmailToken:       getEnv("INBOUND_EMAIL_TOKEN", ""),
+		EmailReplyRetentionDays: getEnvAsInt("EMAIL_REPLY_RETENTION_DAYS", 30),
+
+		// Signing keys
+		SigningKeyRotationHours: getEnvAsInt("SIGNING_KEY_ROTATION_HOURS", 720),
+		SigningKeyOverlapHours:  getEnvAsInt("SIGNING_KEY_OVERLAP_HOURS", 24),
+		SigningKeyEncryptionKey: getEnv("SIGNING_KEY_ENCRYPTION_KEY", ""),
+		WebSocketSigningEnabled: getEnvAsBool("WEBSOCKET_SIGNING_ENABLED", false),
+
+		// Tenant fairness
+		TenantFairnessEnabled:       getEnvAsBool("TENANT_FAIRNESS_ENABLED", false),
+		TenantFairnessMaxInFlight:   getEnvAsInt("TENANT_FAIRNESS_MAX_IN_FLIGHT", 20),
+		TenantMaxInFlight:           getEnvAsInt("TENANT_MAX_IN_FLIGHT", 5),
+		TenantTierWeights:           getEnv("TENANT_TIER_WEIGHTS", "premium=4,standard=2,free=1"),
+		TenantTiers:                 getEnv("TENANT_TIERS", ""),
+		TenantDefaultTier:           getEnv("TENANT_DEFAULT_TIER", "standard"),
+		TenantStarvationThresholdMs: getEnvAsInt("TENANT_STARVATION_THRESHOLD_MS", 5000),
+
+		// Data residency
+		ServiceRegion:      getEnv("SERVICE_REGION", ""),
+		TenantRegions:      getEnv("TENANT_REGIONS", ""),
+		RegionRedisURLs:    getEnv("REGION_REDIS_URLS", ""),
+		RegionDatabaseURLs: getEnv("REGION_DATABASE_URLS", ""),
+
+		// Active-active
+		PreferenceConflictWindowSeconds: getEnvAsInt("PREFERENCE_CONFLICT_WINDOW_SECONDS", 5),
+		EventDedupWindowMinutes:         getEnvAsInt("EVENT_DEDUP_WINDOW_MINUTES", 60),
+
+		// Provider payload sampling
+		ProviderSampleRate:           getEnvAsFloat("PROVIDER_SAMPLE_RATE", 0),
+		ProviderSampleMaxBytes:       getEnvAsInt("PROVIDER_SAMPLE_MAX_BYTES", 16384),
+		ProviderSampleRetentionHours: getEnvAsInt("PROVIDER_SAMPLE_RETENTION_HOURS", 72),
+
+		// HTTP payload logging
+		PayloadLogEnabled:      getEnvAsBool("PAYLOAD_LOG_ENABLED", false),
+		PayloadLogSampleRate:   getEnvAsFloat("PAYLOAD_LOG_SAMPLE_RATE", 0.01),
+		PayloadLogMaxBodyBytes: getEnvAsInt("PAYLOAD_LOG_MAX_BODY_BYTES", 4096),
+		PayloadLogHeaders:      getEnv("PAYLOAD_LOG_HEADERS", "Content-Type,User-Agent,X-Request-Id,X-User-Id,Traceparent"),
+		PayloadLogMaskFields:   getEnv("PAYLOAD_LOG_MASK_FIELDS", "recipient,email,phone,phone_number,device_token"),
+
+		// WebSocket resume
+		WebSocketReplayBufferSize:   getEnvAsInt("WEBSOCKET_REPLAY_BUFFER_SIZE", 100),
+		WebSocketResumeTTLSeconds:   getEnvAsInt("WEBSOCKET_RESUME_TTL_SECONDS", 300),
+		WebSocketReconnectInitialMs: getEnvAsInt("WEBSOCKET_RECONNECT_INITIAL_MS", 1000),
+		WebSocketReconnectMaxMs:     getEnvAsInt("WEBSOCKET_RECONNECT_MAX_MS", 30000),
+
+		// Routing policy
+		RoutingPolicy:           getEnv("ROUTING_POLICY", "websocket"),
+		RoutingFallbackWaitMs:   getEnvAsInt("ROUTING_FALLBACK_WAIT_MS", 30000),
+		RoutingFallbackChannels: getEnv("ROUTING_FALLBACK_CHANNELS", "push,email"),
+		RoutingFallbackWorkers:  getEnvAsInt("ROUTING_FALLBACK_WORKERS", 10),
+
+		// Scheduled dispatch
+		ScheduledDispatchWorkers: getEnvAsInt("SCHEDULED_DISPATCH_WORKERS", 10),
+
+		// Metadata indexes
+		MetadataIndexMaxKeys: getEnvAsInt("METADATA_INDEX_MAX_KEYS", 5),
+
+		// Demo endpoints
+		DemoEndpointsEnabled: getEnvAsBool("DEMO_ENDPOINTS_ENABLED", false),
 
 		// Failure injection
-		FailureInjectionEnabled: getEnvAsBool("FAILURE_INJECTION_ENABLED", true),
+		FailureInjectionEnabled: getEnvAsBool("FAILURE_INJECTION_ENABLED", false),
+		FailureInjectionDryRun:  getEnvAsBool("FAILURE_INJECTION_DRY_RUN", false),
+		FaultPoints:             getEnv("FAULT_POINTS", ""),
 		LatencyProbability:      getEnvAsFloat("LATENCY_PROBABILITY", 0.1),
 		ErrorProbability:        getEnvAsFloat("ERROR_PROBABILITY", 0.05),
 		LatencyMinMs:            getEnvAsInt("LATENCY_MIN_MS", 100),
@@ -114,6 +746,14 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getEnvAllowEmpty is getEnv for settings where set but empty turns a feature off
+func getEnvAllowEmpty(key, defaultValue string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+	return defaultValue
+}
+
 func getEnvAsInt(key string, defaultValue int) int {
 	if value := os.Getenv(key); value != "" {
 		if intValue, err := strconv.Atoi(value); err == nil {
@@ -0,0 +1,143 @@
+package faults
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"notification-service/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DryRunReport shows the blast radius of the current rules: how often each operation was
+// evaluated since the dry run started and which faults would have fired
+type DryRunReport struct {
+	Enabled    bool              `json:"enabled"`
+	Since      *time.Time        `json:"since,omitempty"`
+	Operations []OperationReport `json:"operations"`
+}
+
+// OperationReport counts evaluations of one operation and the faults that would have
+// fired there, by fault type
+type OperationReport struct {
+	Operation   string           `json:"operation"`
+	Evaluated   int64            `json:"evaluated"`
+	WouldInject map[string]int64 `json:"would_inject"`
+	Rate        float64          `json:"rate"`
+	LastAt      *time.Time       `json:"last_at,omitempty"`
+}
+
+// reportMutex guards the dry-run counts, which are only kept while a dry run is on
+var (
+	reportMutex sync.Mutex
+	since       *time.Time
+	operations  = make(map[string]*OperationReport)
+)
+
+// SetDryRun switches dry-run mode (FAILURE_INJECTION_DRY_RUN). Turning it on starts a
+// fresh report; turning it off keeps the last one for inspection.
+func SetDryRun(on bool) {
+	mutex.Lock()
+	was := dryRun
+	dryRun = on
+	mutex.Unlock()
+
+	if on && !was {
+		now := time.Now().UTC()
+		reportMutex.Lock()
+		since = &now
+		operations = make(map[string]*OperationReport)
+		reportMutex.Unlock()
+		log.Println("🔍 Failure injection dry run started: faults are evaluated but not injected")
+	} else if !on && was {
+		log.Println("🔍 Failure injection dry run stopped")
+	}
+}
+
+// DryRun reports whether faults are evaluated without being injected
+func DryRun() bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return dryRun
+}
+
+// Evaluate counts an evaluation of operation's fault rules during a dry run
+func Evaluate(operation string) {
+	if !DryRun() {
+		return
+	}
+	reportMutex.Lock()
+	operationReport(operation).Evaluated++
+	reportMutex.Unlock()
+}
+
+// Report returns the dry-run counts ordered by operation
+func Report() DryRunReport {
+	report := DryRunReport{Enabled: DryRun(), Operations: []OperationReport{}}
+
+	reportMutex.Lock()
+	defer reportMutex.Unlock()
+	report.Since = since
+	for _, entry := range operations {
+		copied := *entry
+		copied.WouldInject = make(map[string]int64, len(entry.WouldInject))
+		var fired int64
+		for faultType, count := range entry.WouldInject {
+			copied.WouldInject[faultType] = count
+			fired += count
+		}
+		if copied.Evaluated > 0 {
+			copied.Rate = float64(fired) / float64(copied.Evaluated)
+		}
+		report.Operations = append(report.Operations, copied)
+	}
+	sort.Slice(report.Operations, func(i, j int) bool { return report.Operations[i].Operation < report.Operations[j].Operation })
+	return report
+}
+
+// operationReport returns operation's counts, creating them; reportMutex must be held
+func operationReport(operation string) *OperationReport {
+	entry, ok := operations[operation]
+	if !ok {
+		entry = &OperationReport{Operation: operation, WouldInject: make(map[string]int64)}
+		operations[operation] = entry
+	}
+	return entry
+}
+
+// recordWouldInject marks a fault a dry run held back, the way Record marks an injected
+// one, with fault.dry_run set and a fault.would_inject event
+func recordWouldInject(ctx context.Context, experiment *Experiment, operation, faultType string, extra []attribute.KeyValue) {
+	now := time.Now().UTC()
+	reportMutex.Lock()
+	entry := operationReport(operation)
+	entry.WouldInject[faultType]++
+	entry.LastAt = &now
+	reportMutex.Unlock()
+
+	attrs := append([]attribute.KeyValue{
+		attribute.Bool("fault.dry_run", true),
+		attribute.String("fault.operation", operation),
+		attribute.String("fault.type", faultType),
+	}, extra...)
+	experimentID := ""
+	if experiment != nil {
+		experimentID = experiment.ID
+		atomic.AddInt64(&experiment.FaultsWouldFire, 1)
+		attrs = append(attrs, attribute.String("chaos.experiment.id", experimentID))
+	}
+
+	trace.SpanFromContext(ctx).AddEvent("fault.would_inject", trace.WithAttributes(attrs...))
+	telemetry.RecordFaultWouldInject(ctx, operation, faultType, experimentID)
+	logAttrs := []any{"fault.type", faultType, "fault.operation", operation}
+	if experimentID != "" {
+		logAttrs = append(logAttrs, "chaos.experiment.id", experimentID)
+	}
+	slog.InfoContext(ctx, "🔍 Would inject fault", logAttrs...)
+}
@@ -0,0 +1,155 @@
+package faults
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// maxExperimentHistory bounds how many finished experiments are remembered
+const maxExperimentHistory = 50
+
+var (
+	ErrExperimentRunning  = errors.New("a chaos experiment is already running")
+	ErrExperimentNotFound = errors.New("chaos experiment not found")
+)
+
+// Experiment is a named chaos run. While it is active, every injected fault is stamped
+// with its ID so dashboards can tell injected failures from organic ones.
+type Experiment struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	Rules       []Rule     `json:"rules"`
+	StartedAt   time.Time  `json:"started_at"`
+	EndedAt     *time.Time `json:"ended_at,omitempty"`
+	FaultsFired int64      `json:"faults_fired"`
+	// Faults held back because the experiment ran during a dry run
+	FaultsWouldFire int64 `json:"faults_would_fire,omitempty"`
+}
+
+// ExperimentRequest starts a chaos experiment; without rules the current fault rules are used
+type ExperimentRequest struct {
+	Name            string `json:"name" binding:"required"`
+	Rules           []Rule `json:"rules,omitempty"`
+	DurationSeconds int    `json:"duration_seconds,omitempty"`
+}
+
+// experimentMutex serializes starting and stopping experiments and guards the history;
+// the active experiment pointer is read by Inject under the rules mutex
+var (
+	experimentMutex sync.Mutex
+	previousRules   []Rule
+	stopTimer       *time.Timer
+	history         []*Experiment
+)
+
+// StartExperiment activates rules under a new experiment ID, replacing the current rules
+// until the experiment stops. With no rules the current ones are used. A positive
+// duration stops the experiment automatically.
+func StartExperiment(name string, experimentRules []Rule, duration time.Duration) (*Experiment, error) {
+	experimentMutex.Lock()
+	defer experimentMutex.Unlock()
+
+	if ActiveExperimentID() != "" {
+		return nil, ErrExperimentRunning
+	}
+
+	current := Rules()
+	if len(experimentRules) == 0 {
+		experimentRules = current
+	}
+	if err := SetRules(experimentRules); err != nil {
+		return nil, err
+	}
+
+	experiment := &Experiment{
+		ID:        uuid.New().String(),
+		Name:      name,
+		Rules:     Rules(),
+		StartedAt: time.Now().UTC(),
+	}
+	mutex.Lock()
+	active = experiment
+	mutex.Unlock()
+	previousRules = current
+	history = append(history, experiment)
+	if len(history) > maxExperimentHistory {
+		history = history[len(history)-maxExperimentHistory:]
+	}
+
+	if duration > 0 {
+		id := experiment.ID
+		stopTimer = time.AfterFunc(duration, func() {
+			if _, err := StopExperiment(id); err != nil && !errors.Is(err, ErrExperimentNotFound) {
+				log.Printf("Failed to stop chaos experiment %s: %v", id, err)
+			}
+		})
+	}
+
+	log.Printf("🧪 Chaos experiment %s (%s) started with %d fault rules", experiment.ID, name, len(experiment.Rules))
+	return experiment.snapshot(), nil
+}
+
+// StopExperiment ends the active experiment and restores the rules that preceded it
+func StopExperiment(id string) (*Experiment, error) {
+	experimentMutex.Lock()
+	defer experimentMutex.Unlock()
+
+	if ActiveExperimentID() != id {
+		return nil, ErrExperimentNotFound
+	}
+
+	if stopTimer != nil {
+		stopTimer.Stop()
+		stopTimer = nil
+	}
+	_ = SetRules(previousRules)
+
+	mutex.Lock()
+	now := time.Now().UTC()
+	active.EndedAt = &now
+	stopped := active.snapshot()
+	active = nil
+	mutex.Unlock()
+
+	if stopped.FaultsWouldFire > 0 {
+		log.Printf("🧪 Chaos experiment %s stopped after %d injected faults (%d more held back by the dry run)", stopped.ID, stopped.FaultsFired, stopped.FaultsWouldFire)
+	} else {
+		log.Printf("🧪 Chaos experiment %s stopped after %d injected faults", stopped.ID, stopped.FaultsFired)
+	}
+	return stopped, nil
+}
+
+// Experiments returns the experiment history, most recent first
+func Experiments() []*Experiment {
+	experimentMutex.Lock()
+	defer experimentMutex.Unlock()
+
+	list := make([]*Experiment, 0, len(history))
+	for i := len(history) - 1; i >= 0; i-- {
+		list = append(list, history[i].snapshot())
+	}
+	return list
+}
+
+// ActiveExperimentID returns the running experiment's ID, or "" when none is running
+func ActiveExperimentID() string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	if active == nil {
+		return ""
+	}
+	return active.ID
+}
+
+func (e *Experiment) snapshot() *Experiment {
+	copied := *e
+	copied.FaultsFired = atomic.LoadInt64(&e.FaultsFired)
+	copied.FaultsWouldFire = atomic.LoadInt64(&e.FaultsWouldFire)
+	copied.Rules = append([]Rule(nil), e.Rules...)
+	return &copied
+}
@@ -0,0 +1,267 @@
+// Package faults injects failures at named operations deep inside request and event
+// processing, so chaos scenarios show realistic failures in traces rather than only at
+// the HTTP edge. Rules are set from FAULT_POINTS at startup and through the admin API.
+// In dry-run mode every rule is evaluated and the faults that would have fired are
+// logged and counted, but none is injected.
+package faults
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"notification-service/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Fault types
+const (
+	TemplateRender  = "template_render"
+	ProviderTimeout = "provider_timeout"
+	RedisError      = "redis_error"
+
+	// Injected at the HTTP edge by the failure injection middleware
+	HTTPLatency = "http_latency"
+	HTTPError   = "http_error"
+)
+
+// Operations with built-in fault points
+const (
+	OpTemplateRender = "template.render"
+	OpWebSocketSend  = "websocket.send"
+	OpRedisSave      = "redis.save_notification"
+	OpChannelEmail   = "channel.email"
+	OpChannelSMS     = "channel.sms"
+	OpChannelPush    = "channel.push"
+	OpChannelWebhook = "channel.webhook"
+	OpChannelTeams   = "channel.teams"
+	OpHTTPRequest    = "http.request"
+)
+
+// defaultTimeout is how long a provider_timeout fault stalls when no latency is set
+const defaultTimeout = 5 * time.Second
+
+// ErrInjectedFault is wrapped by every injected error
+var ErrInjectedFault = errors.New("injected fault")
+
+var ErrInvalidRule = errors.New("invalid fault rule")
+
+// Rule makes an operation fail with the given fault type and probability
+type Rule struct {
+	Operation   string  `json:"operation"`
+	Type        string  `json:"type"`
+	Probability float64 `json:"probability"`
+	LatencyMs   int     `json:"latency_ms,omitempty"`
+}
+
+func (r Rule) validate() error {
+	if r.Operation == "" {
+		return fmt.Errorf("%w: operation is required", ErrInvalidRule)
+	}
+	switch r.Type {
+	case TemplateRender, ProviderTimeout, RedisError:
+	default:
+		return fmt.Errorf("%w: unknown type %q for %s (expected %s, %s or %s)", ErrInvalidRule, r.Type, r.Operation, TemplateRender, ProviderTimeout, RedisError)
+	}
+	if r.Probability < 0 || r.Probability > 1 {
+		return fmt.Errorf("%w: probability for %s must be between 0 and 1", ErrInvalidRule, r.Operation)
+	}
+	if r.LatencyMs < 0 {
+		return fmt.Errorf("%w: latency_ms for %s must not be negative", ErrInvalidRule, r.Operation)
+	}
+	return nil
+}
+
+var (
+	mutex   sync.RWMutex
+	enabled bool
+	dryRun  bool
+	rules   = make(map[string]Rule)
+	active  *Experiment
+)
+
+// Configure enables or disables injection and replaces the rules with those parsed from
+// spec, a comma-separated list of operation=type:probability[:latencyMs]. Invalid
+// entries are left out and reported in the error; the valid ones still apply.
+func Configure(enable bool, spec string) error {
+	mutex.Lock()
+	enabled = enable
+	mutex.Unlock()
+
+	parsed, err := ParseRules(spec)
+	return errors.Join(err, SetRules(parsed))
+}
+
+// ParseRules parses a FAULT_POINTS specification. It returns the valid rules, and the
+// invalid entries joined in the error.
+func ParseRules(spec string) ([]Rule, error) {
+	var parsed []Rule
+	var errs []error
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		operation, definition, ok := strings.Cut(entry, "=")
+		parts := strings.Split(definition, ":")
+		if !ok || len(parts) < 2 || len(parts) > 3 {
+			errs = append(errs, fmt.Errorf("%w: %q (expected operation=type:probability[:latencyMs])", ErrInvalidRule, entry))
+			continue
+		}
+
+		rule := Rule{Operation: strings.TrimSpace(operation), Type: parts[0]}
+		probability, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%w: %q has an invalid probability", ErrInvalidRule, entry))
+			continue
+		}
+		rule.Probability = probability
+		if len(parts) == 3 {
+			if rule.LatencyMs, err = strconv.Atoi(parts[2]); err != nil {
+				errs = append(errs, fmt.Errorf("%w: %q has an invalid latency", ErrInvalidRule, entry))
+				continue
+			}
+		}
+		if err := rule.validate(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		parsed = append(parsed, rule)
+	}
+	return parsed, errors.Join(errs...)
+}
+
+// SetRules validates and replaces all rules
+func SetRules(newRules []Rule) error {
+	byOperation := make(map[string]Rule, len(newRules))
+	for _, rule := range newRules {
+		if err := rule.validate(); err != nil {
+			return err
+		}
+		byOperation[rule.Operation] = rule
+	}
+
+	mutex.Lock()
+	rules = byOperation
+	mutex.Unlock()
+	return nil
+}
+
+// Rules returns the active rules ordered by operation
+func Rules() []Rule {
+	mutex.RLock()
+	defer mutex.RUnlock()
+
+	list := make([]Rule, 0, len(rules))
+	for _, rule := range rules {
+		list = append(list, rule)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Operation < list[j].Operation })
+	return list
+}
+
+// Enabled reports whether fault injection is switched on (FAILURE_INJECTION_ENABLED)
+func Enabled() bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return enabled
+}
+
+// Evaluating reports whether faults are evaluated at all: injection is enabled or a dry
+// run is in progress
+func Evaluating() bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return enabled || dryRun
+}
+
+// Inject returns an error when a rule for operation fires. The fault is recorded on the
+// current span so it appears where it happened in the trace; callers handle the error
+// exactly as they would a real failure. During a dry run it records what would have
+// fired and returns nil.
+func Inject(ctx context.Context, operation string) error {
+	mutex.RLock()
+	rule, ok := rules[operation]
+	on := enabled || dryRun
+	mutex.RUnlock()
+
+	if !on || !ok {
+		return nil
+	}
+	Evaluate(operation)
+	if rand.Float64() >= rule.Probability {
+		return nil
+	}
+
+	if !Record(ctx, operation, rule.Type) {
+		return nil
+	}
+
+	switch rule.Type {
+	case TemplateRender:
+		return fmt.Errorf("%w: template render failed at %s: undefined variable", ErrInjectedFault, operation)
+	case ProviderTimeout:
+		timeout := defaultTimeout
+		if rule.LatencyMs > 0 {
+			timeout = time.Duration(rule.LatencyMs) * time.Millisecond
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(timeout):
+		}
+		return fmt.Errorf("%w: provider did not respond within %s at %s: %w", ErrInjectedFault, timeout, operation, context.DeadlineExceeded)
+	default:
+		return fmt.Errorf("%w: redis connection reset at %s", ErrInjectedFault, operation)
+	}
+}
+
+// Record marks a fault that fired at operation: the active span gets fault attributes
+// and a fault.injected event, the fault is counted and logged, and a running chaos
+// experiment is credited with it. During a dry run the fault is recorded as one that
+// would have been injected instead, and Record returns false so the caller skips it.
+func Record(ctx context.Context, operation, faultType string, extra ...attribute.KeyValue) bool {
+	mutex.RLock()
+	experiment := active
+	dry := dryRun
+	mutex.RUnlock()
+
+	if dry {
+		recordWouldInject(ctx, experiment, operation, faultType, extra)
+		return false
+	}
+
+	attrs := append([]attribute.KeyValue{
+		attribute.Bool("fault.injected", true),
+		attribute.String("fault.operation", operation),
+		attribute.String("fault.type", faultType),
+	}, extra...)
+	experimentID := ""
+	if experiment != nil {
+		experimentID = experiment.ID
+		atomic.AddInt64(&experiment.FaultsFired, 1)
+		attrs = append(attrs, attribute.String("chaos.experiment.id", experimentID))
+	}
+
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attrs...)
+	span.AddEvent("fault.injected", trace.WithAttributes(attrs...))
+	telemetry.RecordFaultInjected(ctx, operation, faultType, experimentID)
+	logAttrs := []any{"fault.type", faultType, "fault.operation", operation}
+	if experimentID != "" {
+		logAttrs = append(logAttrs, "chaos.experiment.id", experimentID)
+	}
+	slog.WarnContext(ctx, "💥 Injecting fault", logAttrs...)
+	return true
+}
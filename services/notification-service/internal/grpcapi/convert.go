@@ -0,0 +1,116 @@
+package grpcapi
+
+import (
+	"log"
+	"time"
+
+	"notification-service/internal/grpcapi/notificationpb"
+	"notification-service/internal/models"
+
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// createRequestFromProto converts a gRPC create request to the REST request model, so
+// both are validated by the same binding rules
+func createRequestFromProto(in *notificationpb.CreateNotificationRequest) models.CreateNotificationRequest {
+	req := models.CreateNotificationRequest{
+		Type:             models.NotificationType(in.GetType()),
+		Recipient:        in.GetRecipient(),
+		Subject:          in.GetSubject(),
+		Message:          in.GetMessage(),
+		Data:             structToMap(in.GetData()),
+		Priority:         models.Priority(in.GetPriority()),
+		TemplateID:       in.GetTemplateId(),
+		CustomerID:       in.GetCustomerId(),
+		OrderID:          in.GetOrderId(),
+		ScheduledAt:      timeFromProto(in.GetScheduledAt()),
+		Metadata:         structToMap(in.GetMetadata()),
+		HTMLMessage:      in.GetHtmlMessage(),
+		OptimizeSendTime: in.OptimizeSendTime,
+		IdempotencyKey:   in.GetIdempotencyKey(),
+	}
+	for _, attachment := range in.GetAttachments() {
+		req.Attachments = append(req.Attachments, models.Attachment{
+			Filename:    attachment.GetFilename(),
+			ContentType: attachment.GetContentType(),
+			Content:     attachment.GetContent(),
+		})
+	}
+	return req
+}
+
+func notificationToProto(n *models.Notification) *notificationpb.Notification {
+	if n == nil {
+		return nil
+	}
+	return &notificationpb.Notification{
+		Id:           n.ID,
+		Type:         string(n.Type),
+		Recipient:    n.Recipient,
+		Subject:      n.Subject,
+		Message:      n.Message,
+		Data:         mapToStruct(n.Data),
+		Status:       string(n.Status),
+		Priority:     string(n.Priority),
+		TemplateId:   n.TemplateID,
+		CustomerId:   n.CustomerID,
+		OrderId:      n.OrderID,
+		CreatedAt:    timestamppb.New(n.CreatedAt),
+		ScheduledAt:  timeToProto(n.ScheduledAt),
+		SentAt:       timeToProto(n.SentAt),
+		DeliveredAt:  timeToProto(n.DeliveredAt),
+		FailedAt:     timeToProto(n.FailedAt),
+		CancelledAt:  timeToProto(n.CancelledAt),
+		RetryCount:   int32(n.RetryCount),
+		MaxRetries:   int32(n.MaxRetries),
+		ErrorMessage: n.ErrorMessage,
+		Metadata:     mapToStruct(n.Metadata),
+		Version:      int32(n.Version),
+		Locale:       n.Locale,
+	}
+}
+
+func structToMap(s *structpb.Struct) map[string]interface{} {
+	if s == nil {
+		return nil
+	}
+	return s.AsMap()
+}
+
+// mapToStruct converts notification data and metadata, which hold JSON values. A value
+// with no Struct equivalent is left out rather than failing the call.
+func mapToStruct(m map[string]interface{}) *structpb.Struct {
+	if m == nil {
+		return nil
+	}
+	s, err := structpb.NewStruct(m)
+	if err == nil {
+		return s
+	}
+	s = &structpb.Struct{Fields: make(map[string]*structpb.Value, len(m))}
+	for key, value := range m {
+		converted, err := structpb.NewValue(value)
+		if err != nil {
+			log.Printf("WARN: Leaving %q out of gRPC response: %v", key, err)
+			continue
+		}
+		s.Fields[key] = converted
+	}
+	return s
+}
+
+func timeFromProto(t *timestamppb.Timestamp) *time.Time {
+	if t == nil {
+		return nil
+	}
+	converted := t.AsTime()
+	return &converted
+}
+
+func timeToProto(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
@@ -0,0 +1,253 @@
+package grpcapi
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+
+	"notification-service/internal/grpcapi/notificationpb"
+	"notification-service/internal/middleware"
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// healthService is served without a token so probes and load balancers can reach it
+const healthService = "/grpc.health.v1.Health/"
+
+// apiKeyMetadata carries the producer's API key, as the X-API-Key header does over REST
+const apiKeyMetadata = "x-api-key"
+
+// methodRoutes maps each method to the REST route it mirrors, so both APIs share rate
+// limit groups and usage
+var methodRoutes = map[string]string{
+	notificationpb.NotificationService_CreateNotification_FullMethodName:    "/api/v1/notifications",
+	notificationpb.NotificationService_SendBulkNotifications_FullMethodName: "/api/v1/notifications/bulk",
+	notificationpb.NotificationService_GetNotification_FullMethodName:       "/api/v1/notifications/:id",
+	notificationpb.NotificationService_StreamStatus_FullMethodName:          "/api/v1/notifications/:id",
+}
+
+// mutatingMethods are refused in read-only mode, like REST methods other than GET
+var mutatingMethods = map[string]bool{
+	notificationpb.NotificationService_CreateNotification_FullMethodName:    true,
+	notificationpb.NotificationService_SendBulkNotifications_FullMethodName: true,
+}
+
+// guard checks a call before its handler runs, returning the context to run it with
+type guard func(ctx context.Context, fullMethod string) (context.Context, error)
+
+func unaryGuard(check guard) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := check(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func streamGuard(check guard) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := check(stream.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &contextStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+// identityKey holds the caller's verified identity in a call's context
+type identityKey struct{}
+
+// IdentityFromContext returns the identity the caller's bearer token was verified as
+func IdentityFromContext(ctx context.Context) (*models.Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(*models.Identity)
+	return identity, ok
+}
+
+// authenticate requires the same bearer tokens as the REST API, in the authorization
+// metadata, and keeps the caller's identity in the context
+func authenticate(verifier middleware.TokenVerifier) guard {
+	return func(ctx context.Context, fullMethod string) (context.Context, error) {
+		if strings.HasPrefix(fullMethod, healthService) {
+			return ctx, nil
+		}
+		token, found := strings.CutPrefix(metadataValue(ctx, "authorization"), "Bearer ")
+		if !found || token == "" {
+			return nil, status.Error(codes.Unauthenticated, "missing bearer token")
+		}
+		identity, err := verifier.Verify(ctx, token)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		trace.SpanFromContext(ctx).SetAttributes(
+			attribute.String("enduser.id", identity.Subject),
+			attribute.String("customer.id", identity.CustomerID),
+		)
+		return context.WithValue(ctx, identityKey{}, identity), nil
+	}
+}
+
+// readOnly refuses calls that create notifications while a storage migration is
+// running, as ReadOnlyMiddleware does
+func readOnly(checker middleware.ReadOnlyChecker) guard {
+	return func(ctx context.Context, fullMethod string) (context.Context, error) {
+		if mutatingMethods[fullMethod] && checker.IsReadOnly(ctx) {
+			return nil, status.Error(codes.Unavailable, "service is in read-only mode for maintenance")
+		}
+		return ctx, nil
+	}
+}
+
+// apiKeyIDKey holds the ID of the call's valid API key in its context
+type apiKeyIDKey struct{}
+
+func apiKeyIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(apiKeyIDKey{}).(string)
+	return id, ok
+}
+
+// checkAPIKey refuses calls with an API key that isn't configured, as APIKeyMiddleware
+// does, and keeps a valid key's ID in the context
+func checkAPIKey(keys *middleware.APIKeys) guard {
+	return func(ctx context.Context, fullMethod string) (context.Context, error) {
+		apiKey := metadataValue(ctx, apiKeyMetadata)
+		if apiKey == "" {
+			return ctx, nil
+		}
+		if !keys.Valid(apiKey) {
+			return nil, status.Error(codes.Unauthenticated, "unknown API key")
+		}
+		return context.WithValue(ctx, apiKeyIDKey{}, middleware.APIKeyID(apiKey)), nil
+	}
+}
+
+// rateLimit takes each call from the allowances of its API key and customer in its REST
+// route's group, or of its peer address when it has neither, as RateLimitMiddleware
+// does. A call over any of its allowances fails with ResourceExhausted and a
+// retry-after header.
+func rateLimit(limiter middleware.RateLimitChecker) guard {
+	return func(ctx context.Context, fullMethod string) (context.Context, error) {
+		route, ok := methodRoutes[fullMethod]
+		if !ok {
+			return ctx, nil
+		}
+		var subjects []models.RateLimitSubject
+		if keyID, ok := apiKeyIDFromContext(ctx); ok {
+			subjects = append(subjects, models.RateLimitSubject{Kind: middleware.RateLimitSubjectAPIKey, ID: keyID})
+		}
+		if identity, ok := IdentityFromContext(ctx); ok && identity.CustomerID != "" {
+			subjects = append(subjects, models.RateLimitSubject{Kind: middleware.RateLimitSubjectCustomer, ID: identity.CustomerID})
+		}
+		if len(subjects) == 0 {
+			if p, ok := peer.FromContext(ctx); ok {
+				host, _, err := net.SplitHostPort(p.Addr.String())
+				if err != nil {
+					host = p.Addr.String()
+				}
+				subjects = append(subjects, models.RateLimitSubject{Kind: middleware.RateLimitSubjectClientIP, ID: host})
+			}
+		}
+		if len(subjects) == 0 {
+			return ctx, nil
+		}
+
+		decision, err := limiter.Allow(ctx, route, subjects)
+		if err != nil {
+			slog.WarnContext(ctx, "Rate limit check failed, allowing call", "error", err)
+			return ctx, nil
+		}
+		if decision.Group == "" {
+			return ctx, nil
+		}
+		span := trace.SpanFromContext(ctx)
+		span.SetAttributes(
+			attribute.String("ratelimit.group", decision.Group),
+			attribute.Bool("ratelimit.hit", !decision.Allowed),
+		)
+		if decision.Allowed {
+			return ctx, nil
+		}
+
+		retryAfter := max(int(math.Ceil(decision.RetryAfter.Seconds())), 1)
+		span.SetAttributes(attribute.String("ratelimit.subject", decision.Subject.Kind))
+		telemetry.RecordRateLimitHit(ctx, decision.Group, decision.Subject.Kind)
+		_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(retryAfter)))
+		return nil, status.Errorf(codes.ResourceExhausted, "rate limit exceeded for %s in group %s, retry after %ds",
+			decision.Subject.Kind, decision.Group, retryAfter)
+	}
+}
+
+// usageUnaryInterceptor records calls, errors, message bytes and notifications created
+// for callers that present a valid API key, as UsageMiddleware does
+func usageUnaryInterceptor(recorder middleware.UsageRecorder) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		keyID, ok := apiKeyIDFromContext(ctx)
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		resp, err := handler(ctx, req)
+		sample := models.UsageSample{Error: err != nil}
+		if message, ok := req.(proto.Message); ok {
+			sample.BytesIn = int64(proto.Size(message))
+		}
+		switch resp := resp.(type) {
+		case *notificationpb.CreateNotificationResponse:
+			sample.BytesOut = int64(proto.Size(resp))
+			sample.Notifications = 1
+		case *notificationpb.BulkNotificationResponse:
+			sample.BytesOut = int64(proto.Size(resp))
+			sample.Notifications = int(resp.GetAccepted())
+		case proto.Message:
+			sample.BytesOut = int64(proto.Size(resp))
+		}
+
+		// The recorder only queues the sample, so it never adds call latency
+		recorder.RecordUsage(ctx, keyID, sample)
+		return resp, err
+	}
+}
+
+func usageStreamInterceptor(recorder middleware.UsageRecorder) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		keyID, ok := apiKeyIDFromContext(stream.Context())
+		if !ok {
+			return handler(srv, stream)
+		}
+
+		err := handler(srv, stream)
+		recorder.RecordUsage(stream.Context(), keyID, models.UsageSample{Error: err != nil})
+		return err
+	}
+}
+
+// metadataValue returns the first value of an incoming metadata key
+func metadataValue(ctx context.Context, key string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// contextStream carries a guard's context into a streaming handler
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,299 @@
+package grpcapi
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/grpcapi/notificationpb"
+	"notification-service/internal/middleware"
+	"notification-service/internal/models"
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+	"notification-service/internal/storage"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Bounds on a StreamStatus call
+const (
+	maxStreamedNotifications = 100
+	defaultStatusPoll        = time.Second
+)
+
+// NotificationSubmitter creates notifications the way the REST handlers do, so both APIs
+// validate, render, screen and store them alike
+type NotificationSubmitter interface {
+	Submit(ctx context.Context, req models.CreateNotificationRequest) (*models.Notification, bool, bool, error)
+	SubmitBulk(ctx context.Context, reqs []models.CreateNotificationRequest) ([]models.BulkNotificationResult, int)
+}
+
+// NotificationServer implements notification.v1.NotificationService on the same service
+// layer as the REST API
+type NotificationServer struct {
+	notificationpb.UnimplementedNotificationServiceServer
+
+	submitter     NotificationSubmitter
+	notifications services.NotificationManager
+	statusPoll    time.Duration
+}
+
+func NewNotificationServer(cfg *config.Config, submitter NotificationSubmitter, notifications services.NotificationManager) *NotificationServer {
+	statusPoll := time.Duration(cfg.GRPCStatusPollMs) * time.Millisecond
+	if statusPoll <= 0 {
+		statusPoll = defaultStatusPoll
+	}
+	return &NotificationServer{
+		submitter:     submitter,
+		notifications: notifications,
+		statusPoll:    statusPoll,
+	}
+}
+
+// ServerOptions are the checks the REST API applies in middleware, applied to gRPC calls
+// in the same order. Nil ones are skipped.
+type ServerOptions struct {
+	Verifier  middleware.TokenVerifier
+	ReadOnly  middleware.ReadOnlyChecker
+	APIKeys   *middleware.APIKeys
+	Usage     middleware.UsageRecorder
+	RateLimit middleware.RateLimitChecker
+}
+
+// NewServer builds the gRPC server: the notification service, the standard health
+// service and reflection for tools such as grpcurl. Every call is traced and timed by
+// otelgrpc; with a verifier, calls other than health checks need a bearer token.
+func NewServer(notifications *NotificationServer, options ServerOptions) *grpc.Server {
+	var unary []grpc.UnaryServerInterceptor
+	var stream []grpc.StreamServerInterceptor
+	guards := func(check guard) {
+		unary = append(unary, unaryGuard(check))
+		stream = append(stream, streamGuard(check))
+	}
+	if options.Verifier != nil {
+		guards(authenticate(options.Verifier))
+	}
+	if options.ReadOnly != nil {
+		guards(readOnly(options.ReadOnly))
+	}
+	if options.APIKeys != nil && options.APIKeys.Enabled() {
+		guards(checkAPIKey(options.APIKeys))
+	}
+	if options.Usage != nil {
+		unary = append(unary, usageUnaryInterceptor(options.Usage))
+		stream = append(stream, usageStreamInterceptor(options.Usage))
+	}
+	if options.RateLimit != nil {
+		guards(rateLimit(options.RateLimit))
+	}
+
+	server := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+	)
+	notificationpb.RegisterNotificationServiceServer(server, notifications)
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	reflection.Register(server)
+	return server
+}
+
+func (s *NotificationServer) CreateNotification(ctx context.Context, in *notificationpb.CreateNotificationRequest) (*notificationpb.CreateNotificationResponse, error) {
+	req := createRequestFromProto(in)
+	if err := router.Validate(&req); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := checkCustomer(ctx, req.CustomerID); err != nil {
+		return nil, err
+	}
+
+	notification, buffered, replayed, err := s.submitter.Submit(ctx, req)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &notificationpb.CreateNotificationResponse{Notification: notificationToProto(notification), Buffered: buffered, Replayed: replayed}, nil
+}
+
+// SendBulkNotifications creates up to 100 notifications; as over REST, one bad
+// notification fails alone and is reported in its result
+func (s *NotificationServer) SendBulkNotifications(ctx context.Context, in *notificationpb.BulkNotificationRequest) (*notificationpb.BulkNotificationResponse, error) {
+	bulk := models.BulkNotificationRequest{Notifications: make([]models.CreateNotificationRequest, len(in.GetNotifications()))}
+	for i, notification := range in.GetNotifications() {
+		bulk.Notifications[i] = createRequestFromProto(notification)
+	}
+	if err := router.Validate(&bulk); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	for _, req := range bulk.Notifications {
+		if err := checkCustomer(ctx, req.CustomerID); err != nil {
+			return nil, err
+		}
+	}
+
+	results, accepted := s.submitter.SubmitBulk(ctx, bulk.Notifications)
+	response := &notificationpb.BulkNotificationResponse{
+		Results:  make([]*notificationpb.BulkNotificationResult, len(results)),
+		Accepted: int32(accepted),
+		Failed:   int32(len(results) - accepted),
+	}
+	for i, result := range results {
+		response.Results[i] = &notificationpb.BulkNotificationResult{
+			Index:        int32(result.Index),
+			Result:       result.Result,
+			Notification: notificationToProto(result.Notification),
+			Buffered:     result.Buffered,
+			Error:        result.Error,
+			Replayed:     result.Replayed,
+		}
+	}
+	return response, nil
+}
+
+func (s *NotificationServer) GetNotification(ctx context.Context, in *notificationpb.GetNotificationRequest) (*notificationpb.Notification, error) {
+	if in.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "id is required")
+	}
+	notification, err := s.notifications.GetNotification(ctx, in.GetId())
+	if err == nil && !callerOwns(ctx, notification) {
+		err = services.ErrNotificationNotFound
+	}
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return notificationToProto(notification), nil
+}
+
+// checkCustomer refuses a caller whose token belongs to a customer to act for another
+// one; tokens without a customer, used by internal services, may act for any
+func checkCustomer(ctx context.Context, customerID string) error {
+	identity, ok := IdentityFromContext(ctx)
+	if !ok || identity.CustomerID == "" || identity.CustomerID == customerID {
+		return nil
+	}
+	return status.Errorf(codes.PermissionDenied, "token does not grant access to customer %s", customerID)
+}
+
+// callerOwns reports whether the caller may see a notification. Others' notifications
+// are reported as not found, so their IDs can't be probed.
+func callerOwns(ctx context.Context, notification *models.Notification) bool {
+	return checkCustomer(ctx, notification.CustomerID) == nil
+}
+
+// StreamStatus polls the requested notifications every GRPC_STATUS_POLL_MS, sending each
+// one's current status first and then every change, until all of them are final
+func (s *NotificationServer) StreamStatus(in *notificationpb.StreamStatusRequest, stream grpc.ServerStreamingServer[notificationpb.StatusUpdate]) error {
+	ids := in.GetNotificationIds()
+	if len(ids) == 0 || len(ids) > maxStreamedNotifications {
+		return status.Errorf(codes.InvalidArgument, "between 1 and %d notification_ids are required", maxStreamedNotifications)
+	}
+
+	ctx := stream.Context()
+	pending := make(map[string]models.NotificationStatus, len(ids))
+	for _, id := range ids {
+		pending[id] = ""
+	}
+	ticker := time.NewTicker(s.statusPoll)
+	defer ticker.Stop()
+	for {
+		for id, last := range pending {
+			update, final, err := s.statusUpdate(ctx, id)
+			if err != nil {
+				return statusError(err)
+			}
+			if models.NotificationStatus(update.Status) != last || update.NotFound {
+				if err := stream.Send(update); err != nil {
+					return err
+				}
+				pending[id] = models.NotificationStatus(update.Status)
+			}
+			if final {
+				delete(pending, id)
+			}
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// statusUpdate reads a notification's status and whether it will change again. A
+// missing notification is final.
+func (s *NotificationServer) statusUpdate(ctx context.Context, id string) (*notificationpb.StatusUpdate, bool, error) {
+	update := &notificationpb.StatusUpdate{NotificationId: id, ObservedAt: timestamppb.Now()}
+	notification, err := s.notifications.GetNotification(ctx, id)
+	if err == nil && !callerOwns(ctx, notification) {
+		err = services.ErrNotificationNotFound
+	}
+	if errors.Is(err, services.ErrNotificationNotFound) {
+		update.NotFound = true
+		return update, true, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	update.Status = string(notification.Status)
+	update.ErrorMessage = notification.ErrorMessage
+	update.RetryCount = int32(notification.RetryCount)
+	return update, finalStatus(notification.Status), nil
+}
+
+// finalStatus reports whether the stream is done with a notification. Sent counts as
+// final: most channels never confirm delivery, and a receipt posted later through the
+// status API would otherwise hold the stream open indefinitely.
+func finalStatus(s models.NotificationStatus) bool {
+	switch s {
+	case models.NotificationStatusSent, models.NotificationStatusDelivered, models.NotificationStatusFailed, models.NotificationStatusCancelled,
+		models.NotificationStatusBlocked, models.NotificationStatusSuppressed, models.NotificationStatusDuplicateSuppressed:
+		return true
+	default:
+		return false
+	}
+}
+
+// statusError maps service errors to gRPC status codes, as notificationError maps them to
+// HTTP statuses
+func statusError(err error) error {
+	var schemaErr *services.SchemaValidationError
+	var renderErr *services.TemplateRenderError
+	switch {
+	case errors.Is(err, services.ErrNotificationNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, services.ErrVersionMismatch):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, services.ErrNotificationNotEditable), errors.Is(err, services.ErrInvalidStatusTransition):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, storage.ErrInvalidCursor), errors.Is(err, services.ErrInvalidTarget), errors.Is(err, services.ErrInvalidRecipient):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, services.ErrNoTargetDevices):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, services.ErrStorageUnavailable), errors.Is(err, services.ErrBufferFull), errors.Is(err, services.ErrIdempotencyUnavailable):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.As(err, &schemaErr), errors.As(err, &renderErr):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, services.ErrTemplateNotFound), errors.Is(err, services.ErrTemplateInactive), errors.Is(err, services.ErrTemplateVersionNotFound),
+		errors.Is(err, services.ErrTemplateVersionNotPublished):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, services.ErrIdempotencyKeyReused):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, services.ErrIdempotencyInProgress):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
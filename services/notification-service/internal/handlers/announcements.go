@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"notification-service/internal/middleware"
+	"notification-service/internal/models"
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+)
+
+// AnnouncementHandler serves system announcements: outage banners and other notices
+// shown to every client
+type AnnouncementHandler struct {
+	announcements services.AnnouncementManager
+}
+
+func NewAnnouncementHandler(announcements services.AnnouncementManager) *AnnouncementHandler {
+	return &AnnouncementHandler{announcements: announcements}
+}
+
+// CreateAnnouncement schedules an announcement; one that has started is broadcast to
+// connected clients at once
+func (h *AnnouncementHandler) CreateAnnouncement(c *router.Context) {
+	var req models.CreateAnnouncementRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+
+	announcement, err := h.announcements.Create(c.Request.Context(), req, c.GetHeader(middleware.UserIDHeader))
+	if err != nil {
+		announcementError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, router.H{"announcement": announcement})
+}
+
+// ListAnnouncements returns the active announcements, for clients that connect after
+// they were broadcast; ?scheduled=true adds the upcoming ones
+func (h *AnnouncementHandler) ListAnnouncements(c *router.Context) {
+	announcements, err := h.announcements.List(c.Request.Context(), c.Query("scheduled") == "true")
+	if err != nil {
+		announcementError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"announcements": announcements, "count": len(announcements)})
+}
+
+// EndAnnouncement takes an announcement down before its end time, or cancels a
+// scheduled one
+func (h *AnnouncementHandler) EndAnnouncement(c *router.Context) {
+	announcement, err := h.announcements.End(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		announcementError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"announcement": announcement})
+}
+
+func announcementError(c *router.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidAnnouncement):
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+	case errors.Is(err, services.ErrAnnouncementNotFound):
+		c.JSON(http.StatusNotFound, router.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"notification-service/internal/models"
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+)
+
+// BlackoutHandler lets admins manage each tenant's blackout calendar and see how many
+// notifications it held and when they are released
+type BlackoutHandler struct {
+	blackouts services.BlackoutManager
+}
+
+func NewBlackoutHandler(blackouts services.BlackoutManager) *BlackoutHandler {
+	return &BlackoutHandler{blackouts: blackouts}
+}
+
+func (h *BlackoutHandler) GetBlackoutCalendars(c *router.Context) {
+	calendars, err := h.blackouts.Calendars(c.Request.Context())
+	if err != nil {
+		blackoutError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"calendars": calendars})
+}
+
+func (h *BlackoutHandler) GetBlackoutCalendar(c *router.Context) {
+	calendar, err := h.blackouts.Calendar(c.Request.Context(), c.Param("tenantId"))
+	if err != nil {
+		blackoutError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"calendar": calendar})
+}
+
+// SetBlackoutCalendar replaces the windows of the tenant in the path
+func (h *BlackoutHandler) SetBlackoutCalendar(c *router.Context) {
+	var req models.BlackoutCalendarRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+
+	calendar, err := h.blackouts.SetCalendar(c.Request.Context(), c.Param("tenantId"), req.Windows)
+	if err != nil {
+		blackoutError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"calendar": calendar})
+}
+
+func (h *BlackoutHandler) DeleteBlackoutCalendar(c *router.Context) {
+	if err := h.blackouts.DeleteCalendar(c.Request.Context(), c.Param("tenantId")); err != nil {
+		blackoutError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func blackoutError(c *router.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrBlackoutCalendarNotFound):
+		c.JSON(http.StatusNotFound, router.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidBlackoutCalendar):
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+	}
+}
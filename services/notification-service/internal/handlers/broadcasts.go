@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"notification-service/internal/middleware"
+	"notification-service/internal/models"
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+)
+
+// BroadcastApproverRole is required to approve or reject a held broadcast
+const BroadcastApproverRole = "broadcast-approver"
+
+// BroadcastHandler serves broadcast submission and the second-approver workflow
+type BroadcastHandler struct {
+	broadcastService services.BroadcastManager
+}
+
+func NewBroadcastHandler(broadcastService services.BroadcastManager) *BroadcastHandler {
+	return &BroadcastHandler{broadcastService: broadcastService}
+}
+
+// BroadcastNotification starts sending a broadcast, or holds it for a second approver.
+// Either way it answers 202; GET /broadcasts/:id follows its progress.
+func (h *BroadcastHandler) BroadcastNotification(c *router.Context) {
+	requestedBy := c.GetHeader(middleware.UserIDHeader)
+	if requestedBy == "" {
+		c.JSON(http.StatusUnauthorized, router.H{"error": "missing " + middleware.UserIDHeader + " header"})
+		return
+	}
+
+	var req models.BroadcastNotificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+
+	job, err := h.broadcastService.Submit(c.Request.Context(), req, requestedBy)
+	if err != nil {
+		broadcastError(c, err)
+		return
+	}
+
+	if job.Status == models.BroadcastStatusSending {
+		c.Set(middleware.UsageNotificationsKey, job.Progress.Total)
+	}
+	c.JSON(http.StatusAccepted, router.H{"broadcast": job})
+}
+
+func (h *BroadcastHandler) GetBroadcast(c *router.Context) {
+	job, err := h.broadcastService.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		broadcastError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"broadcast": job})
+}
+
+func (h *BroadcastHandler) GetBroadcastAudit(c *router.Context) {
+	records, err := h.broadcastService.AuditTrail(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		broadcastError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"audit": records})
+}
+
+func (h *BroadcastHandler) ApproveBroadcast(c *router.Context) {
+	var req models.BroadcastDecisionRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+
+	job, err := h.broadcastService.Approve(c.Request.Context(), c.Param("id"), c.GetHeader(middleware.UserIDHeader), req.Comment)
+	if err != nil {
+		broadcastError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"broadcast": job})
+}
+
+func (h *BroadcastHandler) RejectBroadcast(c *router.Context) {
+	var req models.BroadcastDecisionRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+
+	job, err := h.broadcastService.Reject(c.Request.Context(), c.Param("id"), c.GetHeader(middleware.UserIDHeader), req.Comment)
+	if err != nil {
+		broadcastError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"broadcast": job})
+}
+
+func broadcastError(c *router.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidBroadcast):
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+	case errors.Is(err, services.ErrBroadcastNotFound):
+		c.JSON(http.StatusNotFound, router.H{"error": err.Error()})
+	case errors.Is(err, services.ErrSelfApproval):
+		c.JSON(http.StatusForbidden, router.H{"error": err.Error()})
+	case errors.Is(err, services.ErrBroadcastNotPending), errors.Is(err, services.ErrBroadcastExpired):
+		c.JSON(http.StatusConflict, router.H{"error": err.Error()})
+	case errors.Is(err, services.ErrContentBlocked):
+		c.JSON(http.StatusUnprocessableEntity, router.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+	}
+}
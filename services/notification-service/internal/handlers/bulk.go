@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"notification-service/internal/middleware"
+	"notification-service/internal/models"
+	"notification-service/internal/router"
+	"notification-service/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SendBulkNotifications creates up to 100 notifications, each as CreateNotification
+// would. One bad notification doesn't fail the others: the response has a result per
+// notification, in request order.
+func (h *NotificationHandler) SendBulkNotifications(c *router.Context) {
+	var req models.BulkNotificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+
+	results, accepted := h.SubmitBulk(c.Request.Context(), req.Notifications)
+	c.Set(middleware.UsageNotificationsKey, accepted)
+	c.JSON(http.StatusOK, router.H{"results": results, "accepted": accepted, "failed": len(results) - accepted})
+}
+
+// SubmitBulk validates and creates each notification as Submit would, on BULK_WORKERS
+// workers, returning a result per notification in request order and how many were
+// accepted. The whole batch runs in a notification.bulk span with a child span per
+// notification.
+func (h *NotificationHandler) SubmitBulk(ctx context.Context, reqs []models.CreateNotificationRequest) ([]models.BulkNotificationResult, int) {
+	ctx, span := telemetry.Tracer.Start(ctx, "notification.bulk",
+		trace.WithAttributes(attribute.Int("bulk.size", len(reqs))),
+	)
+	defer span.End()
+
+	results := make([]models.BulkNotificationResult, len(reqs))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range min(h.bulkWorkers, len(reqs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] = h.createBulkItem(ctx, i, reqs[i])
+			}
+		}()
+	}
+	for i := range reqs {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	accepted := 0
+	for _, result := range results {
+		if result.Result == models.BulkResultAccepted {
+			accepted++
+		}
+	}
+	failed := len(results) - accepted
+	span.SetAttributes(attribute.Int("bulk.accepted", accepted), attribute.Int("bulk.failed", failed))
+	if failed > 0 {
+		span.SetStatus(codes.Error, fmt.Sprintf("%d of %d notifications failed", failed, len(results)))
+	}
+	return results, accepted
+}
+
+// createBulkItem validates and creates one notification of a bulk request in its own span
+func (h *NotificationHandler) createBulkItem(ctx context.Context, index int, req models.CreateNotificationRequest) models.BulkNotificationResult {
+	ctx, span := telemetry.Tracer.Start(ctx, "notification.bulk.item",
+		trace.WithAttributes(
+			attribute.Int("bulk.index", index),
+			attribute.String("notification.channel", string(req.Type)),
+			attribute.String("customer.id", req.CustomerID),
+		),
+	)
+	defer span.End()
+
+	result := models.BulkNotificationResult{Index: index, Result: models.BulkResultFailed}
+	// Items aren't validated when the request is bound, so each fails on its own
+	err := router.Validate(&req)
+	if err == nil {
+		result.Notification, result.Buffered, result.Replayed, err = h.Submit(ctx, req)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to create notification")
+		result.Error = err.Error()
+		return result
+	}
+
+	span.SetAttributes(
+		attribute.String("notification.id", result.Notification.ID),
+		attribute.String("notification.status", string(result.Notification.Status)),
+	)
+	result.Result = models.BulkResultAccepted
+	return result
+}
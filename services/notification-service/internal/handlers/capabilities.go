@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"net/http"
+
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+)
+
+// CapabilitiesHandler tells clients what this deployment supports
+type CapabilitiesHandler struct {
+	reporter *services.CapabilityReporter
+}
+
+func NewCapabilitiesHandler(reporter *services.CapabilityReporter) *CapabilitiesHandler {
+	return &CapabilitiesHandler{reporter: reporter}
+}
+
+// GetCapabilities reports the enabled channels, configured providers, limits, retention
+// and sandbox mode, so frontends and producers can adapt instead of hard-coding them
+func (h *CapabilitiesHandler) GetCapabilities(c *router.Context) {
+	c.JSON(http.StatusOK, h.reporter.Capabilities(c.Request.Context()))
+}
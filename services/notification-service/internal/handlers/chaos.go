@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"notification-service/internal/faults"
+	"notification-service/internal/router"
+)
+
+// GetChaosExperiments lists chaos experiments run by this instance, most recent first
+func GetChaosExperiments(c *router.Context) {
+	c.JSON(http.StatusOK, router.H{
+		"active":      faults.ActiveExperimentID(),
+		"experiments": faults.Experiments(),
+	})
+}
+
+// StartChaosExperiment activates fault rules under a new chaos.experiment.id
+func StartChaosExperiment(c *router.Context) {
+	var req faults.ExperimentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+
+	experiment, err := faults.StartExperiment(req.Name, req.Rules, time.Duration(req.DurationSeconds)*time.Second)
+	switch {
+	case errors.Is(err, faults.ErrExperimentRunning):
+		c.JSON(http.StatusConflict, router.H{"error": err.Error()})
+		return
+	case errors.Is(err, faults.ErrInvalidRule):
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, router.H{"experiment": experiment})
+}
+
+// StopChaosExperiment ends the running experiment and restores the previous fault rules
+func StopChaosExperiment(c *router.Context) {
+	experiment, err := faults.StopExperiment(c.Param("id"))
+	if errors.Is(err, faults.ErrExperimentNotFound) {
+		c.JSON(http.StatusNotFound, router.H{"error": "no running chaos experiment with that id"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, router.H{"experiment": experiment})
+}
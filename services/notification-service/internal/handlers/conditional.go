@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"net/http"
+
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+)
+
+// notModified sets the ETag of the representation about to be sent and answers 304 Not
+// Modified instead when the request's If-None-Match already has it, so pollers don't
+// download unchanged resources
+func notModified(c *router.Context, resource interface{}) bool {
+	etag := services.ETag(resource)
+	c.Header("ETag", etag)
+	if services.MatchesETag(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return true
+	}
+	return false
+}
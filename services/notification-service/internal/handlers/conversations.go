@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+)
+
+// ConversationHandler serves conversations: the notifications sent for an order or
+// customer across channels, threaded with the replies they got
+type ConversationHandler struct {
+	conversations services.ConversationReader
+}
+
+func NewConversationHandler(conversations services.ConversationReader) *ConversationHandler {
+	return &ConversationHandler{conversations: conversations}
+}
+
+// GetConversation returns a conversation's thread, oldest message first
+func (h *ConversationHandler) GetConversation(c *router.Context) {
+	conversation, err := h.conversations.Conversation(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		if errors.Is(err, services.ErrConversationNotFound) {
+			c.JSON(http.StatusNotFound, router.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"conversation": conversation})
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"net/http"
+
+	"notification-service/internal/models"
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+)
+
+// CustomerDigestHandler serves the digests customers' low-priority notifications are
+// batched into
+type CustomerDigestHandler struct {
+	digests services.CustomerDigestManager
+}
+
+func NewCustomerDigestHandler(digests services.CustomerDigestManager) *CustomerDigestHandler {
+	return &CustomerDigestHandler{digests: digests}
+}
+
+// GetCustomerDigests lists a customer's open digests and the notifications held in them
+func (h *CustomerDigestHandler) GetCustomerDigests(c *router.Context) {
+	digests, err := h.digests.Pending(c.Request.Context(), c.Param("customerId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+	if digests == nil {
+		digests = []*models.CustomerDigest{}
+	}
+	c.JSON(http.StatusOK, router.H{"customer_id": c.Param("customerId"), "digests": digests})
+}
+
+// FlushCustomerDigests sends a customer's open digests now instead of at their due time
+func (h *CustomerDigestHandler) FlushCustomerDigests(c *router.Context) {
+	digests, err := h.digests.Flush(c.Request.Context(), c.Param("customerId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+	if digests == nil {
+		digests = []*models.CustomerDigest{}
+	}
+	c.JSON(http.StatusOK, router.H{"customer_id": c.Param("customerId"), "digests": digests})
+}
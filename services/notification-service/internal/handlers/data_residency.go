@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"net/http"
+
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+)
+
+// DataResidencyHandler reports which data region each pinned tenant's notifications
+// are stored in
+type DataResidencyHandler struct {
+	residency services.ResidencyReporter
+}
+
+func NewDataResidencyHandler(residency services.ResidencyReporter) *DataResidencyHandler {
+	return &DataResidencyHandler{residency: residency}
+}
+
+// GetDataResidency lists the data regions with their tenants and the state of their stores
+func (h *DataResidencyHandler) GetDataResidency(c *router.Context) {
+	c.JSON(http.StatusOK, h.residency.Status(c.Request.Context()))
+}
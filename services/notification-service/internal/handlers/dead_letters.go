@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"notification-service/internal/models"
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+)
+
+// DeadLetterHandler lets admins inspect, re-drive and discard dead-lettered notifications
+type DeadLetterHandler struct {
+	deadLetters   services.DeadLetterManager
+	notifications services.NotificationManager
+}
+
+func NewDeadLetterHandler(deadLetters services.DeadLetterManager, notifications services.NotificationManager) *DeadLetterHandler {
+	return &DeadLetterHandler{deadLetters: deadLetters, notifications: notifications}
+}
+
+// GetDeadLetters lists dead letters newest first, paged with cursor
+func (h *DeadLetterHandler) GetDeadLetters(c *router.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 500 {
+		c.JSON(http.StatusBadRequest, router.H{"error": "limit must be between 1 and 500"})
+		return
+	}
+
+	letters, next, err := h.deadLetters.List(c.Request.Context(), c.Query("cursor"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+	total, err := h.deadLetters.Count(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+	if letters == nil {
+		letters = []*models.DeadLetter{}
+	}
+	c.JSON(http.StatusOK, router.H{"dead_letters": letters, "total": total, "next_cursor": next})
+}
+
+func (h *DeadLetterHandler) GetDeadLetter(c *router.Context) {
+	letter, err := h.deadLetters.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		deadLetterError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"dead_letter": letter})
+}
+
+// RedriveDeadLetter re-sends a dead letter, optionally on another channel. A failed
+// re-drive answers 502 with the letter as dead-lettered again.
+func (h *DeadLetterHandler) RedriveDeadLetter(c *router.Context) {
+	var req models.RedriveDeadLetterRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	letter, err := h.deadLetters.Redrive(ctx, c.Param("id"), req.Channel)
+	if errors.Is(err, services.ErrRedriveFailed) && letter != nil {
+		c.JSON(http.StatusBadGateway, router.H{"error": err.Error(), "dead_letter": letter})
+		return
+	}
+	if err != nil {
+		deadLetterError(c, err)
+		return
+	}
+
+	// Stored notifications record the re-send; fallback notifications were never stored
+	status := letter.Notification.Status
+	if status != models.NotificationStatusDelivered {
+		status = models.NotificationStatusSent
+	}
+	_, err = h.notifications.UpdateNotificationStatus(ctx, letter.NotificationID, models.UpdateNotificationStatusRequest{Status: status})
+	if err != nil && !errors.Is(err, services.ErrNotificationNotFound) {
+		slog.WarnContext(ctx, "Re-drove notification but failed to update its status", "notification.id", letter.NotificationID, "error", err)
+	}
+	c.JSON(http.StatusOK, router.H{"dead_letter": letter, "redriven": true})
+}
+
+func (h *DeadLetterHandler) DiscardDeadLetter(c *router.Context) {
+	if err := h.deadLetters.Discard(c.Request.Context(), c.Param("id")); err != nil {
+		deadLetterError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func deadLetterError(c *router.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrDeadLetterNotFound):
+		c.JSON(http.StatusNotFound, router.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidRedrive):
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+	case errors.Is(err, services.ErrRedriveSuppressed), errors.Is(err, services.ErrRedriveInProgress):
+		c.JSON(http.StatusConflict, router.H{"error": err.Error()})
+	case errors.Is(err, services.ErrContentBlocked):
+		c.JSON(http.StatusUnprocessableEntity, router.H{"error": err.Error()})
+	case errors.Is(err, services.ErrRedriveFailed):
+		c.JSON(http.StatusBadGateway, router.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// suppressDuplicate marks a pending notification duplicate_suppressed when one with the
+// same content was created for its customer on its channel within the dedupe window,
+// unless that one ended failed, and reports whether notification claimed its content instead. Blocked and suppressed
+// notifications claim nothing, since they aren't sent. Redis being unreachable doesn't
+// block creation, which then goes ahead without dedupe.
+func (h *NotificationHandler) suppressDuplicate(ctx context.Context, notification *models.Notification, dedupe *bool) bool {
+	if notification.Status != models.NotificationStatusPending {
+		return false
+	}
+	original, claimed, err := h.duplicates.Claim(ctx, notification, dedupe)
+	if err != nil {
+		slog.WarnContext(ctx, "Creating notification without dedupe", "notification.id", notification.ID, "error", err)
+		return false
+	}
+	if original == "" {
+		return claimed
+	}
+	if h.originalFailed(ctx, original) {
+		claimed, err := h.duplicates.Takeover(ctx, notification, original)
+		if err != nil {
+			slog.WarnContext(ctx, "Creating notification without dedupe", "notification.id", notification.ID, "error", err)
+			return false
+		}
+		if claimed {
+			return true
+		}
+	}
+
+	notification.Status = models.NotificationStatusDuplicateSuppressed
+	notification.DuplicateOf = original
+	notification.ErrorMessage = "duplicate of notification " + original
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("notification.duplicate_of", original))
+	telemetry.RecordNotificationSuppressed(ctx, string(notification.Type), models.SuppressionDuplicate)
+	slog.InfoContext(ctx, "Suppressed duplicate notification", "notification.id", notification.ID, "notification.duplicate_of", original, "customer.id", notification.CustomerID)
+	return false
+}
+
+// originalFailed reports whether the notification holding a content hash ended failed,
+// so a notification with its content is a resubmission rather than a duplicate. An
+// original that can't be loaded, such as a broadcast's, counts as sent.
+func (h *NotificationHandler) originalFailed(ctx context.Context, original string) bool {
+	notification, err := h.notificationService.GetNotification(ctx, original)
+	return err == nil && notification.Status == models.NotificationStatusFailed
+}
+
+// releaseDuplicate frees the content claimed by a notification that wasn't stored, so
+// its retry isn't suppressed as a duplicate of it
+func (h *NotificationHandler) releaseDuplicate(ctx context.Context, notification *models.Notification) {
+	if err := h.duplicates.Release(context.WithoutCancel(ctx), notification); err != nil {
+		slog.WarnContext(ctx, "Failed to release content hash", "notification.id", notification.ID, "error", err)
+	}
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"notification-service/internal/models"
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+)
+
+// DeliveryStatsHandler serves delivery statistics computed from stored notifications
+type DeliveryStatsHandler struct {
+	stats         services.DeliveryStatsProvider
+	notifications services.NotificationManager
+	smsConsent    services.SMSConsentManager
+}
+
+func NewDeliveryStatsHandler(stats services.DeliveryStatsProvider, notifications services.NotificationManager, smsConsent services.SMSConsentManager) *DeliveryStatsHandler {
+	return &DeliveryStatsHandler{stats: stats, notifications: notifications, smsConsent: smsConsent}
+}
+
+// GetDeliveryStats returns the delivery statistics of the notifications created in the
+// time_range (1h, 24h or 7d, 24h by default), with the cancellation and replacement counts
+// and the SMS keywords received in the same range
+func (h *DeliveryStatsHandler) GetDeliveryStats(c *router.Context) {
+	ctx := c.Request.Context()
+	timeRange := c.DefaultQuery("time_range", services.DefaultDeliveryStatsRange)
+	stats, err := h.stats.DeliveryStats(ctx, timeRange)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidTimeRange):
+			c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		case errors.Is(err, services.ErrDeliveryStatsUnavailable):
+			c.JSON(http.StatusServiceUnavailable, router.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		}
+		return
+	}
+
+	cancellations, err := h.notifications.CancellationStats(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+	stats.Cancellations = cancellations
+
+	replacements, err := h.notifications.ReplacementStats(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+	stats.Replacements = replacements
+
+	consent, err := h.smsConsent.SMSConsentStats(ctx, timeRange)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+	if sent := stats.ByType[models.NotificationTypeSMS].Sent; sent > 0 {
+		consent.OptOutRate = float64(consent.OptOuts) / float64(sent)
+	}
+	stats.SMSConsent = &consent
+	c.JSON(http.StatusOK, router.H{"stats": stats})
+}
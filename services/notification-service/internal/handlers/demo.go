@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"notification-service/internal/models"
+	"notification-service/internal/router"
+	"notification-service/internal/telemetry"
+)
+
+// EmitDemoMetric records an ad-hoc counter, up/down counter, gauge or histogram value
+// through the service Meter so presenters can chart arbitrary KPIs in Azure Monitor
+func EmitDemoMetric(c *router.Context) {
+	var req models.DemoMetricRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+
+	err := telemetry.RecordDemoMetric(c.Request.Context(), req.Type, req.Name, req.Unit, req.Description, req.Value, req.Attributes)
+	switch {
+	case errors.Is(err, telemetry.ErrInvalidDemoMetric):
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	case errors.Is(err, telemetry.ErrDemoMetricLimit):
+		c.JSON(http.StatusTooManyRequests, router.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, router.H{"metric": req, "instrument": telemetry.DemoMetricName(req.Name)})
+}
+
+// GenerateDemoTrace synthesizes a multi-span trace with fake dependencies under the
+// request span, so Application Map and end-to-end transaction views can be shown on demand
+func GenerateDemoTrace(c *router.Context) {
+	req := models.DemoTraceRequest{Depth: 3, FanOut: 2, SpanLatencyMs: 20}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+
+	result, err := telemetry.GenerateDemoTrace(c.Request.Context(), telemetry.DemoTraceOptions{
+		Depth:            req.Depth,
+		FanOut:           req.FanOut,
+		SpanLatency:      time.Duration(req.SpanLatencyMs) * time.Millisecond,
+		ErrorProbability: req.ErrorProbability,
+		Dependencies:     req.Dependencies,
+		Seed:             req.Seed,
+	})
+	if errors.Is(err, telemetry.ErrInvalidDemoTrace) {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, router.H{"trace": result})
+}
+
+// GenerateDemoLogs emits structured log records at the requested severities and rate
+// through the OTel log pipeline. Emission continues after the 202 response.
+func GenerateDemoLogs(c *router.Context) {
+	req := models.DemoLogRequest{Count: 100, RatePerSecond: 10}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+
+	duration, err := telemetry.EmitDemoLogs(c.Request.Context(), telemetry.DemoLogOptions{
+		Count:         req.Count,
+		RatePerSecond: req.RatePerSecond,
+		Severities:    req.Severities,
+		Message:       req.Message,
+		Attributes:    req.Attributes,
+	})
+	if errors.Is(err, telemetry.ErrInvalidDemoLogs) {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, router.H{"logs": req, "estimated_duration": duration.String()})
+}
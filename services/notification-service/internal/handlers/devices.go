@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+
+	"notification-service/internal/middleware"
+	"notification-service/internal/models"
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+)
+
+// DeviceHandler serves the registry of customers' push devices
+type DeviceHandler struct {
+	devices services.DeviceManager
+}
+
+func NewDeviceHandler(devices services.DeviceManager) *DeviceHandler {
+	return &DeviceHandler{devices: devices}
+}
+
+// RegisterDevice adds a device or refreshes its token, app version, locale and last seen
+// time; apps call it on every launch
+func (h *DeviceHandler) RegisterDevice(c *router.Context) {
+	if !authorizeCustomer(c, c.Param("customerId")) {
+		return
+	}
+	var req models.RegisterDeviceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+
+	device, created, err := h.devices.Register(c.Request.Context(), c.Param("customerId"), c.Param("deviceId"), req)
+	if err != nil {
+		deviceError(c, err)
+		return
+	}
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, router.H{"device": device})
+}
+
+// ListDevices returns a customer's devices, most recently seen first; ?platform= keeps
+// one platform
+func (h *DeviceHandler) ListDevices(c *router.Context) {
+	if !authorizeCustomer(c, c.Param("customerId")) {
+		return
+	}
+	platform := models.DevicePlatform(c.Query("platform"))
+	if platform != "" && !slices.Contains(models.DevicePlatforms, platform) {
+		c.JSON(http.StatusBadRequest, router.H{"error": "platform must be ios, android or web"})
+		return
+	}
+
+	devices, err := h.devices.List(c.Request.Context(), c.Param("customerId"), platform)
+	if err != nil {
+		deviceError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"devices": devices, "count": len(devices)})
+}
+
+// DeleteDevice unregisters a device, as when the customer signs out of the app
+func (h *DeviceHandler) DeleteDevice(c *router.Context) {
+	if !authorizeCustomer(c, c.Param("customerId")) {
+		return
+	}
+	if err := h.devices.Delete(c.Request.Context(), c.Param("customerId"), c.Param("deviceId")); err != nil {
+		deviceError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// authorizeCustomer answers 403 and returns false when the caller's token belongs to a
+// customer other than customerID. Admins and tokens without a customer, used by
+// internal services, may act for any customer.
+func authorizeCustomer(c *router.Context, customerID string) bool {
+	identity, ok := middleware.IdentityFromContext(c)
+	if !ok || identity.CustomerID == "" || identity.CustomerID == customerID || slices.Contains(identity.Roles, AdminRole) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, router.H{"error": "token does not grant access to customer " + customerID})
+	return false
+}
+
+func deviceError(c *router.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrDeviceNotFound):
+		c.JSON(http.StatusNotFound, router.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net/http"
+
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+)
+
+// DigestHandler exposes operational digests to admins
+type DigestHandler struct {
+	digestService services.DigestManager
+}
+
+func NewDigestHandler(digestService services.DigestManager) *DigestHandler {
+	return &DigestHandler{digestService: digestService}
+}
+
+// PreviewDigest compiles a digest without sending it
+func (h *DigestHandler) PreviewDigest(c *router.Context) {
+	period, ok := digestPeriod(c)
+	if !ok {
+		return
+	}
+
+	digest, err := h.digestService.Compile(c.Request.Context(), period)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"digest": digest, "text": digest.Text()})
+}
+
+// SendDigest compiles a digest and sends it to the configured admin recipients now
+func (h *DigestHandler) SendDigest(c *router.Context) {
+	period, ok := digestPeriod(c)
+	if !ok {
+		return
+	}
+
+	digest, err := h.digestService.Send(c.Request.Context(), period)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"digest": digest})
+}
+
+func digestPeriod(c *router.Context) (services.DigestPeriod, bool) {
+	period := services.DigestPeriod(c.DefaultQuery("period", string(services.DigestDaily)))
+	if period != services.DigestDaily && period != services.DigestWeekly {
+		c.JSON(http.StatusBadRequest, router.H{"error": "period must be daily or weekly"})
+		return "", false
+	}
+	return period, true
+}
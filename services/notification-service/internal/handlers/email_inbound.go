@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"notification-service/internal/models"
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+)
+
+// maxInboundEmailMemory is how much of a multipart inbound email is held in memory;
+// attachments beyond it spill to temporary files
+const maxInboundEmailMemory = 8 << 20
+
+// EmailInboundHandler receives the emails customers send back to notifications, posted
+// by SendGrid Inbound Parse or as JSON by another relay, and lists a notification's replies
+type EmailInboundHandler struct {
+	replies services.EmailReplyManager
+	token   string
+}
+
+// NewEmailInboundHandler creates the handler; every inbound email must carry the token as
+// the token query parameter or the basic auth password, and none is accepted without one
+func NewEmailInboundHandler(replies services.EmailReplyManager, token string) *EmailInboundHandler {
+	return &EmailInboundHandler{replies: replies, token: token}
+}
+
+// ReceiveEmail correlates an inbound email with the notification it replies to. Emails
+// matching no notification are acknowledged too, so the relay doesn't retry them.
+func (h *EmailInboundHandler) ReceiveEmail(c *router.Context) {
+	ctx := c.Request.Context()
+	if h.token == "" || !h.authorized(c) {
+		slog.WarnContext(ctx, "⚠️ Rejected inbound email with invalid token")
+		c.JSON(http.StatusUnauthorized, router.H{"error": "invalid token"})
+		return
+	}
+
+	var email models.InboundEmail
+	if c.ContentType() == router.MIMEMultipartPOSTForm {
+		if err := c.Request.ParseMultipartForm(maxInboundEmailMemory); err != nil {
+			c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := c.ShouldBind(&email); err != nil {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.replies.HandleInbound(ctx, email)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidInboundEmail) {
+			c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+			return
+		}
+		slog.ErrorContext(ctx, "Failed to process inbound email", "error", err)
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// GetReplies lists the replies to a notification, oldest first
+func (h *EmailInboundHandler) GetReplies(c *router.Context) {
+	replies, err := h.replies.Replies(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"notification_id": c.Param("id"), "replies": replies})
+}
+
+func (h *EmailInboundHandler) authorized(c *router.Context) bool {
+	token := c.Query("token")
+	if _, password, ok := c.Request.BasicAuth(); ok {
+		token = password
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) == 1
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"notification-service/internal/models"
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+	"notification-service/internal/storage"
+)
+
+// EngagementHandler records opens, clicks, acks, reads and snoozes and serves the raw
+// event stream and its rollups
+type EngagementHandler struct {
+	engagementService services.EngagementRecorder
+}
+
+func NewEngagementHandler(engagementService services.EngagementRecorder) *EngagementHandler {
+	return &EngagementHandler{engagementService: engagementService}
+}
+
+// RecordEngagementEvent appends one event to the engagement stream
+func (h *EngagementHandler) RecordEngagementEvent(c *router.Context) {
+	var req models.RecordEngagementEventRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+
+	event, err := h.engagementService.Record(c.Request.Context(), req)
+	if err != nil {
+		engagementError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, router.H{"event": event})
+}
+
+// GetEngagementEvents lists raw events oldest first, filtered by customer_id,
+// notification_id, type and an occurred_at window (from inclusive, to exclusive).
+// Follow next_cursor to export the full stream.
+func (h *EngagementHandler) GetEngagementEvents(c *router.Context) {
+	filter, ok := engagementFilter(c)
+	if !ok {
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit < 1 || limit > 1000 {
+		c.JSON(http.StatusBadRequest, router.H{"error": "limit must be between 1 and 1000"})
+		return
+	}
+	filter.Cursor = c.Query("cursor")
+	filter.Limit = limit
+
+	events, next, err := h.engagementService.Events(c.Request.Context(), filter)
+	if err != nil {
+		engagementError(c, err)
+		return
+	}
+	if events == nil {
+		events = []*models.EngagementEvent{}
+	}
+	c.JSON(http.StatusOK, router.H{"events": events, "next_cursor": next})
+}
+
+// GetEngagementMetrics rolls engagement events up per type and reports the open rate,
+// click-through rate and per-template engagement of the notifications sent on channel
+// (email by default); the window defaults to the last 24 hours
+func (h *EngagementHandler) GetEngagementMetrics(c *router.Context) {
+	filter, ok := engagementFilter(c)
+	if !ok {
+		return
+	}
+	if filter.To.IsZero() {
+		filter.To = time.Now().UTC()
+	}
+	if filter.From.IsZero() {
+		filter.From = filter.To.Add(-24 * time.Hour)
+	}
+	channel := models.NotificationType(c.DefaultQuery("channel", string(models.NotificationTypeEmail)))
+	switch channel {
+	case models.NotificationTypeEmail, models.NotificationTypeSMS, models.NotificationTypePush,
+		models.NotificationTypeWebSocket, models.NotificationTypeWebhook:
+	default:
+		c.JSON(http.StatusBadRequest, router.H{"error": "channel must be email, sms, push, websocket or webhook"})
+		return
+	}
+
+	metrics, err := h.engagementService.Metrics(c.Request.Context(), filter, channel)
+	if err != nil {
+		engagementError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"metrics": metrics})
+}
+
+// engagementFilter parses the shared query filters, answering 400 itself when they're invalid
+func engagementFilter(c *router.Context) (storage.EngagementFilter, bool) {
+	filter := storage.EngagementFilter{
+		CustomerID:     c.Query("customer_id"),
+		NotificationID: c.Query("notification_id"),
+		Type:           models.EngagementEventType(c.Query("type")),
+	}
+	for name, target := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, router.H{"error": name + " must be an RFC 3339 timestamp"})
+			return filter, false
+		}
+		*target = parsed
+	}
+	return filter, true
+}
+
+func engagementError(c *router.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidEngagementEvent), errors.Is(err, storage.ErrInvalidCursor):
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+	case errors.Is(err, services.ErrStorageUnavailable):
+		c.JSON(http.StatusServiceUnavailable, router.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"notification-service/internal/faults"
+	"notification-service/internal/models"
+	"notification-service/internal/router"
+)
+
+// GetFaultRules lists the active internal fault points
+func GetFaultRules(c *router.Context) {
+	c.JSON(http.StatusOK, router.H{"enabled": faults.Enabled(), "dry_run": faults.DryRun(), "rules": faults.Rules()})
+}
+
+// SetFaultRules replaces the internal fault points
+func SetFaultRules(c *router.Context) {
+	var rules []faults.Rule
+	if err := c.ShouldBindJSON(&rules); err != nil {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+
+	if err := faults.SetRules(rules); err != nil {
+		if errors.Is(err, faults.ErrInvalidRule) {
+			c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"enabled": faults.Enabled(), "dry_run": faults.DryRun(), "rules": faults.Rules()})
+}
+
+// GetFaultDryRun reports, for the current dry run or the last one, how often each
+// operation was evaluated and which faults would have fired
+func GetFaultDryRun(c *router.Context) {
+	c.JSON(http.StatusOK, faults.Report())
+}
+
+// SetFaultDryRun switches dry-run mode; switching it on starts a fresh report
+func SetFaultDryRun(c *router.Context) {
+	var req models.FaultDryRunRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+
+	faults.SetDryRun(*req.Enabled)
+	c.JSON(http.StatusOK, faults.Report())
+}
+
+// ClearFaultRules removes all internal fault points
+func ClearFaultRules(c *router.Context) {
+	_ = faults.SetRules(nil)
+	c.Status(http.StatusNoContent)
+}
@@ -2,263 +2,613 @@ package handlers
 
 import (
 	"context"
-	"fmt"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
+	"notification-service/internal/middleware"
 	"notification-service/internal/models"
+	"notification-service/internal/pipeline"
+	"notification-service/internal/router"
 	"notification-service/internal/services"
+	"notification-service/internal/storage"
 	"notification-service/internal/telemetry"
+	"strconv"
 	"time"
 
-	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
 )
 
+// NotificationHandler depends only on service interfaces, so handler logic can be
+// exercised with the mocks in internal/mocks instead of real Redis/Event Hub
 type NotificationHandler struct {
-	notificationService *services.NotificationService
-	emailService        *services.EmailService
-	smsService          *services.SMSService
-	pushService         *services.PushNotificationService
-	webhookService      *services.WebhookService
-	wsHub               *models.Hub
+	notificationService services.NotificationManager
+	emailService        services.ChannelSender
+	smsService          services.ChannelSender
+	pushService         services.ChannelSender
+	webhookService      services.ChannelSender
+	wsHub               services.RealtimeHub
+	templateService     services.TemplateManager
+	presenceService     services.PresenceTracker
+	routing             services.RoutingPolicy
+	fallbacks           services.FallbackScheduler
+	coalescer           *services.Coalescer
+	screening           services.ContentScreener
+	sendTime            services.SendTimeScheduler
+	deadLetters         services.DeadLetterManager
+	retries             services.RetryScheduler
+	preferences         services.PreferenceEnforcer
+	idempotency         services.IdempotencyGuard
+	duplicates          services.DuplicateGuard
+	devices             services.DeviceManager
+	recipients          services.RecipientValidator
+	blackouts           services.BlackoutManager
+	digests             services.CustomerDigestManager
+	bulkWorkers         int
+	pipeline            *pipeline.Pipeline
 }
 
 func NewNotificationHandler(
-	notificationService *services.NotificationService,
-	emailService *services.EmailService,
-	smsService *services.SMSService,
-	pushService *services.PushNotificationService,
-	webhookService *services.WebhookService,
-	wsHub *models.Hub,
+	notificationService services.NotificationManager,
+	emailService services.ChannelSender,
+	smsService services.ChannelSender,
+	pushService services.ChannelSender,
+	webhookService services.ChannelSender,
+	wsHub services.RealtimeHub,
+	templateService services.TemplateManager,
+	presenceService services.PresenceTracker,
+	routing services.RoutingPolicy,
+	fallbacks services.FallbackScheduler,
+	coalescer *services.Coalescer,
+	screening services.ContentScreener,
+	sendTime services.SendTimeScheduler,
+	deadLetters services.DeadLetterManager,
+	retries services.RetryScheduler,
+	preferences services.PreferenceEnforcer,
+	idempotency services.IdempotencyGuard,
+	duplicates services.DuplicateGuard,
+	devices services.DeviceManager,
+	recipients services.RecipientValidator,
+	blackouts services.BlackoutManager,
+	digests services.CustomerDigestManager,
+	bulkWorkers int,
 ) *NotificationHandler {
-	return &NotificationHandler{
+	h := &NotificationHandler{
 		notificationService: notificationService,
 		emailService:        emailService,
 		smsService:          smsService,
 		pushService:         pushService,
 		webhookService:      webhookService,
 		wsHub:               wsHub,
+		templateService:     templateService,
+		presenceService:     presenceService,
+		routing:             routing,
+		fallbacks:           fallbacks,
+		coalescer:           coalescer,
+		screening:           screening,
+		sendTime:            sendTime,
+		deadLetters:         deadLetters,
+		retries:             retries,
+		preferences:         preferences,
+		idempotency:         idempotency,
+		duplicates:          duplicates,
+		devices:             devices,
+		recipients:          recipients,
+		blackouts:           blackouts,
+		digests:             digests,
+		bulkWorkers:         max(bulkWorkers, 1),
 	}
+	h.pipeline = h.newEventPipeline()
+	return h
 }
 
-func (h *NotificationHandler) CreateNotification(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "CreateNotification - not implemented"})
-}
+// A retried create carries the Idempotency-Key of the original request; the response to
+// a retry is marked as a replay
+const (
+	IdempotencyKeyHeader     = "Idempotency-Key"
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+)
 
-func (h *NotificationHandler) GetNotifications(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"notifications": []interface{}{}})
-}
+func (h *NotificationHandler) CreateNotification(c *router.Context) {
+	var req models.CreateNotificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+	if key := c.GetHeader(IdempotencyKeyHeader); key != "" {
+		if req.IdempotencyKey != "" && req.IdempotencyKey != key {
+			c.JSON(http.StatusBadRequest, router.H{"error": "Idempotency-Key header and idempotency_key field differ"})
+			return
+		}
+		req.IdempotencyKey = key
+		if err := router.Validate(&req); err != nil {
+			c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+			return
+		}
+	}
 
-func (h *NotificationHandler) GetNotification(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"notification": nil})
-}
+	notification, buffered, replayed, err := h.Submit(c.Request.Context(), req)
+	if err != nil {
+		notificationError(c, err)
+		return
+	}
+
+	// A retry gets the notification its key created, which isn't sent again
+	if replayed {
+		c.Header(IdempotentReplayedHeader, "true")
+		c.JSON(http.StatusOK, router.H{"notification": notification, "replayed": true})
+		return
+	}
+
+	c.Set(middleware.UsageNotificationsKey, 1)
+
+	// A blocked notification is stored with its screening decision but never sent
+	if notification.Status == models.NotificationStatusBlocked {
+		c.JSON(http.StatusCreated, router.H{"notification": notification, "blocked": true, "buffered": buffered})
+		return
+	}
+	// Likewise a notification the customer's preferences rule out
+	if notification.Status == models.NotificationStatusSuppressed {
+		c.JSON(http.StatusCreated, router.H{"notification": notification, "suppressed": true, "buffered": buffered})
+		return
+	}
+	// And one repeating a notification sent within the dedupe window
+	if notification.Status == models.NotificationStatusDuplicateSuppressed {
+		c.JSON(http.StatusCreated, router.H{"notification": notification, "duplicate_suppressed": true, "duplicate_of": notification.DuplicateOf, "buffered": buffered})
+		return
+	}
+	// A low-priority one held for the customer's digest goes out with it later
+	if notification.Status == models.NotificationStatusBatched {
+		c.JSON(http.StatusCreated, router.H{"notification": notification, "batched": true, "digest_id": notification.DigestID, "buffered": buffered})
+		return
+	}
 
-func (h *NotificationHandler) UpdateNotificationStatus(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "UpdateNotificationStatus - not implemented"})
+	// A buffered write is accepted but not yet durable in Redis
+	if buffered {
+		c.JSON(http.StatusAccepted, router.H{"notification": notification, "buffered": true})
+		return
+	}
+	c.JSON(http.StatusCreated, router.H{"notification": notification})
 }
 
-func (h *NotificationHandler) DeleteNotification(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "DeleteNotification - not implemented"})
+// Submit validates, renders, screens and schedules a new notification, batching a
+// low-priority one into its customer's digest, then stores it.
+// buffered reports a write accepted but not yet durable in Redis. With an idempotency
+// key already claimed, it returns the notification the key created with replayed set
+// instead. The request must already have passed its binding validation. The gRPC API
+// creates notifications through it too.
+func (h *NotificationHandler) Submit(ctx context.Context, req models.CreateNotificationRequest) (*models.Notification, bool, bool, error) {
+	return h.submit(ctx, req, newNotification(req))
 }
 
-func (h *NotificationHandler) CreateTemplate(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "CreateTemplate - not implemented"})
+// submit creates notification, built from req by newNotification
+func (h *NotificationHandler) submit(ctx context.Context, req models.CreateNotificationRequest, notification *models.Notification) (_ *models.Notification, buffered bool, replayed bool, err error) {
+	notification.MaxRetries = h.retries.MaxRetries(notification.Priority)
+	if err := h.recipients.Normalize(notification); err != nil {
+		return nil, false, false, err
+	}
+
+	if req.IdempotencyKey != "" {
+		id := notification.ID
+		original, claimErr := h.claimIdempotencyKey(ctx, req, id)
+		if claimErr != nil || original != nil {
+			return original, false, original != nil, claimErr
+		}
+		defer func() {
+			if err != nil {
+				h.releaseIdempotencyKey(ctx, req, id)
+			}
+		}()
+	}
+
+	if err := h.templateService.ValidateNotification(ctx, notification); err != nil {
+		return nil, false, false, err
+	}
+	if err := h.templateService.RenderNotification(ctx, notification); err != nil {
+		return nil, false, false, err
+	}
+	if err := services.ValidatePush(notification); err != nil {
+		return nil, false, false, err
+	}
+	if err := services.ValidateCollapseKey(notification); err != nil {
+		return nil, false, false, err
+	}
+	if err := services.ValidateCard(notification); err != nil {
+		return nil, false, false, err
+	}
+	if err := services.ValidateTarget(notification); err != nil {
+		return nil, false, false, err
+	}
+	if notification.Target != nil {
+		if _, err := h.devices.Resolve(ctx, notification.CustomerID, notification.Target); err != nil {
+			return nil, false, false, err
+		}
+	}
+	if req.ScheduledLocal {
+		if err := h.preferences.ScheduleLocal(ctx, notification); err != nil {
+			return nil, false, false, err
+		}
+	}
+	h.screenNotification(ctx, notification)
+	h.sendTime.Schedule(ctx, notification, req.OptimizeSendTime)
+	h.applyPreferences(ctx, notification)
+	claimed := h.suppressDuplicate(ctx, notification, req.Dedupe)
+	h.digests.Hold(ctx, notification, req.Digest)
+
+	buffered, err = h.notificationService.SaveNotification(ctx, notification)
+	if err != nil {
+		if claimed {
+			h.releaseDuplicate(ctx, notification)
+		}
+		return nil, false, false, err
+	}
+	if req.IdempotencyKey != "" {
+		h.completeIdempotencyKey(ctx, req, notification.ID)
+	}
+	h.sendTime.Record(ctx, notification)
+	h.pushReplacement(ctx, notification)
+	return notification, buffered, false, nil
 }
 
-func (h *NotificationHandler) GetTemplates(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"templates": []interface{}{}})
+// pushReplacement tells the customer's WebSocket clients that a websocket notification
+// replaced an earlier one through its collapse key, so they swap it in place
+func (h *NotificationHandler) pushReplacement(ctx context.Context, notification *models.Notification) {
+	if notification.Replaces == "" || notification.Type != models.NotificationTypeWebSocket {
+		return
+	}
+	err := h.wsHub.SendToCustomer(ctx, notification.CustomerID, map[string]interface{}{
+		"id":           notification.ID,
+		"type":         "replacement",
+		"replaces":     notification.Replaces,
+		"collapse_key": notification.CollapseKey,
+		"subject":      notification.Subject,
+		"message":      notification.Message,
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to push notification replacement", "notification.id", notification.ID, "notification.replaces", notification.Replaces, "error", err)
+	}
 }
 
-func (h *NotificationHandler) GetTemplate(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"template": nil})
+// claimIdempotencyKey claims req's key for notificationID, returning the notification an
+// earlier request with the key created, if any. A key whose notification was deleted is
+// claimed afresh.
+func (h *NotificationHandler) claimIdempotencyKey(ctx context.Context, req models.CreateNotificationRequest, notificationID string) (*models.Notification, error) {
+	span := trace.SpanFromContext(ctx)
+	originalID, claimed, err := h.idempotency.Claim(ctx, req, notificationID)
+	if err != nil || claimed {
+		return nil, err
+	}
+
+	original, err := h.notificationService.GetNotification(ctx, originalID)
+	if errors.Is(err, services.ErrNotificationNotFound) {
+		if err := h.idempotency.Release(ctx, req, originalID); err != nil {
+			return nil, err
+		}
+		if originalID, claimed, err = h.idempotency.Claim(ctx, req, notificationID); err != nil || claimed {
+			return nil, err
+		}
+		return nil, services.ErrIdempotencyInProgress
+	}
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.Bool("idempotency.replayed", true), attribute.String("notification.id", original.ID))
+	telemetry.RecordIdempotentReplay(ctx, string(req.Type))
+	return original, nil
 }
 
-func (h *NotificationHandler) UpdateTemplate(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "UpdateTemplate - not implemented"})
+// completeIdempotencyKey holds the key of a saved notification for the window. A key
+// that can't be held lapses after a minute, after which a retry creates the
+// notification again.
+func (h *NotificationHandler) completeIdempotencyKey(ctx context.Context, req models.CreateNotificationRequest, notificationID string) {
+	if err := h.idempotency.Complete(context.WithoutCancel(ctx), req, notificationID); err != nil {
+		slog.WarnContext(ctx, "Failed to hold idempotency key", "idempotency_key", req.IdempotencyKey, "error", err)
+	}
 }
 
-func (h *NotificationHandler) DeleteTemplate(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "DeleteTemplate - not implemented"})
+// releaseIdempotencyKey frees the key of a request that failed, so its retry isn't
+// answered as in progress until the window ends
+func (h *NotificationHandler) releaseIdempotencyKey(ctx context.Context, req models.CreateNotificationRequest, notificationID string) {
+	if err := h.idempotency.Release(context.WithoutCancel(ctx), req, notificationID); err != nil {
+		slog.WarnContext(ctx, "Failed to release idempotency key", "idempotency_key", req.IdempotencyKey, "error", err)
+	}
 }
 
-func (h *NotificationHandler) SendBulkNotifications(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "SendBulkNotifications - not implemented"})
+// newNotification builds a pending notification from a create request
+func newNotification(req models.CreateNotificationRequest) *models.Notification {
+	priority := req.Priority
+	if priority == "" {
+		priority = models.PriorityNormal
+	}
+	metadata := req.Metadata
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+
+	return &models.Notification{
+		ID:          services.NewID(),
+		Type:        req.Type,
+		Recipient:   req.Recipient,
+		Subject:     req.Subject,
+		Message:     req.Message,
+		Data:        req.Data,
+		Status:      models.NotificationStatusPending,
+		Priority:    priority,
+		TemplateID:  req.TemplateID,
+		CustomerID:  req.CustomerID,
+		OrderID:     req.OrderID,
+		CreatedAt:   time.Now().UTC(),
+		ScheduledAt: req.ScheduledAt,
+		MaxRetries:  3,
+		Metadata:    metadata,
+		Version:     1,
+		HTMLMessage: req.HTMLMessage,
+		Attachments: req.Attachments,
+		Push:        req.Push,
+		Card:        req.Card,
+		CollapseKey: req.CollapseKey,
+		Target:      req.Target,
+
+		TemplateVersion: req.TemplateVersion,
+		ConversationID:  req.ConversationID,
+	}
 }
 
-func (h *NotificationHandler) BroadcastNotification(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "BroadcastNotification - not implemented"})
+// GetNotifications lists notifications newest first. metadata.<key> filters use the
+// metadata indexes of the tenant_id tenant, or the default tenant; otherwise customer_id, status and type filter the database listing,
+// which leaves out notifications replaced through a collapse key unless include_replaced
+// is true, and counts every match when include_total is true.
+func (h *NotificationHandler) GetNotifications(c *router.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 500 {
+		c.JSON(http.StatusBadRequest, router.H{"error": "limit must be between 1 and 500"})
+		return
+	}
+
+	if filters := metadataFilters(c); len(filters) > 0 {
+		notifications, err := h.notificationService.FindNotificationsByMetadata(c.Request.Context(), c.DefaultQuery("tenant_id", metadataFilterDefaultTenant), filters, limit)
+		if err != nil {
+			if errors.Is(err, services.ErrMetadataKeyNotIndexed) {
+				c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, router.H{"notifications": notifications})
+		return
+	}
+
+	filter := storage.NotificationFilter{
+		CustomerID: c.Query("customer_id"),
+		Status:     models.NotificationStatus(c.Query("status")),
+		Type:       models.NotificationType(c.Query("type")),
+		OrderID:    c.Query("order_id"),
+		Priority:   models.Priority(c.Query("priority")),
+		Cursor:     c.Query("cursor"),
+		Limit:      limit,
+
+		ExcludeReplaced: c.Query("include_replaced") != "true",
+	}
+	for param, bound := range map[string]*time.Time{"created_after": &filter.CreatedAfter, "created_before": &filter.CreatedBefore} {
+		if value := c.Query(param); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, router.H{"error": param + " must be an RFC 3339 timestamp"})
+				return
+			}
+			*bound = parsed
+		}
+	}
+	switch c.DefaultQuery("sort", "desc") {
+	case "desc":
+	case "asc":
+		filter.Ascending = true
+	default:
+		c.JSON(http.StatusBadRequest, router.H{"error": "sort must be asc or desc"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	notifications, next, err := h.notificationService.ListNotifications(ctx, filter)
+	if err != nil {
+		notificationError(c, err)
+		return
+	}
+	if notifications == nil {
+		notifications = []*models.Notification{}
+	}
+	response := router.H{
+		"notifications": notifications,
+		"next_cursor":   next,
+		"has_more":      next != "",
+	}
+	// Counting scans every matching row, in every region, so it is left to callers who ask
+	if c.Query("include_total") == "true" {
+		total, err := h.notificationService.CountNotifications(ctx, filter)
+		if err != nil {
+			notificationError(c, err)
+			return
+		}
+		response["total"] = total
+	}
+	c.JSON(http.StatusOK, response)
 }
 
-func (h *NotificationHandler) GetCustomerPreferences(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"preferences": nil})
+func (h *NotificationHandler) GetNotification(c *router.Context) {
+	notification, err := h.notificationService.GetNotification(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		notificationError(c, err)
+		return
+	}
+
+	c.Header("ETag", notificationETag(notification))
+	c.JSON(http.StatusOK, router.H{"notification": notification})
 }
 
-func (h *NotificationHandler) UpdateCustomerPreferences(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"message": "UpdateCustomerPreferences - not implemented"})
+func (h *NotificationHandler) UpdateNotificationStatus(c *router.Context) {
+	var req models.UpdateNotificationStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+
+	notification, err := h.notificationService.UpdateNotificationStatus(c.Request.Context(), c.Param("id"), req)
+	if err != nil {
+		notificationError(c, err)
+		return
+	}
+
+	c.Header("ETag", notificationETag(notification))
+	c.JSON(http.StatusOK, router.H{"notification": notification})
 }
 
-func (h *NotificationHandler) GetDeliveryStats(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"stats": nil})
+func (h *NotificationHandler) DeleteNotification(c *router.Context) {
+	if err := h.notificationService.DeleteNotification(c.Request.Context(), c.Param("id")); err != nil {
+		notificationError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
 }
 
-func (h *NotificationHandler) GetEngagementMetrics(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"metrics": nil})
+func (h *NotificationHandler) GetEventHubFailoverStatus(c *router.Context) {
+	c.JSON(http.StatusOK, router.H{"namespaces": h.notificationService.EventHubFailoverStatus()})
 }
 
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true
 	},
+	// Echoed back to browsers that send their token as a subprotocol
+	Subprotocols: []string{middleware.WebSocketTokenProtocol},
 }
 
-func (h *NotificationHandler) HandleWebSocket(c *gin.Context) {
+// HandleWebSocket upgrades a connection for ?customerId= and serves it until it closes.
+// Reconnecting clients pass resumeToken (and optionally lastMessageId) to have missed
+// messages replayed. Authenticated callers may only connect as their own customer.
+func (h *NotificationHandler) HandleWebSocket(c *router.Context) {
+	customerID := c.Query("customerId")
+	if identity, ok := middleware.IdentityFromContext(c); ok {
+		if customerID == "" {
+			customerID = identity.CustomerID
+		}
+		if customerID != identity.CustomerID {
+			c.JSON(http.StatusForbidden, router.H{"error": "token does not grant access to customer " + customerID})
+			return
+		}
+	}
+	if customerID == "" {
+		c.JSON(http.StatusBadRequest, router.H{"error": "customerId query parameter is required"})
+		return
+	}
+
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
 		return
 	}
-	// Handle WebSocket connection
-	defer conn.Close()
+	h.wsHub.Serve(c.Request.Context(), conn, customerID, c.Request.UserAgent(), c.ClientIP(), models.ResumeRequest{
+		Token:         c.Query("resumeToken"),
+		LastMessageID: c.Query("lastMessageId"),
+	})
 }
 
-func (h *NotificationHandler) ProcessEventHubMessage(message []byte) error {
+// ProcessEventHubMessage handles one order event. ctx comes from the Event Hub consumer
+// and carries its eventhub.receive span, so processing and delivery spans nest under it.
+func (h *NotificationHandler) ProcessEventHubMessage(ctx context.Context, message []byte) error {
 	start := time.Now()
-	ctx := context.Background()
-	
+	partitionID := services.PartitionIDFromContext(ctx)
+
 	// Create a span for event processing
 	ctx, span := telemetry.Tracer.Start(ctx, "ProcessEventHubMessage",
-		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithSpanKind(trace.SpanKindInternal),
 		trace.WithAttributes(
 			attribute.Int("message.size", len(message)),
+			attribute.String("partition.id", partitionID),
 		),
 	)
 	defer span.End()
 
-	// Parse the order event
-	event, err := services.ParseOrderEvent(message)
+	// Run the event through decode → validate → enrich → transform → dispatch
+	msg, err := h.pipeline.Execute(ctx, message)
 	if err != nil {
 		span.RecordError(err)
-		span.SetStatus(codes.Error, "Failed to parse event")
-		log.Printf("Failed to parse event: %v", err)
-		
-		// Record error metric
-		telemetry.RecordEventHubMessage(ctx, "unknown", "unknown", false, time.Since(start).Seconds())
-		return err
-	}
+		span.SetStatus(codes.Error, "Failed to process event")
+		slog.ErrorContext(ctx, "Failed to process event", "partition.id", partitionID, "error", err)
 
-	// Add event details to span
-	span.SetAttributes(
-		attribute.String("event.type", event.EventType),
-		attribute.Int("order.id", event.OrderID),
-		attribute.String("customer.id", event.CustomerID),
-		attribute.Float64("order.total_amount", event.TotalAmount),
-	)
-
-	log.Printf("Processing %s event for Order ID: %d, Customer ID: %s", 
-		event.EventType, event.OrderID, event.CustomerID)
-
-	// Create notification based on event type
-	notification := models.WebSocketMessage{
-		Type:      "notification",
-		Timestamp: time.Now(),
-	}
-
-	switch event.EventType {
-	case "OrderCreated":
-		notification.Data = map[string]interface{}{
-			"type":        "order_created",
-			"orderId":     event.OrderID,
-			"customerId":  event.CustomerID,
-			"subject":     "Order Confirmed",
-			"message":     fmt.Sprintf("Your order #%d has been confirmed! Total: $%.2f", event.OrderID, event.TotalAmount),
-			"totalAmount": event.TotalAmount,
-			"productId":   event.ProductID,
-			"quantity":    event.Quantity,
-			"timestamp":   event.Timestamp,
-		}
-
-	case "OrderStatusUpdated":
-		notification.Data = map[string]interface{}{
-			"type":       "order_status_updated",
-			"orderId":    event.OrderID,
-			"customerId": event.CustomerID,
-			"subject":    "Order Status Update",
-			"message":    fmt.Sprintf("Order #%d status: %s", event.OrderID, event.Status),
-			"status":     event.Status,
-			"timestamp":  event.Timestamp,
-		}
-
-	case "PaymentProcessed":
-		notification.Data = map[string]interface{}{
-			"type":        "payment_processed",
-			"orderId":     event.OrderID,
-			"customerId":  event.CustomerID,
-			"subject":     "Payment Processed",
-			"message":     fmt.Sprintf("Payment of $%.2f processed for order #%d", event.TotalAmount, event.OrderID),
-			"totalAmount": event.TotalAmount,
-			"timestamp":   event.Timestamp,
+		eventType := "unknown"
+		if msg.Event != nil {
+			eventType = msg.Event.EventType
 		}
 
-	default:
-		log.Printf("Unknown event type: %s", event.EventType)
-		notification.Data = map[string]interface{}{
-			"type":       "order_event",
-			"orderId":    event.OrderID,
-			"customerId": event.CustomerID,
-			"subject":    "Order Update",
-			"message":    fmt.Sprintf("Update for order #%d", event.OrderID),
-			"eventType":  event.EventType,
-			"timestamp":  event.Timestamp,
-		}
+		// Record error metric
+		telemetry.RecordEventHubMessage(ctx, partitionID, eventType, false, time.Since(start).Seconds())
+		return err
 	}
 
-	// Send notification via WebSocket with telemetry
-	wsStart := time.Now()
-	err = h.wsHub.SendToCustomer(event.CustomerID, notification)
-	wsDuration := time.Since(wsStart).Seconds()
-	
-	if err != nil {
-		span.RecordError(err)
-		span.SetStatus(codes.Error, "Failed to send WebSocket notification")
-		log.Printf("Failed to send WebSocket notification: %v", err)
-		
-		// Record WebSocket error metric
-		telemetry.RecordWebSocketMessage(ctx, event.CustomerID, event.EventType, false, wsDuration)
-		telemetry.RecordNotificationError(ctx, event.EventType, "websocket", err.Error())
-		
-		// Don't return error - WebSocket failure shouldn't fail event processing
-	} else {
-		log.Printf("Sent %s notification to customer %s via WebSocket", 
-			event.EventType, event.CustomerID)
-		
-		// Record successful WebSocket delivery
-		telemetry.RecordWebSocketMessage(ctx, event.CustomerID, event.EventType, true, wsDuration)
-		telemetry.RecordNotificationSent(ctx, event.EventType, "websocket")
-	}
+	slog.InfoContext(ctx, "Processed event",
+		"event.type", msg.Event.EventType, "order.id", msg.Event.OrderID, "customer.id", msg.Event.CustomerID)
 
 	// Record successful event processing
 	duration := time.Since(start).Seconds()
-	telemetry.RecordEventHubMessage(ctx, "unknown-partition", event.EventType, true, duration)
-	
+	telemetry.RecordEventHubMessage(ctx, partitionID, msg.Event.EventType, true, duration)
+
 	span.SetStatus(codes.Ok, "Event processed successfully")
 	return nil
 }
 
-func HealthCheck(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": "healthy"})
+func HealthCheck(c *router.Context) {
+	c.JSON(http.StatusOK, router.H{"status": "healthy"})
+}
+
+// ReadinessCheck reports not-ready with 503 while Redis, the database or the Event Hub
+// consumer is down, or the database schema is newer than this binary, keeping the
+// replica out of rotation. Checks lists each dependency's status.
+func ReadinessCheck(readiness services.ReadinessProber, info models.ServiceInfo) router.HandlerFunc {
+	started := time.Now()
+	return func(c *router.Context) {
+		report := readiness.Check(c.Request.Context())
+		response := models.HealthResponse{
+			Status:    "ready",
+			Timestamp: report.CheckedAt,
+			Service:   info.Service,
+			Version:   info.Version,
+			Uptime:    time.Since(started).Round(time.Second).String(),
+			Checks:    make(map[string]interface{}, len(report.Checks)),
+		}
+		for name, check := range report.Checks {
+			response.Checks[name] = check
+		}
+
+		if !report.Ready {
+			response.Status = "not ready"
+			c.JSON(http.StatusServiceUnavailable, response)
+			return
+		}
+		c.JSON(http.StatusOK, response)
+	}
 }
 
-func ReadinessCheck(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": "ready"})
+func LivenessCheck(c *router.Context) {
+	c.JSON(http.StatusOK, router.H{"status": "live"})
 }
 
-func LivenessCheck(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": "live"})
+// InfoHandler describes this instance, including the addresses it listens on
+func InfoHandler(info models.ServiceInfo) router.HandlerFunc {
+	return func(c *router.Context) {
+		c.JSON(http.StatusOK, info)
+	}
 }
 
-func MetricsHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"metrics": "prometheus metrics"})
+// MetricsHandler serves every OTel instrument in the Prometheus format for scraping,
+// alongside the OTLP export
+func MetricsHandler(c *router.Context) {
+	handler, err := telemetry.PrometheusHandler()
+	if err != nil {
+		c.JSON(http.StatusNotFound, router.H{"error": err.Error()})
+		return
+	}
+	handler.ServeHTTP(c.Writer, c.Request)
 }
@@ -0,0 +1,255 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"notification-service/internal/handlers"
+	"notification-service/internal/mocks"
+	"notification-service/internal/models"
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+	"notification-service/internal/storage"
+)
+
+// newNotificationRoutes serves the notification endpoints of a handler backed by manager;
+// the services they don't reach are left nil
+func newNotificationRoutes(t *testing.T, manager *mocks.NotificationManager) http.Handler {
+	t.Helper()
+	h := handlers.NewNotificationHandler(manager, &mocks.ChannelSender{}, &mocks.ChannelSender{}, &mocks.ChannelSender{}, &mocks.ChannelSender{}, &mocks.RealtimeHub{},
+		nil, nil, services.RoutingPolicy{}, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, nil, 1)
+
+	routes, err := router.New(router.ModeStdlib, "notification-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes.GET("/notifications", h.GetNotifications)
+	routes.GET("/notifications/:id", h.GetNotification)
+	routes.PUT("/notifications/:id/status", h.UpdateNotificationStatus)
+	routes.DELETE("/notifications/:id", h.DeleteNotification)
+	routes.GET("/admin/eventhub/failover", h.GetEventHubFailoverStatus)
+	return routes.Handler()
+}
+
+func serve(handler http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestGetNotification(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode int
+		wantETag string
+	}{
+		{name: "found", wantCode: http.StatusOK, wantETag: `"3"`},
+		{name: "not found", err: services.ErrNotificationNotFound, wantCode: http.StatusNotFound},
+		{name: "storage unavailable", err: services.ErrStorageUnavailable, wantCode: http.StatusServiceUnavailable},
+		{name: "region unavailable", err: storage.ErrRegionUnavailable, wantCode: http.StatusServiceUnavailable},
+		{name: "unexpected error", err: errors.New("boom"), wantCode: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := &mocks.NotificationManager{
+				GetNotificationFunc: func(_ context.Context, id string) (*models.Notification, error) {
+					if id != "n-1" {
+						t.Errorf("id = %q, want n-1", id)
+					}
+					if tt.err != nil {
+						return nil, tt.err
+					}
+					return &models.Notification{ID: id, Version: 3}, nil
+				},
+			}
+
+			response := serve(newNotificationRoutes(t, manager), http.MethodGet, "/notifications/n-1", "")
+			if response.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", response.Code, tt.wantCode, response.Body)
+			}
+			if etag := response.Header().Get("ETag"); etag != tt.wantETag {
+				t.Errorf("ETag = %q, want %q", etag, tt.wantETag)
+			}
+		})
+	}
+}
+
+func TestGetNotificationsPaging(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		next       string
+		err        error
+		wantCode   int
+		wantFilter storage.NotificationFilter
+		wantTotal  bool
+	}{
+		{
+			name:       "first page",
+			query:      "customer_id=c-1&limit=2",
+			next:       "cursor-2",
+			wantCode:   http.StatusOK,
+			wantFilter: storage.NotificationFilter{CustomerID: "c-1", Limit: 2, ExcludeReplaced: true},
+		},
+		{
+			name:       "next page ascending with total",
+			query:      "cursor=cursor-2&sort=asc&include_total=true&include_replaced=true",
+			wantCode:   http.StatusOK,
+			wantFilter: storage.NotificationFilter{Cursor: "cursor-2", Limit: 50, Ascending: true},
+			wantTotal:  true,
+		},
+		{name: "malformed cursor", query: "cursor=bad", err: storage.ErrInvalidCursor, wantCode: http.StatusBadRequest},
+		{name: "limit out of range", query: "limit=501", wantCode: http.StatusBadRequest},
+		{name: "unknown sort", query: "sort=sideways", wantCode: http.StatusBadRequest},
+		{name: "malformed timestamp", query: "created_after=yesterday", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := &mocks.NotificationManager{
+				ListNotificationsFunc: func(_ context.Context, filter storage.NotificationFilter) ([]*models.Notification, string, error) {
+					if tt.err != nil {
+						return nil, "", tt.err
+					}
+					if filter != tt.wantFilter {
+						t.Errorf("filter = %+v, want %+v", filter, tt.wantFilter)
+					}
+					return []*models.Notification{{ID: "n-1"}}, tt.next, nil
+				},
+				CountNotificationsFunc: func(context.Context, storage.NotificationFilter) (int64, error) {
+					return 7, nil
+				},
+			}
+
+			response := serve(newNotificationRoutes(t, manager), http.MethodGet, "/notifications?"+tt.query, "")
+			if response.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", response.Code, tt.wantCode, response.Body)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var page struct {
+				Notifications []models.Notification `json:"notifications"`
+				NextCursor    string                `json:"next_cursor"`
+				HasMore       bool                  `json:"has_more"`
+				Total         *int64                `json:"total"`
+			}
+			if err := json.Unmarshal(response.Body.Bytes(), &page); err != nil {
+				t.Fatal(err)
+			}
+			if page.NextCursor != tt.next || page.HasMore != (tt.next != "") {
+				t.Errorf("next_cursor = %q, has_more = %t, want %q", page.NextCursor, page.HasMore, tt.next)
+			}
+			if (page.Total != nil) != tt.wantTotal {
+				t.Errorf("total = %v, want it returned: %t", page.Total, tt.wantTotal)
+			}
+		})
+	}
+}
+
+func TestGetNotificationsByMetadata(t *testing.T) {
+	manager := &mocks.NotificationManager{
+		FindByMetadataFunc: func(_ context.Context, tenantID string, filters map[string]string, _ int) ([]*models.Notification, error) {
+			if tenantID != "acme" || filters["region"] != "emea" {
+				t.Errorf("tenant = %q, filters = %v", tenantID, filters)
+			}
+			return nil, services.ErrMetadataKeyNotIndexed
+		},
+	}
+
+	response := serve(newNotificationRoutes(t, manager), http.MethodGet, "/notifications?tenant_id=acme&metadata.region=emea", "")
+	if response.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400 for an unindexed key", response.Code)
+	}
+}
+
+func TestUpdateNotificationStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		err      error
+		wantCode int
+	}{
+		{name: "updated", body: `{"status":"delivered"}`, wantCode: http.StatusOK},
+		{name: "missing status", body: `{}`, wantCode: http.StatusBadRequest},
+		{name: "invalid transition", body: `{"status":"pending"}`, err: services.ErrInvalidStatusTransition, wantCode: http.StatusConflict},
+		{name: "not found", body: `{"status":"delivered"}`, err: services.ErrNotificationNotFound, wantCode: http.StatusNotFound},
+		{name: "write buffer full", body: `{"status":"delivered"}`, err: services.ErrBufferFull, wantCode: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manager := &mocks.NotificationManager{
+				UpdateStatusFunc: func(_ context.Context, id string, req models.UpdateNotificationStatusRequest) (*models.Notification, error) {
+					if tt.err != nil {
+						return nil, tt.err
+					}
+					return &models.Notification{ID: id, Status: req.Status, Version: 2}, nil
+				},
+			}
+
+			response := serve(newNotificationRoutes(t, manager), http.MethodPut, "/notifications/n-1/status", tt.body)
+			if response.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d: %s", response.Code, tt.wantCode, response.Body)
+			}
+		})
+	}
+}
+
+func TestDeleteNotification(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode int
+	}{
+		{name: "deleted", wantCode: http.StatusNoContent},
+		{name: "not found", err: services.ErrNotificationNotFound, wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deleted := ""
+			manager := &mocks.NotificationManager{
+				DeleteNotificationFunc: func(_ context.Context, id string) error {
+					deleted = id
+					return tt.err
+				},
+			}
+
+			response := serve(newNotificationRoutes(t, manager), http.MethodDelete, "/notifications/n-1", "")
+			if response.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d", response.Code, tt.wantCode)
+			}
+			if deleted != "n-1" {
+				t.Errorf("deleted %q, want n-1", deleted)
+			}
+		})
+	}
+}
+
+func TestGetEventHubFailoverStatus(t *testing.T) {
+	manager := &mocks.NotificationManager{
+		EventHubFailoverStatusFunc: func() []services.FailoverStatus {
+			return []services.FailoverStatus{{Name: "consumer", Active: services.NamespacePrimary, FailureThreshold: 5}}
+		},
+	}
+
+	response := serve(newNotificationRoutes(t, manager), http.MethodGet, "/admin/eventhub/failover", "")
+	var body struct {
+		Namespaces []services.FailoverStatus `json:"namespaces"`
+	}
+	if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if response.Code != http.StatusOK || len(body.Namespaces) != 1 || body.Namespaces[0].Name != "consumer" {
+		t.Errorf("status = %d, namespaces = %+v", response.Code, body.Namespaces)
+	}
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+)
+
+// metadataFilterPrefix marks query parameters that filter on notification metadata
+const metadataFilterPrefix = "metadata."
+
+// metadataFilterDefaultTenant is the tenant metadata filters search without tenant_id,
+// the one notifications without tenant_id metadata are indexed under
+const metadataFilterDefaultTenant = "default"
+
+// MetadataIndexHandler lets admins choose which notification metadata keys are indexed
+// for each tenant
+type MetadataIndexHandler struct {
+	metadataIndex services.MetadataIndexManager
+}
+
+func NewMetadataIndexHandler(metadataIndex services.MetadataIndexManager) *MetadataIndexHandler {
+	return &MetadataIndexHandler{metadataIndex: metadataIndex}
+}
+
+func (h *MetadataIndexHandler) GetIndexedKeys(c *router.Context) {
+	keys, err := h.metadataIndex.IndexedKeys(c.Request.Context(), c.Param("tenantId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"keys": keys})
+}
+
+func (h *MetadataIndexHandler) RegisterIndexedKey(c *router.Context) {
+	var req struct {
+		Key string `json:"key" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+
+	keys, err := h.metadataIndex.RegisterKey(c.Request.Context(), c.Param("tenantId"), req.Key)
+	if err != nil {
+		metadataIndexError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"keys": keys})
+}
+
+func (h *MetadataIndexHandler) UnregisterIndexedKey(c *router.Context) {
+	keys, err := h.metadataIndex.UnregisterKey(c.Request.Context(), c.Param("tenantId"), c.Param("key"))
+	if err != nil {
+		metadataIndexError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"keys": keys})
+}
+
+func metadataIndexError(c *router.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidMetadataKey):
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+	case errors.Is(err, services.ErrMetadataKeyNotIndexed):
+		c.JSON(http.StatusNotFound, router.H{"error": err.Error()})
+	case errors.Is(err, services.ErrMetadataIndexLimit):
+		c.JSON(http.StatusConflict, router.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+	}
+}
+
+// metadataFilters collects metadata.<key>=<value> query parameters
+func metadataFilters(c *router.Context) map[string]string {
+	filters := make(map[string]string)
+	for param, values := range c.Request.URL.Query() {
+		if key, ok := strings.CutPrefix(param, metadataFilterPrefix); ok && len(values) > 0 {
+			filters[key] = values[0]
+		}
+	}
+	return filters
+}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"notification-service/internal/middleware"
+	"notification-service/internal/models"
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+	"notification-service/internal/storage"
+)
+
+// PatchNotification edits a pending scheduled notification before it is sent. The
+// If-Match header must carry the ETag from the last read, so concurrent edits cannot
+// silently overwrite each other.
+func (h *NotificationHandler) PatchNotification(c *router.Context) {
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch == "" {
+		c.JSON(http.StatusPreconditionRequired, router.H{"error": "If-Match header with the notification ETag is required"})
+		return
+	}
+	version, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, router.H{"error": "If-Match must be a notification ETag such as \"3\""})
+		return
+	}
+
+	var patch models.NotificationPatchRequest
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	id := c.Param("id")
+
+	// Edited data must still satisfy the template's schema
+	if patch.Data != nil {
+		current, err := h.notificationService.GetNotification(ctx, id)
+		if err != nil {
+			notificationError(c, err)
+			return
+		}
+		candidate := *current
+		candidate.Data = patch.Data
+		if err := h.templateService.ValidateNotification(ctx, &candidate); err != nil {
+			notificationError(c, err)
+			return
+		}
+	}
+
+	notification, err := h.notificationService.UpdateNotification(ctx, id, version, patch, c.GetHeader(middleware.UserIDHeader))
+	if err != nil {
+		notificationError(c, err)
+		return
+	}
+
+	c.Header("ETag", notificationETag(notification))
+	c.JSON(http.StatusOK, router.H{"notification": notification})
+}
+
+// GetNotificationEdits returns the pre-send edit history of a notification
+func (h *NotificationHandler) GetNotificationEdits(c *router.Context) {
+	edits, err := h.notificationService.NotificationEdits(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		notificationError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"edits": edits})
+}
+
+func notificationETag(notification *models.Notification) string {
+	return `"` + strconv.Itoa(notification.Version) + `"`
+}
+
+func notificationError(c *router.Context, err error) {
+	var schemaErr *services.SchemaValidationError
+	var renderErr *services.TemplateRenderError
+	var recipientErr *services.RecipientError
+	switch {
+	case errors.Is(err, services.ErrNotificationNotFound):
+		c.JSON(http.StatusNotFound, router.H{"error": err.Error()})
+	case errors.Is(err, services.ErrVersionMismatch):
+		c.JSON(http.StatusPreconditionFailed, router.H{"error": err.Error()})
+	case errors.Is(err, services.ErrNotificationNotEditable), errors.Is(err, services.ErrInvalidStatusTransition), errors.Is(err, services.ErrNotificationNotResendable):
+		c.JSON(http.StatusConflict, router.H{"error": err.Error()})
+	case errors.Is(err, storage.ErrInvalidCursor), errors.Is(err, services.ErrInvalidPushContent), errors.Is(err, services.ErrInvalidCollapseKey), errors.Is(err, services.ErrInvalidCard), errors.Is(err, services.ErrInvalidTarget), errors.Is(err, services.ErrInvalidLocalSchedule),
+		errors.Is(err, services.ErrInvalidSchedule):
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+	case errors.Is(err, services.ErrNoTargetDevices):
+		c.JSON(http.StatusUnprocessableEntity, router.H{"error": err.Error()})
+	case errors.Is(err, services.ErrStorageUnavailable), errors.Is(err, services.ErrBufferFull), errors.Is(err, storage.ErrRegionUnavailable),
+		errors.Is(err, services.ErrIdempotencyUnavailable):
+		c.JSON(http.StatusServiceUnavailable, router.H{"error": err.Error()})
+	case errors.As(err, &schemaErr):
+		c.JSON(http.StatusUnprocessableEntity, router.H{"error": "notification does not match template schema", "template_id": schemaErr.TemplateID, "violations": schemaErr.Violations})
+	case errors.As(err, &renderErr):
+		c.JSON(http.StatusUnprocessableEntity, router.H{"error": renderErr.Error(), "template_id": renderErr.TemplateID, "missing_variables": renderErr.MissingVariables})
+	case errors.As(err, &recipientErr):
+		c.JSON(http.StatusUnprocessableEntity, router.H{"error": recipientErr.Error(), "recipient": recipientErr.Recipient, "reason": recipientErr.Reason, "hint": recipientErr.Hint})
+	case errors.Is(err, services.ErrTemplateNotFound), errors.Is(err, services.ErrTemplateInactive), errors.Is(err, services.ErrTemplateVersionNotFound),
+		errors.Is(err, services.ErrTemplateVersionNotPublished):
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+	case errors.Is(err, services.ErrIdempotencyKeyReused):
+		c.JSON(http.StatusUnprocessableEntity, router.H{"error": err.Error()})
+	case errors.Is(err, services.ErrIdempotencyInProgress):
+		c.JSON(http.StatusConflict, router.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+	}
+}
+
+// CancelNotification cancels a notification that has not been sent. The response says
+// whether the cancellation won; 409 means delivery got there first.
+func (h *NotificationHandler) CancelNotification(c *router.Context) {
+	result, err := h.notificationService.CancelNotification(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		notificationError(c, err)
+		return
+	}
+
+	if !result.Cancelled {
+		c.JSON(http.StatusConflict, router.H{"result": result})
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"result": result})
+}
+
+// CancelOrderNotifications cancels every unsent notification for an order
+func (h *NotificationHandler) CancelOrderNotifications(c *router.Context) {
+	var req models.BulkCancelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+
+	results, err := h.notificationService.CancelOrderNotifications(c.Request.Context(), req.OrderID)
+	if err != nil {
+		notificationError(c, err)
+		return
+	}
+
+	cancelled := 0
+	for _, result := range results {
+		if result.Cancelled {
+			cancelled++
+		}
+	}
+	c.JSON(http.StatusOK, router.H{"order_id": req.OrderID, "cancelled": cancelled, "results": results})
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"notification-service/internal/models"
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+)
+
+// PayloadLoggingHandler lets admins switch HTTP payload logging on for an incident and
+// off again
+type PayloadLoggingHandler struct {
+	payloadLogs services.PayloadLogManager
+}
+
+func NewPayloadLoggingHandler(payloadLogs services.PayloadLogManager) *PayloadLoggingHandler {
+	return &PayloadLoggingHandler{payloadLogs: payloadLogs}
+}
+
+func (h *PayloadLoggingHandler) GetPayloadLogging(c *router.Context) {
+	c.JSON(http.StatusOK, router.H{"settings": h.payloadLogs.Settings(c.Request.Context())})
+}
+
+// SetPayloadLogging overrides the payload logging settings on every replica until the
+// override's TTL runs out
+func (h *PayloadLoggingHandler) SetPayloadLogging(c *router.Context) {
+	var settings models.PayloadLogSettings
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+
+	settings, err := h.payloadLogs.SetSettings(c.Request.Context(), settings)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidPayloadLogSettings) {
+			c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"settings": settings})
+}
+
+// ResetPayloadLogging drops the override so the configured settings apply again
+func (h *PayloadLoggingHandler) ResetPayloadLogging(c *router.Context) {
+	if err := h.payloadLogs.ResetSettings(c.Request.Context()); err != nil {
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"notification-service/internal/faults"
+	"notification-service/internal/models"
+	"notification-service/internal/pipeline"
+	"notification-service/internal/services"
+	"notification-service/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// newEventPipeline registers the built-in stages for inbound order events.
+// Enrichment middleware is registered separately through Use.
+func (h *NotificationHandler) newEventPipeline() *pipeline.Pipeline {
+	p := pipeline.New()
+	p.Use(pipeline.StageDecode, decodeOrderEvent)
+	p.Use(pipeline.StageValidate, validateOrderEvent)
+	p.Use(pipeline.StageEnrich, h.enrichTenant)
+	p.Use(pipeline.StageTransform, transformOrderEvent, h.screenOrderNotification, h.applyOrderPreferences)
+	p.Use(pipeline.StageDispatch, h.dispatchWebSocket)
+	return p
+}
+
+// Use registers additional middleware on the inbound event pipeline,
+// e.g. enrichment such as currency conversion or customer-tier lookup
+func (h *NotificationHandler) Use(stage pipeline.Stage, middleware ...pipeline.Middleware) {
+	h.pipeline.Use(stage, middleware...)
+}
+
+func decodeOrderEvent(next pipeline.Handler) pipeline.Handler {
+	return func(ctx context.Context, msg *pipeline.Message) error {
+		event, err := services.ParseOrderEvent(msg.Raw)
+		if err != nil {
+			return err
+		}
+		msg.Event = event
+
+		trace.SpanFromContext(ctx).SetAttributes(
+			attribute.String("event.type", event.EventType),
+			attribute.Int("order.id", event.OrderID),
+			attribute.String("customer.id", event.CustomerID),
+			attribute.Float64("order.total_amount", event.TotalAmount),
+		)
+		return next(ctx, msg)
+	}
+}
+
+func validateOrderEvent(next pipeline.Handler) pipeline.Handler {
+	return func(ctx context.Context, msg *pipeline.Message) error {
+		if msg.Event.EventType == "" {
+			return fmt.Errorf("event is missing EventType")
+		}
+		if msg.Event.CustomerID == "" {
+			return fmt.Errorf("%s event for order %d is missing CustomerId", msg.Event.EventType, msg.Event.OrderID)
+		}
+		return next(ctx, msg)
+	}
+}
+
+// enrichTenant places the event in the tenant the customer was imported under, unless
+// an earlier enrichment stage already did. A customer without preferences, or whose
+// preferences can't be read, stays outside any tenant.
+func (h *NotificationHandler) enrichTenant(next pipeline.Handler) pipeline.Handler {
+	return func(ctx context.Context, msg *pipeline.Message) error {
+		if tenantID, _ := msg.Enrichment[services.TenantIDEnrichment].(string); tenantID != "" {
+			return next(ctx, msg)
+		}
+		preferences, err := h.preferences.Preferences(ctx, msg.Event.CustomerID)
+		switch {
+		case err == nil && preferences.TenantID != "":
+			msg.Enrichment[services.TenantIDEnrichment] = preferences.TenantID
+			trace.SpanFromContext(ctx).SetAttributes(attribute.String("tenant.id", preferences.TenantID))
+		case err != nil && !errors.Is(err, services.ErrPreferencesNotFound):
+			slog.WarnContext(ctx, "Failed to look up customer tenant", "customer.id", msg.Event.CustomerID, "error", err)
+		}
+		return next(ctx, msg)
+	}
+}
+
+func transformOrderEvent(next pipeline.Handler) pipeline.Handler {
+	return func(ctx context.Context, msg *pipeline.Message) error {
+		event := msg.Event
+
+		if err := faults.Inject(ctx, faults.OpTemplateRender); err != nil {
+			return err
+		}
+
+		// Create notification based on event type
+		notification := &models.WebSocketMessage{
+			Type:      "notification",
+			Timestamp: time.Now(),
+		}
+
+		var data map[string]interface{}
+		switch event.EventType {
+		case "OrderCreated":
+			data = map[string]interface{}{
+				"type":        "order_created",
+				"orderId":     event.OrderID,
+				"customerId":  event.CustomerID,
+				"subject":     "Order Confirmed",
+				"message":     fmt.Sprintf("Your order #%d has been confirmed! Total: $%.2f", event.OrderID, event.TotalAmount),
+				"totalAmount": event.TotalAmount,
+				"productId":   event.ProductID,
+				"quantity":    event.Quantity,
+				"timestamp":   event.Timestamp,
+			}
+
+		case "OrderStatusUpdated":
+			data = map[string]interface{}{
+				"type":       "order_status_updated",
+				"orderId":    event.OrderID,
+				"customerId": event.CustomerID,
+				"subject":    "Order Status Update",
+				"message":    fmt.Sprintf("Order #%d status: %s", event.OrderID, event.Status),
+				"status":     event.Status,
+				"timestamp":  event.Timestamp,
+			}
+
+		case "PaymentProcessed":
+			data = map[string]interface{}{
+				"type":        "payment_processed",
+				"orderId":     event.OrderID,
+				"customerId":  event.CustomerID,
+				"subject":     "Payment Processed",
+				"message":     fmt.Sprintf("Payment of $%.2f processed for order #%d", event.TotalAmount, event.OrderID),
+				"totalAmount": event.TotalAmount,
+				"timestamp":   event.Timestamp,
+			}
+
+		default:
+			slog.WarnContext(ctx, "Unknown event type", "event.type", event.EventType)
+			data = map[string]interface{}{
+				"type":       "order_event",
+				"orderId":    event.OrderID,
+				"customerId": event.CustomerID,
+				"subject":    "Order Update",
+				"message":    fmt.Sprintf("Update for order #%d", event.OrderID),
+				"eventType":  event.EventType,
+				"timestamp":  event.Timestamp,
+			}
+		}
+
+		// Enrichment stages contribute extra fields without overriding the core payload;
+		// the tenant is only used to dispatch
+		for key, value := range msg.Enrichment {
+			if key == services.TenantIDEnrichment || key == services.TenantTierEnrichment {
+				continue
+			}
+			if _, exists := data[key]; !exists {
+				data[key] = value
+			}
+		}
+
+		notification.Data = data
+		msg.Notification = notification
+		return next(ctx, msg)
+	}
+}
+
+// dispatchWebSocket delivers the transformed notification according to the routing
+// policy; by default straight to the customer's WebSocket clients. One due in a
+// blackout window of its tenant is held until the window ends. Provider deliveries are
+// queued fairly under the tenant enrichment set, or the customer when there is none.
+func (h *NotificationHandler) dispatchWebSocket(next pipeline.Handler) pipeline.Handler {
+	return func(ctx context.Context, msg *pipeline.Message) error {
+		tenantID, _ := msg.Enrichment[services.TenantIDEnrichment].(string)
+		tier, _ := msg.Enrichment[services.TenantTierEnrichment].(string)
+		if h.holdForBlackout(ctx, msg.Event, *msg.Notification, tenantID) {
+			return next(ctx, msg)
+		}
+		if tenantID == "" {
+			tenantID = msg.Event.CustomerID
+		}
+		ctx = services.WithTenant(ctx, tenantID, tier)
+
+		if h.routing.Name == services.RoutingOnlineElseFallback {
+			h.routeOnlineElseFallback(ctx, msg.Event, *msg.Notification)
+		} else {
+			h.deliverWebSocket(ctx, msg.Event, *msg.Notification)
+		}
+		return next(ctx, msg)
+	}
+}
+
+// holdForBlackout queues an order notification due in a blackout window of its tenant,
+// or the default tenant without one, to go out when the window ends as a fallback does:
+// over WebSocket if the customer is online then, else on the fallback channels. It
+// reports whether the notification was held; one that can't be queued is sent now.
+func (h *NotificationHandler) holdForBlackout(ctx context.Context, event *services.OrderEvent, notification models.WebSocketMessage, tenantID string) bool {
+	held := &models.Notification{
+		Type:       models.NotificationTypeWebSocket,
+		CustomerID: event.CustomerID,
+		Priority:   models.PriorityNormal,
+		Metadata:   map[string]interface{}{},
+	}
+	if tenantID != "" {
+		held.Metadata[services.TenantMetadata] = tenantID
+	}
+	until := h.blackouts.Hold(ctx, held, time.Now().UTC())
+	if until == nil {
+		return false
+	}
+	if err := h.fallbacks.Schedule(ctx, &services.FallbackJob{Event: event, Message: notification}, *until); err != nil {
+		slog.WarnContext(ctx, "Failed to hold order notification for blackout, sending it now", "customer.id", event.CustomerID, "error", err)
+		return false
+	}
+	h.blackouts.RecordDeferral(ctx, held)
+	return true
+}
+
+// deliverWebSocket sends through the coalescing window, which may hold the update and
+// send a merged one later. WebSocket failure shouldn't fail event processing.
+func (h *NotificationHandler) deliverWebSocket(ctx context.Context, event *services.OrderEvent, notification models.WebSocketMessage) {
+	held := h.coalescer.Submit(ctx, event.CustomerID, event.OrderID, event.EventType, notification,
+		func(ctx context.Context, message models.WebSocketMessage) {
+			_ = h.sendWebSocket(ctx, event, message)
+		})
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("websocket.coalesced", held))
+}
+
+// sendWebSocket sends a notification to the customer's WebSocket clients with telemetry
+// and lifecycle events
+func (h *NotificationHandler) sendWebSocket(ctx context.Context, event *services.OrderEvent, notification models.WebSocketMessage) error {
+	wsStart := time.Now()
+	err := faults.Inject(ctx, faults.OpWebSocketSend)
+	if err == nil {
+		err = h.wsHub.SendToCustomer(ctx, event.CustomerID, notification)
+	}
+	wsDuration := time.Since(wsStart).Seconds()
+
+	if err != nil {
+		span := trace.SpanFromContext(ctx)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Failed to send WebSocket notification")
+		slog.ErrorContext(ctx, "Failed to send WebSocket notification", "customer.id", event.CustomerID, "error", err)
+
+		// Record WebSocket error metric
+		telemetry.RecordWebSocketMessage(ctx, event.CustomerID, event.EventType, false, wsDuration)
+		telemetry.RecordNotificationError(ctx, event.EventType, "websocket", err.Error())
+
+		h.publishLifecycle(ctx, event, models.NotificationTypeWebSocket, "NotificationFailed", string(models.NotificationStatusFailed), err)
+		return err
+	}
+
+	slog.InfoContext(ctx, "Sent notification via WebSocket",
+		"event.type", event.EventType, "customer.id", event.CustomerID)
+
+	// Record successful WebSocket delivery
+	telemetry.RecordWebSocketMessage(ctx, event.CustomerID, event.EventType, true, wsDuration)
+	telemetry.RecordNotificationSent(ctx, event.EventType, "websocket")
+
+	h.publishLifecycle(ctx, event, models.NotificationTypeWebSocket, "NotificationSent", string(models.NotificationStatusSent), nil)
+	return nil
+}
+
+// publishLifecycle queues an outbound lifecycle event; publishing problems are logged, never fatal
+func (h *NotificationHandler) publishLifecycle(ctx context.Context, event *services.OrderEvent, channel models.NotificationType, eventType, status string, deliveryErr error) {
+	lifecycle := services.LifecycleEvent{
+		EventType:  eventType,
+		CustomerID: event.CustomerID,
+		OrderID:    event.OrderID,
+		Channel:    string(channel),
+		Status:     status,
+	}
+	if deliveryErr != nil {
+		lifecycle.Error = deliveryErr.Error()
+	}
+
+	if err := h.notificationService.PublishLifecycleEvent(ctx, lifecycle); err != nil {
+		slog.WarnContext(ctx, "Failed to publish lifecycle event", "event.type", eventType, "error", err)
+	}
+}
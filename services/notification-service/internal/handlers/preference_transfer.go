@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"notification-service/internal/middleware"
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+)
+
+// preferenceContentTypes are the media types of the bulk preferences formats
+var preferenceContentTypes = map[string]string{
+	services.PreferenceFormatCSV:   "text/csv",
+	services.PreferenceFormatJSONL: "application/x-ndjson",
+}
+
+// PreferenceTransferHandler imports and exports customer preferences in bulk, for
+// onboarding a tenant's customers at once
+type PreferenceTransferHandler struct {
+	transfer services.PreferenceTransfer
+}
+
+func NewPreferenceTransferHandler(transfer services.PreferenceTransfer) *PreferenceTransferHandler {
+	return &PreferenceTransferHandler{transfer: transfer}
+}
+
+// transferTenant is the tenant a bulk transfer is scoped to: the one the caller's token
+// names, or every tenant for operators whose token names none
+func transferTenant(c *router.Context) string {
+	if identity, ok := middleware.IdentityFromContext(c); ok {
+		return identity.TenantID
+	}
+	return ""
+}
+
+// ImportPreferences stores the preferences in a CSV or JSONL body, picked by the format
+// query parameter or else the Content-Type, for customers of the caller's tenant. Bad
+// rows don't fail the import: the report lists them by line. A body that can't be read
+// on answers 400 with the report so far.
+func (h *PreferenceTransferHandler) ImportPreferences(c *router.Context) {
+	format := c.Query("format")
+	if format == "" {
+		switch c.ContentType() {
+		case "text/csv":
+			format = services.PreferenceFormatCSV
+		case "application/x-ndjson", "application/jsonl", "application/x-jsonlines":
+			format = services.PreferenceFormatJSONL
+		}
+	}
+
+	report, err := h.transfer.ImportPreferences(c.Request.Context(), transferTenant(c), format, c.Request.Body)
+	switch {
+	case errors.Is(err, services.ErrUnknownPreferencesFormat):
+		c.JSON(http.StatusUnsupportedMediaType, router.H{"error": err.Error()})
+	case err != nil:
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error(), "report": report})
+	default:
+		c.JSON(http.StatusOK, router.H{"report": report})
+	}
+}
+
+// ExportPreferences streams the preferences of the caller's tenant's customers as JSONL,
+// or CSV with format=csv, in the form imports take
+func (h *PreferenceTransferHandler) ExportPreferences(c *router.Context) {
+	format := c.DefaultQuery("format", services.PreferenceFormatJSONL)
+	contentType, ok := preferenceContentTypes[format]
+	if !ok {
+		c.JSON(http.StatusBadRequest, router.H{"error": services.ErrUnknownPreferencesFormat.Error()})
+		return
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", `attachment; filename="preferences.`+format+`"`)
+	c.Status(http.StatusOK)
+	exported, err := h.transfer.ExportPreferences(c.Request.Context(), transferTenant(c), format, c.Writer)
+	if err != nil {
+		// The status is already sent; the client gets a short file
+		slog.ErrorContext(c.Request.Context(), "Preferences export stopped", "export.exported", exported, "error", err)
+	}
+}
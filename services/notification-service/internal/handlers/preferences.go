@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"notification-service/internal/models"
+	"notification-service/internal/pipeline"
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+	"notification-service/internal/telemetry"
+)
+
+func (h *NotificationHandler) GetCustomerPreferences(c *router.Context) {
+	preferences, err := h.preferences.Preferences(c.Request.Context(), c.Param("customerId"))
+	if err != nil {
+		preferencesError(c, err)
+		return
+	}
+	if notModified(c, preferences) {
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"preferences": preferences})
+}
+
+// UpdateCustomerPreferences replaces a customer's preferences; channel toggles left out
+// of the body are off. With If-Match, the update only applies when the preferences still
+// have that ETag.
+func (h *NotificationHandler) UpdateCustomerPreferences(c *router.Context) {
+	var preferences models.CustomerPreferences
+	if err := c.ShouldBindJSON(&preferences); err != nil {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+	preferences.CustomerID = c.Param("customerId")
+	// Customers are assigned to a tenant by its imports only
+	preferences.TenantID = ""
+
+	updated, err := h.preferences.SetPreferences(c.Request.Context(), &preferences, c.GetHeader("If-Match"))
+	if err != nil {
+		preferencesError(c, err)
+		return
+	}
+	c.Header("ETag", services.ETag(updated))
+	c.JSON(http.StatusOK, router.H{"preferences": updated})
+}
+
+func preferencesError(c *router.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrPreferencesNotFound):
+		c.JSON(http.StatusNotFound, router.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidPreferences):
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+	case errors.Is(err, services.ErrPreconditionFailed):
+		c.JSON(http.StatusPreconditionFailed, router.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+	}
+}
+
+// applyPreferences checks a new notification against its tenant's blackout calendar and
+// its customer's preferences at the time it is due. One due in a blackout window is
+// scheduled for when it ends, and checked against preferences then. A suppressed
+// notification is stored but never sent, and isn't counted as held by a blackout; one
+// due in quiet hours is scheduled for when they end.
+func (h *NotificationHandler) applyPreferences(ctx context.Context, notification *models.Notification) {
+	if notification.Status != models.NotificationStatusPending {
+		return
+	}
+	due := time.Now().UTC()
+	if notification.ScheduledAt != nil && notification.ScheduledAt.After(due) {
+		due = *notification.ScheduledAt
+	}
+	until := h.blackouts.Hold(ctx, notification, due)
+	if until != nil {
+		due = *until
+	}
+
+	decision := h.preferences.Check(ctx, notification, due)
+	if decision.Action == models.PreferenceActionSuppress {
+		notification.Status = models.NotificationStatusSuppressed
+		notification.ErrorMessage = "suppressed by customer preferences: " + decision.Reason
+		telemetry.RecordNotificationSuppressed(ctx, string(notification.Type), decision.Reason)
+		return
+	}
+	if until != nil {
+		h.blackouts.RecordDeferral(ctx, notification)
+	}
+	if decision.Action == models.PreferenceActionDefer {
+		notification.ScheduledAt = decision.Until
+		telemetry.RecordNotificationDeferred(ctx, string(notification.Type))
+	}
+}
+
+// applyOrderPreferences drops order notifications for customers who opted out of the
+// orders category. Quiet hours don't hold in-app WebSocket messages; the fallback
+// channels are checked as they are tried.
+func (h *NotificationHandler) applyOrderPreferences(next pipeline.Handler) pipeline.Handler {
+	return func(ctx context.Context, msg *pipeline.Message) error {
+		decision := h.preferences.Check(ctx, &models.Notification{
+			Type:       models.NotificationTypeWebSocket,
+			CustomerID: msg.Event.CustomerID,
+			Priority:   models.PriorityNormal,
+			Metadata:   map[string]interface{}{services.CategoryMetadata: services.OrderEventsCategory},
+		}, time.Now().UTC())
+		if decision.Action != models.PreferenceActionSuppress {
+			return next(ctx, msg)
+		}
+
+		slog.InfoContext(ctx, "Customer opted out, suppressed notification", "customer.id", msg.Event.CustomerID, "notification.category", services.OrderEventsCategory, "event.type", msg.Event.EventType)
+		telemetry.RecordNotificationSuppressed(ctx, string(models.NotificationTypeWebSocket), decision.Reason)
+		h.publishLifecycle(ctx, msg.Event, models.NotificationTypeWebSocket, "NotificationSuppressed", string(models.NotificationStatusSuppressed), nil)
+		return nil
+	}
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"net/http"
+
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+)
+
+// PresenceHandler exposes customers' WebSocket presence so other services can route work
+type PresenceHandler struct {
+	presenceService services.PresenceTracker
+}
+
+func NewPresenceHandler(presenceService services.PresenceTracker) *PresenceHandler {
+	return &PresenceHandler{presenceService: presenceService}
+}
+
+func (h *PresenceHandler) GetCustomerPresence(c *router.Context) {
+	presence, err := h.presenceService.Presence(c.Request.Context(), c.Param("customerId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"presence": presence})
+}
@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"net/http"
+
+	"notification-service/internal/models"
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+)
+
+// ProviderPayloadHandler serves the provider requests and responses captured for
+// sampled notifications
+type ProviderPayloadHandler struct {
+	payloads services.ProviderPayloadReader
+}
+
+func NewProviderPayloadHandler(payloads services.ProviderPayloadReader) *ProviderPayloadHandler {
+	return &ProviderPayloadHandler{payloads: payloads}
+}
+
+// GetProviderPayloads lists a notification's captured exchanges, oldest first; the list
+// is empty when the notification was not sampled or its captures have expired
+func (h *ProviderPayloadHandler) GetProviderPayloads(c *router.Context) {
+	exchanges, err := h.payloads.ProviderPayloads(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+	if exchanges == nil {
+		exchanges = []models.ProviderExchange{}
+	}
+	c.JSON(http.StatusOK, router.H{"notification_id": c.Param("id"), "exchanges": exchanges})
+}
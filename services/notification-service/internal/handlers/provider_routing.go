@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"net/http"
+
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+)
+
+// ProviderRoutingHandler reports how sends are spread across the providers of channels
+// that have more than one
+type ProviderRoutingHandler struct {
+	routing services.ProviderRoutingReporter
+}
+
+func NewProviderRoutingHandler(routing services.ProviderRoutingReporter) *ProviderRoutingHandler {
+	return &ProviderRoutingHandler{routing: routing}
+}
+
+// GetProviderRouting lists each routed provider with its health, circuit and weight
+func (h *ProviderRoutingHandler) GetProviderRouting(c *router.Context) {
+	c.JSON(http.StatusOK, router.H{"providers": h.routing.Status()})
+}
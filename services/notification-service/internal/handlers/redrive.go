@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"notification-service/internal/middleware"
+	"notification-service/internal/models"
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+)
+
+// RedriveHandler serves bulk re-drives of failed notifications and their confirmation
+type RedriveHandler struct {
+	redrives services.RedriveManager
+}
+
+func NewRedriveHandler(redrives services.RedriveManager) *RedriveHandler {
+	return &RedriveHandler{redrives: redrives}
+}
+
+// RedriveNotifications matches the failed notifications for a filter and answers 201
+// with a job awaiting confirmation; nothing is re-sent yet
+func (h *RedriveHandler) RedriveNotifications(c *router.Context) {
+	var req models.NotificationRedriveRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+
+	job, err := h.redrives.Submit(c.Request.Context(), req, c.GetHeader(middleware.UserIDHeader))
+	if err != nil {
+		redriveError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, router.H{"redrive": job})
+}
+
+func (h *RedriveHandler) GetRedrive(c *router.Context) {
+	job, err := h.redrives.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		redriveError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"redrive": job})
+}
+
+// ConfirmRedrive starts a job once the caller repeats its matched count. It answers
+// 202; GET /redrives/:id follows its progress.
+func (h *RedriveHandler) ConfirmRedrive(c *router.Context) {
+	var req models.RedriveConfirmRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+
+	job, err := h.redrives.Confirm(c.Request.Context(), c.Param("id"), c.GetHeader(middleware.UserIDHeader), *req.Matched)
+	if err != nil {
+		redriveError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, router.H{"redrive": job})
+}
+
+func redriveError(c *router.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidRedriveFilter):
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+	case errors.Is(err, services.ErrRedriveJobNotFound):
+		c.JSON(http.StatusNotFound, router.H{"error": err.Error()})
+	case errors.Is(err, services.ErrRedriveJobNotPending), errors.Is(err, services.ErrRedriveJobExpired), errors.Is(err, services.ErrRedriveCountMismatch):
+		c.JSON(http.StatusConflict, router.H{"error": err.Error()})
+	case errors.Is(err, services.ErrRedriveTooManyMatches):
+		c.JSON(http.StatusUnprocessableEntity, router.H{"error": err.Error()})
+	case errors.Is(err, services.ErrStorageUnavailable):
+		c.JSON(http.StatusServiceUnavailable, router.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+
+	"notification-service/internal/middleware"
+	"notification-service/internal/models"
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ResendNotification re-sends a sent, delivered or failed notification as a new one
+// linked to it by resend_of, to another channel or recipient when given, for example to
+// check a provider with a message that really went out. The copy is rendered, screened
+// and checked against preferences like any new notification, and sent now.
+func (h *NotificationHandler) ResendNotification(c *router.Context) {
+	var req models.ResendNotificationRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	original, err := h.notificationService.GetNotification(ctx, c.Param("id"))
+	if err != nil {
+		notificationError(c, err)
+		return
+	}
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.String("resend.of", original.ID))
+
+	switch original.Status {
+	case models.NotificationStatusSent, models.NotificationStatusDelivered, models.NotificationStatusFailed:
+	default:
+		notificationError(c, services.ErrNotificationNotResendable)
+		return
+	}
+	if req.Type != "" && req.Type != original.Type && req.Recipient == "" {
+		c.JSON(http.StatusBadRequest, router.H{"error": "recipient is required to re-send on another channel"})
+		return
+	}
+
+	create := resendRequest(original, req)
+	notification := newNotification(create)
+	notification.ResendOf = original.ID
+	notification, buffered, _, err := h.submit(ctx, create, notification)
+	if err != nil {
+		notificationError(c, err)
+		return
+	}
+
+	span.SetAttributes(attribute.String("notification.id", notification.ID))
+	slog.InfoContext(ctx, "🔁 Re-sending notification", "notification.resend_of", original.ID, "notification.id", notification.ID, "notification.channel", notification.Type)
+	c.Set(middleware.UsageNotificationsKey, 1)
+
+	if buffered {
+		c.JSON(http.StatusAccepted, router.H{"notification": notification, "resend_of": original.ID, "buffered": true})
+		return
+	}
+	c.JSON(http.StatusCreated, router.H{"notification": notification, "resend_of": original.ID})
+}
+
+// resendRequest is the create request for a copy of original, sent immediately
+func resendRequest(original *models.Notification, req models.ResendNotificationRequest) models.CreateNotificationRequest {
+	sendNow, dedupe := false, false
+	create := models.CreateNotificationRequest{
+		Type:             original.Type,
+		Recipient:        original.Recipient,
+		Subject:          original.Subject,
+		Message:          original.Message,
+		Data:             maps.Clone(original.Data),
+		Priority:         original.Priority,
+		TemplateID:       original.TemplateID,
+		TemplateVersion:  original.TemplateVersion,
+		CustomerID:       original.CustomerID,
+		OrderID:          original.OrderID,
+		Metadata:         maps.Clone(original.Metadata),
+		HTMLMessage:      original.HTMLMessage,
+		Attachments:      original.Attachments,
+		Push:             original.Push,
+		CollapseKey:      original.CollapseKey,
+		Target:           original.Target,
+		ConversationID:   services.ConversationID(original),
+		OptimizeSendTime: &sendNow,
+		// A re-send repeats its original on purpose
+		Dedupe: &dedupe,
+		Digest: &sendNow,
+	}
+	// A copy sent on another channel doesn't replace anything there
+	if req.Type != "" && req.Type != original.Type {
+		create.Type = req.Type
+		create.CollapseKey = ""
+		create.Target = nil
+	}
+	if req.Recipient != "" {
+		create.Recipient = req.Recipient
+		create.Target = nil
+	}
+	return create
+}
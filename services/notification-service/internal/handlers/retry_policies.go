@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"notification-service/internal/models"
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+)
+
+// RetryPolicyHandler lets admins inspect and override per-channel retry policies, see the
+// per-priority retry budgets, and see which channels are slowed down by provider throttling
+type RetryPolicyHandler struct {
+	retryPolicies services.RetryPolicyManager
+	throttles     services.ProviderThrottleReporter
+	retries       services.RetryScheduler
+}
+
+func NewRetryPolicyHandler(retryPolicies services.RetryPolicyManager, throttles services.ProviderThrottleReporter, retries services.RetryScheduler) *RetryPolicyHandler {
+	return &RetryPolicyHandler{retryPolicies: retryPolicies, throttles: throttles, retries: retries}
+}
+
+func (h *RetryPolicyHandler) GetRetryPolicies(c *router.Context) {
+	policies, err := h.retryPolicies.Policies(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"policies": policies, "priorities": h.retries.PriorityPolicies()})
+}
+
+// SetRetryPolicy replaces the policy for the channel in the path
+func (h *RetryPolicyHandler) SetRetryPolicy(c *router.Context) {
+	var policy models.RetryPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+	policy.Channel = models.NotificationType(c.Param("channel"))
+
+	policy, err := h.retryPolicies.SetPolicy(c.Request.Context(), policy)
+	if err != nil {
+		retryPolicyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"policy": policy})
+}
+
+// ResetRetryPolicy removes the channel's override so its configured policy applies again
+func (h *RetryPolicyHandler) ResetRetryPolicy(c *router.Context) {
+	if err := h.retryPolicies.ResetPolicy(c.Request.Context(), models.NotificationType(c.Param("channel"))); err != nil {
+		retryPolicyError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *RetryPolicyHandler) GetProviderThrottles(c *router.Context) {
+	throttles, err := h.throttles.Throttles(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"throttles": throttles})
+}
+
+func retryPolicyError(c *router.Context, err error) {
+	if errors.Is(err, services.ErrInvalidRetryPolicy) {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+}
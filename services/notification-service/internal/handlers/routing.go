@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"notification-service/internal/models"
+	"notification-service/internal/services"
+	"notification-service/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Routing outcomes recorded on dispatch spans and the routing metric
+const (
+	routingOutcomeWebSocket          = "websocket"
+	routingOutcomeDeferred           = "deferred"
+	routingOutcomeWebSocketAfterWait = "websocket_after_wait"
+	routingOutcomeFallbackFailed     = "fallback_failed"
+	routingOutcomeFallbackSuppressed = "fallback_suppressed"
+)
+
+// routeOnlineElseFallback sends over WebSocket when the customer is online. Otherwise the
+// notification is queued for a fallback after the wait, so event processing is not held
+// up for it. A fallback that can't be queued runs at once.
+func (h *NotificationHandler) routeOnlineElseFallback(ctx context.Context, event *services.OrderEvent, notification models.WebSocketMessage) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.String("routing.policy", h.routing.Name))
+
+	online := h.customerOnline(ctx, event.CustomerID)
+	span.SetAttributes(attribute.Bool("routing.customer_online", online))
+
+	if online {
+		h.deliverWebSocket(ctx, event, notification)
+		h.recordRoutingOutcome(ctx, span, routingOutcomeWebSocket)
+		return
+	}
+
+	span.SetAttributes(attribute.Int64("routing.fallback.wait_ms", h.routing.FallbackWait.Milliseconds()))
+	h.recordRoutingOutcome(ctx, span, routingOutcomeDeferred)
+	job := &services.FallbackJob{Event: event, Message: notification}
+	if err := h.fallbacks.Schedule(ctx, job, time.Now().Add(h.routing.FallbackWait)); err != nil {
+		slog.WarnContext(ctx, "Failed to queue fallback, falling back now", "customer.id", event.CustomerID, "error", err)
+		h.Fallback(ctx, job)
+	}
+}
+
+// Fallback runs a queued fallback once the customer has had the configured wait to come
+// online: it sends over WebSocket if they did, and otherwise tries the fallback channels
+// in order until one accepts the notification, at the customer's address on each in
+// their preferences. Channels the customer has no address on, or their preferences rule
+// out, including for quiet hours, are skipped: a fallback notification isn't stored, so
+// it can't be held until later.
+func (h *NotificationHandler) Fallback(ctx context.Context, job *services.FallbackJob) {
+	event, notification := job.Event, job.Message
+	ctx, span := telemetry.Tracer.Start(ctx, "notification.route.fallback",
+		trace.WithAttributes(
+			attribute.String("routing.policy", h.routing.Name),
+			attribute.String("customer.id", event.CustomerID),
+			attribute.Int("order.id", event.OrderID),
+		),
+	)
+	defer span.End()
+
+	online := h.customerOnline(ctx, event.CustomerID)
+	span.SetAttributes(attribute.Bool("routing.customer_online", online))
+	if online {
+		h.deliverWebSocket(ctx, event, notification)
+		h.recordRoutingOutcome(ctx, span, routingOutcomeWebSocketAfterWait)
+		return
+	}
+
+	fallback := fallbackNotification(event, notification)
+	var failures []error
+	attempts, suppressed := 0, 0
+	for _, channel := range h.routing.FallbackChannels {
+		sender := h.channelSender(channel)
+		if sender == nil {
+			continue
+		}
+		span.SetAttributes(attribute.String("routing.fallback.channel", string(channel)))
+
+		fallback.Type = channel
+		if err := h.preferences.Address(ctx, fallback); err != nil {
+			reason := models.SuppressionNoContact
+			if !errors.Is(err, services.ErrNoContact) {
+				failures = append(failures, err)
+				telemetry.RecordNotificationError(ctx, event.EventType, string(channel), err.Error())
+				continue
+			}
+			suppressed++
+			span.AddEvent("routing.fallback.suppressed", trace.WithAttributes(
+				attribute.String("routing.fallback.channel", string(channel)),
+				attribute.String("preferences.reason", reason),
+			))
+			telemetry.RecordNotificationSuppressed(ctx, string(channel), reason)
+			continue
+		}
+		if decision := h.preferences.Check(ctx, fallback, time.Now().UTC()); decision.Action != models.PreferenceActionSend {
+			suppressed++
+			span.AddEvent("routing.fallback.suppressed", trace.WithAttributes(
+				attribute.String("routing.fallback.channel", string(channel)),
+				attribute.String("preferences.reason", decision.Reason),
+			))
+			telemetry.RecordNotificationSuppressed(ctx, string(channel), decision.Reason)
+			continue
+		}
+		if err := sender.Send(ctx, fallback); err != nil {
+			failures = append(failures, err)
+			attempts += fallback.RetryCount + 1
+			span.AddEvent("routing.fallback.failed", trace.WithAttributes(
+				attribute.String("routing.fallback.channel", string(channel)),
+				attribute.String("error.message", err.Error()),
+			))
+			telemetry.RecordNotificationError(ctx, event.EventType, string(channel), err.Error())
+			h.publishLifecycle(ctx, event, channel, "NotificationFailed", string(models.NotificationStatusFailed), err)
+			continue
+		}
+
+		slog.InfoContext(ctx, "Customer offline, sent notification via fallback", "customer.id", event.CustomerID, "event.type", event.EventType, "notification.channel", channel)
+		telemetry.RecordNotificationSent(ctx, event.EventType, string(channel))
+		h.publishLifecycle(ctx, event, channel, "NotificationSent", string(models.NotificationStatusSent), nil)
+		h.recordRoutingOutcome(ctx, span, "fallback_"+string(channel))
+		return
+	}
+
+	if len(failures) == 0 && suppressed > 0 {
+		slog.InfoContext(ctx, "Customer offline and their preferences rule out every fallback channel", "customer.id", event.CustomerID, "event.type", event.EventType)
+		h.recordRoutingOutcome(ctx, span, routingOutcomeFallbackSuppressed)
+		h.publishLifecycle(ctx, event, fallback.Type, "NotificationSuppressed", string(models.NotificationStatusSuppressed), nil)
+		return
+	}
+
+	span.SetStatus(codes.Error, "All fallback channels failed")
+	slog.WarnContext(ctx, "Customer offline and no fallback channel delivered notification", "customer.id", event.CustomerID, "event.type", event.EventType)
+	h.recordRoutingOutcome(ctx, span, routingOutcomeFallbackFailed)
+	if len(failures) > 0 {
+		if _, err := h.deadLetters.Capture(ctx, fallback, models.DeadLetterFallbackFailed, errors.Join(failures...), attempts); err != nil {
+			slog.WarnContext(ctx, "Failed to dead-letter notification", "customer.id", event.CustomerID, "event.type", event.EventType, "error", err)
+		}
+	}
+}
+
+// customerOnline treats a failed presence lookup as offline so the fallback still runs
+func (h *NotificationHandler) customerOnline(ctx context.Context, customerID string) bool {
+	online, err := h.presenceService.IsOnline(ctx, customerID)
+	if err != nil {
+		slog.WarnContext(ctx, "Presence lookup failed, treating as offline", "customer.id", customerID, "error", err)
+		return false
+	}
+	return online
+}
+
+func (h *NotificationHandler) channelSender(channel models.NotificationType) services.ChannelSender {
+	switch channel {
+	case models.NotificationTypeEmail:
+		return h.emailService
+	case models.NotificationTypeSMS:
+		return h.smsService
+	case models.NotificationTypePush:
+		return h.pushService
+	case models.NotificationTypeWebhook:
+		return h.webhookService
+	default:
+		return nil
+	}
+}
+
+func (h *NotificationHandler) recordRoutingOutcome(ctx context.Context, span trace.Span, outcome string) {
+	span.SetAttributes(attribute.String("routing.outcome", outcome))
+	telemetry.RecordRoutingOutcome(ctx, h.routing.Name, outcome)
+}
+
+// fallbackNotification converts the WebSocket payload into a channel notification,
+// addressed to the customer on each channel it is tried on
+func fallbackNotification(event *services.OrderEvent, notification models.WebSocketMessage) *models.Notification {
+	data, _ := notification.Data.(map[string]interface{})
+	subject, _ := data["subject"].(string)
+	message, _ := data["message"].(string)
+
+	return &models.Notification{
+		ID:         services.NewID(),
+		Subject:    subject,
+		Message:    message,
+		Data:       data,
+		Status:     models.NotificationStatusPending,
+		Priority:   models.PriorityNormal,
+		CustomerID: event.CustomerID,
+		CreatedAt:  time.Now().UTC(),
+		Metadata: map[string]interface{}{
+			"routing.policy":          services.RoutingOnlineElseFallback,
+			services.CategoryMetadata: services.OrderEventsCategory,
+		},
+		Version: 1,
+	}
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+
+	"notification-service/internal/models"
+	"notification-service/internal/pipeline"
+	"notification-service/internal/services"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// screenNotification records the content screener's decision on a notification. A
+// blocked notification is still stored, marked blocked, which is final, so it is never
+// sent.
+func (h *NotificationHandler) screenNotification(ctx context.Context, notification *models.Notification) {
+	if err := services.ScreenNotification(ctx, h.screening, notification); err != nil {
+		slog.InfoContext(ctx, "Content screening blocked notification", "notification.id", notification.ID, "customer.id", notification.CustomerID, "error", err)
+	}
+}
+
+// screenOrderNotification runs after the transform stage has built the notification
+// content. Blocked notifications stop before dispatch; flagged ones carry the decision
+// in their data.
+func (h *NotificationHandler) screenOrderNotification(next pipeline.Handler) pipeline.Handler {
+	return func(ctx context.Context, msg *pipeline.Message) error {
+		data, _ := msg.Notification.Data.(map[string]interface{})
+		subject, _ := data["subject"].(string)
+		message, _ := data["message"].(string)
+
+		decision, err := h.screening.Screen(ctx, models.ScreeningRequest{
+			CustomerID: msg.Event.CustomerID,
+			Channel:    models.NotificationTypeWebSocket,
+			EventType:  msg.Event.EventType,
+			Subject:    subject,
+			Message:    message,
+		})
+		if err != nil {
+			slog.WarnContext(ctx, "Content screening failed, sending unscreened", "event.type", msg.Event.EventType, "error", err)
+			return next(ctx, msg)
+		}
+		if decision.Screener == services.ContentScreeningOff {
+			return next(ctx, msg)
+		}
+
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("screening.action", string(decision.Action)))
+		switch decision.Action {
+		case models.ScreeningActionBlock:
+			slog.InfoContext(ctx, "Content screening blocked notification", "event.type", msg.Event.EventType, "customer.id", msg.Event.CustomerID, "screening.reason", decision.Reason)
+			h.publishLifecycle(ctx, msg.Event, models.NotificationTypeWebSocket, "NotificationBlocked", string(models.NotificationStatusBlocked), nil)
+			return nil
+		case models.ScreeningActionFlag:
+			if data != nil {
+				data["screening"] = decision
+			}
+		}
+		return next(ctx, msg)
+	}
+}
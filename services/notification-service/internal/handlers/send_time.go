@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+)
+
+// SendTimeHandler serves customers' engagement-hour profiles and the comparison between
+// optimized and immediate sending
+type SendTimeHandler struct {
+	sendTime services.SendTimeScheduler
+}
+
+func NewSendTimeHandler(sendTime services.SendTimeScheduler) *SendTimeHandler {
+	return &SendTimeHandler{sendTime: sendTime}
+}
+
+// GetSendTimeProfile returns the customer's engagement per UTC hour and the hour
+// notifications to them are held for
+func (h *SendTimeHandler) GetSendTimeProfile(c *router.Context) {
+	profile, err := h.sendTime.Profile(c.Request.Context(), c.Param("customerId"))
+	if err != nil {
+		if errors.Is(err, services.ErrStorageUnavailable) {
+			c.JSON(http.StatusServiceUnavailable, router.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"profile": profile})
+}
+
+// GetSendTimeStats compares engagement between the optimized and control variants
+func (h *SendTimeHandler) GetSendTimeStats(c *router.Context) {
+	stats, err := h.sendTime.Stats(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"variants": stats})
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"notification-service/internal/models"
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+)
+
+// SigningKeyHandler administers the webhook and WebSocket signing keys and serves their
+// public halves to consumers
+type SigningKeyHandler struct {
+	keys services.SigningKeyManager
+}
+
+func NewSigningKeyHandler(keys services.SigningKeyManager) *SigningKeyHandler {
+	return &SigningKeyHandler{keys: keys}
+}
+
+// GetJWKS serves the published keys as a JWKS. Consumers poll it, so it is cacheable
+// for a few minutes and answers 304 when their copy is current.
+func (h *SigningKeyHandler) GetJWKS(c *router.Context) {
+	set, err := h.keys.JWKS(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, router.H{"error": err.Error()})
+		return
+	}
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(services.JWKSMaxAge.Seconds())))
+	if notModified(c, set) {
+		return
+	}
+	c.JSON(http.StatusOK, set)
+}
+
+func (h *SigningKeyHandler) GetSigningKeys(c *router.Context) {
+	keys, err := h.keys.List(c.Request.Context())
+	if err != nil {
+		signingKeyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"keys": keys, "count": len(keys)})
+}
+
+func (h *SigningKeyHandler) GetSigningKey(c *router.Context) {
+	key, err := h.keys.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		signingKeyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"key": key})
+}
+
+// CreateSigningKey adds a key for an activation window; with no body the key signs from
+// now for a rotation period
+func (h *SigningKeyHandler) CreateSigningKey(c *router.Context) {
+	var req models.SigningKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+
+	key, err := h.keys.Create(c.Request.Context(), req)
+	if err != nil {
+		signingKeyError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, router.H{"key": key})
+}
+
+// UpdateSigningKey moves a key's activation window
+func (h *SigningKeyHandler) UpdateSigningKey(c *router.Context) {
+	var req models.SigningKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+
+	key, err := h.keys.Update(c.Request.Context(), c.Param("id"), req)
+	if err != nil {
+		signingKeyError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"key": key})
+}
+
+// DeleteSigningKey revokes a key; signatures made with it stop verifying
+func (h *SigningKeyHandler) DeleteSigningKey(c *router.Context) {
+	if err := h.keys.Delete(c.Request.Context(), c.Param("id")); err != nil {
+		signingKeyError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// RotateSigningKeys replaces the signing key now, ahead of the rotation schedule
+func (h *SigningKeyHandler) RotateSigningKeys(c *router.Context) {
+	key, err := h.keys.Rotate(c.Request.Context())
+	if err != nil {
+		signingKeyError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, router.H{"key": key})
+}
+
+func signingKeyError(c *router.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrSigningKeyNotFound):
+		c.JSON(http.StatusNotFound, router.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidSigningKey):
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+	case errors.Is(err, services.ErrSigningKeysLocked):
+		c.JSON(http.StatusServiceUnavailable, router.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+	}
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"log/slog"
+	"net/http"
+
+	"notification-service/internal/models"
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+)
+
+// twimlResponse is the TwiML Twilio expects in reply to an inbound SMS; an empty
+// Response sends nothing back
+type twimlResponse struct {
+	XMLName xml.Name `xml:"Response"`
+	Message string   `xml:"Message,omitempty"`
+}
+
+// SMSInboundHandler receives the SMS customers send to the service's numbers from Twilio
+type SMSInboundHandler struct {
+	consent    services.SMSConsentManager
+	authTokens []string
+	webhookURL string
+}
+
+// NewSMSInboundHandler creates the handler. Every request must carry a valid
+// X-Twilio-Signature made with the auth token of one of the Twilio accounts, authTokens,
+// for webhookURL, or for its own URL when webhookURL is empty; without an auth token the
+// route must not be registered.
+func NewSMSInboundHandler(consent services.SMSConsentManager, authTokens []string, webhookURL string) *SMSInboundHandler {
+	return &SMSInboundHandler{consent: consent, authTokens: authTokens, webhookURL: webhookURL}
+}
+
+// ReceiveSMS processes the STOP, START and HELP keywords of an inbound SMS and replies
+// with the confirmation as TwiML
+func (h *SMSInboundHandler) ReceiveSMS(c *router.Context) {
+	ctx := c.Request.Context()
+	if err := c.Request.ParseForm(); err != nil {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+	if !h.verified(c) {
+		slog.WarnContext(ctx, "⚠️ Rejected inbound SMS with invalid signature")
+		c.JSON(http.StatusForbidden, router.H{"error": "invalid signature"})
+		return
+	}
+
+	var message models.InboundSMS
+	if err := c.ShouldBind(&message); err != nil {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+	if message.From == "" {
+		c.JSON(http.StatusBadRequest, router.H{"error": "From is required"})
+		return
+	}
+
+	result, err := h.consent.HandleInbound(ctx, message)
+	if err != nil {
+		// The failure shows up in the Twilio debugger for the operator
+		slog.ErrorContext(ctx, "Failed to process inbound SMS", "sms.message_sid", message.MessageSID, "error", err)
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+	c.XML(http.StatusOK, twimlResponse{Message: result.Reply})
+}
+
+// verified reports whether the request is signed with any account's auth token, since
+// each account signs the messages sent to its own numbers
+func (h *SMSInboundHandler) verified(c *router.Context) bool {
+	url, signature := h.requestURL(c), c.GetHeader("X-Twilio-Signature")
+	for _, token := range h.authTokens {
+		if token != "" && services.VerifyTwilioSignature(token, url, c.Request.PostForm, signature) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestURL is the URL Twilio signed: TWILIO_INBOUND_WEBHOOK_URL, or the request's URL
+// as seen by the proxy in front of the service
+func (h *SMSInboundHandler) requestURL(c *router.Context) string {
+	if h.webhookURL != "" {
+		return h.webhookURL
+	}
+	scheme := c.GetHeader("X-Forwarded-Proto")
+	if scheme == "" {
+		scheme = "http"
+		if c.Request.TLS != nil {
+			scheme = "https"
+		}
+	}
+	host := c.GetHeader("X-Forwarded-Host")
+	if host == "" {
+		host = c.Request.Host
+	}
+	return scheme + "://" + host + c.Request.RequestURI
+}
@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"notification-service/internal/models"
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+)
+
+// TemplateHandler serves template CRUD and the publish/approval/rollback workflow
+type TemplateHandler struct {
+	templateService services.TemplateManager
+	approvalSecret  string
+}
+
+func NewTemplateHandler(templateService services.TemplateManager, approvalSecret string) *TemplateHandler {
+	return &TemplateHandler{
+		templateService: templateService,
+		approvalSecret:  approvalSecret,
+	}
+}
+
+func (h *TemplateHandler) CreateTemplate(c *router.Context) {
+	var req models.TemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+
+	template, err := h.templateService.Create(c.Request.Context(), req)
+	if err != nil {
+		templateError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, router.H{"template": template})
+}
+
+func (h *TemplateHandler) GetTemplates(c *router.Context) {
+	templates, err := h.templateService.List(c.Request.Context())
+	if err != nil {
+		templateError(c, err)
+		return
+	}
+	if notModified(c, templates) {
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"templates": templates})
+}
+
+func (h *TemplateHandler) GetTemplate(c *router.Context) {
+	template, err := h.templateService.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		templateError(c, err)
+		return
+	}
+	if notModified(c, template) {
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"template": template})
+}
+
+// UpdateTemplate replaces a template's working copy. With If-Match, the update only
+// applies when the template still has that ETag, and 412 tells the caller to re-read it.
+func (h *TemplateHandler) UpdateTemplate(c *router.Context) {
+	var req models.TemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+
+	template, err := h.templateService.Update(c.Request.Context(), c.Param("id"), req, c.GetHeader("If-Match"))
+	if err != nil {
+		templateError(c, err)
+		return
+	}
+	c.Header("ETag", services.ETag(template))
+	c.JSON(http.StatusOK, router.H{"template": template})
+}
+
+func (h *TemplateHandler) DeleteTemplate(c *router.Context) {
+	if err := h.templateService.Delete(c.Request.Context(), c.Param("id"), c.GetHeader("If-Match")); err != nil {
+		templateError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// PublishTemplate publishes a template, or returns 202 while it waits for approval
+func (h *TemplateHandler) PublishTemplate(c *router.Context) {
+	template, err := h.templateService.RequestPublish(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		templateError(c, err)
+		return
+	}
+
+	if template.State == models.TemplateStatePendingApproval {
+		c.JSON(http.StatusAccepted, router.H{"template": template})
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"template": template})
+}
+
+// ApproveTemplate receives the decision callback from the external approval system.
+// The body must carry an X-Signature made with TEMPLATE_APPROVAL_SECRET; without the
+// secret no callback is accepted.
+func (h *TemplateHandler) ApproveTemplate(c *router.Context) {
+	if h.approvalSecret == "" {
+		c.JSON(http.StatusServiceUnavailable, router.H{"error": "template approval callbacks are not configured"})
+		return
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+
+	if !services.VerifySignature(h.approvalSecret, body, c.GetHeader("X-Signature")) {
+		slog.WarnContext(c.Request.Context(), "⚠️ Rejected template approval callback with invalid signature", "template.id", c.Param("id"))
+		c.JSON(http.StatusUnauthorized, router.H{"error": "invalid signature"})
+		return
+	}
+
+	var decision models.TemplateApprovalRequest
+	if err := json.Unmarshal(body, &decision); err != nil {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+	if decision.Approver == "" {
+		c.JSON(http.StatusBadRequest, router.H{"error": "approver is required"})
+		return
+	}
+
+	template, err := h.templateService.Decide(c.Request.Context(), c.Param("id"), decision)
+	if err != nil {
+		templateError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"template": template})
+}
+
+// RollbackTemplate publishes the content of the version in the body as a new version,
+// or of the previously published version when the body is empty
+func (h *TemplateHandler) RollbackTemplate(c *router.Context) {
+	var req models.TemplateRollbackRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+			return
+		}
+	}
+
+	template, err := h.templateService.Rollback(c.Request.Context(), c.Param("id"), req.Version)
+	if err != nil {
+		templateError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"template": template})
+}
+
+func (h *TemplateHandler) GetTemplateVersions(c *router.Context) {
+	versions, err := h.templateService.Versions(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		templateError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"versions": versions})
+}
+
+func (h *TemplateHandler) GetTemplateVersion(c *router.Context) {
+	number, err := strconv.Atoi(c.Param("version"))
+	if err != nil || number < 1 {
+		c.JSON(http.StatusBadRequest, router.H{"error": "version must be a positive number"})
+		return
+	}
+
+	version, err := h.templateService.Version(c.Request.Context(), c.Param("id"), number)
+	if err != nil {
+		templateError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"version": version})
+}
+
+// DiffTemplateVersions compares the versions in ?from= and ?to=, by default the current
+// version and the one before it
+func (h *TemplateHandler) DiffTemplateVersions(c *router.Context) {
+	var bounds [2]int
+	for i, name := range []string{"from", "to"} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 1 {
+			c.JSON(http.StatusBadRequest, router.H{"error": name + " must be a positive number"})
+			return
+		}
+		bounds[i] = number
+	}
+
+	diff, err := h.templateService.Diff(c.Request.Context(), c.Param("id"), bounds[0], bounds[1])
+	if err != nil {
+		templateError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"diff": diff})
+}
+
+func templateError(c *router.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrTemplateNotFound), errors.Is(err, services.ErrTemplateVersionNotFound):
+		c.JSON(http.StatusNotFound, router.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidTemplateSchema), errors.Is(err, services.ErrInvalidTemplateSyntax):
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+	case errors.Is(err, services.ErrTemplateNotPending), errors.Is(err, services.ErrNoPreviousVersion),
+		errors.Is(err, services.ErrTemplateChanged), errors.Is(err, services.ErrTemplateVersionNotPublished):
+		c.JSON(http.StatusConflict, router.H{"error": err.Error()})
+	case errors.Is(err, services.ErrPreconditionFailed):
+		c.JSON(http.StatusPreconditionFailed, router.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+	}
+}
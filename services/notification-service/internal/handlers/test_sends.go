@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"notification-service/internal/middleware"
+	"notification-service/internal/models"
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+)
+
+// AdminRole is required to send test notifications
+const AdminRole = middleware.AdminRole
+
+// TestSendHandler lets operators check a channel with a test notification that skips
+// queues and preferences and stays out of delivery statistics
+type TestSendHandler struct {
+	testSends services.TestSender
+}
+
+func NewTestSendHandler(testSends services.TestSender) *TestSendHandler {
+	return &TestSendHandler{testSends: testSends}
+}
+
+// SendTestNotification sends a test notification at once and answers with the channel's
+// result: 200 when it was delivered, 502 when the channel failed
+func (h *TestSendHandler) SendTestNotification(c *router.Context) {
+	var req models.TestSendRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.testSends.Send(c.Request.Context(), req, c.GetHeader(middleware.UserIDHeader))
+	if errors.Is(err, services.ErrInvalidTestSend) {
+		c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+	if !result.Delivered {
+		c.JSON(http.StatusBadGateway, router.H{"test_send": result})
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"test_send": result})
+}
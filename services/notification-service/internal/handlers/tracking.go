@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"notification-service/internal/models"
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+)
+
+// trackingPixel is a transparent 1x1 GIF
+var trackingPixel = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+// TrackingHandler serves the open pixels and tracked links of emails, recording each
+// open and click as an engagement event
+type TrackingHandler struct {
+	links         services.LinkVerifier
+	notifications services.NotificationManager
+	engagement    services.EngagementRecorder
+}
+
+func NewTrackingHandler(links services.LinkVerifier, notifications services.NotificationManager, engagement services.EngagementRecorder) *TrackingHandler {
+	return &TrackingHandler{links: links, notifications: notifications, engagement: engagement}
+}
+
+// TrackOpen records an open and returns the pixel. The pixel is served even when the
+// signature is wrong or recording fails, so an email never shows a broken image.
+func (h *TrackingHandler) TrackOpen(c *router.Context) {
+	id := c.Param("id")
+	if h.links.VerifyOpen(id, c.Query("sig")) {
+		h.record(c.Request.Context(), models.EngagementOpened, id, c.Request.UserAgent(), nil)
+	}
+
+	c.Header("Cache-Control", "no-store, no-cache, must-revalidate, private")
+	c.Data(http.StatusOK, "image/gif", trackingPixel)
+}
+
+// TrackClick records a click and redirects to the link's target. Only signed links are
+// followed, so the endpoint can't send anyone elsewhere.
+func (h *TrackingHandler) TrackClick(c *router.Context) {
+	id := c.Param("id")
+	target := c.Query("url")
+	if target == "" || !h.links.VerifyClick(id, target, c.Query("sig")) {
+		c.JSON(http.StatusBadRequest, router.H{"error": "invalid tracking link"})
+		return
+	}
+
+	h.record(c.Request.Context(), models.EngagementClicked, id, c.Request.UserAgent(), map[string]string{"url": target})
+	c.Header("Cache-Control", "no-store")
+	c.Redirect(http.StatusFound, target)
+}
+
+// record stores an engagement event for a tracked notification with its template, so
+// engagement can be broken down per template
+func (h *TrackingHandler) record(ctx context.Context, eventType models.EngagementEventType, id, userAgent string, attributes map[string]string) {
+	notification, err := h.notifications.GetNotification(ctx, id)
+	if err != nil {
+		slog.WarnContext(ctx, "Not recording engagement", "engagement.type", eventType, "notification.id", id, "error", err)
+		return
+	}
+
+	if attributes == nil {
+		attributes = make(map[string]string)
+	}
+	if notification.TemplateID != "" {
+		attributes["template_id"] = notification.TemplateID
+	}
+	if userAgent != "" {
+		attributes["user_agent"] = userAgent
+	}
+	customerID := notification.CustomerID
+	if customerID == "" {
+		customerID = notification.Recipient
+	}
+
+	if _, err := h.engagement.Record(ctx, models.RecordEngagementEventRequest{
+		Type:           eventType,
+		NotificationID: id,
+		CustomerID:     customerID,
+		Channel:        notification.Type,
+		Attributes:     attributes,
+	}); err != nil {
+		slog.WarnContext(ctx, "Failed to record engagement", "engagement.type", eventType, "notification.id", id, "error", err)
+	}
+}
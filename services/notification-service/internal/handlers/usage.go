@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"notification-service/internal/models"
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+)
+
+// UsageHandler exposes per-API-key usage so operators can find noisy producers
+type UsageHandler struct {
+	usageService services.UsageReporter
+}
+
+func NewUsageHandler(usageService services.UsageReporter) *UsageHandler {
+	return &UsageHandler{usageService: usageService}
+}
+
+// GetAPIKeys lists the IDs of API keys with recorded usage
+func (h *UsageHandler) GetAPIKeys(c *router.Context) {
+	keys, err := h.usageService.APIKeys(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"api_keys": keys})
+}
+
+// GetAPIKeyUsage returns a key's usage series; defaults to hourly buckets over the last day
+func (h *UsageHandler) GetAPIKeyUsage(c *router.Context) {
+	to := time.Now().UTC()
+	if value := c.Query("to"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, router.H{"error": "to must be an RFC 3339 timestamp"})
+			return
+		}
+		to = parsed
+	}
+	from := to.Add(-24 * time.Hour)
+	if value := c.Query("from"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, router.H{"error": "from must be an RFC 3339 timestamp"})
+			return
+		}
+		from = parsed
+	}
+	resolution := c.DefaultQuery("resolution", "hour")
+
+	series, err := h.usageService.UsageSeries(c.Request.Context(), c.Param("id"), from, to, resolution)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidUsageRange) {
+			c.JSON(http.StatusBadRequest, router.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, router.H{
+		"api_key_id": c.Param("id"),
+		"resolution": resolution,
+		"totals":     usageTotals(series),
+		"series":     series,
+	})
+}
+
+func usageTotals(series []models.UsageBucket) models.UsageBucket {
+	var totals models.UsageBucket
+	for _, bucket := range series {
+		totals.Requests += bucket.Requests
+		totals.Errors += bucket.Errors
+		totals.Notifications += bucket.Notifications
+		totals.BytesIn += bucket.BytesIn
+		totals.BytesOut += bucket.BytesOut
+	}
+	if len(series) > 0 {
+		totals.Start = series[0].Start
+	}
+	if totals.Requests > 0 {
+		totals.ErrorRate = float64(totals.Errors) / float64(totals.Requests)
+	}
+	return totals
+}
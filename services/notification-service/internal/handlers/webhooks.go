@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"net/http"
+
+	"notification-service/internal/models"
+	"notification-service/internal/router"
+	"notification-service/internal/services"
+)
+
+// WebhookHandler exposes webhook delivery history for analytics and debugging
+type WebhookHandler struct {
+	webhookService services.WebhookDeliveryReporter
+}
+
+func NewWebhookHandler(webhookService services.WebhookDeliveryReporter) *WebhookHandler {
+	return &WebhookHandler{webhookService: webhookService}
+}
+
+// GetWebhookAttempts lists a notification's webhook delivery attempts, oldest first
+func (h *WebhookHandler) GetWebhookAttempts(c *router.Context) {
+	attempts, err := h.webhookService.WebhookAttempts(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+	if attempts == nil {
+		attempts = []models.WebhookAttempt{}
+	}
+	c.JSON(http.StatusOK, router.H{"notification_id": c.Param("id"), "attempts": attempts})
+}
+
+// GetWebhookStats returns webhook delivery totals
+func (h *WebhookHandler) GetWebhookStats(c *router.Context) {
+	stats, err := h.webhookService.WebhookStats(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, router.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, router.H{"stats": stats})
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"notification-service/internal/router"
+	"notification-service/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Deprecation describes a deprecated route: since when, when it is due to be removed
+// (optional), the route replacing it and a migration guide (both optional)
+type Deprecation struct {
+	Since     time.Time
+	Sunset    time.Time
+	Successor string
+	Docs      string
+}
+
+// Deprecated marks the routes it is added to as deprecated. Responses carry a
+// Deprecation header (RFC 9745), a Sunset header (RFC 8594) once a removal date is set,
+// and Link headers to the successor and the migration guide. Every call is counted by
+// route and caller, so migration progress can be followed per API key or user.
+func Deprecated(deprecation Deprecation) router.HandlerFunc {
+	var links []string
+	if deprecation.Successor != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="successor-version"`, deprecation.Successor))
+	}
+	if deprecation.Docs != "" {
+		links = append(links, fmt.Sprintf(`<%s>; rel="deprecation"; type="text/html"`, deprecation.Docs))
+	}
+
+	return func(c *router.Context) {
+		header := c.Writer.Header()
+		header.Set("Deprecation", fmt.Sprintf("@%d", deprecation.Since.Unix()))
+		if !deprecation.Sunset.IsZero() {
+			header.Set("Sunset", deprecation.Sunset.UTC().Format(http.TimeFormat))
+		}
+		for _, link := range links {
+			header.Add("Link", link)
+		}
+
+		caller, callerID := deprecatedCaller(c)
+		ctx := c.Request.Context()
+		trace.SpanFromContext(ctx).SetAttributes(
+			attribute.Bool("http.route.deprecated", true),
+			attribute.String("caller.id", callerID),
+		)
+		telemetry.RecordDeprecatedRequest(ctx, c.Request.Method, c.FullPath(), caller)
+		c.Next()
+	}
+}
+
+// deprecatedCaller identifies the caller by API key ID, then by verified user, returning
+// the metric label and the ID for the span. Key IDs are bounded by API_KEYS, so they
+// label the metric; users are unbounded, so the label is just "user" and the subject
+// goes on the span only. The raw key and unverified headers are never used.
+func deprecatedCaller(c *router.Context) (string, string) {
+	if keyID, ok := APIKeyIDFromContext(c); ok {
+		return "apikey:" + keyID, "apikey:" + keyID
+	}
+	if identity, ok := IdentityFromContext(c); ok && identity.Subject != "" {
+		return "user", "user:" + identity.Subject
+	}
+	return "anonymous", "anonymous"
+}
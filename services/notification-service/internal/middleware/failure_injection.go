@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/faults"
+	"notification-service/internal/router"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Headers that override failure injection for a single request, so a demo can make a
+// chosen route slow or failing without touching the rest of the traffic. Only callers
+// with AdminRole can use them.
+const (
+	FaultLatencyHeader            = "X-Fault-Latency-Ms"
+	FaultStatusHeader             = "X-Fault-Status"
+	FaultLatencyProbabilityHeader = "X-Fault-Latency-Probability"
+	FaultErrorProbabilityHeader   = "X-Fault-Error-Probability"
+)
+
+// maxFaultLatencyMs caps the latency a FaultLatencyHeader can ask for, so a request
+// can't tie up a handler for long
+const maxFaultLatencyMs = 10000
+
+// injectedStatuses are the errors a probabilistic fault answers with
+var injectedStatuses = []int{
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// FailureInjectionMiddleware delays requests by LATENCY_MIN_MS to LATENCY_MAX_MS with
+// LATENCY_PROBABILITY, and fails them with a 5xx with ERROR_PROBABILITY, while fault
+// injection is enabled. Health, info and metrics endpoints, the signing keys and every
+// admin and chaos endpoint are never affected. Faults are recorded on the request span.
+// During a dry run requests are evaluated the same way but pass through untouched. It
+// goes after authentication, so the override headers are only taken from verified
+// admins.
+func FailureInjectionMiddleware(cfg *config.Config) router.HandlerFunc {
+	minLatency, maxLatency := cfg.LatencyMinMs, max(cfg.LatencyMaxMs, cfg.LatencyMinMs)
+
+	return func(c *router.Context) {
+		if !faults.Evaluating() || faultExempt(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+		faults.Evaluate(faults.OpHTTPRequest)
+
+		overrides := HasRole(c, AdminRole)
+		latencyProbability, errorProbability := cfg.LatencyProbability, cfg.ErrorProbability
+		if overrides {
+			latencyProbability = headerProbability(c, FaultLatencyProbabilityHeader, latencyProbability)
+			errorProbability = headerProbability(c, FaultErrorProbabilityHeader, errorProbability)
+		}
+		source := "config"
+
+		latencyMs := 0
+		if header, err := strconv.Atoi(c.GetHeader(FaultLatencyHeader)); overrides && err == nil && header > 0 {
+			latencyMs, source = min(header, maxFaultLatencyMs), "header"
+		} else if rand.Float64() < latencyProbability {
+			latencyMs = minLatency + rand.Intn(maxLatency-minLatency+1)
+		}
+		if latencyMs > 0 {
+			ctx := c.Request.Context()
+			injected := faults.Record(ctx, faults.OpHTTPRequest, faults.HTTPLatency,
+				attribute.Int("fault.latency_ms", latencyMs),
+				attribute.String("fault.source", source),
+			)
+			if injected {
+				select {
+				case <-ctx.Done():
+					c.AbortWithStatus(http.StatusServiceUnavailable)
+					return
+				case <-time.After(time.Duration(latencyMs) * time.Millisecond):
+				}
+			}
+		}
+
+		status := 0
+		if header, err := strconv.Atoi(c.GetHeader(FaultStatusHeader)); overrides && err == nil && header >= 500 && header <= 599 {
+			status, source = header, "header"
+		} else if rand.Float64() < errorProbability {
+			status = injectedStatuses[rand.Intn(len(injectedStatuses))]
+		}
+		if status > 0 {
+			injected := faults.Record(c.Request.Context(), faults.OpHTTPRequest, faults.HTTPError,
+				attribute.Int("fault.status_code", status),
+				attribute.String("fault.source", source),
+			)
+			if injected {
+				c.AbortWithStatusJSON(status, router.H{"error": fmt.Sprintf("%s: simulated %d from %s", faults.ErrInjectedFault, status, c.Request.URL.Path)})
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// faultExempt keeps probes, scraping, key discovery and the operators' controls,
+// including those for fault injection, reliable
+func faultExempt(path string) bool {
+	return strings.HasPrefix(path, "/health") ||
+		path == "/metrics" ||
+		path == "/info" ||
+		strings.HasPrefix(path, "/.well-known/") ||
+		strings.HasPrefix(path, "/api/v1/admin/") ||
+		strings.HasPrefix(path, "/api/v1/chaos")
+}
+
+// headerProbability reads a probability override between 0 and 1 from header
+func headerProbability(c *router.Context, header string, fallback float64) float64 {
+	value, err := strconv.ParseFloat(c.GetHeader(header), 64)
+	if err != nil || value < 0 || value > 1 {
+		return fallback
+	}
+	return value
+}
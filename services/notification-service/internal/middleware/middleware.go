@@ -1,17 +1,25 @@
 package middleware
 
 import (
-	"notification-service/internal/config"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
 
-	"github.com/gin-gonic/gin"
+	"notification-service/internal/models"
+	"notification-service/internal/router"
+	"notification-service/internal/telemetry"
 )
 
-func CORSMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
+func CORSMiddleware() router.HandlerFunc {
+	return func(c *router.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-User-Id, X-User-Roles, X-API-Key, If-Match, If-None-Match, Idempotency-Key, X-Fault-Latency-Ms, X-Fault-Status, X-Fault-Latency-Probability, X-Fault-Error-Probability")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "ETag, Deprecation, Sunset, Link, Idempotent-Replayed, Retry-After, X-RateLimit-Limit, X-RateLimit-Remaining")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, PATCH, DELETE")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -22,16 +30,200 @@ func CORSMiddleware() gin.HandlerFunc {
 	}
 }
 
-func FailureInjectionMiddleware(cfg *config.Config) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Failure injection logic can be added here
+// unmatchedRoute labels requests that match no route, so stray paths can't grow the
+// metrics' cardinality
+const unmatchedRoute = "unmatched"
+
+// MetricsMiddleware records RED metrics per route template, method and status: the
+// request count, the duration histogram and the number of requests in flight.
+// WebSocket upgrades are counted, but their connection lifetime is not a request
+// duration, so they stay out of the histogram and the in-flight count.
+func MetricsMiddleware() router.HandlerFunc {
+	return func(c *router.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = unmatchedRoute
+		}
+		method := c.Request.Method
+		ctx := c.Request.Context()
+
+		if strings.EqualFold(c.GetHeader("Upgrade"), "websocket") {
+			c.Next()
+			telemetry.RecordHTTPRequest(ctx, method, route, c.Writer.Status())
+			return
+		}
+
+		start := time.Now()
+		telemetry.AddHTTPActiveRequests(ctx, method, route, 1)
+		defer func() {
+			status := c.Writer.Status()
+			telemetry.AddHTTPActiveRequests(ctx, method, route, -1)
+			telemetry.RecordHTTPRequest(ctx, method, route, status)
+			telemetry.RecordHTTPRequestDuration(ctx, method, route, status, time.Since(start).Seconds())
+		}()
+		c.Next()
+	}
+}
+
+// ReadOnlyChecker reports whether the service is in maintenance read-only mode
+type ReadOnlyChecker interface {
+	IsReadOnly(ctx context.Context) bool
+}
+
+// ReadOnlyMiddleware rejects mutating requests while a storage migration is running
+func ReadOnlyMiddleware(checker ReadOnlyChecker) router.HandlerFunc {
+	return func(c *router.Context) {
+		switch c.Request.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			c.Next()
+			return
+		}
+
+		if checker.IsReadOnly(c.Request.Context()) {
+			c.Header("Retry-After", "60")
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, router.H{"error": "service is in read-only mode for maintenance"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// Identity headers are set by the API gateway after authenticating the caller
+const (
+	UserIDHeader    = "X-User-Id"
+	UserRolesHeader = "X-User-Roles"
+)
+
+// AdminRole is held by the operators of the service
+const AdminRole = "admin"
+
+// RequireRole rejects requests whose caller does not hold the given role
+func RequireRole(role string) router.HandlerFunc {
+	return func(c *router.Context) {
+		if c.GetHeader(UserIDHeader) == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, router.H{"error": "missing " + UserIDHeader + " header"})
+			return
+		}
+		if !HasRole(c, role) {
+			c.AbortWithStatusJSON(http.StatusForbidden, router.H{"error": "role " + role + " is required"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// HasRole reports whether the request's caller holds role
+func HasRole(c *router.Context, role string) bool {
+	if c.GetHeader(UserIDHeader) == "" {
+		return false
+	}
+	for _, granted := range strings.Split(c.GetHeader(UserRolesHeader), ",") {
+		if strings.TrimSpace(granted) == role {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyHeader identifies the producer calling the API
+const APIKeyHeader = "X-API-Key"
+
+// UsageNotificationsKey is set by handlers to the number of notifications a request produced
+const UsageNotificationsKey = "usage.notifications"
+
+// UsageRecorder stores per-API-key usage samples. RecordUsage is called on the request
+// path, so it must not block.
+type UsageRecorder interface {
+	RecordUsage(ctx context.Context, keyID string, sample models.UsageSample)
+}
+
+// APIKeyID derives the identifier usage is tracked under, so raw keys are never stored
+func APIKeyID(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+// APIKeyIDKey is the gin context key holding the ID of the request's valid API key
+const APIKeyIDKey = "api_key.id"
+
+// APIKeys are the API keys producers may present, known by their SHA-256 so the raw keys
+// aren't configured anywhere
+type APIKeys struct {
+	digests map[string]bool
+}
+
+// NewAPIKeys parses API_KEYS, comma-separated hex SHA-256 digests of the keys
+func NewAPIKeys(digests string) *APIKeys {
+	keys := &APIKeys{digests: make(map[string]bool)}
+	for _, digest := range strings.Split(digests, ",") {
+		if digest = strings.ToLower(strings.TrimSpace(digest)); digest != "" {
+			keys.digests[digest] = true
+		}
+	}
+	return keys
+}
+
+// Enabled reports whether any keys are configured
+func (k *APIKeys) Enabled() bool {
+	return len(k.digests) > 0
+}
+
+// Valid reports whether apiKey is one of the keys
+func (k *APIKeys) Valid(apiKey string) bool {
+	sum := sha256.Sum256([]byte(apiKey))
+	return k.digests[hex.EncodeToString(sum[:])]
+}
+
+// APIKeyMiddleware checks the X-API-Key a request carries, so usage, rate limits and
+// deprecation reports are only kept for real producers. A request with a key that isn't
+// in API_KEYS gets 401; a valid key's ID is kept for the handlers after it. Without
+// API_KEYS the header is ignored.
+func APIKeyMiddleware(keys *APIKeys) router.HandlerFunc {
+	return func(c *router.Context) {
+		apiKey := c.GetHeader(APIKeyHeader)
+		if apiKey == "" || !keys.Enabled() {
+			c.Next()
+			return
+		}
+		if !keys.Valid(apiKey) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, router.H{"error": "unknown API key"})
+			return
+		}
+		c.Set(APIKeyIDKey, APIKeyID(apiKey))
 		c.Next()
 	}
 }
 
-func MetricsMiddleware() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Metrics collection logic
+// APIKeyIDFromContext returns the ID of the valid API key APIKeyMiddleware found on the
+// request
+func APIKeyIDFromContext(c *router.Context) (string, bool) {
+	id := c.GetString(APIKeyIDKey)
+	return id, id != ""
+}
+
+// UsageMiddleware records request counts, errors, payload bytes and notification
+// volumes for callers that present an API key
+func UsageMiddleware(recorder UsageRecorder) router.HandlerFunc {
+	return func(c *router.Context) {
+		keyID, ok := APIKeyIDFromContext(c)
+		if !ok {
+			c.Next()
+			return
+		}
+
 		c.Next()
+
+		sample := models.UsageSample{
+			Error:         c.Writer.Status() >= http.StatusBadRequest,
+			BytesOut:      int64(max(c.Writer.Size(), 0)),
+			Notifications: c.GetInt(UsageNotificationsKey),
+		}
+		if c.Request.ContentLength > 0 {
+			sample.BytesIn = c.Request.ContentLength
+		}
+
+		// The recorder only queues the sample, so it never adds request latency
+		recorder.RecordUsage(c.Request.Context(), keyID, sample)
 	}
 }
@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"notification-service/internal/models"
+	"notification-service/internal/router"
+)
+
+// PayloadSampler picks the requests whose payloads are logged and logs them
+type PayloadSampler interface {
+	Sample(ctx context.Context, path string) (models.PayloadLogSettings, bool)
+	LogExchange(ctx context.Context, settings models.PayloadLogSettings, exchange models.HTTPExchange)
+}
+
+// PayloadLoggingMiddleware captures the headers and bodies of sampled requests and their
+// responses, up to the settings' MaxBodyBytes, and hands them to the sampler once the
+// response is written. Probes, scraping and WebSocket upgrades are never sampled.
+func PayloadLoggingMiddleware(sampler PayloadSampler) router.HandlerFunc {
+	return func(c *router.Context) {
+		path := c.Request.URL.Path
+		if strings.HasPrefix(path, "/health") || path == "/metrics" || strings.EqualFold(c.GetHeader("Upgrade"), "websocket") {
+			c.Next()
+			return
+		}
+		ctx := c.Request.Context()
+		settings, sampled := sampler.Sample(ctx, path)
+		if !sampled {
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+		exchange := models.HTTPExchange{
+			Method:        c.Request.Method,
+			Path:          path,
+			Route:         c.FullPath(),
+			RequestHeader: c.Request.Header.Clone(),
+		}
+		exchange.RequestBody, exchange.RequestTruncated = captureRequestBody(c, settings.MaxBodyBytes)
+		writer := &bodyCaptureWriter{ResponseWriter: c.Writer, limit: settings.MaxBodyBytes}
+		c.Writer = writer
+
+		c.Next()
+
+		exchange.Status = writer.Status()
+		exchange.Duration = time.Since(start)
+		exchange.ResponseHeader = writer.Header().Clone()
+		exchange.ResponseBody = writer.body.Bytes()
+		exchange.ResponseTruncated = writer.truncated
+		sampler.LogExchange(ctx, settings, exchange)
+	}
+}
+
+// captureRequestBody reads up to limit bytes of the request body and puts them back in
+// front of the rest, so handlers still read the whole body
+func captureRequestBody(c *router.Context, limit int) ([]byte, bool) {
+	if c.Request.Body == nil || c.Request.Body == http.NoBody {
+		return nil, false
+	}
+	if limit <= 0 {
+		return nil, c.Request.ContentLength != 0
+	}
+
+	body := c.Request.Body
+	captured, err := io.ReadAll(io.LimitReader(body, int64(limit)+1))
+	c.Request.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(captured), body), body}
+	if err != nil {
+		return nil, false
+	}
+	if len(captured) > limit {
+		return captured[:limit], true
+	}
+	return captured, false
+}
+
+// bodyCaptureWriter keeps the first limit bytes of the response body as it is written
+type bodyCaptureWriter struct {
+	router.ResponseWriter
+	body      bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (w *bodyCaptureWriter) Write(b []byte) (int, error) {
+	w.capture(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyCaptureWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *bodyCaptureWriter) capture(b []byte) {
+	room := w.limit - w.body.Len()
+	if len(b) > room {
+		b, w.truncated = b[:max(room, 0)], true
+	}
+	w.body.Write(b)
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+
+	"notification-service/internal/models"
+	"notification-service/internal/router"
+	"notification-service/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Subjects rate limits are kept for
+const (
+	RateLimitSubjectAPIKey   = "api_key"
+	RateLimitSubjectCustomer = "customer"
+	RateLimitSubjectClientIP = "client_ip"
+)
+
+// RateLimitChecker takes a request from the allowance of each subject for a route
+type RateLimitChecker interface {
+	Allow(ctx context.Context, route string, subjects []models.RateLimitSubject) (models.RateLimitDecision, error)
+}
+
+// RateLimitMiddleware limits requests per valid API key and per authenticated customer
+// in the route's group, and requests with neither per client IP. A request over any of
+// its allowances gets 429 with Retry-After. Requests are let through when the limiter is
+// unreachable, so Redis trouble doesn't take the API down.
+func RateLimitMiddleware(limiter RateLimitChecker) router.HandlerFunc {
+	return func(c *router.Context) {
+		var subjects []models.RateLimitSubject
+		if keyID, ok := APIKeyIDFromContext(c); ok {
+			subjects = append(subjects, models.RateLimitSubject{Kind: RateLimitSubjectAPIKey, ID: keyID})
+		}
+		if identity, ok := IdentityFromContext(c); ok && identity.CustomerID != "" {
+			subjects = append(subjects, models.RateLimitSubject{Kind: RateLimitSubjectCustomer, ID: identity.CustomerID})
+		}
+		if len(subjects) == 0 {
+			subjects = append(subjects, models.RateLimitSubject{Kind: RateLimitSubjectClientIP, ID: c.ClientIP()})
+		}
+
+		ctx := c.Request.Context()
+		decision, err := limiter.Allow(ctx, c.FullPath(), subjects)
+		if err != nil {
+			slog.WarnContext(ctx, "Rate limit check failed, allowing request", "error", err)
+			c.Next()
+			return
+		}
+		if decision.Group == "" {
+			c.Next()
+			return
+		}
+
+		span := trace.SpanFromContext(ctx)
+		span.SetAttributes(
+			attribute.String("ratelimit.group", decision.Group),
+			attribute.Bool("ratelimit.hit", !decision.Allowed),
+		)
+		c.Header("X-RateLimit-Limit", strconv.Itoa(decision.Limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+
+		if !decision.Allowed {
+			retryAfter := max(int(math.Ceil(decision.RetryAfter.Seconds())), 1)
+			span.SetAttributes(attribute.String("ratelimit.subject", decision.Subject.Kind))
+			telemetry.RecordRateLimitHit(ctx, decision.Group, decision.Subject.Kind)
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, router.H{
+				"error":               "rate limit exceeded",
+				"group":               decision.Group,
+				"subject":             decision.Subject.Kind,
+				"retry_after_seconds": retryAfter,
+			})
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,1296 @@
+// Package mocks provides function-field implementations of the service interfaces so
+// handlers can be tested without Redis, Event Hub or real channel providers. Unset
+// functions return zero values.
+package mocks
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"notification-service/internal/models"
+	"notification-service/internal/services"
+	"notification-service/internal/storage"
+
+	"github.com/gorilla/websocket"
+)
+
+// NotificationManager mocks services.NotificationManager
+type NotificationManager struct {
+	SaveNotificationFunc       func(ctx context.Context, notification *models.Notification) (bool, error)
+	GetNotificationFunc        func(ctx context.Context, id string) (*models.Notification, error)
+	ListNotificationsFunc      func(ctx context.Context, filter storage.NotificationFilter) ([]*models.Notification, string, error)
+	CountNotificationsFunc     func(ctx context.Context, filter storage.NotificationFilter) (int64, error)
+	UpdateStatusFunc           func(ctx context.Context, id string, req models.UpdateNotificationStatusRequest) (*models.Notification, error)
+	DeleteNotificationFunc     func(ctx context.Context, id string) error
+	UpdateNotificationFunc     func(ctx context.Context, id string, expectedVersion int, patch models.NotificationPatchRequest, editedBy string) (*models.Notification, error)
+	NotificationEditsFunc      func(ctx context.Context, id string) ([]models.NotificationEdit, error)
+	CancelNotificationFunc     func(ctx context.Context, id string) (*models.CancelResult, error)
+	CancelOrderFunc            func(ctx context.Context, orderID string) ([]*models.CancelResult, error)
+	CancellationStatsFunc      func(ctx context.Context) (map[string]int64, error)
+	ReplacementStatsFunc       func(ctx context.Context) (map[string]int64, error)
+	FindByMetadataFunc         func(ctx context.Context, tenantID string, filters map[string]string, limit int) ([]*models.Notification, error)
+	PublishLifecycleEventFunc  func(ctx context.Context, event services.LifecycleEvent) error
+	EventHubFailoverStatusFunc func() []services.FailoverStatus
+}
+
+func (m *NotificationManager) SaveNotification(ctx context.Context, notification *models.Notification) (bool, error) {
+	if m.SaveNotificationFunc == nil {
+		return false, nil
+	}
+	return m.SaveNotificationFunc(ctx, notification)
+}
+
+func (m *NotificationManager) GetNotification(ctx context.Context, id string) (*models.Notification, error) {
+	if m.GetNotificationFunc == nil {
+		return nil, nil
+	}
+	return m.GetNotificationFunc(ctx, id)
+}
+
+func (m *NotificationManager) ListNotifications(ctx context.Context, filter storage.NotificationFilter) ([]*models.Notification, string, error) {
+	if m.ListNotificationsFunc == nil {
+		return nil, "", nil
+	}
+	return m.ListNotificationsFunc(ctx, filter)
+}
+
+func (m *NotificationManager) CountNotifications(ctx context.Context, filter storage.NotificationFilter) (int64, error) {
+	if m.CountNotificationsFunc == nil {
+		return 0, nil
+	}
+	return m.CountNotificationsFunc(ctx, filter)
+}
+
+func (m *NotificationManager) UpdateNotificationStatus(ctx context.Context, id string, req models.UpdateNotificationStatusRequest) (*models.Notification, error) {
+	if m.UpdateStatusFunc == nil {
+		return nil, nil
+	}
+	return m.UpdateStatusFunc(ctx, id, req)
+}
+
+func (m *NotificationManager) DeleteNotification(ctx context.Context, id string) error {
+	if m.DeleteNotificationFunc == nil {
+		return nil
+	}
+	return m.DeleteNotificationFunc(ctx, id)
+}
+
+func (m *NotificationManager) UpdateNotification(ctx context.Context, id string, expectedVersion int, patch models.NotificationPatchRequest, editedBy string) (*models.Notification, error) {
+	if m.UpdateNotificationFunc == nil {
+		return nil, nil
+	}
+	return m.UpdateNotificationFunc(ctx, id, expectedVersion, patch, editedBy)
+}
+
+func (m *NotificationManager) NotificationEdits(ctx context.Context, id string) ([]models.NotificationEdit, error) {
+	if m.NotificationEditsFunc == nil {
+		return nil, nil
+	}
+	return m.NotificationEditsFunc(ctx, id)
+}
+
+func (m *NotificationManager) CancelNotification(ctx context.Context, id string) (*models.CancelResult, error) {
+	if m.CancelNotificationFunc == nil {
+		return nil, nil
+	}
+	return m.CancelNotificationFunc(ctx, id)
+}
+
+func (m *NotificationManager) CancelOrderNotifications(ctx context.Context, orderID string) ([]*models.CancelResult, error) {
+	if m.CancelOrderFunc == nil {
+		return nil, nil
+	}
+	return m.CancelOrderFunc(ctx, orderID)
+}
+
+func (m *NotificationManager) CancellationStats(ctx context.Context) (map[string]int64, error) {
+	if m.CancellationStatsFunc == nil {
+		return nil, nil
+	}
+	return m.CancellationStatsFunc(ctx)
+}
+
+func (m *NotificationManager) ReplacementStats(ctx context.Context) (map[string]int64, error) {
+	if m.ReplacementStatsFunc == nil {
+		return nil, nil
+	}
+	return m.ReplacementStatsFunc(ctx)
+}
+
+func (m *NotificationManager) FindNotificationsByMetadata(ctx context.Context, tenantID string, filters map[string]string, limit int) ([]*models.Notification, error) {
+	if m.FindByMetadataFunc == nil {
+		return nil, nil
+	}
+	return m.FindByMetadataFunc(ctx, tenantID, filters, limit)
+}
+
+func (m *NotificationManager) PublishLifecycleEvent(ctx context.Context, event services.LifecycleEvent) error {
+	if m.PublishLifecycleEventFunc == nil {
+		return nil
+	}
+	return m.PublishLifecycleEventFunc(ctx, event)
+}
+
+func (m *NotificationManager) EventHubFailoverStatus() []services.FailoverStatus {
+	if m.EventHubFailoverStatusFunc == nil {
+		return nil
+	}
+	return m.EventHubFailoverStatusFunc()
+}
+
+// WebhookPoster mocks services.WebhookPoster and records every notification it is given
+type WebhookPoster struct {
+	SendToFunc func(ctx context.Context, notification *models.Notification, target string) error
+	Sent       []*models.Notification
+}
+
+func (m *WebhookPoster) SendTo(ctx context.Context, notification *models.Notification, target string) error {
+	m.Sent = append(m.Sent, notification)
+	if m.SendToFunc == nil {
+		return nil
+	}
+	return m.SendToFunc(ctx, notification, target)
+}
+
+// ChannelSender mocks services.ChannelSender and records every notification it is given
+type ChannelSender struct {
+	SendFunc func(ctx context.Context, notification *models.Notification) error
+	Sent     []*models.Notification
+}
+
+func (m *ChannelSender) Send(ctx context.Context, notification *models.Notification) error {
+	m.Sent = append(m.Sent, notification)
+	if m.SendFunc == nil {
+		return nil
+	}
+	return m.SendFunc(ctx, notification)
+}
+
+// RealtimeHub mocks services.RealtimeHub
+type RealtimeHub struct {
+	SendToCustomerFunc       func(ctx context.Context, customerID string, message interface{}) error
+	BroadcastToAllFunc       func(ctx context.Context, message interface{}) error
+	BroadcastMessageFunc     func(ctx context.Context, messageType string, message interface{}) error
+	GetActiveConnectionsFunc func() int
+	ServeFunc                func(ctx context.Context, conn *websocket.Conn, customerID, userAgent, ipAddress string, resume models.ResumeRequest)
+}
+
+func (m *RealtimeHub) SendToCustomer(ctx context.Context, customerID string, message interface{}) error {
+	if m.SendToCustomerFunc == nil {
+		return nil
+	}
+	return m.SendToCustomerFunc(ctx, customerID, message)
+}
+
+func (m *RealtimeHub) BroadcastToAll(ctx context.Context, message interface{}) error {
+	if m.BroadcastToAllFunc == nil {
+		return nil
+	}
+	return m.BroadcastToAllFunc(ctx, message)
+}
+
+func (m *RealtimeHub) BroadcastMessage(ctx context.Context, messageType string, message interface{}) error {
+	if m.BroadcastMessageFunc == nil {
+		return nil
+	}
+	return m.BroadcastMessageFunc(ctx, messageType, message)
+}
+
+func (m *RealtimeHub) GetActiveConnections() int {
+	if m.GetActiveConnectionsFunc == nil {
+		return 0
+	}
+	return m.GetActiveConnectionsFunc()
+}
+
+func (m *RealtimeHub) Serve(ctx context.Context, conn *websocket.Conn, customerID, userAgent, ipAddress string, resume models.ResumeRequest) {
+	if m.ServeFunc != nil {
+		m.ServeFunc(ctx, conn, customerID, userAgent, ipAddress, resume)
+	}
+}
+
+// TemplateManager mocks services.TemplateManager
+type TemplateManager struct {
+	CreateFunc         func(ctx context.Context, req models.TemplateRequest) (*models.NotificationTemplate, error)
+	GetFunc            func(ctx context.Context, id string) (*models.NotificationTemplate, error)
+	ListFunc           func(ctx context.Context) ([]*models.NotificationTemplate, error)
+	UpdateFunc         func(ctx context.Context, id string, req models.TemplateRequest, ifMatch string) (*models.NotificationTemplate, error)
+	DeleteFunc         func(ctx context.Context, id string, ifMatch string) error
+	RequestPublishFunc func(ctx context.Context, id string) (*models.NotificationTemplate, error)
+	DecideFunc         func(ctx context.Context, id string, decision models.TemplateApprovalRequest) (*models.NotificationTemplate, error)
+	RollbackFunc       func(ctx context.Context, id string, version int) (*models.NotificationTemplate, error)
+	VersionsFunc       func(ctx context.Context, id string) ([]*models.TemplateVersion, error)
+	VersionFunc        func(ctx context.Context, id string, number int) (*models.TemplateVersion, error)
+	DiffFunc           func(ctx context.Context, id string, from, to int) (*models.TemplateDiff, error)
+
+	ValidateNotificationFunc func(ctx context.Context, notification *models.Notification) error
+	RenderNotificationFunc   func(ctx context.Context, notification *models.Notification) error
+}
+
+func (m *TemplateManager) Create(ctx context.Context, req models.TemplateRequest) (*models.NotificationTemplate, error) {
+	if m.CreateFunc == nil {
+		return nil, nil
+	}
+	return m.CreateFunc(ctx, req)
+}
+
+func (m *TemplateManager) Get(ctx context.Context, id string) (*models.NotificationTemplate, error) {
+	if m.GetFunc == nil {
+		return nil, nil
+	}
+	return m.GetFunc(ctx, id)
+}
+
+func (m *TemplateManager) List(ctx context.Context) ([]*models.NotificationTemplate, error) {
+	if m.ListFunc == nil {
+		return nil, nil
+	}
+	return m.ListFunc(ctx)
+}
+
+func (m *TemplateManager) Update(ctx context.Context, id string, req models.TemplateRequest, ifMatch string) (*models.NotificationTemplate, error) {
+	if m.UpdateFunc == nil {
+		return nil, nil
+	}
+	return m.UpdateFunc(ctx, id, req, ifMatch)
+}
+
+func (m *TemplateManager) Delete(ctx context.Context, id string, ifMatch string) error {
+	if m.DeleteFunc == nil {
+		return nil
+	}
+	return m.DeleteFunc(ctx, id, ifMatch)
+}
+
+func (m *TemplateManager) RequestPublish(ctx context.Context, id string) (*models.NotificationTemplate, error) {
+	if m.RequestPublishFunc == nil {
+		return nil, nil
+	}
+	return m.RequestPublishFunc(ctx, id)
+}
+
+func (m *TemplateManager) Decide(ctx context.Context, id string, decision models.TemplateApprovalRequest) (*models.NotificationTemplate, error) {
+	if m.DecideFunc == nil {
+		return nil, nil
+	}
+	return m.DecideFunc(ctx, id, decision)
+}
+
+func (m *TemplateManager) Rollback(ctx context.Context, id string, version int) (*models.NotificationTemplate, error) {
+	if m.RollbackFunc == nil {
+		return nil, nil
+	}
+	return m.RollbackFunc(ctx, id, version)
+}
+
+func (m *TemplateManager) Versions(ctx context.Context, id string) ([]*models.TemplateVersion, error) {
+	if m.VersionsFunc == nil {
+		return nil, nil
+	}
+	return m.VersionsFunc(ctx, id)
+}
+
+func (m *TemplateManager) Version(ctx context.Context, id string, number int) (*models.TemplateVersion, error) {
+	if m.VersionFunc == nil {
+		return nil, nil
+	}
+	return m.VersionFunc(ctx, id, number)
+}
+
+func (m *TemplateManager) Diff(ctx context.Context, id string, from, to int) (*models.TemplateDiff, error) {
+	if m.DiffFunc == nil {
+		return nil, nil
+	}
+	return m.DiffFunc(ctx, id, from, to)
+}
+
+func (m *TemplateManager) ValidateNotification(ctx context.Context, notification *models.Notification) error {
+	if m.ValidateNotificationFunc == nil {
+		return nil
+	}
+	return m.ValidateNotificationFunc(ctx, notification)
+}
+
+func (m *TemplateManager) RenderNotification(ctx context.Context, notification *models.Notification) error {
+	if m.RenderNotificationFunc == nil {
+		return nil
+	}
+	return m.RenderNotificationFunc(ctx, notification)
+}
+
+// BroadcastManager mocks services.BroadcastManager
+type BroadcastManager struct {
+	SubmitFunc     func(ctx context.Context, req models.BroadcastNotificationRequest, requestedBy string) (*models.BroadcastJob, error)
+	GetFunc        func(ctx context.Context, id string) (*models.BroadcastJob, error)
+	ApproveFunc    func(ctx context.Context, id, approver, comment string) (*models.BroadcastJob, error)
+	RejectFunc     func(ctx context.Context, id, approver, comment string) (*models.BroadcastJob, error)
+	AuditTrailFunc func(ctx context.Context, id string) ([]models.BroadcastAuditRecord, error)
+}
+
+func (m *BroadcastManager) Submit(ctx context.Context, req models.BroadcastNotificationRequest, requestedBy string) (*models.BroadcastJob, error) {
+	if m.SubmitFunc == nil {
+		return nil, nil
+	}
+	return m.SubmitFunc(ctx, req, requestedBy)
+}
+
+func (m *BroadcastManager) Get(ctx context.Context, id string) (*models.BroadcastJob, error) {
+	if m.GetFunc == nil {
+		return nil, nil
+	}
+	return m.GetFunc(ctx, id)
+}
+
+func (m *BroadcastManager) Approve(ctx context.Context, id, approver, comment string) (*models.BroadcastJob, error) {
+	if m.ApproveFunc == nil {
+		return nil, nil
+	}
+	return m.ApproveFunc(ctx, id, approver, comment)
+}
+
+func (m *BroadcastManager) Reject(ctx context.Context, id, approver, comment string) (*models.BroadcastJob, error) {
+	if m.RejectFunc == nil {
+		return nil, nil
+	}
+	return m.RejectFunc(ctx, id, approver, comment)
+}
+
+func (m *BroadcastManager) AuditTrail(ctx context.Context, id string) ([]models.BroadcastAuditRecord, error) {
+	if m.AuditTrailFunc == nil {
+		return nil, nil
+	}
+	return m.AuditTrailFunc(ctx, id)
+}
+
+// DigestManager mocks services.DigestManager
+type DigestManager struct {
+	CompileFunc func(ctx context.Context, period services.DigestPeriod) (*services.Digest, error)
+	SendFunc    func(ctx context.Context, period services.DigestPeriod) (*services.Digest, error)
+}
+
+func (m *DigestManager) Compile(ctx context.Context, period services.DigestPeriod) (*services.Digest, error) {
+	if m.CompileFunc == nil {
+		return nil, nil
+	}
+	return m.CompileFunc(ctx, period)
+}
+
+func (m *DigestManager) Send(ctx context.Context, period services.DigestPeriod) (*services.Digest, error) {
+	if m.SendFunc == nil {
+		return nil, nil
+	}
+	return m.SendFunc(ctx, period)
+}
+
+// UsageReporter mocks services.UsageReporter
+type UsageReporter struct {
+	APIKeysFunc     func(ctx context.Context) ([]string, error)
+	UsageSeriesFunc func(ctx context.Context, keyID string, from, to time.Time, resolution string) ([]models.UsageBucket, error)
+}
+
+func (m *UsageReporter) APIKeys(ctx context.Context) ([]string, error) {
+	if m.APIKeysFunc == nil {
+		return nil, nil
+	}
+	return m.APIKeysFunc(ctx)
+}
+
+func (m *UsageReporter) UsageSeries(ctx context.Context, keyID string, from, to time.Time, resolution string) ([]models.UsageBucket, error) {
+	if m.UsageSeriesFunc == nil {
+		return nil, nil
+	}
+	return m.UsageSeriesFunc(ctx, keyID, from, to, resolution)
+}
+
+// PresenceTracker mocks services.PresenceTracker
+type PresenceTracker struct {
+	PresenceFunc func(ctx context.Context, customerID string) (*models.CustomerPresence, error)
+	IsOnlineFunc func(ctx context.Context, customerID string) (bool, error)
+
+	ClusterConnectionsFunc func(ctx context.Context) (int, error)
+}
+
+func (m *PresenceTracker) Presence(ctx context.Context, customerID string) (*models.CustomerPresence, error) {
+	if m.PresenceFunc == nil {
+		return nil, nil
+	}
+	return m.PresenceFunc(ctx, customerID)
+}
+
+func (m *PresenceTracker) IsOnline(ctx context.Context, customerID string) (bool, error) {
+	if m.IsOnlineFunc == nil {
+		return false, nil
+	}
+	return m.IsOnlineFunc(ctx, customerID)
+}
+
+func (m *PresenceTracker) ClusterConnections(ctx context.Context) (int, error) {
+	if m.ClusterConnectionsFunc == nil {
+		return 0, nil
+	}
+	return m.ClusterConnectionsFunc(ctx)
+}
+
+// FallbackScheduler mocks services.FallbackScheduler
+type FallbackScheduler struct {
+	ScheduleFunc func(ctx context.Context, job *services.FallbackJob, due time.Time) error
+}
+
+func (m *FallbackScheduler) Schedule(ctx context.Context, job *services.FallbackJob, due time.Time) error {
+	if m.ScheduleFunc == nil {
+		return nil
+	}
+	return m.ScheduleFunc(ctx, job, due)
+}
+
+// MetadataIndexManager mocks services.MetadataIndexManager
+type MetadataIndexManager struct {
+	IndexedKeysFunc   func(ctx context.Context, tenantID string) ([]string, error)
+	RegisterKeyFunc   func(ctx context.Context, tenantID, key string) ([]string, error)
+	UnregisterKeyFunc func(ctx context.Context, tenantID, key string) ([]string, error)
+}
+
+func (m *MetadataIndexManager) IndexedKeys(ctx context.Context, tenantID string) ([]string, error) {
+	if m.IndexedKeysFunc == nil {
+		return nil, nil
+	}
+	return m.IndexedKeysFunc(ctx, tenantID)
+}
+
+func (m *MetadataIndexManager) RegisterKey(ctx context.Context, tenantID, key string) ([]string, error) {
+	if m.RegisterKeyFunc == nil {
+		return nil, nil
+	}
+	return m.RegisterKeyFunc(ctx, tenantID, key)
+}
+
+func (m *MetadataIndexManager) UnregisterKey(ctx context.Context, tenantID, key string) ([]string, error) {
+	if m.UnregisterKeyFunc == nil {
+		return nil, nil
+	}
+	return m.UnregisterKeyFunc(ctx, tenantID, key)
+}
+
+// EngagementRecorder mocks services.EngagementRecorder
+type EngagementRecorder struct {
+	RecordFunc  func(ctx context.Context, req models.RecordEngagementEventRequest) (*models.EngagementEvent, error)
+	EventsFunc  func(ctx context.Context, filter storage.EngagementFilter) ([]*models.EngagementEvent, string, error)
+	RollupFunc  func(ctx context.Context, filter storage.EngagementFilter) ([]models.EngagementRollup, error)
+	MetricsFunc func(ctx context.Context, filter storage.EngagementFilter, channel models.NotificationType) (*models.EngagementMetrics, error)
+}
+
+func (m *EngagementRecorder) Record(ctx context.Context, req models.RecordEngagementEventRequest) (*models.EngagementEvent, error) {
+	if m.RecordFunc == nil {
+		return nil, nil
+	}
+	return m.RecordFunc(ctx, req)
+}
+
+func (m *EngagementRecorder) Events(ctx context.Context, filter storage.EngagementFilter) ([]*models.EngagementEvent, string, error) {
+	if m.EventsFunc == nil {
+		return nil, "", nil
+	}
+	return m.EventsFunc(ctx, filter)
+}
+
+func (m *EngagementRecorder) Rollup(ctx context.Context, filter storage.EngagementFilter) ([]models.EngagementRollup, error) {
+	if m.RollupFunc == nil {
+		return nil, nil
+	}
+	return m.RollupFunc(ctx, filter)
+}
+
+func (m *EngagementRecorder) Metrics(ctx context.Context, filter storage.EngagementFilter, channel models.NotificationType) (*models.EngagementMetrics, error) {
+	if m.MetricsFunc == nil {
+		return &models.EngagementMetrics{From: filter.From, To: filter.To, Channel: channel}, nil
+	}
+	return m.MetricsFunc(ctx, filter, channel)
+}
+
+// ProviderPayloadReader mocks services.ProviderPayloadReader
+type ProviderPayloadReader struct {
+	ProviderPayloadsFunc func(ctx context.Context, notificationID string) ([]models.ProviderExchange, error)
+}
+
+func (m *ProviderPayloadReader) ProviderPayloads(ctx context.Context, notificationID string) ([]models.ProviderExchange, error) {
+	if m.ProviderPayloadsFunc == nil {
+		return nil, nil
+	}
+	return m.ProviderPayloadsFunc(ctx, notificationID)
+}
+
+// WebhookDeliveryReporter mocks services.WebhookDeliveryReporter
+type WebhookDeliveryReporter struct {
+	WebhookAttemptsFunc func(ctx context.Context, notificationID string) ([]models.WebhookAttempt, error)
+	WebhookStatsFunc    func(ctx context.Context) (*models.WebhookStats, error)
+}
+
+func (m *WebhookDeliveryReporter) WebhookAttempts(ctx context.Context, notificationID string) ([]models.WebhookAttempt, error) {
+	if m.WebhookAttemptsFunc == nil {
+		return nil, nil
+	}
+	return m.WebhookAttemptsFunc(ctx, notificationID)
+}
+
+func (m *WebhookDeliveryReporter) WebhookStats(ctx context.Context) (*models.WebhookStats, error) {
+	if m.WebhookStatsFunc == nil {
+		return nil, nil
+	}
+	return m.WebhookStatsFunc(ctx)
+}
+
+// RetryPolicyManager mocks services.RetryPolicyManager
+type RetryPolicyManager struct {
+	PoliciesFunc    func(ctx context.Context) ([]models.RetryPolicy, error)
+	SetPolicyFunc   func(ctx context.Context, policy models.RetryPolicy) (models.RetryPolicy, error)
+	ResetPolicyFunc func(ctx context.Context, channel models.NotificationType) error
+}
+
+func (m *RetryPolicyManager) Policies(ctx context.Context) ([]models.RetryPolicy, error) {
+	if m.PoliciesFunc == nil {
+		return nil, nil
+	}
+	return m.PoliciesFunc(ctx)
+}
+
+func (m *RetryPolicyManager) SetPolicy(ctx context.Context, policy models.RetryPolicy) (models.RetryPolicy, error) {
+	if m.SetPolicyFunc == nil {
+		return policy, nil
+	}
+	return m.SetPolicyFunc(ctx, policy)
+}
+
+func (m *RetryPolicyManager) ResetPolicy(ctx context.Context, channel models.NotificationType) error {
+	if m.ResetPolicyFunc == nil {
+		return nil
+	}
+	return m.ResetPolicyFunc(ctx, channel)
+}
+
+// ProviderThrottleReporter mocks services.ProviderThrottleReporter
+type ProviderThrottleReporter struct {
+	ThrottlesFunc func(ctx context.Context) ([]models.ProviderThrottleState, error)
+}
+
+func (m *ProviderThrottleReporter) Throttles(ctx context.Context) ([]models.ProviderThrottleState, error) {
+	if m.ThrottlesFunc == nil {
+		return nil, nil
+	}
+	return m.ThrottlesFunc(ctx)
+}
+
+// ContentScreener mocks services.ContentScreener
+type ContentScreener struct {
+	ScreenFunc func(ctx context.Context, req models.ScreeningRequest) (models.ScreeningDecision, error)
+}
+
+func (m *ContentScreener) Screen(ctx context.Context, req models.ScreeningRequest) (models.ScreeningDecision, error) {
+	if m.ScreenFunc == nil {
+		return models.ScreeningDecision{Action: models.ScreeningActionAllow}, nil
+	}
+	return m.ScreenFunc(ctx, req)
+}
+
+// LanguageDetector mocks services.LanguageDetector
+type LanguageDetector struct {
+	DetectFunc func(ctx context.Context, text string) (models.LanguageDetection, error)
+}
+
+func (m *LanguageDetector) Detect(ctx context.Context, text string) (models.LanguageDetection, error) {
+	if m.DetectFunc == nil {
+		return models.LanguageDetection{}, nil
+	}
+	return m.DetectFunc(ctx, text)
+}
+
+// Translator mocks services.Translator; without TranslateFunc texts come back as they are
+type Translator struct {
+	TranslateFunc func(ctx context.Context, texts []string, from, to string) ([]string, error)
+}
+
+func (m *Translator) Translate(ctx context.Context, texts []string, from, to string) ([]string, error) {
+	if m.TranslateFunc == nil {
+		return texts, nil
+	}
+	return m.TranslateFunc(ctx, texts, from, to)
+}
+
+// SendTimeScheduler mocks services.SendTimeScheduler
+type SendTimeScheduler struct {
+	ScheduleFunc func(ctx context.Context, notification *models.Notification, optimize *bool)
+	RecordFunc   func(ctx context.Context, notification *models.Notification)
+	ProfileFunc  func(ctx context.Context, customerID string) (models.SendTimeProfile, error)
+	StatsFunc    func(ctx context.Context) ([]models.SendTimeVariantStats, error)
+}
+
+func (m *SendTimeScheduler) Schedule(ctx context.Context, notification *models.Notification, optimize *bool) {
+	if m.ScheduleFunc != nil {
+		m.ScheduleFunc(ctx, notification, optimize)
+	}
+}
+
+func (m *SendTimeScheduler) Record(ctx context.Context, notification *models.Notification) {
+	if m.RecordFunc != nil {
+		m.RecordFunc(ctx, notification)
+	}
+}
+
+func (m *SendTimeScheduler) Profile(ctx context.Context, customerID string) (models.SendTimeProfile, error) {
+	if m.ProfileFunc == nil {
+		return models.SendTimeProfile{CustomerID: customerID, BestHour: -1}, nil
+	}
+	return m.ProfileFunc(ctx, customerID)
+}
+
+func (m *SendTimeScheduler) Stats(ctx context.Context) ([]models.SendTimeVariantStats, error) {
+	if m.StatsFunc == nil {
+		return nil, nil
+	}
+	return m.StatsFunc(ctx)
+}
+
+// DeadLetterManager mocks services.DeadLetterManager
+type DeadLetterManager struct {
+	CaptureFunc func(ctx context.Context, notification *models.Notification, reason string, deliveryErr error, attempts int) (*models.DeadLetter, error)
+	ListFunc    func(ctx context.Context, cursor string, limit int) ([]*models.DeadLetter, string, error)
+	CountFunc   func(ctx context.Context) (int64, error)
+	GetFunc     func(ctx context.Context, id string) (*models.DeadLetter, error)
+	DiscardFunc func(ctx context.Context, id string) error
+	RedriveFunc func(ctx context.Context, id string, channel models.NotificationType) (*models.DeadLetter, error)
+}
+
+func (m *DeadLetterManager) Capture(ctx context.Context, notification *models.Notification, reason string, deliveryErr error, attempts int) (*models.DeadLetter, error) {
+	if m.CaptureFunc == nil {
+		return &models.DeadLetter{NotificationID: notification.ID, Channel: notification.Type, Reason: reason, Attempts: attempts}, nil
+	}
+	return m.CaptureFunc(ctx, notification, reason, deliveryErr, attempts)
+}
+
+func (m *DeadLetterManager) List(ctx context.Context, cursor string, limit int) ([]*models.DeadLetter, string, error) {
+	if m.ListFunc == nil {
+		return nil, "", nil
+	}
+	return m.ListFunc(ctx, cursor, limit)
+}
+
+func (m *DeadLetterManager) Count(ctx context.Context) (int64, error) {
+	if m.CountFunc == nil {
+		return 0, nil
+	}
+	return m.CountFunc(ctx)
+}
+
+func (m *DeadLetterManager) Get(ctx context.Context, id string) (*models.DeadLetter, error) {
+	if m.GetFunc == nil {
+		return nil, services.ErrDeadLetterNotFound
+	}
+	return m.GetFunc(ctx, id)
+}
+
+func (m *DeadLetterManager) Discard(ctx context.Context, id string) error {
+	if m.DiscardFunc == nil {
+		return nil
+	}
+	return m.DiscardFunc(ctx, id)
+}
+
+func (m *DeadLetterManager) Redrive(ctx context.Context, id string, channel models.NotificationType) (*models.DeadLetter, error) {
+	if m.RedriveFunc == nil {
+		return nil, services.ErrDeadLetterNotFound
+	}
+	return m.RedriveFunc(ctx, id, channel)
+}
+
+// RetryScheduler mocks services.RetryScheduler
+type RetryScheduler struct {
+	MaxRetriesFunc       func(priority models.Priority) int
+	PriorityPoliciesFunc func() []models.PriorityRetryPolicy
+}
+
+func (m *RetryScheduler) MaxRetries(priority models.Priority) int {
+	if m.MaxRetriesFunc == nil {
+		return 3
+	}
+	return m.MaxRetriesFunc(priority)
+}
+
+func (m *RetryScheduler) PriorityPolicies() []models.PriorityRetryPolicy {
+	if m.PriorityPoliciesFunc == nil {
+		return nil
+	}
+	return m.PriorityPoliciesFunc()
+}
+
+// PreferenceEnforcer mocks services.PreferenceEnforcer; without CheckFunc every
+// notification is sent, without ScheduleLocalFunc and LocalTimeFunc local schedules are
+// left as UTC, and without AddressFunc notifications are addressed to their customer ID
+type PreferenceEnforcer struct {
+	PreferencesFunc    func(ctx context.Context, customerID string) (*models.CustomerPreferences, error)
+	SetPreferencesFunc func(ctx context.Context, preferences *models.CustomerPreferences, ifMatch string) (*models.CustomerPreferences, error)
+	CheckFunc          func(ctx context.Context, notification *models.Notification, at time.Time) models.PreferenceDecision
+	ScheduleLocalFunc  func(ctx context.Context, notification *models.Notification) error
+	LocalTimeFunc      func(ctx context.Context, customerID string, at time.Time) time.Time
+	AddressFunc        func(ctx context.Context, notification *models.Notification) error
+}
+
+func (m *PreferenceEnforcer) Preferences(ctx context.Context, customerID string) (*models.CustomerPreferences, error) {
+	if m.PreferencesFunc == nil {
+		return nil, services.ErrPreferencesNotFound
+	}
+	return m.PreferencesFunc(ctx, customerID)
+}
+
+func (m *PreferenceEnforcer) SetPreferences(ctx context.Context, preferences *models.CustomerPreferences, ifMatch string) (*models.CustomerPreferences, error) {
+	if m.SetPreferencesFunc == nil {
+		return preferences, nil
+	}
+	return m.SetPreferencesFunc(ctx, preferences, ifMatch)
+}
+
+func (m *PreferenceEnforcer) Check(ctx context.Context, notification *models.Notification, at time.Time) models.PreferenceDecision {
+	if m.CheckFunc == nil {
+		return models.PreferenceDecision{Action: models.PreferenceActionSend}
+	}
+	return m.CheckFunc(ctx, notification, at)
+}
+
+func (m *PreferenceEnforcer) ScheduleLocal(ctx context.Context, notification *models.Notification) error {
+	if m.ScheduleLocalFunc == nil {
+		return nil
+	}
+	return m.ScheduleLocalFunc(ctx, notification)
+}
+
+func (m *PreferenceEnforcer) LocalTime(ctx context.Context, customerID string, at time.Time) time.Time {
+	if m.LocalTimeFunc == nil {
+		return at
+	}
+	return m.LocalTimeFunc(ctx, customerID, at)
+}
+
+func (m *PreferenceEnforcer) Address(ctx context.Context, notification *models.Notification) error {
+	if m.AddressFunc == nil {
+		notification.Recipient = notification.CustomerID
+		return nil
+	}
+	return m.AddressFunc(ctx, notification)
+}
+
+// PayloadLogManager mocks services.PayloadLogManager
+type PayloadLogManager struct {
+	SettingsFunc      func(ctx context.Context) models.PayloadLogSettings
+	SetSettingsFunc   func(ctx context.Context, settings models.PayloadLogSettings) (models.PayloadLogSettings, error)
+	ResetSettingsFunc func(ctx context.Context) error
+}
+
+func (m *PayloadLogManager) Settings(ctx context.Context) models.PayloadLogSettings {
+	if m.SettingsFunc == nil {
+		return models.PayloadLogSettings{}
+	}
+	return m.SettingsFunc(ctx)
+}
+
+func (m *PayloadLogManager) SetSettings(ctx context.Context, settings models.PayloadLogSettings) (models.PayloadLogSettings, error) {
+	if m.SetSettingsFunc == nil {
+		return settings, nil
+	}
+	return m.SetSettingsFunc(ctx, settings)
+}
+
+func (m *PayloadLogManager) ResetSettings(ctx context.Context) error {
+	if m.ResetSettingsFunc == nil {
+		return nil
+	}
+	return m.ResetSettingsFunc(ctx)
+}
+
+// DeliveryStatsProvider mocks services.DeliveryStatsProvider
+type DeliveryStatsProvider struct {
+	DeliveryStatsFunc func(ctx context.Context, timeRange string) (models.DeliveryStats, error)
+}
+
+func (m *DeliveryStatsProvider) DeliveryStats(ctx context.Context, timeRange string) (models.DeliveryStats, error) {
+	if m.DeliveryStatsFunc == nil {
+		return models.DeliveryStats{TimeRange: timeRange}, nil
+	}
+	return m.DeliveryStatsFunc(ctx, timeRange)
+}
+
+// PreferenceTransfer mocks services.PreferenceTransfer
+type PreferenceTransfer struct {
+	ImportPreferencesFunc func(ctx context.Context, tenantID, format string, r io.Reader) (*models.PreferenceImportReport, error)
+	ExportPreferencesFunc func(ctx context.Context, tenantID, format string, w io.Writer) (int, error)
+}
+
+func (m *PreferenceTransfer) ImportPreferences(ctx context.Context, tenantID, format string, r io.Reader) (*models.PreferenceImportReport, error) {
+	if m.ImportPreferencesFunc == nil {
+		return &models.PreferenceImportReport{Format: format, Errors: []models.PreferenceImportError{}}, nil
+	}
+	return m.ImportPreferencesFunc(ctx, tenantID, format, r)
+}
+
+func (m *PreferenceTransfer) ExportPreferences(ctx context.Context, tenantID, format string, w io.Writer) (int, error) {
+	if m.ExportPreferencesFunc == nil {
+		return 0, nil
+	}
+	return m.ExportPreferencesFunc(ctx, tenantID, format, w)
+}
+
+// LinkVerifier mocks services.LinkVerifier; without functions every signature is valid
+type LinkVerifier struct {
+	VerifyOpenFunc  func(notificationID, signature string) bool
+	VerifyClickFunc func(notificationID, target, signature string) bool
+}
+
+func (m *LinkVerifier) VerifyOpen(notificationID, signature string) bool {
+	if m.VerifyOpenFunc == nil {
+		return true
+	}
+	return m.VerifyOpenFunc(notificationID, signature)
+}
+
+func (m *LinkVerifier) VerifyClick(notificationID, target, signature string) bool {
+	if m.VerifyClickFunc == nil {
+		return true
+	}
+	return m.VerifyClickFunc(notificationID, target, signature)
+}
+
+// SigningKeyManager mocks services.SigningKeyManager; without functions there are no keys
+type SigningKeyManager struct {
+	ListFunc   func(ctx context.Context) ([]models.SigningKey, error)
+	GetFunc    func(ctx context.Context, id string) (*models.SigningKey, error)
+	CreateFunc func(ctx context.Context, req models.SigningKeyRequest) (*models.SigningKey, error)
+	UpdateFunc func(ctx context.Context, id string, req models.SigningKeyRequest) (*models.SigningKey, error)
+	DeleteFunc func(ctx context.Context, id string) error
+	RotateFunc func(ctx context.Context) (*models.SigningKey, error)
+	JWKSFunc   func(ctx context.Context) (models.JWKSet, error)
+}
+
+func (m *SigningKeyManager) List(ctx context.Context) ([]models.SigningKey, error) {
+	if m.ListFunc == nil {
+		return []models.SigningKey{}, nil
+	}
+	return m.ListFunc(ctx)
+}
+
+func (m *SigningKeyManager) Get(ctx context.Context, id string) (*models.SigningKey, error) {
+	if m.GetFunc == nil {
+		return nil, services.ErrSigningKeyNotFound
+	}
+	return m.GetFunc(ctx, id)
+}
+
+func (m *SigningKeyManager) Create(ctx context.Context, req models.SigningKeyRequest) (*models.SigningKey, error) {
+	if m.CreateFunc == nil {
+		return &models.SigningKey{ActivatesAt: time.Now(), ExpiresAt: req.ExpiresAt}, nil
+	}
+	return m.CreateFunc(ctx, req)
+}
+
+func (m *SigningKeyManager) Update(ctx context.Context, id string, req models.SigningKeyRequest) (*models.SigningKey, error) {
+	if m.UpdateFunc == nil {
+		return nil, services.ErrSigningKeyNotFound
+	}
+	return m.UpdateFunc(ctx, id, req)
+}
+
+func (m *SigningKeyManager) Delete(ctx context.Context, id string) error {
+	if m.DeleteFunc == nil {
+		return services.ErrSigningKeyNotFound
+	}
+	return m.DeleteFunc(ctx, id)
+}
+
+func (m *SigningKeyManager) Rotate(ctx context.Context) (*models.SigningKey, error) {
+	if m.RotateFunc == nil {
+		return &models.SigningKey{ActivatesAt: time.Now(), Status: models.SigningKeyActive}, nil
+	}
+	return m.RotateFunc(ctx)
+}
+
+func (m *SigningKeyManager) JWKS(ctx context.Context) (models.JWKSet, error) {
+	if m.JWKSFunc == nil {
+		return models.JWKSet{Keys: []models.JWK{}}, nil
+	}
+	return m.JWKSFunc(ctx)
+}
+
+// IdempotencyGuard mocks services.IdempotencyGuard; without functions every key is claimed
+type IdempotencyGuard struct {
+	ClaimFunc    func(ctx context.Context, req models.CreateNotificationRequest, notificationID string) (string, bool, error)
+	CompleteFunc func(ctx context.Context, req models.CreateNotificationRequest, notificationID string) error
+	ReleaseFunc  func(ctx context.Context, req models.CreateNotificationRequest, notificationID string) error
+}
+
+func (m *IdempotencyGuard) Claim(ctx context.Context, req models.CreateNotificationRequest, notificationID string) (string, bool, error) {
+	if m.ClaimFunc == nil {
+		return notificationID, true, nil
+	}
+	return m.ClaimFunc(ctx, req, notificationID)
+}
+
+func (m *IdempotencyGuard) Complete(ctx context.Context, req models.CreateNotificationRequest, notificationID string) error {
+	if m.CompleteFunc == nil {
+		return nil
+	}
+	return m.CompleteFunc(ctx, req, notificationID)
+}
+
+func (m *IdempotencyGuard) Release(ctx context.Context, req models.CreateNotificationRequest, notificationID string) error {
+	if m.ReleaseFunc == nil {
+		return nil
+	}
+	return m.ReleaseFunc(ctx, req, notificationID)
+}
+
+// DuplicateGuard mocks services.DuplicateGuard; without functions nothing is a duplicate
+type DuplicateGuard struct {
+	ClaimFunc    func(ctx context.Context, notification *models.Notification, dedupe *bool) (string, bool, error)
+	ReleaseFunc  func(ctx context.Context, notification *models.Notification) error
+	TakeoverFunc func(ctx context.Context, notification *models.Notification, original string) (bool, error)
+}
+
+func (m *DuplicateGuard) Claim(ctx context.Context, notification *models.Notification, dedupe *bool) (string, bool, error) {
+	if m.ClaimFunc == nil {
+		return "", false, nil
+	}
+	return m.ClaimFunc(ctx, notification, dedupe)
+}
+
+func (m *DuplicateGuard) Release(ctx context.Context, notification *models.Notification) error {
+	if m.ReleaseFunc == nil {
+		return nil
+	}
+	return m.ReleaseFunc(ctx, notification)
+}
+
+func (m *DuplicateGuard) Takeover(ctx context.Context, notification *models.Notification, original string) (bool, error) {
+	if m.TakeoverFunc == nil {
+		return false, nil
+	}
+	return m.TakeoverFunc(ctx, notification, original)
+}
+
+// RedriveManager mocks services.RedriveManager
+type RedriveManager struct {
+	SubmitFunc  func(ctx context.Context, req models.NotificationRedriveRequest, requestedBy string) (*models.RedriveJob, error)
+	GetFunc     func(ctx context.Context, id string) (*models.RedriveJob, error)
+	ConfirmFunc func(ctx context.Context, id, confirmedBy string, matched int) (*models.RedriveJob, error)
+}
+
+func (m *RedriveManager) Submit(ctx context.Context, req models.NotificationRedriveRequest, requestedBy string) (*models.RedriveJob, error) {
+	if m.SubmitFunc == nil {
+		return nil, nil
+	}
+	return m.SubmitFunc(ctx, req, requestedBy)
+}
+
+func (m *RedriveManager) Get(ctx context.Context, id string) (*models.RedriveJob, error) {
+	if m.GetFunc == nil {
+		return nil, nil
+	}
+	return m.GetFunc(ctx, id)
+}
+
+func (m *RedriveManager) Confirm(ctx context.Context, id, confirmedBy string, matched int) (*models.RedriveJob, error) {
+	if m.ConfirmFunc == nil {
+		return nil, nil
+	}
+	return m.ConfirmFunc(ctx, id, confirmedBy, matched)
+}
+
+// AnnouncementManager mocks services.AnnouncementManager
+type AnnouncementManager struct {
+	CreateFunc func(ctx context.Context, req models.CreateAnnouncementRequest, createdBy string) (*models.Announcement, error)
+	ListFunc   func(ctx context.Context, includeScheduled bool) ([]*models.Announcement, error)
+	EndFunc    func(ctx context.Context, id string) (*models.Announcement, error)
+}
+
+func (m *AnnouncementManager) Create(ctx context.Context, req models.CreateAnnouncementRequest, createdBy string) (*models.Announcement, error) {
+	if m.CreateFunc == nil {
+		return nil, nil
+	}
+	return m.CreateFunc(ctx, req, createdBy)
+}
+
+func (m *AnnouncementManager) List(ctx context.Context, includeScheduled bool) ([]*models.Announcement, error) {
+	if m.ListFunc == nil {
+		return nil, nil
+	}
+	return m.ListFunc(ctx, includeScheduled)
+}
+
+func (m *AnnouncementManager) End(ctx context.Context, id string) (*models.Announcement, error) {
+	if m.EndFunc == nil {
+		return nil, nil
+	}
+	return m.EndFunc(ctx, id)
+}
+
+// ReadinessProber mocks services.ReadinessProber
+type ReadinessProber struct {
+	CheckFunc func(ctx context.Context) models.ReadinessReport
+}
+
+func (m *ReadinessProber) Check(ctx context.Context) models.ReadinessReport {
+	if m.CheckFunc == nil {
+		return models.ReadinessReport{}
+	}
+	return m.CheckFunc(ctx)
+}
+
+// TestSender mocks services.TestSender
+type TestSender struct {
+	SendFunc func(ctx context.Context, req models.TestSendRequest, requestedBy string) (*models.TestSendResult, error)
+}
+
+func (m *TestSender) Send(ctx context.Context, req models.TestSendRequest, requestedBy string) (*models.TestSendResult, error) {
+	if m.SendFunc == nil {
+		return nil, nil
+	}
+	return m.SendFunc(ctx, req, requestedBy)
+}
+
+// DeviceManager mocks services.DeviceManager
+type DeviceManager struct {
+	RegisterFunc func(ctx context.Context, customerID, deviceID string, req models.RegisterDeviceRequest) (*models.Device, bool, error)
+	ListFunc     func(ctx context.Context, customerID string, platform models.DevicePlatform) ([]*models.Device, error)
+	DeleteFunc   func(ctx context.Context, customerID, deviceID string) error
+	ResolveFunc  func(ctx context.Context, customerID string, target *models.DeviceTarget) ([]*models.Device, error)
+}
+
+func (m *DeviceManager) Register(ctx context.Context, customerID, deviceID string, req models.RegisterDeviceRequest) (*models.Device, bool, error) {
+	if m.RegisterFunc == nil {
+		return nil, false, nil
+	}
+	return m.RegisterFunc(ctx, customerID, deviceID, req)
+}
+
+func (m *DeviceManager) List(ctx context.Context, customerID string, platform models.DevicePlatform) ([]*models.Device, error) {
+	if m.ListFunc == nil {
+		return nil, nil
+	}
+	return m.ListFunc(ctx, customerID, platform)
+}
+
+func (m *DeviceManager) Delete(ctx context.Context, customerID, deviceID string) error {
+	if m.DeleteFunc == nil {
+		return nil
+	}
+	return m.DeleteFunc(ctx, customerID, deviceID)
+}
+
+func (m *DeviceManager) Resolve(ctx context.Context, customerID string, target *models.DeviceTarget) ([]*models.Device, error) {
+	if m.ResolveFunc == nil {
+		return nil, nil
+	}
+	return m.ResolveFunc(ctx, customerID, target)
+}
+
+// RecipientValidator mocks services.RecipientValidator
+type RecipientValidator struct {
+	NormalizeFunc func(notification *models.Notification) error
+}
+
+func (m *RecipientValidator) Normalize(notification *models.Notification) error {
+	if m.NormalizeFunc == nil {
+		return nil
+	}
+	return m.NormalizeFunc(notification)
+}
+
+// BlackoutManager mocks services.BlackoutManager; without HoldFunc nothing is held
+type BlackoutManager struct {
+	HoldFunc           func(ctx context.Context, notification *models.Notification, due time.Time) *time.Time
+	RecordDeferralFunc func(ctx context.Context, notification *models.Notification)
+	CalendarsFunc      func(ctx context.Context) ([]*models.BlackoutStatus, error)
+	CalendarFunc       func(ctx context.Context, tenantID string) (*models.BlackoutStatus, error)
+	SetCalendarFunc    func(ctx context.Context, tenantID string, windows []models.BlackoutWindow) (*models.BlackoutCalendar, error)
+	DeleteCalendarFunc func(ctx context.Context, tenantID string) error
+}
+
+func (m *BlackoutManager) Hold(ctx context.Context, notification *models.Notification, due time.Time) *time.Time {
+	if m.HoldFunc == nil {
+		return nil
+	}
+	return m.HoldFunc(ctx, notification, due)
+}
+
+func (m *BlackoutManager) RecordDeferral(ctx context.Context, notification *models.Notification) {
+	if m.RecordDeferralFunc != nil {
+		m.RecordDeferralFunc(ctx, notification)
+	}
+}
+
+func (m *BlackoutManager) Calendars(ctx context.Context) ([]*models.BlackoutStatus, error) {
+	if m.CalendarsFunc == nil {
+		return []*models.BlackoutStatus{}, nil
+	}
+	return m.CalendarsFunc(ctx)
+}
+
+func (m *BlackoutManager) Calendar(ctx context.Context, tenantID string) (*models.BlackoutStatus, error) {
+	if m.CalendarFunc == nil {
+		return nil, services.ErrBlackoutCalendarNotFound
+	}
+	return m.CalendarFunc(ctx, tenantID)
+}
+
+func (m *BlackoutManager) SetCalendar(ctx context.Context, tenantID string, windows []models.BlackoutWindow) (*models.BlackoutCalendar, error) {
+	if m.SetCalendarFunc == nil {
+		return &models.BlackoutCalendar{TenantID: tenantID, Windows: windows}, nil
+	}
+	return m.SetCalendarFunc(ctx, tenantID, windows)
+}
+
+func (m *BlackoutManager) DeleteCalendar(ctx context.Context, tenantID string) error {
+	if m.DeleteCalendarFunc == nil {
+		return nil
+	}
+	return m.DeleteCalendarFunc(ctx, tenantID)
+}
+
+// SMSConsentManager mocks services.SMSConsentManager
+type SMSConsentManager struct {
+	HandleInboundFunc   func(ctx context.Context, message models.InboundSMS) (*models.SMSKeywordResult, error)
+	SMSConsentStatsFunc func(ctx context.Context, timeRange string) (models.SMSConsentStats, error)
+}
+
+func (m *SMSConsentManager) HandleInbound(ctx context.Context, message models.InboundSMS) (*models.SMSKeywordResult, error) {
+	if m.HandleInboundFunc == nil {
+		return &models.SMSKeywordResult{}, nil
+	}
+	return m.HandleInboundFunc(ctx, message)
+}
+
+func (m *SMSConsentManager) SMSConsentStats(ctx context.Context, timeRange string) (models.SMSConsentStats, error) {
+	if m.SMSConsentStatsFunc == nil {
+		return models.SMSConsentStats{}, nil
+	}
+	return m.SMSConsentStatsFunc(ctx, timeRange)
+}
+
+// EmailReplyManager mocks services.EmailReplyManager; without HandleInboundFunc no email
+// is correlated
+type EmailReplyManager struct {
+	HandleInboundFunc func(ctx context.Context, email models.InboundEmail) (*models.InboundEmailResult, error)
+	RepliesFunc       func(ctx context.Context, notificationID string) ([]*models.EmailReply, error)
+}
+
+func (m *EmailReplyManager) HandleInbound(ctx context.Context, email models.InboundEmail) (*models.InboundEmailResult, error) {
+	if m.HandleInboundFunc == nil {
+		return &models.InboundEmailResult{}, nil
+	}
+	return m.HandleInboundFunc(ctx, email)
+}
+
+func (m *EmailReplyManager) Replies(ctx context.Context, notificationID string) ([]*models.EmailReply, error) {
+	if m.RepliesFunc == nil {
+		return []*models.EmailReply{}, nil
+	}
+	return m.RepliesFunc(ctx, notificationID)
+}
+
+// CustomerDigestManager mocks services.CustomerDigestManager; without HoldFunc no
+// notification is batched
+type CustomerDigestManager struct {
+	HoldFunc    func(ctx context.Context, notification *models.Notification, digest *bool) bool
+	PendingFunc func(ctx context.Context, customerID string) ([]*models.CustomerDigest, error)
+	FlushFunc   func(ctx context.Context, customerID string) ([]*models.CustomerDigest, error)
+}
+
+func (m *CustomerDigestManager) Hold(ctx context.Context, notification *models.Notification, digest *bool) bool {
+	if m.HoldFunc == nil {
+		return false
+	}
+	return m.HoldFunc(ctx, notification, digest)
+}
+
+func (m *CustomerDigestManager) Pending(ctx context.Context, customerID string) ([]*models.CustomerDigest, error) {
+	if m.PendingFunc == nil {
+		return []*models.CustomerDigest{}, nil
+	}
+	return m.PendingFunc(ctx, customerID)
+}
+
+func (m *CustomerDigestManager) Flush(ctx context.Context, customerID string) ([]*models.CustomerDigest, error) {
+	if m.FlushFunc == nil {
+		return []*models.CustomerDigest{}, nil
+	}
+	return m.FlushFunc(ctx, customerID)
+}
+
+// ConversationReader mocks services.ConversationReader; without ConversationFunc no
+// conversation is found
+type ConversationReader struct {
+	ConversationFunc func(ctx context.Context, id string) (*models.Conversation, error)
+}
+
+func (m *ConversationReader) Conversation(ctx context.Context, id string) (*models.Conversation, error) {
+	if m.ConversationFunc == nil {
+		return nil, services.ErrConversationNotFound
+	}
+	return m.ConversationFunc(ctx, id)
+}
+
+// ResidencyReporter mocks services.ResidencyReporter
+type ResidencyReporter struct {
+	StatusFunc func(ctx context.Context) models.DataResidency
+}
+
+func (m *ResidencyReporter) Status(ctx context.Context) models.DataResidency {
+	if m.StatusFunc == nil {
+		return models.DataResidency{Regions: []models.RegionResidency{}}
+	}
+	return m.StatusFunc(ctx)
+}
+
+var (
+	_ services.NotificationManager      = (*NotificationManager)(nil)
+	_ services.ChannelSender            = (*ChannelSender)(nil)
+	_ services.RealtimeHub              = (*RealtimeHub)(nil)
+	_ services.TemplateManager          = (*TemplateManager)(nil)
+	_ services.BroadcastManager         = (*BroadcastManager)(nil)
+	_ services.DigestManager            = (*DigestManager)(nil)
+	_ services.UsageReporter            = (*UsageReporter)(nil)
+	_ services.MetadataIndexManager     = (*MetadataIndexManager)(nil)
+	_ services.PresenceTracker          = (*PresenceTracker)(nil)
+	_ services.FallbackScheduler        = (*FallbackScheduler)(nil)
+	_ services.EngagementRecorder       = (*EngagementRecorder)(nil)
+	_ services.ProviderPayloadReader    = (*ProviderPayloadReader)(nil)
+	_ services.WebhookDeliveryReporter  = (*WebhookDeliveryReporter)(nil)
+	_ services.RetryPolicyManager       = (*RetryPolicyManager)(nil)
+	_ services.ProviderThrottleReporter = (*ProviderThrottleReporter)(nil)
+	_ services.ContentScreener          = (*ContentScreener)(nil)
+	_ services.SendTimeScheduler        = (*SendTimeScheduler)(nil)
+	_ services.LanguageDetector         = (*LanguageDetector)(nil)
+	_ services.Translator               = (*Translator)(nil)
+	_ services.DeadLetterManager        = (*DeadLetterManager)(nil)
+	_ services.RetryScheduler           = (*RetryScheduler)(nil)
+	_ services.PreferenceEnforcer       = (*PreferenceEnforcer)(nil)
+	_ services.PayloadLogManager        = (*PayloadLogManager)(nil)
+	_ services.PreferenceTransfer       = (*PreferenceTransfer)(nil)
+	_ services.LinkVerifier             = (*LinkVerifier)(nil)
+	_ services.DeliveryStatsProvider    = (*DeliveryStatsProvider)(nil)
+	_ services.SigningKeyManager        = (*SigningKeyManager)(nil)
+	_ services.IdempotencyGuard         = (*IdempotencyGuard)(nil)
+	_ services.DuplicateGuard           = (*DuplicateGuard)(nil)
+	_ services.RedriveManager           = (*RedriveManager)(nil)
+	_ services.AnnouncementManager      = (*AnnouncementManager)(nil)
+	_ services.ReadinessProber          = (*ReadinessProber)(nil)
+	_ services.TestSender               = (*TestSender)(nil)
+)
+
+// ProviderRoutingReporter mocks services.ProviderRoutingReporter
+type ProviderRoutingReporter struct {
+	StatusFunc func() []models.ProviderRoute
+}
+
+func (m *ProviderRoutingReporter) Status() []models.ProviderRoute {
+	if m.StatusFunc == nil {
+		return []models.ProviderRoute{}
+	}
+	return m.StatusFunc()
+}
@@ -0,0 +1,142 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"notification-service/internal/models"
+	"notification-service/internal/storage"
+)
+
+// NotificationRepository mocks storage.NotificationRepository
+type NotificationRepository struct {
+	GetNotificationFunc    func(ctx context.Context, id string) (*models.Notification, error)
+	ListNotificationsFunc  func(ctx context.Context, filter storage.NotificationFilter) ([]*models.Notification, string, error)
+	CountNotificationsFunc func(ctx context.Context, filter storage.NotificationFilter) (int64, error)
+	UpsertNotificationFunc func(ctx context.Context, notification *models.Notification) error
+	DeleteNotificationFunc func(ctx context.Context, id string) error
+	DeliveryRollupFunc     func(ctx context.Context, since time.Time) ([]models.DeliveryRollup, error)
+	TemplateFailuresFunc   func(ctx context.Context, since time.Time, limit int) ([]models.TemplateFailures, error)
+	QueueDepthsFunc        func(ctx context.Context) ([]models.QueueDepth, error)
+	PingFunc               func(ctx context.Context) error
+}
+
+func (m *NotificationRepository) GetNotification(ctx context.Context, id string) (*models.Notification, error) {
+	if m.GetNotificationFunc == nil {
+		return nil, nil
+	}
+	return m.GetNotificationFunc(ctx, id)
+}
+
+func (m *NotificationRepository) ListNotifications(ctx context.Context, filter storage.NotificationFilter) ([]*models.Notification, string, error) {
+	if m.ListNotificationsFunc == nil {
+		return nil, "", nil
+	}
+	return m.ListNotificationsFunc(ctx, filter)
+}
+
+func (m *NotificationRepository) CountNotifications(ctx context.Context, filter storage.NotificationFilter) (int64, error) {
+	if m.CountNotificationsFunc == nil {
+		return 0, nil
+	}
+	return m.CountNotificationsFunc(ctx, filter)
+}
+
+func (m *NotificationRepository) UpsertNotification(ctx context.Context, notification *models.Notification) error {
+	if m.UpsertNotificationFunc == nil {
+		return nil
+	}
+	return m.UpsertNotificationFunc(ctx, notification)
+}
+
+func (m *NotificationRepository) DeleteNotification(ctx context.Context, id string) error {
+	if m.DeleteNotificationFunc == nil {
+		return nil
+	}
+	return m.DeleteNotificationFunc(ctx, id)
+}
+
+func (m *NotificationRepository) DeliveryRollup(ctx context.Context, since time.Time) ([]models.DeliveryRollup, error) {
+	if m.DeliveryRollupFunc == nil {
+		return nil, nil
+	}
+	return m.DeliveryRollupFunc(ctx, since)
+}
+
+func (m *NotificationRepository) TemplateFailures(ctx context.Context, since time.Time, limit int) ([]models.TemplateFailures, error) {
+	if m.TemplateFailuresFunc == nil {
+		return nil, nil
+	}
+	return m.TemplateFailuresFunc(ctx, since, limit)
+}
+
+func (m *NotificationRepository) QueueDepths(ctx context.Context) ([]models.QueueDepth, error) {
+	if m.QueueDepthsFunc == nil {
+		return nil, nil
+	}
+	return m.QueueDepthsFunc(ctx)
+}
+
+func (m *NotificationRepository) Ping(ctx context.Context) error {
+	if m.PingFunc == nil {
+		return nil
+	}
+	return m.PingFunc(ctx)
+}
+
+func (m *NotificationRepository) Close() error {
+	return nil
+}
+
+// EngagementRepository mocks storage.EngagementRepository
+type EngagementRepository struct {
+	AppendEngagementEventFunc func(ctx context.Context, event *models.EngagementEvent) error
+	ListEngagementEventsFunc  func(ctx context.Context, filter storage.EngagementFilter) ([]*models.EngagementEvent, string, error)
+	EngagementRollupFunc      func(ctx context.Context, filter storage.EngagementFilter) ([]models.EngagementRollup, error)
+	EngagementHoursFunc       func(ctx context.Context, filter storage.EngagementFilter) ([24]int64, error)
+	EngagementRatesFunc       func(ctx context.Context, filter storage.EngagementFilter, channel models.NotificationType) ([]models.EngagementRates, error)
+}
+
+func (m *EngagementRepository) AppendEngagementEvent(ctx context.Context, event *models.EngagementEvent) error {
+	if m.AppendEngagementEventFunc == nil {
+		return nil
+	}
+	return m.AppendEngagementEventFunc(ctx, event)
+}
+
+func (m *EngagementRepository) ListEngagementEvents(ctx context.Context, filter storage.EngagementFilter) ([]*models.EngagementEvent, string, error) {
+	if m.ListEngagementEventsFunc == nil {
+		return nil, "", nil
+	}
+	return m.ListEngagementEventsFunc(ctx, filter)
+}
+
+func (m *EngagementRepository) EngagementRollup(ctx context.Context, filter storage.EngagementFilter) ([]models.EngagementRollup, error) {
+	if m.EngagementRollupFunc == nil {
+		return nil, nil
+	}
+	return m.EngagementRollupFunc(ctx, filter)
+}
+
+func (m *EngagementRepository) EngagementHours(ctx context.Context, filter storage.EngagementFilter) ([24]int64, error) {
+	if m.EngagementHoursFunc == nil {
+		return [24]int64{}, nil
+	}
+	return m.EngagementHoursFunc(ctx, filter)
+}
+
+func (m *EngagementRepository) EngagementRates(ctx context.Context, filter storage.EngagementFilter, channel models.NotificationType) ([]models.EngagementRates, error) {
+	if m.EngagementRatesFunc == nil {
+		return nil, nil
+	}
+	return m.EngagementRatesFunc(ctx, filter, channel)
+}
+
+func (m *EngagementRepository) Close() error {
+	return nil
+}
+
+var (
+	_ storage.NotificationRepository = (*NotificationRepository)(nil)
+	_ storage.EngagementRepository   = (*EngagementRepository)(nil)
+)
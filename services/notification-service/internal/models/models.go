@@ -1,9 +1,13 @@
 package models
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -19,17 +23,29 @@ const (
 	NotificationTypePush      NotificationType = "push"
 	NotificationTypeWebSocket NotificationType = "websocket"
 	NotificationTypeWebhook   NotificationType = "webhook"
+	NotificationTypeTeams     NotificationType = "teams"
 )
 
 // NotificationStatus represents the delivery status
 type NotificationStatus string
 
 const (
-	NotificationStatusPending   NotificationStatus = "pending"
-	NotificationStatusSent      NotificationStatus = "sent"
-	NotificationStatusDelivered NotificationStatus = "delivered"
-	NotificationStatusFailed    NotificationStatus = "failed"
-	NotificationStatusRetrying  NotificationStatus = "retrying"
+	NotificationStatusPending    NotificationStatus = "pending"
+	NotificationStatusSent       NotificationStatus = "sent"
+	NotificationStatusDelivered  NotificationStatus = "delivered"
+	NotificationStatusFailed     NotificationStatus = "failed"
+	NotificationStatusRetrying   NotificationStatus = "retrying"
+	NotificationStatusCancelled  NotificationStatus = "cancelled"
+	NotificationStatusBlocked    NotificationStatus = "blocked"
+	NotificationStatusSuppressed NotificationStatus = "suppressed"
+
+	// NotificationStatusDuplicateSuppressed is a notification with the same content as
+	// one created for its customer on its channel within the dedupe window
+	NotificationStatusDuplicateSuppressed NotificationStatus = "duplicate_suppressed"
+
+	// NotificationStatusBatched is a low-priority notification held for its customer's
+	// digest, sent with the others of the digest when it is flushed
+	NotificationStatusBatched NotificationStatus = "batched"
 )
 
 // Priority levels for notifications
@@ -47,12 +63,16 @@ type Notification struct {
 	ID          string             `json:"id" db:"id"`
 	Type        NotificationType   `json:"type" db:"type"`
 	Recipient   string             `json:"recipient" db:"recipient"`
+	// RawRecipient is the email address or phone number as given, before normalization
+	RawRecipient string            `json:"raw_recipient,omitempty" db:"raw_recipient"`
 	Subject     string             `json:"subject" db:"subject"`
 	Message     string             `json:"message" db:"message"`
 	Data        map[string]interface{} `json:"data" db:"data"`
 	Status      NotificationStatus `json:"status" db:"status"`
 	Priority    Priority           `json:"priority" db:"priority"`
 	TemplateID  string             `json:"template_id,omitempty" db:"template_id"`
+	// TemplateVersion is the template version the notification was rendered from
+	TemplateVersion int            `json:"template_version,omitempty" db:"template_version"`
 	CustomerID  string             `json:"customer_id" db:"customer_id"`
 	OrderID     string             `json:"order_id,omitempty" db:"order_id"`
 	CreatedAt   time.Time          `json:"created_at" db:"created_at"`
@@ -60,12 +80,89 @@ type Notification struct {
 	SentAt      *time.Time         `json:"sent_at,omitempty" db:"sent_at"`
 	DeliveredAt *time.Time         `json:"delivered_at,omitempty" db:"delivered_at"`
 	FailedAt    *time.Time         `json:"failed_at,omitempty" db:"failed_at"`
+	CancelledAt *time.Time         `json:"cancelled_at,omitempty" db:"cancelled_at"`
 	RetryCount  int                `json:"retry_count" db:"retry_count"`
 	MaxRetries  int                `json:"max_retries" db:"max_retries"`
 	ErrorMessage string            `json:"error_message,omitempty" db:"error_message"`
+	ErrorClass  ErrorClass         `json:"error_class,omitempty" db:"error_class"`
 	Metadata    map[string]interface{} `json:"metadata" db:"metadata"`
+	Version     int                `json:"version" db:"version"`
+	HTMLMessage string             `json:"html_message,omitempty" db:"html_message"`
+	Attachments []Attachment       `json:"attachments,omitempty" db:"attachments"`
+	Screening   *ScreeningDecision `json:"screening,omitempty" db:"screening"`
+	Locale      string             `json:"locale,omitempty" db:"locale"`
+	// Region is the data region the notification is stored in, set when its tenant is
+	// pinned to one or the deployment names its own region
+	Region      string             `json:"region,omitempty" db:"region"`
+	// ResendOf is the notification this one re-sends
+	ResendOf    string             `json:"resend_of,omitempty" db:"resend_of"`
+	Push        *PushContent       `json:"push,omitempty" db:"push"`
+	// Card is the Adaptive Card a teams notification posts, given on the request or
+	// rendered from its template's card
+	Card        json.RawMessage    `json:"card,omitempty" db:"card"`
+	// CollapseKey makes a newer push or WebSocket notification to the same customer
+	// replace this one, which then records the notification that replaced it
+	CollapseKey string             `json:"collapse_key,omitempty" db:"collapse_key"`
+	Replaces    string             `json:"replaces,omitempty" db:"replaces"`
+	ReplacedBy  string             `json:"replaced_by,omitempty" db:"replaced_by"`
+	// DuplicateOf is the earlier notification a duplicate_suppressed one repeats
+	DuplicateOf string             `json:"duplicate_of,omitempty" db:"duplicate_of"`
+	// ConversationID groups the notification with the related ones of its order or
+	// customer, on any channel, and their replies
+	ConversationID string          `json:"conversation_id,omitempty" db:"conversation_id"`
+	// ThreadReferences are the IDs of the emails sent earlier in the conversation to the
+	// same recipient, oldest first, which mail clients thread an email under
+	ThreadReferences []string      `json:"thread_references,omitempty" db:"thread_references"`
+	// DigestID is the customer digest a batched notification was held for
+	DigestID string                `json:"digest_id,omitempty" db:"digest_id"`
+	// Target sends a push notification to some of its customer's registered devices
+	// instead of the recipient token
+	Target      *DeviceTarget      `json:"target,omitempty" db:"target"`
+}
+
+// Attachment is a file sent with an email notification; Content is base64 in JSON
+type Attachment struct {
+	Filename    string `json:"filename" binding:"required"`
+	ContentType string `json:"content_type,omitempty"`
+	Content     []byte `json:"content" binding:"required"`
+}
+
+// PushContent is the rich content of a push notification. Title and Body default to the
+// notification's subject and message. It is mapped to each platform's payload when sent:
+// FCM notification and data fields, and an APNs alert with a category.
+type PushContent struct {
+	// Silent sends only Data, waking the app to refresh its state without showing
+	// anything; title, body, image, actions, sound and badge are not allowed with it
+	Silent   bool   `json:"silent,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Body     string `json:"body,omitempty"`
+	ImageURL string `json:"image_url,omitempty" binding:"omitempty,url"`
+	// DeepLink opens a screen of the app when the notification is tapped
+	DeepLink string       `json:"deep_link,omitempty" binding:"omitempty,uri"`
+	Actions  []PushAction `json:"actions,omitempty" binding:"dive"`
+	// Category names the action set registered by the iOS app; required with actions
+	Category string            `json:"category,omitempty"`
+	Sound    string            `json:"sound,omitempty"`
+	Badge    *int              `json:"badge,omitempty" binding:"omitempty,min=0"`
+	Data     map[string]string `json:"data,omitempty"`
+}
+
+// PushAction is a button on a push notification
+type PushAction struct {
+	ID       string `json:"id" binding:"required"`
+	Title    string `json:"title" binding:"required"`
+	DeepLink string `json:"deep_link,omitempty" binding:"omitempty,uri"`
 }
 
+// TemplateState tracks a template through the publishing workflow
+type TemplateState string
+
+const (
+	TemplateStateDraft           TemplateState = "draft"
+	TemplateStatePendingApproval TemplateState = "pending_approval"
+	TemplateStatePublished       TemplateState = "published"
+)
+
 // NotificationTemplate represents a reusable notification template
 type NotificationTemplate struct {
 	ID          string                 `json:"id" db:"id"`
@@ -78,6 +175,82 @@ type NotificationTemplate struct {
 	CreatedAt   time.Time              `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time              `json:"updated_at" db:"updated_at"`
 	IsActive    bool                   `json:"is_active" db:"is_active"`
+	State       TemplateState          `json:"state" db:"state"`
+	PublishedAt *time.Time             `json:"published_at,omitempty" db:"published_at"`
+
+	// Version is the template's current version, one more with every edit or rollback;
+	// PublishedVersion is the version last published
+	Version          int `json:"version" db:"version"`
+	PublishedVersion int `json:"published_version,omitempty" db:"published_version"`
+
+	// Optional JSON Schemas that notifications using this template must satisfy
+	DataSchema     json.RawMessage `json:"data_schema,omitempty" db:"data_schema"`
+	MetadataSchema json.RawMessage `json:"metadata_schema,omitempty" db:"metadata_schema"`
+
+	// Locale is the language of Subject and Body ("en" when unset); Localizations holds
+	// the same template in other locales, keyed by BCP 47 tag such as "fr" or "pt-BR"
+	Locale        string                          `json:"locale,omitempty" db:"locale"`
+	Localizations map[string]TemplateLocalization `json:"localizations,omitempty" db:"localizations"`
+
+	// Card is the Adaptive Card layout of a teams template; its string values are
+	// templates like Subject and Body
+	Card json.RawMessage `json:"card,omitempty" db:"card"`
+}
+
+// TemplateVersion is an immutable snapshot of a template's content, written when the
+// template is created and on every edit and rollback
+type TemplateVersion struct {
+	TemplateID     string                          `json:"template_id"`
+	Version        int                             `json:"version"`
+	Name           string                          `json:"name"`
+	Type           NotificationType                `json:"type"`
+	Subject        string                          `json:"subject"`
+	Body           string                          `json:"body"`
+	Variables      []string                        `json:"variables"`
+	Metadata       map[string]interface{}          `json:"metadata"`
+	DataSchema     json.RawMessage                 `json:"data_schema,omitempty"`
+	MetadataSchema json.RawMessage                 `json:"metadata_schema,omitempty"`
+	Locale         string                          `json:"locale,omitempty"`
+	Localizations  map[string]TemplateLocalization `json:"localizations,omitempty"`
+	Card           json.RawMessage                 `json:"card,omitempty"`
+	CreatedAt      time.Time                       `json:"created_at"`
+
+	// RolledBackFrom is the version whose content a rollback restored
+	RolledBackFrom int `json:"rolled_back_from,omitempty"`
+	// Published marks the template's published version when versions are listed
+	Published bool `json:"published"`
+}
+
+// TemplateDiff lists what changed between two versions of a template: each changed
+// field, and the subject and body line by line, prefixed "-" for removed lines, "+" for
+// added ones and " " for unchanged ones
+type TemplateDiff struct {
+	TemplateID  string                 `json:"template_id"`
+	From        int                    `json:"from"`
+	To          int                    `json:"to"`
+	Changes     map[string]FieldChange `json:"changes"`
+	SubjectDiff []string               `json:"subject_diff,omitempty"`
+	BodyDiff    []string               `json:"body_diff,omitempty"`
+}
+
+// TemplateRollbackRequest picks the version a rollback restores; without one it restores
+// the version published before the current one
+type TemplateRollbackRequest struct {
+	Version int `json:"version,omitempty" binding:"min=0"`
+}
+
+// TemplateLocalization is a template's subject and body in one locale
+type TemplateLocalization struct {
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// LanguageDetection is the language detected in a customer's content
+type LanguageDetection struct {
+	Language   string    `json:"language"`
+	Confidence float64   `json:"confidence"`
+	Detector   string    `json:"detector"`
+	DetectedAt time.Time `json:"detected_at"`
 }
 
 // CustomerPreferences represents customer notification preferences
@@ -88,11 +261,18 @@ type CustomerPreferences struct {
 	PushEnabled       bool                      `json:"push_enabled" db:"push_enabled"`
 	WebhookEnabled    bool                      `json:"webhook_enabled" db:"webhook_enabled"`
 	WebhookURL        string                    `json:"webhook_url,omitempty" db:"webhook_url"`
+	Email             string                    `json:"email,omitempty" db:"email"` // Address of email notifications addressed to the customer
+	Phone             string                    `json:"phone,omitempty" db:"phone"` // E.164 number of SMS notifications addressed to the customer
 	PreferredTypes    []NotificationType        `json:"preferred_types" db:"preferred_types"`
 	QuietHours        *QuietHours               `json:"quiet_hours,omitempty" db:"quiet_hours"`
 	Categories        map[string]bool           `json:"categories" db:"categories"`
+	Language          string                    `json:"language,omitempty" db:"language"`
+	Timezone          string                    `json:"timezone,omitempty" db:"timezone"` // IANA name, e.g. "America/New_York"
+	Digest            *DigestPreferences        `json:"digest,omitempty" db:"digest"`
 	CreatedAt         time.Time                 `json:"created_at" db:"created_at"`
 	UpdatedAt         time.Time                 `json:"updated_at" db:"updated_at"`
+	UpdatedRegion     string                    `json:"updated_region,omitempty" db:"updated_region"` // Region of the last write (SERVICE_REGION)
+	TenantID          string                    `json:"tenant_id,omitempty" db:"tenant_id"`           // Tenant that imported the customer; bulk exports are scoped by it
 }
 
 // QuietHours represents time ranges when notifications should not be sent
@@ -103,16 +283,137 @@ type QuietHours struct {
 	Timezone  string `json:"timezone"`   // Format: "UTC" or "America/New_York"
 }
 
+// DigestPreferences decide whether a customer's low-priority email and WebSocket
+// notifications are batched into digests, and how often those are sent. Zero values
+// use CUSTOMER_DIGEST_INTERVAL_MINUTES and CUSTOMER_DIGEST_MAX_ITEMS.
+type DigestPreferences struct {
+	Enabled         bool `json:"enabled"`
+	IntervalMinutes int  `json:"interval_minutes,omitempty"`
+	MaxItems        int  `json:"max_items,omitempty"`
+}
+
+// PreferenceAction is what a customer's preferences allow for a notification
+type PreferenceAction string
+
+const (
+	PreferenceActionSend     PreferenceAction = "send"
+	PreferenceActionDefer    PreferenceAction = "defer"
+	PreferenceActionSuppress PreferenceAction = "suppress"
+)
+
+// Reasons a notification is suppressed or deferred by preferences
+const (
+	SuppressionChannelDisabled  = "channel_disabled"
+	SuppressionCategoryOptedOut = "category_opted_out"
+	SuppressionQuietHours       = "quiet_hours"
+	// SuppressionSMSOptedOut is an SMS to a number that replied STOP
+	SuppressionSMSOptedOut = "sms_opted_out"
+	// SuppressionLocalTimePassed is a notification scheduled in the recipient's local
+	// time after that time had already passed for them
+	SuppressionLocalTimePassed = "local_time_passed"
+	// SuppressionDuplicate is a notification repeating one sent within the dedupe window
+	SuppressionDuplicate = "duplicate"
+	// SuppressionNoContact is a notification addressed to a customer with no address on
+	// its channel in their preferences
+	SuppressionNoContact = "no_contact"
+)
+
+// PreferenceDecision is the outcome of checking a notification against its customer's
+// preferences; a deferred notification may be sent from Until
+type PreferenceDecision struct {
+	Action PreferenceAction `json:"action"`
+	Reason string           `json:"reason,omitempty"`
+	Until  *time.Time       `json:"until,omitempty"`
+}
+
+// BlackoutKind is why a tenant's sends are paused
+type BlackoutKind string
+
+const (
+	BlackoutKindHoliday      BlackoutKind = "holiday"
+	BlackoutKindChangeFreeze BlackoutKind = "change_freeze"
+)
+
+// BlackoutWindow is a period a tenant's non-urgent notifications are held through. It
+// runs from Start to End, or covers the whole days from Date through EndDate in Timezone
+// (UTC when empty), every year when Annual is set.
+type BlackoutWindow struct {
+	ID       string       `json:"id"`
+	Name     string       `json:"name"`
+	Kind     BlackoutKind `json:"kind"`
+	Start    *time.Time   `json:"start,omitempty"`
+	End      *time.Time   `json:"end,omitempty"`
+	Date     string       `json:"date,omitempty"`     // Format: "2026-12-25"
+	EndDate  string       `json:"end_date,omitempty"` // Format: "2026-12-26", Date when empty
+	Timezone string       `json:"timezone,omitempty"`
+	Annual   bool         `json:"annual,omitempty"`
+}
+
+// BlackoutCalendar is a tenant's blackout windows
+type BlackoutCalendar struct {
+	TenantID  string           `json:"tenant_id"`
+	Windows   []BlackoutWindow `json:"windows"`
+	UpdatedAt time.Time        `json:"updated_at"`
+}
+
+type BlackoutCalendarRequest struct {
+	Windows []BlackoutWindow `json:"windows" binding:"required"`
+}
+
+// BlackoutStatus is a tenant's calendar with the notifications it has held, in total and
+// by window, and the window in force with when the notifications it holds are released
+type BlackoutStatus struct {
+	BlackoutCalendar
+	Active           *BlackoutWindow  `json:"active,omitempty"`
+	NextRelease      *time.Time       `json:"next_release,omitempty"`
+	Deferred         int64            `json:"deferred"`
+	DeferredByWindow map[string]int64 `json:"deferred_by_window,omitempty"`
+}
+
+// PreferenceImportReport summarizes a bulk import of customer preferences. Rows that
+// failed validation or couldn't be stored are listed in Errors, up to a limit.
+type PreferenceImportReport struct {
+	Format          string                  `json:"format"`
+	Rows            int                     `json:"rows"`
+	Imported        int                     `json:"imported"`
+	Failed          int                     `json:"failed"`
+	Errors          []PreferenceImportError `json:"errors"`
+	ErrorsTruncated bool                    `json:"errors_truncated,omitempty"`
+}
+
+// PreferenceImportError is a row of a preferences import that wasn't imported; Line is
+// its line in the file, counting the CSV header
+type PreferenceImportError struct {
+	Line       int    `json:"line"`
+	CustomerID string `json:"customer_id,omitempty"`
+	Error      string `json:"error"`
+}
+
 // DeliveryStats represents notification delivery statistics
 type DeliveryStats struct {
-	TotalSent      int64   `json:"total_sent"`
-	TotalDelivered int64   `json:"total_delivered"`
-	TotalFailed    int64   `json:"total_failed"`
-	DeliveryRate   float64 `json:"delivery_rate"`
-	AvgDeliveryTime float64 `json:"avg_delivery_time_seconds"`
-	ByType         map[NotificationType]TypeStats `json:"by_type"`
-	ByPriority     map[Priority]PriorityStats     `json:"by_priority"`
-	TimeRange      string  `json:"time_range"`
+	TotalSent       int64                          `json:"total_sent"`
+	TotalDelivered  int64                          `json:"total_delivered"`
+	TotalFailed     int64                          `json:"total_failed"`
+	DeliveryRate    float64                        `json:"delivery_rate"`
+	AvgDeliveryTime float64                        `json:"avg_delivery_time_seconds"`
+	ByType          map[NotificationType]TypeStats `json:"by_type"`
+	ByPriority      map[Priority]PriorityStats     `json:"by_priority"`
+	TimeRange       string                         `json:"time_range"`
+	Cancellations   map[string]int64               `json:"cancellations,omitempty"`
+	// Replacements counts notifications replaced through a collapse key, by channel
+	Replacements    map[string]int64               `json:"replacements,omitempty"`
+	// SMSConsent counts the opt-out, opt-in and help keywords received in the time range
+	SMSConsent      *SMSConsentStats               `json:"sms_consent,omitempty"`
+	ComputedAt      time.Time                      `json:"computed_at"`
+}
+
+// SMSConsentStats counts inbound SMS keywords; the opt-out rate is the share of opt-outs
+// among the SMS sent in the same time range
+type SMSConsentStats struct {
+	OptOuts      int64   `json:"opt_outs"`
+	OptIns       int64   `json:"opt_ins"`
+	HelpRequests int64   `json:"help_requests"`
+	OptOutRate   float64 `json:"opt_out_rate"`
 }
 
 // TypeStats represents statistics by notification type
@@ -131,6 +432,32 @@ type PriorityStats struct {
 	AvgTime      float64 `json:"avg_delivery_time_seconds"`
 }
 
+// DeliveryRollup counts stored notifications with one type, priority and status.
+// Timed of them have a sent or delivered time, and DeliverySeconds sums how long each
+// took from when it was due.
+type DeliveryRollup struct {
+	Type            NotificationType
+	Priority        Priority
+	Status          NotificationStatus
+	Count           int64
+	Timed           int64
+	DeliverySeconds float64
+}
+
+// TemplateFailures counts the failed notifications rendered from one template
+type TemplateFailures struct {
+	TemplateID string
+	Failures   int64
+}
+
+// QueueDepth counts the notifications of one priority in a queue: pending (due now),
+// scheduled (pending until a later scheduled_at) or retrying
+type QueueDepth struct {
+	Queue    string
+	Priority Priority
+	Count    int64
+}
+
 // WebSocket models
 var upgrader = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
@@ -147,8 +474,23 @@ type Client struct {
 	UserAgent  string
 	IPAddress  string
 	ConnectedAt time.Time
+
+	// lastMessageID is the newest buffered message queued to this client; live messages
+	// arriving while replaying are held until the replay has been queued
+	lastMessageID string
+	replaying     bool
+	held          []queuedMessage
+}
+
+type queuedMessage struct {
+	id      string
+	payload []byte
 }
 
+// RemoteDelivery hands a signed, encoded message with its replay ID to the replicas
+// holding a customer's connections
+type RemoteDelivery func(ctx context.Context, customerID, id string, payload []byte) error
+
 // Hub maintains active WebSocket connections and broadcasts messages
 type Hub struct {
 	Clients    map[*Client]bool
@@ -156,13 +498,81 @@ type Hub struct {
 	Unregister chan *Client
 	Broadcast  chan []byte
 	mutex      sync.RWMutex
+
+	// customers indexes local connections by customer, so targeted sends and presence
+	// tracking don't scan every client
+	customers map[string][]*Client
+	presence  func(customerID string, online bool)
+
+	// remote forwards targeted messages for customers with no local connection to the
+	// replicas holding theirs; nil leaves them to be replayed on reconnect
+	remote RemoteDelivery
+
+	// buffer keeps recent per-customer messages for resuming clients; nil disables resume
+	buffer  MessageBuffer
+	backoff ReconnectBackoff
+
+	// signer signs the data of notification and broadcast messages; nil leaves them unsigned
+	signer MessageSigner
+
+	// closing is set by Close; writers tracks the write pumps still sending
+	closing bool
+	writers sync.WaitGroup
 }
 
 // WebSocketMessage represents a message sent over WebSocket
 type WebSocketMessage struct {
+	ID        string      `json:"id,omitempty"`
 	Type      string      `json:"type"`
 	Data      interface{} `json:"data"`
 	Timestamp time.Time   `json:"timestamp"`
+	Replayed  bool        `json:"replayed,omitempty"`
+	KeyID     string      `json:"kid,omitempty"`
+	Signature string      `json:"sig,omitempty"`
+}
+
+// ResumeRequest is what a reconnecting client presents: the resume token from its last
+// session message and, optionally, the ID of the last message it processed
+type ResumeRequest struct {
+	Token         string
+	LastMessageID string
+}
+
+// ReplayedMessage is a buffered WebSocket message and the ID it was sent with
+type ReplayedMessage struct {
+	ID      string
+	Payload []byte
+}
+
+// MessageBuffer keeps recent messages per customer so reconnecting clients can be sent
+// what they missed
+type MessageBuffer interface {
+	Append(ctx context.Context, customerID string, payload []byte) (string, error)
+	IssueToken(ctx context.Context, customerID string) (string, error)
+	Replay(ctx context.Context, customerID string, resume ResumeRequest) ([]ReplayedMessage, error)
+}
+
+// MessageSigner signs the envelope of a WebSocket message, returning the ID of the key it
+// signed with and the signature
+type MessageSigner interface {
+	Sign(ctx context.Context, payload []byte) (string, string, error)
+}
+
+// ReconnectBackoff is the reconnect schedule clients are asked to follow: delays start at
+// InitialDelayMs and grow by Multiplier up to MaxDelayMs, each randomized by ±Jitter
+type ReconnectBackoff struct {
+	InitialDelayMs int     `json:"initialDelayMs"`
+	MaxDelayMs     int     `json:"maxDelayMs"`
+	Multiplier     float64 `json:"multiplier"`
+	Jitter         float64 `json:"jitter"`
+}
+
+// WebSocketSession is the first message sent on every connection
+type WebSocketSession struct {
+	ResumeToken string           `json:"resumeToken,omitempty"`
+	Resumed     bool             `json:"resumed"`
+	Replayed    int              `json:"replayed"`
+	Reconnect   ReconnectBackoff `json:"reconnect"`
 }
 
 // NewWebSocketHub creates a new WebSocket hub
@@ -172,7 +582,65 @@ func NewWebSocketHub() *Hub {
 		Register:   make(chan *Client),
 		Unregister: make(chan *Client),
 		Broadcast:  make(chan []byte),
+		customers:  make(map[string][]*Client),
+		backoff: ReconnectBackoff{
+			InitialDelayMs: 1000,
+			MaxDelayMs:     30000,
+			Multiplier:     2,
+			Jitter:         0.5,
+		},
+	}
+}
+
+// SetMessageBuffer enables resume tokens: messages to customers are appended to buffer
+// and replayed to clients that reconnect with a token
+func (h *Hub) SetMessageBuffer(buffer MessageBuffer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.buffer = buffer
+}
+
+// SetReconnectBackoff sets the reconnect schedule sent to clients in the session message
+func (h *Hub) SetReconnectBackoff(backoff ReconnectBackoff) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.backoff = backoff
+}
+
+// SetMessageSigner signs the data of every notification and broadcast message, adding
+// the key ID and signature to the message
+func (h *Hub) SetMessageSigner(signer MessageSigner) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.signer = signer
+}
+
+// SetPresenceHook registers a function called when a customer's first connection to this
+// instance opens (online) or its last one closes (offline). It is called with the hub
+// locked, so it must not block.
+func (h *Hub) SetPresenceHook(hook func(customerID string, online bool)) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.presence = hook
+}
+
+// SetRemoteDelivery registers where targeted messages go when the customer has no
+// connection to this instance
+func (h *Hub) SetRemoteDelivery(remote RemoteDelivery) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.remote = remote
+}
+
+// ConnectedCustomers returns the customers with at least one connection to this instance
+func (h *Hub) ConnectedCustomers() []string {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	customers := make([]string, 0, len(h.customers))
+	for customerID := range h.customers {
+		customers = append(customers, customerID)
 	}
+	return customers
 }
 
 // Run starts the WebSocket hub
@@ -181,34 +649,324 @@ func (h *Hub) Run() {
 		select {
 		case client := <-h.Register:
 			h.mutex.Lock()
-			h.Clients[client] = true
+			h.addLocked(client)
 			h.mutex.Unlock()
-			log.Printf("WebSocket client connected: %s", client.CustomerID)
+			slog.Info("WebSocket client connected", "customer.id", client.CustomerID)
 
 		case client := <-h.Unregister:
 			h.mutex.Lock()
-			if _, ok := h.Clients[client]; ok {
-				delete(h.Clients, client)
-				close(client.Send)
-			}
+			h.removeLocked(client)
 			h.mutex.Unlock()
-			log.Printf("WebSocket client disconnected: %s", client.CustomerID)
+			slog.Info("WebSocket client disconnected", "customer.id", client.CustomerID)
 
 		case message := <-h.Broadcast:
-			h.mutex.RLock()
+			h.mutex.Lock()
 			for client := range h.Clients {
 				select {
 				case client.Send <- message:
 				default:
-					close(client.Send)
-					delete(h.Clients, client)
+					h.removeLocked(client)
 				}
 			}
-			h.mutex.RUnlock()
+			h.mutex.Unlock()
+		}
+	}
+}
+
+// addLocked registers a client; the caller holds the write lock
+func (h *Hub) addLocked(client *Client) {
+	h.Clients[client] = true
+	h.customers[client.CustomerID] = append(h.customers[client.CustomerID], client)
+	if len(h.customers[client.CustomerID]) == 1 && h.presence != nil {
+		h.presence(client.CustomerID, true)
+	}
+}
+
+// removeLocked drops a client and closes its send channel; the caller holds the write lock
+func (h *Hub) removeLocked(client *Client) {
+	if _, ok := h.Clients[client]; !ok {
+		return
+	}
+	delete(h.Clients, client)
+	close(client.Send)
+
+	connections := h.customers[client.CustomerID]
+	for i, c := range connections {
+		if c == client {
+			connections[i] = connections[len(connections)-1]
+			connections[len(connections)-1] = nil
+			connections = connections[:len(connections)-1]
+			break
+		}
+	}
+	h.customers[client.CustomerID] = connections
+	if len(connections) == 0 {
+		delete(h.customers, client.CustomerID)
+		if h.presence != nil {
+			h.presence(client.CustomerID, false)
+		}
+	}
+}
+
+// WebSocket keepalive timing and inbound limits; clients only send control frames, so
+// anything larger than wsMaxMessageSize is treated as misbehaviour and closes the connection
+const (
+	wsWriteWait      = 10 * time.Second
+	wsPongWait       = 60 * time.Second
+	wsPingPeriod     = wsPongWait * 9 / 10
+	wsMaxMessageSize = 4096
+)
+
+// shutdownCloseMessage tells clients the server is going away, so they reconnect to
+// another instance
+var shutdownCloseMessage = websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+
+// Serve registers an upgraded connection for a customer, sends the session message and
+// any replay, and pumps messages to it until the connection closes. ctx is the upgrade
+// request's, and bounds the replay.
+func (h *Hub) Serve(ctx context.Context, conn *websocket.Conn, customerID, userAgent, ipAddress string, resume ResumeRequest) {
+	client := &Client{
+		Hub:         h,
+		Conn:        conn,
+		Send:        make(chan []byte, 256),
+		CustomerID:  customerID,
+		UserAgent:   userAgent,
+		IPAddress:   ipAddress,
+		ConnectedAt: time.Now().UTC(),
+	}
+
+	// Registered directly rather than through Run so no message can slip between the
+	// replay read and the client being visible to SendToCustomer
+	h.mutex.Lock()
+	if h.closing {
+		h.mutex.Unlock()
+		conn.WriteControl(websocket.CloseMessage, shutdownCloseMessage, time.Now().Add(wsWriteWait))
+		conn.Close()
+		return
+	}
+	client.replaying = h.buffer != nil
+	h.addLocked(client)
+	h.writers.Add(1)
+	h.mutex.Unlock()
+	slog.InfoContext(ctx, "WebSocket client connected", "customer.id", customerID)
+
+	go client.writePump()
+	h.startSession(ctx, client, resume)
+	client.readPump()
+}
+
+// startSession issues or redeems the client's resume token, queues the session message
+// and replayed messages, then releases live messages held in the meantime
+func (h *Hub) startSession(ctx context.Context, client *Client, resume ResumeRequest) {
+	h.mutex.RLock()
+	buffer := h.buffer
+	session := WebSocketSession{Reconnect: h.backoff}
+	h.mutex.RUnlock()
+
+	var replayed []ReplayedMessage
+	if buffer != nil {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+
+		if resume.Token != "" {
+			messages, err := buffer.Replay(ctx, client.CustomerID, resume)
+			if err != nil {
+				slog.InfoContext(ctx, "WebSocket resume not possible, starting a new session", "customer.id", client.CustomerID, "error", err)
+			} else {
+				replayed = messages
+				session.Resumed = true
+				session.ResumeToken = resume.Token
+			}
+		}
+		if session.ResumeToken == "" {
+			token, err := buffer.IssueToken(ctx, client.CustomerID)
+			if err != nil {
+				slog.WarnContext(ctx, "No resume token issued", "customer.id", client.CustomerID, "error", err)
+			}
+			session.ResumeToken = token
+		}
+	}
+	session.Replayed = len(replayed)
+
+	sessionBytes, err := json.Marshal(WebSocketMessage{Type: "session", Data: session, Timestamp: time.Now()})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to encode WebSocket session", "customer.id", client.CustomerID, "error", err)
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if _, ok := h.Clients[client]; !ok {
+		return
+	}
+
+	held := client.held
+	client.held = nil
+	client.replaying = false
+	if resume.LastMessageID != "" {
+		client.lastMessageID = resume.LastMessageID
+	}
+
+	h.deliverLocked(client, queuedMessage{payload: sessionBytes})
+	for _, message := range replayed {
+		payload, err := replayPayload(message)
+		if err != nil {
+			slog.Warn("Skipping undecodable buffered message", "message.id", message.ID, "customer.id", client.CustomerID, "error", err)
+			continue
+		}
+		h.deliverLocked(client, queuedMessage{id: message.ID, payload: payload})
+	}
+	for _, message := range held {
+		h.deliverLocked(client, message)
+	}
+}
+
+// replayPayload marks a buffered message as replayed and stamps the ID it was buffered under
+func replayPayload(message ReplayedMessage) ([]byte, error) {
+	// The data is kept as it was encoded so a signature over it still holds
+	var data json.RawMessage
+	wsMessage := WebSocketMessage{Data: &data}
+	if err := json.Unmarshal(message.Payload, &wsMessage); err != nil {
+		return nil, err
+	}
+	wsMessage.ID = message.ID
+	wsMessage.Replayed = true
+	return json.Marshal(wsMessage)
+}
+
+// deliverLocked queues a message to a client, holding it while the client's replay is
+// being read and skipping IDs the client has already been sent. A client whose queue is
+// full is dropped. The caller holds the write lock.
+func (h *Hub) deliverLocked(client *Client, message queuedMessage) {
+	if _, ok := h.Clients[client]; !ok {
+		return
+	}
+	if client.replaying {
+		if len(client.held) >= cap(client.Send) {
+			h.removeLocked(client)
+			return
+		}
+		client.held = append(client.held, message)
+		return
+	}
+	if message.id != "" && client.lastMessageID != "" && !messageIDAfter(message.id, client.lastMessageID) {
+		return
+	}
+
+	select {
+	case client.Send <- message.payload:
+		if message.id != "" {
+			client.lastMessageID = message.id
+		}
+	default:
+		h.removeLocked(client)
+	}
+}
+
+// messageIDAfter reports whether buffered message ID a ("<millis>-<seq>") is newer than b
+func messageIDAfter(a, b string) bool {
+	aMillis, aSeq := splitMessageID(a)
+	bMillis, bSeq := splitMessageID(b)
+	if aMillis != bMillis {
+		return aMillis > bMillis
+	}
+	return aSeq > bSeq
+}
+
+func splitMessageID(id string) (uint64, uint64) {
+	millis, seq, _ := strings.Cut(id, "-")
+	m, _ := strconv.ParseUint(millis, 10, 64)
+	s, _ := strconv.ParseUint(seq, 10, 64)
+	return m, s
+}
+
+// readPump discards inbound messages and unregisters the client once the connection drops
+func (c *Client) readPump() {
+	defer func() {
+		c.Hub.Unregister <- c
+		c.Conn.Close()
+	}()
+
+	c.Conn.SetReadLimit(wsMaxMessageSize)
+	c.Conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	c.Conn.SetPongHandler(func(string) error {
+		return c.Conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		if _, _, err := c.Conn.ReadMessage(); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure, websocket.CloseNoStatusReceived) {
+				slog.Info("WebSocket connection closed unexpectedly", "customer.id", c.CustomerID, "error", err)
+			}
+			return
+		}
+	}
+}
+
+// writePump sends queued messages and keepalive pings until the hub closes Send, then
+// sends a close frame
+func (c *Client) writePump() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.Conn.Close()
+		c.Hub.writers.Done()
+	}()
+
+	for {
+		select {
+		case message, ok := <-c.Send:
+			c.Conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if !ok {
+				c.Conn.WriteMessage(websocket.CloseMessage, c.Hub.closeMessage())
+				return
+			}
+			if err := c.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.Conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
 		}
 	}
 }
 
+// Close disconnects every client: messages already queued to a client are sent, then a
+// going-away close frame. It waits for that until ctx ends, and refuses new connections.
+func (h *Hub) Close(ctx context.Context) error {
+	h.mutex.Lock()
+	h.closing = true
+	clients := len(h.Clients)
+	for client := range h.Clients {
+		h.removeLocked(client)
+	}
+	h.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		h.writers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		slog.InfoContext(ctx, "✓ Closed WebSocket connections", "connections", clients)
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// closeMessage is the close frame a client is sent once its Send channel is closed
+func (h *Hub) closeMessage() []byte {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+	if h.closing {
+		return shutdownCloseMessage
+	}
+	return []byte{}
+}
+
 // GetActiveConnections returns the number of active WebSocket connections
 func (h *Hub) GetActiveConnections() int {
 	h.mutex.RLock()
@@ -216,50 +974,124 @@ func (h *Hub) GetActiveConnections() int {
 	return len(h.Clients)
 }
 
-// SendToCustomer sends a message to a specific customer
-func (h *Hub) SendToCustomer(customerID string, message interface{}) error {
+// SendToCustomer sends a message to every local connection of a customer, looked up in
+// the per-customer index rather than by scanning all clients
+func (h *Hub) SendToCustomer(ctx context.Context, customerID string, message interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	wsMessage := WebSocketMessage{
 		Type:      "notification",
 		Data:      message,
 		Timestamp: time.Now(),
 	}
+	if err := h.sign(ctx, &wsMessage); err != nil {
+		return err
+	}
 
 	messageBytes, err := json.Marshal(wsMessage)
 	if err != nil {
 		return err
 	}
 
+	// Buffer the message, whether or not the customer is connected, so a client that
+	// reconnects to any replica can have it replayed
 	h.mutex.RLock()
-	defer h.mutex.RUnlock()
-
-	for client := range h.Clients {
-		if client.CustomerID == customerID {
-			select {
-			case client.Send <- messageBytes:
-			default:
-				close(client.Send)
-				delete(h.Clients, client)
+	buffer := h.buffer
+	remote := h.remote
+	local := len(h.customers[customerID]) > 0
+	h.mutex.RUnlock()
+	if buffer != nil {
+		id, err := buffer.Append(ctx, customerID, messageBytes)
+		if err != nil {
+			slog.WarnContext(ctx, "WebSocket message not buffered for replay", "customer.id", customerID, "error", err)
+		} else {
+			wsMessage.ID = id
+			if messageBytes, err = json.Marshal(wsMessage); err != nil {
+				return err
 			}
 		}
 	}
 
+	if !local && remote != nil {
+		return remote(ctx, customerID, wsMessage.ID, messageBytes)
+	}
+	h.DeliverLocal(customerID, wsMessage.ID, messageBytes)
 	return nil
 }
 
+// DeliverLocal sends an encoded message to the customer's connections to this instance,
+// such as one forwarded by the replica that sent it
+func (h *Hub) DeliverLocal(customerID, id string, messageBytes []byte) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	// Several connections are copied first because delivering can drop a client, which
+	// edits the index
+	connections := h.customers[customerID]
+	if len(connections) == 1 {
+		h.deliverLocked(connections[0], queuedMessage{id: id, payload: messageBytes})
+		return
+	}
+	for _, client := range append([]*Client(nil), connections...) {
+		h.deliverLocked(client, queuedMessage{id: id, payload: messageBytes})
+	}
+}
+
 // BroadcastToAll sends a message to all connected clients
-func (h *Hub) BroadcastToAll(message interface{}) error {
+func (h *Hub) BroadcastToAll(ctx context.Context, message interface{}) error {
+	return h.BroadcastMessage(ctx, "broadcast", message)
+}
+
+// BroadcastMessage sends a message of the given type to all connected clients
+func (h *Hub) BroadcastMessage(ctx context.Context, messageType string, message interface{}) error {
 	wsMessage := WebSocketMessage{
-		Type:      "broadcast",
+		Type:      messageType,
 		Data:      message,
 		Timestamp: time.Now(),
 	}
+	if err := h.sign(ctx, &wsMessage); err != nil {
+		return err
+	}
 
 	messageBytes, err := json.Marshal(wsMessage)
 	if err != nil {
 		return err
 	}
 
-	h.Broadcast <- messageBytes
+	select {
+	case h.Broadcast <- messageBytes:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sign encodes a message's data, which is sent as is, and signs the envelope: the type,
+// the timestamp as sent and the raw data, joined by dots. Clients can check the signature
+// without re-encoding anything, and a signed message can't be passed off as another type
+// or time. The ID and replayed flag are set after signing, so they aren't covered. A
+// message that can't be signed is sent unsigned.
+func (h *Hub) sign(ctx context.Context, message *WebSocketMessage) error {
+	h.mutex.RLock()
+	signer := h.signer
+	h.mutex.RUnlock()
+	if signer == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(message.Data)
+	if err != nil {
+		return err
+	}
+	message.Data = json.RawMessage(data)
+	envelope := message.Type + "." + message.Timestamp.Format(time.RFC3339Nano) + "." + string(data)
+	message.KeyID, message.Signature, err = signer.Sign(ctx, []byte(envelope))
+	if err != nil {
+		slog.WarnContext(ctx, "Sending unsigned WebSocket message", "message.type", message.Type, "error", err)
+		message.KeyID, message.Signature = "", ""
+	}
 	return nil
 }
 
@@ -284,26 +1116,146 @@ type OrderStatusUpdatedEvent struct {
 // Request/Response models
 type CreateNotificationRequest struct {
 	Type        NotificationType       `json:"type" binding:"required"`
-	Recipient   string                 `json:"recipient" binding:"required"`
+	Recipient   string                 `json:"recipient" binding:"required_without=Target"`
 	Subject     string                 `json:"subject"`
-	Message     string                 `json:"message" binding:"required"`
+	Message     string                 `json:"message" binding:"required_without=TemplateID"`
 	Data        map[string]interface{} `json:"data"`
 	Priority    Priority               `json:"priority"`
 	TemplateID  string                 `json:"template_id,omitempty"`
 	CustomerID  string                 `json:"customer_id" binding:"required"`
 	OrderID     string                 `json:"order_id,omitempty"`
 	ScheduledAt *time.Time             `json:"scheduled_at,omitempty"`
+	Metadata    map[string]interface{} `json:"metadata,omitempty"`
+	HTMLMessage string                 `json:"html_message,omitempty"`
+	Attachments []Attachment           `json:"attachments,omitempty" binding:"dive"`
+	Push        *PushContent           `json:"push,omitempty"`
+	Card        json.RawMessage        `json:"card,omitempty"`
+	CollapseKey string                 `json:"collapse_key,omitempty" binding:"max=64"`
+	Target      *DeviceTarget          `json:"target,omitempty"`
+
+	// OptimizeSendTime set to false sends immediately even when send-time
+	// optimization is on
+	OptimizeSendTime *bool `json:"optimize_send_time,omitempty"`
+
+	// ConversationID puts the notification in a conversation of the caller's choosing
+	// instead of its order's or customer's
+	ConversationID string `json:"conversation_id,omitempty" binding:"max=128"`
+
+	// Dedupe set to true suppresses the notification when one with the same content was
+	// created for the customer on the channel within the dedupe window; false sends it
+	// regardless. Unset follows DEDUPE_ENABLED.
+	Dedupe *bool `json:"dedupe,omitempty"`
+
+	// Digest set to false sends a low-priority notification on its own even when its
+	// customer gets digests; true batches it even when CUSTOMER_DIGEST_ENABLED is off and
+	// the customer has no digest preference
+	Digest *bool `json:"digest,omitempty"`
+
+	// ScheduledLocal reads ScheduledAt's date and time of day, ignoring its offset, as
+	// wall-clock time in the recipient's time zone
+	ScheduledLocal bool `json:"scheduled_local,omitempty"`
+
+	// TemplateVersion pins the notification to a version of its template instead of the
+	// current one
+	TemplateVersion int `json:"template_version,omitempty" binding:"min=0"`
+
+	// IdempotencyKey dedupes retries of this request; the Idempotency-Key header sets it too
+	IdempotencyKey string `json:"idempotency_key,omitempty" binding:"max=255"`
+}
+
+type TemplateRequest struct {
+	Name      string                 `json:"name" binding:"required"`
+	Type      NotificationType       `json:"type" binding:"required"`
+	Subject   string                 `json:"subject"`
+	Body      string                 `json:"body" binding:"required"`
+	Variables []string               `json:"variables"`
+	Metadata  map[string]interface{} `json:"metadata"`
+
+	DataSchema     json.RawMessage `json:"data_schema,omitempty"`
+	MetadataSchema json.RawMessage `json:"metadata_schema,omitempty"`
+
+	Locale        string                          `json:"locale,omitempty"`
+	Localizations map[string]TemplateLocalization `json:"localizations,omitempty"`
+
+	Card json.RawMessage `json:"card,omitempty"`
+}
+
+type TemplateApprovalRequest struct {
+	Approved bool   `json:"approved"`
+	Approver string `json:"approver" binding:"required"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// NotificationPatchRequest edits a pending scheduled notification; omitted fields are unchanged
+type NotificationPatchRequest struct {
+	Subject     *string                `json:"subject,omitempty"`
+	Message     *string                `json:"message,omitempty"`
+	Data        map[string]interface{} `json:"data,omitempty"`
+	ScheduledAt *time.Time             `json:"scheduled_at,omitempty"`
+}
+
+// NotificationEdit records one pre-send edit for the notification's timeline
+type NotificationEdit struct {
+	Version  int                    `json:"version"`
+	EditedBy string                 `json:"edited_by,omitempty"`
+	EditedAt time.Time              `json:"edited_at"`
+	Changes  map[string]FieldChange `json:"changes"`
+}
+
+type FieldChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// CancelResult reports whether a cancellation won the race against delivery
+type CancelResult struct {
+	NotificationID string             `json:"notification_id"`
+	Cancelled      bool               `json:"cancelled"`
+	Status         NotificationStatus `json:"status"`
+}
+
+// ResendNotificationRequest re-sends a past notification, to another channel or recipient
+// when given. A different channel needs a recipient on that channel.
+type ResendNotificationRequest struct {
+	Type      NotificationType `json:"type,omitempty" binding:"omitempty,oneof=email sms push websocket webhook teams"`
+	Recipient string           `json:"recipient,omitempty"`
+}
+
+type BulkCancelRequest struct {
+	OrderID string `json:"order_id" binding:"required"`
 }
 
 type UpdateNotificationStatusRequest struct {
 	Status       NotificationStatus `json:"status" binding:"required"`
 	ErrorMessage string             `json:"error_message,omitempty"`
+	// ErrorClass of a failure, when known; classes the channel's retry policy doesn't
+	// retry fail the notification without scheduling a retry
+	ErrorClass ErrorClass `json:"error_class,omitempty"`
 }
 
 type BulkNotificationRequest struct {
 	Notifications []CreateNotificationRequest `json:"notifications" binding:"required,min=1,max=100"`
 }
 
+// Outcomes of one notification in a bulk request
+const (
+	BulkResultAccepted = "accepted"
+	BulkResultFailed   = "failed"
+)
+
+// BulkNotificationResult is the outcome of the notification at Index in a bulk request.
+// Accepted notifications were stored, though screening or preferences may have kept
+// them from being sent; replayed ones were stored by an earlier request with the same
+// idempotency key. Failed ones carry the reason.
+type BulkNotificationResult struct {
+	Index        int           `json:"index"`
+	Result       string        `json:"result"`
+	Notification *Notification `json:"notification,omitempty"`
+	Buffered     bool          `json:"buffered,omitempty"`
+	Replayed     bool          `json:"replayed,omitempty"`
+	Error        string        `json:"error,omitempty"`
+}
+
 type BroadcastNotificationRequest struct {
 	Type     NotificationType       `json:"type" binding:"required"`
 	Subject  string                 `json:"subject"`
@@ -311,14 +1263,741 @@ type BroadcastNotificationRequest struct {
 	Data     map[string]interface{} `json:"data"`
 	Priority Priority               `json:"priority"`
 	Filters  BroadcastFilters       `json:"filters"`
+
+	// Dedupe skips customers already sent the same subject and message on a channel
+	// within the dedupe window; unset follows DEDUPE_ENABLED
+	Dedupe *bool `json:"dedupe,omitempty"`
+
+	// ScheduledAt holds the broadcast until then. With ScheduledLocal its date and time
+	// of day, ignoring its offset, are read in each targeted customer's time zone when
+	// the broadcast runs, so each gets it at that local time.
+	ScheduledAt    *time.Time `json:"scheduled_at,omitempty"`
+	ScheduledLocal bool       `json:"scheduled_local,omitempty"`
 }
 
+// BroadcastFilters pick a broadcast's audience. Without CustomerIDs it goes to every
+// connected WebSocket client. Types are the channels it is delivered on, WebSocket when
+// empty; Categories are checked against each customer's opt-outs.
 type BroadcastFilters struct {
 	CustomerIDs []string             `json:"customer_ids,omitempty"`
 	Types       []NotificationType   `json:"types,omitempty"`
 	Categories  []string             `json:"categories,omitempty"`
 }
 
+// BroadcastStatus tracks a broadcast job through the approval workflow
+type BroadcastStatus string
+
+const (
+	BroadcastStatusPendingApproval BroadcastStatus = "pending_approval"
+	BroadcastStatusRejected        BroadcastStatus = "rejected"
+	BroadcastStatusExpired         BroadcastStatus = "expired"
+	BroadcastStatusSending         BroadcastStatus = "sending"
+	BroadcastStatusSent            BroadcastStatus = "sent"
+	BroadcastStatusFailed          BroadcastStatus = "failed"
+)
+
+// BroadcastProgress counts a broadcast's deliveries, one per recipient and channel.
+// Queued deliveries haven't been attempted yet.
+type BroadcastProgress struct {
+	Total      int `json:"total"`
+	Queued     int `json:"queued"`
+	Sent       int `json:"sent"`
+	Failed     int `json:"failed"`
+	Suppressed int `json:"suppressed"`
+	// DuplicateSuppressed counts deliveries skipped as duplicates of recent sends
+	DuplicateSuppressed int `json:"duplicate_suppressed"`
+}
+
+// BroadcastJob is a broadcast request, held for a second approver when it targets
+// more recipients than the approval threshold
+type BroadcastJob struct {
+	ID             string                       `json:"id"`
+	Request        BroadcastNotificationRequest `json:"request"`
+	RecipientCount int                          `json:"recipient_count"`
+	Channels       []NotificationType           `json:"channels"`
+	Status         BroadcastStatus              `json:"status"`
+	Progress       *BroadcastProgress           `json:"progress,omitempty"`
+	RequestedBy    string                       `json:"requested_by"`
+	DecidedBy      string                       `json:"decided_by,omitempty"`
+	Comment        string                       `json:"comment,omitempty"`
+	Error          string                       `json:"error,omitempty"`
+	// Screening is the content screener's decision when it flagged the broadcast
+	Screening   *ScreeningDecision `json:"screening,omitempty"`
+	CreatedAt   time.Time          `json:"created_at"`
+	ExpiresAt   *time.Time         `json:"expires_at,omitempty"`
+	DecidedAt   *time.Time         `json:"decided_at,omitempty"`
+	CompletedAt *time.Time         `json:"completed_at,omitempty"`
+}
+
+// BroadcastAuditRecord is one entry in a broadcast job's audit trail
+type BroadcastAuditRecord struct {
+	JobID     string    `json:"job_id"`
+	Action    string    `json:"action"`
+	Actor     string    `json:"actor"`
+	Comment   string    `json:"comment,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type BroadcastDecisionRequest struct {
+	Comment string `json:"comment,omitempty"`
+}
+
+// NotificationRedriveRequest selects failed notifications to retry again: by channel,
+// by the class of their last error and by when they failed, from inclusive, to exclusive
+type NotificationRedriveRequest struct {
+	Status     NotificationStatus `json:"status,omitempty"`
+	Channel    NotificationType   `json:"channel,omitempty"`
+	ErrorClass ErrorClass         `json:"error_class,omitempty"`
+	From       *time.Time         `json:"from,omitempty"`
+	To         *time.Time         `json:"to,omitempty"`
+}
+
+// RedriveJobStatus tracks a re-drive job from its confirmation to its completion
+type RedriveJobStatus string
+
+const (
+	RedriveJobPendingConfirmation RedriveJobStatus = "pending_confirmation"
+	RedriveJobExpired             RedriveJobStatus = "expired"
+	RedriveJobRunning             RedriveJobStatus = "running"
+	RedriveJobCompleted           RedriveJobStatus = "completed"
+)
+
+// RedriveProgress counts a re-drive job's notifications. Skipped notifications were no
+// longer failed when their turn came; queued ones haven't had it yet.
+type RedriveProgress struct {
+	Total    int `json:"total"`
+	Queued   int `json:"queued"`
+	Requeued int `json:"requeued"`
+	Skipped  int `json:"skipped"`
+	Failed   int `json:"failed"`
+}
+
+// RedriveJob is a bulk re-drive of the failed notifications matching a filter. Nothing is
+// re-sent until the job is confirmed with its matched count.
+type RedriveJob struct {
+	ID          string                     `json:"id"`
+	Filter      NotificationRedriveRequest `json:"filter"`
+	Matched     int                        `json:"matched"`
+	Status      RedriveJobStatus           `json:"status"`
+	Progress    *RedriveProgress           `json:"progress,omitempty"`
+	RequestedBy string                     `json:"requested_by,omitempty"`
+	ConfirmedBy string                     `json:"confirmed_by,omitempty"`
+	CreatedAt   time.Time                  `json:"created_at"`
+	ExpiresAt   *time.Time                 `json:"expires_at,omitempty"`
+	ConfirmedAt *time.Time                 `json:"confirmed_at,omitempty"`
+	CompletedAt *time.Time                 `json:"completed_at,omitempty"`
+}
+
+// RedriveConfirmRequest confirms a re-drive job by repeating the number of notifications
+// it matched
+type RedriveConfirmRequest struct {
+	Matched *int `json:"matched" binding:"required"`
+}
+
+// AnnouncementSeverity ranks a system announcement for display
+type AnnouncementSeverity string
+
+const (
+	AnnouncementSeverityInfo     AnnouncementSeverity = "info"
+	AnnouncementSeverityWarning  AnnouncementSeverity = "warning"
+	AnnouncementSeverityCritical AnnouncementSeverity = "critical"
+)
+
+// Announcement is a system-wide banner, such as an outage notice, shown to every client
+// from StartsAt until EndsAt, or until it is ended early. Without EndsAt it stays up
+// until it is ended.
+type Announcement struct {
+	ID        string               `json:"id"`
+	Title     string               `json:"title,omitempty"`
+	Message   string               `json:"message"`
+	Severity  AnnouncementSeverity `json:"severity"`
+	StartsAt  time.Time            `json:"starts_at"`
+	EndsAt    *time.Time           `json:"ends_at,omitempty"`
+	EndedAt   *time.Time           `json:"ended_at,omitempty"`
+	CreatedBy string               `json:"created_by,omitempty"`
+	CreatedAt time.Time            `json:"created_at"`
+}
+
+// Active reports whether the announcement is shown at t
+func (a *Announcement) Active(t time.Time) bool {
+	return a.EndedAt == nil && !t.Before(a.StartsAt) && (a.EndsAt == nil || t.Before(*a.EndsAt))
+}
+
+// CreateAnnouncementRequest schedules an announcement; it starts at once without StartsAt
+type CreateAnnouncementRequest struct {
+	Title    string               `json:"title" binding:"max=200"`
+	Message  string               `json:"message" binding:"required,max=2000"`
+	Severity AnnouncementSeverity `json:"severity" binding:"required,oneof=info warning critical"`
+	StartsAt *time.Time           `json:"starts_at,omitempty"`
+	EndsAt   *time.Time           `json:"ends_at,omitempty"`
+}
+
+// TestSendRequest sends an operator test notification straight to one channel
+type TestSendRequest struct {
+	Channel    NotificationType `json:"channel" binding:"required,oneof=email sms push webhook websocket teams"`
+	Recipient  string           `json:"recipient"`
+	CustomerID string           `json:"customer_id"`
+	Subject    string           `json:"subject" binding:"max=200"`
+	Message    string           `json:"message" binding:"max=2000"`
+}
+
+// TestSendResult is the channel's answer to a test notification
+type TestSendResult struct {
+	ID          string           `json:"id"`
+	Channel     NotificationType `json:"channel"`
+	Delivered   bool             `json:"delivered"`
+	Error       string           `json:"error,omitempty"`
+	ErrorClass  ErrorClass       `json:"error_class,omitempty"`
+	LatencyMs   float64          `json:"latency_ms"`
+	SentAt      time.Time        `json:"sent_at"`
+	RequestedBy string           `json:"requested_by,omitempty"`
+}
+
+// DemoMetricRequest emits one value on an ad-hoc instrument for demo scenarios
+type DemoMetricRequest struct {
+	Name        string            `json:"name" binding:"required"`
+	Type        string            `json:"type" binding:"required"`
+	Value       float64           `json:"value"`
+	Unit        string            `json:"unit,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+}
+
+// DemoTraceRequest shapes a synthetic trace for Application Map and transaction demos
+type DemoTraceRequest struct {
+	Depth            int      `json:"depth"`
+	FanOut           int      `json:"fan_out"`
+	SpanLatencyMs    int      `json:"span_latency_ms"`
+	ErrorProbability float64  `json:"error_probability"`
+	Dependencies     []string `json:"dependencies,omitempty"`
+	Seed             int64    `json:"seed,omitempty"`
+}
+
+// DemoLogRequest shapes a burst of synthetic log records for Log Analytics demos
+type DemoLogRequest struct {
+	Count         int               `json:"count"`
+	RatePerSecond float64           `json:"rate_per_second"`
+	Severities    []string          `json:"severities,omitempty"`
+	Message       string            `json:"message,omitempty"`
+	Attributes    map[string]string `json:"attributes,omitempty"`
+}
+
+// FaultDryRunRequest switches failure injection dry-run mode
+type FaultDryRunRequest struct {
+	Enabled *bool `json:"enabled" binding:"required"`
+}
+
+// CustomerPresence reports whether a customer has a live WebSocket connection to any replica
+type CustomerPresence struct {
+	CustomerID string     `json:"customer_id"`
+	Online     bool       `json:"online"`
+	Instances  []string   `json:"instances"`
+	LastSeen   *time.Time `json:"last_seen,omitempty"`
+}
+
+// UsageSample is one API request's contribution to its key's usage
+type UsageSample struct {
+	Error         bool
+	BytesIn       int64
+	BytesOut      int64
+	Notifications int
+}
+
+// UsageBucket is an API key's usage over one time bucket
+type UsageBucket struct {
+	Start         time.Time `json:"start"`
+	Requests      int64     `json:"requests"`
+	Errors        int64     `json:"errors"`
+	ErrorRate     float64   `json:"error_rate"`
+	Notifications int64     `json:"notifications"`
+	BytesIn       int64     `json:"bytes_in"`
+	BytesOut      int64     `json:"bytes_out"`
+}
+
+// RateLimitSubject is who a rate limit applies to: an API key by its key ID, or a customer
+type RateLimitSubject struct {
+	Kind string
+	ID   string
+}
+
+// RateLimitDecision is the rate limiter's answer for one request. Remaining is the
+// allowance left for the most limited subject; RetryAfter is set when Allowed is false.
+type RateLimitDecision struct {
+	Allowed    bool
+	Group      string
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+	// Subject is the subject that used up its allowance
+	Subject RateLimitSubject
+}
+
+// ScreeningAction is a content screener's verdict on a notification
+type ScreeningAction string
+
+const (
+	ScreeningActionAllow ScreeningAction = "allow"
+	ScreeningActionFlag  ScreeningAction = "flag"
+	ScreeningActionBlock ScreeningAction = "block"
+)
+
+// ScreeningRequest is the rendered content handed to a content screener before sending
+type ScreeningRequest struct {
+	NotificationID string           `json:"notification_id,omitempty"`
+	CustomerID     string           `json:"customer_id"`
+	Channel        NotificationType `json:"channel"`
+	EventType      string           `json:"event_type,omitempty"`
+	Subject        string           `json:"subject,omitempty"`
+	Message        string           `json:"message"`
+	HTMLMessage    string           `json:"html_message,omitempty"`
+	// CardText is the text of a teams notification's Adaptive Card
+	CardText string `json:"card_text,omitempty"`
+}
+
+// ScreeningDecision is a content screener's answer: flagged content is sent and marked,
+// blocked content is not sent
+type ScreeningDecision struct {
+	Action     ScreeningAction `json:"action"`
+	Reason     string          `json:"reason,omitempty"`
+	Score      float64         `json:"score,omitempty"`
+	Labels     []string        `json:"labels,omitempty"`
+	Screener   string          `json:"screener"`
+	ScreenedAt time.Time       `json:"screened_at"`
+
+	// Categories holds per-category severities from moderation services
+	Categories map[string]int `json:"categories,omitempty"`
+}
+
+// Identity is the caller a verified bearer token identifies
+type Identity struct {
+	Subject    string    `json:"sub"`
+	CustomerID string    `json:"customer_id"`
+	Roles      []string  `json:"roles,omitempty"`
+	Issuer     string    `json:"iss"`
+	TenantID   string    `json:"tid,omitempty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// ErrorClass groups delivery errors for retry decisions
+type ErrorClass string
+
+const (
+	// ErrorClassNetwork covers connection failures, resets and timeouts
+	ErrorClassNetwork ErrorClass = "network"
+	// ErrorClassProxy covers failures to reach the provider through an egress proxy
+	ErrorClassProxy ErrorClass = "proxy"
+	// ErrorClassThrottled covers rate limiting (HTTP 429, Twilio 20429/30022)
+	ErrorClassThrottled ErrorClass = "throttled"
+	// ErrorClassServer covers provider outages (HTTP 5xx, 408)
+	ErrorClassServer ErrorClass = "server"
+	// ErrorClassTransient covers temporary refusals such as SMTP 4xx greylisting
+	ErrorClassTransient ErrorClass = "transient"
+	// ErrorClassRejected covers permanent refusals: invalid recipients, 4xx, SMTP 5xx
+	ErrorClassRejected ErrorClass = "rejected"
+	// ErrorClassUnknown covers errors that could not be classified
+	ErrorClassUnknown ErrorClass = "unknown"
+)
+
+// KnownErrorClass reports whether class is one of the defined error classes
+func KnownErrorClass(class ErrorClass) bool {
+	switch class {
+	case ErrorClassNetwork, ErrorClassProxy, ErrorClassThrottled, ErrorClassServer, ErrorClassTransient, ErrorClassRejected, ErrorClassUnknown:
+		return true
+	}
+	return false
+}
+
+// RetryPolicy says how a channel retries failed deliveries. Delays start at
+// InitialBackoffMs and grow by Multiplier up to MaxBackoffMs, randomized by ±Jitter.
+type RetryPolicy struct {
+	Channel          NotificationType `json:"channel"`
+	MaxAttempts      int              `json:"max_attempts"`
+	InitialBackoffMs int              `json:"initial_backoff_ms"`
+	MaxBackoffMs     int              `json:"max_backoff_ms"`
+	Multiplier       float64          `json:"multiplier"`
+	Jitter           float64          `json:"jitter"`
+	RetryOn          []ErrorClass     `json:"retry_on"`
+	HonorRetryAfter  bool             `json:"honor_retry_after"`
+	Source           string           `json:"source,omitempty"`
+}
+
+// PriorityRetryPolicy is how many times stored notifications of a priority are retried
+// and how the channel's backoff is scaled between their retries
+type PriorityRetryPolicy struct {
+	Priority     Priority `json:"priority"`
+	MaxRetries   int      `json:"max_retries"`
+	BackoffScale float64  `json:"backoff_scale"`
+}
+
+// ProviderThrottleState is a provider's slowdown after it throttled deliveries
+type ProviderThrottleState struct {
+	Provider    string           `json:"provider"`
+	Channel     NotificationType `json:"channel"`
+	Throttled   bool             `json:"throttled"`
+	Since       *time.Time       `json:"since,omitempty"`
+	Until       *time.Time       `json:"until,omitempty"`
+	RemainingMs int64            `json:"remaining_ms,omitempty"`
+	Reason      string           `json:"reason,omitempty"`
+}
+
+// Circuit states of a routed provider
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half_open"
+)
+
+// ProviderRoute is the health of one of a channel's providers and the share of new
+// sends routed to it. SuccessRate and LatencyMs are moving averages of recent sends.
+type ProviderRoute struct {
+	Channel             NotificationType `json:"channel"`
+	Provider            string           `json:"provider"`
+	Weight              float64          `json:"weight"`
+	SuccessRate         float64          `json:"success_rate"`
+	LatencyMs           float64          `json:"latency_ms"`
+	Sends               int64            `json:"sends"`
+	ConsecutiveFailures int              `json:"consecutive_failures"`
+	Circuit             string           `json:"circuit"`
+	OpenUntil           *time.Time       `json:"open_until,omitempty"`
+}
+
+// Retries reports whether the policy retries errors of class
+func (p RetryPolicy) Retries(class ErrorClass) bool {
+	for _, retryable := range p.RetryOn {
+		if retryable == class {
+			return true
+		}
+	}
+	return false
+}
+
+// ProviderExchange is one captured request to a channel provider and its response
+type ProviderExchange struct {
+	Channel    NotificationType `json:"channel"`
+	Provider   string           `json:"provider"`
+	Endpoint   string           `json:"endpoint"`
+	Attempt    int              `json:"attempt"`
+	Request    ProviderPayload  `json:"request"`
+	Response   *ProviderPayload `json:"response,omitempty"`
+	StatusCode int              `json:"status_code,omitempty"`
+	Error      string           `json:"error,omitempty"`
+	DurationMs int64            `json:"duration_ms"`
+	CapturedAt time.Time        `json:"captured_at"`
+}
+
+// ProviderPayload is a captured request or response with secrets redacted. Size is the
+// original body length; Truncated is set when the body was cut to the capture limit.
+type ProviderPayload struct {
+	Headers   map[string]string `json:"headers,omitempty"`
+	Body      string            `json:"body"`
+	Size      int               `json:"size"`
+	Truncated bool              `json:"truncated,omitempty"`
+}
+
+// PayloadLogSettings control HTTP payload logging: the share of requests sampled, the
+// paths they are sampled on (all when empty), the headers logged and how much of each
+// body. Source is "config" for the startup settings or "admin" for an override, which
+// lapses at ExpiresAt.
+type PayloadLogSettings struct {
+	Enabled      bool       `json:"enabled"`
+	SampleRate   float64    `json:"sample_rate"`
+	MaxBodyBytes int        `json:"max_body_bytes"`
+	Headers      []string   `json:"headers"`
+	Paths        []string   `json:"paths,omitempty"`
+	TTLSeconds   int        `json:"ttl_seconds,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	Source       string     `json:"source"`
+}
+
+// HTTPExchange is a sampled API request and its response as captured, before masking.
+// Bodies are cut to the sampling settings' MaxBodyBytes.
+type HTTPExchange struct {
+	Method            string
+	Path              string
+	Route             string
+	Status            int
+	Duration          time.Duration
+	RequestHeader     http.Header
+	RequestBody       []byte
+	RequestTruncated  bool
+	ResponseHeader    http.Header
+	ResponseBody      []byte
+	ResponseTruncated bool
+}
+
+// WebhookAttempt is one POST of a webhook notification
+type WebhookAttempt struct {
+	Attempt     int       `json:"attempt"`
+	URL         string    `json:"url"`
+	StatusCode  int       `json:"status_code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	DurationMs  int64     `json:"duration_ms"`
+	AttemptedAt time.Time `json:"attempted_at"`
+}
+
+// WebhookStats totals webhook deliveries; ByStatus counts attempts per response status,
+// with "error" for attempts that got no response
+type WebhookStats struct {
+	Delivered           int64            `json:"delivered"`
+	Failed              int64            `json:"failed"`
+	DeliveredAfterRetry int64            `json:"delivered_after_retry"`
+	Attempts            int64            `json:"attempts"`
+	SuccessRate         float64          `json:"success_rate"`
+	ByStatus            map[string]int64 `json:"by_status"`
+}
+
+// EngagementEventType is a recipient interaction with a delivered notification
+type EngagementEventType string
+
+const (
+	EngagementOpened       EngagementEventType = "opened"
+	EngagementClicked      EngagementEventType = "clicked"
+	EngagementAcknowledged EngagementEventType = "acknowledged"
+	EngagementRead         EngagementEventType = "read"
+	EngagementSnoozed      EngagementEventType = "snoozed"
+	EngagementReplied      EngagementEventType = "replied"
+)
+
+// EngagementEvent is one entry in the append-only engagement event stream
+type EngagementEvent struct {
+	ID             int64               `json:"id"`
+	Type           EngagementEventType `json:"type"`
+	NotificationID string              `json:"notification_id"`
+	CustomerID     string              `json:"customer_id"`
+	Channel        NotificationType    `json:"channel,omitempty"`
+	OccurredAt     time.Time           `json:"occurred_at"`
+	RecordedAt     time.Time           `json:"recorded_at"`
+	Attributes     map[string]string   `json:"attributes,omitempty"`
+}
+
+// RecordEngagementEventRequest reports an interaction; occurred_at defaults to now.
+// Attributes carry event details such as the clicked URL or the snooze deadline.
+type RecordEngagementEventRequest struct {
+	Type           EngagementEventType `json:"type" binding:"required,oneof=opened clicked acknowledged read snoozed replied"`
+	NotificationID string              `json:"notification_id" binding:"required"`
+	CustomerID     string              `json:"customer_id" binding:"required"`
+	Channel        NotificationType    `json:"channel,omitempty"`
+	OccurredAt     *time.Time          `json:"occurred_at,omitempty"`
+	Attributes     map[string]string   `json:"attributes,omitempty"`
+}
+
+// EngagementRollup counts one engagement event type over a query window
+type EngagementRollup struct {
+	Type          EngagementEventType `json:"type"`
+	Events        int64               `json:"events"`
+	Customers     int64               `json:"customers"`
+	Notifications int64               `json:"notifications"`
+}
+
+// EngagementRates are the share of sent notifications that were opened and clicked,
+// overall or for one template. A notification counts once however often it was opened
+// or clicked, and a click counts as an open, since mail clients blocking images never
+// load the open pixel.
+type EngagementRates struct {
+	TemplateID       string  `json:"template_id,omitempty"`
+	Sent             int64   `json:"sent"`
+	Opened           int64   `json:"opened"`
+	Clicked          int64   `json:"clicked"`
+	OpenRate         float64 `json:"open_rate"`
+	ClickThroughRate float64 `json:"click_through_rate"`
+	ClickToOpenRate  float64 `json:"click_to_open_rate"`
+}
+
+// EngagementMetrics are the engagement events of a window rolled up per type, and the
+// open and click rates of the notifications sent on Channel in it
+type EngagementMetrics struct {
+	From    time.Time        `json:"from"`
+	To      time.Time        `json:"to"`
+	Channel NotificationType `json:"channel"`
+	EngagementRates
+	ByType     []EngagementRollup `json:"by_type"`
+	ByTemplate []EngagementRates  `json:"by_template"`
+}
+
+// SendTimeVariant is the arm of the send-time comparison a notification was assigned to
+type SendTimeVariant string
+
+const (
+	SendTimeOptimized SendTimeVariant = "optimized"
+	SendTimeControl   SendTimeVariant = "control"
+)
+
+// SendTimeProfile is a customer's positive engagement per UTC hour over the lookback
+// window. BestHour is -1 until there are enough events to trust.
+type SendTimeProfile struct {
+	CustomerID string    `json:"customer_id"`
+	Hours      [24]int64 `json:"hours"`
+	Events     int64     `json:"events"`
+	BestHour   int       `json:"best_hour"`
+	ComputedAt time.Time `json:"computed_at"`
+}
+
+// SendTimeVariantStats compares one arm of the send-time experiment: how many
+// notifications it got, how many were delayed, and how many were engaged with, counting
+// each engagement type once per notification
+type SendTimeVariantStats struct {
+	Variant        SendTimeVariant                 `json:"variant"`
+	Assigned       int64                           `json:"assigned"`
+	Delayed        int64                           `json:"delayed"`
+	Engaged        map[EngagementEventType]int64   `json:"engaged"`
+	EngagementRate map[EngagementEventType]float64 `json:"engagement_rate"`
+}
+
+// Reasons a notification is dead-lettered
+const (
+	DeadLetterRetriesExhausted = "retries_exhausted"
+	DeadLetterDeliveryFailed   = "delivery_failed"
+	DeadLetterFallbackFailed   = "fallback_failed"
+)
+
+// DeadLetter is a notification whose delivery failed for good, kept with its error
+// chain until it is re-driven or discarded. ID is its entry in the dead-letter stream.
+type DeadLetter struct {
+	ID             string           `json:"id"`
+	NotificationID string           `json:"notification_id"`
+	Channel        NotificationType `json:"channel"`
+	Reason         string           `json:"reason"`
+	ErrorClass     ErrorClass       `json:"error_class,omitempty"`
+	Errors         []string         `json:"errors"`
+	Attempts       int              `json:"attempts"`
+	RedriveCount   int              `json:"redrive_count"`
+	DeadLetteredAt time.Time        `json:"dead_lettered_at"`
+	Notification   *Notification    `json:"notification"`
+	// Region is the data region the notification is stored in, which also keeps its
+	// errors and content; the home region's queue only holds the letter's IDs
+	Region string `json:"region,omitempty"`
+}
+
+// RedriveDeadLetterRequest re-sends a dead-lettered notification, on another channel
+// when one is given
+type RedriveDeadLetterRequest struct {
+	Channel NotificationType `json:"channel,omitempty"`
+}
+
+// SigningKeyStatus is where a signing key is in its lifecycle
+type SigningKeyStatus string
+
+const (
+	SigningKeyScheduled  SigningKeyStatus = "scheduled"
+	SigningKeyActive     SigningKeyStatus = "active"
+	SigningKeySuperseded SigningKeyStatus = "superseded"
+	SigningKeyExpired    SigningKeyStatus = "expired"
+)
+
+// SigningKey is the public half of a key webhooks and WebSocket messages are signed
+// with. It signs from ActivatesAt until ExpiresAt, or until a newer key activates, and
+// is published for verification until PublishedUntil.
+type SigningKey struct {
+	ID             string           `json:"kid"`
+	Algorithm      string           `json:"alg"`
+	PublicKey      string           `json:"public_key"`
+	Status         SigningKeyStatus `json:"status"`
+	CreatedAt      time.Time        `json:"created_at"`
+	ActivatesAt    time.Time        `json:"activates_at"`
+	ExpiresAt      *time.Time       `json:"expires_at,omitempty"`
+	PublishedUntil *time.Time       `json:"published_until,omitempty"`
+}
+
+// SigningKeyRequest sets a signing key's activation window. On creation an unset start
+// is now and an unset end is a rotation period later.
+type SigningKeyRequest struct {
+	ActivatesAt *time.Time `json:"activates_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+// JWK is a public key in JSON Web Key form (RFC 7517); Ed25519 keys are OKP keys
+// (RFC 8037)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+}
+
+// JWKSet is the published set of signing keys consumers verify signatures with
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Listener is an address the HTTP server accepts connections on
+type Listener struct {
+	Network string `json:"network"`
+	Address string `json:"address"`
+}
+
+// ServiceInfo describes the running service instance
+type ServiceInfo struct {
+	Service     string     `json:"service"`
+	Version     string     `json:"version"`
+	Environment string     `json:"environment"`
+	Listeners   []Listener `json:"listeners"`
+}
+
+// Capabilities describes what this deployment supports, so frontends and producers can
+// adapt at runtime instead of assuming it
+type Capabilities struct {
+	Service   string               `json:"service"`
+	Channels  []ChannelCapability  `json:"channels"`
+	Providers []ProviderCapability `json:"providers"`
+	Limits    CapabilityLimits     `json:"limits"`
+	Retention RetentionPolicy      `json:"retention"`
+	Sandbox   SandboxMode          `json:"sandbox"`
+}
+
+// ChannelCapability reports whether notifications of a type can be delivered. Push has a
+// provider slot but no delivery yet, so it is never enabled.
+type ChannelCapability struct {
+	Channel    NotificationType        `json:"channel"`
+	Enabled    bool                    `json:"enabled"`
+	Provider   string                  `json:"provider"`
+	Configured bool                    `json:"configured"`
+	Throttles  []ProviderThrottleState `json:"throttles,omitempty"`
+}
+
+// ProviderCapability reports whether an integration outside the channels is configured
+type ProviderCapability struct {
+	Name       string `json:"name"`
+	Purpose    string `json:"purpose"`
+	Configured bool   `json:"configured"`
+	Mode       string `json:"mode,omitempty"`
+}
+
+// CapabilityLimits are the request limits and delivery rate limits in force
+type CapabilityLimits struct {
+	MaxBulkNotifications       int  `json:"max_bulk_notifications"`
+	BulkWorkers                int  `json:"bulk_workers"`
+	TenantFairness             bool `json:"tenant_fairness"`
+	MaxInFlightDeliveries      int  `json:"max_in_flight_deliveries,omitempty"`
+	TenantMaxInFlight          int  `json:"tenant_max_in_flight,omitempty"`
+	ProviderThrottleMaxSeconds int  `json:"provider_throttle_max_seconds"`
+	SilentPushPerHour          int  `json:"silent_push_per_hour"`
+}
+
+// RetentionPolicy is how long the service keeps each kind of data. Notifications
+// themselves stay in the database; Redis only caches them.
+type RetentionPolicy struct {
+	NotificationCacheSeconds   int `json:"notification_cache_seconds"`
+	DeadLetterMaxEntries       int `json:"dead_letter_max_entries"`
+	WebhookAttemptsHours       int `json:"webhook_attempts_hours"`
+	ProviderPayloadSampleHours int `json:"provider_payload_sample_hours"`
+	UsageMinuteHours           int `json:"usage_minute_hours"`
+	UsageHourDays              int `json:"usage_hour_days"`
+	ConversationDays           int `json:"conversation_days"`
+	WebSocketResumeSeconds     int `json:"websocket_resume_seconds"`
+}
+
+// SandboxMode flags deployments whose behaviour isn't production's: a non-production
+// environment, synthetic demo traffic or injected failures
+type SandboxMode struct {
+	Enabled          bool   `json:"enabled"`
+	Environment      string `json:"environment"`
+	DemoEndpoints    bool   `json:"demo_endpoints"`
+	FailureInjection bool   `json:"failure_injection"`
+	ChaosExperiment  string `json:"chaos_experiment,omitempty"`
+}
+
 // HealthCheck response
 type HealthResponse struct {
 	Status      string    `json:"status"`
@@ -327,4 +2006,214 @@ type HealthResponse struct {
 	Version     string    `json:"version"`
 	Uptime      string    `json:"uptime"`
 	Checks      map[string]interface{} `json:"checks,omitempty"`
-}
\ No newline at end of file
+}
+
+// DependencyStatus is the state of one dependency in a readiness check
+type DependencyStatus string
+
+const (
+	DependencyUp       DependencyStatus = "up"
+	DependencyDown     DependencyStatus = "down"
+	DependencyDisabled DependencyStatus = "disabled"
+)
+
+// DependencyCheck is the result of checking one dependency for readiness. Disabled
+// dependencies aren't configured and don't affect readiness.
+type DependencyCheck struct {
+	Status    DependencyStatus `json:"status"`
+	LatencyMs float64          `json:"latency_ms"`
+	Error     string           `json:"error,omitempty"`
+	Detail    string           `json:"detail,omitempty"`
+}
+
+// ReadinessReport is the outcome of checking every dependency; the service is ready
+// when none is down
+type ReadinessReport struct {
+	Ready     bool                       `json:"ready"`
+	Checks    map[string]DependencyCheck `json:"checks"`
+	CheckedAt time.Time                  `json:"checked_at"`
+}
+// DevicePlatform is the push platform of a registered device
+type DevicePlatform string
+
+const (
+	DevicePlatformIOS     DevicePlatform = "ios"
+	DevicePlatformAndroid DevicePlatform = "android"
+	DevicePlatformWeb     DevicePlatform = "web"
+)
+
+// DevicePlatforms lists every device platform
+var DevicePlatforms = []DevicePlatform{DevicePlatformIOS, DevicePlatformAndroid, DevicePlatformWeb}
+
+// Device is an app install of a customer that can receive push notifications. iOS
+// devices are sent APNs payloads, Android and web ones FCM messages. LastSeenAt moves
+// each time the app registers, and devices unseen for DEVICE_STALE_DAYS are pruned.
+type Device struct {
+	ID         string         `json:"id"`
+	CustomerID string         `json:"customer_id"`
+	Platform   DevicePlatform `json:"platform"`
+	Token      string         `json:"token"`
+	AppVersion string         `json:"app_version,omitempty"`
+	Locale     string         `json:"locale,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+	LastSeenAt time.Time      `json:"last_seen_at"`
+}
+
+// RegisterDeviceRequest registers a device or refreshes it; apps send it on every launch
+type RegisterDeviceRequest struct {
+	Platform   DevicePlatform `json:"platform" binding:"required,oneof=ios android web"`
+	Token      string         `json:"token" binding:"required,max=4096"`
+	AppVersion string         `json:"app_version" binding:"max=64"`
+	Locale     string         `json:"locale" binding:"max=35"`
+}
+
+// DeviceTarget picks a customer's devices for a push notification: the listed devices,
+// the devices on the listed platforms, or with both, the listed devices on those
+// platforms
+type DeviceTarget struct {
+	DeviceIDs []string         `json:"device_ids,omitempty"`
+	Platforms []DevicePlatform `json:"platforms,omitempty" binding:"dive,oneof=ios android web"`
+}
+
+// Matches reports whether a device is picked by the target
+func (t *DeviceTarget) Matches(device *Device) bool {
+	if len(t.DeviceIDs) > 0 && !slices.Contains(t.DeviceIDs, device.ID) {
+		return false
+	}
+	return len(t.Platforms) == 0 || slices.Contains(t.Platforms, device.Platform)
+}
+
+// SMSKeyword is what a customer's reply to an SMS asks for
+type SMSKeyword string
+
+const (
+	SMSKeywordStop  SMSKeyword = "stop"
+	SMSKeywordStart SMSKeyword = "start"
+	SMSKeywordHelp  SMSKeyword = "help"
+)
+
+// InboundSMS is a message a customer sent to the service's number, as posted by Twilio
+type InboundSMS struct {
+	MessageSID string `json:"message_sid" form:"MessageSid"`
+	From       string `json:"from" form:"From"`
+	To         string `json:"to" form:"To"`
+	Body       string `json:"body" form:"Body"`
+}
+
+// SMSKeywordResult is the outcome of an inbound SMS: the keyword it carried, if any, the
+// customer last sent an SMS at the number, and the reply to send back
+type SMSKeywordResult struct {
+	Keyword    SMSKeyword `json:"keyword,omitempty"`
+	CustomerID string     `json:"customer_id,omitempty"`
+	Reply      string     `json:"reply,omitempty"`
+}
+
+// SMSOptOut is a phone number on the SMS opt-out list, with the keyword it replied
+type SMSOptOut struct {
+	PhoneNumber string    `json:"phone_number"`
+	CustomerID  string    `json:"customer_id,omitempty"`
+	Keyword     string    `json:"keyword"`
+	OptedOutAt  time.Time `json:"opted_out_at"`
+}
+
+// How an inbound email was matched to the notification it replies to
+const (
+	// ReplyCorrelatedAddress is a reply sent to the notification's signed reply-to address
+	ReplyCorrelatedAddress = "reply_to"
+	// ReplyCorrelatedThread is a reply whose In-Reply-To names the notification's
+	// Message-ID, sent from the address the notification went to
+	ReplyCorrelatedThread = "in_reply_to"
+)
+
+// InboundEmail is an email received by the inbound webhook, as posted by SendGrid Inbound
+// Parse or as JSON by any other relay. Headers are the raw message headers and Envelope
+// SendGrid's SMTP envelope, a JSON object with to and from.
+type InboundEmail struct {
+	From     string `json:"from" form:"from"`
+	To       string `json:"to" form:"to"`
+	Cc       string `json:"cc" form:"cc"`
+	Subject  string `json:"subject" form:"subject"`
+	Text     string `json:"text" form:"text"`
+	HTML     string `json:"html" form:"html"`
+	Headers  string `json:"headers" form:"headers"`
+	Envelope string `json:"envelope" form:"envelope"`
+}
+
+// EmailReply is a customer's reply to an email notification, matched to the notification
+// and its order. Text is the reply without the quoted original.
+type EmailReply struct {
+	ID             string    `json:"id"`
+	NotificationID string    `json:"notification_id"`
+	ConversationID string    `json:"conversation_id,omitempty"`
+	CustomerID     string    `json:"customer_id,omitempty"`
+	OrderID        string    `json:"order_id,omitempty"`
+	From           string    `json:"from"`
+	Subject        string    `json:"subject,omitempty"`
+	Text           string    `json:"text"`
+	CorrelatedBy   string    `json:"correlated_by"`
+	ReceivedAt     time.Time `json:"received_at"`
+}
+
+// InboundEmailResult is the outcome of an inbound email: the reply it was matched as, or
+// why it wasn't
+type InboundEmailResult struct {
+	Correlated bool        `json:"correlated"`
+	Reply      *EmailReply `json:"reply,omitempty"`
+	Reason     string      `json:"reason,omitempty"`
+}
+
+// Directions of the messages of a conversation
+const (
+	ConversationOutbound = "outbound"
+	ConversationInbound  = "inbound"
+)
+
+// Conversation is the thread of notifications sent for an order or customer on every
+// channel, with the replies they got, oldest first
+type Conversation struct {
+	ID             string                `json:"id"`
+	CustomerID     string                `json:"customer_id,omitempty"`
+	OrderID        string                `json:"order_id,omitempty"`
+	Channels       []NotificationType    `json:"channels"`
+	Messages       []ConversationMessage `json:"messages"`
+	StartedAt      time.Time             `json:"started_at"`
+	LastActivityAt time.Time             `json:"last_activity_at"`
+	// Truncated is set when older notifications were left out of Messages
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// ConversationMessage is a notification sent in a conversation or a reply received in it
+type ConversationMessage struct {
+	Direction    string           `json:"direction"`
+	Channel      NotificationType `json:"channel"`
+	At           time.Time        `json:"at"`
+	Notification *Notification    `json:"notification,omitempty"`
+	Reply        *EmailReply      `json:"reply,omitempty"`
+}
+
+// CustomerDigest is a batch of a customer's low-priority notifications on one channel,
+// sent as a single message when its interval ends or it holds enough of them
+type CustomerDigest struct {
+	ID              string           `json:"id"`
+	CustomerID      string           `json:"customer_id"`
+	Channel         NotificationType `json:"channel"`
+	NotificationIDs []string         `json:"notification_ids"`
+	DueAt           *time.Time       `json:"due_at,omitempty"`
+	SentAt          *time.Time       `json:"sent_at,omitempty"`
+	Error           string           `json:"error,omitempty"`
+}
+
+// DataResidency is where this deployment stores each tenant's notifications
+type DataResidency struct {
+	HomeRegion string            `json:"home_region,omitempty"`
+	Regions    []RegionResidency `json:"regions"`
+}
+
+// RegionResidency is a data region with the tenants pinned to it and the state of its
+// stores. Redis and Database are "ok", "not_configured" or the error reaching them.
+type RegionResidency struct {
+	Region   string   `json:"region"`
+	Tenants  []string `json:"tenants"`
+	Redis    string   `json:"redis"`
+	Database string   `json:"database"`
+}
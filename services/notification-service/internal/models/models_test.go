@@ -0,0 +1,163 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// benchmarkHub returns a hub with one connection for each of customers customers
+func benchmarkHub(customers int) *Hub {
+	hub := NewWebSocketHub()
+	for i := 0; i < customers; i++ {
+		hub.addLocked(&Client{
+			Hub:        hub,
+			Send:       make(chan []byte, 1),
+			CustomerID: fmt.Sprintf("customer-%d", i),
+		})
+	}
+	return hub
+}
+
+// connect registers a client of customerID whose send channel holds capacity messages
+func connect(hub *Hub, customerID string, capacity int) *Client {
+	client := &Client{Hub: hub, Send: make(chan []byte, capacity), CustomerID: customerID}
+	hub.mutex.Lock()
+	hub.addLocked(client)
+	hub.mutex.Unlock()
+	return client
+}
+
+func TestSendToCustomer(t *testing.T) {
+	hub := NewWebSocketHub()
+	first, second := connect(hub, "customer-1", 1), connect(hub, "customer-1", 1)
+	other := connect(hub, "customer-2", 1)
+
+	message := map[string]interface{}{"subject": "Order Update"}
+	if err := hub.SendToCustomer(context.Background(), "customer-1", message); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, client := range []*Client{first, second} {
+		select {
+		case payload := <-client.Send:
+			var received WebSocketMessage
+			if err := json.Unmarshal(payload, &received); err != nil {
+				t.Fatalf("connection %d: %v", i, err)
+			}
+			data, _ := received.Data.(map[string]interface{})
+			if received.Type != "notification" || data["subject"] != "Order Update" || received.Timestamp.IsZero() {
+				t.Errorf("connection %d received %s", i, payload)
+			}
+			if received.ID != "" || received.Replayed {
+				t.Errorf("connection %d: unbuffered message has id %q, replayed %t", i, received.ID, received.Replayed)
+			}
+		default:
+			t.Errorf("connection %d of the customer received nothing", i)
+		}
+	}
+	if len(other.Send) != 0 {
+		t.Error("another customer's connection received the message")
+	}
+}
+
+func TestSendToCustomerCancelled(t *testing.T) {
+	hub := NewWebSocketHub()
+	client := connect(hub, "customer-1", 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := hub.SendToCustomer(ctx, "customer-1", "hello"); !errors.Is(err, context.Canceled) {
+		t.Errorf("error = %v, want context.Canceled", err)
+	}
+	if len(client.Send) != 0 {
+		t.Error("message was delivered after the context was cancelled")
+	}
+}
+
+func TestSendToCustomerDropsFullConnection(t *testing.T) {
+	hub := NewWebSocketHub()
+	var offline []string
+	hub.SetPresenceHook(func(customerID string, online bool) {
+		if !online {
+			offline = append(offline, customerID)
+		}
+	})
+	slow, fast := connect(hub, "customer-1", 0), connect(hub, "customer-1", 1)
+
+	if err := hub.SendToCustomer(context.Background(), "customer-1", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := hub.Clients[slow]; ok {
+		t.Error("connection with a full send channel was kept")
+	}
+	if connections := hub.customers["customer-1"]; len(connections) != 1 || connections[0] != fast {
+		t.Errorf("index = %v, want only the connection that kept up", connections)
+	}
+	if len(fast.Send) != 1 {
+		t.Error("remaining connection missed the message")
+	}
+	if len(offline) != 0 {
+		t.Errorf("customer went offline with a connection left: %v", offline)
+	}
+
+	hub.mutex.Lock()
+	hub.removeLocked(fast)
+	hub.mutex.Unlock()
+	if _, ok := hub.customers["customer-1"]; ok {
+		t.Error("customer is still indexed without connections")
+	}
+	if len(offline) != 1 || offline[0] != "customer-1" {
+		t.Errorf("offline = %v, want customer-1 once", offline)
+	}
+}
+
+// sendScanning delivers to a customer's connections by scanning every client, as
+// SendToCustomer did before connections were indexed by customer
+func (h *Hub) sendScanning(customerID string, message interface{}) error {
+	messageBytes, err := json.Marshal(WebSocketMessage{Type: "notification", Data: message, Timestamp: time.Now()})
+	if err != nil {
+		return err
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for client := range h.Clients {
+		if client.CustomerID == customerID {
+			h.deliverLocked(client, queuedMessage{payload: messageBytes})
+		}
+	}
+	return nil
+}
+
+// BenchmarkSendToCustomer compares a targeted send through the per-customer index with
+// a scan of every connection. The index keeps the cost flat as connections grow; the
+// scan grows with them.
+func BenchmarkSendToCustomer(b *testing.B) {
+	message := map[string]interface{}{"subject": "Order Update", "message": "Your order has shipped"}
+	for _, connections := range []int{100, 1000, 10000} {
+		hub := benchmarkHub(connections)
+		customerID := fmt.Sprintf("customer-%d", connections/2)
+		target := hub.customers[customerID][0]
+
+		b.Run(fmt.Sprintf("index/%d", connections), func(b *testing.B) {
+			ctx := context.Background()
+			for i := 0; i < b.N; i++ {
+				if err := hub.SendToCustomer(ctx, customerID, message); err != nil {
+					b.Fatal(err)
+				}
+				<-target.Send
+			}
+		})
+		b.Run(fmt.Sprintf("scan/%d", connections), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := hub.sendScanning(customerID, message); err != nil {
+					b.Fatal(err)
+				}
+				<-target.Send
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"notification-service/internal/models"
+	"notification-service/internal/services"
+	"notification-service/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Stage identifies a step of the inbound event pipeline
+type Stage string
+
+const (
+	StageDecode    Stage = "decode"
+	StageValidate  Stage = "validate"
+	StageEnrich    Stage = "enrich"
+	StageTransform Stage = "transform"
+	StageDispatch  Stage = "dispatch"
+)
+
+// stageOrder is the fixed order stages run in
+var stageOrder = []Stage{StageDecode, StageValidate, StageEnrich, StageTransform, StageDispatch}
+
+// Message carries an inbound event through the pipeline
+type Message struct {
+	Raw          []byte
+	Event        *services.OrderEvent
+	Enrichment   map[string]interface{}
+	Notification *models.WebSocketMessage
+}
+
+// Handler processes a message
+type Handler func(ctx context.Context, msg *Message) error
+
+// Middleware wraps a handler, doing its work before and/or after calling next
+type Middleware func(next Handler) Handler
+
+// Pipeline composes middleware registered per stage into a single handler
+type Pipeline struct {
+	mutex  sync.RWMutex
+	stages map[Stage][]Middleware
+}
+
+// New creates an empty pipeline
+func New() *Pipeline {
+	return &Pipeline{
+		stages: make(map[Stage][]Middleware),
+	}
+}
+
+// Use registers middleware for a stage. Middleware within a stage runs in registration order.
+func (p *Pipeline) Use(stage Stage, middleware ...Middleware) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.stages[stage] = append(p.stages[stage], middleware...)
+}
+
+// Execute runs a raw message through every stage
+func (p *Pipeline) Execute(ctx context.Context, raw []byte) (*Message, error) {
+	msg := &Message{
+		Raw:        raw,
+		Enrichment: make(map[string]interface{}),
+	}
+	return msg, p.build()(ctx, msg)
+}
+
+// build chains the registered middleware, outermost first
+func (p *Pipeline) build() Handler {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	var handler Handler = func(ctx context.Context, msg *Message) error { return nil }
+	for i := len(stageOrder) - 1; i >= 0; i-- {
+		stage := stageOrder[i]
+		middleware := p.stages[stage]
+		for j := len(middleware) - 1; j >= 0; j-- {
+			handler = traced(stage, middleware[j], handler)
+		}
+	}
+	return handler
+}
+
+// traced records a child span around a single middleware invocation. The span covers
+// the stages after it too, so an error is recorded only on the span of the middleware
+// it came from, not again on each span it passes back up through.
+func traced(stage Stage, middleware Middleware, next Handler) Handler {
+	if telemetry.Tracer == nil {
+		return middleware(next)
+	}
+	return func(ctx context.Context, msg *Message) error {
+		ctx, span := telemetry.Tracer.Start(ctx, "pipeline."+string(stage),
+			trace.WithAttributes(attribute.String("pipeline.stage", string(stage))),
+		)
+		defer span.End()
+
+		var downstreamErr error
+		err := middleware(func(ctx context.Context, msg *Message) error {
+			downstreamErr = next(ctx, msg)
+			return downstreamErr
+		})(ctx, msg)
+		if err != nil && (downstreamErr == nil || !errors.Is(err, downstreamErr)) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		return err
+	}
+}
@@ -0,0 +1,444 @@
+package router
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// MIME types handlers and bindings tell requests apart by
+const (
+	MIMEJSON              = "application/json"
+	MIMEPOSTForm          = "application/x-www-form-urlencoded"
+	MIMEMultipartPOSTForm = "multipart/form-data"
+)
+
+// maxMultipartMemory is how much of a multipart form is held in memory when it is
+// bound; the rest goes to temporary files
+const maxMultipartMemory = 32 << 20
+
+// H is a JSON object, as handlers write them
+type H map[string]interface{}
+
+// HandlerFunc handles a request, or runs as middleware around the rest of its route's
+// handlers
+type HandlerFunc func(c *Context)
+
+// Param is a route parameter, such as id of /notifications/:id
+type Param struct {
+	Key   string
+	Value string
+}
+
+// Context carries a request through its route's middleware and handlers. It is the
+// same in both router modes, so handlers don't depend on the router underneath.
+type Context struct {
+	Request *http.Request
+	// Writer is the response; middleware may wrap it
+	Writer ResponseWriter
+
+	params   []Param
+	route    string
+	handlers []HandlerFunc
+	index    int
+	keys     map[string]interface{}
+	query    url.Values
+}
+
+// abortIndex is past any chain, so Next runs nothing more once it is reached
+const abortIndex = math.MaxInt / 2
+
+func newContext(w http.ResponseWriter, r *http.Request, route string, params []Param, handlers []HandlerFunc) *Context {
+	return &Context{
+		Request:  r,
+		Writer:   &responseWriter{ResponseWriter: w, status: http.StatusOK, size: noWritten},
+		params:   params,
+		route:    route,
+		handlers: handlers,
+		index:    -1,
+	}
+}
+
+// run runs the chain and writes the response status when no handler wrote anything
+func (c *Context) run() {
+	c.Next()
+	c.Writer.WriteHeaderNow()
+}
+
+// Next runs the handlers after the current one; middleware calls it to wrap them
+func (c *Context) Next() {
+	c.index++
+	for c.index < len(c.handlers) {
+		c.handlers[c.index](c)
+		c.index++
+	}
+}
+
+// Abort stops the handlers after the current one from running
+func (c *Context) Abort() {
+	c.index = abortIndex
+}
+
+// IsAborted reports whether Abort was called
+func (c *Context) IsAborted() bool {
+	return c.index >= abortIndex
+}
+
+// AbortWithStatus aborts with status and no body
+func (c *Context) AbortWithStatus(status int) {
+	c.Status(status)
+	c.Writer.WriteHeaderNow()
+	c.Abort()
+}
+
+// AbortWithStatusJSON aborts with status and obj as the JSON body
+func (c *Context) AbortWithStatusJSON(status int, obj interface{}) {
+	c.Abort()
+	c.JSON(status, obj)
+}
+
+// FullPath is the template of the matched route, such as /api/v1/notifications/:id,
+// or "" when no route matched
+func (c *Context) FullPath() string {
+	return c.route
+}
+
+// Param returns the value of a route parameter
+func (c *Context) Param(key string) string {
+	for _, param := range c.params {
+		if param.Key == key {
+			return param.Value
+		}
+	}
+	return ""
+}
+
+// Query returns the first value of a query string parameter
+func (c *Context) Query(key string) string {
+	value, _ := c.GetQuery(key)
+	return value
+}
+
+// DefaultQuery returns a query string parameter, or fallback when it isn't given
+func (c *Context) DefaultQuery(key, fallback string) string {
+	if value, ok := c.GetQuery(key); ok {
+		return value
+	}
+	return fallback
+}
+
+// GetQuery returns a query string parameter and whether it was given
+func (c *Context) GetQuery(key string) (string, bool) {
+	if c.query == nil {
+		c.query = c.Request.URL.Query()
+	}
+	values, ok := c.query[key]
+	if !ok || len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
+
+// GetHeader returns a request header
+func (c *Context) GetHeader(key string) string {
+	return c.Request.Header.Get(key)
+}
+
+// Header sets a response header, or removes it when value is empty
+func (c *Context) Header(key, value string) {
+	if value == "" {
+		c.Writer.Header().Del(key)
+		return
+	}
+	c.Writer.Header().Set(key, value)
+}
+
+// ContentType is the media type of the request body, without its parameters
+func (c *Context) ContentType() string {
+	mediaType, _, _ := strings.Cut(c.GetHeader("Content-Type"), ";")
+	return strings.TrimSpace(mediaType)
+}
+
+// ClientIP is the address of the client: the first valid address of X-Forwarded-For,
+// else X-Real-IP, else the peer's
+func (c *Context) ClientIP() string {
+	for _, forwarded := range strings.Split(c.GetHeader("X-Forwarded-For"), ",") {
+		if ip := strings.TrimSpace(forwarded); net.ParseIP(ip) != nil {
+			return ip
+		}
+	}
+	if ip := strings.TrimSpace(c.GetHeader("X-Real-IP")); net.ParseIP(ip) != nil {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(strings.TrimSpace(c.Request.RemoteAddr))
+	if err != nil {
+		return c.Request.RemoteAddr
+	}
+	return host
+}
+
+// Set keeps a value on the request for later handlers
+func (c *Context) Set(key string, value interface{}) {
+	if c.keys == nil {
+		c.keys = make(map[string]interface{})
+	}
+	c.keys[key] = value
+}
+
+// Get returns a value kept with Set
+func (c *Context) Get(key string) (interface{}, bool) {
+	value, ok := c.keys[key]
+	return value, ok
+}
+
+// GetString returns a string kept with Set, or ""
+func (c *Context) GetString(key string) string {
+	value, _ := c.keys[key].(string)
+	return value
+}
+
+// GetInt returns an int kept with Set, or 0
+func (c *Context) GetInt(key string) int {
+	value, _ := c.keys[key].(int)
+	return value
+}
+
+// Status sets the response status, written with the body or when the chain ends
+func (c *Context) Status(status int) {
+	c.Writer.WriteHeader(status)
+}
+
+// JSON writes obj as the JSON body
+func (c *Context) JSON(status int, obj interface{}) {
+	body, err := json.Marshal(obj)
+	if err != nil {
+		panic(err)
+	}
+	c.Data(status, "application/json; charset=utf-8", body)
+}
+
+// XML writes obj as the XML body
+func (c *Context) XML(status int, obj interface{}) {
+	body, err := xml.Marshal(obj)
+	if err != nil {
+		panic(err)
+	}
+	c.Data(status, "application/xml; charset=utf-8", body)
+}
+
+// Data writes body with its content type
+func (c *Context) Data(status int, contentType string, body []byte) {
+	c.Header("Content-Type", contentType)
+	c.Status(status)
+	if bodyAllowed(status) {
+		_, _ = c.Writer.Write(body)
+	} else {
+		c.Writer.WriteHeaderNow()
+	}
+}
+
+// Redirect redirects the client to location
+func (c *Context) Redirect(status int, location string) {
+	c.Writer.WriteHeaderNow()
+	http.Redirect(c.Writer, c.Request, location, status)
+}
+
+// bodyAllowed reports whether a response with status may have a body
+func bodyAllowed(status int) bool {
+	return !(status >= 100 && status <= 199 || status == http.StatusNoContent || status == http.StatusNotModified)
+}
+
+// ShouldBindJSON decodes the JSON request body into obj and validates it
+func (c *Context) ShouldBindJSON(obj interface{}) error {
+	if c.Request == nil || c.Request.Body == nil {
+		return errors.New("invalid request")
+	}
+	if err := json.NewDecoder(c.Request.Body).Decode(obj); err != nil {
+		return err
+	}
+	return Validate(obj)
+}
+
+// ShouldBind binds the request body, chosen by its content type, into obj and validates
+// it: JSON bodies by their json tags, and forms, or the query string of a GET, by the
+// form tags of obj's string, integer and boolean fields
+func (c *Context) ShouldBind(obj interface{}) error {
+	if c.Request.Method != http.MethodGet && c.ContentType() == MIMEJSON {
+		return c.ShouldBindJSON(obj)
+	}
+	if c.ContentType() == MIMEMultipartPOSTForm {
+		if err := c.Request.ParseMultipartForm(maxMultipartMemory); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+			return err
+		}
+	} else if err := c.Request.ParseForm(); err != nil {
+		return err
+	}
+	if err := bindForm(obj, c.Request.Form); err != nil {
+		return err
+	}
+	return Validate(obj)
+}
+
+// bindForm sets the fields of the struct obj points to from the form values named by
+// their form tags
+func bindForm(obj interface{}, form url.Values) error {
+	value := reflect.ValueOf(obj)
+	if value.Kind() != reflect.Pointer || value.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("cannot bind a form to %T", obj)
+	}
+	value = value.Elem()
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		name := field.Tag.Get("form")
+		if name == "" || name == "-" || !field.IsExported() {
+			continue
+		}
+		values, ok := form[name]
+		if !ok || len(values) == 0 {
+			continue
+		}
+		target := value.Field(i)
+		switch target.Kind() {
+		case reflect.String:
+			target.SetString(values[0])
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n, err := strconv.ParseInt(values[0], 10, target.Type().Bits())
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			target.SetInt(n)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(values[0])
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			target.SetBool(b)
+		default:
+			return fmt.Errorf("%s: cannot bind a form value to %s", name, target.Type())
+		}
+	}
+	return nil
+}
+
+// validate checks the binding tags of request structs
+var validate = func() *validator.Validate {
+	v := validator.New()
+	v.SetTagName("binding")
+	return v
+}()
+
+// Validate checks the binding tags of a struct, a pointer to one, or each element of a
+// slice of them
+func Validate(obj interface{}) error {
+	value := reflect.ValueOf(obj)
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	switch value.Kind() {
+	case reflect.Struct:
+		return validate.Struct(value.Interface())
+	case reflect.Slice, reflect.Array:
+		var errs []error
+		for i := 0; i < value.Len(); i++ {
+			if err := Validate(value.Index(i).Interface()); err != nil {
+				errs = append(errs, fmt.Errorf("[%d]: %w", i, err))
+			}
+		}
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// ResponseWriter is the response of a Context. It holds back the status until the body
+// is written, so middleware can still change it, and records the status and size.
+type ResponseWriter interface {
+	http.ResponseWriter
+	http.Hijacker
+	http.Flusher
+	// Status is the response status, written or pending
+	Status() int
+	// Size is the number of body bytes written, or -1 before the header is written
+	Size() int
+	// Written reports whether the header was written
+	Written() bool
+	// WriteHeaderNow writes the pending status
+	WriteHeaderNow()
+	WriteString(s string) (int, error)
+}
+
+const noWritten = -1
+
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if status > 0 && !w.Written() {
+		w.status = status
+	}
+}
+
+func (w *responseWriter) WriteHeaderNow() {
+	if !w.Written() {
+		w.size = 0
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.WriteHeaderNow()
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+func (w *responseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *responseWriter) Status() int {
+	return w.status
+}
+
+func (w *responseWriter) Size() int {
+	return w.size
+}
+
+func (w *responseWriter) Written() bool {
+	return w.size != noWritten
+}
+
+// Hijack hands the connection over, as a WebSocket upgrade does; the response then
+// counts as written with 101
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.size < 0 {
+		w.size = 0
+		w.status = http.StatusSwitchingProtocols
+	}
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+func (w *responseWriter) Flush() {
+	w.WriteHeaderNow()
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,73 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+)
+
+// ginRouter routes requests with a gin engine, which also logs, recovers and traces
+// them. Each matched route runs its handler chain on a Context; requests matching no
+// route get the root middleware and a 404.
+type ginRouter struct {
+	engine *gin.Engine
+	routes *routes
+}
+
+func newGinRouter(service string) *ginRouter {
+	engine := gin.New()
+	engine.Use(gin.Logger())
+	engine.Use(gin.Recovery())
+	engine.Use(otelgin.Middleware(service))
+
+	root := &routes{}
+	engine.NoRoute(func(gc *gin.Context) {
+		newContext(gc.Writer, gc.Request, "", nil, root.chain([]HandlerFunc{notFound})).run()
+	})
+	return &ginRouter{engine: engine, routes: root}
+}
+
+func (r *ginRouter) Use(middleware ...HandlerFunc) {
+	r.routes.use(middleware...)
+}
+
+func (r *ginRouter) Group(prefix string) Router {
+	return &ginRouter{engine: r.engine, routes: r.routes.group(prefix)}
+}
+
+func (r *ginRouter) Handle(method, path string, handlers ...HandlerFunc) {
+	route := r.routes.prefix + path
+	chain := r.routes.chain(handlers)
+	r.engine.Handle(method, route, func(gc *gin.Context) {
+		params := make([]Param, len(gc.Params))
+		for i, param := range gc.Params {
+			params[i] = Param{Key: param.Key, Value: param.Value}
+		}
+		newContext(gc.Writer, gc.Request, route, params, chain).run()
+	})
+}
+
+func (r *ginRouter) GET(path string, handlers ...HandlerFunc) {
+	r.Handle(http.MethodGet, path, handlers...)
+}
+
+func (r *ginRouter) POST(path string, handlers ...HandlerFunc) {
+	r.Handle(http.MethodPost, path, handlers...)
+}
+
+func (r *ginRouter) PUT(path string, handlers ...HandlerFunc) {
+	r.Handle(http.MethodPut, path, handlers...)
+}
+
+func (r *ginRouter) PATCH(path string, handlers ...HandlerFunc) {
+	r.Handle(http.MethodPatch, path, handlers...)
+}
+
+func (r *ginRouter) DELETE(path string, handlers ...HandlerFunc) {
+	r.Handle(http.MethodDelete, path, handlers...)
+}
+
+func (r *ginRouter) Handler() http.Handler {
+	return r.engine
+}
@@ -0,0 +1,99 @@
+package router
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Router modes selectable with ROUTER_MODE
+const (
+	ModeGin    = "gin"
+	ModeStdlib = "stdlib"
+)
+
+// Router registers routes independently of the HTTP router underneath. Handlers and
+// route middleware are HandlerFuncs run on a Context in both modes, with gin-style route
+// templates (/notifications/:id); only routing and the request-level middleware differ.
+type Router interface {
+	// Use adds middleware to routes registered afterwards
+	Use(middleware ...HandlerFunc)
+	// Group returns a router whose routes are prefixed with prefix and inherit the
+	// middleware added so far
+	Group(prefix string) Router
+	Handle(method, path string, handlers ...HandlerFunc)
+	GET(path string, handlers ...HandlerFunc)
+	POST(path string, handlers ...HandlerFunc)
+	PUT(path string, handlers ...HandlerFunc)
+	PATCH(path string, handlers ...HandlerFunc)
+	DELETE(path string, handlers ...HandlerFunc)
+	// Handler serves every route registered on the router and its groups
+	Handler() http.Handler
+}
+
+// New returns a router for mode, with request logging, panic recovery and OTel tracing
+// for service already in place. In gin mode the gin engine routes requests and otelgin
+// traces them; in stdlib mode a net/http ServeMux routes them, wrapped in net/http
+// middleware with otelhttp tracing, and gin isn't involved. Spans are named after the
+// route template either way.
+func New(mode, service string) (Router, error) {
+	switch mode {
+	case ModeGin, "":
+		return newGinRouter(service), nil
+	case ModeStdlib:
+		return newStdlibRouter(service), nil
+	default:
+		return nil, fmt.Errorf("unknown ROUTER_MODE %q, expected %s or %s", mode, ModeGin, ModeStdlib)
+	}
+}
+
+// muxPattern converts a gin route template to a ServeMux pattern:
+// /notifications/:id becomes /notifications/{id} and /files/*path becomes /files/{path...}
+func muxPattern(route string) string {
+	segments := strings.Split(route, "/")
+	for i, segment := range segments {
+		switch {
+		case strings.HasPrefix(segment, ":"):
+			segments[i] = "{" + segment[1:] + "}"
+		case strings.HasPrefix(segment, "*"):
+			segments[i] = "{" + segment[1:] + "...}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// routeParams returns the names of a route template's parameters, in order
+func routeParams(route string) []string {
+	var names []string
+	for _, segment := range strings.Split(route, "/") {
+		if strings.HasPrefix(segment, ":") || strings.HasPrefix(segment, "*") {
+			names = append(names, segment[1:])
+		}
+	}
+	return names
+}
+
+// routes is the prefix and middleware of a router or group. Both are copied into
+// groups, so middleware added to a group doesn't reach its parent's routes.
+type routes struct {
+	prefix     string
+	middleware []HandlerFunc
+}
+
+func (r *routes) use(middleware ...HandlerFunc) {
+	r.middleware = append(r.middleware[:len(r.middleware):len(r.middleware)], middleware...)
+}
+
+func (r *routes) group(prefix string) *routes {
+	return &routes{prefix: r.prefix + prefix, middleware: r.middleware[:len(r.middleware):len(r.middleware)]}
+}
+
+// chain is the middleware followed by a route's handlers
+func (r *routes) chain(handlers []HandlerFunc) []HandlerFunc {
+	return append(r.middleware[:len(r.middleware):len(r.middleware)], handlers...)
+}
+
+// notFound answers requests that match no route, after the root middleware
+func notFound(c *Context) {
+	c.Data(http.StatusNotFound, "text/plain; charset=utf-8", []byte("404 page not found"))
+}
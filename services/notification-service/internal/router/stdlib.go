@@ -0,0 +1,192 @@
+package router
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// stdlibServer is shared by a stdlib router and its groups
+type stdlibServer struct {
+	mux     *http.ServeMux
+	handler http.Handler
+}
+
+// stdlibRouter routes requests with a net/http ServeMux. Logging, panic recovery and
+// tracing are net/http middleware around the mux; each matched route then runs its
+// handler chain on a Context. Requests matching no route get the root middleware and a
+// 404, as in gin mode.
+type stdlibRouter struct {
+	server *stdlibServer
+	routes *routes
+}
+
+func newStdlibRouter(service string) *stdlibRouter {
+	server := &stdlibServer{mux: http.NewServeMux()}
+	root := &routes{}
+	server.mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		newContext(w, req, "", nil, root.chain([]HandlerFunc{notFound})).run()
+	})
+
+	// Matched routes rename the span after their template, as otelgin does; route metrics
+	// come from the service's own middleware, so otelhttp's are left out. Requests are
+	// logged and recovered inside the span, so their log records carry its trace.
+	server.handler = otelhttp.NewHandler(logRequests(recoverPanics(server.mux)), service,
+		otelhttp.WithServerName(service),
+		otelhttp.WithMeterProvider(noop.NewMeterProvider()),
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return fmt.Sprintf("HTTP %s route not found", r.Method)
+		}),
+	)
+
+	return &stdlibRouter{server: server, routes: root}
+}
+
+func (r *stdlibRouter) Use(middleware ...HandlerFunc) {
+	r.routes.use(middleware...)
+}
+
+func (r *stdlibRouter) Group(prefix string) Router {
+	return &stdlibRouter{server: r.server, routes: r.routes.group(prefix)}
+}
+
+func (r *stdlibRouter) Handle(method, path string, handlers ...HandlerFunc) {
+	route := r.routes.prefix + path
+	chain := r.routes.chain(handlers)
+	names := routeParams(route)
+
+	handler := otelhttp.WithRouteTag(route, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		trace.SpanFromContext(req.Context()).SetName(route)
+		params := make([]Param, len(names))
+		for i, name := range names {
+			params[i] = Param{Key: name, Value: req.PathValue(name)}
+		}
+		newContext(w, req, route, params, chain).run()
+	}))
+	r.server.mux.Handle(method+" "+muxPattern(route), handler)
+}
+
+func (r *stdlibRouter) GET(path string, handlers ...HandlerFunc) {
+	r.Handle(http.MethodGet, path, handlers...)
+}
+
+func (r *stdlibRouter) POST(path string, handlers ...HandlerFunc) {
+	r.Handle(http.MethodPost, path, handlers...)
+}
+
+func (r *stdlibRouter) PUT(path string, handlers ...HandlerFunc) {
+	r.Handle(http.MethodPut, path, handlers...)
+}
+
+func (r *stdlibRouter) PATCH(path string, handlers ...HandlerFunc) {
+	r.Handle(http.MethodPatch, path, handlers...)
+}
+
+func (r *stdlibRouter) DELETE(path string, handlers ...HandlerFunc) {
+	r.Handle(http.MethodDelete, path, handlers...)
+}
+
+func (r *stdlibRouter) Handler() http.Handler {
+	return r.server.handler
+}
+
+// logRequests logs each request's status, latency, client and path once it completes,
+// with the request's context
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		path := r.URL.Path
+		if r.URL.RawQuery != "" {
+			path += "?" + r.URL.RawQuery
+		}
+		defer func() {
+			if recorder.status == 0 {
+				recorder.status = http.StatusOK
+			}
+			slog.InfoContext(r.Context(), "HTTP request",
+				"http.request.method", r.Method,
+				"url.path", path,
+				"http.response.status_code", recorder.status,
+				"duration", time.Since(start),
+				"client.address", clientIP(r),
+			)
+		}()
+		next.ServeHTTP(recorder, r)
+	})
+}
+
+// recoverPanics answers 500 to a request whose handler panicked instead of dropping the
+// connection; http.ErrAbortHandler keeps aborting the response
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+				slog.ErrorContext(r.Context(), "Panic serving request",
+					"http.request.method", r.Method,
+					"url.path", r.URL.Path,
+					"error", err,
+					"stack", string(debug.Stack()),
+				)
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// statusRecorder captures the response status for logging. WebSocket upgrades hijack
+// the connection through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusRecorder) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil && w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, rw, err
+}
+
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
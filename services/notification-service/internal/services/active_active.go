@@ -0,0 +1,282 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"maps"
+	"sort"
+	"strings"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// idRegion is the region NewID prefixes IDs with, set once at startup
+var idRegion string
+
+// SetIDRegion makes NewID mint IDs of region (SERVICE_REGION)
+func SetIDRegion(region string) {
+	idRegion = region
+}
+
+// NewID mints the ID of a new notification or other record. With SERVICE_REGION set it
+// is "<region>-<uuid>", so the records of two regions writing to the same geo-replicated
+// stores show where they were created.
+func NewID() string {
+	if idRegion == "" {
+		return uuid.New().String()
+	}
+	return idRegion + "-" + uuid.New().String()
+}
+
+// IDRegion returns the region an ID from NewID was minted in, empty for one minted
+// without SERVICE_REGION
+func IDRegion(id string) string {
+	const uuidLength = 36
+	if len(id) <= uuidLength+1 || id[len(id)-uuidLength-1] != '-' {
+		return ""
+	}
+	if _, err := uuid.Parse(id[len(id)-uuidLength:]); err != nil {
+		return ""
+	}
+	return id[:len(id)-uuidLength-1]
+}
+
+// processedEventKey holds the claim of the region handling an order event
+func processedEventKey(identity string) string {
+	return "processed-event:" + identity
+}
+
+// eventClaimTTL is how long an event is held by a replica handling it without hearing
+// from it, so an event whose replica stopped mid-handler can be handled again
+const eventClaimTTL = time.Minute
+
+// An event's claim is "pending:<claim ID>" while it is handled and "done:<claim ID>" once
+// it has been; claim IDs are minted by NewID, so they carry the claiming region
+const (
+	eventClaimPending = "pending:"
+	eventClaimDone    = "done:"
+)
+
+// ErrEventInProgress means another replica is handling the event; a redelivery after it
+// finishes or stops is skipped or handled again
+var ErrEventInProgress = errors.New("event is being handled by another replica")
+
+// extendEventClaimScript moves a claim to a new value and expiry, if it is still held
+var extendEventClaimScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+return 1
+`)
+
+// EventDeduplicator keeps an order event from being handled twice when it reaches both
+// regions, as through a geo-replicated Event Hub or again after a geo-DR failover. The
+// first replica to claim the event in the shared Redis handles it. The claim is pending
+// for a minute, extended while the handler runs, and held for EVENT_DEDUP_WINDOW_MINUTES
+// once the event was handled; a copy arriving meanwhile is skipped, or refused while the
+// event is pending so it is redelivered. An event is known by the message ID its
+// publisher gave it, or else by a hash of its body.
+//
+// On active-active geo-replicated Redis, SETNX is only atomic within a region: two
+// regions claiming an event within the replication lag both win and both handle it.
+// Replication then keeps one of the claims, and the region whose claim was lost finds
+// out when it completes; that is logged and counted as a duplicate with
+// event.handled_by=concurrent. Handlers must tolerate this rare double delivery.
+type EventDeduplicator struct {
+	redis  *RedisClient
+	window time.Duration
+}
+
+func NewEventDeduplicator(cfg *config.Config, redis *RedisClient) *EventDeduplicator {
+	return &EventDeduplicator{redis: redis, window: time.Duration(cfg.EventDedupWindowMinutes) * time.Minute}
+}
+
+// Wrap returns handler with duplicate events skipped. A claim is given up when the
+// handler fails, so a redelivery is handled again. Events are handled when Redis can't
+// be reached, as they would be without deduplication.
+func (d *EventDeduplicator) Wrap(handler EventHandler) EventHandler {
+	if d.window <= 0 {
+		return handler
+	}
+	return func(ctx context.Context, body []byte) error {
+		key := processedEventKey(eventIdentity(ctx, body))
+		pending := eventClaimPending + NewID()
+		claimed, err := d.redis.client.SetNX(ctx, key, pending, eventClaimTTL).Result()
+		if err != nil {
+			slog.WarnContext(ctx, "Event deduplication unavailable, handling event", "error", err)
+			return handler(ctx, body)
+		}
+		if !claimed {
+			return d.skip(ctx, key)
+		}
+
+		stop := d.hold(ctx, key, pending)
+		err = handler(ctx, body)
+		stop()
+		if err != nil {
+			if releaseErr := d.release(context.WithoutCancel(ctx), key, pending); releaseErr != nil {
+				slog.WarnContext(ctx, "Failed to release event claim", "error", releaseErr)
+			}
+			return err
+		}
+
+		done := eventClaimDone + strings.TrimPrefix(pending, eventClaimPending)
+		held, err := extendEventClaimScript.Run(context.WithoutCancel(ctx), d.redis.client, []string{key},
+			pending, done, d.window.Milliseconds()).Int()
+		switch {
+		case err != nil:
+			slog.WarnContext(ctx, "Failed to mark event handled", "error", err)
+		case held == 0:
+			// Another region's claim replaced this one: both handled the event
+			trace.SpanFromContext(ctx).SetAttributes(attribute.String("event.handled_by", "concurrent"))
+			telemetry.RecordDuplicateEvent(ctx, "concurrent")
+			slog.WarnContext(ctx, "Event was also handled by another region")
+		}
+		return nil
+	}
+}
+
+// skip answers a copy of an event another replica claimed: nil once it was handled, or
+// ErrEventInProgress while it is being handled
+func (d *EventDeduplicator) skip(ctx context.Context, key string) error {
+	holder, err := d.redis.client.Get(ctx, key).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		slog.WarnContext(ctx, "Failed to read event claim", "error", err)
+	}
+	state, claim, _ := strings.Cut(holder, ":")
+	handledBy := IDRegion(claim)
+	if handledBy == "" {
+		handledBy = "unknown"
+	}
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(
+		attribute.Bool("event.duplicate", true),
+		attribute.String("event.handled_by", handledBy),
+	)
+	if state+":" == eventClaimPending {
+		slog.InfoContext(ctx, "Event is being handled elsewhere", "event.handled_by", handledBy)
+		return ErrEventInProgress
+	}
+	telemetry.RecordDuplicateEvent(ctx, handledBy)
+	slog.InfoContext(ctx, "Skipping event already handled", "event.handled_by", handledBy)
+	return nil
+}
+
+// hold extends a pending claim every third of its TTL until the returned function is
+// called
+func (d *EventDeduplicator) hold(ctx context.Context, key, pending string) func() {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	go func() {
+		ticker := time.NewTicker(eventClaimTTL / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := extendEventClaimScript.Run(ctx, d.redis.client, []string{key}, pending, pending, eventClaimTTL.Milliseconds()).Err()
+				if err != nil && ctx.Err() == nil {
+					slog.WarnContext(ctx, "Failed to extend event claim", "error", err)
+				}
+			}
+		}
+	}()
+	return cancel
+}
+
+// release gives up a claim, unless it has expired and another replica holds it now
+func (d *EventDeduplicator) release(ctx context.Context, key, claim string) error {
+	return watchKey(ctx, d.redis.client, key, func(tx *redis.Tx) error {
+		holder, err := tx.Get(ctx, key).Result()
+		if errors.Is(err, redis.Nil) {
+			return nil
+		}
+		if err != nil || holder != claim {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			return nil
+		})
+		return err
+	})
+}
+
+// eventIdentity is the publisher's message ID, or the hash of the body without one
+func eventIdentity(ctx context.Context, body []byte) string {
+	if messageID := MessageIDFromContext(ctx); messageID != "" {
+		return "id:" + messageID
+	}
+	sum := sha256.Sum256(body)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// preferenceWritesKey holds the last preferences each region wrote for a customer, one
+// hash field per region. Geo-replication settles concurrent writes to the preferences
+// key itself by keeping one of them; the fields of a hash are kept from every region.
+func preferenceWritesKey(customerID string) string {
+	return "preferences-writes:" + customerID
+}
+
+// mergePreferences settles the preferences written by different regions. The last
+// writer wins, and of writes made at the same instant, the one from the region whose
+// name sorts first. Writes from different regions less than window apart were made
+// without either region seeing the other, so an opt-out in either one is kept: a channel
+// toggle or category turned off by the losing write stays off. It reports whether any
+// writes conflicted that way.
+func mergePreferences(writes []*models.CustomerPreferences, window time.Duration) (*models.CustomerPreferences, bool) {
+	if len(writes) == 0 {
+		return nil, false
+	}
+	sorted := append([]*models.CustomerPreferences(nil), writes...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if !a.UpdatedAt.Equal(b.UpdatedAt) {
+			return a.UpdatedAt.Before(b.UpdatedAt)
+		}
+		return a.UpdatedRegion > b.UpdatedRegion
+	})
+
+	merged := *sorted[0]
+	conflicted := false
+	for _, winner := range sorted[1:] {
+		loser := merged
+		merged = *winner
+		merged.Categories = maps.Clone(winner.Categories)
+		if !loser.CreatedAt.IsZero() && (merged.CreatedAt.IsZero() || loser.CreatedAt.Before(merged.CreatedAt)) {
+			merged.CreatedAt = loser.CreatedAt
+		}
+
+		concurrent := loser.UpdatedRegion != "" && winner.UpdatedRegion != "" &&
+			loser.UpdatedRegion != winner.UpdatedRegion && winner.UpdatedAt.Sub(loser.UpdatedAt) < window
+		if !concurrent {
+			continue
+		}
+		conflicted = true
+		merged.EmailEnabled = merged.EmailEnabled && loser.EmailEnabled
+		merged.SMSEnabled = merged.SMSEnabled && loser.SMSEnabled
+		merged.PushEnabled = merged.PushEnabled && loser.PushEnabled
+		merged.WebhookEnabled = merged.WebhookEnabled && loser.WebhookEnabled
+		for category, enabled := range loser.Categories {
+			if !enabled {
+				if merged.Categories == nil {
+					merged.Categories = make(map[string]bool)
+				}
+				merged.Categories[category] = false
+			}
+		}
+	}
+	return &merged, conflicted
+}
@@ -0,0 +1,284 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"notification-service/internal/models"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	ErrAnnouncementNotFound = errors.New("announcement not found")
+	ErrInvalidAnnouncement  = errors.New("invalid announcement")
+)
+
+// announcementsKey holds every announcement by ID
+const announcementsKey = "announcements"
+
+// announcementRetention is how long an announcement is kept once it has ended
+const announcementRetention = 7 * 24 * time.Hour
+
+// announcementSyncInterval is how often each replica checks for announcements that have
+// started or ended, so its clients are told within that time
+const announcementSyncInterval = 5 * time.Second
+
+// WebSocket message types of announcements starting and ending
+const (
+	announcementMessage      = "announcement"
+	announcementEndedMessage = "announcement_ended"
+)
+
+// severityRank orders announcements for display, most severe first
+var severityRank = map[models.AnnouncementSeverity]int{
+	models.AnnouncementSeverityCritical: 0,
+	models.AnnouncementSeverityWarning:  1,
+	models.AnnouncementSeverityInfo:     2,
+}
+
+// AnnouncementService keeps system announcements in Redis and broadcasts them to this
+// replica's WebSocket clients as they start and end. Clients that connect later read
+// the active ones with List.
+type AnnouncementService struct {
+	redis *RedisClient
+	hub   RealtimeHub
+
+	// announced holds the announcements this replica's clients were sent as active
+	mutex     sync.Mutex
+	announced map[string]bool
+}
+
+func NewAnnouncementService(redis *RedisClient, hub RealtimeHub) *AnnouncementService {
+	return &AnnouncementService{
+		redis:     redis,
+		hub:       hub,
+		announced: make(map[string]bool),
+	}
+}
+
+// Start broadcasts announcements as they start and end until ctx ends
+func (s *AnnouncementService) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(announcementSyncInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.sync(ctx); err != nil {
+					slog.WarnContext(ctx, "Failed to sync announcements", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// Create schedules an announcement, broadcasting it at once when it has already started
+func (s *AnnouncementService) Create(ctx context.Context, req models.CreateAnnouncementRequest, createdBy string) (*models.Announcement, error) {
+	now := time.Now().UTC()
+	announcement := &models.Announcement{
+		ID:        NewID(),
+		Title:     req.Title,
+		Message:   req.Message,
+		Severity:  req.Severity,
+		StartsAt:  now,
+		EndsAt:    req.EndsAt,
+		CreatedBy: createdBy,
+		CreatedAt: now,
+	}
+	if req.StartsAt != nil && req.StartsAt.After(now) {
+		announcement.StartsAt = req.StartsAt.UTC()
+	}
+	if announcement.EndsAt != nil {
+		if !announcement.EndsAt.After(announcement.StartsAt) || !announcement.EndsAt.After(now) {
+			return nil, fmt.Errorf("%w: ends_at must be after starts_at and in the future", ErrInvalidAnnouncement)
+		}
+		endsAt := announcement.EndsAt.UTC()
+		announcement.EndsAt = &endsAt
+	}
+
+	if err := s.save(ctx, announcement); err != nil {
+		return nil, err
+	}
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("announcement.id", announcement.ID),
+		attribute.String("announcement.severity", string(announcement.Severity)),
+	)
+	slog.InfoContext(ctx, "📢 Announcement scheduled", "announcement.id", announcement.ID, "announcement.severity", announcement.Severity, "announcement.starts_at", announcement.StartsAt)
+
+	if err := s.sync(ctx); err != nil {
+		slog.WarnContext(ctx, "Announcement not broadcast yet", "announcement.id", announcement.ID, "error", err)
+	}
+	return announcement, nil
+}
+
+// List returns the active announcements, most severe first, and the scheduled ones
+// after them when includeScheduled is set
+func (s *AnnouncementService) List(ctx context.Context, includeScheduled bool) ([]*models.Announcement, error) {
+	all, err := s.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var active, scheduled []*models.Announcement
+	for _, announcement := range all {
+		switch {
+		case announcement.Active(now):
+			active = append(active, announcement)
+		case includeScheduled && announcement.EndedAt == nil && now.Before(announcement.StartsAt):
+			scheduled = append(scheduled, announcement)
+		}
+	}
+	sort.Slice(active, func(i, j int) bool {
+		if severityRank[active[i].Severity] != severityRank[active[j].Severity] {
+			return severityRank[active[i].Severity] < severityRank[active[j].Severity]
+		}
+		return active[i].StartsAt.After(active[j].StartsAt)
+	})
+	sort.Slice(scheduled, func(i, j int) bool { return scheduled[i].StartsAt.Before(scheduled[j].StartsAt) })
+	return append(append([]*models.Announcement{}, active...), scheduled...), nil
+}
+
+// End takes an announcement down now, or cancels it before it starts
+func (s *AnnouncementService) End(ctx context.Context, id string) (*models.Announcement, error) {
+	var ended *models.Announcement
+	err := s.redis.client.Watch(ctx, func(tx *redis.Tx) error {
+		payload, err := tx.HGet(ctx, announcementsKey, id).Bytes()
+		if errors.Is(err, redis.Nil) {
+			return ErrAnnouncementNotFound
+		}
+		if err != nil {
+			return fmt.Errorf("failed to load announcement: %w", err)
+		}
+		var announcement models.Announcement
+		if err := json.Unmarshal(payload, &announcement); err != nil {
+			return fmt.Errorf("failed to decode announcement: %w", err)
+		}
+		if announcement.EndedAt == nil {
+			now := time.Now().UTC()
+			announcement.EndedAt = &now
+		}
+		if payload, err = json.Marshal(announcement); err != nil {
+			return fmt.Errorf("failed to marshal announcement: %w", err)
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, announcementsKey, id, payload)
+			return nil
+		})
+		ended = &announcement
+		return err
+	}, announcementsKey)
+
+	if errors.Is(err, redis.TxFailedErr) {
+		return nil, fmt.Errorf("announcement changed concurrently, retry")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	slog.InfoContext(ctx, "📢 Announcement ended", "announcement.id", id)
+	if err := s.sync(ctx); err != nil {
+		slog.WarnContext(ctx, "End of announcement not broadcast yet", "announcement.id", id, "error", err)
+	}
+	return ended, nil
+}
+
+// sync broadcasts announcements that started or ended since the last sync to this
+// replica's clients, and drops those that ended more than announcementRetention ago
+func (s *AnnouncementService) sync(ctx context.Context) error {
+	all, err := s.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	current := make(map[string]bool, len(all))
+	var expired []string
+	for _, announcement := range all {
+		current[announcement.ID] = true
+		if end := announcementEnd(announcement); end != nil && now.Sub(*end) > announcementRetention {
+			expired = append(expired, announcement.ID)
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, announcement := range all {
+		active := announcement.Active(now)
+		switch {
+		case active && !s.announced[announcement.ID]:
+			if err := s.hub.BroadcastMessage(ctx, announcementMessage, announcement); err != nil {
+				return fmt.Errorf("failed to broadcast announcement %s: %w", announcement.ID, err)
+			}
+			s.announced[announcement.ID] = true
+		case !active && s.announced[announcement.ID]:
+			if err := s.hub.BroadcastMessage(ctx, announcementEndedMessage, map[string]string{"id": announcement.ID}); err != nil {
+				return fmt.Errorf("failed to broadcast end of announcement %s: %w", announcement.ID, err)
+			}
+			delete(s.announced, announcement.ID)
+		}
+	}
+	// Deleted from Redis while shown, e.g. by another replica's cleanup
+	for id := range s.announced {
+		if !current[id] {
+			delete(s.announced, id)
+		}
+	}
+
+	if len(expired) > 0 {
+		if err := s.redis.client.HDel(ctx, announcementsKey, expired...).Err(); err != nil {
+			slog.WarnContext(ctx, "Failed to drop ended announcements", "announcements", len(expired), "error", err)
+		}
+	}
+	return nil
+}
+
+// announcementEnd returns when an announcement ended, or nil while it hasn't
+func announcementEnd(announcement *models.Announcement) *time.Time {
+	if announcement.EndedAt != nil {
+		return announcement.EndedAt
+	}
+	if announcement.EndsAt != nil && time.Now().After(*announcement.EndsAt) {
+		return announcement.EndsAt
+	}
+	return nil
+}
+
+func (s *AnnouncementService) save(ctx context.Context, announcement *models.Announcement) error {
+	payload, err := json.Marshal(announcement)
+	if err != nil {
+		return fmt.Errorf("failed to marshal announcement: %w", err)
+	}
+	if err := s.redis.client.HSet(ctx, announcementsKey, announcement.ID, payload).Err(); err != nil {
+		return fmt.Errorf("failed to save announcement: %w", err)
+	}
+	return nil
+}
+
+func (s *AnnouncementService) load(ctx context.Context) ([]*models.Announcement, error) {
+	entries, err := s.redis.client.HGetAll(ctx, announcementsKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load announcements: %w", err)
+	}
+	announcements := make([]*models.Announcement, 0, len(entries))
+	for id, payload := range entries {
+		var announcement models.Announcement
+		if err := json.Unmarshal([]byte(payload), &announcement); err != nil {
+			slog.WarnContext(ctx, "Skipping undecodable announcement", "announcement.id", id, "error", err)
+			continue
+		}
+		announcements = append(announcements, &announcement)
+	}
+	return announcements, nil
+}
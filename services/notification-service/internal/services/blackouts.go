@@ -0,0 +1,401 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+
+	"notification-service/internal/cache"
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	ErrBlackoutCalendarNotFound = errors.New("blackout calendar not found")
+	ErrInvalidBlackoutCalendar  = errors.New("invalid blackout calendar")
+)
+
+// TenantMetadata is the notification metadata key holding its tenant, whose blackout
+// calendar it is held by. Notifications without one use the default tenant's calendar.
+const TenantMetadata = "tenant_id"
+
+// Notification metadata written when a blackout window holds a notification
+const (
+	BlackoutWindowMetadata = "blackout_window"
+	BlackoutKindMetadata   = "blackout_kind"
+	BlackoutUntilMetadata  = "blackout_until"
+)
+
+// blackoutTenantsKey holds the tenants with a blackout calendar
+const blackoutTenantsKey = "blackout-tenants"
+
+// blackoutDeferralsTotal is the field of a tenant's deferral counts holding their total
+const blackoutDeferralsTotal = "total"
+
+// blackoutMaxChain bounds how many back-to-back windows are followed to find a release
+const blackoutMaxChain = 100
+
+// blackoutCalendarKey holds a tenant's blackout windows
+func blackoutCalendarKey(tenantID string) string {
+	return "blackout-calendar:" + tenantID
+}
+
+// blackoutDeferralsKey counts the notifications a tenant's windows held, by window ID
+func blackoutDeferralsKey(tenantID string) string {
+	return "blackout-deferrals:" + tenantID
+}
+
+// BlackoutCalendars keeps each tenant's blackout calendar in Redis: public holidays and
+// change freezes during which the tenant's notifications below urgent priority are held
+// until the window ends. Windows that follow one another are held through together.
+// Calendars are read through an in-process cache, so an update reaches other replicas
+// within BLACKOUT_CACHE_TTL_SECONDS. Notifications are sent as usual when calendars
+// can't be read.
+type BlackoutCalendars struct {
+	redis     *RedisClient
+	calendars *cache.Cache[*models.BlackoutCalendar]
+}
+
+func NewBlackoutCalendars(cfg *config.Config, redis *RedisClient) *BlackoutCalendars {
+	return &BlackoutCalendars{
+		redis: redis,
+		calendars: cache.New[*models.BlackoutCalendar](nil, cache.Options{
+			Name:       "blackout-calendars",
+			Mode:       cache.ReadThrough,
+			L1TTL:      time.Duration(max(cfg.BlackoutCacheTTLSeconds, 1)) * time.Second,
+			L1MaxItems: 1000,
+		}),
+	}
+}
+
+// Hold defers a notification due at the given time past the blackout windows of its
+// tenant, setting ScheduledAt to when they end, and returns that time. Urgent
+// notifications and those due outside any window are left alone and nil is returned.
+// The deferral is counted by RecordDeferral, once the caller knows the notification is
+// held rather than suppressed.
+func (b *BlackoutCalendars) Hold(ctx context.Context, notification *models.Notification, due time.Time) *time.Time {
+	if notification.Priority == models.PriorityUrgent {
+		return nil
+	}
+	tenantID := notificationTenant(notification)
+	calendar, err := b.lookup(ctx, tenantID)
+	if err != nil {
+		slog.WarnContext(ctx, "Blackout calendar unavailable, sending notification", "tenant.id", tenantID, "notification.id", notification.ID, "error", err)
+		return nil
+	}
+	if calendar == nil {
+		return nil
+	}
+	window, until, held := blackoutRelease(calendar.Windows, due)
+	if !held {
+		return nil
+	}
+
+	notification.ScheduledAt = &until
+	if notification.Metadata == nil {
+		notification.Metadata = map[string]interface{}{}
+	}
+	notification.Metadata[BlackoutWindowMetadata] = window.ID
+	notification.Metadata[BlackoutKindMetadata] = string(window.Kind)
+	notification.Metadata[BlackoutUntilMetadata] = until.Format(time.RFC3339)
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("tenant.id", tenantID),
+		attribute.String("blackout.window", window.ID),
+		attribute.String("blackout.kind", string(window.Kind)),
+		attribute.String("blackout.until", until.Format(time.RFC3339)),
+	)
+	return &until
+}
+
+// RecordDeferral counts a notification Hold deferred against its tenant's calendar and
+// the window that held it
+func (b *BlackoutCalendars) RecordDeferral(ctx context.Context, notification *models.Notification) {
+	windowID, _ := notification.Metadata[BlackoutWindowMetadata].(string)
+	if windowID == "" {
+		return
+	}
+	kind, _ := notification.Metadata[BlackoutKindMetadata].(string)
+	tenantID := notificationTenant(notification)
+	_, err := b.redis.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HIncrBy(ctx, blackoutDeferralsKey(tenantID), blackoutDeferralsTotal, 1)
+		pipe.HIncrBy(ctx, blackoutDeferralsKey(tenantID), windowID, 1)
+		return nil
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to count blackout deferral", "tenant.id", tenantID, "blackout.window", windowID, "error", err)
+	}
+	telemetry.RecordBlackoutDeferral(ctx, string(notification.Type), kind)
+}
+
+// Calendars returns every tenant's calendar and what it holds, by tenant ID
+func (b *BlackoutCalendars) Calendars(ctx context.Context) ([]*models.BlackoutStatus, error) {
+	tenantIDs, err := b.redis.client.SMembers(ctx, blackoutTenantsKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blackout calendars: %w", err)
+	}
+	sort.Strings(tenantIDs)
+	statuses := make([]*models.BlackoutStatus, 0, len(tenantIDs))
+	for _, tenantID := range tenantIDs {
+		status, err := b.Calendar(ctx, tenantID)
+		if errors.Is(err, ErrBlackoutCalendarNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Calendar returns a tenant's calendar with the notifications its windows held and, when
+// one is in force, when it lifts
+func (b *BlackoutCalendars) Calendar(ctx context.Context, tenantID string) (*models.BlackoutStatus, error) {
+	calendar, err := loadBlackoutCalendar(ctx, b.redis.client, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	if calendar == nil {
+		return nil, fmt.Errorf("%w: %s", ErrBlackoutCalendarNotFound, tenantID)
+	}
+	counts, err := b.redis.client.HGetAll(ctx, blackoutDeferralsKey(tenantID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blackout deferrals: %w", err)
+	}
+
+	status := &models.BlackoutStatus{BlackoutCalendar: *calendar}
+	for field, value := range counts {
+		count, _ := strconv.ParseInt(value, 10, 64)
+		if field == blackoutDeferralsTotal {
+			status.Deferred = count
+			continue
+		}
+		if status.DeferredByWindow == nil {
+			status.DeferredByWindow = make(map[string]int64)
+		}
+		status.DeferredByWindow[field] = count
+	}
+	if window, until, held := blackoutRelease(calendar.Windows, time.Now().UTC()); held {
+		status.Active = window
+		status.NextRelease = &until
+	}
+	return status, nil
+}
+
+// SetCalendar replaces a tenant's blackout windows, giving an ID to those without one.
+// Notifications already held keep the release they were given.
+func (b *BlackoutCalendars) SetCalendar(ctx context.Context, tenantID string, windows []models.BlackoutWindow) (*models.BlackoutCalendar, error) {
+	if tenantID == "" {
+		return nil, fmt.Errorf("%w: tenant ID is required", ErrInvalidBlackoutCalendar)
+	}
+	ids := make(map[string]bool, len(windows))
+	for i := range windows {
+		if windows[i].ID == "" {
+			windows[i].ID = NewID()
+		}
+		if ids[windows[i].ID] {
+			return nil, fmt.Errorf("%w: window ID %q appears twice", ErrInvalidBlackoutCalendar, windows[i].ID)
+		}
+		ids[windows[i].ID] = true
+		if err := validateBlackoutWindow(windows[i]); err != nil {
+			return nil, fmt.Errorf("%w: window %q: %v", ErrInvalidBlackoutCalendar, windows[i].ID, err)
+		}
+	}
+
+	calendar := &models.BlackoutCalendar{TenantID: tenantID, Windows: windows, UpdatedAt: time.Now().UTC()}
+	payload, err := json.Marshal(calendar)
+	if err != nil {
+		return nil, err
+	}
+	_, err = b.redis.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, blackoutCalendarKey(tenantID), payload, 0)
+		pipe.SAdd(ctx, blackoutTenantsKey, tenantID)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store blackout calendar: %w", err)
+	}
+	b.calendars.Delete(ctx, tenantID)
+	slog.InfoContext(ctx, "📅 Blackout calendar updated", "tenant.id", tenantID, "blackout.windows", len(windows))
+	return calendar, nil
+}
+
+// DeleteCalendar removes a tenant's blackout calendar and its deferral counts
+func (b *BlackoutCalendars) DeleteCalendar(ctx context.Context, tenantID string) error {
+	var deleted *redis.IntCmd
+	_, err := b.redis.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		deleted = pipe.Del(ctx, blackoutCalendarKey(tenantID))
+		pipe.Del(ctx, blackoutDeferralsKey(tenantID))
+		pipe.SRem(ctx, blackoutTenantsKey, tenantID)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete blackout calendar: %w", err)
+	}
+	b.calendars.Delete(ctx, tenantID)
+	if deleted.Val() == 0 {
+		return fmt.Errorf("%w: %s", ErrBlackoutCalendarNotFound, tenantID)
+	}
+	return nil
+}
+
+// lookup returns a tenant's calendar through the cache, or nil when they have none
+func (b *BlackoutCalendars) lookup(ctx context.Context, tenantID string) (*models.BlackoutCalendar, error) {
+	return b.calendars.Get(ctx, tenantID, func(ctx context.Context) (*models.BlackoutCalendar, error) {
+		return loadBlackoutCalendar(ctx, b.redis.client, tenantID)
+	})
+}
+
+func loadBlackoutCalendar(ctx context.Context, client redis.Cmdable, tenantID string) (*models.BlackoutCalendar, error) {
+	payload, err := client.Get(ctx, blackoutCalendarKey(tenantID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var calendar models.BlackoutCalendar
+	if err := json.Unmarshal(payload, &calendar); err != nil {
+		return nil, fmt.Errorf("failed to decode blackout calendar for %s: %w", tenantID, err)
+	}
+	return &calendar, nil
+}
+
+// notificationTenant is the tenant in a notification's metadata, or the default tenant
+func notificationTenant(notification *models.Notification) string {
+	if tenantID, _ := notification.Metadata[TenantMetadata].(string); tenantID != "" {
+		return tenantID
+	}
+	return defaultTenant
+}
+
+// blackoutRelease reports whether at falls in one of the windows and, following windows
+// that start before the previous one ends, when the blackout lifts. The window returned
+// is the one at falls in.
+func blackoutRelease(windows []models.BlackoutWindow, at time.Time) (*models.BlackoutWindow, time.Time, bool) {
+	var first *models.BlackoutWindow
+	release := at
+	for range blackoutMaxChain {
+		var latest time.Time
+		var covering *models.BlackoutWindow
+		for i := range windows {
+			if _, end, ok := blackoutSpan(windows[i], release); ok && end.After(latest) {
+				latest, covering = end, &windows[i]
+			}
+		}
+		if covering == nil {
+			break
+		}
+		if first == nil {
+			first = covering
+		}
+		release = latest
+	}
+	if first == nil {
+		return nil, time.Time{}, false
+	}
+	return first, release.UTC(), true
+}
+
+// blackoutSpan returns the occurrence of a window that at falls in. Dated windows run
+// from midnight on Date to midnight after EndDate in their time zone, so they follow
+// daylight saving time; an annual one is tried in at's year and the year before, for
+// windows that run into the new year.
+func blackoutSpan(window models.BlackoutWindow, at time.Time) (time.Time, time.Time, bool) {
+	if window.Start != nil && window.End != nil {
+		return *window.Start, *window.End, !at.Before(*window.Start) && at.Before(*window.End)
+	}
+	first, last, location, err := parseBlackoutDates(window)
+	if err != nil {
+		return time.Time{}, time.Time{}, false
+	}
+	years := []int{0}
+	if window.Annual {
+		local := at.In(location)
+		years = []int{local.Year() - first.Year() - 1, local.Year() - first.Year()}
+	}
+	for _, offset := range years {
+		start := time.Date(first.Year()+offset, first.Month(), first.Day(), 0, 0, 0, 0, location)
+		end := time.Date(last.Year()+offset, last.Month(), last.Day()+1, 0, 0, 0, 0, location)
+		if !at.Before(start) && at.Before(end) {
+			return start, end, true
+		}
+	}
+	return time.Time{}, time.Time{}, false
+}
+
+// parseBlackoutDates reads a dated window's first and last days and its time zone, UTC
+// when unset
+func parseBlackoutDates(window models.BlackoutWindow) (time.Time, time.Time, *time.Location, error) {
+	first, err := time.Parse(time.DateOnly, window.Date)
+	if err != nil {
+		return time.Time{}, time.Time{}, nil, fmt.Errorf("date %q must be YYYY-MM-DD", window.Date)
+	}
+	last := first
+	if window.EndDate != "" {
+		if last, err = time.Parse(time.DateOnly, window.EndDate); err != nil {
+			return time.Time{}, time.Time{}, nil, fmt.Errorf("end_date %q must be YYYY-MM-DD", window.EndDate)
+		}
+	}
+	location := time.UTC
+	if window.Timezone != "" {
+		if location, err = time.LoadLocation(window.Timezone); err != nil {
+			return time.Time{}, time.Time{}, nil, fmt.Errorf("timezone %q is not a known time zone", window.Timezone)
+		}
+	}
+	return first, last, location, nil
+}
+
+// validateBlackoutWindow checks a window has a name and kind and is either a period with
+// start and end or a run of dates
+func validateBlackoutWindow(window models.BlackoutWindow) error {
+	if window.Name == "" {
+		return errors.New("name is required")
+	}
+	switch window.Kind {
+	case models.BlackoutKindHoliday, models.BlackoutKindChangeFreeze:
+	default:
+		return fmt.Errorf("kind %q must be %s or %s", window.Kind, models.BlackoutKindHoliday, models.BlackoutKindChangeFreeze)
+	}
+
+	timed := window.Start != nil || window.End != nil
+	dated := window.Date != "" || window.EndDate != ""
+	switch {
+	case timed && dated:
+		return errors.New("set start and end, or date, not both")
+	case timed:
+		if window.Start == nil || window.End == nil {
+			return errors.New("start and end must be set together")
+		}
+		if !window.End.After(*window.Start) {
+			return errors.New("end must be after start")
+		}
+		if window.Annual || window.Timezone != "" {
+			return errors.New("annual and timezone apply to dated windows only")
+		}
+	case dated:
+		first, last, _, err := parseBlackoutDates(window)
+		if err != nil {
+			return err
+		}
+		if last.Before(first) {
+			return errors.New("end_date must not be before date")
+		}
+		if window.Annual && last.Sub(first) >= 365*24*time.Hour {
+			return errors.New("an annual window must be shorter than a year")
+		}
+	default:
+		return errors.New("start and end, or date, is required")
+	}
+	return nil
+}
@@ -0,0 +1,798 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	ErrBroadcastNotFound   = errors.New("broadcast not found")
+	ErrBroadcastNotPending = errors.New("broadcast is not awaiting approval")
+	ErrBroadcastExpired    = errors.New("broadcast approval has expired")
+	ErrSelfApproval        = errors.New("a broadcast cannot be approved by the user who requested it")
+	ErrInvalidBroadcast    = errors.New("invalid broadcast")
+)
+
+// broadcastRetention is how long jobs and their audit trail are kept after creation
+const broadcastRetention = 30 * 24 * time.Hour
+
+const (
+	// broadcastQueue holds the IDs of the broadcasts waiting to be delivered
+	broadcastQueue = "broadcast-jobs"
+	// broadcastLease is how long a replica that stopped keeps a broadcast from the others
+	broadcastLease = time.Minute
+	// broadcastPoll is how often each replica looks for broadcasts to deliver
+	broadcastPoll = time.Second
+	// broadcastFanout is the pub/sub channel carrying broadcasts to every connected
+	// client to each replica's own clients
+	broadcastFanout = "broadcast-fanout"
+)
+
+// BroadcastService sends broadcasts, holding those above the recipient threshold until
+// a second user approves them. Every state change is appended to an audit trail.
+// Broadcasts are queued in Redis and delivered by whichever replica claims them, to each
+// targeted customer at the address in their preferences on each of their channels, as
+// their preferences allow, with the counts kept as the job's progress. Each delivery is
+// marked done as it is counted, so a broadcast whose replica stopped is resumed by
+// another one where it left off. Failed deliveries are dead-lettered. A scheduled
+// broadcast is queued for its scheduled_at; a local one runs again as each customer's
+// local time comes.
+type BroadcastService struct {
+	redis       *RedisClient
+	hub         RealtimeHub
+	senders     map[models.NotificationType]ChannelSender
+	preferences PreferenceEnforcer
+	duplicates  DuplicateGuard
+	deadLetters *DeadLetterQueue
+	presence    PresenceTracker
+	screening   ContentScreener
+	queue       *workQueue
+	threshold   int
+	ttl         time.Duration
+	workers     int
+}
+
+func NewBroadcastService(cfg *config.Config, redis *RedisClient, hub RealtimeHub, senders map[models.NotificationType]ChannelSender, preferences PreferenceEnforcer, duplicates DuplicateGuard, deadLetters *DeadLetterQueue, presence PresenceTracker, screening ContentScreener) *BroadcastService {
+	return &BroadcastService{
+		redis:       redis,
+		hub:         hub,
+		senders:     senders,
+		preferences: preferences,
+		duplicates:  duplicates,
+		deadLetters: deadLetters,
+		presence:    presence,
+		screening:   screening,
+		queue:       newWorkQueue(redis, broadcastQueue, broadcastLease),
+		threshold:   cfg.BroadcastApprovalThreshold,
+		ttl:         time.Duration(cfg.BroadcastApprovalTTLMinutes) * time.Minute,
+		workers:     max(cfg.BroadcastWorkers, 1),
+	}
+}
+
+// Start delivers queued broadcasts, and relays broadcasts to every connected client to
+// this replica's clients, until ctx is cancelled
+func (s *BroadcastService) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(broadcastPoll)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ids, err := s.queue.Claim(ctx, 1)
+				if err != nil {
+					slog.WarnContext(ctx, "Failed to claim broadcasts", "error", err)
+					continue
+				}
+				for _, id := range ids {
+					s.run(ctx, id)
+				}
+			}
+		}
+	}()
+
+	pubsub := s.redis.client.Subscribe(ctx, broadcastFanout)
+	go func() {
+		<-ctx.Done()
+		pubsub.Close()
+	}()
+	go func() {
+		for message := range pubsub.Channel() {
+			var payload models.WebSocketMessage
+			if err := json.Unmarshal([]byte(message.Payload), &payload); err != nil {
+				slog.WarnContext(ctx, "Dropping undecodable broadcast", "error", err)
+				continue
+			}
+			if err := s.hub.BroadcastToAll(ctx, payload); err != nil {
+				slog.WarnContext(ctx, "Failed to broadcast to local clients", "error", err)
+			}
+		}
+	}()
+}
+
+// run delivers a claimed broadcast, holding its lease meanwhile. A broadcast left
+// unfinished because ctx was cancelled stays claimed until its lease runs out, and is
+// then resumed.
+func (s *BroadcastService) run(ctx context.Context, id string) {
+	release := s.queue.Hold(ctx, id)
+	defer release()
+
+	job, err := s.load(ctx, s.redis.client, id)
+	switch {
+	case errors.Is(err, ErrBroadcastNotFound):
+	case err != nil:
+		slog.WarnContext(ctx, "Failed to load broadcast", "broadcast.id", id, "error", err)
+		if err := s.queue.Retry(ctx, id, time.Now().Add(broadcastPoll)); err != nil {
+			slog.WarnContext(ctx, "Failed to requeue broadcast", "broadcast.id", id, "error", err)
+		}
+		return
+	case job.Status == models.BroadcastStatusSending:
+		next, done := s.deliver(ctx, *job)
+		if !next.IsZero() {
+			if err := s.queue.Retry(ctx, id, next); err != nil {
+				slog.WarnContext(ctx, "Failed to requeue broadcast", "broadcast.id", id, "error", err)
+			}
+			return
+		}
+		if !done {
+			return
+		}
+	}
+	if err := s.queue.Done(ctx, id); err != nil {
+		slog.WarnContext(ctx, "Failed to finish broadcast job", "broadcast.id", id, "error", err)
+	}
+}
+
+func broadcastKey(id string) string {
+	return "broadcast:" + id
+}
+
+func broadcastAuditKey(id string) string {
+	return "broadcast-audit:" + id
+}
+
+// broadcastProgressKey holds the delivery counts of a broadcast being sent
+func broadcastProgressKey(id string) string {
+	return "broadcast-progress:" + id
+}
+
+// broadcastDeliveredKey holds the deliveries of a broadcast already counted, as
+// channel:customer, or "all" for a broadcast to every connected client
+func broadcastDeliveredKey(id string) string {
+	return "broadcast-delivered:" + id
+}
+
+// broadcastToAll is the delivery of a broadcast to every connected client
+const broadcastToAll = "all"
+
+// Results of one broadcast delivery, also the fields of its progress hash
+const (
+	broadcastSent       = "sent"
+	broadcastFailed     = "failed"
+	broadcastSuppressed = "suppressed"
+	broadcastDuplicate  = "duplicate_suppressed"
+)
+
+// Submit starts sending a broadcast, or parks it for approval when it targets more
+// recipients than the threshold. Its content is screened once, on each of its channels,
+// as every recipient gets the same; a broadcast blocked on any channel is refused with
+// ErrContentBlocked.
+func (s *BroadcastService) Submit(ctx context.Context, req models.BroadcastNotificationRequest, requestedBy string) (*models.BroadcastJob, error) {
+	req.Filters.CustomerIDs = uniqueCustomerIDs(req.Filters.CustomerIDs)
+	if req.ScheduledLocal && req.ScheduledAt == nil {
+		return nil, fmt.Errorf("%w: scheduled_local needs scheduled_at", ErrInvalidBroadcast)
+	}
+	if req.ScheduledLocal && len(req.Filters.CustomerIDs) == 0 {
+		return nil, fmt.Errorf("%w: scheduled_local needs filters.customer_ids", ErrInvalidBroadcast)
+	}
+	channels, err := s.channels(req)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	job := &models.BroadcastJob{
+		ID:             NewID(),
+		Request:        req,
+		RecipientCount: s.recipientCount(ctx, req),
+		Channels:       channels,
+		RequestedBy:    requestedBy,
+		CreatedAt:      now,
+	}
+	if err := s.screen(ctx, job); err != nil {
+		return nil, err
+	}
+
+	if job.RecipientCount <= s.threshold {
+		job.Status = models.BroadcastStatusSending
+		if err := s.start(ctx, job, requestedBy); err != nil {
+			return nil, err
+		}
+		return job, nil
+	}
+
+	expiresAt := now.Add(s.ttl)
+	job.Status = models.BroadcastStatusPendingApproval
+	job.ExpiresAt = &expiresAt
+	if err := s.save(ctx, s.redis.client, job); err != nil {
+		return nil, err
+	}
+	s.audit(ctx, job.ID, "requested", requestedBy, fmt.Sprintf("%d recipients exceeds approval threshold %d", job.RecipientCount, s.threshold))
+	return job, nil
+}
+
+// Get returns a broadcast job, with its progress so far while it is being sent, marking
+// it expired if its approval window has passed
+func (s *BroadcastService) Get(ctx context.Context, id string) (*models.BroadcastJob, error) {
+	job, err := s.load(ctx, s.redis.client, id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status == models.BroadcastStatusSending {
+		if job.Progress, err = s.progress(ctx, job); err != nil {
+			return nil, err
+		}
+	}
+	if s.expired(job) {
+		expired, err := s.transition(ctx, id, "", "", nil)
+		if errors.Is(err, ErrBroadcastExpired) {
+			return expired, nil
+		}
+		// Another request decided or expired the job first
+		return s.load(ctx, s.redis.client, id)
+	}
+	return job, nil
+}
+
+// Approve starts sending a pending broadcast on behalf of a second approver
+func (s *BroadcastService) Approve(ctx context.Context, id, approver, comment string) (*models.BroadcastJob, error) {
+	job, err := s.transition(ctx, id, approver, comment, func(job *models.BroadcastJob) error {
+		if job.RequestedBy == approver {
+			return ErrSelfApproval
+		}
+		job.Status = models.BroadcastStatusSending
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	telemetry.RecordBroadcastDecision(ctx, "approved")
+	s.audit(ctx, id, "approved", approver, comment)
+
+	// The job is claimed as sending before delivery so a concurrent approval cannot send it twice
+	if err := s.start(ctx, job, approver); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Reject discards a pending broadcast
+func (s *BroadcastService) Reject(ctx context.Context, id, approver, comment string) (*models.BroadcastJob, error) {
+	job, err := s.transition(ctx, id, approver, comment, func(job *models.BroadcastJob) error {
+		job.Status = models.BroadcastStatusRejected
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	telemetry.RecordBroadcastDecision(ctx, "rejected")
+	s.audit(ctx, id, "rejected", approver, comment)
+	return job, nil
+}
+
+// AuditTrail returns the audit records for a broadcast job, oldest first
+func (s *BroadcastService) AuditTrail(ctx context.Context, id string) ([]models.BroadcastAuditRecord, error) {
+	entries, err := s.redis.client.LRange(ctx, broadcastAuditKey(id), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load broadcast audit trail: %w", err)
+	}
+	if len(entries) == 0 {
+		return nil, ErrBroadcastNotFound
+	}
+
+	records := make([]models.BroadcastAuditRecord, 0, len(entries))
+	for _, entry := range entries {
+		var record models.BroadcastAuditRecord
+		if err := json.Unmarshal([]byte(entry), &record); err != nil {
+			return nil, fmt.Errorf("failed to decode broadcast audit record: %w", err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// transition applies a decision to a pending job atomically, so concurrent approvers
+// cannot both act on it. Jobs past their approval window are marked expired instead.
+func (s *BroadcastService) transition(ctx context.Context, id, actor, comment string, decide func(*models.BroadcastJob) error) (*models.BroadcastJob, error) {
+	var job *models.BroadcastJob
+	var expired bool
+
+	err := s.redis.client.Watch(ctx, func(tx *redis.Tx) error {
+		var err error
+		job, err = s.load(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if job.Status != models.BroadcastStatusPendingApproval {
+			return ErrBroadcastNotPending
+		}
+
+		now := time.Now().UTC()
+		if s.expired(job) {
+			expired = true
+			job.Status = models.BroadcastStatusExpired
+		} else {
+			if err := decide(job); err != nil {
+				return err
+			}
+			job.DecidedBy = actor
+			job.Comment = comment
+		}
+		job.DecidedAt = &now
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return s.save(ctx, pipe, job)
+		})
+		return err
+	}, broadcastKey(id))
+
+	if errors.Is(err, redis.TxFailedErr) {
+		// The job changed while this decision was being applied
+		return nil, ErrBroadcastNotPending
+	}
+	if expired && err == nil {
+		s.audit(ctx, id, "expired", "system", "")
+		return job, ErrBroadcastExpired
+	}
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (s *BroadcastService) expired(job *models.BroadcastJob) bool {
+	return job.Status == models.BroadcastStatusPendingApproval &&
+		job.ExpiresAt != nil && time.Now().After(*job.ExpiresAt)
+}
+
+// recipientCount is the number of targeted customers, or the clients connected to every
+// replica when the broadcast has no customer filter. The count falls back to this
+// replica's clients when the others' can't be read.
+func (s *BroadcastService) recipientCount(ctx context.Context, req models.BroadcastNotificationRequest) int {
+	if len(req.Filters.CustomerIDs) > 0 {
+		return len(req.Filters.CustomerIDs)
+	}
+	count, err := s.presence.ClusterConnections(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "Counting this replica's connections only", "error", err)
+		return s.hub.GetActiveConnections()
+	}
+	return count
+}
+
+// channels returns the channels a broadcast is delivered on. Only WebSocket can reach
+// every connected client; the other channels need customer IDs to address. Teams
+// notifications go to configured channels rather than customers, so can't be broadcast.
+func (s *BroadcastService) channels(req models.BroadcastNotificationRequest) ([]models.NotificationType, error) {
+	if len(req.Filters.Types) == 0 {
+		return []models.NotificationType{models.NotificationTypeWebSocket}, nil
+	}
+
+	channels := make([]models.NotificationType, 0, len(req.Filters.Types))
+	seen := make(map[models.NotificationType]bool, len(req.Filters.Types))
+	for _, channel := range req.Filters.Types {
+		if seen[channel] {
+			continue
+		}
+		seen[channel] = true
+		if channel != models.NotificationTypeWebSocket {
+			if s.senders[channel] == nil {
+				return nil, fmt.Errorf("%w: unknown channel %q", ErrInvalidBroadcast, channel)
+			}
+			if channel == models.NotificationTypeTeams {
+				return nil, fmt.Errorf("%w: teams notifications can't be broadcast to customers", ErrInvalidBroadcast)
+			}
+			if len(req.Filters.CustomerIDs) == 0 {
+				return nil, fmt.Errorf("%w: %s broadcasts need filters.customer_ids", ErrInvalidBroadcast, channel)
+			}
+		}
+		channels = append(channels, channel)
+	}
+	return channels, nil
+}
+
+// screen runs the broadcast's content past the screener on each of its channels, keeping
+// the first decision that isn't an allow on the job
+func (s *BroadcastService) screen(ctx context.Context, job *models.BroadcastJob) error {
+	for _, channel := range job.Channels {
+		notification := &models.Notification{
+			ID:       job.ID,
+			Type:     channel,
+			Subject:  job.Request.Subject,
+			Message:  job.Request.Message,
+			Metadata: map[string]interface{}{"broadcast.id": job.ID},
+		}
+		err := ScreenNotification(ctx, s.screening, notification)
+		if screening := notification.Screening; screening != nil && screening.Action != models.ScreeningActionAllow && job.Screening == nil {
+			job.Screening = screening
+		}
+		if err != nil {
+			slog.InfoContext(ctx, "Content screening blocked broadcast", "broadcast.id", job.ID, "notification.channel", channel, "error", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// start saves a broadcast as sending, with every delivery queued, and queues it for
+// delivery at its scheduled_at, or now. A local schedule is resolved per customer as
+// the broadcast runs, so it is queued to run now.
+func (s *BroadcastService) start(ctx context.Context, job *models.BroadcastJob, actor string) error {
+	total := job.RecipientCount * len(job.Channels)
+	if len(job.Request.Filters.CustomerIDs) == 0 {
+		total = job.RecipientCount
+	}
+	job.Progress = &models.BroadcastProgress{Total: total, Queued: total}
+
+	pipe := s.redis.client.TxPipeline()
+	pipe.HSet(ctx, broadcastProgressKey(job.ID), "total", total)
+	pipe.Expire(ctx, broadcastProgressKey(job.ID), broadcastRetention)
+	if err := s.save(ctx, pipe, job); err != nil {
+		return err
+	}
+	due := time.Now()
+	if job.Request.ScheduledAt != nil && !job.Request.ScheduledLocal {
+		due = *job.Request.ScheduledAt
+	}
+	s.queue.Add(ctx, pipe, job.ID, due)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to start broadcast: %w", err)
+	}
+	s.audit(ctx, job.ID, string(job.Status), actor, "")
+	return nil
+}
+
+// deliver sends a broadcast to every connected client, or to each targeted customer on
+// each channel on BROADCAST_WORKERS workers, skipping the deliveries already counted,
+// and saves the outcome. The broadcast fails when nothing was sent and at least one
+// delivery failed. It stops handing out deliveries when ctx is cancelled, letting those
+// in flight finish, and returns false when it did so before the broadcast was done. With
+// a local schedule, customers whose local time hasn't come yet are left queued, and it
+// returns when the first of them is due.
+func (s *BroadcastService) deliver(ctx context.Context, job models.BroadcastJob) (time.Time, bool) {
+	ctx, span := telemetry.Tracer.Start(ctx, "broadcast.deliver",
+		trace.WithAttributes(
+			attribute.String("broadcast.id", job.ID),
+			attribute.Int("broadcast.recipients", job.RecipientCount),
+			attribute.Int("broadcast.deliveries", job.Progress.Total),
+		),
+	)
+	defer span.End()
+
+	members, err := s.redis.client.SMembers(ctx, broadcastDeliveredKey(job.ID)).Result()
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to load broadcast deliveries", "broadcast.id", job.ID, "error", err)
+		return time.Time{}, false
+	}
+	delivered := make(map[string]bool, len(members))
+	for _, member := range members {
+		delivered[member] = true
+	}
+	sendCtx := context.WithoutCancel(ctx)
+	// When the first customer left for their local time is due
+	var next time.Time
+
+	if len(job.Request.Filters.CustomerIDs) == 0 {
+		if !delivered[broadcastToAll] {
+			result := broadcastSent
+			if err := s.broadcastToAll(sendCtx, s.message(job)); err != nil {
+				slog.ErrorContext(ctx, "❌ Broadcast failed", "broadcast.id", job.ID, "error", err)
+				result = broadcastFailed
+				job.Error = err.Error()
+			}
+			s.count(sendCtx, job.ID, broadcastToAll, result, job.Progress.Total)
+			telemetry.RecordBroadcastDelivery(ctx, string(models.NotificationTypeWebSocket), result)
+		}
+	} else {
+		customers := make(chan string)
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		for range min(s.workers, len(job.Request.Filters.CustomerIDs)) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for customerID := range customers {
+					if job.Request.ScheduledLocal {
+						if due := s.preferences.LocalTime(sendCtx, customerID, *job.Request.ScheduledAt); due.After(time.Now()) {
+							mu.Lock()
+							if next.IsZero() || due.Before(next) {
+								next = due
+							}
+							mu.Unlock()
+							continue
+						}
+					}
+					for _, channel := range job.Channels {
+						member := string(channel) + ":" + customerID
+						if delivered[member] {
+							continue
+						}
+						result := s.deliverTo(sendCtx, &job, customerID, channel)
+						s.count(sendCtx, job.ID, member, result, 1)
+						telemetry.RecordBroadcastDelivery(ctx, string(channel), result)
+					}
+				}
+			}()
+		}
+	feed:
+		for _, customerID := range job.Request.Filters.CustomerIDs {
+			select {
+			case customers <- customerID:
+			case <-ctx.Done():
+				break feed
+			}
+		}
+		close(customers)
+		wg.Wait()
+	}
+	if ctx.Err() != nil {
+		slog.InfoContext(sendCtx, "Broadcast interrupted, leaving it to resume", "broadcast.id", job.ID)
+		return time.Time{}, false
+	}
+	if !next.IsZero() {
+		span.SetAttributes(attribute.String("broadcast.next_local_at", next.Format(time.RFC3339)))
+		return next, false
+	}
+	ctx = sendCtx
+
+	progress, err := s.progress(ctx, &job)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to read broadcast progress", "broadcast.id", job.ID, "error", err)
+		progress = job.Progress
+	}
+	now := time.Now().UTC()
+	job.Progress = progress
+	job.CompletedAt = &now
+	job.Status = models.BroadcastStatusSent
+	if progress.Sent == 0 && progress.Failed > 0 {
+		job.Status = models.BroadcastStatusFailed
+		if job.Error == "" {
+			job.Error = fmt.Sprintf("delivery failed for all %d deliveries attempted", progress.Failed)
+		}
+		span.SetStatus(codes.Error, job.Error)
+	}
+	span.SetAttributes(
+		attribute.Int("broadcast.sent", progress.Sent),
+		attribute.Int("broadcast.failed", progress.Failed),
+		attribute.Int("broadcast.suppressed", progress.Suppressed),
+	)
+
+	if err := s.save(ctx, s.redis.client, &job); err != nil {
+		slog.ErrorContext(ctx, "Failed to save broadcast", "broadcast.id", job.ID, "error", err)
+	}
+	summary := fmt.Sprintf("%d sent, %d failed, %d suppressed, %d duplicates", progress.Sent, progress.Failed, progress.Suppressed, progress.DuplicateSuppressed)
+	s.audit(ctx, job.ID, string(job.Status), "system", summary)
+	slog.InfoContext(ctx, "📢 Broadcast "+string(job.Status), "broadcast.id", job.ID, "broadcast.sent", progress.Sent, "broadcast.failed", progress.Failed, "broadcast.suppressed", progress.Suppressed)
+	return time.Time{}, true
+}
+
+// broadcastToAll sends a message to the clients connected to every replica, each
+// replica relaying it to its own clients from the fanout channel
+func (s *BroadcastService) broadcastToAll(ctx context.Context, message models.WebSocketMessage) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal broadcast: %w", err)
+	}
+	if err := s.redis.client.Publish(ctx, broadcastFanout, payload).Err(); err != nil {
+		return fmt.Errorf("failed to publish broadcast: %w", err)
+	}
+	return nil
+}
+
+// deliverTo sends a broadcast to one customer on one channel, at the address in their
+// preferences, unless their preferences rule it out. A customer with no address on the
+// channel, or who opted out of any of the broadcast's categories, is skipped. Broadcasts
+// aren't held for quiet hours; below urgent priority they skip customers in them. With
+// dedupe, a customer already sent the same content on the channel within the window is
+// skipped too. A failed delivery is dead-lettered.
+func (s *BroadcastService) deliverTo(ctx context.Context, job *models.BroadcastJob, customerID string, channel models.NotificationType) string {
+	priority := job.Request.Priority
+	if priority == "" {
+		priority = models.PriorityNormal
+	}
+	notification := &models.Notification{
+		ID:         NewID(),
+		Type:       channel,
+		Recipient:  customerID,
+		Subject:    job.Request.Subject,
+		Message:    job.Request.Message,
+		Data:       job.Request.Data,
+		Status:     models.NotificationStatusPending,
+		Priority:   priority,
+		CustomerID: customerID,
+		CreatedAt:  time.Now().UTC(),
+		Metadata:   map[string]interface{}{"broadcast.id": job.ID},
+		Version:    1,
+	}
+	if err := s.preferences.Address(ctx, notification); errors.Is(err, ErrNoContact) {
+		telemetry.RecordNotificationSuppressed(ctx, string(channel), models.SuppressionNoContact)
+		return broadcastSuppressed
+	} else if err != nil {
+		slog.WarnContext(ctx, "Failed to address broadcast", "broadcast.id", job.ID, "customer.id", customerID, "notification.channel", channel, "error", err)
+		return broadcastFailed
+	}
+
+	categories := job.Request.Filters.Categories
+	if len(categories) == 0 {
+		categories = []string{""}
+	}
+	for _, category := range categories {
+		if category != "" {
+			notification.Metadata[CategoryMetadata] = category
+		}
+		if decision := s.preferences.Check(ctx, notification, time.Now().UTC()); decision.Action != models.PreferenceActionSend {
+			telemetry.RecordNotificationSuppressed(ctx, string(channel), decision.Reason)
+			return broadcastSuppressed
+		}
+	}
+
+	original, claimed, err := s.duplicates.Claim(ctx, notification, job.Request.Dedupe)
+	if err != nil {
+		slog.WarnContext(ctx, "Sending broadcast without dedupe", "broadcast.id", job.ID, "customer.id", customerID, "error", err)
+	}
+	if original != "" {
+		telemetry.RecordNotificationSuppressed(ctx, string(channel), models.SuppressionDuplicate)
+		return broadcastDuplicate
+	}
+
+	if channel == models.NotificationTypeWebSocket {
+		err = s.hub.SendToCustomer(ctx, customerID, s.message(*job))
+	} else {
+		err = s.senders[channel].Send(ctx, notification)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Broadcast delivery failed", "broadcast.id", job.ID, "customer.id", customerID, "notification.channel", channel, "error", err)
+		// A broadcast sent again should still reach the customer
+		if claimed {
+			if err := s.duplicates.Release(context.WithoutCancel(ctx), notification); err != nil {
+				slog.WarnContext(ctx, "Failed to release content hash", "broadcast.id", job.ID, "customer.id", customerID, "error", err)
+			}
+		}
+		notification.Status = models.NotificationStatusFailed
+		notification.ErrorMessage = err.Error()
+		if _, err := s.deadLetters.Capture(ctx, notification, models.DeadLetterDeliveryFailed, err, 1); err != nil {
+			slog.ErrorContext(ctx, "Failed to dead-letter broadcast delivery", "broadcast.id", job.ID, "customer.id", customerID, "error", err)
+		}
+		return broadcastFailed
+	}
+	return broadcastSent
+}
+
+func (s *BroadcastService) message(job models.BroadcastJob) models.WebSocketMessage {
+	return models.WebSocketMessage{
+		Type: "broadcast",
+		Data: map[string]interface{}{
+			"broadcastId": job.ID,
+			"type":        job.Request.Type,
+			"subject":     job.Request.Subject,
+			"message":     job.Request.Message,
+			"data":        job.Request.Data,
+			"priority":    job.Request.Priority,
+		},
+		Timestamp: time.Now().UTC(),
+	}
+}
+
+// count adds deliveries with a result to a broadcast's progress, marking them done in
+// the same transaction
+func (s *BroadcastService) count(ctx context.Context, jobID, member, result string, deliveries int) {
+	pipe := s.redis.client.TxPipeline()
+	pipe.HIncrBy(ctx, broadcastProgressKey(jobID), result, int64(deliveries))
+	pipe.SAdd(ctx, broadcastDeliveredKey(jobID), member)
+	pipe.Expire(ctx, broadcastDeliveredKey(jobID), broadcastRetention)
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.WarnContext(ctx, "Failed to count broadcast delivery", "broadcast.id", jobID, "broadcast.result", result, "error", err)
+	}
+}
+
+// progress reads a broadcast's delivery counts
+func (s *BroadcastService) progress(ctx context.Context, job *models.BroadcastJob) (*models.BroadcastProgress, error) {
+	fields, err := s.redis.client.HGetAll(ctx, broadcastProgressKey(job.ID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load broadcast progress: %w", err)
+	}
+	if len(fields) == 0 {
+		return job.Progress, nil
+	}
+	field := func(name string) int {
+		value, _ := strconv.Atoi(fields[name])
+		return value
+	}
+	progress := &models.BroadcastProgress{
+		Total:      field("total"),
+		Sent:       field(broadcastSent),
+		Failed:     field(broadcastFailed),
+		Suppressed: field(broadcastSuppressed),
+
+		DuplicateSuppressed: field(broadcastDuplicate),
+	}
+	progress.Queued = max(progress.Total-progress.Sent-progress.Failed-progress.Suppressed-progress.DuplicateSuppressed, 0)
+	return progress, nil
+}
+
+// uniqueCustomerIDs drops blank and repeated customer IDs, keeping the order
+func uniqueCustomerIDs(customerIDs []string) []string {
+	seen := make(map[string]bool, len(customerIDs))
+	unique := customerIDs[:0:0]
+	for _, customerID := range customerIDs {
+		if customerID == "" || seen[customerID] {
+			continue
+		}
+		seen[customerID] = true
+		unique = append(unique, customerID)
+	}
+	return unique
+}
+
+func (s *BroadcastService) audit(ctx context.Context, jobID, action, actor, comment string) {
+	payload, err := json.Marshal(models.BroadcastAuditRecord{
+		JobID:     jobID,
+		Action:    action,
+		Actor:     actor,
+		Comment:   comment,
+		Timestamp: time.Now().UTC(),
+	})
+	if err != nil {
+		return
+	}
+
+	pipe := s.redis.client.TxPipeline()
+	pipe.RPush(ctx, broadcastAuditKey(jobID), payload)
+	pipe.Expire(ctx, broadcastAuditKey(jobID), broadcastRetention)
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.ErrorContext(ctx, "Failed to record broadcast audit entry", "broadcast.id", jobID, "error", err)
+	}
+}
+
+func (s *BroadcastService) save(ctx context.Context, client redis.Cmdable, job *models.BroadcastJob) error {
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal broadcast: %w", err)
+	}
+	if err := client.Set(ctx, broadcastKey(job.ID), payload, broadcastRetention).Err(); err != nil {
+		return fmt.Errorf("failed to save broadcast: %w", err)
+	}
+	return nil
+}
+
+func (s *BroadcastService) load(ctx context.Context, client redis.Cmdable, id string) (*models.BroadcastJob, error) {
+	payload, err := client.Get(ctx, broadcastKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrBroadcastNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load broadcast: %w", err)
+	}
+
+	var job models.BroadcastJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return nil, fmt.Errorf("failed to decode broadcast: %w", err)
+	}
+	return &job, nil
+}
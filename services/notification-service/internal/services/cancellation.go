@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// notificationStatsKey holds service-wide notification counters for analytics
+const notificationStatsKey = "notification-stats"
+
+// cancellable reports whether a notification has not been handed to a provider yet
+func cancellable(status models.NotificationStatus) bool {
+	return canTransition(status, models.NotificationStatusCancelled)
+}
+
+// CancelNotification cancels a notification that has not been sent yet. The status check
+// and update are atomic, so either the cancellation or the delivery wins; the result
+// reports which. A concurrent change that isn't a delivery, such as an edit, makes the
+// check run again rather than lose the race. A cancelled notification is taken out of
+// the scheduled and retry queues; anything delivering stored notifications must still
+// skip the cancelled state, as a worker may have claimed it already.
+func (s *NotificationService) CancelNotification(ctx context.Context, id string) (*models.CancelResult, error) {
+	if _, err := s.GetNotification(ctx, id); err != nil {
+		return nil, err
+	}
+	records, err := s.residency.Records(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *models.CancelResult
+	var cancelled *models.Notification
+	var previous models.NotificationStatus
+
+	err = watchKey(ctx, records, notificationKey(id), func(tx *redis.Tx) error {
+		notification, err := loadNotification(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		result = &models.CancelResult{NotificationID: id, Status: notification.Status}
+		previous = notification.Status
+		if !cancellable(notification.Status) {
+			return nil
+		}
+
+		stampStatus(notification, models.NotificationStatusCancelled, time.Now().UTC())
+		notification.Version++
+		payload, err := json.Marshal(notification)
+		if err != nil {
+			return fmt.Errorf("failed to marshal notification: %w", err)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, notificationKey(id), payload, 0)
+			return nil
+		})
+		if err == nil {
+			result.Cancelled = true
+			result.Status = notification.Status
+			cancelled = notification
+		}
+		return err
+	})
+	if errors.Is(err, redis.TxFailedErr) {
+		return nil, fmt.Errorf("failed to cancel notification: it kept changing: %w", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if result.Cancelled {
+		if err := s.scheduled.Remove(ctx, id); err != nil {
+			slog.WarnContext(ctx, "Failed to drop cancelled notification from the scheduled queue", "notification.id", id, "error", err)
+		}
+		if err := s.retries.Unschedule(ctx, id); err != nil {
+			slog.WarnContext(ctx, "Failed to drop cancelled notification from the retry queue", "notification.id", id, "error", err)
+		}
+		if err := s.redis.client.HIncrBy(ctx, notificationStatsKey, "cancelled", 1).Err(); err != nil {
+			slog.WarnContext(ctx, "Failed to count cancellation", "notification.id", id, "error", err)
+		}
+		telemetry.RecordStatusTransition(ctx, string(cancelled.Type), string(previous), string(cancelled.Status))
+		s.persist(ctx, cancelled)
+	} else {
+		if err := s.redis.client.HIncrBy(ctx, notificationStatsKey, "cancel_too_late", 1).Err(); err != nil {
+			return nil, fmt.Errorf("failed to record cancellation: %w", err)
+		}
+	}
+	telemetry.RecordNotificationCancel(ctx, result.Cancelled)
+	return result, nil
+}
+
+// CancelOrderNotifications cancels every unsent notification for an order
+func (s *NotificationService) CancelOrderNotifications(ctx context.Context, orderID string) ([]*models.CancelResult, error) {
+	ids, err := s.redis.client.SMembers(ctx, orderNotificationsKey(orderID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list order notifications: %w", err)
+	}
+
+	results := make([]*models.CancelResult, 0, len(ids))
+	for _, id := range ids {
+		result, err := s.CancelNotification(ctx, id)
+		if errors.Is(err, ErrNotificationNotFound) {
+			continue
+		}
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// CancellationStats returns how many cancellations succeeded and how many arrived too late
+func (s *NotificationService) CancellationStats(ctx context.Context) (map[string]int64, error) {
+	values, err := s.redis.client.HMGet(ctx, notificationStatsKey, "cancelled", "cancel_too_late").Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cancellation stats: %w", err)
+	}
+
+	stats := map[string]int64{"cancelled": 0, "cancel_too_late": 0}
+	for i, field := range []string{"cancelled", "cancel_too_late"} {
+		if value, ok := values[i].(string); ok {
+			count, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s count %q: %w", field, value, err)
+			}
+			stats[field] = count
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+
+	"notification-service/internal/config"
+	"notification-service/internal/faults"
+	"notification-service/internal/models"
+)
+
+// maxBulkNotifications matches the binding on BulkNotificationRequest.Notifications
+const maxBulkNotifications = 100
+
+// CapabilityReporter describes what this deployment actually supports from its
+// configuration and the live state of the services that enforce it
+type CapabilityReporter struct {
+	cfg         *config.Config
+	throttle    *ProviderThrottle
+	fairness    *FairDispatcher
+	sampler     *ProviderPayloadSampler
+	deadLetters *DeadLetterQueue
+	resume      *WebSocketMessageBuffer
+}
+
+// NewCapabilityReporter takes the WebSocket replay buffer only when resume is enabled
+func NewCapabilityReporter(cfg *config.Config, throttle *ProviderThrottle, fairness *FairDispatcher, sampler *ProviderPayloadSampler, deadLetters *DeadLetterQueue, resume *WebSocketMessageBuffer) *CapabilityReporter {
+	return &CapabilityReporter{
+		cfg:         cfg,
+		throttle:    throttle,
+		fairness:    fairness,
+		sampler:     sampler,
+		deadLetters: deadLetters,
+		resume:      resume,
+	}
+}
+
+// Capabilities reports the deployment's channels, integrations, limits, retention and
+// whether it is a sandbox. Channels with throttled providers carry their throttles.
+func (r *CapabilityReporter) Capabilities(ctx context.Context) models.Capabilities {
+	return models.Capabilities{
+		Service:   r.cfg.ServiceName,
+		Channels:  r.channels(ctx),
+		Providers: r.providers(),
+		Limits:    r.limits(),
+		Retention: r.retention(),
+		Sandbox:   r.sandbox(),
+	}
+}
+
+func (r *CapabilityReporter) channels(ctx context.Context) []models.ChannelCapability {
+	smtp := r.cfg.SMTPHost != ""
+	twilio := r.cfg.TwilioAccountSID != "" && r.cfg.TwilioAuthToken != "" && r.cfg.TwilioPhoneNumber != ""
+	push := r.cfg.FCMServerKey != "" || r.cfg.APNSKeyID != ""
+	teams := r.cfg.TeamsWebhookURLs != ""
+
+	channels := []models.ChannelCapability{
+		{Channel: models.NotificationTypeEmail, Enabled: smtp, Provider: "smtp", Configured: smtp},
+		{Channel: models.NotificationTypeSMS, Enabled: twilio, Provider: "twilio", Configured: twilio},
+		{Channel: models.NotificationTypePush, Enabled: false, Provider: "fcm/apns", Configured: push},
+		{Channel: models.NotificationTypeWebhook, Enabled: true, Provider: "http", Configured: true},
+		{Channel: models.NotificationTypeTeams, Enabled: teams, Provider: "teams", Configured: teams},
+		{Channel: models.NotificationTypeWebSocket, Enabled: true, Provider: "websocket", Configured: true},
+	}
+	for i := range channels {
+		channels[i].Throttles = r.throttle.ChannelThrottles(ctx, channels[i].Channel)
+	}
+	return channels
+}
+
+func (r *CapabilityReporter) providers() []models.ProviderCapability {
+	return []models.ProviderCapability{
+		{Name: "event_hub", Purpose: "order event consumption", Configured: r.cfg.EventHubConnectionString != ""},
+		{Name: "service_bus", Purpose: "order event consumption", Configured: r.cfg.ServiceBusConnectionString != ""},
+		{Name: "event_hub_producer", Purpose: "lifecycle event publishing", Configured: r.cfg.EventHubProducerConnectionString != ""},
+		{Name: "content_screening", Purpose: "content screening before send", Configured: enabledMode(r.cfg.ContentScreening), Mode: r.cfg.ContentScreening},
+		{Name: "language_detection", Purpose: "template locale selection", Configured: enabledMode(r.cfg.LanguageDetection), Mode: r.cfg.LanguageDetection},
+		{Name: "translation", Purpose: "machine translation of templates", Configured: r.cfg.TranslationEnabled && r.cfg.TranslatorKey != ""},
+		{Name: "engagement_tracking", Purpose: "email open and click tracking", Configured: r.cfg.TrackingBaseURL != "" && r.cfg.TrackingSecret != ""},
+		{Name: "authentication", Purpose: "bearer token authentication", Configured: r.cfg.AuthEnabled},
+		{Name: "grpc", Purpose: "gRPC API", Configured: r.cfg.GRPCPort != ""},
+	}
+}
+
+func enabledMode(mode string) bool {
+	return mode != "" && mode != "off"
+}
+
+func (r *CapabilityReporter) limits() models.CapabilityLimits {
+	limits := models.CapabilityLimits{
+		MaxBulkNotifications:       maxBulkNotifications,
+		BulkWorkers:                max(r.cfg.BulkWorkers, 1),
+		TenantFairness:             r.fairness.enabled,
+		ProviderThrottleMaxSeconds: int(r.throttle.maxHold.Seconds()),
+		SilentPushPerHour:          max(r.cfg.SilentPushPerHour, 0),
+	}
+	if r.fairness.enabled {
+		limits.MaxInFlightDeliveries = r.fairness.maxInFlight
+		limits.TenantMaxInFlight = r.fairness.tenantCap
+	}
+	return limits
+}
+
+func (r *CapabilityReporter) retention() models.RetentionPolicy {
+	retention := models.RetentionPolicy{
+		NotificationCacheSeconds:   int(notificationCacheTTL.Seconds()),
+		DeadLetterMaxEntries:       int(r.deadLetters.maxLen),
+		WebhookAttemptsHours:       int(webhookAttemptsTTL.Hours()),
+		ProviderPayloadSampleHours: int(r.sampler.retention.Hours()),
+		UsageMinuteHours:           int(usageMinuteRetention.Hours()),
+		UsageHourDays:              int(usageHourRetention.Hours() / 24),
+		ConversationDays:           int(conversationRetention.Hours() / 24),
+	}
+	// Without a replay buffer WebSocket sessions cannot be resumed
+	if r.resume != nil {
+		retention.WebSocketResumeSeconds = int(r.resume.ttl.Seconds())
+	}
+	return retention
+}
+
+func (r *CapabilityReporter) sandbox() models.SandboxMode {
+	sandbox := models.SandboxMode{
+		Environment:      r.cfg.Environment,
+		DemoEndpoints:    r.cfg.DemoEndpointsEnabled,
+		FailureInjection: faults.Enabled() && !faults.DryRun(),
+		ChaosExperiment:  faults.ActiveExperimentID(),
+	}
+	sandbox.Enabled = sandbox.Environment != "production" || sandbox.DemoEndpoints || sandbox.FailureInjection
+	return sandbox
+}
@@ -0,0 +1,125 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+)
+
+// coalesceKey identifies a stream of updates that can replace each other: every update
+// for one order, whatever its event type, since the latest says where the order stands
+type coalesceKey struct {
+	customerID string
+	orderID    int
+}
+
+// coalesceEntry is an open window; the event type and send of the latest held update
+// go out with it
+type coalesceEntry struct {
+	ctx       context.Context
+	eventType string
+	latest    models.WebSocketMessage
+	merged    int
+	send      func(ctx context.Context, message models.WebSocketMessage)
+	timer     *time.Timer
+}
+
+// Coalescer merges rapid successive WebSocket updates for the same customer and order,
+// such as a creation, payment and status change arriving together. The first update is
+// sent at once and opens a window; updates arriving inside the window replace each other
+// and only the latest is sent when it closes.
+type Coalescer struct {
+	window time.Duration
+
+	mutex   sync.Mutex
+	pending map[coalesceKey]*coalesceEntry
+}
+
+// NewCoalescer creates a coalescer; a zero window disables coalescing
+func NewCoalescer(window time.Duration) *Coalescer {
+	return &Coalescer{
+		window:  window,
+		pending: make(map[coalesceKey]*coalesceEntry),
+	}
+}
+
+// Enabled reports whether a coalescing window is configured
+func (c *Coalescer) Enabled() bool {
+	return c.window > 0
+}
+
+// Submit sends the update now, or holds it if a window for the same stream is open. The
+// returned flag reports whether it was held. The latest held update's send sends the
+// trailing update.
+func (c *Coalescer) Submit(ctx context.Context, customerID string, orderID int, eventType string, message models.WebSocketMessage,
+	send func(ctx context.Context, message models.WebSocketMessage)) bool {
+	if !c.Enabled() {
+		send(ctx, message)
+		return false
+	}
+
+	key := coalesceKey{customerID: customerID, orderID: orderID}
+
+	c.mutex.Lock()
+	if entry, ok := c.pending[key]; ok {
+		entry.ctx = context.WithoutCancel(ctx)
+		entry.eventType = eventType
+		entry.latest = message
+		entry.send = send
+		entry.merged++
+		c.mutex.Unlock()
+		return true
+	}
+	entry := &coalesceEntry{eventType: eventType, send: send}
+	entry.timer = time.AfterFunc(c.window, func() { c.flush(key) })
+	c.pending[key] = entry
+	c.mutex.Unlock()
+
+	send(ctx, message)
+	return false
+}
+
+// Flush closes every open window now, sending the updates they hold; used on shutdown,
+// before the connections they would go to are closed
+func (c *Coalescer) Flush() {
+	c.mutex.Lock()
+	keys := make([]coalesceKey, 0, len(c.pending))
+	for key, entry := range c.pending {
+		entry.timer.Stop()
+		keys = append(keys, key)
+	}
+	c.mutex.Unlock()
+
+	for _, key := range keys {
+		c.flush(key)
+	}
+}
+
+// flush closes a window and sends its latest held update, if any
+func (c *Coalescer) flush(key coalesceKey) {
+	c.mutex.Lock()
+	entry := c.pending[key]
+	delete(c.pending, key)
+	c.mutex.Unlock()
+
+	if entry == nil || entry.merged == 0 {
+		return
+	}
+
+	// Tell the client how many updates this message stands for
+	message := entry.latest
+	if data, ok := message.Data.(map[string]interface{}); ok {
+		merged := make(map[string]interface{}, len(data)+1)
+		for k, v := range data {
+			merged[k] = v
+		}
+		merged["coalescedUpdates"] = entry.merged
+		message.Data = merged
+	}
+
+	telemetry.RecordWebSocketCoalesced(entry.ctx, entry.eventType, entry.merged)
+	entry.send(entry.ctx, message)
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrInvalidCollapseKey means a collapse key was given for a channel that can't replace
+// notifications
+var ErrInvalidCollapseKey = errors.New("invalid collapse key")
+
+// collapseKeyMaxBytes is the longest apns-collapse-id APNs accepts
+const collapseKeyMaxBytes = 64
+
+// collapseRetention is how long the latest notification of a collapse key is remembered;
+// FCM keeps a collapsible message for at most 28 days
+const collapseRetention = 28 * 24 * time.Hour
+
+// replacedStatPrefix prefixes the per-channel replacement counts in notificationStatsKey
+const replacedStatPrefix = "replaced:"
+
+// collapsibleChannels are the channels whose notifications replace each other
+var collapsibleChannels = map[models.NotificationType]bool{
+	models.NotificationTypePush:      true,
+	models.NotificationTypeWebSocket: true,
+}
+
+// collapseKeyKey holds the latest notification of a customer's collapse key on a channel
+func collapseKeyKey(customerID string, channel models.NotificationType, key string) string {
+	return fmt.Sprintf("collapse:%s:%s:%s", channel, customerID, key)
+}
+
+// ValidateCollapseKey checks that a notification with a collapse key goes to a channel
+// that can replace notifications, and that the key fits in an apns-collapse-id
+func ValidateCollapseKey(notification *models.Notification) error {
+	if notification.CollapseKey == "" {
+		return nil
+	}
+	if !collapsibleChannels[notification.Type] {
+		return fmt.Errorf("%w: only push and websocket notifications can be collapsed", ErrInvalidCollapseKey)
+	}
+	if len(notification.CollapseKey) > collapseKeyMaxBytes {
+		return fmt.Errorf("%w: longer than %d bytes", ErrInvalidCollapseKey, collapseKeyMaxBytes)
+	}
+	return nil
+}
+
+// claimCollapseKey makes notification the latest of its collapse key and returns the ID
+// of the notification it replaces, if any
+func (s *NotificationService) claimCollapseKey(ctx context.Context, notification *models.Notification) (string, error) {
+	key := collapseKeyKey(notification.CustomerID, notification.Type, notification.CollapseKey)
+	pipe := s.redis.client.TxPipeline()
+	previous := pipe.GetSet(ctx, key, notification.ID)
+	pipe.Expire(ctx, key, collapseRetention)
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return "", fmt.Errorf("failed to claim collapse key: %w", err)
+	}
+	if previous.Val() == notification.ID {
+		return "", nil
+	}
+	return previous.Val(), nil
+}
+
+// replace makes a saved notification the latest of its collapse key, records on it the
+// notification it replaces and marks that one replaced. Only notifications that will be
+// sent replace others; a blocked, suppressed or batched one would hide one the customer
+// got. Failures are logged; the new notification is already saved.
+func (s *NotificationService) replace(ctx context.Context, notification *models.Notification) {
+	if notification.Status != models.NotificationStatusPending {
+		return
+	}
+	previous, err := s.claimCollapseKey(ctx, notification)
+	if err != nil {
+		slog.WarnContext(ctx, "Notification saved without replacing by collapse key", "notification.id", notification.ID, "error", err)
+		return
+	}
+	if previous == "" {
+		return
+	}
+
+	notification.Replaces = previous
+	updated, err := s.editStored(ctx, notification.ID, func(stored *models.Notification) bool {
+		stored.Replaces = previous
+		return true
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to record replaced notification", "notification.id", notification.ID, "notification.replaces", previous, "error", err)
+	} else if updated != nil {
+		notification.Version = updated.Version
+		s.persist(ctx, updated)
+	}
+	s.markReplaced(ctx, previous, notification)
+}
+
+// editStored applies edit to the Redis copy of a notification, saving it when edit
+// reports a change, and returns the saved notification
+func (s *NotificationService) editStored(ctx context.Context, id string, edit func(*models.Notification) bool) (*models.Notification, error) {
+	records, err := s.residency.Records(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	var edited *models.Notification
+	err = records.Watch(ctx, func(tx *redis.Tx) error {
+		notification, err := loadNotification(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if !edit(notification) {
+			return nil
+		}
+		notification.Version++
+		payload, err := json.Marshal(notification)
+		if err != nil {
+			return fmt.Errorf("failed to marshal notification: %w", err)
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, notificationKey(id), payload, 0)
+			return nil
+		})
+		edited = notification
+		return err
+	}, notificationKey(id))
+	return edited, err
+}
+
+// markReplaced records on the previous notification of a collapse key that replacement
+// replaced it, so inbox listings show only the newer one, and counts the replacement
+func (s *NotificationService) markReplaced(ctx context.Context, previousID string, replacement *models.Notification) {
+	replaced, err := s.editStored(ctx, previousID, func(notification *models.Notification) bool {
+		if notification.ReplacedBy != "" {
+			return false
+		}
+		notification.ReplacedBy = replacement.ID
+		return true
+	})
+
+	switch {
+	case errors.Is(err, ErrNotificationNotFound):
+		// Deleted since; the new notification just has nothing to hide
+		return
+	case err != nil:
+		slog.WarnContext(ctx, "Failed to mark notification replaced", "notification.id", previousID, "notification.replaced_by", replacement.ID, "error", err)
+		return
+	case replaced == nil:
+		return
+	}
+
+	if err := s.redis.client.HIncrBy(ctx, notificationStatsKey, replacedStatPrefix+string(replacement.Type), 1).Err(); err != nil {
+		slog.WarnContext(ctx, "Failed to count notification replacement", "notification.id", previousID, "error", err)
+	}
+	s.persist(ctx, replaced)
+	telemetry.RecordNotificationReplaced(ctx, string(replacement.Type))
+	slog.InfoContext(ctx, "Notification replaced", "notification.id", previousID, "notification.replaced_by", replacement.ID, "notification.channel", replacement.Type)
+}
+
+// ReplacementStats returns how many notifications newer ones have replaced, by channel
+func (s *NotificationService) ReplacementStats(ctx context.Context) (map[string]int64, error) {
+	fields, err := s.redis.client.HGetAll(ctx, notificationStatsKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load replacement stats: %w", err)
+	}
+
+	stats := make(map[string]int64)
+	for field, value := range fields {
+		channel, ok := strings.CutPrefix(field, replacedStatPrefix)
+		if !ok {
+			continue
+		}
+		count, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s count %q: %w", field, value, err)
+		}
+		stats[channel] = count
+	}
+	return stats, nil
+}
@@ -0,0 +1,231 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	contentSafetyAPIVersion = "2023-10-01"
+	// contentSafetyMaxChars is the text:analyze limit per request; longer content is
+	// analyzed in parts of this size
+	contentSafetyMaxChars = 10000
+)
+
+// contentSafetyCategories are the harm categories Azure AI Content Safety scores
+var contentSafetyCategories = []string{"Hate", "SelfHarm", "Sexual", "Violence"}
+
+type contentSafetyRequest struct {
+	Text               string   `json:"text"`
+	Categories         []string `json:"categories"`
+	BlocklistNames     []string `json:"blocklistNames,omitempty"`
+	HaltOnBlocklistHit bool     `json:"haltOnBlocklistHit"`
+	OutputType         string   `json:"outputType"`
+}
+
+type contentSafetyResponse struct {
+	BlocklistsMatch []struct {
+		BlocklistName   string `json:"blocklistName"`
+		BlocklistItemID string `json:"blocklistItemId"`
+	} `json:"blocklistsMatch"`
+	CategoriesAnalysis []struct {
+		Category string `json:"category"`
+		Severity int    `json:"severity"`
+	} `json:"categoriesAnalysis"`
+}
+
+type contentSafetyError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// ContentSafetyScreener screens message content with Azure AI Content Safety text
+// analysis: the subject, the message, the text of the HTML message and the card text,
+// all of it, in as many requests as the text:analyze limit takes. Content whose highest
+// category severity reaches the block severity, or that matches a configured blocklist,
+// is blocked; content reaching the flag severity is flagged. Category severities (0, 2,
+// 4 or 6) are returned with the decision, the highest of any part.
+type ContentSafetyScreener struct {
+	endpoint      string
+	key           string
+	flagSeverity  int
+	blockSeverity int
+	blocklists    []string
+	client        *http.Client
+}
+
+func NewContentSafetyScreener(cfg *config.Config) *ContentSafetyScreener {
+	var blocklists []string
+	for _, name := range strings.Split(cfg.ContentSafetyBlocklists, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			blocklists = append(blocklists, name)
+		}
+	}
+	return &ContentSafetyScreener{
+		endpoint:      strings.TrimSuffix(cfg.ContentSafetyEndpoint, "/"),
+		key:           cfg.ContentSafetyKey,
+		flagSeverity:  cfg.ContentSafetyFlagSeverity,
+		blockSeverity: cfg.ContentSafetyBlockSeverity,
+		blocklists:    blocklists,
+		client:        NewHTTPClient(cfg, ProviderContentSafety, time.Duration(cfg.ContentScreeningTimeoutMs)*time.Millisecond),
+	}
+}
+
+func (s *ContentSafetyScreener) Screen(ctx context.Context, req models.ScreeningRequest) (models.ScreeningDecision, error) {
+	text := strings.TrimSpace(req.Subject + "\n" + req.Message + "\n" + htmlText(req.HTMLMessage) + "\n" + req.CardText)
+	if text == "" {
+		return models.ScreeningDecision{Action: models.ScreeningActionAllow, Screener: ContentScreeningAzure}, nil
+	}
+
+	ctx, span := telemetry.Tracer.Start(ctx, "content_safety.analyze",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("server.address", s.endpoint),
+			attribute.Int("content_safety.text_length", utf8.RuneCountInString(text)),
+		),
+	)
+	defer span.End()
+
+	parts := splitRunes(text, contentSafetyMaxChars)
+	span.SetAttributes(attribute.Int("content_safety.parts", len(parts)))
+	decision := models.ScreeningDecision{
+		Action:     models.ScreeningActionAllow,
+		Screener:   ContentScreeningAzure,
+		Categories: make(map[string]int, len(contentSafetyCategories)),
+	}
+	var blocklist string
+	for _, part := range parts {
+		result, err := s.analyze(ctx, part)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, "content safety analysis failed")
+			return models.ScreeningDecision{}, err
+		}
+		for _, analysis := range result.CategoriesAnalysis {
+			decision.Categories[analysis.Category] = max(decision.Categories[analysis.Category], analysis.Severity)
+		}
+		if len(result.BlocklistsMatch) > 0 && blocklist == "" {
+			blocklist = result.BlocklistsMatch[0].BlocklistName
+		}
+	}
+
+	highest := 0
+	var violations []string
+	for category, severity := range decision.Categories {
+		span.SetAttributes(attribute.Int("content_safety.severity."+strings.ToLower(category), severity))
+		highest = max(highest, severity)
+		if severity >= s.flagSeverity {
+			violations = append(violations, fmt.Sprintf("%s severity %d", category, severity))
+			decision.Labels = append(decision.Labels, category)
+		}
+	}
+	sort.Strings(violations)
+	sort.Strings(decision.Labels)
+	decision.Score = float64(highest) / 6
+
+	switch {
+	case blocklist != "":
+		decision.Action = models.ScreeningActionBlock
+		decision.Reason = "matched blocklist " + blocklist
+		decision.Labels = append(decision.Labels, "blocklist")
+	case highest >= s.blockSeverity:
+		decision.Action = models.ScreeningActionBlock
+		decision.Reason = strings.Join(violations, ", ")
+	case highest >= s.flagSeverity:
+		decision.Action = models.ScreeningActionFlag
+		decision.Reason = strings.Join(violations, ", ")
+	}
+	return decision, nil
+}
+
+func (s *ContentSafetyScreener) analyze(ctx context.Context, text string) (*contentSafetyResponse, error) {
+	body, err := json.Marshal(contentSafetyRequest{
+		Text:           text,
+		Categories:     contentSafetyCategories,
+		BlocklistNames: s.blocklists,
+		OutputType:     "FourSeverityLevels",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/contentsafety/text:analyze?api-version=%s", s.endpoint, contentSafetyAPIVersion)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Ocp-Apim-Subscription-Key", s.key)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	payload, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		var apiErr contentSafetyError
+		if json.Unmarshal(payload, &apiErr) == nil && apiErr.Error.Message != "" {
+			return nil, fmt.Errorf("content safety %s: %s: %w", apiErr.Error.Code, apiErr.Error.Message,
+				&StatusError{StatusCode: resp.StatusCode, RetryAfterDelay: ParseRetryAfter(resp.Header.Get("Retry-After"))})
+		}
+		return nil, &StatusError{StatusCode: resp.StatusCode, RetryAfterDelay: ParseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+
+	var result contentSafetyResponse
+	if err := json.Unmarshal(payload, &result); err != nil {
+		return nil, fmt.Errorf("failed to decode content safety response: %w", err)
+	}
+	return &result, nil
+}
+
+// splitRunes cuts text into parts of at most n characters, without splitting a UTF-8
+// sequence, breaking at the last whitespace of a part where there is one so words stay
+// whole
+func splitRunes(text string, n int) []string {
+	var parts []string
+	runes := []rune(text)
+	for len(runes) > n {
+		end := n
+		for i := n; i > n/2; i-- {
+			if unicode.IsSpace(runes[i]) {
+				end = i
+				break
+			}
+		}
+		parts = append(parts, string(runes[:end]))
+		runes = runes[end:]
+	}
+	return append(parts, string(runes))
+}
+
+// truncateRunes cuts text to at most n characters without splitting a UTF-8 sequence
+func truncateRunes(text string, n int) string {
+	if utf8.RuneCountInString(text) <= n {
+		return text
+	}
+	return string([]rune(text)[:n])
+}
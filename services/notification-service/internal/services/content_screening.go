@@ -0,0 +1,353 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Content screening modes
+const (
+	ContentScreeningOff       = "off"
+	ContentScreeningHeuristic = "heuristic"
+	ContentScreeningHook      = "hook"
+	ContentScreeningAzure     = "azure_content_safety"
+)
+
+// ErrContentBlocked means the content screener blocked a notification
+var ErrContentBlocked = errors.New("blocked by content screening")
+
+// ContentScreening runs rendered content past the configured screener before it is
+// sent and records the decision. A screener that fails allows the send when
+// CONTENT_SCREENING_FAIL_OPEN is set and blocks it otherwise.
+type ContentScreening struct {
+	screener ContentScreener
+	name     string
+	failOpen bool
+}
+
+// NewContentScreening builds the screener CONTENT_SCREENING selects. Other screeners,
+// such as an ML model client, are plugged in with NewContentScreeningWith.
+func NewContentScreening(cfg *config.Config) *ContentScreening {
+	switch cfg.ContentScreening {
+	case ContentScreeningHeuristic:
+		return NewContentScreeningWith(ContentScreeningHeuristic, NewHeuristicScreener(cfg), cfg.ContentScreeningFailOpen)
+	case ContentScreeningHook:
+		if cfg.ContentScreeningHookURL == "" {
+			slog.Warn("CONTENT_SCREENING=hook without CONTENT_SCREENING_HOOK_URL, content screening disabled")
+			return NewContentScreeningWith(ContentScreeningOff, nil, true)
+		}
+		return NewContentScreeningWith(ContentScreeningHook, NewHookScreener(cfg), cfg.ContentScreeningFailOpen)
+	case ContentScreeningAzure:
+		if cfg.ContentSafetyEndpoint == "" || cfg.ContentSafetyKey == "" {
+			slog.Warn("CONTENT_SCREENING=azure_content_safety without CONTENT_SAFETY_ENDPOINT and CONTENT_SAFETY_KEY, content screening disabled")
+			return NewContentScreeningWith(ContentScreeningOff, nil, true)
+		}
+		return NewContentScreeningWith(ContentScreeningAzure, NewContentSafetyScreener(cfg), cfg.ContentScreeningFailOpen)
+	case ContentScreeningOff, "":
+		return NewContentScreeningWith(ContentScreeningOff, nil, true)
+	default:
+		slog.Warn("Unknown CONTENT_SCREENING, content screening disabled", "content_screening", cfg.ContentScreening)
+		return NewContentScreeningWith(ContentScreeningOff, nil, true)
+	}
+}
+
+// NewContentScreeningWith screens content with screener, reported under name; a nil
+// screener allows everything without recording decisions
+func NewContentScreeningWith(name string, screener ContentScreener, failOpen bool) *ContentScreening {
+	return &ContentScreening{screener: screener, name: name, failOpen: failOpen}
+}
+
+// Enabled reports whether a screener is configured
+func (s *ContentScreening) Enabled() bool {
+	return s != nil && s.screener != nil
+}
+
+// Screen asks the screener for a decision. It never fails: screener errors turn into an
+// allow or block decision according to the fail-open setting.
+func (s *ContentScreening) Screen(ctx context.Context, req models.ScreeningRequest) (models.ScreeningDecision, error) {
+	if !s.Enabled() {
+		return models.ScreeningDecision{Action: models.ScreeningActionAllow, Screener: ContentScreeningOff}, nil
+	}
+
+	ctx, span := telemetry.Tracer.Start(ctx, "content.screen")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("screening.screener", s.name),
+		attribute.String("notification.channel", string(req.Channel)),
+	)
+
+	decision, err := s.screener.Screen(ctx, req)
+	if err == nil && !knownScreeningAction(decision.Action) {
+		err = fmt.Errorf("screener returned unknown action %q", decision.Action)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "content screening failed")
+		slog.WarnContext(ctx, "Content screening failed", "customer.id", req.CustomerID, "error", err)
+		decision = models.ScreeningDecision{Action: models.ScreeningActionBlock, Reason: "screening unavailable: " + err.Error()}
+		if s.failOpen {
+			decision.Action = models.ScreeningActionAllow
+		}
+	}
+	if decision.Screener == "" {
+		decision.Screener = s.name
+	}
+	decision.ScreenedAt = time.Now().UTC()
+
+	span.SetAttributes(
+		attribute.String("screening.action", string(decision.Action)),
+		attribute.Float64("screening.score", decision.Score),
+	)
+	telemetry.RecordContentScreening(ctx, decision.Screener, string(decision.Action), string(req.Channel))
+	return decision, nil
+}
+
+// ScreenNotification records the content screener's decision on a notification, and any
+// moderation category severities in its metadata. A blocked notification is marked
+// blocked, which is final, and ErrContentBlocked is returned; it must not be sent. A
+// screener error lets the notification through unscreened.
+func ScreenNotification(ctx context.Context, screener ContentScreener, notification *models.Notification) error {
+	decision, err := screener.Screen(ctx, models.ScreeningRequest{
+		NotificationID: notification.ID,
+		CustomerID:     notification.CustomerID,
+		Channel:        notification.Type,
+		Subject:        notification.Subject,
+		Message:        notification.Message,
+		HTMLMessage:    notification.HTMLMessage,
+		CardText:       CardText(notification.Card),
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "Content screening failed, sending unscreened", "notification.id", notification.ID, "error", err)
+		return nil
+	}
+	if decision.Screener == ContentScreeningOff {
+		return nil
+	}
+
+	notification.Screening = &decision
+	for category, severity := range decision.Categories {
+		if notification.Metadata == nil {
+			notification.Metadata = map[string]interface{}{}
+		}
+		notification.Metadata["content_safety."+strings.ToLower(category)] = severity
+	}
+	if decision.Action != models.ScreeningActionBlock {
+		return nil
+	}
+	err = fmt.Errorf("%w: %s", ErrContentBlocked, decision.Reason)
+	now := time.Now().UTC()
+	notification.Status = models.NotificationStatusBlocked
+	notification.FailedAt = &now
+	notification.ErrorMessage = err.Error()
+	return err
+}
+
+func knownScreeningAction(action models.ScreeningAction) bool {
+	switch action {
+	case models.ScreeningActionAllow, models.ScreeningActionFlag, models.ScreeningActionBlock:
+		return true
+	}
+	return false
+}
+
+// HookScreener posts the content to an external HTTP hook, which answers with a
+// decision: {"action": "allow|flag|block", "reason": "...", "score": 0.93, "labels": [...]}
+type HookScreener struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func NewHookScreener(cfg *config.Config) *HookScreener {
+	return &HookScreener{
+		url:    cfg.ContentScreeningHookURL,
+		secret: cfg.WebhookSigningSecret,
+		client: NewHTTPClient(cfg, ProviderContentScreening, time.Duration(cfg.ContentScreeningTimeoutMs)*time.Millisecond),
+	}
+}
+
+func (h *HookScreener) Screen(ctx context.Context, req models.ScreeningRequest) (models.ScreeningDecision, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return models.ScreeningDecision{}, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return models.ScreeningDecision{}, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if h.secret != "" {
+		httpReq.Header.Set("X-Signature", "sha256="+SignPayload(h.secret, body))
+	}
+
+	resp, err := h.client.Do(httpReq)
+	if err != nil {
+		return models.ScreeningDecision{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return models.ScreeningDecision{}, &StatusError{StatusCode: resp.StatusCode}
+	}
+
+	var decision models.ScreeningDecision
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&decision); err != nil {
+		return models.ScreeningDecision{}, fmt.Errorf("failed to decode screening decision: %w", err)
+	}
+	decision.Screener = ContentScreeningHook
+	return decision, nil
+}
+
+// Heuristic screening thresholds
+const (
+	heuristicMaxLinks       = 3
+	heuristicMinCapsLetters = 20
+	heuristicCapsRatio      = 0.7
+)
+
+var (
+	linkPattern        = regexp.MustCompile(`(?i)https?://`)
+	punctuationPattern = regexp.MustCompile(`[!?$]{4,}`)
+)
+
+// HeuristicScreener is the built-in screener: blocklisted terms block the send, and
+// spam signals (many links, shouting, runs of !!!!) flag it
+type HeuristicScreener struct {
+	blocklist []string
+}
+
+func NewHeuristicScreener(cfg *config.Config) *HeuristicScreener {
+	var blocklist []string
+	for _, term := range strings.Split(cfg.ContentScreeningBlocklist, ",") {
+		if term = strings.ToLower(strings.TrimSpace(term)); term != "" {
+			blocklist = append(blocklist, term)
+		}
+	}
+	return &HeuristicScreener{blocklist: blocklist}
+}
+
+func (h *HeuristicScreener) Screen(ctx context.Context, req models.ScreeningRequest) (models.ScreeningDecision, error) {
+	content := req.Subject + "\n" + req.Message + "\n" + htmlText(req.HTMLMessage) + "\n" + req.CardText
+	lower := strings.ToLower(content)
+
+	for _, term := range h.blocklist {
+		if strings.Contains(lower, term) {
+			return models.ScreeningDecision{
+				Action: models.ScreeningActionBlock,
+				Reason: fmt.Sprintf("contains blocked term %q", term),
+				Score:  1,
+				Labels: []string{"blocklist"},
+			}, nil
+		}
+	}
+
+	var labels []string
+	// Links are counted in the HTML itself, whose hrefs its text leaves out
+	if len(linkPattern.FindAllStringIndex(req.Subject+"\n"+req.Message+"\n"+req.HTMLMessage+"\n"+req.CardText, -1)) > heuristicMaxLinks {
+		labels = append(labels, "links")
+	}
+	if shouting(req.Subject + " " + req.Message) {
+		labels = append(labels, "caps")
+	}
+	if punctuationPattern.MatchString(content) {
+		labels = append(labels, "punctuation")
+	}
+	if len(labels) == 0 {
+		return models.ScreeningDecision{Action: models.ScreeningActionAllow}, nil
+	}
+	return models.ScreeningDecision{
+		Action: models.ScreeningActionFlag,
+		Reason: "spam signals: " + strings.Join(labels, ", "),
+		Score:  float64(len(labels)) / 3,
+		Labels: labels,
+	}, nil
+}
+
+// htmlText is the text a reader of an HTML message sees, with the alt and title text of
+// its elements, so words split by markup are screened whole. Scripts and styles are left
+// out.
+func htmlText(message string) string {
+	if message == "" {
+		return ""
+	}
+	var text strings.Builder
+	skip := 0
+	tokenizer := html.NewTokenizer(strings.NewReader(message))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return strings.Join(strings.Fields(text.String()), " ")
+		case html.TextToken:
+			if skip == 0 {
+				text.Write(tokenizer.Text())
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			token := tokenizer.Token()
+			if token.DataAtom == atom.Script || token.DataAtom == atom.Style {
+				if token.Type == html.StartTagToken {
+					skip++
+				}
+				continue
+			}
+			for _, attr := range token.Attr {
+				if attr.Key == "alt" || attr.Key == "title" {
+					text.WriteString(" " + attr.Val + " ")
+				}
+			}
+			if !inlineElement(token.DataAtom) {
+				text.WriteString(" ")
+			}
+		case html.EndTagToken:
+			token := tokenizer.Token()
+			if token.DataAtom == atom.Script || token.DataAtom == atom.Style {
+				skip = max(skip-1, 0)
+			} else if !inlineElement(token.DataAtom) {
+				text.WriteString(" ")
+			}
+		}
+	}
+}
+
+// inlineElement reports whether an element runs on within a word, like <b>, rather than
+// separating the text around it
+func inlineElement(element atom.Atom) bool {
+	switch element {
+	case atom.A, atom.Abbr, atom.B, atom.Bdi, atom.Bdo, atom.Cite, atom.Code, atom.Data, atom.Dfn, atom.Em,
+		atom.Font, atom.I, atom.Kbd, atom.Mark, atom.Q, atom.S, atom.Samp, atom.Small, atom.Span,
+		atom.Strong, atom.Sub, atom.Sup, atom.Time, atom.U, atom.Var:
+		return true
+	}
+	return false
+}
+
+// shouting reports whether most letters of a reasonably long text are upper case
+func shouting(text string) bool {
+	letters, upper := 0, 0
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+	return letters >= heuristicMinCapsLetters && float64(upper) >= heuristicCapsRatio*float64(letters)
+}
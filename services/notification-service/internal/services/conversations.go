@@ -0,0 +1,207 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"notification-service/internal/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var ErrConversationNotFound = errors.New("conversation not found")
+
+const (
+	// conversationMaxMessages caps the notifications of a conversation that are returned;
+	// older ones are left out
+	conversationMaxMessages = 200
+	// emailThreadMaxReferences caps the References of an email, as RFC 5322 suggests
+	// trimming long ones
+	emailThreadMaxReferences = 10
+	// emailThreadScan is how many earlier notifications of a conversation are searched
+	// for the emails an email threads under
+	emailThreadScan = 50
+	// conversationRetention is how long a conversation's index is kept after its latest
+	// notification
+	conversationRetention = 90 * 24 * time.Hour
+)
+
+// conversationKey indexes the notifications of a conversation by creation time
+func conversationKey(id string) string {
+	return "conversation:" + id
+}
+
+// ConversationID is the conversation a notification belongs to: the one it was created
+// in, else its order's, else its customer's (its recipient's when it has no customer)
+func ConversationID(notification *models.Notification) string {
+	switch {
+	case notification.ConversationID != "":
+		return notification.ConversationID
+	case notification.OrderID != "":
+		return "order-" + notification.OrderID
+	case notification.CustomerID != "":
+		return "customer-" + notification.CustomerID
+	default:
+		return "customer-" + notification.Recipient
+	}
+}
+
+// threadEmail sets the thread references of a new email: the emails created earlier in
+// its conversation to the same recipient, leaving out those never sent. Failing to read
+// them only leaves the email unthreaded.
+func (s *NotificationService) threadEmail(ctx context.Context, notification *models.Notification) {
+	if notification.Type != models.NotificationTypeEmail || len(notification.ThreadReferences) > 0 {
+		return
+	}
+	ids, err := s.redis.client.ZRevRangeByScore(ctx, conversationKey(notification.ConversationID), &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   "(" + strconv.FormatInt(notification.CreatedAt.UnixNano(), 10),
+		Count: emailThreadScan,
+	}).Result()
+	if err == nil && len(ids) > 0 {
+		var earlier []*models.Notification
+		earlier, err = s.residency.LoadNotifications(ctx, ids)
+		for _, previous := range earlier {
+			if previous.Type == models.NotificationTypeEmail && strings.EqualFold(previous.Recipient, notification.Recipient) && !neverSent(previous.Status) {
+				notification.ThreadReferences = append(notification.ThreadReferences, previous.ID)
+				if len(notification.ThreadReferences) == emailThreadMaxReferences {
+					break
+				}
+			}
+		}
+		slices.Reverse(notification.ThreadReferences)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Sending email without thread references", "notification.id", notification.ID, "conversation.id", notification.ConversationID, "error", err)
+	}
+}
+
+// neverSent reports whether a notification in a status was settled without being sent,
+// so there is no email to thread under
+func neverSent(status models.NotificationStatus) bool {
+	switch status {
+	case models.NotificationStatusBlocked, models.NotificationStatusSuppressed,
+		models.NotificationStatusDuplicateSuppressed, models.NotificationStatusCancelled:
+		return true
+	default:
+		return false
+	}
+}
+
+// ConversationService assembles the conversations notifications are grouped into: every
+// notification sent for an order or customer, on any channel, with the email replies
+// each one got
+type ConversationService struct {
+	redis         *RedisClient
+	notifications *NotificationService
+	replies       *EmailReplyService
+}
+
+func NewConversationService(redis *RedisClient, notifications *NotificationService, replies *EmailReplyService) *ConversationService {
+	return &ConversationService{redis: redis, notifications: notifications, replies: replies}
+}
+
+// Conversation returns a conversation's thread, oldest first, with its newest
+// conversationMaxMessages notifications
+func (s *ConversationService) Conversation(ctx context.Context, id string) (*models.Conversation, error) {
+	key := conversationKey(id)
+	var ids *redis.StringSliceCmd
+	var total *redis.IntCmd
+	if _, err := s.redis.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		ids = pipe.ZRevRange(ctx, key, 0, conversationMaxMessages-1)
+		total = pipe.ZCard(ctx, key)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to load conversation: %w", err)
+	}
+	if len(ids.Val()) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrConversationNotFound, id)
+	}
+
+	conversation := &models.Conversation{ID: id, Truncated: total.Val() > int64(len(ids.Val()))}
+	channels := map[models.NotificationType]bool{}
+	notifications, err := s.notifications.getNotifications(ctx, ids.Val())
+	if err != nil {
+		return nil, err
+	}
+	for _, notification := range notifications {
+		conversation.Messages = append(conversation.Messages, models.ConversationMessage{
+			Direction:    models.ConversationOutbound,
+			Channel:      notification.Type,
+			At:           notification.CreatedAt,
+			Notification: notification,
+		})
+		channels[notification.Type] = true
+		if conversation.CustomerID == "" {
+			conversation.CustomerID = notification.CustomerID
+		}
+		if conversation.OrderID == "" {
+			conversation.OrderID = notification.OrderID
+		}
+
+		if notification.Type != models.NotificationTypeEmail {
+			continue
+		}
+		replies, err := s.replies.Replies(ctx, notification.ID)
+		if err != nil {
+			return nil, err
+		}
+		for _, reply := range replies {
+			conversation.Messages = append(conversation.Messages, models.ConversationMessage{
+				Direction: models.ConversationInbound,
+				Channel:   models.NotificationTypeEmail,
+				At:        reply.ReceivedAt,
+				Reply:     reply,
+			})
+		}
+	}
+	if len(conversation.Messages) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrConversationNotFound, id)
+	}
+
+	sort.SliceStable(conversation.Messages, func(i, j int) bool {
+		return conversation.Messages[i].At.Before(conversation.Messages[j].At)
+	})
+	conversation.StartedAt = conversation.Messages[0].At
+	conversation.LastActivityAt = conversation.Messages[len(conversation.Messages)-1].At
+	conversation.Channels = make([]models.NotificationType, 0, len(channels))
+	for channel := range channels {
+		conversation.Channels = append(conversation.Channels, channel)
+	}
+	slices.Sort(conversation.Channels)
+	return conversation, nil
+}
+
+// loadNotifications reads notifications from Redis in one round trip, in the order of
+// ids, skipping those no longer there
+func loadNotifications(ctx context.Context, client *redis.Client, ids []string) ([]*models.Notification, error) {
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = notificationKey(id)
+	}
+	values, err := client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load notifications: %w", err)
+	}
+	notifications := make([]*models.Notification, 0, len(values))
+	for _, value := range values {
+		payload, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var notification models.Notification
+		if err := json.Unmarshal([]byte(payload), &notification); err != nil {
+			return nil, fmt.Errorf("failed to decode notification: %w", err)
+		}
+		notifications = append(notifications, &notification)
+	}
+	return notifications, nil
+}
@@ -0,0 +1,465 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Every customer digest key is hash-tagged alike, so the scripts that move a digest
+// between them run in one cluster slot
+const (
+	// customerDigestsDueKey is a sorted set of open digests, as channel:customer, scored
+	// by the Unix millisecond they are due
+	customerDigestsDueKey = "{customer-digests}:due"
+	// customerDigestPoll is how often due digests are looked for
+	customerDigestPoll = 10 * time.Second
+	// customerDigestBatch caps the digests sent per poll
+	customerDigestBatch = 100
+	// customerDigestLease is how long a replica sending a digest keeps it from the
+	// others; a digest whose sender stopped is sent again after it
+	customerDigestLease = 5 * time.Minute
+
+	maxDigestIntervalMinutes = 7 * 24 * 60
+	maxDigestItems           = 500
+)
+
+// customerDigestOpenKey holds the ID of a customer's open digest on a channel
+func customerDigestOpenKey(channel models.NotificationType, customerID string) string {
+	return "{customer-digests}:open:" + string(channel) + ":" + customerID
+}
+
+// customerDigestItemsKey lists the notifications held for a customer's open digest on a
+// channel, oldest first
+func customerDigestItemsKey(channel models.NotificationType, customerID string) string {
+	return "{customer-digests}:items:" + string(channel) + ":" + customerID
+}
+
+// customerDigestSendingKey lists the notifications of a customer's digest being sent on
+// a channel, until their statuses are recorded
+func customerDigestSendingKey(channel models.NotificationType, customerID string) string {
+	return "{customer-digests}:sending:" + string(channel) + ":" + customerID
+}
+
+// customerDigestLeaseKey holds the ID of the digest being sent and when its lease ends
+func customerDigestLeaseKey(channel models.NotificationType, customerID string) string {
+	return "{customer-digests}:lease:" + string(channel) + ":" + customerID
+}
+
+// customerDigestMember names a customer's digest on a channel in customerDigestsDueKey
+func customerDigestMember(channel models.NotificationType, customerID string) string {
+	return string(channel) + ":" + customerID
+}
+
+// holdDigestScript adds a notification to the customer's open digest on a channel,
+// opening one due at ARGV[3] when there is none, and makes the digest due at once
+// when it reaches ARGV[4] notifications. A digest already due sooner, such as one whose
+// sender stopped, keeps its time. It returns the digest's ID and size.
+var holdDigestScript = redis.NewScript(`
+local id = redis.call('GET', KEYS[1])
+if not id then
+	id = ARGV[1]
+	redis.call('SET', KEYS[1], id)
+	local due = redis.call('ZSCORE', KEYS[2], ARGV[6])
+	if not due or tonumber(due) > tonumber(ARGV[3]) then
+		redis.call('ZADD', KEYS[2], ARGV[3], ARGV[6])
+	end
+end
+local count = redis.call('RPUSH', KEYS[3], ARGV[2])
+if count >= tonumber(ARGV[4]) then
+	redis.call('ZADD', KEYS[2], ARGV[5], ARGV[6])
+end
+return {id, count}
+`)
+
+// takeDigestScript leases the customer's digest on a channel to the caller and returns
+// its ID and notifications, or nothing when there is none or another replica holds it.
+// A digest whose sender stopped before finishing is handed out again once its lease
+// ends; otherwise the open digest is closed and its notifications moved to the sending
+// list. The digest is due again when the lease ends, so it is retried should the caller
+// stop too.
+var takeDigestScript = redis.NewScript(`
+local now = tonumber(ARGV[2])
+local leaseEnd = now + tonumber(ARGV[3])
+local lease = redis.call('HMGET', KEYS[5], 'id', 'until')
+if lease[1] then
+	if tonumber(lease[2]) > now then
+		return false
+	end
+	redis.call('HSET', KEYS[5], 'until', leaseEnd)
+	redis.call('ZADD', KEYS[2], leaseEnd, ARGV[1])
+	return {lease[1], redis.call('LRANGE', KEYS[4], 0, -1)}
+end
+redis.call('ZREM', KEYS[2], ARGV[1])
+local id = redis.call('GET', KEYS[1])
+if not id then
+	return false
+end
+redis.call('DEL', KEYS[1])
+redis.call('DEL', KEYS[4])
+if redis.call('EXISTS', KEYS[3]) == 1 then
+	redis.call('RENAME', KEYS[3], KEYS[4])
+end
+redis.call('HSET', KEYS[5], 'id', id, 'until', leaseEnd)
+redis.call('ZADD', KEYS[2], leaseEnd, ARGV[1])
+return {id, redis.call('LRANGE', KEYS[4], 0, -1)}
+`)
+
+// finishDigestScript drops a sent digest's lease and sending list, unless another
+// replica took the digest over
+var finishDigestScript = redis.NewScript(`
+if redis.call('HGET', KEYS[1], 'id') ~= ARGV[1] then
+	return 0
+end
+redis.call('DEL', KEYS[1], KEYS[2])
+return 1
+`)
+
+// CustomerDigests batches customers' low-priority email and WebSocket notifications into
+// digests. A held notification is stored as batched; its customer's digest on the
+// channel is sent as one combined message when its interval ends or it reaches its size
+// limit, and the notifications in it are then recorded sent. Customers opt in or out
+// through their digest preferences, and CUSTOMER_DIGEST_ENABLED decides for those with
+// none. Every replica sends due digests; each is leased to one, and sent again by
+// another should that one stop before recording it.
+type CustomerDigests struct {
+	redis         *RedisClient
+	notifications NotificationManager
+	preferences   PreferenceEnforcer
+	hub           RealtimeHub
+	email         ChannelSender
+	enabled       bool
+	interval      time.Duration
+	maxItems      int
+}
+
+func NewCustomerDigests(cfg *config.Config, redis *RedisClient, notifications NotificationManager, preferences PreferenceEnforcer, hub RealtimeHub, email ChannelSender) *CustomerDigests {
+	return &CustomerDigests{
+		redis:         redis,
+		notifications: notifications,
+		preferences:   preferences,
+		hub:           hub,
+		email:         email,
+		enabled:       cfg.CustomerDigestEnabled,
+		interval:      time.Duration(min(max(cfg.CustomerDigestIntervalMinutes, 1), maxDigestIntervalMinutes)) * time.Minute,
+		maxItems:      min(max(cfg.CustomerDigestMaxItems, 1), maxDigestItems),
+	}
+}
+
+// Hold batches a new notification into its customer's digest when it is a pending,
+// low-priority email or WebSocket notification due now and the customer gets digests.
+// digest is the request's choice; the customer's digest preference, then
+// CUSTOMER_DIGEST_ENABLED, decide when it is nil. Failing to batch the notification
+// only sends it on its own.
+func (d *CustomerDigests) Hold(ctx context.Context, notification *models.Notification, digest *bool) bool {
+	if notification.Status != models.NotificationStatusPending || notification.Priority != models.PriorityLow ||
+		notification.CustomerID == "" || (digest != nil && !*digest) {
+		return false
+	}
+	if notification.Type != models.NotificationTypeEmail && notification.Type != models.NotificationTypeWebSocket {
+		return false
+	}
+	if notification.ScheduledAt != nil && notification.ScheduledAt.After(time.Now()) {
+		return false
+	}
+
+	enabled, interval, maxItems := d.enabled, d.interval, d.maxItems
+	preferences, err := d.preferences.Preferences(ctx, notification.CustomerID)
+	if err != nil && !errors.Is(err, ErrPreferencesNotFound) {
+		slog.WarnContext(ctx, "Sending notification without digest", "notification.id", notification.ID, "customer.id", notification.CustomerID, "error", err)
+		return false
+	}
+	if preferences != nil && preferences.Digest != nil {
+		enabled = preferences.Digest.Enabled
+		if preferences.Digest.IntervalMinutes > 0 {
+			interval = time.Duration(preferences.Digest.IntervalMinutes) * time.Minute
+		}
+		if preferences.Digest.MaxItems > 0 {
+			maxItems = preferences.Digest.MaxItems
+		}
+	}
+	if digest != nil {
+		enabled = *digest
+	}
+	if !enabled {
+		return false
+	}
+
+	now := time.Now().UTC()
+	result, err := holdDigestScript.Run(ctx, d.redis.client,
+		[]string{
+			customerDigestOpenKey(notification.Type, notification.CustomerID),
+			customerDigestsDueKey,
+			customerDigestItemsKey(notification.Type, notification.CustomerID),
+		},
+		NewID(), notification.ID, now.Add(interval).UnixMilli(), maxItems, now.UnixMilli(),
+		customerDigestMember(notification.Type, notification.CustomerID),
+	).Slice()
+	if err != nil || len(result) != 2 {
+		slog.WarnContext(ctx, "Sending notification without digest", "notification.id", notification.ID, "customer.id", notification.CustomerID, "error", err)
+		return false
+	}
+	notification.Status = models.NotificationStatusBatched
+	notification.DigestID, _ = result[0].(string)
+	telemetry.RecordNotificationBatched(ctx, string(notification.Type))
+	return true
+}
+
+// Pending returns a customer's open digests, with when each is due
+func (d *CustomerDigests) Pending(ctx context.Context, customerID string) ([]*models.CustomerDigest, error) {
+	var digests []*models.CustomerDigest
+	for _, channel := range []models.NotificationType{models.NotificationTypeEmail, models.NotificationTypeWebSocket} {
+		id, err := d.redis.client.Get(ctx, customerDigestOpenKey(channel, customerID)).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load customer digest: %w", err)
+		}
+		ids, err := d.redis.client.LRange(ctx, customerDigestItemsKey(channel, customerID), 0, -1).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load customer digest: %w", err)
+		}
+		digest := &models.CustomerDigest{ID: id, CustomerID: customerID, Channel: channel, NotificationIDs: ids}
+		if due, err := d.redis.client.ZScore(ctx, customerDigestsDueKey, customerDigestMember(channel, customerID)).Result(); err == nil {
+			at := time.UnixMilli(int64(due)).UTC()
+			digest.DueAt = &at
+		}
+		digests = append(digests, digest)
+	}
+	return digests, nil
+}
+
+// Flush sends a customer's open digests now, returning those that had notifications
+func (d *CustomerDigests) Flush(ctx context.Context, customerID string) ([]*models.CustomerDigest, error) {
+	var digests []*models.CustomerDigest
+	for _, channel := range []models.NotificationType{models.NotificationTypeEmail, models.NotificationTypeWebSocket} {
+		digest, err := d.send(ctx, channel, customerID, false)
+		if err != nil {
+			return digests, err
+		}
+		if digest != nil {
+			digests = append(digests, digest)
+		}
+	}
+	return digests, nil
+}
+
+// Start sends due digests until ctx is cancelled
+func (d *CustomerDigests) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(customerDigestPoll)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if !d.redis.ReadOnly().IsReadOnly(ctx) {
+					d.sendDue(ctx)
+				}
+			}
+		}
+	}()
+}
+
+func (d *CustomerDigests) sendDue(ctx context.Context) {
+	members, err := d.redis.client.ZRangeByScore(ctx, customerDigestsDueKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Count: customerDigestBatch,
+	}).Result()
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to load due customer digests", "error", err)
+		return
+	}
+	for _, member := range members {
+		channel, customerID, _ := strings.Cut(member, ":")
+		if _, err := d.send(ctx, models.NotificationType(channel), customerID, true); err != nil {
+			slog.WarnContext(ctx, "Failed to send customer digest", "customer.id", customerID, "notification.channel", channel, "error", err)
+		}
+	}
+}
+
+// send takes a customer's open digest on a channel and delivers the notifications in it
+// that are still batched, recording each one sent, or failed with the delivery error.
+// The customer's preferences are checked for the digest as a whole: one due in quiet
+// hours waits for them to end when scheduled is set, and one on a channel the customer
+// has turned off since is suppressed. It returns nil when no digest was sent.
+func (d *CustomerDigests) send(ctx context.Context, channel models.NotificationType, customerID string, scheduled bool) (*models.CustomerDigest, error) {
+	member := customerDigestMember(channel, customerID)
+	decision := d.preferences.Check(ctx, &models.Notification{
+		Type:       channel,
+		CustomerID: customerID,
+		Priority:   models.PriorityLow,
+	}, time.Now().UTC())
+	if scheduled && decision.Action == models.PreferenceActionDefer && decision.Until != nil {
+		err := d.redis.client.ZAdd(ctx, customerDigestsDueKey, &redis.Z{Score: float64(decision.Until.UnixMilli()), Member: member}).Err()
+		if err != nil {
+			return nil, fmt.Errorf("failed to hold customer digest for quiet hours: %w", err)
+		}
+		telemetry.RecordNotificationDeferred(ctx, string(channel))
+		return nil, nil
+	}
+
+	result, err := takeDigestScript.Run(ctx, d.redis.client,
+		[]string{
+			customerDigestOpenKey(channel, customerID),
+			customerDigestsDueKey,
+			customerDigestItemsKey(channel, customerID),
+			customerDigestSendingKey(channel, customerID),
+			customerDigestLeaseKey(channel, customerID),
+		},
+		member, time.Now().UnixMilli(), customerDigestLease.Milliseconds(),
+	).Slice()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to take customer digest: %w", err)
+	}
+	if len(result) != 2 {
+		return nil, fmt.Errorf("failed to take customer digest: unexpected reply %v", result)
+	}
+	digest := &models.CustomerDigest{CustomerID: customerID, Channel: channel}
+	digest.ID, _ = result[0].(string)
+	ids, _ := result[1].([]interface{})
+
+	var held []*models.Notification
+	for _, value := range ids {
+		id, _ := value.(string)
+		notification, err := d.notifications.GetNotification(ctx, id)
+		if errors.Is(err, ErrNotificationNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Cancelled since it was batched, or recorded before an earlier sender stopped
+		if notification.Status != models.NotificationStatusBatched {
+			continue
+		}
+		held = append(held, notification)
+		digest.NotificationIDs = append(digest.NotificationIDs, notification.ID)
+	}
+	if len(held) == 0 {
+		return nil, d.finish(ctx, channel, customerID, digest.ID)
+	}
+
+	var sendErr error
+	req := models.UpdateNotificationStatusRequest{Status: models.NotificationStatusSent}
+	switch {
+	case decision.Action == models.PreferenceActionSuppress:
+		digest.Error = "suppressed by customer preferences: " + decision.Reason
+		req = models.UpdateNotificationStatusRequest{
+			Status:       models.NotificationStatusSuppressed,
+			ErrorMessage: digest.Error,
+		}
+		telemetry.RecordNotificationSuppressed(ctx, string(channel), decision.Reason)
+	case channel == models.NotificationTypeWebSocket:
+		sendErr = d.hub.SendToCustomer(ctx, customerID, digestWebSocketMessage(digest.ID, held))
+	default:
+		sendErr = d.email.Send(ctx, digestEmail(digest.ID, held))
+	}
+	if sendErr != nil {
+		digest.Error = sendErr.Error()
+		req = models.UpdateNotificationStatusRequest{
+			Status:       models.NotificationStatusFailed,
+			ErrorMessage: "digest failed: " + sendErr.Error(),
+			ErrorClass:   ClassifyError(sendErr),
+		}
+	} else if digest.Error == "" {
+		sentAt := time.Now().UTC()
+		digest.SentAt = &sentAt
+	}
+	for _, notification := range held {
+		if _, err := d.notifications.UpdateNotificationStatus(ctx, notification.ID, req); err != nil {
+			slog.WarnContext(ctx, "Failed to record digest of notification", "notification.id", notification.ID, "digest.id", digest.ID, "error", err)
+		}
+	}
+
+	if err := d.finish(ctx, channel, customerID, digest.ID); err != nil {
+		slog.WarnContext(ctx, "Failed to finish customer digest", "digest.id", digest.ID, "error", err)
+	}
+
+	telemetry.RecordCustomerDigest(ctx, string(channel), digest.SentAt != nil, len(held))
+	slog.InfoContext(ctx, "📬 Customer digest flushed", "digest.id", digest.ID, "customer.id", customerID,
+		"notification.channel", channel, "digest.size", len(held), "error", sendErr)
+	return digest, nil
+}
+
+// finish drops a sent digest's lease and notifications
+func (d *CustomerDigests) finish(ctx context.Context, channel models.NotificationType, customerID, id string) error {
+	err := finishDigestScript.Run(ctx, d.redis.client,
+		[]string{customerDigestLeaseKey(channel, customerID), customerDigestSendingKey(channel, customerID)}, id).Err()
+	if err != nil {
+		return fmt.Errorf("failed to finish customer digest: %w", err)
+	}
+	return nil
+}
+
+// digestEmail combines a digest's notifications into one email to the recipient of the
+// newest, listing each subject and message oldest first
+func digestEmail(id string, held []*models.Notification) *models.Notification {
+	newest := held[len(held)-1]
+	var text, body strings.Builder
+	body.WriteString("<html><body>")
+	for _, notification := range held {
+		fmt.Fprintf(&text, "%s\n%s\n\n", notification.Subject, notification.Message)
+		fmt.Fprintf(&body, "<h3>%s</h3><p>%s</p>", html.EscapeString(notification.Subject), html.EscapeString(notification.Message))
+	}
+	body.WriteString("</body></html>")
+
+	subject := "1 update"
+	if len(held) > 1 {
+		subject = fmt.Sprintf("%d updates", len(held))
+	}
+	return &models.Notification{
+		ID:          id,
+		Type:        models.NotificationTypeEmail,
+		Recipient:   newest.Recipient,
+		CustomerID:  newest.CustomerID,
+		Subject:     subject,
+		Message:     strings.TrimSpace(text.String()),
+		HTMLMessage: body.String(),
+		Status:      models.NotificationStatusPending,
+		Priority:    models.PriorityLow,
+		CreatedAt:   time.Now().UTC(),
+		Metadata:    map[string]interface{}{"digest_size": len(held)},
+	}
+}
+
+// digestWebSocketMessage combines a digest's notifications into one WebSocket message
+func digestWebSocketMessage(id string, held []*models.Notification) models.WebSocketMessage {
+	items := make([]map[string]interface{}, len(held))
+	for i, notification := range held {
+		items[i] = map[string]interface{}{
+			"id":        notification.ID,
+			"subject":   notification.Subject,
+			"message":   notification.Message,
+			"data":      notification.Data,
+			"createdAt": notification.CreatedAt,
+		}
+	}
+	return models.WebSocketMessage{
+		ID:   id,
+		Type: "digest",
+		Data: map[string]interface{}{
+			"digestId":      id,
+			"count":         len(held),
+			"notifications": items,
+		},
+		Timestamp: time.Now().UTC(),
+	}
+}
@@ -0,0 +1,486 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// deadLetterStream holds one entry per dead-lettered notification, oldest first
+	deadLetterStream = "notifications-dlq"
+	// deadLetterClaimTTL bounds how long a replica that stopped mid-re-drive keeps the
+	// letter from the others; it outlasts a re-send through the channel's retry policy
+	deadLetterClaimTTL = 5 * time.Minute
+)
+
+// deadLetterClaimKey holds the claim of the replica re-driving a dead letter
+func deadLetterClaimKey(id string) string {
+	return "dead-letter-redrive:" + id
+}
+
+var (
+	ErrDeadLetterNotFound = errors.New("dead letter not found")
+	ErrRedriveFailed      = errors.New("re-drive failed")
+	ErrInvalidRedrive     = errors.New("invalid re-drive")
+	ErrRedriveSuppressed  = errors.New("re-drive held back by customer preferences")
+	ErrRedriveInProgress  = errors.New("dead letter is being re-driven")
+)
+
+// DeadLetterQueue keeps notifications whose delivery failed for good in a Redis stream,
+// capped at DEAD_LETTER_MAX_ENTRIES, so they can be inspected and re-driven instead of
+// being lost. The letters of tenants pinned to a data region are kept whole in the same
+// stream in their region's Redis; the home stream holds their IDs only, and points to
+// the regional entry.
+type DeadLetterQueue struct {
+	redis     *RedisClient
+	residency *DataResidency
+	maxLen    int64
+	senders   map[models.NotificationType]ChannelSender
+
+	preferences *CustomerPreferenceService
+	blackouts   *BlackoutCalendars
+	screening   ContentScreener
+}
+
+func NewDeadLetterQueue(cfg *config.Config, redis *RedisClient, senders map[models.NotificationType]ChannelSender, preferences *CustomerPreferenceService, blackouts *BlackoutCalendars, residency *DataResidency, screening ContentScreener) *DeadLetterQueue {
+	maxLen := int64(cfg.DeadLetterMaxEntries)
+	if maxLen <= 0 {
+		maxLen = 10000
+	}
+	return &DeadLetterQueue{redis: redis, residency: residency, maxLen: maxLen, senders: senders, preferences: preferences, blackouts: blackouts, screening: screening}
+}
+
+// Capture dead-letters a notification with the error chain of its last failure and the
+// delivery attempts made. attempts of 0 counts the notification's own retries.
+func (q *DeadLetterQueue) Capture(ctx context.Context, notification *models.Notification, reason string, deliveryErr error, attempts int) (*models.DeadLetter, error) {
+	if attempts <= 0 {
+		attempts = notification.RetryCount + 1
+	}
+	letter := &models.DeadLetter{
+		NotificationID: notification.ID,
+		Channel:        notification.Type,
+		Reason:         reason,
+		Errors:         errorChain(deliveryErr),
+		Attempts:       attempts,
+		DeadLetteredAt: time.Now().UTC(),
+		Notification:   notification,
+		Region:         notification.Region,
+	}
+	if letter.Region == "" {
+		letter.Region = q.residency.Region(notification)
+	}
+	if deliveryErr != nil {
+		letter.ErrorClass = ClassifyError(deliveryErr)
+	}
+
+	if err := q.store(ctx, letter, nil); err != nil {
+		return nil, fmt.Errorf("failed to dead-letter notification %s: %w", notification.ID, err)
+	}
+
+	trace.SpanFromContext(ctx).AddEvent("notification.dead_lettered", trace.WithAttributes(append([]attribute.KeyValue{
+		attribute.String("notification.id", notification.ID),
+		attribute.String("notification.channel", string(letter.Channel)),
+		attribute.String("dead_letter.reason", reason),
+		attribute.String("dead_letter.id", letter.ID),
+	}, regionAttributes(notification)...)...))
+	telemetry.RecordDeadLetter(ctx, string(letter.Channel), reason, string(letter.ErrorClass), letter.Region)
+	slog.WarnContext(ctx, "☠️ Dead-lettered notification", "notification.id", notification.ID, "notification.channel", letter.Channel, "dead_letter.reason", reason, "retry.count", attempts)
+	return letter, nil
+}
+
+// storedLetter is a dead letter with where its regional entry is, if it has one
+type storedLetter struct {
+	*models.DeadLetter
+	regionalID string
+}
+
+// store appends a dead letter to the stream and sets its ID. A regional notification's
+// letter is added whole to its region's stream first, and by its IDs to the home one.
+// replaces is removed in the same transaction as the home entry is added.
+func (q *DeadLetterQueue) store(ctx context.Context, letter *models.DeadLetter, replaces *storedLetter) error {
+	values := map[string]interface{}{
+		"notification_id": letter.NotificationID,
+		"channel":         string(letter.Channel),
+	}
+	home := letter
+	if q.residency.Regional(letter.Region) {
+		client, err := q.residency.client(letter.Region)
+		if err != nil {
+			return err
+		}
+		payload, err := json.Marshal(letter)
+		if err != nil {
+			return fmt.Errorf("failed to marshal dead letter: %w", err)
+		}
+		regionalID, err := q.add(ctx, client, payload, values).Result()
+		if err != nil {
+			return fmt.Errorf("failed to store dead letter in region %s: %w", letter.Region, err)
+		}
+		stub := *letter
+		stub.Errors = nil
+		stub.Notification = nil
+		home = &stub
+		values["region"] = letter.Region
+		values["regional_id"] = regionalID
+	}
+	payload, err := json.Marshal(home)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead letter: %w", err)
+	}
+
+	var add *redis.StringCmd
+	if _, err := q.redis.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		add = q.add(ctx, pipe, payload, values)
+		if replaces != nil {
+			pipe.XDel(ctx, deadLetterStream, replaces.ID)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	letter.ID = add.Val()
+	if replaces != nil {
+		q.removeRegional(ctx, replaces)
+	}
+	return nil
+}
+
+// add appends a dead letter's payload with its stream values; the command's result is
+// the entry's ID
+func (q *DeadLetterQueue) add(ctx context.Context, client redis.Cmdable, payload []byte, values map[string]interface{}) *redis.StringCmd {
+	entry := map[string]interface{}{"letter": payload}
+	for name, value := range values {
+		entry[name] = value
+	}
+	return client.XAdd(ctx, &redis.XAddArgs{
+		Stream:       deadLetterStream,
+		MaxLenApprox: q.maxLen,
+		Values:       entry,
+	})
+}
+
+// removeRegional drops a letter's regional entry; a failure leaves it to be trimmed
+func (q *DeadLetterQueue) removeRegional(ctx context.Context, letter *storedLetter) {
+	if letter.regionalID == "" {
+		return
+	}
+	client, err := q.residency.client(letter.Region)
+	if err == nil {
+		err = client.XDel(ctx, deadLetterStream, letter.regionalID).Err()
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to remove regional dead letter", "dead_letter.id", letter.ID, "data.region", letter.Region, "error", err)
+	}
+}
+
+// List pages through dead letters newest first. The cursor is the ID of the last letter
+// on the previous page; the returned cursor is empty on the last page.
+func (q *DeadLetterQueue) List(ctx context.Context, cursor string, limit int) ([]*models.DeadLetter, string, error) {
+	start := "+"
+	if cursor != "" {
+		start = "(" + cursor
+	}
+	entries, err := q.redis.client.XRevRangeN(ctx, deadLetterStream, start, "-", int64(limit)+1).Result()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list dead letters: %w", err)
+	}
+
+	next := ""
+	if len(entries) > limit {
+		entries = entries[:limit]
+		next = entries[limit-1].ID
+	}
+	stored := make([]*storedLetter, 0, len(entries))
+	for _, entry := range entries {
+		letter, err := decodeDeadLetter(entry)
+		if err != nil {
+			slog.WarnContext(ctx, "Skipping unreadable dead letter", "dead_letter.id", entry.ID, "error", err)
+			continue
+		}
+		stored = append(stored, letter)
+	}
+	q.hydrate(ctx, stored)
+	letters := make([]*models.DeadLetter, len(stored))
+	for i, letter := range stored {
+		letters[i] = letter.DeadLetter
+	}
+	return letters, next, nil
+}
+
+// Count returns how many notifications are dead-lettered
+func (q *DeadLetterQueue) Count(ctx context.Context) (int64, error) {
+	return q.redis.client.XLen(ctx, deadLetterStream).Result()
+}
+
+// Oldest returns when the oldest dead letter still queued was captured, or the zero time
+// when the queue is empty
+func (q *DeadLetterQueue) Oldest(ctx context.Context) (time.Time, error) {
+	entries, err := q.redis.client.XRangeN(ctx, deadLetterStream, "-", "+", 1).Result()
+	if err != nil || len(entries) == 0 {
+		return time.Time{}, err
+	}
+	millis, _, _ := strings.Cut(entries[0].ID, "-")
+	ms, err := strconv.ParseInt(millis, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("malformed dead letter ID %q: %w", entries[0].ID, err)
+	}
+	return time.UnixMilli(ms).UTC(), nil
+}
+
+// Get returns a dead letter by its stream ID
+func (q *DeadLetterQueue) Get(ctx context.Context, id string) (*models.DeadLetter, error) {
+	letter, err := q.load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return letter.DeadLetter, nil
+}
+
+// load reads a dead letter with its regional entry
+func (q *DeadLetterQueue) load(ctx context.Context, id string) (*storedLetter, error) {
+	entries, err := q.redis.client.XRangeN(ctx, deadLetterStream, id, id, 1).Result()
+	if err != nil {
+		// Redis rejects malformed stream IDs, which can't match a letter either
+		var redisErr redis.Error
+		if errors.As(err, &redisErr) {
+			return nil, ErrDeadLetterNotFound
+		}
+		return nil, fmt.Errorf("failed to load dead letter: %w", err)
+	}
+	if len(entries) == 0 {
+		return nil, ErrDeadLetterNotFound
+	}
+	letter, err := decodeDeadLetter(entries[0])
+	if err != nil {
+		return nil, err
+	}
+	q.hydrate(ctx, []*storedLetter{letter})
+	return letter, nil
+}
+
+// hydrate fills regional letters in with the errors and notification kept in their
+// region. Letters whose region can't be read keep their IDs only.
+func (q *DeadLetterQueue) hydrate(ctx context.Context, letters []*storedLetter) {
+	byRegion := make(map[string][]*storedLetter)
+	for _, letter := range letters {
+		if letter.regionalID != "" {
+			byRegion[letter.Region] = append(byRegion[letter.Region], letter)
+		}
+	}
+	for region, regional := range byRegion {
+		client, err := q.residency.client(region)
+		if err != nil {
+			slog.WarnContext(ctx, "Dead letters' region unavailable", "data.region", region, "error", err)
+			continue
+		}
+		cmds := make([]*redis.XMessageSliceCmd, len(regional))
+		if _, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, letter := range regional {
+				cmds[i] = pipe.XRangeN(ctx, deadLetterStream, letter.regionalID, letter.regionalID, 1)
+			}
+			return nil
+		}); err != nil {
+			slog.WarnContext(ctx, "Failed to load regional dead letters", "data.region", region, "error", err)
+			continue
+		}
+		for i, letter := range regional {
+			entries := cmds[i].Val()
+			if len(entries) == 0 {
+				continue
+			}
+			full, err := decodeDeadLetter(entries[0])
+			if err != nil {
+				slog.WarnContext(ctx, "Skipping unreadable regional dead letter", "dead_letter.id", letter.ID, "data.region", region, "error", err)
+				continue
+			}
+			letter.Errors = full.Errors
+			letter.Notification = full.Notification
+		}
+	}
+}
+
+// Discard drops a dead letter without re-sending it
+func (q *DeadLetterQueue) Discard(ctx context.Context, id string) error {
+	letter, err := q.load(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := q.redis.client.XDel(ctx, deadLetterStream, id).Err(); err != nil {
+		return err
+	}
+	q.removeRegional(ctx, letter)
+	return nil
+}
+
+// Redrive re-sends a dead-lettered notification on its channel, or on channel when given.
+// On success the letter leaves the queue and the re-sent notification is returned. On
+// failure it is dead-lettered again with the new error chain and a higher redrive count,
+// and the error wraps ErrRedriveFailed. The letter is claimed first, so two calls on any
+// replicas can't both re-send it; the one that loses the claim gets ErrRedriveInProgress,
+// and one claiming it after the other re-sent it finds it gone.
+func (q *DeadLetterQueue) Redrive(ctx context.Context, id string, channel models.NotificationType) (*models.DeadLetter, error) {
+	claim := NewID()
+	claimed, err := q.redis.client.SetNX(ctx, deadLetterClaimKey(id), claim, deadLetterClaimTTL).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim dead letter: %w", err)
+	}
+	if !claimed {
+		return nil, ErrRedriveInProgress
+	}
+	defer func() {
+		if err := q.release(context.WithoutCancel(ctx), id, claim); err != nil {
+			slog.WarnContext(ctx, "Failed to release dead letter claim", "dead_letter.id", id, "error", err)
+		}
+	}()
+
+	stored, err := q.load(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	letter := stored.DeadLetter
+	if letter.Notification == nil {
+		return nil, fmt.Errorf("%w: dead letter %s has no notification", ErrInvalidRedrive, id)
+	}
+	if channel == "" {
+		channel = letter.Channel
+	}
+	sender, ok := q.senders[channel]
+	if !ok {
+		return nil, fmt.Errorf("%w: notifications can't be re-sent on channel %q", ErrInvalidRedrive, channel)
+	}
+
+	notification := *letter.Notification
+	notification.Type = channel
+	notification.Status = models.NotificationStatusPending
+	notification.FailedAt = nil
+	notification.ErrorMessage = ""
+	notification.RetryCount = 0
+
+	// The customer may have turned the channel off, or be in quiet hours, or their tenant
+	// in a blackout, since the notification failed; the letter stays for a later re-drive
+	now := time.Now().UTC()
+	decision := q.preferences.Check(ctx, &notification, now)
+	switch decision.Action {
+	case models.PreferenceActionSuppress:
+		return nil, fmt.Errorf("%w: %s", ErrRedriveSuppressed, decision.Reason)
+	case models.PreferenceActionDefer:
+		return nil, fmt.Errorf("%w: %s until %s", ErrRedriveSuppressed, decision.Reason, decision.Until.Format(time.RFC3339))
+	}
+	if until := q.blackouts.Hold(ctx, &notification, now); until != nil {
+		return nil, fmt.Errorf("%w: blackout window %v until %s", ErrRedriveSuppressed, notification.Metadata[BlackoutWindowMetadata], until.Format(time.RFC3339))
+	}
+	// Content the screener blocks is never sent, even if it was let through before
+	if err := ScreenNotification(ctx, q.screening, &notification); err != nil {
+		return nil, err
+	}
+
+	sendErr := sender.Send(ctx, &notification)
+	telemetry.RecordDeadLetterRedrive(ctx, string(channel), sendErr == nil)
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(
+		attribute.String("dead_letter.id", id),
+		attribute.String("notification.channel", string(channel)),
+		attribute.Bool("dead_letter.redriven", sendErr == nil),
+	)
+	span.SetAttributes(regionAttributes(&notification)...)
+
+	if sendErr == nil {
+		if err := q.redis.client.XDel(ctx, deadLetterStream, id).Err(); err != nil {
+			slog.WarnContext(ctx, "Re-drove notification but failed to remove dead letter", "notification.id", notification.ID, "dead_letter.id", id, "error", err)
+		}
+		q.removeRegional(ctx, stored)
+		letter.Channel = channel
+		letter.Notification = &notification
+		slog.InfoContext(ctx, "♻️ Re-drove dead-lettered notification", "notification.id", notification.ID, "notification.channel", channel)
+		return letter, nil
+	}
+
+	retried := &models.DeadLetter{
+		NotificationID: letter.NotificationID,
+		Channel:        channel,
+		Reason:         letter.Reason,
+		ErrorClass:     ClassifyError(sendErr),
+		Errors:         errorChain(sendErr),
+		Attempts:       letter.Attempts + notification.RetryCount + 1,
+		RedriveCount:   letter.RedriveCount + 1,
+		DeadLetteredAt: time.Now().UTC(),
+		Notification:   &notification,
+		Region:         letter.Region,
+	}
+	if err := q.store(ctx, retried, stored); err != nil {
+		return nil, fmt.Errorf("%w: %v; and failed to dead-letter it again: %v", ErrRedriveFailed, sendErr, err)
+	}
+	return retried, fmt.Errorf("%w: %v", ErrRedriveFailed, sendErr)
+}
+
+// release gives up a re-drive claim, unless it has expired and another replica holds it now
+func (q *DeadLetterQueue) release(ctx context.Context, id, claim string) error {
+	key := deadLetterClaimKey(id)
+	return watchKey(ctx, q.redis.client, key, func(tx *redis.Tx) error {
+		holder, err := tx.Get(ctx, key).Result()
+		if errors.Is(err, redis.Nil) {
+			return nil
+		}
+		if err != nil || holder != claim {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			return nil
+		})
+		return err
+	})
+}
+
+func decodeDeadLetter(entry redis.XMessage) (*storedLetter, error) {
+	payload, ok := entry.Values["letter"].(string)
+	if !ok {
+		return nil, fmt.Errorf("dead letter %s has no payload", entry.ID)
+	}
+	var letter models.DeadLetter
+	if err := json.Unmarshal([]byte(payload), &letter); err != nil {
+		return nil, err
+	}
+	letter.ID = entry.ID
+	regionalID, _ := entry.Values["regional_id"].(string)
+	return &storedLetter{DeadLetter: &letter, regionalID: regionalID}, nil
+}
+
+// errorChain lists an error and everything it wraps, outermost first, following both
+// single and joined wrapping
+func errorChain(err error) []string {
+	chain := []string{}
+	var walk func(error)
+	walk = func(err error) {
+		for err != nil {
+			chain = append(chain, err.Error())
+			switch wrapped := err.(type) {
+			case interface{ Unwrap() []error }:
+				for _, inner := range wrapped.Unwrap() {
+					walk(inner)
+				}
+				return
+			case interface{ Unwrap() error }:
+				err = wrapped.Unwrap()
+			default:
+				return
+			}
+		}
+	}
+	walk(err)
+	return chain
+}
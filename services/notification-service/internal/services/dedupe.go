@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// contentDedupeKey holds the notification first created with a content hash in the window
+func contentDedupeKey(hash string) string {
+	return "dedupe:" + hash
+}
+
+// ContentDeduper catches the same message going to the same customer twice, as when a
+// marketing blast is started again. A notification's content hash is claimed in Redis
+// for DEDUPE_WINDOW_SECONDS; a later notification with the same hash is a duplicate of
+// the one holding the claim. Unlike idempotency keys, this needs nothing from the caller.
+type ContentDeduper struct {
+	redis   *RedisClient
+	enabled bool
+	window  time.Duration
+}
+
+func NewContentDeduper(cfg *config.Config, redis *RedisClient) *ContentDeduper {
+	window := time.Duration(cfg.DedupeWindowSeconds) * time.Second
+	if window <= 0 {
+		window = time.Hour
+	}
+	return &ContentDeduper{redis: redis, enabled: cfg.DedupeEnabled, window: window}
+}
+
+// ContentHash hashes what makes two notifications the same send: the customer, or the
+// recipient when there is none, the channel, and the rendered subject and message
+func ContentHash(notification *models.Notification) string {
+	customer := notification.CustomerID
+	if customer == "" {
+		customer = notification.Recipient
+	}
+	sum := sha256.Sum256([]byte(strings.Join([]string{customer, string(notification.Type), notification.Subject, notification.Message}, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// Claim claims a notification's content hash when dedupe applies to it: dedupe is the
+// request's choice, and DEDUPE_ENABLED decides when it is nil. It returns the ID of the
+// notification already holding the hash, or whether this one now holds it.
+func (d *ContentDeduper) Claim(ctx context.Context, notification *models.Notification, dedupe *bool) (string, bool, error) {
+	if (dedupe == nil && !d.enabled) || (dedupe != nil && !*dedupe) {
+		return "", false, nil
+	}
+	key := contentDedupeKey(ContentHash(notification))
+
+	// A claim can expire between SETNX and GET; the second round then claims the hash
+	for attempt := 0; attempt < 2; attempt++ {
+		claimed, err := d.redis.client.SetNX(ctx, key, notification.ID, d.window).Result()
+		if err != nil {
+			return "", false, fmt.Errorf("failed to claim content hash: %w", err)
+		}
+		if claimed {
+			return "", true, nil
+		}
+
+		original, err := d.redis.client.Get(ctx, key).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return "", false, fmt.Errorf("failed to read content hash: %w", err)
+		}
+		return original, false, nil
+	}
+	return "", false, fmt.Errorf("failed to claim content hash of %s", notification.ID)
+}
+
+// Release gives up the claim of a notification that was never stored, so its content
+// isn't held against the next attempt. A claim held by another notification is left alone.
+func (d *ContentDeduper) Release(ctx context.Context, notification *models.Notification) error {
+	key := contentDedupeKey(ContentHash(notification))
+	return watchKey(ctx, d.redis.client, key, func(tx *redis.Tx) error {
+		holder, err := tx.Get(ctx, key).Result()
+		if errors.Is(err, redis.Nil) {
+			return nil
+		}
+		if err != nil || holder != notification.ID {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			return nil
+		})
+		return err
+	})
+}
+
+// Takeover moves the claim on a notification's content hash from original, one that
+// ended failed, to the notification, so a resubmission of a failed send isn't
+// suppressed as its duplicate. It reports false when original no longer holds the hash.
+func (d *ContentDeduper) Takeover(ctx context.Context, notification *models.Notification, original string) (bool, error) {
+	key := contentDedupeKey(ContentHash(notification))
+	claimed := false
+	err := watchKey(ctx, d.redis.client, key, func(tx *redis.Tx) error {
+		holder, err := tx.Get(ctx, key).Result()
+		if errors.Is(err, redis.Nil) {
+			return nil
+		}
+		if err != nil || holder != original {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, notification.ID, d.window)
+			return nil
+		})
+		claimed = err == nil
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to take over content hash: %w", err)
+	}
+	return claimed, nil
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"notification-service/internal/cache"
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/storage"
+)
+
+var (
+	ErrInvalidTimeRange         = errors.New("invalid time range")
+	ErrDeliveryStatsUnavailable = errors.New("delivery statistics need the notification database")
+)
+
+// DefaultDeliveryStatsRange is the window delivery statistics cover when none is given
+const DefaultDeliveryStatsRange = "24h"
+
+// deliveryStatsRanges are the windows delivery statistics can cover
+var deliveryStatsRanges = map[string]time.Duration{
+	"1h":  time.Hour,
+	"24h": 24 * time.Hour,
+	"7d":  7 * 24 * time.Hour,
+}
+
+// DeliveryAnalytics computes delivery statistics from the stored notification records.
+// Each time range's aggregation is cached in Redis for DELIVERY_STATS_CACHE_TTL_SECONDS,
+// so dashboards polling the statistics on every replica don't each scan the table.
+type DeliveryAnalytics struct {
+	repo  storage.NotificationRepository
+	stats *cache.Cache[models.DeliveryStats]
+}
+
+// NewDeliveryAnalytics creates the delivery statistics; repo is nil when the service
+// runs on Redis alone
+func NewDeliveryAnalytics(cfg *config.Config, redisClient *RedisClient, repo storage.NotificationRepository) *DeliveryAnalytics {
+	ttl := time.Duration(max(cfg.DeliveryStatsCacheTTLSeconds, 1)) * time.Second
+	return &DeliveryAnalytics{
+		repo: repo,
+		stats: cache.New[models.DeliveryStats](redisClient.client, cache.Options{
+			Name:       "delivery-stats",
+			Mode:       cache.ReadThrough,
+			L1TTL:      min(ttl, 10*time.Second),
+			L1MaxItems: len(deliveryStatsRanges),
+			L2TTL:      ttl,
+		}),
+	}
+}
+
+// DeliveryStats returns the statistics of the notifications created in the last 1h, 24h
+// or 7d
+func (a *DeliveryAnalytics) DeliveryStats(ctx context.Context, timeRange string) (models.DeliveryStats, error) {
+	window, ok := deliveryStatsRanges[timeRange]
+	if !ok {
+		return models.DeliveryStats{}, fmt.Errorf("%w %q (expected 1h, 24h or 7d)", ErrInvalidTimeRange, timeRange)
+	}
+	if a.repo == nil {
+		return models.DeliveryStats{}, ErrDeliveryStatsUnavailable
+	}
+
+	return a.stats.Get(ctx, timeRange, func(ctx context.Context) (models.DeliveryStats, error) {
+		now := time.Now().UTC()
+		rollups, err := a.repo.DeliveryRollup(ctx, now.Add(-window))
+		if err != nil {
+			return models.DeliveryStats{}, err
+		}
+		stats := deliveryStats(rollups)
+		stats.TimeRange = timeRange
+		stats.ComputedAt = now
+		return stats, nil
+	})
+}
+
+// deliveryStats totals the rollups of finished notifications: sent counts those sent or
+// delivered, and the delivery rate is the share of them among sent and failed ones.
+// Notifications still pending, retrying, or cancelled, suppressed or blocked before
+// sending are left out.
+func deliveryStats(rollups []models.DeliveryRollup) models.DeliveryStats {
+	stats := models.DeliveryStats{
+		ByType:     make(map[models.NotificationType]models.TypeStats),
+		ByPriority: make(map[models.Priority]models.PriorityStats),
+	}
+	var timed int64
+	var seconds float64
+	priorityTimed := make(map[models.Priority]int64)
+	prioritySeconds := make(map[models.Priority]float64)
+
+	for _, rollup := range rollups {
+		var sent, delivered, failed int64
+		switch rollup.Status {
+		case models.NotificationStatusDelivered:
+			sent, delivered = rollup.Count, rollup.Count
+		case models.NotificationStatusSent:
+			sent = rollup.Count
+		case models.NotificationStatusFailed:
+			failed = rollup.Count
+		default:
+			continue
+		}
+
+		stats.TotalSent += sent
+		stats.TotalDelivered += delivered
+		stats.TotalFailed += failed
+
+		byType := stats.ByType[rollup.Type]
+		byType.Sent += sent
+		byType.Delivered += delivered
+		byType.Failed += failed
+		stats.ByType[rollup.Type] = byType
+
+		byPriority := stats.ByPriority[rollup.Priority]
+		byPriority.Sent += sent
+		byPriority.Delivered += delivered
+		byPriority.Failed += failed
+		stats.ByPriority[rollup.Priority] = byPriority
+
+		if sent > 0 {
+			timed += rollup.Timed
+			seconds += rollup.DeliverySeconds
+			priorityTimed[rollup.Priority] += rollup.Timed
+			prioritySeconds[rollup.Priority] += rollup.DeliverySeconds
+		}
+	}
+
+	stats.DeliveryRate = deliveryRate(stats.TotalSent, stats.TotalFailed)
+	if timed > 0 {
+		stats.AvgDeliveryTime = seconds / float64(timed)
+	}
+	for notificationType, byType := range stats.ByType {
+		byType.DeliveryRate = deliveryRate(byType.Sent, byType.Failed)
+		stats.ByType[notificationType] = byType
+	}
+	for priority, byPriority := range stats.ByPriority {
+		if priorityTimed[priority] > 0 {
+			byPriority.AvgTime = prioritySeconds[priority] / float64(priorityTimed[priority])
+		}
+		stats.ByPriority[priority] = byPriority
+	}
+	return stats
+}
+
+func deliveryRate(sent, failed int64) float64 {
+	if sent+failed == 0 {
+		return 0
+	}
+	return float64(sent) / float64(sent+failed)
+}
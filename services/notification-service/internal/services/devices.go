@@ -0,0 +1,385 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	ErrDeviceNotFound  = errors.New("device not found")
+	ErrInvalidTarget   = errors.New("invalid device target")
+	ErrNoTargetDevices = errors.New("no registered device matches the target")
+)
+
+// Redis keys of the device registry
+const (
+	// deviceLastSeenKey scores each customer's device by the unix time it was last seen,
+	// so stale devices are found without reading every customer
+	deviceLastSeenKey = "devices-last-seen"
+	// deviceCountsKey holds the number of registered devices by platform
+	deviceCountsKey = "device-counts"
+)
+
+// deviceMemberSeparator joins customer and device IDs in deviceLastSeenKey members
+const deviceMemberSeparator = "\x1f"
+
+// devicePruneBatch is how many stale devices one pass looks at
+const devicePruneBatch = 500
+
+// deviceCountInterval is how often the device count gauge is refreshed from Redis
+const deviceCountInterval = time.Minute
+
+// devicesKey holds a customer's devices by ID
+func devicesKey(customerID string) string {
+	return "devices:" + customerID
+}
+
+// deviceTokenKey holds the device a push token is registered to, as a deviceLastSeenKey
+// member, so a token moves with the app rather than reaching two customers. Tokens are
+// hashed so they aren't readable from key names.
+func deviceTokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "device-token:" + hex.EncodeToString(sum[:16])
+}
+
+// DeviceRegistry keeps each customer's push devices in Redis: platform, token, app
+// version, locale and when the app last registered. Push notifications with a target
+// are sent to the matching devices. Devices unseen for DEVICE_STALE_DAYS are pruned,
+// since their tokens have most likely been invalidated.
+type DeviceRegistry struct {
+	redis         *RedisClient
+	staleAfter    time.Duration
+	pruneInterval time.Duration
+}
+
+func NewDeviceRegistry(cfg *config.Config, redis *RedisClient) *DeviceRegistry {
+	pruneInterval := time.Duration(cfg.DevicePruneIntervalMinutes) * time.Minute
+	if pruneInterval <= 0 {
+		pruneInterval = time.Hour
+	}
+	return &DeviceRegistry{
+		redis:         redis,
+		staleAfter:    time.Duration(cfg.DeviceStaleDays) * 24 * time.Hour,
+		pruneInterval: pruneInterval,
+	}
+}
+
+// Start prunes stale devices and refreshes the device count gauge until ctx ends
+func (r *DeviceRegistry) Start(ctx context.Context) {
+	go func() {
+		prune := time.NewTicker(r.pruneInterval)
+		defer prune.Stop()
+		count := time.NewTicker(deviceCountInterval)
+		defer count.Stop()
+		r.refreshCounts(ctx)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-prune.C:
+				if _, err := r.Prune(ctx); err != nil {
+					slog.WarnContext(ctx, "Failed to prune stale devices", "error", err)
+				}
+			case <-count.C:
+				r.refreshCounts(ctx)
+			}
+		}
+	}()
+}
+
+// Register adds a device or refreshes it, moving its last seen time to now. Any other
+// device with the same token is dropped, the customer's own or another customer's, as
+// the app was reinstalled or another account signed in on the device.
+func (r *DeviceRegistry) Register(ctx context.Context, customerID, deviceID string, req models.RegisterDeviceRequest) (*models.Device, bool, error) {
+	now := time.Now().UTC()
+	device := &models.Device{
+		ID:         deviceID,
+		CustomerID: customerID,
+		Platform:   req.Platform,
+		Token:      req.Token,
+		AppVersion: req.AppVersion,
+		Locale:     req.Locale,
+		CreatedAt:  now,
+		LastSeenAt: now,
+	}
+	var created bool
+	key := devicesKey(customerID)
+	tokenKey := deviceTokenKey(device.Token)
+	err := watchKeys(ctx, r.redis.client, func(tx *redis.Tx) error {
+		devices, err := loadDevices(ctx, tx, customerID)
+		if err != nil {
+			return err
+		}
+		// The token key is watched, so the device it names can't move on meanwhile
+		var previous *models.Device
+		owner, err := tx.Get(ctx, tokenKey).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		if ownerID, ownerDevice, _ := strings.Cut(owner, deviceMemberSeparator); owner != "" && ownerID != customerID {
+			previous, err = loadDevice(ctx, tx, ownerID, ownerDevice)
+			if err != nil && !errors.Is(err, ErrDeviceNotFound) {
+				return err
+			}
+			if previous != nil && previous.Token != device.Token {
+				previous = nil
+			}
+		}
+		existing := devices[deviceID]
+		created = existing == nil
+		if existing != nil {
+			device.CreatedAt = existing.CreatedAt
+		}
+		payload, err := json.Marshal(device)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, key, deviceID, payload)
+			pipe.ZAdd(ctx, deviceLastSeenKey, &redis.Z{Score: float64(now.Unix()), Member: deviceMember(customerID, deviceID)})
+			if existing == nil {
+				pipe.HIncrBy(ctx, deviceCountsKey, string(device.Platform), 1)
+			} else if existing.Platform != device.Platform {
+				pipe.HIncrBy(ctx, deviceCountsKey, string(existing.Platform), -1)
+				pipe.HIncrBy(ctx, deviceCountsKey, string(device.Platform), 1)
+			}
+			for _, other := range devices {
+				if other.ID != deviceID && other.Token == device.Token {
+					removeDevice(ctx, pipe, other)
+				}
+			}
+			if previous != nil {
+				removeDevice(ctx, pipe, previous)
+			}
+			if existing != nil && existing.Token != device.Token {
+				pipe.Del(ctx, deviceTokenKey(existing.Token))
+			}
+			pipe.Set(ctx, tokenKey, deviceMember(customerID, deviceID), 0)
+			return nil
+		})
+		return err
+	}, key, tokenKey)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to register device: %w", err)
+	}
+
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("customer.id", customerID),
+		attribute.String("device.id", deviceID),
+		attribute.String("device.platform", string(device.Platform)),
+		attribute.Bool("device.created", created),
+	)
+	return device, created, nil
+}
+
+// List returns a customer's devices, most recently seen first, narrowed to one platform
+// when platform is set
+func (r *DeviceRegistry) List(ctx context.Context, customerID string, platform models.DevicePlatform) ([]*models.Device, error) {
+	devices, err := loadDevices(ctx, r.redis.client, customerID)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]*models.Device, 0, len(devices))
+	for _, device := range devices {
+		if platform == "" || device.Platform == platform {
+			list = append(list, device)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].LastSeenAt.After(list[j].LastSeenAt) })
+	return list, nil
+}
+
+// Delete unregisters a device, as when the customer signs out of the app
+func (r *DeviceRegistry) Delete(ctx context.Context, customerID, deviceID string) error {
+	key := devicesKey(customerID)
+	return watchKey(ctx, r.redis.client, key, func(tx *redis.Tx) error {
+		device, err := loadDevice(ctx, tx, customerID, deviceID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			removeDevice(ctx, pipe, device)
+			return nil
+		})
+		return err
+	})
+}
+
+// Resolve returns the customer's devices a target picks, or ErrNoTargetDevices when it
+// picks none
+func (r *DeviceRegistry) Resolve(ctx context.Context, customerID string, target *models.DeviceTarget) ([]*models.Device, error) {
+	devices, err := r.List(ctx, customerID, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read devices: %w", err)
+	}
+	var matched []*models.Device
+	for _, device := range devices {
+		if target.Matches(device) {
+			matched = append(matched, device)
+		}
+	}
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("%w of customer %s", ErrNoTargetDevices, customerID)
+	}
+	return matched, nil
+}
+
+// Prune removes devices unseen for DEVICE_STALE_DAYS, returning how many it removed. A
+// device that registers again while it runs is kept.
+func (r *DeviceRegistry) Prune(ctx context.Context) (int, error) {
+	if r.staleAfter <= 0 {
+		return 0, nil
+	}
+	cutoff := time.Now().Add(-r.staleAfter)
+	pruned := 0
+	for {
+		members, err := r.redis.client.ZRangeByScore(ctx, deviceLastSeenKey, &redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   strconv.FormatInt(cutoff.Unix(), 10),
+			Count: devicePruneBatch,
+		}).Result()
+		if err != nil {
+			return pruned, err
+		}
+		for _, member := range members {
+			customerID, deviceID, _ := strings.Cut(member, deviceMemberSeparator)
+			removed, err := r.pruneDevice(ctx, customerID, deviceID, cutoff)
+			if err != nil {
+				return pruned, err
+			}
+			if removed {
+				pruned++
+			}
+		}
+		if len(members) < devicePruneBatch {
+			break
+		}
+	}
+	if pruned > 0 {
+		slog.InfoContext(ctx, "🧹 Pruned stale devices", "device.count", pruned, "device.stale_days", int(r.staleAfter.Hours()/24))
+		r.refreshCounts(ctx)
+	}
+	return pruned, nil
+}
+
+// pruneDevice removes one device if it still hasn't been seen since cutoff
+func (r *DeviceRegistry) pruneDevice(ctx context.Context, customerID, deviceID string, cutoff time.Time) (bool, error) {
+	removed := false
+	err := watchKey(ctx, r.redis.client, devicesKey(customerID), func(tx *redis.Tx) error {
+		device, err := loadDevice(ctx, tx, customerID, deviceID)
+		if errors.Is(err, ErrDeviceNotFound) {
+			// Already gone; drop the leftover last seen entry
+			return tx.ZRem(ctx, deviceLastSeenKey, deviceMember(customerID, deviceID)).Err()
+		}
+		if err != nil || device.LastSeenAt.After(cutoff) {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			removeDevice(ctx, pipe, device)
+			return nil
+		})
+		removed = err == nil
+		return err
+	})
+	return removed, err
+}
+
+// refreshCounts reads the device counts by platform for the devices.registered gauge
+func (r *DeviceRegistry) refreshCounts(ctx context.Context) {
+	values, err := r.redis.client.HGetAll(ctx, deviceCountsKey).Result()
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to read device counts", "error", err)
+		return
+	}
+	counts := make(map[string]int64, len(models.DevicePlatforms))
+	for _, platform := range models.DevicePlatforms {
+		counts[string(platform)] = 0
+	}
+	for platform, value := range values {
+		count, _ := strconv.ParseInt(value, 10, 64)
+		counts[platform] = max(count, 0)
+	}
+	telemetry.RecordDeviceCounts(counts)
+}
+
+// ValidateTarget checks that a device target is only set on push notifications of a
+// customer, and picks devices by ID or platform
+func ValidateTarget(notification *models.Notification) error {
+	target := notification.Target
+	if target == nil {
+		return nil
+	}
+	if notification.Type != models.NotificationTypePush {
+		return fmt.Errorf("%w: only push notifications can target devices", ErrInvalidTarget)
+	}
+	if notification.CustomerID == "" {
+		return fmt.Errorf("%w: customer_id is required", ErrInvalidTarget)
+	}
+	if len(target.DeviceIDs) == 0 && len(target.Platforms) == 0 {
+		return fmt.Errorf("%w: device_ids or platforms is required", ErrInvalidTarget)
+	}
+	return nil
+}
+
+func deviceMember(customerID, deviceID string) string {
+	return customerID + deviceMemberSeparator + deviceID
+}
+
+// removeDevice queues the removal of a device, its last seen entry, its token and its
+// count. A registered device's token is never another device's, so it can go too.
+func removeDevice(ctx context.Context, pipe redis.Pipeliner, device *models.Device) {
+	pipe.HDel(ctx, devicesKey(device.CustomerID), device.ID)
+	pipe.ZRem(ctx, deviceLastSeenKey, deviceMember(device.CustomerID, device.ID))
+	pipe.Del(ctx, deviceTokenKey(device.Token))
+	pipe.HIncrBy(ctx, deviceCountsKey, string(device.Platform), -1)
+}
+
+func loadDevices(ctx context.Context, client redis.Cmdable, customerID string) (map[string]*models.Device, error) {
+	values, err := client.HGetAll(ctx, devicesKey(customerID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	devices := make(map[string]*models.Device, len(values))
+	for id, value := range values {
+		var device models.Device
+		if err := json.Unmarshal([]byte(value), &device); err != nil {
+			slog.WarnContext(ctx, "Skipping unreadable device", "customer.id", customerID, "device.id", id, "error", err)
+			continue
+		}
+		devices[id] = &device
+	}
+	return devices, nil
+}
+
+func loadDevice(ctx context.Context, client redis.Cmdable, customerID, deviceID string) (*models.Device, error) {
+	value, err := client.HGet(ctx, devicesKey(customerID), deviceID).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("%w: %s", ErrDeviceNotFound, deviceID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var device models.Device
+	if err := json.Unmarshal(value, &device); err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
@@ -0,0 +1,339 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/storage"
+)
+
+// DigestPeriod is how much history an operational digest covers
+type DigestPeriod string
+
+const (
+	DigestDaily  DigestPeriod = "daily"
+	DigestWeekly DigestPeriod = "weekly"
+)
+
+func (p DigestPeriod) duration() time.Duration {
+	if p == DigestWeekly {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// DigestSection adds one block of lines to an operational digest. Features with their
+// own operational data register a section.
+type DigestSection func(ctx context.Context, since time.Time) (title string, lines []string, err error)
+
+// Digest is a compiled operational summary
+type Digest struct {
+	Period   DigestPeriod `json:"period"`
+	Since    time.Time    `json:"since"`
+	Until    time.Time    `json:"until"`
+	Sections []DigestBody `json:"sections"`
+}
+
+type DigestBody struct {
+	Title string   `json:"title"`
+	Lines []string `json:"lines"`
+}
+
+// DigestService compiles operational digests and sends them to admins through the
+// service's own email and webhook (Teams) channels. Delivery and cost figures are
+// aggregated by the notification database rather than read notification by notification.
+type DigestService struct {
+	repo        storage.NotificationRepository
+	readOnly    *storage.ReadOnlyFlag
+	deadLetters *DeadLetterQueue
+	costs       map[models.NotificationType]float64
+	currency    string
+	email       ChannelSender
+	webhook     WebhookPoster
+	recipients  []string
+	teamsURL    string
+	period      DigestPeriod
+	hourUTC     int
+	sections    []DigestSection
+}
+
+// NewDigestService creates the digest service; repo is nil when the service runs on Redis
+// alone, and the delivery and cost sections then say so
+func NewDigestService(cfg *config.Config, repo storage.NotificationRepository, readOnly *storage.ReadOnlyFlag, deadLetters *DeadLetterQueue, email ChannelSender, webhook WebhookPoster) *DigestService {
+	s := &DigestService{
+		repo:        repo,
+		readOnly:    readOnly,
+		deadLetters: deadLetters,
+		costs:       make(map[models.NotificationType]float64),
+		currency:    cfg.DigestCostCurrency,
+		email:       email,
+		webhook:     webhook,
+		teamsURL:    cfg.DigestTeamsWebhookURL,
+		period:      DigestPeriod(cfg.DigestSchedule),
+		hourUTC:     cfg.DigestHourUTC,
+	}
+	for _, recipient := range strings.Split(cfg.DigestRecipients, ",") {
+		if recipient = strings.TrimSpace(recipient); recipient != "" {
+			s.recipients = append(s.recipients, recipient)
+		}
+	}
+	for _, entry := range strings.Split(cfg.DigestProviderCosts, ",") {
+		channel, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			continue
+		}
+		price, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || price < 0 {
+			slog.Warn("Ignoring DIGEST_PROVIDER_COSTS entry: price must be a number of at least 0", "entry", entry)
+			continue
+		}
+		s.costs[models.NotificationType(strings.TrimSpace(channel))] = price
+	}
+	s.sections = []DigestSection{s.deliverySection, s.deadLetterSection, s.costSection}
+	return s
+}
+
+// AddSection registers an extra digest section, rendered after the built-in ones
+func (s *DigestService) AddSection(section DigestSection) {
+	s.sections = append(s.sections, section)
+}
+
+// Start sends digests on the configured schedule until ctx is cancelled. Daily digests
+// go out at DIGEST_HOUR_UTC; weekly digests at that hour on Mondays.
+func (s *DigestService) Start(ctx context.Context) {
+	if s.period != DigestDaily && s.period != DigestWeekly {
+		slog.InfoContext(ctx, "Operational digests disabled", "digest.schedule", s.period)
+		return
+	}
+
+	go func() {
+		for {
+			next := s.nextRun(time.Now().UTC())
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Until(next)):
+			}
+
+			if s.readOnly.IsReadOnly(ctx) {
+				slog.WarnContext(ctx, "Skipping operational digest while the service is read-only", "digest.period", s.period)
+				continue
+			}
+			if _, err := s.Send(ctx, s.period); err != nil {
+				slog.ErrorContext(ctx, "Failed to send operational digest", "digest.period", s.period, "error", err)
+			}
+		}
+	}()
+}
+
+func (s *DigestService) nextRun(now time.Time) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), s.hourUTC, 0, 0, 0, time.UTC)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	if s.period == DigestWeekly {
+		for next.Weekday() != time.Monday {
+			next = next.AddDate(0, 0, 1)
+		}
+	}
+	return next
+}
+
+// Compile builds a digest covering the period ending now
+func (s *DigestService) Compile(ctx context.Context, period DigestPeriod) (*Digest, error) {
+	until := time.Now().UTC()
+	digest := &Digest{
+		Period: period,
+		Since:  until.Add(-period.duration()),
+		Until:  until,
+	}
+
+	for _, section := range s.sections {
+		title, lines, err := section(ctx, digest.Since)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile digest section: %w", err)
+		}
+		digest.Sections = append(digest.Sections, DigestBody{Title: title, Lines: lines})
+	}
+	return digest, nil
+}
+
+// Send compiles a digest and delivers it to every admin recipient and the Teams webhook.
+// Delivery failures are logged; the digest is returned as long as it compiled.
+func (s *DigestService) Send(ctx context.Context, period DigestPeriod) (*Digest, error) {
+	digest, err := s.Compile(ctx, period)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := fmt.Sprintf("Notification service %s digest: %s", period, digest.Until.Format("2006-01-02"))
+	body := digest.Text()
+
+	for _, recipient := range s.recipients {
+		err := s.email.Send(ctx, &models.Notification{
+			Type:      models.NotificationTypeEmail,
+			Recipient: recipient,
+			Subject:   subject,
+			Message:   body,
+			CreatedAt: digest.Until,
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to email digest", "digest.recipient", recipient, "error", err)
+		}
+	}
+
+	if s.teamsURL != "" {
+		err := s.webhook.SendTo(ctx, &models.Notification{
+			Type:    models.NotificationTypeWebhook,
+			Subject: subject,
+			Message: body,
+			// Teams incoming webhooks accept a plain {"text": ...} payload
+			Data:      map[string]interface{}{"text": "**" + subject + "**\n\n" + body},
+			CreatedAt: digest.Until,
+		}, s.teamsURL)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to post digest to Teams", "error", err)
+		}
+	}
+
+	slog.InfoContext(ctx, "📊 Sent operational digest", "digest.period", period, "digest.recipients", len(s.recipients))
+	return digest, nil
+}
+
+// Text renders the digest as plain text for email and Teams
+func (d *Digest) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Period: %s to %s\n", d.Since.Format(time.RFC3339), d.Until.Format(time.RFC3339))
+	for _, section := range d.Sections {
+		fmt.Fprintf(&b, "\n%s\n", section.Title)
+		for _, line := range section.Lines {
+			fmt.Fprintf(&b, "- %s\n", line)
+		}
+	}
+	return b.String()
+}
+
+// digestTopTemplates is how many of the templates failing most a digest lists
+const digestTopTemplates = 5
+
+// deliverySection summarises notification outcomes and the templates failing most often
+func (s *DigestService) deliverySection(ctx context.Context, since time.Time) (string, []string, error) {
+	const title = "Delivery"
+	if s.repo == nil {
+		return title, []string{"Unavailable: " + ErrDeliveryStatsUnavailable.Error()}, nil
+	}
+	rollups, err := s.repo.DeliveryRollup(ctx, since)
+	if err != nil {
+		return "", nil, err
+	}
+	byStatus := make(map[models.NotificationStatus]int64)
+	var total int64
+	for _, rollup := range rollups {
+		total += rollup.Count
+		byStatus[rollup.Status] += rollup.Count
+	}
+
+	lines := []string{fmt.Sprintf("Notifications created: %d", total)}
+	for _, status := range []models.NotificationStatus{
+		models.NotificationStatusSent,
+		models.NotificationStatusDelivered,
+		models.NotificationStatusFailed,
+		models.NotificationStatusRetrying,
+		models.NotificationStatusPending,
+	} {
+		lines = append(lines, fmt.Sprintf("%s: %d", status, byStatus[status]))
+	}
+
+	// Each data region reports its own top templates, so they are summed before ranking
+	failures, err := s.repo.TemplateFailures(ctx, since, digestTopTemplates)
+	if err != nil {
+		return "", nil, err
+	}
+	failuresByTemplate := make(map[string]int64)
+	for _, failure := range failures {
+		failuresByTemplate[failure.TemplateID] += failure.Failures
+	}
+	templates := make([]string, 0, len(failuresByTemplate))
+	for id := range failuresByTemplate {
+		templates = append(templates, id)
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		if failuresByTemplate[templates[i]] != failuresByTemplate[templates[j]] {
+			return failuresByTemplate[templates[i]] > failuresByTemplate[templates[j]]
+		}
+		return templates[i] < templates[j]
+	})
+	if len(templates) > digestTopTemplates {
+		templates = templates[:digestTopTemplates]
+	}
+	for _, id := range templates {
+		lines = append(lines, fmt.Sprintf("Top failing template %s: %d failures", id, failuresByTemplate[id]))
+	}
+
+	return title, lines, nil
+}
+
+// deadLetterSection reports how many notifications wait in the dead-letter queue and
+// since when
+func (s *DigestService) deadLetterSection(ctx context.Context, _ time.Time) (string, []string, error) {
+	count, err := s.deadLetters.Count(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	lines := []string{fmt.Sprintf("Dead letters waiting: %d", count)}
+	if count > 0 {
+		oldest, err := s.deadLetters.Oldest(ctx)
+		if err != nil {
+			return "", nil, err
+		}
+		if !oldest.IsZero() {
+			lines = append(lines, "Oldest dead letter captured: "+oldest.Format(time.RFC3339))
+		}
+	}
+	return "Dead-letter queue", lines, nil
+}
+
+// costSection estimates what the period's messages cost: those sent or delivered on each
+// channel priced in DIGEST_PROVIDER_COSTS, times its price
+func (s *DigestService) costSection(ctx context.Context, since time.Time) (string, []string, error) {
+	const title = "Cost"
+	if len(s.costs) == 0 {
+		return title, []string{"No prices configured (DIGEST_PROVIDER_COSTS)"}, nil
+	}
+	if s.repo == nil {
+		return title, []string{"Unavailable: " + ErrDeliveryStatsUnavailable.Error()}, nil
+	}
+	rollups, err := s.repo.DeliveryRollup(ctx, since)
+	if err != nil {
+		return "", nil, err
+	}
+	sent := make(map[models.NotificationType]int64)
+	for _, rollup := range rollups {
+		if rollup.Status == models.NotificationStatusSent || rollup.Status == models.NotificationStatusDelivered {
+			sent[rollup.Type] += rollup.Count
+		}
+	}
+
+	channels := make([]models.NotificationType, 0, len(s.costs))
+	for channel := range s.costs {
+		channels = append(channels, channel)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i] < channels[j] })
+
+	var lines []string
+	total := 0.0
+	for _, channel := range channels {
+		cost := float64(sent[channel]) * s.costs[channel]
+		total += cost
+		lines = append(lines, fmt.Sprintf("%s: %d messages, %.2f %s", channel, sent[channel], cost, s.currency))
+	}
+	lines = append(lines, fmt.Sprintf("Estimated total: %.2f %s", total, s.currency))
+	return title, lines, nil
+}
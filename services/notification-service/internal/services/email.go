@@ -0,0 +1,369 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/faults"
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// SMTP TLS modes
+const (
+	SMTPTLSStartTLS = "starttls"
+	SMTPTLSImplicit = "implicit"
+	SMTPTLSNone     = "none"
+)
+
+// maxAttachmentBytes caps the total attachment size of one email
+const maxAttachmentBytes = 10 << 20
+
+// permanentEmailError marks failures that retrying can't fix
+type permanentEmailError string
+
+func (e permanentEmailError) Error() string { return string(e) }
+
+func (e permanentEmailError) ErrorClass() models.ErrorClass { return models.ErrorClassRejected }
+
+const errPermanentEmail = permanentEmailError("permanent email failure")
+
+type EmailService struct {
+	cfg      *config.Config
+	sampler  *ProviderPayloadSampler
+	retries  *RetryPolicies
+	provider DeliveryProvider
+	dialer   *ProviderDialer
+	tracker  *LinkTracker
+	replies  *ReplyAddresses
+}
+
+// NewEmailService creates the sender for one SMTP relay; provider names it, so its
+// throttling only holds its own sends
+func NewEmailService(cfg *config.Config, provider DeliveryProvider, sampler *ProviderPayloadSampler, retries *RetryPolicies, tracker *LinkTracker, replies *ReplyAddresses) *EmailService {
+	return &EmailService{cfg: cfg, provider: provider, sampler: sampler, retries: retries, dialer: NewProviderDialer(cfg, ProviderSMTP, 10*time.Second), tracker: tracker, replies: replies}
+}
+
+// Send delivers a notification over SMTP as a plaintext + HTML message with any
+// attachments. Connection failures and 4xx replies are retried with backoff.
+func (s *EmailService) Send(ctx context.Context, notification *models.Notification) error {
+	if err := faults.Inject(ctx, faults.OpChannelEmail); err != nil {
+		return fmt.Errorf("email: %w", err)
+	}
+	if s.cfg.SMTPHost == "" {
+		return fmt.Errorf("email: %w", ErrChannelNotConfigured)
+	}
+
+	ctx, span := telemetry.Tracer.Start(ctx, "email.send",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("notification.id", notification.ID),
+			attribute.String("notification.channel", string(models.NotificationTypeEmail)),
+			attribute.String("server.address", s.cfg.SMTPHost),
+			attribute.Int("server.port", s.cfg.SMTPPort),
+			attribute.Int("email.attachments", len(notification.Attachments)),
+		),
+	)
+	defer span.End()
+	span.SetAttributes(regionAttributes(notification)...)
+
+	start := time.Now()
+	err := s.send(ctx, span, notification)
+	telemetry.RecordChannelDelivery(ctx, string(models.NotificationTypeEmail), notification.Region, err == nil, time.Since(start).Seconds())
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Email delivery failed")
+		return fmt.Errorf("email: %w", err)
+	}
+	return nil
+}
+
+func (s *EmailService) send(ctx context.Context, span trace.Span, notification *models.Notification) error {
+	to, err := mail.ParseAddress(notification.Recipient)
+	if err != nil {
+		return fmt.Errorf("%w: invalid recipient %q: %v", errPermanentEmail, notification.Recipient, err)
+	}
+	from, err := mail.ParseAddress(s.cfg.FromEmail)
+	if err != nil {
+		return fmt.Errorf("%w: invalid FROM_EMAIL %q: %v", errPermanentEmail, s.cfg.FromEmail, err)
+	}
+
+	message, err := buildEmailMessage(from, to, s.replies.Address(notification.ID), notification, s.tracker)
+	if err != nil {
+		return err
+	}
+	span.SetAttributes(attribute.Int("email.size_bytes", len(message)))
+
+	attempts, err := s.retries.Do(ctx, models.NotificationTypeEmail, s.provider, func(attempt int) error {
+		start := time.Now()
+		err := s.deliver(ctx, from.Address, to.Address, message)
+		s.capture(ctx, notification, attempt, from.Address, to.Address, message, err, start)
+		return err
+	})
+	span.SetAttributes(attribute.Int("email.attempts", attempts))
+	notification.RetryCount = max(attempts-1, 0)
+	return err
+}
+
+// capture records an SMTP attempt when the notification is sampled. The request is the
+// message as submitted; the SMTP reply is only kept when delivery failed.
+func (s *EmailService) capture(ctx context.Context, notification *models.Notification, attempt int, from, to string, message []byte, err error, start time.Time) {
+	if !s.sampler.Sampled(notification.ID) {
+		return
+	}
+
+	exchange := models.ProviderExchange{
+		Channel:  models.NotificationTypeEmail,
+		Provider: "smtp",
+		Endpoint: fmt.Sprintf("smtp://%s (%s)", net.JoinHostPort(s.cfg.SMTPHost, strconv.Itoa(s.cfg.SMTPPort)), s.cfg.SMTPTLSMode),
+		Attempt:  attempt,
+		Request: s.sampler.Payload(map[string]string{
+			"MAIL FROM": from,
+			"RCPT TO":   to,
+		}, message),
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		exchange.Error = err.Error()
+		var protoErr *textproto.Error
+		if errors.As(err, &protoErr) {
+			exchange.StatusCode = protoErr.Code
+		}
+	} else {
+		exchange.StatusCode = 250
+	}
+	s.sampler.Record(ctx, notification, exchange)
+}
+
+// deliver runs one SMTP session
+func (s *EmailService) deliver(ctx context.Context, from, to string, message []byte) error {
+	address := net.JoinHostPort(s.cfg.SMTPHost, strconv.Itoa(s.cfg.SMTPPort))
+	tlsConfig := &tls.Config{ServerName: s.cfg.SMTPHost, MinVersion: tls.VersionTLS12}
+
+	conn, err := s.dialer.DialContext(ctx, address)
+	if err != nil {
+		return err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(60 * time.Second)
+	}
+	conn.SetDeadline(deadline)
+
+	if s.cfg.SMTPTLSMode == SMTPTLSImplicit {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	client, err := smtp.NewClient(conn, s.cfg.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if s.cfg.SMTPTLSMode == SMTPTLSStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("%w: server does not support STARTTLS", errPermanentEmail)
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+
+	if s.cfg.SMTPUsername != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.cfg.SMTPUsername, s.cfg.SMTPPassword, s.cfg.SMTPHost)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(message); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// buildEmailMessage renders a MIME message: multipart/alternative with plaintext and HTML
+// bodies, wrapped in multipart/mixed when there are attachments. The HTML body is
+// instrumented for open and click tracking when the tracker is enabled, and replies go
+// to replyTo when it is set.
+func buildEmailMessage(from, to *mail.Address, replyTo string, notification *models.Notification, tracker *LinkTracker) ([]byte, error) {
+	total := 0
+	for _, attachment := range notification.Attachments {
+		total += len(attachment.Content)
+	}
+	if total > maxAttachmentBytes {
+		return nil, fmt.Errorf("%w: attachments total %d bytes, limit is %d", errPermanentEmail, total, maxAttachmentBytes)
+	}
+
+	htmlBody := notification.HTMLMessage
+	if htmlBody == "" {
+		htmlBody = "<p>" + strings.ReplaceAll(html.EscapeString(notification.Message), "\n", "<br>") + "</p>"
+	}
+	htmlBody = tracker.InstrumentHTML(notification.ID, htmlBody)
+
+	var buf bytes.Buffer
+	header := textproto.MIMEHeader{}
+	header.Set("From", from.String())
+	header.Set("To", to.String())
+	if replyTo != "" {
+		header.Set("Reply-To", replyTo)
+	}
+	header.Set("Subject", mime.QEncoding.Encode("utf-8", notification.Subject))
+	header.Set("Date", time.Now().UTC().Format(time.RFC1123Z))
+	header.Set("Message-ID", emailMessageID(notification.ID, from.Address))
+	// Mail clients thread the email under the earlier ones of its conversation
+	if references := notification.ThreadReferences; len(references) > 0 {
+		ids := make([]string, len(references))
+		for i, id := range references {
+			ids[i] = emailMessageID(id, from.Address)
+		}
+		header.Set("In-Reply-To", ids[len(ids)-1])
+		header.Set("References", strings.Join(ids, " "))
+	}
+	header.Set("MIME-Version", "1.0")
+
+	mixed := multipart.NewWriter(&buf)
+	alternative := mixed
+	if len(notification.Attachments) > 0 {
+		header.Set("Content-Type", "multipart/mixed; boundary="+mixed.Boundary())
+		writeHeader(&buf, header)
+
+		altBuf := &bytes.Buffer{}
+		alternative = multipart.NewWriter(altBuf)
+		if err := writeAlternative(alternative, notification.Message, htmlBody); err != nil {
+			return nil, err
+		}
+		part, err := mixed.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {"multipart/alternative; boundary=" + alternative.Boundary()},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := part.Write(altBuf.Bytes()); err != nil {
+			return nil, err
+		}
+
+		for _, attachment := range notification.Attachments {
+			if err := writeAttachment(mixed, attachment); err != nil {
+				return nil, err
+			}
+		}
+		if err := mixed.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	header.Set("Content-Type", "multipart/alternative; boundary="+alternative.Boundary())
+	writeHeader(&buf, header)
+	if err := writeAlternative(alternative, notification.Message, htmlBody); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeHeader(buf *bytes.Buffer, header textproto.MIMEHeader) {
+	for _, key := range []string{"From", "To", "Reply-To", "Subject", "Date", "Message-ID", "In-Reply-To", "References", "MIME-Version", "Content-Type"} {
+		if value := header.Get(key); value != "" {
+			fmt.Fprintf(buf, "%s: %s\r\n", key, value)
+		}
+	}
+	buf.WriteString("\r\n")
+}
+
+// writeAlternative writes the plaintext and HTML bodies and closes the writer
+func writeAlternative(writer *multipart.Writer, text, htmlBody string) error {
+	for _, body := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", htmlBody},
+	} {
+		part, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {body.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return err
+		}
+		qp := quotedprintable.NewWriter(part)
+		if _, err := qp.Write([]byte(body.content)); err != nil {
+			return err
+		}
+		if err := qp.Close(); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+func writeAttachment(writer *multipart.Writer, attachment models.Attachment) error {
+	contentType := attachment.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	part, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename})},
+	})
+	if err != nil {
+		return err
+	}
+
+	// RFC 2045 limits encoded lines to 76 characters
+	encoded := base64.StdEncoding.EncodeToString(attachment.Content)
+	for len(encoded) > 76 {
+		if _, err := io.WriteString(part, encoded[:76]+"\r\n"); err != nil {
+			return err
+		}
+		encoded = encoded[76:]
+	}
+	_, err = io.WriteString(part, encoded+"\r\n")
+	return err
+}
+
+// emailMessageID is the Message-ID of a notification's email sent from address
+func emailMessageID(notificationID, from string) string {
+	return fmt.Sprintf("<%s@%s>", notificationID, emailDomain(from))
+}
+
+func emailDomain(address string) string {
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		return address[at+1:]
+	}
+	return "localhost"
+}
@@ -0,0 +1,428 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var ErrInvalidInboundEmail = errors.New("invalid inbound email")
+
+// EmailReplyEvent is the lifecycle event published for each reply matched to a notification
+const EmailReplyEvent = "NotificationReplied"
+
+// replyUnmatched is the correlation recorded for inbound emails matched to no notification
+const replyUnmatched = "unmatched"
+
+// replySignatureLength is the number of hex digits of a reply-to address's signature
+const replySignatureLength = 16
+
+// maxReplyTextBytes caps the reply text kept and published
+const maxReplyTextBytes = 16 << 10
+
+var (
+	// emailAddressPattern finds addresses in recipient lists net/mail can't parse
+	emailAddressPattern = regexp.MustCompile(`[^\s<>,;"']+@[^\s<>,;"']+`)
+	// messageIDPattern finds the Message-IDs of In-Reply-To and References
+	messageIDPattern = regexp.MustCompile(`<([^<>@\s]+)@([^<>\s]+)>`)
+	// quoteAttribution is the line mail clients put above the quoted original
+	quoteAttribution = regexp.MustCompile(`(?i)^(-+\s*original message\s*-+|_{10,}|from:\s.+|.*\bwrote:)$`)
+	// htmlQuote, htmlBreak and htmlTag turn an HTML-only reply into text without the
+	// quoted original
+	htmlQuote = regexp.MustCompile(`(?is)<blockquote\b.*</blockquote>`)
+	htmlBreak = regexp.MustCompile(`(?i)<br\s*/?>|</p>|</div>`)
+	htmlTag   = regexp.MustCompile(`<[^>]*>`)
+)
+
+// emailRepliesKey holds the replies to a notification, oldest first
+func emailRepliesKey(notificationID string) string {
+	return "email-replies:" + notificationID
+}
+
+// ReplyAddresses gives each email a reply-to address naming its notification,
+// <mailbox>+<notification id>.<signature>@<domain>. The signature, an HMAC with
+// REPLY_TO_SECRET, keeps senders from attributing replies to other notifications; it is
+// hex so mail servers that lowercase addresses don't break it.
+type ReplyAddresses struct {
+	domain  string
+	mailbox string
+	secret  []byte
+}
+
+func NewReplyAddresses(cfg *config.Config) *ReplyAddresses {
+	addresses := &ReplyAddresses{
+		domain:  strings.ToLower(strings.TrimSpace(cfg.ReplyToDomain)),
+		mailbox: strings.ToLower(strings.TrimSpace(cfg.ReplyToMailbox)),
+		secret:  []byte(cfg.ReplyToSecret),
+	}
+	if addresses.domain != "" && len(addresses.secret) == 0 {
+		slog.Warn("Reply tracking is off: REPLY_TO_DOMAIN is set without REPLY_TO_SECRET")
+	}
+	return addresses
+}
+
+// Enabled reports whether emails get reply-to addresses
+func (a *ReplyAddresses) Enabled() bool {
+	return a != nil && a.domain != "" && len(a.secret) > 0
+}
+
+// Address is the reply-to address of a notification, or "" when reply tracking is off
+func (a *ReplyAddresses) Address(notificationID string) string {
+	if !a.Enabled() {
+		return ""
+	}
+	return a.mailbox + "+" + notificationID + "." + a.sign(notificationID) + "@" + a.domain
+}
+
+// NotificationID returns the notification a reply-to address was made for, if address
+// is one with a valid signature
+func (a *ReplyAddresses) NotificationID(address string) (string, bool) {
+	if !a.Enabled() {
+		return "", false
+	}
+	at := strings.LastIndex(address, "@")
+	if at < 0 || !strings.EqualFold(address[at+1:], a.domain) {
+		return "", false
+	}
+	local := address[:at]
+	if len(local) <= len(a.mailbox)+1 || !strings.EqualFold(local[:len(a.mailbox)+1], a.mailbox+"+") {
+		return "", false
+	}
+	token := local[len(a.mailbox)+1:]
+	dot := strings.LastIndex(token, ".")
+	if dot <= 0 {
+		return "", false
+	}
+	id, signature := token[:dot], strings.ToLower(token[dot+1:])
+	if !hmac.Equal([]byte(signature), []byte(a.sign(id))) {
+		return "", false
+	}
+	return id, true
+}
+
+func (a *ReplyAddresses) sign(notificationID string) string {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte("reply\n" + notificationID))
+	return hex.EncodeToString(mac.Sum(nil))[:replySignatureLength]
+}
+
+// EmailReplyService matches the emails customers send back to the notifications they
+// reply to: by the signed reply-to address the email went to, or else by an In-Reply-To
+// or References naming the notification's Message-ID when it comes from the address the
+// notification was sent to. Each matched reply is kept with the notification, recorded
+// as a replied engagement event and republished as a NotificationReplied lifecycle event
+// carrying the notification's customer and order.
+type EmailReplyService struct {
+	redis         *RedisClient
+	addresses     *ReplyAddresses
+	notifications *NotificationService
+	engagement    *EngagementService
+	messageDomain string
+	retention     time.Duration
+}
+
+func NewEmailReplyService(cfg *config.Config, redis *RedisClient, addresses *ReplyAddresses, notifications *NotificationService, engagement *EngagementService) *EmailReplyService {
+	retention := time.Duration(cfg.EmailReplyRetentionDays) * 24 * time.Hour
+	if retention <= 0 {
+		retention = 30 * 24 * time.Hour
+	}
+	messageDomain := ""
+	if from, err := mail.ParseAddress(cfg.FromEmail); err == nil {
+		messageDomain = emailDomain(from.Address)
+	}
+	return &EmailReplyService{
+		redis:         redis,
+		addresses:     addresses,
+		notifications: notifications,
+		engagement:    engagement,
+		messageDomain: messageDomain,
+		retention:     retention,
+	}
+}
+
+// HandleInbound matches an inbound email to its notification and republishes it. An email
+// matching no notification isn't an error: the result says why it was dropped.
+func (s *EmailReplyService) HandleInbound(ctx context.Context, email models.InboundEmail) (*models.InboundEmailResult, error) {
+	sender := parseSender(email.From)
+	if sender == "" {
+		return nil, fmt.Errorf("%w: from is required", ErrInvalidInboundEmail)
+	}
+
+	notification, correlatedBy, reason, err := s.correlate(ctx, email, sender)
+	if err != nil {
+		return nil, err
+	}
+	span := trace.SpanFromContext(ctx)
+	if notification == nil {
+		span.SetAttributes(attribute.String("email.reply.correlation", replyUnmatched))
+		telemetry.RecordEmailReply(ctx, replyUnmatched)
+		slog.InfoContext(ctx, "Dropped inbound email matching no notification", "reason", reason)
+		return &models.InboundEmailResult{Reason: reason}, nil
+	}
+
+	customerID := notification.CustomerID
+	if customerID == "" {
+		customerID = notification.Recipient
+	}
+	reply := &models.EmailReply{
+		ID:             NewID(),
+		NotificationID: notification.ID,
+		ConversationID: ConversationID(notification),
+		CustomerID:     customerID,
+		OrderID:        notification.OrderID,
+		From:           sender,
+		Subject:        email.Subject,
+		Text:           replyText(email),
+		CorrelatedBy:   correlatedBy,
+		ReceivedAt:     time.Now().UTC(),
+	}
+	if err := s.store(ctx, notification, reply); err != nil {
+		return nil, err
+	}
+	span.SetAttributes(
+		attribute.String("notification.id", notification.ID),
+		attribute.String("email.reply.correlation", correlatedBy),
+	)
+
+	if _, err := s.engagement.Record(ctx, models.RecordEngagementEventRequest{
+		Type:           models.EngagementReplied,
+		NotificationID: notification.ID,
+		CustomerID:     customerID,
+		Channel:        notification.Type,
+		OccurredAt:     &reply.ReceivedAt,
+		Attributes:     map[string]string{"reply_id": reply.ID, "correlated_by": correlatedBy},
+	}); err != nil && !errors.Is(err, ErrStorageUnavailable) {
+		slog.WarnContext(ctx, "Failed to record reply engagement", "notification.id", notification.ID, "error", err)
+	}
+
+	// Order IDs are numeric in lifecycle events; other references are left out
+	orderID, _ := strconv.Atoi(notification.OrderID)
+	event := LifecycleEvent{
+		EventType:      EmailReplyEvent,
+		NotificationID: notification.ID,
+		ConversationID: reply.ConversationID,
+		CustomerID:     customerID,
+		OrderID:        orderID,
+		Channel:        string(notification.Type),
+		Status:         "replied",
+		Reply: &LifecycleReply{
+			ReplyID:    reply.ID,
+			ReceivedAt: reply.ReceivedAt.Format(time.RFC3339Nano),
+		},
+	}
+	// A pinned tenant's reply stays in its data region; consumers read it from there
+	if s.notifications.residency.Regional(notification.Region) {
+		event.Region = notification.Region
+	} else {
+		event.Reply.From = reply.From
+		event.Reply.Subject = reply.Subject
+		event.Reply.Text = reply.Text
+	}
+	if err := s.notifications.PublishLifecycleEvent(ctx, event); err != nil {
+		slog.WarnContext(ctx, "Failed to publish reply event", "notification.id", notification.ID, "error", err)
+	}
+
+	telemetry.RecordEmailReply(ctx, correlatedBy)
+	slog.InfoContext(ctx, "📨 Received email reply", "notification.id", notification.ID, "customer.id", customerID, "order.id", notification.OrderID, "email.reply.correlation", correlatedBy)
+	return &models.InboundEmailResult{Correlated: true, Reply: reply}, nil
+}
+
+// Replies returns the replies to a notification, oldest first
+func (s *EmailReplyService) Replies(ctx context.Context, notificationID string) ([]*models.EmailReply, error) {
+	client, err := s.notifications.residency.Records(ctx, notificationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load email replies: %w", err)
+	}
+	values, err := client.LRange(ctx, emailRepliesKey(notificationID), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load email replies: %w", err)
+	}
+	replies := make([]*models.EmailReply, 0, len(values))
+	for _, value := range values {
+		var reply models.EmailReply
+		if err := json.Unmarshal([]byte(value), &reply); err != nil {
+			return nil, fmt.Errorf("failed to decode email reply: %w", err)
+		}
+		replies = append(replies, &reply)
+	}
+	return replies, nil
+}
+
+// correlate returns the notification an inbound email replies to and how it was matched,
+// or why none was
+func (s *EmailReplyService) correlate(ctx context.Context, email models.InboundEmail, sender string) (*models.Notification, string, string, error) {
+	for _, address := range inboundRecipients(email) {
+		id, ok := s.addresses.NotificationID(address)
+		if !ok {
+			continue
+		}
+		notification, err := s.notifications.GetNotification(ctx, id)
+		if errors.Is(err, ErrNotificationNotFound) {
+			return nil, "", "notification " + id + " no longer exists", nil
+		}
+		if err != nil {
+			return nil, "", "", err
+		}
+		return notification, models.ReplyCorrelatedAddress, "", nil
+	}
+
+	for _, id := range s.threadIDs(email.Headers) {
+		notification, err := s.notifications.GetNotification(ctx, id)
+		if errors.Is(err, ErrNotificationNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, "", "", err
+		}
+		// Message-IDs aren't secret, so only the original recipient can thread a reply
+		if notification.Type == models.NotificationTypeEmail && strings.EqualFold(parseSender(notification.Recipient), sender) {
+			return notification, models.ReplyCorrelatedThread, "", nil
+		}
+	}
+	return nil, "", "no reply-to address or thread of a notification", nil
+}
+
+// threadIDs returns the notification IDs named by the Message-IDs of an email's
+// In-Reply-To and References headers, the most recent first
+func (s *EmailReplyService) threadIDs(headers string) []string {
+	if headers == "" || s.messageDomain == "" {
+		return nil
+	}
+	header, err := textproto.NewReader(bufio.NewReader(strings.NewReader(headers + "\r\n\r\n"))).ReadMIMEHeader()
+	if err != nil && len(header) == 0 {
+		return nil
+	}
+
+	var ids []string
+	references := messageIDPattern.FindAllStringSubmatch(header.Get("References"), -1)
+	for i := len(references) - 1; i >= 0; i-- {
+		if strings.EqualFold(references[i][2], s.messageDomain) {
+			ids = append(ids, references[i][1])
+		}
+	}
+	for _, match := range messageIDPattern.FindAllStringSubmatch(header.Get("In-Reply-To"), -1) {
+		if strings.EqualFold(match[2], s.messageDomain) {
+			ids = append([]string{match[1]}, ids...)
+		}
+	}
+	return ids
+}
+
+// store appends a reply to its notification's replies, in the notification's data
+// region, where they are kept for EMAIL_REPLY_RETENTION_DAYS after the last one
+func (s *EmailReplyService) store(ctx context.Context, notification *models.Notification, reply *models.EmailReply) error {
+	payload, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+	client, err := s.notifications.residency.RecordClient(notification)
+	if err != nil {
+		return fmt.Errorf("failed to store email reply: %w", err)
+	}
+	key := emailRepliesKey(reply.NotificationID)
+	if _, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, key, payload)
+		pipe.Expire(ctx, key, s.retention)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to store email reply: %w", err)
+	}
+	return nil
+}
+
+// inboundRecipients lists every address an inbound email was delivered to: the SMTP
+// envelope's recipients and the To and Cc headers
+func inboundRecipients(email models.InboundEmail) []string {
+	var addresses []string
+	if email.Envelope != "" {
+		var envelope struct {
+			To []string `json:"to"`
+		}
+		if err := json.Unmarshal([]byte(email.Envelope), &envelope); err == nil {
+			addresses = append(addresses, envelope.To...)
+		}
+	}
+	for _, field := range []string{email.To, email.Cc} {
+		if field == "" {
+			continue
+		}
+		list, err := mail.ParseAddressList(field)
+		if err != nil {
+			addresses = append(addresses, emailAddressPattern.FindAllString(field, -1)...)
+			continue
+		}
+		for _, address := range list {
+			addresses = append(addresses, address.Address)
+		}
+	}
+	return addresses
+}
+
+// parseSender returns the bare address of a From header
+func parseSender(from string) string {
+	if address, err := mail.ParseAddress(from); err == nil {
+		return address.Address
+	}
+	return emailAddressPattern.FindString(from)
+}
+
+// replyText is what the customer wrote: the text body, or the HTML one as text, up to
+// the quoted original. A reply that is nothing but a quote is kept whole.
+func replyText(email models.InboundEmail) string {
+	text := email.Text
+	if strings.TrimSpace(text) == "" && email.HTML != "" {
+		text = htmlQuote.ReplaceAllString(email.HTML, "")
+		text = html.UnescapeString(htmlTag.ReplaceAllString(htmlBreak.ReplaceAllString(text, "\n"), ""))
+	}
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+
+	lines := strings.Split(text, "\n")
+	kept := lines
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, ">") || quoteAttribution.MatchString(trimmed) {
+			kept = lines[:i]
+			// Attributions wrapped over two lines start with "On <date>"
+			if i > 0 && strings.HasSuffix(trimmed, "wrote:") && !strings.HasPrefix(strings.ToLower(trimmed), "on ") &&
+				strings.HasPrefix(strings.ToLower(strings.TrimSpace(lines[i-1])), "on ") {
+				kept = lines[:i-1]
+			}
+			break
+		}
+	}
+	reply := strings.TrimSpace(strings.Join(kept, "\n"))
+	if reply == "" {
+		reply = strings.TrimSpace(text)
+	}
+
+	if len(reply) > maxReplyTextBytes {
+		cut := maxReplyTextBytes
+		for cut > 0 && !utf8.RuneStart(reply[cut]) {
+			cut--
+		}
+		reply = reply[:cut]
+	}
+	return reply
+}
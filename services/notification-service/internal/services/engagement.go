@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"notification-service/internal/models"
+	"notification-service/internal/storage"
+	"notification-service/internal/telemetry"
+)
+
+// engagementClockSkew is how far in the future a reported occurred_at may be
+const engagementClockSkew = 5 * time.Minute
+
+// ErrInvalidEngagementEvent is returned for events that can't be recorded as reported
+var ErrInvalidEngagementEvent = errors.New("invalid engagement event")
+
+// EngagementService records recipient interactions in the append-only engagement store
+// and serves the raw events and per-type rollups built from them
+type EngagementService struct {
+	repo     storage.EngagementRepository
+	sendTime *SendTimeOptimizer
+}
+
+// NewEngagementService takes the engagement repository; nil leaves the API answering
+// ErrStorageUnavailable. Recorded events are also credited to the send-time experiment.
+func NewEngagementService(repo storage.EngagementRepository, sendTime *SendTimeOptimizer) *EngagementService {
+	return &EngagementService{repo: repo, sendTime: sendTime}
+}
+
+// Record appends an engagement event and returns it with its ID
+func (s *EngagementService) Record(ctx context.Context, req models.RecordEngagementEventRequest) (*models.EngagementEvent, error) {
+	if s.repo == nil {
+		return nil, ErrStorageUnavailable
+	}
+
+	now := time.Now().UTC()
+	occurredAt := now
+	if req.OccurredAt != nil {
+		occurredAt = req.OccurredAt.UTC()
+		if occurredAt.After(now.Add(engagementClockSkew)) {
+			return nil, fmt.Errorf("%w: occurred_at %s is in the future", ErrInvalidEngagementEvent, occurredAt.Format(time.RFC3339))
+		}
+	}
+
+	event := &models.EngagementEvent{
+		Type:           req.Type,
+		NotificationID: req.NotificationID,
+		CustomerID:     req.CustomerID,
+		Channel:        req.Channel,
+		OccurredAt:     occurredAt,
+		Attributes:     req.Attributes,
+	}
+	if err := s.repo.AppendEngagementEvent(ctx, event); err != nil {
+		return nil, err
+	}
+
+	telemetry.RecordEngagementEvent(ctx, string(event.Type), string(event.Channel))
+	if s.sendTime != nil {
+		s.sendTime.RecordEngagement(ctx, event)
+	}
+	return event, nil
+}
+
+// Events pages through raw engagement events in the order they were recorded
+func (s *EngagementService) Events(ctx context.Context, filter storage.EngagementFilter) ([]*models.EngagementEvent, string, error) {
+	if s.repo == nil {
+		return nil, "", ErrStorageUnavailable
+	}
+	if err := validEngagementWindow(filter); err != nil {
+		return nil, "", err
+	}
+	return s.repo.ListEngagementEvents(ctx, filter)
+}
+
+// Rollup counts events per type over the filter's window
+func (s *EngagementService) Rollup(ctx context.Context, filter storage.EngagementFilter) ([]models.EngagementRollup, error) {
+	if s.repo == nil {
+		return nil, ErrStorageUnavailable
+	}
+	if err := validEngagementWindow(filter); err != nil {
+		return nil, err
+	}
+	return s.repo.EngagementRollup(ctx, filter)
+}
+
+// Metrics rolls the window's events up per type and computes the open and click rates
+// of the notifications sent on channel in it, overall and per template
+func (s *EngagementService) Metrics(ctx context.Context, filter storage.EngagementFilter, channel models.NotificationType) (*models.EngagementMetrics, error) {
+	if s.repo == nil {
+		return nil, ErrStorageUnavailable
+	}
+	if err := validEngagementWindow(filter); err != nil {
+		return nil, err
+	}
+
+	byType, err := s.repo.EngagementRollup(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	byTemplate, err := s.repo.EngagementRates(ctx, filter, channel)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := &models.EngagementMetrics{
+		From:       filter.From,
+		To:         filter.To,
+		Channel:    channel,
+		ByType:     byType,
+		ByTemplate: byTemplate,
+	}
+	if metrics.ByType == nil {
+		metrics.ByType = []models.EngagementRollup{}
+	}
+	if metrics.ByTemplate == nil {
+		metrics.ByTemplate = []models.EngagementRates{}
+	}
+	for i := range metrics.ByTemplate {
+		rates := &metrics.ByTemplate[i]
+		metrics.Sent += rates.Sent
+		metrics.Opened += rates.Opened
+		metrics.Clicked += rates.Clicked
+		computeEngagementRates(rates)
+	}
+	computeEngagementRates(&metrics.EngagementRates)
+	return metrics, nil
+}
+
+func computeEngagementRates(rates *models.EngagementRates) {
+	if rates.Sent > 0 {
+		rates.OpenRate = float64(rates.Opened) / float64(rates.Sent)
+		rates.ClickThroughRate = float64(rates.Clicked) / float64(rates.Sent)
+	}
+	if rates.Opened > 0 {
+		rates.ClickToOpenRate = float64(rates.Clicked) / float64(rates.Opened)
+	}
+}
+
+func validEngagementWindow(filter storage.EngagementFilter) error {
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.From.Before(filter.To) {
+		return fmt.Errorf("%w: from must be before to", ErrInvalidEngagementEvent)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrPreconditionFailed means a conditional write's If-Match no longer matches the
+// resource: someone else changed it since it was read
+var ErrPreconditionFailed = errors.New("resource has changed since it was read")
+
+// maxWatchAttempts bounds the retries of a write whose key changed between its read and
+// its commit
+const maxWatchAttempts = 3
+
+// ETag returns a strong entity tag for a resource: a hash of its JSON form, so it changes
+// whenever any field does. A nil resource has none.
+func ETag(resource interface{}) string {
+	payload, err := json.Marshal(resource)
+	if err != nil || string(payload) == "null" {
+		return ""
+	}
+	sum := sha256.Sum256(payload)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// MatchesETag reports whether an If-Match or If-None-Match header lists etag. "*"
+// matches any existing resource, and a weak W/ tag matches the same strong tag.
+func MatchesETag(header, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch fails a conditional write when the resource's current etag isn't in
+// ifMatch; an empty ifMatch makes the write unconditional
+func checkIfMatch(ifMatch, etag string) error {
+	if ifMatch != "" && !MatchesETag(ifMatch, etag) {
+		return ErrPreconditionFailed
+	}
+	return nil
+}
+
+// watchKey runs fn in a Redis optimistic transaction on key, running it again when the
+// key changes before fn commits, so a read-check-write is never lost to a concurrent one
+func watchKey(ctx context.Context, client *redis.Client, key string, fn func(tx *redis.Tx) error) error {
+	return watchKeys(ctx, client, fn, key)
+}
+
+// watchKeys is watchKey for a write that depends on several keys
+func watchKeys(ctx context.Context, client *redis.Client, fn func(tx *redis.Tx) error, keys ...string) error {
+	var err error
+	for attempt := 0; attempt < maxWatchAttempts; attempt++ {
+		if err = client.Watch(ctx, fn, keys...); !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return err
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"notification-service/internal/telemetry"
+)
+
+// NamespaceRole identifies which Event Hub namespace is in use
+type NamespaceRole string
+
+const (
+	NamespacePrimary   NamespaceRole = "primary"
+	NamespaceSecondary NamespaceRole = "secondary"
+)
+
+// Failover sources report failures separately: the consumer's connection, each
+// partition it receives from (see partitionFailoverSource) and the producer's publishes
+const (
+	connectFailoverSource = "connect"
+	publishFailoverSource = "publish"
+)
+
+// partitionFailoverSource is the failover source of a consumer partition
+func partitionFailoverSource(partitionID string) string {
+	return "partition:" + partitionID
+}
+
+// FailoverStatus is the operator-visible state of a namespace failover
+type FailoverStatus struct {
+	Name                string        `json:"name"`
+	Active              NamespaceRole `json:"active"`
+	SecondaryConfigured bool          `json:"secondary_configured"`
+	ConsecutiveFailures int           `json:"consecutive_failures"`
+	FailureThreshold    int           `json:"failure_threshold"`
+	LastChange          *time.Time    `json:"last_change,omitempty"`
+	LastError           string        `json:"last_error,omitempty"`
+}
+
+// NamespaceFailover tracks connection health for a primary/secondary namespace pair.
+// After threshold consecutive failures on the primary it switches to the secondary,
+// and while on the secondary it probes the primary and fails back once it answers.
+// Failures are counted per source, such as a partition, so the threshold means the same
+// however many partitions report, and one healthy partition doesn't hide another's
+// failures.
+type NamespaceFailover struct {
+	name      string
+	primary   string
+	secondary string
+	threshold int
+	interval  time.Duration
+	probe     func(ctx context.Context, connectionString string) error
+
+	mutex      sync.Mutex
+	active     NamespaceRole
+	failures   map[string]int
+	lastChange *time.Time
+	lastError  string
+	onChange   []func(context.Context, NamespaceRole)
+}
+
+func NewNamespaceFailover(name, primary, secondary string, threshold int, probeInterval time.Duration, probe func(ctx context.Context, connectionString string) error) *NamespaceFailover {
+	return &NamespaceFailover{
+		name:      name,
+		primary:   primary,
+		secondary: secondary,
+		threshold: threshold,
+		interval:  probeInterval,
+		probe:     probe,
+		active:    NamespacePrimary,
+		failures:  make(map[string]int),
+	}
+}
+
+// ConnectionString returns the connection string of the active namespace
+func (f *NamespaceFailover) ConnectionString() string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.active == NamespaceSecondary {
+		return f.secondary
+	}
+	return f.primary
+}
+
+// OnChange registers a callback invoked (outside the lock) after every switch
+func (f *NamespaceFailover) OnChange(callback func(context.Context, NamespaceRole)) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.onChange = append(f.onChange, callback)
+}
+
+// ReportSuccess resets the source's failure count for the active namespace
+func (f *NamespaceFailover) ReportSuccess(source string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.failures, source)
+}
+
+// ReportFailure records a connection failure of the source and fails over once the
+// source reaches the threshold
+func (f *NamespaceFailover) ReportFailure(ctx context.Context, source string, err error) {
+	f.mutex.Lock()
+	f.failures[source]++
+	f.lastError = err.Error()
+	shouldSwitch := f.active == NamespacePrimary && f.secondary != "" && f.failures[source] >= f.threshold
+	f.mutex.Unlock()
+
+	if shouldSwitch {
+		f.switchTo(ctx, NamespaceSecondary, "sustained connection failures: "+err.Error())
+	}
+}
+
+// Status returns a snapshot of the failover state
+func (f *NamespaceFailover) Status() FailoverStatus {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	consecutive := 0
+	for _, failures := range f.failures {
+		consecutive = max(consecutive, failures)
+	}
+	return FailoverStatus{
+		Name:                f.name,
+		Active:              f.active,
+		SecondaryConfigured: f.secondary != "",
+		ConsecutiveFailures: consecutive,
+		FailureThreshold:    f.threshold,
+		LastChange:          f.lastChange,
+		LastError:           f.lastError,
+	}
+}
+
+// StartFailbackProbe periodically checks the primary while on the secondary
+func (f *NamespaceFailover) StartFailbackProbe(ctx context.Context) {
+	if f.secondary == "" || f.probe == nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				f.mutex.Lock()
+				onSecondary := f.active == NamespaceSecondary
+				f.mutex.Unlock()
+				if !onSecondary {
+					continue
+				}
+
+				probeCtx, cancel := context.WithTimeout(ctx, 15*time.Second)
+				err := f.probe(probeCtx, f.primary)
+				cancel()
+
+				if err != nil {
+					slog.WarnContext(ctx, "Event Hub primary namespace still unavailable", "eventhub.client", f.name, "error", err)
+					continue
+				}
+				f.switchTo(ctx, NamespacePrimary, "primary namespace probe succeeded")
+			}
+		}
+	}()
+}
+
+func (f *NamespaceFailover) switchTo(ctx context.Context, role NamespaceRole, reason string) {
+	f.mutex.Lock()
+	if f.active == role {
+		f.mutex.Unlock()
+		return
+	}
+	from := f.active
+	now := time.Now()
+	f.active = role
+	clear(f.failures)
+	f.lastChange = &now
+	callbacks := append([]func(context.Context, NamespaceRole){}, f.onChange...)
+	f.mutex.Unlock()
+
+	slog.WarnContext(ctx, "⚠ Event Hub FAILOVER", "eventhub.client", f.name, "eventhub.namespace.from", from, "eventhub.namespace.to", role, "reason", reason)
+	telemetry.RecordEventHubFailover(ctx, f.name, string(from), string(role))
+
+	for _, callback := range callbacks {
+		callback(ctx, role)
+	}
+}
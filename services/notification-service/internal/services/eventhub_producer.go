@@ -0,0 +1,413 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azeventhubs"
+	"github.com/Azure/go-amqp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// ErrEventBufferFull is returned when the producer already holds its maximum of unsent events
+var ErrEventBufferFull = errors.New("event hub producer buffer is full")
+
+// LifecycleEvent is published to Event Hub as a notification moves through delivery
+type LifecycleEvent struct {
+	EventType      string `json:"EventType"`
+	NotificationID string `json:"NotificationId,omitempty"`
+	ConversationID string `json:"ConversationId,omitempty"`
+	CustomerID     string `json:"CustomerId"`
+	OrderID        int    `json:"OrderId,omitempty"`
+	Channel        string `json:"Channel"`
+	Status         string `json:"Status"`
+	Error          string `json:"Error,omitempty"`
+	Timestamp      string `json:"Timestamp"`
+	// Reply is the customer's reply on NotificationReplied events
+	Reply *LifecycleReply `json:"Reply,omitempty"`
+	// Region is the data region of a pinned tenant's notification. Its reply is only
+	// named by ID, and is read from the region.
+	Region string `json:"Region,omitempty"`
+}
+
+// LifecycleReply is a customer's reply to a notification, as carried by its lifecycle event
+type LifecycleReply struct {
+	ReplyID    string `json:"ReplyId"`
+	From       string `json:"From,omitempty"`
+	Subject    string `json:"Subject,omitempty"`
+	Text       string `json:"Text,omitempty"`
+	ReceivedAt string `json:"ReceivedAt"`
+}
+
+// EventHubProducer buffers outbound events per partition key and sends them in batches
+type EventHubProducer struct {
+	failover     *NamespaceFailover
+	eventHubName string
+
+	clientMutex sync.RWMutex
+	client      *azeventhubs.ProducerClient
+
+	batchSize     int
+	flushInterval time.Duration
+	maxRetries    int
+	maxPending    int
+
+	mutex   sync.Mutex
+	pending map[string][]*azeventhubs.EventData
+	// buffered counts the events in pending across every partition key
+	buffered int
+	// flushing holds a lock per partition key being flushed, so one key's events are
+	// sent by one flush at a time, in order
+	flushing map[string]*keyLock
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewEventHubProducer(cfg *config.Config) *EventHubProducer {
+	p := &EventHubProducer{
+		eventHubName:  cfg.EventHubProducerName,
+		batchSize:     cfg.ProducerBatchSize,
+		flushInterval: time.Duration(cfg.ProducerFlushIntervalMs) * time.Millisecond,
+		maxRetries:    cfg.ProducerMaxRetries,
+		maxPending:    cfg.ProducerMaxPending,
+		pending:       make(map[string][]*azeventhubs.EventData),
+		flushing:      make(map[string]*keyLock),
+	}
+	p.failover = NewNamespaceFailover("producer",
+		cfg.EventHubProducerConnectionString,
+		cfg.EventHubProducerSecondaryConnectionString,
+		cfg.EventHubFailoverThreshold,
+		time.Duration(cfg.EventHubFailbackProbeSeconds)*time.Second,
+		func(ctx context.Context, connectionString string) error {
+			client, err := azeventhubs.NewProducerClientFromConnectionString(connectionString, p.eventHubName, nil)
+			if err != nil {
+				return err
+			}
+			defer client.Close(ctx)
+			_, err = client.GetEventHubProperties(ctx, nil)
+			return err
+		},
+	)
+	p.failover.OnChange(func(ctx context.Context, _ NamespaceRole) { p.swapClient(ctx) })
+	return p
+}
+
+// Failover exposes the producer's namespace failover state
+func (p *EventHubProducer) Failover() *NamespaceFailover {
+	return p.failover
+}
+
+// Start creates the producer client and the time-based flush loop
+func (p *EventHubProducer) Start(ctx context.Context) error {
+	if p.failover.ConnectionString() == "" {
+		slog.InfoContext(ctx, "Event Hub producer connection string not configured, lifecycle events will not be published")
+		return nil
+	}
+
+	client, err := azeventhubs.NewProducerClientFromConnectionString(p.failover.ConnectionString(), p.eventHubName, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create Event Hub producer client: %w", err)
+	}
+	p.client = client
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+
+	go p.flushLoop(ctx)
+	p.failover.StartFailbackProbe(ctx)
+
+	slog.InfoContext(ctx, "✓ Event Hub producer started", "eventhub.name", p.eventHubName, "batch.size", p.batchSize, "flush.interval", p.flushInterval)
+	return nil
+}
+
+// Publish queues an event for the given partition key. Events sharing a key are
+// sent together, in order, when the batch fills up or the flush interval elapses.
+// While the producer holds its maximum of unsent events, the event is dropped and
+// ErrEventBufferFull returned.
+func (p *EventHubProducer) Publish(ctx context.Context, partitionKey string, event interface{}) error {
+	if p.currentClient() == nil {
+		return nil
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	// Propagate trace context the same way upstream producers do
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	properties := map[string]any{}
+	if traceparent, ok := carrier["traceparent"]; ok {
+		properties["traceparent"] = traceparent
+		properties["Diagnostic-Id"] = traceparent
+	}
+	if tracestate, ok := carrier["tracestate"]; ok {
+		properties["tracestate"] = tracestate
+	}
+
+	p.mutex.Lock()
+	if p.buffered >= p.maxPending {
+		p.mutex.Unlock()
+		telemetry.RecordEventHubEventDrop(ctx, p.eventHubName, "buffer_full")
+		return ErrEventBufferFull
+	}
+	p.pending[partitionKey] = append(p.pending[partitionKey], &azeventhubs.EventData{
+		Body:        body,
+		ContentType: to.Ptr("application/json"),
+		Properties:  properties,
+	})
+	p.buffered++
+	// Only the event that fills the batch starts a flush; the ones after it are picked
+	// up by that flush, the next full batch or the flush loop
+	full := len(p.pending[partitionKey]) == p.batchSize
+	p.mutex.Unlock()
+
+	// The flush outlives the caller's request but keeps its trace context
+	if full {
+		go p.flushKey(context.WithoutCancel(ctx), partitionKey)
+	}
+	return nil
+}
+
+// Flush sends everything currently buffered
+func (p *EventHubProducer) Flush(ctx context.Context) error {
+	p.mutex.Lock()
+	keys := make([]string, 0, len(p.pending))
+	for key := range p.pending {
+		keys = append(keys, key)
+	}
+	p.mutex.Unlock()
+
+	var errs []error
+	for _, key := range keys {
+		if err := p.flushKey(ctx, key); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close stops the flush loop, flushes remaining events and closes the client
+func (p *EventHubProducer) Close(ctx context.Context) error {
+	if p.currentClient() == nil {
+		return nil
+	}
+
+	close(p.stop)
+	<-p.done
+
+	if err := p.Flush(ctx); err != nil {
+		slog.ErrorContext(ctx, "Error flushing Event Hub producer", "error", err)
+	}
+	return p.currentClient().Close(ctx)
+}
+
+func (p *EventHubProducer) currentClient() *azeventhubs.ProducerClient {
+	p.clientMutex.RLock()
+	defer p.clientMutex.RUnlock()
+	return p.client
+}
+
+// swapClient replaces the producer client with one for the now-active namespace
+func (p *EventHubProducer) swapClient(ctx context.Context) {
+	client, err := azeventhubs.NewProducerClientFromConnectionString(p.failover.ConnectionString(), p.eventHubName, nil)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create Event Hub producer client after failover", "error", err)
+		return
+	}
+
+	p.clientMutex.Lock()
+	previous := p.client
+	p.client = client
+	p.clientMutex.Unlock()
+
+	if previous != nil {
+		previous.Close(ctx)
+	}
+}
+
+func (p *EventHubProducer) flushLoop(ctx context.Context) {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			if err := p.Flush(ctx); err != nil {
+				slog.ErrorContext(ctx, "Event Hub producer flush failed", "error", err)
+			}
+		}
+	}
+}
+
+// keyLock serializes the flushes of one partition key; it is dropped when no flush of the
+// key holds or waits for it
+type keyLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lockKey takes the flush lock of a partition key, returning the function that releases it
+func (p *EventHubProducer) lockKey(partitionKey string) func() {
+	p.mutex.Lock()
+	lock, ok := p.flushing[partitionKey]
+	if !ok {
+		lock = &keyLock{}
+		p.flushing[partitionKey] = lock
+	}
+	lock.refs++
+	p.mutex.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		p.mutex.Lock()
+		if lock.refs--; lock.refs == 0 {
+			delete(p.flushing, partitionKey)
+		}
+		p.mutex.Unlock()
+	}
+}
+
+// flushKey sends a partition key's buffered events in order. Events leave the buffer only
+// once their batch is sent, so a failed flush leaves them for the next one. An event too
+// large for a batch of its own can never be sent, and is dropped so it doesn't hold up
+// the events behind it.
+func (p *EventHubProducer) flushKey(ctx context.Context, partitionKey string) error {
+	unlock := p.lockKey(partitionKey)
+	defer unlock()
+
+	p.mutex.Lock()
+	// Publish only appends, and no other flush of the key runs, so these stay at the
+	// head of the buffer until they are sent
+	events := p.pending[partitionKey]
+	p.mutex.Unlock()
+
+	client := p.currentClient()
+	for len(events) > 0 {
+		batch, err := client.NewEventDataBatch(ctx, &azeventhubs.EventDataBatchOptions{
+			PartitionKey: to.Ptr(partitionKey),
+		})
+		if err != nil {
+			p.failover.ReportFailure(ctx, publishFailoverSource, err)
+			return fmt.Errorf("failed to create event batch: %w", err)
+		}
+
+		// Fill the batch until it runs out of room; the rest goes in the next one
+		added := 0
+		for _, event := range events {
+			if err := batch.AddEventData(event, nil); err != nil {
+				if !errors.Is(err, azeventhubs.ErrEventDataTooLarge) {
+					return fmt.Errorf("failed to add event to batch: %w", err)
+				}
+				if added > 0 {
+					break
+				}
+				slog.WarnContext(ctx, "Dropping Event Hub event too large for a batch", "eventhub.name", p.eventHubName, "event.size", len(event.Body))
+				telemetry.RecordEventHubEventDrop(ctx, p.eventHubName, "too_large")
+				events = events[1:]
+				p.trimPending(partitionKey, 1)
+				continue
+			}
+			added++
+		}
+		if added == 0 {
+			continue
+		}
+
+		if err := p.sendWithRetry(ctx, client, batch); err != nil {
+			return err
+		}
+		events = events[added:]
+		p.trimPending(partitionKey, added)
+	}
+	return nil
+}
+
+// trimPending removes the first n events of a partition key's buffer once they are sent
+// or dropped
+func (p *EventHubProducer) trimPending(partitionKey string, n int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if remaining := p.pending[partitionKey][n:]; len(remaining) > 0 {
+		p.pending[partitionKey] = remaining
+	} else {
+		delete(p.pending, partitionKey)
+	}
+	p.buffered -= n
+}
+
+// sendWithRetry sends a batch, backing off while the namespace is throttling (ServerBusy)
+// or the send fails for another transient reason
+func (p *EventHubProducer) sendWithRetry(ctx context.Context, client *azeventhubs.ProducerClient, batch *azeventhubs.EventDataBatch) error {
+	start := time.Now()
+	backoff := 500 * time.Millisecond
+
+	var err error
+	for attempt := 0; attempt <= p.maxRetries; attempt++ {
+		err = client.SendEventDataBatch(ctx, batch, nil)
+		if err == nil || !isTransientPublishError(err) {
+			break
+		}
+
+		slog.WarnContext(ctx, "Event Hub send failed, retrying batch", "error", err, "batch.size", batch.NumEvents(), "retry.delay", backoff, "retry.attempt", attempt+1, "retry.max", p.maxRetries)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+
+	telemetry.RecordEventHubPublish(ctx, p.eventHubName, int(batch.NumEvents()), err == nil, time.Since(start).Seconds())
+	if err != nil {
+		if !isServerBusy(err) {
+			p.failover.ReportFailure(ctx, publishFailoverSource, err)
+		}
+		return fmt.Errorf("failed to send event batch: %w", err)
+	}
+	p.failover.ReportSuccess(publishFailoverSource)
+	return nil
+}
+
+func isServerBusy(err error) bool {
+	var amqpErr *amqp.Error
+	return errors.As(err, &amqpErr) && amqpErr.Condition == "com.microsoft:server-busy"
+}
+
+// isTransientPublishError reports whether a send may succeed if tried again: throttling,
+// timeouts, a lost connection or a server-side error
+func isTransientPublishError(err error) bool {
+	if isServerBusy(err) {
+		return true
+	}
+	var eventHubErr *azeventhubs.Error
+	if errors.As(err, &eventHubErr) && eventHubErr.Code == azeventhubs.ErrorCodeConnectionLost {
+		return true
+	}
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) && (amqpErr.Condition == "com.microsoft:timeout" || amqpErr.Condition == amqp.ErrCondInternalError) {
+		return true
+	}
+	switch ClassifyError(err) {
+	case models.ErrorClassNetwork, models.ErrorClassThrottled, models.ErrorClassServer:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,275 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// defaultTenant holds deliveries made outside any tenant, such as digests
+const defaultTenant = "default"
+
+// Enrichment keys an enrichment stage can set to place an event's deliveries in a tenant
+const (
+	TenantIDEnrichment   = "tenant_id"
+	TenantTierEnrichment = "tenant_tier"
+)
+
+// tenantKey is the context key deliveries carry their tenant under
+type tenantKey struct{}
+
+type tenantContext struct {
+	id   string
+	tier string
+}
+
+// WithTenant attributes the deliveries made with ctx to a tenant. An empty tier is
+// looked up in TENANT_TIERS when the delivery is queued.
+func WithTenant(ctx context.Context, tenantID, tier string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantContext{id: tenantID, tier: tier})
+}
+
+func tenantFromContext(ctx context.Context) tenantContext {
+	tenant, _ := ctx.Value(tenantKey{}).(tenantContext)
+	if tenant.id == "" {
+		tenant.id = defaultTenant
+	}
+	return tenant
+}
+
+// FairDispatcher shares each channel's provider capacity across tenants so one tenant's
+// burst can't starve the rest. At most TENANT_FAIRNESS_MAX_IN_FLIGHT deliveries run per
+// channel and TENANT_MAX_IN_FLIGHT per tenant; when a slot frees, it goes to the waiting
+// tenant furthest behind its share, with shares weighted by tier (TENANT_TIER_WEIGHTS).
+// Limits apply per replica.
+type FairDispatcher struct {
+	enabled     bool
+	maxInFlight int
+	tenantCap   int
+	weights     map[string]float64
+	tiers       map[string]string
+	defaultTier string
+	starvation  time.Duration
+
+	mutex  sync.Mutex
+	queues map[models.NotificationType]*fairQueue
+}
+
+// fairQueue is one channel's start-time fair queue. Each grant advances the tenant's
+// virtual finish by 1/weight, and the waiter with the smallest virtual start goes next,
+// so over a backlog tenants are served in proportion to their weights. A tenant that
+// was idle starts at the queue's virtual time rather than cashing in the idle period.
+type fairQueue struct {
+	inFlight int
+	virtual  float64
+	tenants  map[string]*tenantQueue
+}
+
+type tenantQueue struct {
+	weight   float64
+	inFlight int
+	finish   float64
+	waiters  []*fairWaiter
+}
+
+type fairWaiter struct {
+	ready   chan struct{}
+	granted bool
+}
+
+func NewFairDispatcher(cfg *config.Config) *FairDispatcher {
+	d := &FairDispatcher{
+		enabled:     cfg.TenantFairnessEnabled,
+		maxInFlight: max(cfg.TenantFairnessMaxInFlight, 1),
+		tenantCap:   max(cfg.TenantMaxInFlight, 1),
+		weights:     make(map[string]float64),
+		tiers:       make(map[string]string),
+		defaultTier: cfg.TenantDefaultTier,
+		starvation:  time.Duration(cfg.TenantStarvationThresholdMs) * time.Millisecond,
+		queues:      make(map[models.NotificationType]*fairQueue),
+	}
+
+	for _, entry := range strings.Split(cfg.TenantTierWeights, ",") {
+		tier, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			continue
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || weight <= 0 {
+			slog.Warn("Ignoring TENANT_TIER_WEIGHTS entry: weight must be a positive number", "entry", entry)
+			continue
+		}
+		d.weights[strings.TrimSpace(tier)] = weight
+	}
+	for _, entry := range strings.Split(cfg.TenantTiers, ",") {
+		tenant, tier, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if ok {
+			d.tiers[strings.TrimSpace(tenant)] = strings.TrimSpace(tier)
+		}
+	}
+	if d.tenantCap > d.maxInFlight {
+		d.tenantCap = d.maxInFlight
+	}
+	return d
+}
+
+// Tier returns the tier a tenant's deliveries are weighted by
+func (d *FairDispatcher) Tier(tenantID, tier string) string {
+	if tier == "" {
+		tier = d.tiers[tenantID]
+	}
+	if _, ok := d.weights[tier]; !ok {
+		return d.defaultTier
+	}
+	return tier
+}
+
+func (d *FairDispatcher) weight(tier string) float64 {
+	if weight, ok := d.weights[tier]; ok {
+		return weight
+	}
+	return 1
+}
+
+// Acquire waits for a delivery slot on the channel for the tenant in ctx. The returned
+// release must be called once the provider call finishes. When fairness is disabled
+// every delivery proceeds immediately.
+func (d *FairDispatcher) Acquire(ctx context.Context, channel models.NotificationType) (func(), error) {
+	if d == nil || !d.enabled {
+		return func() {}, nil
+	}
+
+	tenant := tenantFromContext(ctx)
+	tier := d.Tier(tenant.id, tenant.tier)
+	waiter := &fairWaiter{ready: make(chan struct{})}
+
+	d.mutex.Lock()
+	queue := d.queues[channel]
+	if queue == nil {
+		queue = &fairQueue{tenants: make(map[string]*tenantQueue)}
+		d.queues[channel] = queue
+	}
+	tq := queue.tenants[tenant.id]
+	if tq == nil {
+		tq = &tenantQueue{weight: d.weight(tier), finish: queue.virtual}
+		queue.tenants[tenant.id] = tq
+	}
+	tq.waiters = append(tq.waiters, waiter)
+	d.dispatch(queue)
+	granted := waiter.granted
+	d.mutex.Unlock()
+
+	release := func() { d.release(queue, tenant.id) }
+	if granted {
+		return release, nil
+	}
+
+	start := time.Now()
+	telemetry.AddTenantQueueDepth(ctx, string(channel), tier, 1)
+	defer telemetry.AddTenantQueueDepth(ctx, string(channel), tier, -1)
+
+	select {
+	case <-waiter.ready:
+	case <-ctx.Done():
+		d.mutex.Lock()
+		if !waiter.granted {
+			d.abandon(queue, tenant.id, waiter)
+			d.mutex.Unlock()
+			return nil, ctx.Err()
+		}
+		d.mutex.Unlock()
+		// Granted just as ctx ended; hand the slot straight on
+		release()
+		return nil, ctx.Err()
+	}
+
+	wait := time.Since(start)
+	starved := d.starvation > 0 && wait >= d.starvation
+	telemetry.RecordTenantQueueWait(ctx, string(channel), tier, wait.Seconds(), starved)
+	trace.SpanFromContext(ctx).AddEvent("fairness.queue.wait", trace.WithAttributes(
+		attribute.String("notification.channel", string(channel)),
+		attribute.String("tenant.id", tenant.id),
+		attribute.String("tenant.tier", tier),
+		attribute.Int64("fairness.wait_ms", wait.Milliseconds()),
+		attribute.Bool("fairness.starved", starved),
+	))
+	if starved {
+		slog.WarnContext(ctx, "Delivery waited for a provider slot", "notification.channel", channel, "tenant.id", tenant.id, "tenant.tier", tier, "wait", wait.Round(time.Millisecond))
+	}
+	return release, nil
+}
+
+// dispatch grants free slots to waiting tenants under their in-flight cap, smallest
+// virtual start first. Callers hold the mutex.
+func (d *FairDispatcher) dispatch(queue *fairQueue) {
+	for queue.inFlight < d.maxInFlight {
+		var next *tenantQueue
+		nextStart := 0.0
+		for _, tq := range queue.tenants {
+			if len(tq.waiters) == 0 || tq.inFlight >= d.tenantCap {
+				continue
+			}
+			if start := max(tq.finish, queue.virtual); next == nil || start < nextStart {
+				next, nextStart = tq, start
+			}
+		}
+		if next == nil {
+			return
+		}
+
+		waiter := next.waiters[0]
+		next.waiters = next.waiters[1:]
+		next.finish = nextStart + 1/next.weight
+		next.inFlight++
+		queue.virtual = nextStart
+		queue.inFlight++
+		waiter.granted = true
+		close(waiter.ready)
+	}
+}
+
+// release frees a tenant's slot and hands it on
+func (d *FairDispatcher) release(queue *fairQueue, tenantID string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	queue.inFlight--
+	if tq := queue.tenants[tenantID]; tq != nil {
+		tq.inFlight--
+		d.forgetIdle(queue, tenantID, tq)
+	}
+	d.dispatch(queue)
+}
+
+// abandon removes a waiter whose context ended before it got a slot
+func (d *FairDispatcher) abandon(queue *fairQueue, tenantID string, waiter *fairWaiter) {
+	tq := queue.tenants[tenantID]
+	if tq == nil {
+		return
+	}
+	for i, w := range tq.waiters {
+		if w == waiter {
+			tq.waiters = append(tq.waiters[:i], tq.waiters[i+1:]...)
+			break
+		}
+	}
+	d.forgetIdle(queue, tenantID, tq)
+}
+
+// forgetIdle drops a tenant with nothing queued or running. It restarts at the queue's
+// virtual time when it comes back, forgiving at most one delivery's worth of its share.
+func (d *FairDispatcher) forgetIdle(queue *fairQueue, tenantID string, tq *tenantQueue) {
+	if tq.inFlight == 0 && len(tq.waiters) == 0 {
+		delete(queue.tenants, tenantID)
+	}
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+const (
+	// fallbackQueue holds the IDs of the fallbacks waiting for their customer
+	fallbackQueue = "routing-fallbacks"
+	// fallbackLease is how long a replica that stopped keeps a fallback from the others
+	fallbackLease = time.Minute
+	// fallbackPoll is how often each replica looks for due fallbacks
+	fallbackPoll = time.Second
+	// fallbackRetention is how long a fallback is kept past when it was due
+	fallbackRetention = time.Hour
+)
+
+func fallbackKey(id string) string {
+	return "routing-fallback:" + id
+}
+
+// FallbackJob is an order notification for an offline customer, waiting to go over
+// WebSocket should they come online, or else over the fallback channels
+type FallbackJob struct {
+	ID      string                  `json:"id"`
+	Event   *OrderEvent             `json:"event"`
+	Message models.WebSocketMessage `json:"message"`
+	// TraceContext continues the dispatch's trace when the fallback runs
+	TraceContext map[string]string `json:"trace_context,omitempty"`
+}
+
+// FallbackQueue holds fallbacks in Redis until they are due and runs them on at most
+// ROUTING_FALLBACK_WORKERS workers per replica. A fallback is claimed by one replica
+// under a lease, so a replica stopping mid-fallback doesn't lose it.
+type FallbackQueue struct {
+	redis   *RedisClient
+	queue   *workQueue
+	workers int
+}
+
+func NewFallbackQueue(cfg *config.Config, redis *RedisClient) *FallbackQueue {
+	return &FallbackQueue{
+		redis:   redis,
+		queue:   newWorkQueue(redis, fallbackQueue, fallbackLease),
+		workers: max(cfg.RoutingFallbackWorkers, 1),
+	}
+}
+
+// Schedule queues a fallback to run at due
+func (q *FallbackQueue) Schedule(ctx context.Context, job *FallbackJob, due time.Time) error {
+	job.ID = NewID()
+	job.TraceContext = propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.MapCarrier(job.TraceContext))
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal fallback: %w", err)
+	}
+
+	pipe := q.redis.client.TxPipeline()
+	pipe.Set(ctx, fallbackKey(job.ID), payload, time.Until(due)+fallbackRetention)
+	q.queue.Add(ctx, pipe, job.ID, due)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to schedule fallback: %w", err)
+	}
+	return nil
+}
+
+// Start runs due fallbacks with run until ctx is cancelled
+func (q *FallbackQueue) Start(ctx context.Context, run func(context.Context, *FallbackJob)) {
+	slots := make(chan struct{}, q.workers)
+	go func() {
+		ticker := time.NewTicker(fallbackPoll)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				free := q.workers - len(slots)
+				if free == 0 {
+					continue
+				}
+				ids, err := q.queue.Claim(ctx, int64(free))
+				if err != nil {
+					slog.WarnContext(ctx, "Failed to claim fallbacks", "error", err)
+					continue
+				}
+				for _, id := range ids {
+					slots <- struct{}{}
+					go func() {
+						defer func() { <-slots }()
+						q.run(ctx, id, run)
+					}()
+				}
+			}
+		}
+	}()
+}
+
+func (q *FallbackQueue) run(ctx context.Context, id string, run func(context.Context, *FallbackJob)) {
+	release := q.queue.Hold(ctx, id)
+	defer release()
+
+	payload, err := q.redis.client.Get(ctx, fallbackKey(id)).Bytes()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		slog.WarnContext(ctx, "Failed to load fallback", "fallback.id", id, "error", err)
+		if err := q.queue.Retry(ctx, id, time.Now().Add(fallbackPoll)); err != nil {
+			slog.WarnContext(ctx, "Failed to requeue fallback", "fallback.id", id, "error", err)
+		}
+		return
+	}
+	if err == nil {
+		var job FallbackJob
+		if err := json.Unmarshal(payload, &job); err != nil {
+			slog.ErrorContext(ctx, "Dropping undecodable fallback", "fallback.id", id, "error", err)
+		} else {
+			jobCtx := otel.GetTextMapPropagator().Extract(context.WithoutCancel(ctx), propagation.MapCarrier(job.TraceContext))
+			run(jobCtx, &job)
+		}
+	}
+
+	pipe := q.redis.client.TxPipeline()
+	pipe.Del(ctx, fallbackKey(id))
+	pipe.ZRem(ctx, q.queue.leases, id)
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.WarnContext(ctx, "Failed to finish fallback", "fallback.id", id, "error", err)
+	}
+}
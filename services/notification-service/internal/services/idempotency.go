@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	// ErrIdempotencyKeyReused is returned when a key comes back with a different request
+	ErrIdempotencyKeyReused = errors.New("idempotency key was already used for a different request")
+	// ErrIdempotencyInProgress is returned while the first request with a key is still being created
+	ErrIdempotencyInProgress = errors.New("a request with this idempotency key is still in progress")
+	// ErrIdempotencyUnavailable is returned when a key can't be checked, rather than
+	// risking a second send
+	ErrIdempotencyUnavailable = errors.New("idempotency keys are unavailable")
+)
+
+// idempotencyPendingTTL is how long a key is held for a notification that is still being
+// created; a request that stops before it is saved frees its key after this
+const idempotencyPendingTTL = time.Minute
+
+// idempotencyRecord is what an idempotency key claims: the notification created for it
+// and a fingerprint of the request that created it. A record is pending until the
+// notification is saved.
+type idempotencyRecord struct {
+	NotificationID string `json:"notification_id"`
+	Fingerprint    string `json:"fingerprint"`
+	Pending        bool   `json:"pending,omitempty"`
+}
+
+// IdempotencyStore dedupes notification creation by Idempotency-Key. A key is claimed in
+// Redis for a minute while its notification is created, and for IDEMPOTENCY_WINDOW_HOURS
+// once it is saved, scoped to the customer, so an upstream retry within the window gets
+// the original notification instead of a second send.
+type IdempotencyStore struct {
+	redis  *RedisClient
+	window time.Duration
+}
+
+func NewIdempotencyStore(cfg *config.Config, redis *RedisClient) *IdempotencyStore {
+	window := time.Duration(cfg.IdempotencyWindowHours) * time.Hour
+	if window <= 0 {
+		window = 24 * time.Hour
+	}
+	return &IdempotencyStore{redis: redis, window: window}
+}
+
+func idempotencyKey(customerID, key string) string {
+	return "idempotency:" + customerID + ":" + key
+}
+
+// Claim reserves key for notificationID while it is created. When the key is already
+// claimed it returns the notification created for it and false, ErrIdempotencyInProgress
+// while that notification is still being created, or ErrIdempotencyKeyReused when the
+// earlier request differs from req. It fails with ErrIdempotencyUnavailable when Redis
+// can't be reached.
+func (s *IdempotencyStore) Claim(ctx context.Context, req models.CreateNotificationRequest, notificationID string) (string, bool, error) {
+	fingerprint, err := requestFingerprint(req)
+	if err != nil {
+		return "", false, err
+	}
+	record, err := json.Marshal(idempotencyRecord{NotificationID: notificationID, Fingerprint: fingerprint, Pending: true})
+	if err != nil {
+		return "", false, err
+	}
+	key := idempotencyKey(req.CustomerID, req.IdempotencyKey)
+
+	// A claim can expire between SETNX and GET; the second round then claims the key
+	for attempt := 0; attempt < 2; attempt++ {
+		claimed, err := s.redis.client.SetNX(ctx, key, record, idempotencyPendingTTL).Result()
+		if err != nil {
+			return "", false, fmt.Errorf("%w: failed to claim key: %v", ErrIdempotencyUnavailable, err)
+		}
+		if claimed {
+			return notificationID, true, nil
+		}
+
+		data, err := s.redis.client.Get(ctx, key).Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return "", false, fmt.Errorf("%w: failed to read key: %v", ErrIdempotencyUnavailable, err)
+		}
+		var existing idempotencyRecord
+		if err := json.Unmarshal(data, &existing); err != nil {
+			return "", false, fmt.Errorf("failed to decode idempotency key: %w", err)
+		}
+		if existing.Fingerprint != fingerprint {
+			return "", false, ErrIdempotencyKeyReused
+		}
+		if existing.Pending {
+			return "", false, ErrIdempotencyInProgress
+		}
+		return existing.NotificationID, false, nil
+	}
+	return "", false, fmt.Errorf("failed to claim idempotency key %q", req.IdempotencyKey)
+}
+
+// Complete holds a claimed key for the rest of the window once its notification is
+// saved. A claim taken over by another notification is left alone.
+func (s *IdempotencyStore) Complete(ctx context.Context, req models.CreateNotificationRequest, notificationID string) error {
+	key := idempotencyKey(req.CustomerID, req.IdempotencyKey)
+	return watchKey(ctx, s.redis.client, key, func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, key).Bytes()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return err
+		}
+		var existing idempotencyRecord
+		if err == nil {
+			if err := json.Unmarshal(data, &existing); err != nil || existing.NotificationID != notificationID {
+				return nil
+			}
+		} else {
+			// The pending claim lapsed before the save finished; take the key back
+			if existing.Fingerprint, err = requestFingerprint(req); err != nil {
+				return err
+			}
+			existing.NotificationID = notificationID
+		}
+		existing.Pending = false
+		record, err := json.Marshal(existing)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, record, s.window)
+			return nil
+		})
+		return err
+	})
+}
+
+// Release gives up a claim whose notification was never created, or no longer exists,
+// so the request can be retried with the same key. A claim taken over by another notification is left alone.
+func (s *IdempotencyStore) Release(ctx context.Context, req models.CreateNotificationRequest, notificationID string) error {
+	key := idempotencyKey(req.CustomerID, req.IdempotencyKey)
+	return watchKey(ctx, s.redis.client, key, func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, key).Bytes()
+		if errors.Is(err, redis.Nil) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var existing idempotencyRecord
+		if err := json.Unmarshal(data, &existing); err != nil || existing.NotificationID != notificationID {
+			return nil
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, key)
+			return nil
+		})
+		return err
+	})
+}
+
+// requestFingerprint hashes the request without its key; map keys marshal in sorted
+// order, so equal requests hash alike
+func requestFingerprint(req models.CreateNotificationRequest) (string, error) {
+	req.IdempotencyKey = ""
+	data, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fingerprint request: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,387 @@
+package services
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"notification-service/internal/models"
+	"notification-service/internal/storage"
+
+	"github.com/gorilla/websocket"
+)
+
+// NotificationManager is the notification persistence and lifecycle API used by handlers
+type NotificationManager interface {
+	SaveNotification(ctx context.Context, notification *models.Notification) (bool, error)
+	GetNotification(ctx context.Context, id string) (*models.Notification, error)
+	ListNotifications(ctx context.Context, filter storage.NotificationFilter) ([]*models.Notification, string, error)
+	CountNotifications(ctx context.Context, filter storage.NotificationFilter) (int64, error)
+	UpdateNotificationStatus(ctx context.Context, id string, req models.UpdateNotificationStatusRequest) (*models.Notification, error)
+	DeleteNotification(ctx context.Context, id string) error
+	UpdateNotification(ctx context.Context, id string, expectedVersion int, patch models.NotificationPatchRequest, editedBy string) (*models.Notification, error)
+	NotificationEdits(ctx context.Context, id string) ([]models.NotificationEdit, error)
+	CancelNotification(ctx context.Context, id string) (*models.CancelResult, error)
+	CancelOrderNotifications(ctx context.Context, orderID string) ([]*models.CancelResult, error)
+	CancellationStats(ctx context.Context) (map[string]int64, error)
+	ReplacementStats(ctx context.Context) (map[string]int64, error)
+	FindNotificationsByMetadata(ctx context.Context, tenantID string, filters map[string]string, limit int) ([]*models.Notification, error)
+	PublishLifecycleEvent(ctx context.Context, event LifecycleEvent) error
+	EventHubFailoverStatus() []FailoverStatus
+}
+
+// ChannelSender delivers a notification over one channel (email, SMS, push, webhook, Teams)
+type ChannelSender interface {
+	Send(ctx context.Context, notification *models.Notification) error
+}
+
+// WebhookPoster posts to webhook URLs the operator configured, rather than the
+// endpoint a customer registered
+type WebhookPoster interface {
+	SendTo(ctx context.Context, notification *models.Notification, target string) error
+}
+
+// RealtimeHub delivers messages to connected WebSocket clients
+type RealtimeHub interface {
+	SendToCustomer(ctx context.Context, customerID string, message interface{}) error
+	BroadcastToAll(ctx context.Context, message interface{}) error
+	BroadcastMessage(ctx context.Context, messageType string, message interface{}) error
+	GetActiveConnections() int
+	Serve(ctx context.Context, conn *websocket.Conn, customerID, userAgent, ipAddress string, resume models.ResumeRequest)
+}
+
+// TemplateManager is the template CRUD and publishing workflow API used by handlers
+type TemplateManager interface {
+	Create(ctx context.Context, req models.TemplateRequest) (*models.NotificationTemplate, error)
+	Get(ctx context.Context, id string) (*models.NotificationTemplate, error)
+	List(ctx context.Context) ([]*models.NotificationTemplate, error)
+	Update(ctx context.Context, id string, req models.TemplateRequest, ifMatch string) (*models.NotificationTemplate, error)
+	Delete(ctx context.Context, id string, ifMatch string) error
+	RequestPublish(ctx context.Context, id string) (*models.NotificationTemplate, error)
+	Decide(ctx context.Context, id string, decision models.TemplateApprovalRequest) (*models.NotificationTemplate, error)
+	Rollback(ctx context.Context, id string, version int) (*models.NotificationTemplate, error)
+	Versions(ctx context.Context, id string) ([]*models.TemplateVersion, error)
+	Version(ctx context.Context, id string, number int) (*models.TemplateVersion, error)
+	Diff(ctx context.Context, id string, from, to int) (*models.TemplateDiff, error)
+	ValidateNotification(ctx context.Context, notification *models.Notification) error
+	RenderNotification(ctx context.Context, notification *models.Notification) error
+}
+
+// BroadcastManager is the broadcast submission and approval API used by handlers
+type BroadcastManager interface {
+	Submit(ctx context.Context, req models.BroadcastNotificationRequest, requestedBy string) (*models.BroadcastJob, error)
+	Get(ctx context.Context, id string) (*models.BroadcastJob, error)
+	Approve(ctx context.Context, id, approver, comment string) (*models.BroadcastJob, error)
+	Reject(ctx context.Context, id, approver, comment string) (*models.BroadcastJob, error)
+	AuditTrail(ctx context.Context, id string) ([]models.BroadcastAuditRecord, error)
+}
+
+// DigestManager compiles and sends operational digests
+type DigestManager interface {
+	Compile(ctx context.Context, period DigestPeriod) (*Digest, error)
+	Send(ctx context.Context, period DigestPeriod) (*Digest, error)
+}
+
+// PresenceTracker reports customers' WebSocket presence across replicas
+type PresenceTracker interface {
+	Presence(ctx context.Context, customerID string) (*models.CustomerPresence, error)
+	IsOnline(ctx context.Context, customerID string) (bool, error)
+	ClusterConnections(ctx context.Context) (int, error)
+}
+
+// FallbackScheduler holds order notifications for offline customers until their
+// fallback is due
+type FallbackScheduler interface {
+	Schedule(ctx context.Context, job *FallbackJob, due time.Time) error
+}
+
+// EngagementRecorder appends engagement events and queries the engagement stream
+type EngagementRecorder interface {
+	Record(ctx context.Context, req models.RecordEngagementEventRequest) (*models.EngagementEvent, error)
+	Events(ctx context.Context, filter storage.EngagementFilter) ([]*models.EngagementEvent, string, error)
+	Rollup(ctx context.Context, filter storage.EngagementFilter) ([]models.EngagementRollup, error)
+	Metrics(ctx context.Context, filter storage.EngagementFilter, channel models.NotificationType) (*models.EngagementMetrics, error)
+}
+
+// ProviderPayloadReader returns the provider exchanges captured for sampled notifications
+type ProviderPayloadReader interface {
+	ProviderPayloads(ctx context.Context, notificationID string) ([]models.ProviderExchange, error)
+}
+
+// WebhookDeliveryReporter serves webhook attempt history and delivery totals
+type WebhookDeliveryReporter interface {
+	WebhookAttempts(ctx context.Context, notificationID string) ([]models.WebhookAttempt, error)
+	WebhookStats(ctx context.Context) (*models.WebhookStats, error)
+}
+
+// MetadataIndexManager registers the notification metadata keys each tenant gets secondary
+// indexes for
+type MetadataIndexManager interface {
+	IndexedKeys(ctx context.Context, tenantID string) ([]string, error)
+	RegisterKey(ctx context.Context, tenantID, key string) ([]string, error)
+	UnregisterKey(ctx context.Context, tenantID, key string) ([]string, error)
+}
+
+// UsageReporter serves recorded per-API-key usage
+type UsageReporter interface {
+	APIKeys(ctx context.Context) ([]string, error)
+	UsageSeries(ctx context.Context, keyID string, from, to time.Time, resolution string) ([]models.UsageBucket, error)
+}
+
+// RetryPolicyManager serves and overrides the per-channel delivery retry policies
+type RetryPolicyManager interface {
+	Policies(ctx context.Context) ([]models.RetryPolicy, error)
+	SetPolicy(ctx context.Context, policy models.RetryPolicy) (models.RetryPolicy, error)
+	ResetPolicy(ctx context.Context, channel models.NotificationType) error
+}
+
+// ProviderThrottleReporter serves the channels currently slowed down by provider throttling
+type ProviderThrottleReporter interface {
+	Throttles(ctx context.Context) ([]models.ProviderThrottleState, error)
+}
+
+// ContentScreener decides whether rendered notification content may be sent. The
+// built-in heuristic and the HTTP hook implement it; other screeners plug in the same way.
+type ContentScreener interface {
+	Screen(ctx context.Context, req models.ScreeningRequest) (models.ScreeningDecision, error)
+}
+
+// LanguageDetector detects the language of a text, returning its ISO 639-1 code and a
+// confidence between 0 and 1; an empty language means none was recognised
+type LanguageDetector interface {
+	Detect(ctx context.Context, text string) (models.LanguageDetection, error)
+}
+
+// Translator translates HTML texts from one language to another, returning them in order
+type Translator interface {
+	Translate(ctx context.Context, texts []string, from, to string) ([]string, error)
+}
+
+// SendTimeScheduler picks send times from customers' engagement history and reports
+// how the optimized variant compares with immediate sending
+type SendTimeScheduler interface {
+	Schedule(ctx context.Context, notification *models.Notification, optimize *bool)
+	Record(ctx context.Context, notification *models.Notification)
+	Profile(ctx context.Context, customerID string) (models.SendTimeProfile, error)
+	Stats(ctx context.Context) ([]models.SendTimeVariantStats, error)
+}
+
+// DeadLetterManager captures notifications whose delivery failed for good and lets
+// operators inspect, re-drive or discard them
+type DeadLetterManager interface {
+	Capture(ctx context.Context, notification *models.Notification, reason string, deliveryErr error, attempts int) (*models.DeadLetter, error)
+	List(ctx context.Context, cursor string, limit int) ([]*models.DeadLetter, string, error)
+	Count(ctx context.Context) (int64, error)
+	Get(ctx context.Context, id string) (*models.DeadLetter, error)
+	Discard(ctx context.Context, id string) error
+	Redrive(ctx context.Context, id string, channel models.NotificationType) (*models.DeadLetter, error)
+}
+
+// RetryScheduler gives notifications their retry budget by priority and reports the
+// per-priority retry policies
+type RetryScheduler interface {
+	MaxRetries(priority models.Priority) int
+	PriorityPolicies() []models.PriorityRetryPolicy
+}
+
+// PreferenceEnforcer stores customer preferences and decides whether a notification may
+// be sent now, later, or not at all
+type PreferenceEnforcer interface {
+	Preferences(ctx context.Context, customerID string) (*models.CustomerPreferences, error)
+	SetPreferences(ctx context.Context, preferences *models.CustomerPreferences, ifMatch string) (*models.CustomerPreferences, error)
+	Check(ctx context.Context, notification *models.Notification, at time.Time) models.PreferenceDecision
+	ScheduleLocal(ctx context.Context, notification *models.Notification) error
+	LocalTime(ctx context.Context, customerID string, at time.Time) time.Time
+	Address(ctx context.Context, notification *models.Notification) error
+}
+
+// PayloadLogManager serves and overrides the HTTP payload logging settings
+type PayloadLogManager interface {
+	Settings(ctx context.Context) models.PayloadLogSettings
+	SetSettings(ctx context.Context, settings models.PayloadLogSettings) (models.PayloadLogSettings, error)
+	ResetSettings(ctx context.Context) error
+}
+
+// DeliveryStatsProvider serves delivery statistics computed from stored notifications
+type DeliveryStatsProvider interface {
+	DeliveryStats(ctx context.Context, timeRange string) (models.DeliveryStats, error)
+}
+
+// PreferenceTransfer imports and exports customer preferences in bulk as CSV or JSONL
+type PreferenceTransfer interface {
+	ImportPreferences(ctx context.Context, tenantID, format string, r io.Reader) (*models.PreferenceImportReport, error)
+	ExportPreferences(ctx context.Context, tenantID, format string, w io.Writer) (int, error)
+}
+
+// LinkVerifier checks the signatures of email open and click tracking URLs
+type LinkVerifier interface {
+	VerifyOpen(notificationID, signature string) bool
+	VerifyClick(notificationID, target, signature string) bool
+}
+
+// SigningKeyManager administers the signing keys and publishes their JWKS
+type SigningKeyManager interface {
+	List(ctx context.Context) ([]models.SigningKey, error)
+	Get(ctx context.Context, id string) (*models.SigningKey, error)
+	Create(ctx context.Context, req models.SigningKeyRequest) (*models.SigningKey, error)
+	Update(ctx context.Context, id string, req models.SigningKeyRequest) (*models.SigningKey, error)
+	Delete(ctx context.Context, id string) error
+	Rotate(ctx context.Context) (*models.SigningKey, error)
+	JWKS(ctx context.Context) (models.JWKSet, error)
+}
+
+// IdempotencyGuard claims Idempotency-Keys so retried creates return the original notification
+type IdempotencyGuard interface {
+	Claim(ctx context.Context, req models.CreateNotificationRequest, notificationID string) (string, bool, error)
+	Complete(ctx context.Context, req models.CreateNotificationRequest, notificationID string) error
+	Release(ctx context.Context, req models.CreateNotificationRequest, notificationID string) error
+}
+
+// DuplicateGuard claims notification content hashes so repeated sends are suppressed
+type DuplicateGuard interface {
+	Claim(ctx context.Context, notification *models.Notification, dedupe *bool) (string, bool, error)
+	Release(ctx context.Context, notification *models.Notification) error
+	Takeover(ctx context.Context, notification *models.Notification, original string) (bool, error)
+}
+
+// RedriveManager is the bulk re-drive API used by handlers
+type RedriveManager interface {
+	Submit(ctx context.Context, req models.NotificationRedriveRequest, requestedBy string) (*models.RedriveJob, error)
+	Get(ctx context.Context, id string) (*models.RedriveJob, error)
+	Confirm(ctx context.Context, id, confirmedBy string, matched int) (*models.RedriveJob, error)
+}
+
+// AnnouncementManager schedules, lists and ends system announcements
+type AnnouncementManager interface {
+	Create(ctx context.Context, req models.CreateAnnouncementRequest, createdBy string) (*models.Announcement, error)
+	List(ctx context.Context, includeScheduled bool) ([]*models.Announcement, error)
+	End(ctx context.Context, id string) (*models.Announcement, error)
+}
+
+// ReadinessProber reports whether the service's dependencies are usable
+type ReadinessProber interface {
+	Check(ctx context.Context) models.ReadinessReport
+}
+
+// TestSender sends operator test notifications straight to a channel
+type TestSender interface {
+	Send(ctx context.Context, req models.TestSendRequest, requestedBy string) (*models.TestSendResult, error)
+}
+
+// DeviceManager registers customers' push devices and picks the ones a notification targets
+type DeviceManager interface {
+	Register(ctx context.Context, customerID, deviceID string, req models.RegisterDeviceRequest) (*models.Device, bool, error)
+	List(ctx context.Context, customerID string, platform models.DevicePlatform) ([]*models.Device, error)
+	Delete(ctx context.Context, customerID, deviceID string) error
+	Resolve(ctx context.Context, customerID string, target *models.DeviceTarget) ([]*models.Device, error)
+}
+
+// RecipientValidator normalizes the email and SMS recipients of new notifications
+type RecipientValidator interface {
+	Normalize(notification *models.Notification) error
+}
+
+// BlackoutManager holds notifications through their tenant's blackout windows and
+// manages the calendars
+type BlackoutManager interface {
+	Hold(ctx context.Context, notification *models.Notification, due time.Time) *time.Time
+	RecordDeferral(ctx context.Context, notification *models.Notification)
+	Calendars(ctx context.Context) ([]*models.BlackoutStatus, error)
+	Calendar(ctx context.Context, tenantID string) (*models.BlackoutStatus, error)
+	SetCalendar(ctx context.Context, tenantID string, windows []models.BlackoutWindow) (*models.BlackoutCalendar, error)
+	DeleteCalendar(ctx context.Context, tenantID string) error
+}
+
+// SMSConsentManager processes the keywords customers reply to SMS with and counts them
+type SMSConsentManager interface {
+	HandleInbound(ctx context.Context, message models.InboundSMS) (*models.SMSKeywordResult, error)
+	SMSConsentStats(ctx context.Context, timeRange string) (models.SMSConsentStats, error)
+}
+
+// EmailReplyManager matches inbound emails to the notifications they reply to and lists
+// the replies to a notification
+type EmailReplyManager interface {
+	HandleInbound(ctx context.Context, email models.InboundEmail) (*models.InboundEmailResult, error)
+	Replies(ctx context.Context, notificationID string) ([]*models.EmailReply, error)
+}
+
+// CustomerDigestManager batches customers' low-priority notifications into digests and
+// lists or flushes a customer's open ones
+type CustomerDigestManager interface {
+	Hold(ctx context.Context, notification *models.Notification, digest *bool) bool
+	Pending(ctx context.Context, customerID string) ([]*models.CustomerDigest, error)
+	Flush(ctx context.Context, customerID string) ([]*models.CustomerDigest, error)
+}
+
+// ConversationReader assembles the cross-channel thread of a conversation
+type ConversationReader interface {
+	Conversation(ctx context.Context, id string) (*models.Conversation, error)
+}
+
+// ResidencyReporter reports the data regions tenants are pinned to
+type ResidencyReporter interface {
+	Status(ctx context.Context) models.DataResidency
+}
+
+// ProviderRoutingReporter reports the health and routing weights of the providers of
+// channels that have more than one
+type ProviderRoutingReporter interface {
+	Status() []models.ProviderRoute
+}
+
+var (
+	_ NotificationManager      = (*NotificationService)(nil)
+	_ ChannelSender            = (*EmailService)(nil)
+	_ ChannelSender            = (*SMSService)(nil)
+	_ ChannelSender            = (*PushNotificationService)(nil)
+	_ ChannelSender            = (*WebhookService)(nil)
+	_ WebhookPoster            = (*WebhookService)(nil)
+	_ ChannelSender            = (*TeamsService)(nil)
+	_ RealtimeHub              = (*models.Hub)(nil)
+	_ TemplateManager          = (*TemplateService)(nil)
+	_ BroadcastManager         = (*BroadcastService)(nil)
+	_ DigestManager            = (*DigestService)(nil)
+	_ UsageReporter            = (*UsageTracker)(nil)
+	_ MetadataIndexManager     = (*MetadataIndex)(nil)
+	_ PresenceTracker          = (*PresenceService)(nil)
+	_ FallbackScheduler        = (*FallbackQueue)(nil)
+	_ EngagementRecorder       = (*EngagementService)(nil)
+	_ ProviderPayloadReader    = (*ProviderPayloadSampler)(nil)
+	_ WebhookDeliveryReporter  = (*WebhookService)(nil)
+	_ RetryPolicyManager       = (*RetryPolicies)(nil)
+	_ PayloadLogManager        = (*PayloadLogger)(nil)
+	_ PreferenceTransfer       = (*CustomerPreferenceService)(nil)
+	_ LinkVerifier             = (*LinkTracker)(nil)
+	_ DeliveryStatsProvider    = (*DeliveryAnalytics)(nil)
+	_ ProviderThrottleReporter = (*ProviderThrottle)(nil)
+	_ ContentScreener          = (*ContentScreening)(nil)
+	_ ContentScreener          = (*HookScreener)(nil)
+	_ ContentScreener          = (*HeuristicScreener)(nil)
+	_ ContentScreener          = (*ContentSafetyScreener)(nil)
+	_ LanguageDetector         = (*HeuristicLanguageDetector)(nil)
+	_ LanguageDetector         = (*AzureLanguageDetector)(nil)
+	_ Translator               = (*AzureTranslator)(nil)
+	_ SendTimeScheduler        = (*SendTimeOptimizer)(nil)
+	_ models.MessageBuffer     = (*WebSocketMessageBuffer)(nil)
+	_ DeadLetterManager        = (*DeadLetterQueue)(nil)
+	_ RetryScheduler           = (*RetryOrchestrator)(nil)
+	_ PreferenceEnforcer       = (*CustomerPreferenceService)(nil)
+	_ SigningKeyManager        = (*SigningKeyService)(nil)
+	_ models.MessageSigner     = (*SigningKeyService)(nil)
+	_ IdempotencyGuard         = (*IdempotencyStore)(nil)
+	_ DuplicateGuard           = (*ContentDeduper)(nil)
+	_ RedriveManager           = (*RedriveService)(nil)
+	_ AnnouncementManager      = (*AnnouncementService)(nil)
+	_ ReadinessProber          = (*ReadinessChecker)(nil)
+	_ TestSender               = (*TestSendService)(nil)
+	_ DeviceManager            = (*DeviceRegistry)(nil)
+	_ SMSConsentManager        = (*SMSConsentService)(nil)
+	_ RecipientValidator       = (*RecipientNormalizer)(nil)
+	_ BlackoutManager          = (*BlackoutCalendars)(nil)
+	_ EmailReplyManager        = (*EmailReplyService)(nil)
+	_ ConversationReader       = (*ConversationService)(nil)
+	_ CustomerDigestManager    = (*CustomerDigests)(nil)
+	_ ResidencyReporter        = (*DataResidency)(nil)
+	_ ChannelSender            = (*ProviderRouter)(nil)
+	_ ProviderRoutingReporter  = (*ProviderRouting)(nil)
+)
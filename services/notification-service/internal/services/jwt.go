@@ -0,0 +1,411 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+)
+
+// ErrInvalidToken is returned for bearer tokens that fail verification
+var ErrInvalidToken = errors.New("invalid token")
+
+// JWT verification timing
+const (
+	jwtClockSkew        = time.Minute
+	jwksRefreshInterval = time.Hour
+	jwksMinRefresh      = 30 * time.Second
+)
+
+// JWTVerifier validates bearer tokens issued by Azure AD or any OIDC provider. RS256-512
+// and ES256/384 tokens are checked against the issuer's JWKS, found through OIDC
+// discovery unless AUTH_JWKS_URL is set; HS256 tokens against AUTH_HS256_SECRET. Every
+// token must name an AUTH_ISSUER issuer and the AUTH_AUDIENCE audience. The JWKS is
+// fetched outside the lock, one fetch at a time, so verifications with known keys don't
+// wait on it.
+type JWTVerifier struct {
+	issuers       []string
+	audience      string
+	jwksURL       string
+	hmacSecret    []byte
+	customerClaim string
+	rolesClaim    string
+	client        *http.Client
+
+	mutex       sync.Mutex
+	keys        map[string]crypto.PublicKey
+	refreshedAt time.Time
+	// refreshing is closed when the JWKS fetch in flight completes; nil when none is
+	refreshing chan struct{}
+}
+
+func NewJWTVerifier(cfg *config.Config) *JWTVerifier {
+	var issuers []string
+	for _, issuer := range strings.Split(cfg.AuthIssuer, ",") {
+		if issuer = strings.TrimSpace(issuer); issuer != "" {
+			issuers = append(issuers, issuer)
+		}
+	}
+	return &JWTVerifier{
+		issuers:       issuers,
+		audience:      cfg.AuthAudience,
+		jwksURL:       cfg.AuthJWKSURL,
+		hmacSecret:    []byte(cfg.AuthHS256Secret),
+		customerClaim: cfg.AuthCustomerClaim,
+		rolesClaim:    cfg.AuthRolesClaim,
+		client:        NewHTTPClient(cfg, ProviderOIDC, 10*time.Second),
+		keys:          make(map[string]crypto.PublicKey),
+	}
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Verify checks a compact JWT's signature and its exp, nbf, iss and aud claims, and
+// returns the caller's identity
+func (v *JWTVerifier) Verify(ctx context.Context, token string) (*models.Identity, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidToken)
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrInvalidToken, err)
+	}
+	if err := v.verifySignature(ctx, header, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+	}
+	return v.identity(claims)
+}
+
+func (v *JWTVerifier) verifySignature(ctx context.Context, header jwtHeader, signed string, signature []byte) error {
+	switch header.Alg {
+	case "HS256":
+		if len(v.hmacSecret) == 0 {
+			return fmt.Errorf("%w: HS256 tokens are not accepted", ErrInvalidToken)
+		}
+		mac := hmac.New(sha256.New, v.hmacSecret)
+		mac.Write([]byte(signed))
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+		return nil
+	case "RS256", "RS384", "RS512", "ES256", "ES384":
+	default:
+		return fmt.Errorf("%w: unsupported alg %q", ErrInvalidToken, header.Alg)
+	}
+
+	key, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return err
+	}
+	hashFunc, newHash := jwtHash(header.Alg)
+	digest := newHash()
+	digest.Write([]byte(signed))
+	sum := digest.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(header.Alg, "RS") || rsa.VerifyPKCS1v15(key, hashFunc, sum, signature) != nil {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(header.Alg, "ES") || len(signature) != 2*size {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, sum, r, s) {
+			return fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported key type", ErrInvalidToken)
+	}
+	return nil
+}
+
+func jwtHash(alg string) (crypto.Hash, func() hash.Hash) {
+	switch alg[2:] {
+	case "384":
+		return crypto.SHA384, sha512.New384
+	case "512":
+		return crypto.SHA512, sha512.New
+	default:
+		return crypto.SHA256, sha256.New
+	}
+}
+
+// identity validates the registered claims and maps the rest onto an identity. The
+// customer ID comes from AUTH_CUSTOMER_CLAIM, falling back to the subject.
+func (v *JWTVerifier) identity(claims map[string]interface{}) (*models.Identity, error) {
+	now := time.Now()
+	exp, ok := numericClaim(claims, "exp")
+	if !ok {
+		return nil, fmt.Errorf("%w: missing exp", ErrInvalidToken)
+	}
+	if now.After(exp.Add(jwtClockSkew)) {
+		return nil, fmt.Errorf("%w: token expired", ErrInvalidToken)
+	}
+	if nbf, ok := numericClaim(claims, "nbf"); ok && now.Add(jwtClockSkew).Before(nbf) {
+		return nil, fmt.Errorf("%w: token not yet valid", ErrInvalidToken)
+	}
+
+	// Without a configured issuer and audience any token signed by an accepted key would
+	// pass, so none does
+	issuer, _ := claims["iss"].(string)
+	if len(v.issuers) == 0 {
+		return nil, fmt.Errorf("%w: AUTH_ISSUER is not set", ErrInvalidToken)
+	}
+	if !containsString(v.issuers, issuer) {
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidToken, issuer)
+	}
+	if v.audience == "" {
+		return nil, fmt.Errorf("%w: AUTH_AUDIENCE is not set", ErrInvalidToken)
+	}
+	if !containsString(stringsClaim(claims, "aud"), v.audience) {
+		return nil, fmt.Errorf("%w: token is not for audience %q", ErrInvalidToken, v.audience)
+	}
+
+	subject, _ := claims["sub"].(string)
+	customerID, _ := claims[v.customerClaim].(string)
+	if customerID == "" {
+		customerID = subject
+	}
+	tenantID, _ := claims["tid"].(string)
+	return &models.Identity{
+		Subject:    subject,
+		CustomerID: customerID,
+		Roles:      stringsClaim(claims, v.rolesClaim),
+		Issuer:     issuer,
+		TenantID:   tenantID,
+		ExpiresAt:  exp.UTC(),
+	}, nil
+}
+
+// key returns the signing key with kid, refreshing the JWKS when the key is unknown
+// (as after a rotation) or the keys are over an hour old. A known key is returned at
+// once, while a refresh of old keys runs in the background; an unknown one waits for the
+// refresh.
+func (v *JWTVerifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mutex.Lock()
+	key, ok := v.keys[kid]
+	refreshed := v.refreshing
+	stale := time.Since(v.refreshedAt) > jwksRefreshInterval
+	if refreshed == nil && (stale || !ok && time.Since(v.refreshedAt) > jwksMinRefresh) {
+		refreshed = v.refreshLocked(ctx)
+	}
+	v.mutex.Unlock()
+
+	if !ok && refreshed != nil {
+		select {
+		case <-refreshed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		v.mutex.Lock()
+		key, ok = v.keys[kid]
+		v.mutex.Unlock()
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidToken, kid)
+	}
+	return key, nil
+}
+
+// refreshLocked starts fetching the JWKS and returns a channel closed once the keys are
+// replaced, or kept when the fetch fails. The fetch outlives the request that started
+// it, as others may be waiting on it. The caller holds the mutex.
+func (v *JWTVerifier) refreshLocked(ctx context.Context) chan struct{} {
+	done := make(chan struct{})
+	v.refreshing = done
+	v.refreshedAt = time.Now()
+	go func() {
+		defer close(done)
+		keys, err := v.fetchKeys(context.WithoutCancel(ctx))
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to refresh JWKS", "error", err)
+		}
+
+		v.mutex.Lock()
+		defer v.mutex.Unlock()
+		if err == nil {
+			v.keys = keys
+		}
+		v.refreshing = nil
+	}()
+	return done
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetchKeys reads the signing keys from the JWKS
+func (v *JWTVerifier) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	jwksURL := v.jwksURL
+	if jwksURL == "" {
+		if len(v.issuers) == 0 {
+			return nil, errors.New("neither AUTH_JWKS_URL nor AUTH_ISSUER is set")
+		}
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := v.getJSON(ctx, strings.TrimSuffix(v.issuers[0], "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+		}
+		jwksURL = discovery.JWKSURI
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := v.getJSON(ctx, jwksURL, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			slog.WarnContext(ctx, "Skipping JWKS key", "key.id", k.Kid, "error", err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func (v *JWTVerifier) getJSON(ctx context.Context, url string, target interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{StatusCode: resp.StatusCode}
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(target)
+}
+
+func decodeSegment(segment string, target interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
+
+func numericClaim(claims map[string]interface{}, name string) (time.Time, bool) {
+	value, ok := claims[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(value), 0), true
+}
+
+// stringsClaim reads a claim that may be a single string or an array of strings
+func stringsClaim(claims map[string]interface{}, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
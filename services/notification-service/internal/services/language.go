@@ -0,0 +1,384 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"notification-service/internal/cache"
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Language detection modes
+const (
+	LanguageDetectionOff       = "off"
+	LanguageDetectionHeuristic = "heuristic"
+	LanguageDetectionAzure     = "azure_language"
+)
+
+// Where a customer's language came from
+const (
+	LanguageSourcePreference = "preference"
+	LanguageSourceDetected   = "detected"
+	LanguageSourceDefault    = "default"
+)
+
+// errLowConfidence keeps detections below the confidence threshold out of the cache, so
+// the customer's next content is tried again
+var errLowConfidence = errors.New("language detection confidence below threshold")
+
+// LanguageResolver works out which language to address a customer in: the language in
+// their preferences, or else the language detected in their content. Detections that
+// reach LANGUAGE_MIN_CONFIDENCE are cached per customer for
+// LANGUAGE_DETECTION_TTL_HOURS, in Redis so every replica shares them.
+type LanguageResolver struct {
+	redis         *RedisClient
+	preferences   *CustomerPreferenceService
+	detector      LanguageDetector
+	name          string
+	minConfidence float64
+	detections    *cache.Cache[models.LanguageDetection]
+}
+
+func NewLanguageResolver(cfg *config.Config, redisClient *RedisClient, preferences *CustomerPreferenceService) *LanguageResolver {
+	resolver := &LanguageResolver{
+		redis:         redisClient,
+		preferences:   preferences,
+		name:          cfg.LanguageDetection,
+		minConfidence: cfg.LanguageMinConfidence,
+		detections: cache.New[models.LanguageDetection](redisClient.client, cache.Options{
+			Name:       "language-detection",
+			Mode:       cache.ReadThrough,
+			L1TTL:      10 * time.Minute,
+			L1MaxItems: 10000,
+			L2TTL:      time.Duration(cfg.LanguageDetectionTTLHours) * time.Hour,
+		}),
+	}
+
+	switch cfg.LanguageDetection {
+	case LanguageDetectionHeuristic:
+		resolver.detector = NewHeuristicLanguageDetector()
+	case LanguageDetectionAzure:
+		if cfg.LanguageEndpoint == "" || cfg.LanguageKey == "" {
+			slog.Warn("LANGUAGE_DETECTION=azure_language without LANGUAGE_ENDPOINT and LANGUAGE_KEY, falling back to heuristic detection")
+			resolver.name = LanguageDetectionHeuristic
+			resolver.detector = NewHeuristicLanguageDetector()
+		} else {
+			resolver.detector = NewAzureLanguageDetector(cfg)
+		}
+	case LanguageDetectionOff, "":
+	default:
+		slog.Warn("Unknown LANGUAGE_DETECTION, language detection disabled", "language_detection", cfg.LanguageDetection)
+	}
+	return resolver
+}
+
+// CustomerLanguage returns the customer's language and where it came from. An empty
+// language means neither preferences nor detection settled it.
+func (r *LanguageResolver) CustomerLanguage(ctx context.Context, customerID, content string) (string, string) {
+	if language := r.preferredLanguage(ctx, customerID); language != "" {
+		return language, LanguageSourcePreference
+	}
+	if r.detector == nil || customerID == "" || strings.TrimSpace(content) == "" {
+		return "", LanguageSourceDefault
+	}
+
+	detection, err := r.detections.Get(ctx, customerID, func(ctx context.Context) (models.LanguageDetection, error) {
+		detection, err := r.detector.Detect(ctx, content)
+		if err != nil {
+			telemetry.RecordLanguageDetection(ctx, r.name, "", "error")
+			return detection, err
+		}
+		if detection.Language == "" || detection.Confidence < r.minConfidence {
+			telemetry.RecordLanguageDetection(ctx, r.name, detection.Language, "low_confidence")
+			return detection, errLowConfidence
+		}
+		telemetry.RecordLanguageDetection(ctx, r.name, detection.Language, "accepted")
+		detection.Detector = r.name
+		detection.DetectedAt = time.Now().UTC()
+		return detection, nil
+	})
+	if err != nil {
+		if !errors.Is(err, errLowConfidence) {
+			slog.WarnContext(ctx, "Language detection failed", "customer.id", customerID, "error", err)
+		}
+		return "", LanguageSourceDefault
+	}
+	return detection.Language, LanguageSourceDetected
+}
+
+func (r *LanguageResolver) preferredLanguage(ctx context.Context, customerID string) string {
+	if customerID == "" {
+		return ""
+	}
+	preferences, err := r.preferences.Preferences(ctx, customerID)
+	if err != nil {
+		if !errors.Is(err, ErrPreferencesNotFound) {
+			slog.WarnContext(ctx, "Failed to read preferences", "customer.id", customerID, "error", err)
+		}
+		return ""
+	}
+	return preferences.Language
+}
+
+// closestLocale picks the template locale nearest to language: an exact match, then a
+// locale sharing its primary language ("pt-BR" for "pt", "fr" for "fr-CA"), and
+// otherwise the template's own locale
+func closestLocale(tmpl *models.NotificationTemplate, language string) string {
+	base := templateLocale(tmpl)
+	if language == "" {
+		return base
+	}
+
+	locales := []string{base}
+	for locale := range tmpl.Localizations {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales[1:])
+
+	for _, locale := range locales {
+		if strings.EqualFold(locale, language) {
+			return locale
+		}
+	}
+	primary := primaryLanguage(language)
+	for _, locale := range locales {
+		if primaryLanguage(locale) == primary {
+			return locale
+		}
+	}
+	return base
+}
+
+// templateLocale is the locale of a template's own subject and body
+func templateLocale(tmpl *models.NotificationTemplate) string {
+	if tmpl.Locale == "" {
+		return "en"
+	}
+	return tmpl.Locale
+}
+
+func primaryLanguage(tag string) string {
+	tag = strings.ToLower(tag)
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		return tag[:i]
+	}
+	return tag
+}
+
+// languageDetectionText is the content of a notification that its language is detected
+// from: its subject, message and the text values of its data
+func languageDetectionText(notification *models.Notification) string {
+	parts := []string{notification.Subject, notification.Message}
+	keys := make([]string, 0, len(notification.Data))
+	for key := range notification.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if text, ok := notification.Data[key].(string); ok {
+			parts = append(parts, text)
+		}
+	}
+	return strings.TrimSpace(strings.Join(parts, "\n"))
+}
+
+// heuristicMinHits is how many stopwords the heuristic detector needs to see
+const heuristicMinHits = 3
+
+// languageStopwords are common function words of each language. Some are shared, such
+// as "con" and "para" in Spanish and Portuguese.
+var languageStopwords = map[string][]string{
+	"en": {"the", "and", "your", "you", "is", "are", "has", "have", "with", "for", "been", "will", "this", "order", "was"},
+	"es": {"el", "la", "los", "las", "y", "su", "tu", "es", "está", "con", "para", "pedido", "ha", "sido", "por"},
+	"fr": {"le", "la", "les", "et", "votre", "vous", "est", "avec", "pour", "commande", "été", "a", "des", "une"},
+	"de": {"der", "die", "das", "und", "ihre", "ihr", "ist", "mit", "für", "bestellung", "wurde", "sie", "ein", "eine"},
+	"pt": {"o", "os", "as", "e", "seu", "sua", "você", "está", "com", "para", "pedido", "foi", "uma", "um"},
+	"it": {"il", "lo", "gli", "e", "tuo", "tua", "è", "con", "per", "ordine", "stato", "una", "della", "di"},
+	"nl": {"de", "het", "en", "uw", "je", "is", "met", "voor", "bestelling", "werd", "een", "van", "zijn"},
+}
+
+// HeuristicLanguageDetector is the built-in detector: it looks for the stopwords of a
+// handful of European languages. Confidence is the share of recognised words that
+// belong to the winning language, halved when another language matches as many.
+type HeuristicLanguageDetector struct {
+	stopwords map[string]map[string]bool
+}
+
+func NewHeuristicLanguageDetector() *HeuristicLanguageDetector {
+	stopwords := make(map[string]map[string]bool, len(languageStopwords))
+	for language, words := range languageStopwords {
+		stopwords[language] = make(map[string]bool, len(words))
+		for _, word := range words {
+			stopwords[language][word] = true
+		}
+	}
+	return &HeuristicLanguageDetector{stopwords: stopwords}
+}
+
+func (d *HeuristicLanguageDetector) Detect(ctx context.Context, text string) (models.LanguageDetection, error) {
+	hits := make(map[string]int, len(d.stopwords))
+	recognised := 0
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	for _, word := range words {
+		matched := false
+		for language, stopwords := range d.stopwords {
+			if stopwords[word] {
+				hits[language]++
+				matched = true
+			}
+		}
+		if matched {
+			recognised++
+		}
+	}
+	if recognised < heuristicMinHits {
+		return models.LanguageDetection{}, nil
+	}
+
+	best, runnerUp := "", 0
+	for language, count := range hits {
+		switch {
+		case count > hits[best] || (count == hits[best] && language < best):
+			runnerUp = max(runnerUp, hits[best])
+			best = language
+		case count > runnerUp:
+			runnerUp = count
+		}
+	}
+	confidence := float64(hits[best]) / float64(recognised)
+	if runnerUp == hits[best] {
+		confidence /= 2
+	}
+	return models.LanguageDetection{Language: best, Confidence: confidence}, nil
+}
+
+const (
+	azureLanguageAPIVersion = "2023-04-01"
+	// azureLanguageMaxChars is the per-document limit for language detection
+	azureLanguageMaxChars = 5120
+)
+
+type azureLanguageRequest struct {
+	Kind          string `json:"kind"`
+	AnalysisInput struct {
+		Documents []azureLanguageDocument `json:"documents"`
+	} `json:"analysisInput"`
+}
+
+type azureLanguageDocument struct {
+	ID   string `json:"id"`
+	Text string `json:"text"`
+}
+
+type azureLanguageResponse struct {
+	Results struct {
+		Documents []struct {
+			DetectedLanguage struct {
+				Name            string  `json:"name"`
+				ISO6391Name     string  `json:"iso6391Name"`
+				ConfidenceScore float64 `json:"confidenceScore"`
+			} `json:"detectedLanguage"`
+		} `json:"documents"`
+		Errors []struct {
+			Error struct {
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		} `json:"errors"`
+	} `json:"results"`
+}
+
+// AzureLanguageDetector detects languages with the Azure AI Language LanguageDetection task
+type AzureLanguageDetector struct {
+	endpoint string
+	key      string
+	client   *http.Client
+}
+
+func NewAzureLanguageDetector(cfg *config.Config) *AzureLanguageDetector {
+	return &AzureLanguageDetector{
+		endpoint: strings.TrimSuffix(cfg.LanguageEndpoint, "/"),
+		key:      cfg.LanguageKey,
+		client:   NewHTTPClient(cfg, ProviderLanguage, 5*time.Second),
+	}
+}
+
+func (d *AzureLanguageDetector) Detect(ctx context.Context, text string) (models.LanguageDetection, error) {
+	ctx, span := telemetry.Tracer.Start(ctx, "language.detect",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("server.address", d.endpoint)),
+	)
+	defer span.End()
+
+	detection, err := d.detect(ctx, truncateRunes(text, azureLanguageMaxChars))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "language detection failed")
+		return detection, err
+	}
+	span.SetAttributes(
+		attribute.String("language.code", detection.Language),
+		attribute.Float64("language.confidence", detection.Confidence),
+	)
+	return detection, nil
+}
+
+func (d *AzureLanguageDetector) detect(ctx context.Context, text string) (models.LanguageDetection, error) {
+	request := azureLanguageRequest{Kind: "LanguageDetection"}
+	request.AnalysisInput.Documents = []azureLanguageDocument{{ID: "1", Text: text}}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return models.LanguageDetection{}, err
+	}
+
+	url := fmt.Sprintf("%s/language/:analyze-text?api-version=%s", d.endpoint, azureLanguageAPIVersion)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return models.LanguageDetection{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Ocp-Apim-Subscription-Key", d.key)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return models.LanguageDetection{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return models.LanguageDetection{}, &StatusError{StatusCode: resp.StatusCode, RetryAfterDelay: ParseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+
+	var result azureLanguageResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result); err != nil {
+		return models.LanguageDetection{}, fmt.Errorf("failed to decode language detection response: %w", err)
+	}
+	if len(result.Results.Errors) > 0 {
+		apiErr := result.Results.Errors[0].Error
+		return models.LanguageDetection{}, fmt.Errorf("language detection %s: %s", apiErr.Code, apiErr.Message)
+	}
+	if len(result.Results.Documents) == 0 {
+		return models.LanguageDetection{}, errors.New("language detection returned no documents")
+	}
+
+	detected := result.Results.Documents[0].DetectedLanguage
+	// "(Unknown)" comes back with an empty code and zero confidence
+	return models.LanguageDetection{Language: detected.ISO6391Name, Confidence: detected.ConfidenceScore}, nil
+}
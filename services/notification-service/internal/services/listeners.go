@@ -0,0 +1,109 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+)
+
+// unixPrefix marks a Unix socket path in BIND_ADDRESSES
+const unixPrefix = "unix:"
+
+// Listen opens the HTTP server's listeners from BIND_ADDRESSES, a comma-separated list of
+// host:port, host (on PORT) or unix:/path entries. Without it the server listens on PORT
+// on every interface, IPv4 and IPv6. A wildcard or empty host listens dual-stack; an
+// IPv4 or IPv6 literal listens on that family only, so 0.0.0.0 and [::] can be bound
+// side by side. If any listener fails, those already opened are closed.
+func Listen(cfg *config.Config) ([]net.Listener, error) {
+	entries := strings.Split(cfg.BindAddresses, ",")
+	if strings.TrimSpace(cfg.BindAddresses) == "" {
+		entries = []string{":" + cfg.Port}
+	}
+
+	var listeners []net.Listener
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		listener, err := listen(cfg, entry)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("failed to listen on %s: %w", entry, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	if len(listeners) == 0 {
+		return nil, errors.New("BIND_ADDRESSES has no addresses")
+	}
+	return listeners, nil
+}
+
+func listen(cfg *config.Config, entry string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(entry, unixPrefix); ok {
+		return listenUnix(cfg, strings.TrimPrefix(path, "//"))
+	}
+
+	host, port, err := net.SplitHostPort(entry)
+	if err != nil {
+		// A bare host listens on PORT
+		host, port = strings.Trim(entry, "[]"), cfg.Port
+	}
+	network := "tcp"
+	if ip := net.ParseIP(host); ip != nil {
+		network = "tcp6"
+		if ip.To4() != nil {
+			network = "tcp4"
+		}
+	}
+	return net.Listen(network, net.JoinHostPort(host, port))
+}
+
+// listenUnix listens on a Unix socket for sidecars on the same host, replacing a stale
+// socket left by an earlier run. The socket's permissions come from UNIX_SOCKET_MODE.
+func listenUnix(cfg *config.Config, path string) (net.Listener, error) {
+	if path == "" {
+		return nil, errors.New("unix socket path is empty")
+	}
+	mode, err := strconv.ParseUint(cfg.UnixSocketMode, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid UNIX_SOCKET_MODE %q: %w", cfg.UnixSocketMode, err)
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, fs.FileMode(mode)); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// DescribeListeners reports the addresses actually bound, including ports picked by the
+// system for :0
+func DescribeListeners(listeners []net.Listener) []models.Listener {
+	described := make([]models.Listener, 0, len(listeners))
+	for _, l := range listeners {
+		described = append(described, models.Listener{Network: l.Addr().Network(), Address: l.Addr().String()})
+	}
+	return described
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var ErrInvalidLocalSchedule = errors.New("invalid local schedule")
+
+// Values of LOCAL_SCHEDULE_PAST_ACTION, for a local time that has already passed in the
+// recipient's time zone
+const (
+	LocalPastNextDay = "next_day"
+	LocalPastSendNow = "send_now"
+	LocalPastSkip    = "skip"
+)
+
+// Where the time zone of a local schedule came from
+const (
+	TimezoneSourcePreferences = "preferences"
+	TimezoneSourceQuietHours  = "quiet_hours"
+	TimezoneSourceDefault     = "default"
+)
+
+// Notification metadata written when scheduled_at is resolved in the recipient's time zone
+const (
+	LocalTimeMetadata       = "local_schedule_time"
+	LocalTimezoneMetadata   = "local_schedule_timezone"
+	LocalSourceMetadata     = "local_schedule_timezone_source"
+	LocalAdjustedMetadata   = "local_schedule_adjusted"
+	LocalSkippedMetadata    = "local_schedule_skipped"
+	localScheduleTimeFormat = "2006-01-02T15:04:05"
+)
+
+// Adjustments made to a local schedule
+const (
+	localAdjustedDSTGap  = "dst_gap"
+	localAdjustedNextDay = "next_day"
+	localAdjustedSentNow = "sent_now"
+)
+
+// ScheduleLocal reads a notification's ScheduledAt as wall-clock time in its customer's
+// time zone and replaces it with that instant. The zone is the customer's timezone
+// preference, else the time zone of their quiet hours, else DEFAULT_TIMEZONE. A time
+// that doesn't exist because clocks spring forward moves forward by the gap; a time that
+// has already passed for the recipient is handled by LOCAL_SCHEDULE_PAST_ACTION. What
+// was requested and any adjustment are kept in the notification's metadata.
+func (s *CustomerPreferenceService) ScheduleLocal(ctx context.Context, notification *models.Notification) error {
+	if notification.ScheduledAt == nil {
+		return fmt.Errorf("%w: scheduled_local needs scheduled_at", ErrInvalidLocalSchedule)
+	}
+	requested := *notification.ScheduledAt
+	location, source := s.timezone(ctx, notification)
+	notification.Metadata[LocalTimeMetadata] = requested.Format(localScheduleTimeFormat)
+	notification.Metadata[LocalTimezoneMetadata] = location.String()
+	notification.Metadata[LocalSourceMetadata] = source
+
+	var adjusted []string
+	sendAt, gap := wallClock(requested, requested, location)
+	if gap {
+		adjusted = append(adjusted, localAdjustedDSTGap)
+	}
+
+	now := time.Now().UTC()
+	if sendAt.Before(now) {
+		switch s.pastAction {
+		case LocalPastSkip:
+			notification.Status = models.NotificationStatusSuppressed
+			notification.ErrorMessage = fmt.Sprintf("skipped: %s in %s had already passed", notification.Metadata[LocalTimeMetadata], location)
+			notification.Metadata[LocalSkippedMetadata] = models.SuppressionLocalTimePassed
+			telemetry.RecordNotificationSuppressed(ctx, string(notification.Type), models.SuppressionLocalTimePassed)
+		case LocalPastSendNow:
+			sendAt = now
+			adjusted = append(adjusted, localAdjustedSentNow)
+		default:
+			// The same time of day, on the first day it is still ahead for the recipient
+			today := now.In(location)
+			sendAt, gap = wallClock(today, requested, location)
+			if !sendAt.After(now) {
+				sendAt, gap = wallClock(today.AddDate(0, 0, 1), requested, location)
+			}
+			adjusted = nil
+			if gap {
+				adjusted = append(adjusted, localAdjustedDSTGap)
+			}
+			adjusted = append(adjusted, localAdjustedNextDay)
+		}
+	}
+	if len(adjusted) > 0 {
+		notification.Metadata[LocalAdjustedMetadata] = strings.Join(adjusted, ",")
+	}
+	notification.ScheduledAt = &sendAt
+
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("local_schedule.timezone", location.String()),
+		attribute.String("local_schedule.timezone_source", source),
+		attribute.String("local_schedule.adjusted", strings.Join(adjusted, ",")),
+		attribute.Bool("local_schedule.skipped", notification.Status == models.NotificationStatusSuppressed),
+	)
+	return nil
+}
+
+// ResolveLocal returns when a pending scheduled notification is due. A local schedule is
+// read again in its customer's time zone at dispatch, so a customer who moved zones
+// since it was created still gets it at the local time asked for: the wall-clock time it
+// was resolved to in the old zone is kept and read in the new one. Other notifications
+// are due at their ScheduledAt.
+func (s *CustomerPreferenceService) ResolveLocal(ctx context.Context, notification *models.Notification) time.Time {
+	scheduledAt := *notification.ScheduledAt
+	zone, _ := notification.Metadata[LocalTimezoneMetadata].(string)
+	adjusted, _ := notification.Metadata[LocalAdjustedMetadata].(string)
+	if zone == "" || strings.Contains(adjusted, localAdjustedSentNow) {
+		return scheduledAt
+	}
+	resolvedIn, err := time.LoadLocation(zone)
+	if err != nil {
+		return scheduledAt
+	}
+	location, source := s.timezone(ctx, notification)
+	if location.String() == resolvedIn.String() {
+		return scheduledAt
+	}
+
+	local := scheduledAt.In(resolvedIn)
+	due, _ := wallClock(local, local, location)
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("local_schedule.timezone", location.String()),
+		attribute.String("local_schedule.timezone_source", source),
+		attribute.String("local_schedule.resolved_timezone", zone),
+	)
+	return due
+}
+
+// LocalTime returns the instant at's date and time of day, ignoring its offset, fall on
+// in a customer's time zone, for schedules resolved per recipient such as broadcasts
+func (s *CustomerPreferenceService) LocalTime(ctx context.Context, customerID string, at time.Time) time.Time {
+	location, _ := s.timezone(ctx, &models.Notification{CustomerID: customerID})
+	due, _ := wallClock(at, at, location)
+	return due
+}
+
+// timezone returns the time zone a customer's local schedules are read in and where it
+// came from. Customers whose preferences can't be read get DEFAULT_TIMEZONE.
+func (s *CustomerPreferenceService) timezone(ctx context.Context, notification *models.Notification) (*time.Location, string) {
+	customerID := notification.CustomerID
+	if customerID == "" {
+		customerID = notification.Recipient
+	}
+	if customerID == "" {
+		return s.defaultZone, TimezoneSourceDefault
+	}
+	preferences, err := s.lookup(ctx, customerID)
+	if err != nil {
+		slog.WarnContext(ctx, "Preferences unavailable, scheduling in the default time zone", "customer.id", customerID, "notification.id", notification.ID, "error", err)
+		return s.defaultZone, TimezoneSourceDefault
+	}
+	if preferences == nil {
+		return s.defaultZone, TimezoneSourceDefault
+	}
+	// Both are checked when preferences are stored
+	if preferences.Timezone != "" {
+		if location, err := time.LoadLocation(preferences.Timezone); err == nil {
+			return location, TimezoneSourcePreferences
+		}
+	}
+	if q := preferences.QuietHours; q != nil && q.Timezone != "" {
+		if location, err := time.LoadLocation(q.Timezone); err == nil {
+			return location, TimezoneSourceQuietHours
+		}
+	}
+	return s.defaultZone, TimezoneSourceDefault
+}
+
+// wallClock returns the instant clock's time of day falls on day's date in location, and
+// whether that time was skipped by a daylight saving change. A skipped time is moved
+// forward by the gap: 02:30 on the night clocks go from 02:00 to 03:00 becomes 03:30.
+func wallClock(day, clock time.Time, location *time.Location) (time.Time, bool) {
+	t := time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), clock.Second(), 0, location)
+	if t.Hour() == clock.Hour() && t.Minute() == clock.Minute() {
+		return t.UTC(), false
+	}
+	// time.Date reads a skipped time with the offset in force after the change, landing
+	// before it; shift by the difference between the wall clock asked for and the one got
+	want := time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), clock.Second(), 0, time.UTC)
+	got := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+	if want.After(got) {
+		t = t.Add(want.Sub(got))
+	}
+	return t.UTC(), true
+}
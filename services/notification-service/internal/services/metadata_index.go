@@ -0,0 +1,272 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"notification-service/internal/cache"
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// metadataIntersectionScanLimit caps how many IDs of the smallest index a query with
+// several filters checks against the others, so a broad filter can't make it unbounded
+const metadataIntersectionScanLimit = 10000
+
+// metadataIntersectionPage is how many IDs are checked against the other indexes at a time
+const metadataIntersectionPage = 500
+
+var (
+	ErrInvalidMetadataKey    = errors.New("invalid metadata key")
+	ErrMetadataIndexLimit    = errors.New("metadata index limit reached")
+	ErrMetadataKeyNotIndexed = errors.New("metadata key is not indexed")
+)
+
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_]{1,64}$`)
+
+// MetadataIndex maintains secondary indexes for a bounded set of notification metadata
+// keys each tenant registers, so a tenant's notifications can be queried by them. A
+// notification's tenant is its tenant_id metadata, or the default tenant.
+type MetadataIndex struct {
+	redis     *RedisClient
+	residency *DataResidency
+	maxKeys   int
+	keys      *cache.Cache[[]string]
+}
+
+// NewMetadataIndex creates the index; matched notifications are read through residency
+// from their data regions
+func NewMetadataIndex(cfg *config.Config, redis *RedisClient, residency *DataResidency) *MetadataIndex {
+	return &MetadataIndex{
+		redis:     redis,
+		residency: residency,
+		maxKeys:   cfg.MetadataIndexMaxKeys,
+		keys: cache.New[[]string](nil, cache.Options{
+			Name:       "metadata-index-keys",
+			Mode:       cache.ReadThrough,
+			L1TTL:      30 * time.Second,
+			L1MaxItems: 1000,
+		}),
+	}
+}
+
+// metadataIndexKeysKey holds the metadata keys a tenant registered
+func metadataIndexKeysKey(tenantID string) string {
+	return "metadata-index:keys:" + tenantID
+}
+
+// metadataIndexKey holds the IDs of a tenant's notifications with metadata key=value,
+// scored by creation time
+func metadataIndexKey(tenantID, key, value string) string {
+	return "notifications:metadata:" + tenantID + ":" + key + ":" + value
+}
+
+// IndexedKeys returns the tenant's registered keys; other replicas see changes within the
+// cache TTL
+func (m *MetadataIndex) IndexedKeys(ctx context.Context, tenantID string) ([]string, error) {
+	keysKey := metadataIndexKeysKey(tenantID)
+	return m.keys.Get(ctx, keysKey, func(ctx context.Context) ([]string, error) {
+		keys, err := m.redis.client.SMembers(ctx, keysKey).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load indexed metadata keys: %w", err)
+		}
+		sort.Strings(keys)
+		return keys, nil
+	})
+}
+
+// RegisterKey starts indexing a metadata key for the tenant. Notifications saved before
+// registration are not backfilled.
+func (m *MetadataIndex) RegisterKey(ctx context.Context, tenantID, key string) ([]string, error) {
+	if !metadataKeyPattern.MatchString(key) {
+		return nil, fmt.Errorf("%w: %q must be 1-64 letters, digits or underscores", ErrInvalidMetadataKey, key)
+	}
+
+	keysKey := metadataIndexKeysKey(tenantID)
+	err := m.redis.client.Watch(ctx, func(tx *redis.Tx) error {
+		exists, err := tx.SIsMember(ctx, keysKey, key).Result()
+		if err != nil || exists {
+			return err
+		}
+		count, err := tx.SCard(ctx, keysKey).Result()
+		if err != nil {
+			return err
+		}
+		if int(count) >= m.maxKeys {
+			return fmt.Errorf("%w: at most %d keys can be indexed per tenant", ErrMetadataIndexLimit, m.maxKeys)
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.SAdd(ctx, keysKey, key)
+			return nil
+		})
+		return err
+	}, keysKey)
+	if errors.Is(err, redis.TxFailedErr) {
+		return nil, fmt.Errorf("%w: concurrent registration, retry", ErrMetadataIndexLimit)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m.keys.Delete(ctx, keysKey)
+	slog.InfoContext(ctx, "🗂️ Indexing notification metadata key", "tenant.id", tenantID, "metadata.key", key)
+	return m.IndexedKeys(ctx, tenantID)
+}
+
+// UnregisterKey stops indexing a metadata key for the tenant and drops its existing index
+// entries
+func (m *MetadataIndex) UnregisterKey(ctx context.Context, tenantID, key string) ([]string, error) {
+	keysKey := metadataIndexKeysKey(tenantID)
+	removed, err := m.redis.client.SRem(ctx, keysKey, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to unregister metadata key: %w", err)
+	}
+	if removed == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrMetadataKeyNotIndexed, key)
+	}
+	m.keys.Delete(ctx, keysKey)
+
+	// The tenant is escaped so glob characters in its ID only match themselves
+	pattern := metadataIndexKey(globEscaper.Replace(tenantID), key, "*")
+	iter := m.redis.client.Scan(ctx, 0, pattern, 500).Iterator()
+	for iter.Next(ctx) {
+		m.redis.client.Del(ctx, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		slog.WarnContext(ctx, "Failed to drop index entries for metadata key", "metadata.key", key, "error", err)
+	}
+
+	slog.InfoContext(ctx, "🗂️ Stopped indexing notification metadata key", "tenant.id", tenantID, "metadata.key", key)
+	return m.IndexedKeys(ctx, tenantID)
+}
+
+// globEscaper escapes the characters Redis SCAN patterns treat specially
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// IndexEntries returns the index sets the notification belongs in: one per key its tenant
+// indexes. Only scalar values are indexed; nested objects and arrays are ignored.
+func (m *MetadataIndex) IndexEntries(ctx context.Context, notification *models.Notification) []string {
+	if len(notification.Metadata) == 0 {
+		return nil
+	}
+	tenantID := notificationTenant(notification)
+	keys, err := m.IndexedKeys(ctx, tenantID)
+	if err != nil {
+		slog.WarnContext(ctx, "Notification saved without metadata indexes", "notification.id", notification.ID, "error", err)
+		return nil
+	}
+
+	var entries []string
+	for _, key := range keys {
+		switch value := notification.Metadata[key].(type) {
+		case string:
+			entries = append(entries, metadataIndexKey(tenantID, key, value))
+		case float64, int, int64, bool:
+			entries = append(entries, metadataIndexKey(tenantID, key, fmt.Sprint(value)))
+		}
+	}
+	return entries
+}
+
+// FindNotifications returns the tenant's newest notifications matching every metadata
+// filter. With several filters, the newest metadataIntersectionScanLimit IDs of the
+// smallest index are checked against the others, so older matches beyond them are not
+// returned.
+func (m *MetadataIndex) FindNotifications(ctx context.Context, tenantID string, filters map[string]string, limit int) ([]*models.Notification, error) {
+	keys, err := m.IndexedKeys(ctx, tenantID)
+	if err != nil {
+		return nil, err
+	}
+	indexed := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		indexed[key] = true
+	}
+
+	var indexKeys []string
+	for key, value := range filters {
+		if !indexed[key] {
+			return nil, fmt.Errorf("%w: %s", ErrMetadataKeyNotIndexed, key)
+		}
+		indexKeys = append(indexKeys, metadataIndexKey(tenantID, key, value))
+	}
+
+	var ids []string
+	if len(indexKeys) == 1 {
+		ids, err = m.redis.client.ZRevRange(ctx, indexKeys[0], 0, int64(limit-1)).Result()
+	} else {
+		ids, err = m.intersect(ctx, indexKeys, limit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query metadata index: %w", err)
+	}
+
+	return m.residency.LoadNotifications(ctx, ids)
+}
+
+// intersect returns the newest IDs, up to limit, found in every index. It pages through
+// the smallest index newest first and checks each page against the others, stopping
+// after metadataIntersectionScanLimit IDs.
+func (m *MetadataIndex) intersect(ctx context.Context, indexKeys []string, limit int) ([]string, error) {
+	sizes := make([]*redis.IntCmd, len(indexKeys))
+	pipe := m.redis.client.Pipeline()
+	for i, key := range indexKeys {
+		sizes[i] = pipe.ZCard(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	smallest := 0
+	for i := range indexKeys {
+		if sizes[i].Val() < sizes[smallest].Val() {
+			smallest = i
+		}
+	}
+	if sizes[smallest].Val() == 0 {
+		return nil, nil
+	}
+	others := append(append([]string{}, indexKeys[:smallest]...), indexKeys[smallest+1:]...)
+
+	var ids []string
+	for start := 0; start < metadataIntersectionScanLimit && len(ids) < limit; start += metadataIntersectionPage {
+		page, err := m.redis.client.ZRevRange(ctx, indexKeys[smallest], int64(start), int64(start+metadataIntersectionPage-1)).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(page) == 0 {
+			break
+		}
+
+		scores := make([][]*redis.FloatCmd, len(page))
+		pipe := m.redis.client.Pipeline()
+		for i, id := range page {
+			scores[i] = make([]*redis.FloatCmd, len(others))
+			for j, key := range others {
+				scores[i][j] = pipe.ZScore(ctx, key, id)
+			}
+		}
+		if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+			return nil, err
+		}
+	candidates:
+		for i, id := range page {
+			for _, score := range scores[i] {
+				if score.Err() != nil {
+					continue candidates
+				}
+			}
+			ids = append(ids, id)
+			if len(ids) == limit {
+				break
+			}
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"time"
+
+	"notification-service/internal/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	ErrNotificationNotFound      = errors.New("notification not found")
+	ErrNotificationNotEditable   = errors.New("only pending scheduled notifications can be edited")
+	ErrVersionMismatch           = errors.New("notification version does not match")
+	ErrNotificationNotResendable = errors.New("only sent, delivered or failed notifications can be re-sent")
+	ErrInvalidSchedule           = errors.New("scheduled_at must be in the future and at most a year ahead")
+)
+
+// maxScheduleAhead is how far ahead an edit can move a notification's send time
+const maxScheduleAhead = 365 * 24 * time.Hour
+
+// notificationEditsKey holds the edit history, kept outside the notification: prefix so
+// storage scans only see notifications
+func notificationEditsKey(id string) string {
+	return "notification-edits:" + id
+}
+
+// UpdateNotification applies a pre-send edit to a pending scheduled notification whose
+// current version is expectedVersion, and appends the change to its edit history
+func (s *NotificationService) UpdateNotification(ctx context.Context, id string, expectedVersion int, patch models.NotificationPatchRequest, editedBy string) (*models.Notification, error) {
+	if patch.ScheduledAt != nil {
+		now := time.Now()
+		if !patch.ScheduledAt.After(now) || patch.ScheduledAt.After(now.Add(maxScheduleAhead)) {
+			return nil, ErrInvalidSchedule
+		}
+	}
+	if _, err := s.GetNotification(ctx, id); err != nil {
+		return nil, err
+	}
+	records, err := s.residency.Records(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var updated *models.Notification
+	var rescheduled bool
+
+	err = records.Watch(ctx, func(tx *redis.Tx) error {
+		notification, err := loadNotification(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if notification.Version != expectedVersion {
+			return ErrVersionMismatch
+		}
+		if notification.Status != models.NotificationStatusPending || notification.ScheduledAt == nil {
+			return ErrNotificationNotEditable
+		}
+
+		changes := applyPatch(notification, patch)
+		_, rescheduled = changes["scheduled_at"]
+		if len(changes) == 0 {
+			updated = notification
+			return nil
+		}
+		// A longer subject or message can take a push payload over its limit
+		if err := ValidatePush(notification); err != nil {
+			return err
+		}
+		notification.Version++
+
+		payload, err := json.Marshal(notification)
+		if err != nil {
+			return fmt.Errorf("failed to marshal notification: %w", err)
+		}
+		edit, err := json.Marshal(models.NotificationEdit{
+			Version:  notification.Version,
+			EditedBy: editedBy,
+			EditedAt: time.Now().UTC(),
+			Changes:  changes,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to marshal notification edit: %w", err)
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, notificationKey(id), payload, 0)
+			pipe.RPush(ctx, notificationEditsKey(id), edit)
+			return nil
+		})
+		updated = notification
+		return err
+	}, notificationKey(id))
+
+	if errors.Is(err, redis.TxFailedErr) {
+		// Another edit landed between the read and the write
+		return nil, ErrVersionMismatch
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.persist(ctx, updated)
+	if rescheduled {
+		if err := s.scheduled.Add(ctx, s.redis.client, id, *updated.ScheduledAt).Err(); err != nil {
+			slog.ErrorContext(ctx, "Failed to reschedule notification", "notification.id", id, "error", err)
+		}
+	}
+	return updated, nil
+}
+
+// NotificationEdits returns a notification's edit history, oldest first
+func (s *NotificationService) NotificationEdits(ctx context.Context, id string) ([]models.NotificationEdit, error) {
+	records, err := s.residency.Records(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := records.LRange(ctx, notificationEditsKey(id), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load notification edits: %w", err)
+	}
+
+	edits := make([]models.NotificationEdit, 0, len(entries))
+	for _, entry := range entries {
+		var edit models.NotificationEdit
+		if err := json.Unmarshal([]byte(entry), &edit); err != nil {
+			return nil, fmt.Errorf("failed to decode notification edit: %w", err)
+		}
+		edits = append(edits, edit)
+	}
+	return edits, nil
+}
+
+// applyPatch updates the notification in place and returns the fields that changed
+func applyPatch(notification *models.Notification, patch models.NotificationPatchRequest) map[string]models.FieldChange {
+	changes := make(map[string]models.FieldChange)
+
+	if patch.Subject != nil && *patch.Subject != notification.Subject {
+		changes["subject"] = models.FieldChange{From: notification.Subject, To: *patch.Subject}
+		notification.Subject = *patch.Subject
+	}
+	if patch.Message != nil && *patch.Message != notification.Message {
+		changes["message"] = models.FieldChange{From: notification.Message, To: *patch.Message}
+		notification.Message = *patch.Message
+	}
+	if patch.Data != nil && !reflect.DeepEqual(patch.Data, notification.Data) {
+		changes["data"] = models.FieldChange{From: notification.Data, To: patch.Data}
+		notification.Data = patch.Data
+	}
+	if patch.ScheduledAt != nil && !patch.ScheduledAt.Equal(*notification.ScheduledAt) {
+		changes["scheduled_at"] = models.FieldChange{From: *notification.ScheduledAt, To: *patch.ScheduledAt}
+		notification.ScheduledAt = patch.ScheduledAt
+		// An edited time is the instant to send at, no longer a local time to resolve
+		for _, key := range []string{LocalTimeMetadata, LocalTimezoneMetadata, LocalSourceMetadata, LocalAdjustedMetadata} {
+			delete(notification.Metadata, key)
+		}
+	}
+	return changes
+}
+
+func loadNotification(ctx context.Context, client redis.Cmdable, id string) (*models.Notification, error) {
+	payload, err := client.Get(ctx, notificationKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotificationNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load notification: %w", err)
+	}
+
+	var notification models.Notification
+	if err := json.Unmarshal(payload, &notification); err != nil {
+		return nil, fmt.Errorf("failed to decode notification: %w", err)
+	}
+	return &notification, nil
+}
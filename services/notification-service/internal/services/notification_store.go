@@ -0,0 +1,389 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"notification-service/internal/models"
+	"notification-service/internal/storage"
+	"notification-service/internal/telemetry"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Notifications loaded from Postgres are cached in Redis for this long
+const notificationCacheTTL = time.Hour
+
+var (
+	ErrStorageUnavailable      = errors.New("notification database is not configured or unreachable")
+	ErrInvalidStatusTransition = errors.New("invalid notification status transition")
+)
+
+// GetNotification loads a notification from the Redis of its data region, falling back
+// to the database and caching the result on a miss. One this replica saved while Redis
+// was down is read from the write-behind buffer until its write is replayed.
+func (s *NotificationService) GetNotification(ctx context.Context, id string) (*models.Notification, error) {
+	if payload, ok := s.buffered.Load(id); ok {
+		var notification models.Notification
+		if err := json.Unmarshal(payload.([]byte), &notification); err == nil {
+			return &notification, nil
+		}
+	}
+
+	records, err := s.residency.Records(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	notification, err := loadNotification(ctx, records, id)
+	if !errors.Is(err, ErrNotificationNotFound) || s.repo == nil {
+		return notification, err
+	}
+
+	notification, err = s.repo.GetNotification(ctx, id)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrNotificationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if payload, err := json.Marshal(notification); err == nil {
+		// SetNX so a concurrent write to the hot copy is never overwritten by the older row
+		if err := s.residency.CacheRecord(ctx, notification, payload); err != nil {
+			slog.WarnContext(ctx, "Failed to cache notification", "notification.id", id, "error", err)
+		}
+	}
+	return notification, nil
+}
+
+// getNotifications loads notifications in the order of ids, skipping those no longer
+// there. They are read from Redis in one round trip per data region; only those missing
+// from Redis are looked up one at a time, in the database.
+func (s *NotificationService) getNotifications(ctx context.Context, ids []string) ([]*models.Notification, error) {
+	cached, err := s.residency.LoadNotifications(ctx, ids)
+	if err != nil || s.repo == nil || len(cached) == len(ids) {
+		return cached, err
+	}
+
+	loaded := make(map[string]*models.Notification, len(cached))
+	for _, notification := range cached {
+		loaded[notification.ID] = notification
+	}
+	notifications := make([]*models.Notification, 0, len(ids))
+	for _, id := range ids {
+		notification, ok := loaded[id]
+		if !ok {
+			notification, err = s.GetNotification(ctx, id)
+			if errors.Is(err, ErrNotificationNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		notifications = append(notifications, notification)
+	}
+	return notifications, nil
+}
+
+// ListNotifications pages through notifications in the database, newest first unless
+// the filter asks for oldest first
+func (s *NotificationService) ListNotifications(ctx context.Context, filter storage.NotificationFilter) ([]*models.Notification, string, error) {
+	if s.repo == nil {
+		return nil, "", ErrStorageUnavailable
+	}
+	return s.repo.ListNotifications(ctx, filter)
+}
+
+// CountNotifications counts the notifications in the database a filter matches
+func (s *NotificationService) CountNotifications(ctx context.Context, filter storage.NotificationFilter) (int64, error) {
+	if s.repo == nil {
+		return 0, ErrStorageUnavailable
+	}
+	return s.repo.CountNotifications(ctx, filter)
+}
+
+// UpdateNotificationStatus records a delivery status reported for a notification.
+// Only the moves in statusTransitions are allowed; others fail with
+// ErrInvalidStatusTransition. Delivered, cancelled, blocked and suppressed are final;
+// cancellation goes through CancelNotification. A failed or retrying report schedules a
+// retry while the notification has retries left and its error is retryable; otherwise
+// the notification fails and is dead-lettered. Sent, delivered and failed times are
+// stamped as the notification reaches them.
+func (s *NotificationService) UpdateNotificationStatus(ctx context.Context, id string, req models.UpdateNotificationStatusRequest) (*models.Notification, error) {
+	switch req.Status {
+	case models.NotificationStatusPending, models.NotificationStatusSent, models.NotificationStatusDelivered,
+		models.NotificationStatusFailed, models.NotificationStatusRetrying, models.NotificationStatusSuppressed:
+	case models.NotificationStatusCancelled:
+		return nil, fmt.Errorf("%w: use the cancel endpoint to cancel a notification", ErrInvalidStatusTransition)
+	default:
+		return nil, fmt.Errorf("%w: unknown status %q", ErrInvalidStatusTransition, req.Status)
+	}
+
+	// Make sure the hot copy is in Redis before the atomic update
+	if _, err := s.GetNotification(ctx, id); err != nil {
+		return nil, err
+	}
+	records, err := s.residency.Records(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var updated *models.Notification
+	var previous models.NotificationStatus
+	err = records.Watch(ctx, func(tx *redis.Tx) error {
+		notification, err := loadNotification(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		previous = notification.Status
+		if len(statusTransitions[previous]) == 0 {
+			return checkTransition(previous, req.Status)
+		}
+
+		// A failure report becomes a retry or a final failure, as the retry policy decides
+		next := req.Status
+		switch req.Status {
+		case models.NotificationStatusSuppressed:
+			notification.ErrorMessage = req.ErrorMessage
+		case models.NotificationStatusFailed, models.NotificationStatusRetrying:
+			// Only a requeue brings a failed notification back for more retries
+			if previous == models.NotificationStatusFailed {
+				return fmt.Errorf("%w: notification has already failed", ErrInvalidStatusTransition)
+			}
+			notification.ErrorMessage = req.ErrorMessage
+			notification.ErrorClass = req.ErrorClass
+			next = models.NotificationStatusFailed
+			if s.retries.Retryable(ctx, notification, req.ErrorClass) {
+				next = models.NotificationStatusRetrying
+				notification.RetryCount++
+			}
+		}
+		if err := checkTransition(previous, next); err != nil {
+			return err
+		}
+		stampStatus(notification, next, time.Now().UTC())
+		notification.Version++
+
+		payload, err := json.Marshal(notification)
+		if err != nil {
+			return fmt.Errorf("failed to marshal notification: %w", err)
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, notificationKey(id), payload, 0)
+			return nil
+		})
+		updated = notification
+		return err
+	}, notificationKey(id))
+
+	if errors.Is(err, redis.TxFailedErr) {
+		return nil, fmt.Errorf("%w: notification changed concurrently, retry", ErrVersionMismatch)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	telemetry.RecordStatusTransition(ctx, string(updated.Type), string(previous), string(updated.Status))
+	s.persist(ctx, updated)
+	if updated.Status == models.NotificationStatusRetrying {
+		if _, err := s.retries.Schedule(ctx, updated); err != nil {
+			slog.WarnContext(ctx, "Failed to schedule retry of notification", "notification.id", id, "error", err)
+		}
+	}
+	if resolved(updated.Status) && !resolved(previous) {
+		telemetry.RecordNotificationRetries(ctx, string(updated.Type), string(updated.Priority), string(updated.Status), updated.RetryCount)
+	}
+	if updated.Status == models.NotificationStatusFailed && previous != models.NotificationStatusFailed && s.dlq != nil {
+		reason := models.DeadLetterDeliveryFailed
+		if updated.MaxRetries > 0 && updated.RetryCount >= updated.MaxRetries {
+			reason = models.DeadLetterRetriesExhausted
+		}
+		var deliveryErr error
+		if updated.ErrorMessage != "" {
+			deliveryErr = errors.New(updated.ErrorMessage)
+		}
+		if _, err := s.dlq.Capture(ctx, updated, reason, deliveryErr, 0); err != nil {
+			slog.WarnContext(ctx, "Failed to dead-letter notification", "notification.id", id, "error", err)
+		}
+	}
+	return updated, nil
+}
+
+// RequeueNotification moves a failed notification back to retrying with a fresh retry
+// budget and schedules its next attempt
+func (s *NotificationService) RequeueNotification(ctx context.Context, id string) (*models.Notification, error) {
+	if _, err := s.GetNotification(ctx, id); err != nil {
+		return nil, err
+	}
+	records, err := s.residency.Records(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var updated *models.Notification
+	err = records.Watch(ctx, func(tx *redis.Tx) error {
+		notification, err := loadNotification(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if notification.Status != models.NotificationStatusFailed {
+			return fmt.Errorf("%w: notification is %s, not failed", ErrInvalidStatusTransition, notification.Status)
+		}
+		stampStatus(notification, models.NotificationStatusRetrying, time.Now().UTC())
+		notification.RetryCount = 0
+		notification.Version++
+
+		payload, err := json.Marshal(notification)
+		if err != nil {
+			return fmt.Errorf("failed to marshal notification: %w", err)
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, notificationKey(id), payload, 0)
+			return nil
+		})
+		updated = notification
+		return err
+	}, notificationKey(id))
+
+	if errors.Is(err, redis.TxFailedErr) {
+		return nil, fmt.Errorf("%w: notification changed concurrently, retry", ErrVersionMismatch)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	telemetry.RecordStatusTransition(ctx, string(updated.Type), string(models.NotificationStatusFailed), string(updated.Status))
+	s.persist(ctx, updated)
+	if _, err := s.retries.Schedule(ctx, updated); err != nil {
+		return nil, fmt.Errorf("failed to schedule retry: %w", err)
+	}
+	return updated, nil
+}
+
+// resolved reports whether a notification's delivery has been settled, one way or the other
+func resolved(status models.NotificationStatus) bool {
+	return status == models.NotificationStatusSent || status == models.NotificationStatusDelivered ||
+		status == models.NotificationStatusFailed
+}
+
+// DeleteNotification removes a notification with its edit history and index entries
+func (s *NotificationService) DeleteNotification(ctx context.Context, id string) error {
+	notification, err := s.GetNotification(ctx, id)
+	if err != nil {
+		return err
+	}
+	records, err := s.residency.Records(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	// The row goes first: with the cache gone and the row left, a read would load the
+	// notification back from the database
+	if s.repo != nil {
+		if err := s.repo.DeleteNotification(ctx, id); err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+	}
+	if err := records.Del(ctx, notificationKey(id), notificationEditsKey(id)).Err(); err != nil {
+		return fmt.Errorf("failed to delete notification: %w", err)
+	}
+
+	pipe := s.redis.client.TxPipeline()
+	pipe.Del(ctx, notificationRegionKey(id))
+	pipe.ZRem(ctx, customerNotificationsKey(notification.CustomerID), id)
+	pipe.ZRem(ctx, conversationKey(ConversationID(notification)), id)
+	if notification.OrderID != "" {
+		pipe.SRem(ctx, orderNotificationsKey(notification.OrderID), id)
+	}
+	for _, entry := range s.metadata.IndexEntries(ctx, notification) {
+		pipe.ZRem(ctx, entry, id)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to delete notification: %w", err)
+	}
+	if err := s.redis.client.SRem(ctx, unpersistedNotificationsKey, id).Err(); err != nil {
+		slog.WarnContext(ctx, "Failed to drop deleted notification from the write-through retries", "notification.id", id, "error", err)
+	}
+	return nil
+}
+
+// unpersistedNotificationsKey is a set of the notifications whose latest change failed to
+// be written through to the database
+const unpersistedNotificationsKey = "notifications-unpersisted"
+
+// persistRetryInterval is how often failed write-throughs are retried
+const persistRetryInterval = 10 * time.Second
+
+// persist writes a notification changed in Redis through to the database. Redis holds
+// the authoritative copy while a notification is in flight, so a failure doesn't undo
+// the change: it is counted, logged as an error and queued, and Start writes the Redis
+// copy through again until it lands.
+func (s *NotificationService) persist(ctx context.Context, notification *models.Notification) {
+	if s.repo == nil {
+		return
+	}
+	err := s.repo.UpsertNotification(ctx, notification)
+	if err == nil {
+		return
+	}
+	telemetry.RecordNotificationPersistFailure(ctx, false)
+	slog.ErrorContext(ctx, "Failed to persist notification, will retry", "notification.id", notification.ID, "error", err)
+	if err := s.redis.client.SAdd(context.WithoutCancel(ctx), unpersistedNotificationsKey, notification.ID).Err(); err != nil {
+		slog.ErrorContext(ctx, "Failed to queue notification for write-through retry", "notification.id", notification.ID, "error", err)
+	}
+}
+
+// Start retries failed write-throughs until ctx is cancelled, holding off while the
+// service is read-only
+func (s *NotificationService) Start(ctx context.Context) {
+	if s.repo == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(persistRetryInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if !s.redis.ReadOnly().IsReadOnly(ctx) {
+					s.retryPersist(ctx)
+				}
+			}
+		}
+	}()
+}
+
+// retryPersist writes the Redis copy of each queued notification through again. One
+// deleted since is dropped from the queue.
+func (s *NotificationService) retryPersist(ctx context.Context) {
+	ids, err := s.redis.client.SRandMemberN(ctx, unpersistedNotificationsKey, 100).Result()
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to load notifications awaiting write-through", "error", err)
+		return
+	}
+	for _, id := range ids {
+		records, err := s.residency.Records(ctx, id)
+		if err != nil {
+			continue
+		}
+		notification, err := loadNotification(ctx, records, id)
+		if err == nil {
+			err = s.repo.UpsertNotification(ctx, notification)
+		}
+		if err != nil && !errors.Is(err, ErrNotificationNotFound) {
+			telemetry.RecordNotificationPersistFailure(ctx, true)
+			slog.WarnContext(ctx, "Write-through retry failed", "notification.id", id, "error", err)
+			continue
+		}
+		if err := s.redis.client.SRem(ctx, unpersistedNotificationsKey, id).Err(); err != nil {
+			slog.WarnContext(ctx, "Failed to finish write-through retry", "notification.id", id, "error", err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/storage"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// failingRepository records write-throughs, failing them while err is set; the methods
+// it doesn't override aren't used
+type failingRepository struct {
+	storage.NotificationRepository
+	err      error
+	upserted []*models.Notification
+}
+
+func (r *failingRepository) UpsertNotification(_ context.Context, notification *models.Notification) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.upserted = append(r.upserted, notification)
+	return nil
+}
+
+func newWriteThroughService(t *testing.T, repo storage.NotificationRepository) (*NotificationService, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	redisClient := NewRedisClient(&config.Config{RedisURL: server.Addr()})
+	residency := NewDataResidency(context.Background(), &config.Config{}, redisClient, repo)
+	return NewNotificationService(redisClient, nil, nil, nil, repo, nil, nil, residency), server
+}
+
+func storeRedisCopy(t *testing.T, server *miniredis.Miniredis, notification *models.Notification) {
+	t.Helper()
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Set(notificationKey(notification.ID), string(payload)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func unpersisted(t *testing.T, server *miniredis.Miniredis) []string {
+	t.Helper()
+	if !server.Exists(unpersistedNotificationsKey) {
+		return nil
+	}
+	ids, err := server.Members(unpersistedNotificationsKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func TestPersistQueuesFailedWriteThrough(t *testing.T) {
+	ctx := context.Background()
+	repo := &failingRepository{}
+	s, server := newWriteThroughService(t, repo)
+
+	s.persist(ctx, &models.Notification{ID: "n-1", Status: models.NotificationStatusSent})
+	if len(repo.upserted) != 1 || len(unpersisted(t, server)) != 0 {
+		t.Fatalf("upserted %d, queued %v; want the write-through to land unqueued", len(repo.upserted), unpersisted(t, server))
+	}
+
+	repo.err = errors.New("database unreachable")
+	s.persist(ctx, &models.Notification{ID: "n-2", Status: models.NotificationStatusSent})
+	if ids := unpersisted(t, server); len(ids) != 1 || ids[0] != "n-2" {
+		t.Errorf("queued %v, want [n-2]", ids)
+	}
+}
+
+func TestRetryPersistWritesRedisCopyThrough(t *testing.T) {
+	ctx := context.Background()
+	repo := &failingRepository{err: errors.New("database unreachable")}
+	s, server := newWriteThroughService(t, repo)
+
+	s.persist(ctx, &models.Notification{ID: "n-1", Status: models.NotificationStatusSent})
+	s.persist(ctx, &models.Notification{ID: "n-deleted", Status: models.NotificationStatusSent})
+	// The Redis copy moved on after the failed write-through; n-deleted is gone from it
+	storeRedisCopy(t, server, &models.Notification{ID: "n-1", Status: models.NotificationStatusDelivered, Version: 2})
+
+	// n-1 stays queued while the database still fails; n-deleted has no copy left to write
+	s.retryPersist(ctx)
+	if ids := unpersisted(t, server); len(ids) != 1 || ids[0] != "n-1" {
+		t.Fatalf("queued %v after a failed retry, want [n-1]", ids)
+	}
+
+	repo.err = nil
+	s.retryPersist(ctx)
+	if ids := unpersisted(t, server); len(ids) != 0 {
+		t.Errorf("queued %v after the database recovered, want none", ids)
+	}
+	if len(repo.upserted) != 1 {
+		t.Fatalf("upserted %d notifications, want 1", len(repo.upserted))
+	}
+	if got := repo.upserted[0]; got.ID != "n-1" || got.Status != models.NotificationStatusDelivered || got.Version != 2 {
+		t.Errorf("wrote through %+v, want the current Redis copy", got)
+	}
+}
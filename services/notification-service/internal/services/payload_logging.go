@@ -0,0 +1,292 @@
+package services
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"notification-service/internal/cache"
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"github.com/go-redis/redis/v8"
+	otellog "go.opentelemetry.io/otel/log"
+)
+
+// payloadLoggingKey holds the admin override of the payload logging settings
+const payloadLoggingKey = "payload-logging"
+
+// Bounds on an override: bodies stay small enough for a log record, and an override
+// nobody turns off lapses within a day
+const (
+	maxPayloadLogBodyBytes  = 65536
+	defaultPayloadLogTTL    = time.Hour
+	maxPayloadLogTTLSeconds = 86400
+)
+
+var ErrInvalidPayloadLogSettings = errors.New("invalid payload log settings")
+
+// pseudonymousHeaders identify a person, so they are logged as a hash that still
+// correlates one person's requests
+var pseudonymousHeaders = map[string]bool{
+	"x-user-id": true,
+}
+
+// jsonStringField matches a "name": "value" pair whose value may be cut off, for masking
+// JSON bodies that don't parse
+var jsonStringField = regexp.MustCompile(`"((?:[^"\\]|\\.)*)"(\s*:\s*)"(?:[^"\\]|\\.)*"?`)
+
+// PayloadLogger logs the headers and bodies of a sample of API requests and their
+// responses through the OTel log pipeline, for debugging an incident. Only allowlisted
+// headers are logged, secrets and PAYLOAD_LOG_MASK_FIELDS are masked, and bodies are cut
+// to the configured size. The startup settings can be overridden through the admin API
+// for every replica; the override lapses after its TTL.
+type PayloadLogger struct {
+	redis      *RedisClient
+	defaults   models.PayloadLogSettings
+	maskFields map[string]bool
+	override   *cache.Cache[*models.PayloadLogSettings]
+}
+
+func NewPayloadLogger(cfg *config.Config, redis *RedisClient) *PayloadLogger {
+	maskFields := make(map[string]bool)
+	for _, field := range strings.Split(cfg.PayloadLogMaskFields, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			maskFields[strings.ToLower(field)] = true
+		}
+	}
+
+	defaults := models.PayloadLogSettings{
+		Enabled:      cfg.PayloadLogEnabled,
+		SampleRate:   cfg.PayloadLogSampleRate,
+		MaxBodyBytes: cfg.PayloadLogMaxBodyBytes,
+		Headers:      splitHeaderNames(cfg.PayloadLogHeaders),
+		Source:       "config",
+	}
+	if err := validatePayloadLogSettings(defaults); err != nil {
+		slog.Warn("Disabling payload logging", "error", err)
+		defaults.Enabled = false
+	}
+
+	return &PayloadLogger{
+		redis:      redis,
+		defaults:   defaults,
+		maskFields: maskFields,
+		// Overrides reach other replicas within the TTL
+		override: cache.New[*models.PayloadLogSettings](nil, cache.Options{
+			Name:       "payload-logging",
+			Mode:       cache.ReadThrough,
+			L1TTL:      10 * time.Second,
+			L1MaxItems: 1,
+		}),
+	}
+}
+
+// Settings returns the settings in effect: the admin override while it lasts, otherwise
+// the configured settings
+func (l *PayloadLogger) Settings(ctx context.Context) models.PayloadLogSettings {
+	override, _ := l.override.Get(ctx, payloadLoggingKey, l.loadOverride)
+	if override != nil && (override.ExpiresAt == nil || time.Now().Before(*override.ExpiresAt)) {
+		return *override
+	}
+	return l.defaults
+}
+
+// SetSettings stores an override for every replica. It lapses after TTLSeconds, an hour
+// when unset.
+func (l *PayloadLogger) SetSettings(ctx context.Context, settings models.PayloadLogSettings) (models.PayloadLogSettings, error) {
+	if err := validatePayloadLogSettings(settings); err != nil {
+		return models.PayloadLogSettings{}, err
+	}
+	if settings.TTLSeconds < 0 || settings.TTLSeconds > maxPayloadLogTTLSeconds {
+		return models.PayloadLogSettings{}, fmt.Errorf("%w: ttl_seconds must be between 0 (an hour) and %d", ErrInvalidPayloadLogSettings, maxPayloadLogTTLSeconds)
+	}
+	ttl := defaultPayloadLogTTL
+	if settings.TTLSeconds > 0 {
+		ttl = time.Duration(settings.TTLSeconds) * time.Second
+	}
+	expiresAt := time.Now().UTC().Add(ttl)
+	settings.Headers = splitHeaderNames(strings.Join(settings.Headers, ","))
+	settings.TTLSeconds = 0
+	settings.ExpiresAt = &expiresAt
+	settings.Source = "admin"
+
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return models.PayloadLogSettings{}, err
+	}
+	if err := l.redis.client.Set(ctx, payloadLoggingKey, data, ttl).Err(); err != nil {
+		return models.PayloadLogSettings{}, fmt.Errorf("failed to store payload log settings: %w", err)
+	}
+	l.override.Delete(ctx, payloadLoggingKey)
+	slog.InfoContext(ctx, "📝 Payload logging override set", "expires_at", expiresAt, "payload_log.enabled", settings.Enabled, "payload_log.sample_rate", settings.SampleRate, "payload_log.paths", settings.Paths)
+	return settings, nil
+}
+
+// ResetSettings drops the override, restoring the configured settings
+func (l *PayloadLogger) ResetSettings(ctx context.Context) error {
+	if err := l.redis.client.Del(ctx, payloadLoggingKey).Err(); err != nil {
+		return fmt.Errorf("failed to reset payload log settings: %w", err)
+	}
+	l.override.Delete(ctx, payloadLoggingKey)
+	return nil
+}
+
+// Sample decides whether a request to path has its payloads logged, returning the
+// settings to capture it with
+func (l *PayloadLogger) Sample(ctx context.Context, path string) (models.PayloadLogSettings, bool) {
+	settings := l.Settings(ctx)
+	if !settings.Enabled || settings.SampleRate <= 0 {
+		return settings, false
+	}
+	if len(settings.Paths) > 0 {
+		matched := false
+		for _, prefix := range settings.Paths {
+			if strings.HasPrefix(path, prefix) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return settings, false
+		}
+	}
+	return settings, settings.SampleRate >= 1 || rand.Float64() < settings.SampleRate
+}
+
+// LogExchange masks a sampled exchange and emits it as a log record correlated with the
+// request span in ctx
+func (l *PayloadLogger) LogExchange(ctx context.Context, settings models.PayloadLogSettings, exchange models.HTTPExchange) {
+	if telemetry.Logger == nil {
+		return
+	}
+
+	attrs := []otellog.KeyValue{
+		otellog.String("http.request.method", exchange.Method),
+		otellog.String("url.path", exchange.Path),
+		otellog.String("http.route", exchange.Route),
+		otellog.Int("http.response.status_code", exchange.Status),
+		otellog.Int64("http.duration_ms", exchange.Duration.Milliseconds()),
+	}
+	attrs = append(attrs, l.headerAttributes("http.request.header.", settings.Headers, exchange.RequestHeader)...)
+	attrs = append(attrs, l.headerAttributes("http.response.header.", settings.Headers, exchange.ResponseHeader)...)
+	attrs = append(attrs,
+		otellog.String("http.request.body", l.maskBody(exchange.RequestHeader.Get("Content-Type"), exchange.RequestBody)),
+		otellog.Bool("http.request.body.truncated", exchange.RequestTruncated),
+		otellog.String("http.response.body", l.maskBody(exchange.ResponseHeader.Get("Content-Type"), exchange.ResponseBody)),
+		otellog.Bool("http.response.body.truncated", exchange.ResponseTruncated),
+	)
+
+	var record otellog.Record
+	record.SetTimestamp(time.Now())
+	record.SetSeverity(otellog.SeverityDebug)
+	record.SetSeverityText(otellog.SeverityDebug.String())
+	record.SetBody(otellog.StringValue(fmt.Sprintf("HTTP %s %s %d", exchange.Method, exchange.Path, exchange.Status)))
+	record.AddAttributes(attrs...)
+	telemetry.Logger.Emit(ctx, record)
+}
+
+// headerAttributes keeps the allowlisted headers, masking secrets even when allowlisted
+func (l *PayloadLogger) headerAttributes(prefix string, allowlist []string, header http.Header) []otellog.KeyValue {
+	var attrs []otellog.KeyValue
+	for _, name := range allowlist {
+		values := header.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+		value := strings.Join(values, ", ")
+		switch {
+		case sensitiveHeaders[strings.ToLower(name)]:
+			value = redacted
+		case pseudonymousHeaders[strings.ToLower(name)]:
+			sum := sha256.Sum256([]byte(value))
+			value = "sha256:" + hex.EncodeToString(sum[:8])
+		}
+		attrs = append(attrs, otellog.String(prefix+strings.ToLower(name), value))
+	}
+	return attrs
+}
+
+// sensitive matches secrets by name, as in provider payloads, and the configured mask
+// fields exactly
+func (l *PayloadLogger) sensitive(name string) bool {
+	return sensitiveField.MatchString(name) || l.maskFields[strings.ToLower(name)]
+}
+
+// maskBody masks a captured body. A truncated or malformed JSON body doesn't parse, so
+// its string fields are masked where they appear. Bodies that are neither JSON nor a
+// form can't be masked, so only their size is logged.
+func (l *PayloadLogger) maskBody(contentType string, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	if !strings.Contains(contentType, "json") && !strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		return fmt.Sprintf("[%d bytes of %s omitted]", len(body), cmp.Or(contentType, "unknown content"))
+	}
+	if strings.Contains(contentType, "json") && !json.Valid(body) {
+		return jsonStringField.ReplaceAllStringFunc(string(body), func(pair string) string {
+			match := jsonStringField.FindStringSubmatch(pair)
+			if !l.sensitive(match[1]) {
+				return pair
+			}
+			return `"` + match[1] + `"` + match[2] + `"` + redacted + `"`
+		})
+	}
+	return string(redactBody(contentType, body, l.sensitive))
+}
+
+func (l *PayloadLogger) loadOverride(ctx context.Context) (*models.PayloadLogSettings, error) {
+	data, err := l.redis.client.Get(ctx, payloadLoggingKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		// Every request checks the settings, so an outage is cached as no override
+		// rather than retried per request
+		slog.WarnContext(ctx, "Using configured payload log settings, override unavailable", "error", err)
+		return nil, nil
+	}
+	var settings models.PayloadLogSettings
+	if err := json.Unmarshal(data, &settings); err != nil {
+		slog.WarnContext(ctx, "Ignoring unreadable payload log settings", "error", err)
+		return nil, nil
+	}
+	return &settings, nil
+}
+
+func validatePayloadLogSettings(settings models.PayloadLogSettings) error {
+	if settings.SampleRate < 0 || settings.SampleRate > 1 {
+		return fmt.Errorf("%w: sample_rate must be between 0 and 1", ErrInvalidPayloadLogSettings)
+	}
+	if settings.MaxBodyBytes < 0 || settings.MaxBodyBytes > maxPayloadLogBodyBytes {
+		return fmt.Errorf("%w: max_body_bytes must be between 0 and %d", ErrInvalidPayloadLogSettings, maxPayloadLogBodyBytes)
+	}
+	for _, path := range settings.Paths {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("%w: path %q must start with /", ErrInvalidPayloadLogSettings, path)
+		}
+	}
+	return nil
+}
+
+// splitHeaderNames parses a comma-separated header allowlist into canonical names
+func splitHeaderNames(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, http.CanonicalHeaderKey(name))
+		}
+	}
+	return names
+}
@@ -0,0 +1,532 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Formats of bulk preference imports and exports
+const (
+	PreferenceFormatCSV   = "csv"
+	PreferenceFormatJSONL = "jsonl"
+)
+
+const (
+	// maxImportErrors bounds the failed rows listed in an import report
+	maxImportErrors = 1000
+	// maxImportLineBytes bounds one JSONL line
+	maxImportLineBytes = 1 << 20
+	// exportBatchSize is how many customers' preferences an export reads at once
+	exportBatchSize = 500
+)
+
+var (
+	ErrInvalidPreferenceImport  = errors.New("invalid preferences import")
+	ErrUnknownPreferencesFormat = errors.New("preferences format must be csv or jsonl")
+)
+
+// preferenceColumns are the CSV columns of imports and exports. Preferred types are
+// separated by semicolons, and categories are written as orders=true;marketing=false.
+var preferenceColumns = []string{
+	"customer_id", "email_enabled", "sms_enabled", "push_enabled", "webhook_enabled", "webhook_url", "email", "phone",
+	"preferred_types", "categories", "language", "timezone",
+	"quiet_hours_enabled", "quiet_hours_start", "quiet_hours_end", "quiet_hours_timezone",
+	"digest_enabled", "digest_interval_minutes", "digest_max_items",
+}
+
+// preferenceUpdate builds a customer's imported preferences from their stored ones,
+// which are nil for a new customer
+type preferenceUpdate func(existing *models.CustomerPreferences) (*models.CustomerPreferences, error)
+
+// preferenceRow receives each row of an import: the customer it is for and the update
+// it holds, or why it couldn't be read
+type preferenceRow func(line int, customerID string, update preferenceUpdate, err error)
+
+// ImportPreferences stores the customer preferences in a CSV or JSONL stream, one
+// customer per row. Rows are read, validated and stored one at a time, so the file is
+// never held in memory and a bad row doesn't stop the others. A JSONL row replaces the
+// customer's preferences; a CSV file starts with a header naming its columns, and
+// columns left out keep the customer's stored values. With a tenantID, the customers
+// imported are assigned to the tenant, and a customer of another tenant fails its row.
+// An error means the stream couldn't be read on; the report then covers the rows
+// before that point.
+func (s *CustomerPreferenceService) ImportPreferences(ctx context.Context, tenantID, format string, r io.Reader) (*models.PreferenceImportReport, error) {
+	ctx, span := telemetry.Tracer.Start(ctx, "preferences.import",
+		trace.WithAttributes(attribute.String("import.format", format)),
+	)
+	defer span.End()
+
+	report := &models.PreferenceImportReport{Format: format, Errors: []models.PreferenceImportError{}}
+	row := func(line int, customerID string, update preferenceUpdate, err error) {
+		report.Rows++
+		if err == nil {
+			err = s.importRow(ctx, tenantID, customerID, update)
+		}
+		if err == nil {
+			report.Imported++
+			return
+		}
+
+		report.Failed++
+		if len(report.Errors) == maxImportErrors {
+			report.ErrorsTruncated = true
+			return
+		}
+		report.Errors = append(report.Errors, models.PreferenceImportError{Line: line, CustomerID: customerID, Error: err.Error()})
+	}
+
+	var err error
+	switch format {
+	case PreferenceFormatCSV:
+		err = readPreferencesCSV(r, row)
+	case PreferenceFormatJSONL:
+		err = readPreferencesJSONL(r, row)
+	default:
+		err = ErrUnknownPreferencesFormat
+	}
+
+	span.SetAttributes(
+		attribute.Int("import.rows", report.Rows),
+		attribute.Int("import.imported", report.Imported),
+		attribute.Int("import.failed", report.Failed),
+	)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Preferences import stopped")
+	}
+	slog.InfoContext(ctx, "📥 Imported preferences", "import.format", format, "import.rows", report.Rows, "import.imported", report.Imported, "import.failed", report.Failed)
+	return report, err
+}
+
+// importRow applies an imported row to the customer's stored preferences and stores the
+// result, unless the preferences changed since they were read
+func (s *CustomerPreferenceService) importRow(ctx context.Context, tenantID, customerID string, update preferenceUpdate) error {
+	if customerID == "" {
+		return fmt.Errorf("%w: customer_id is required", ErrInvalidPreferences)
+	}
+	existing, err := loadPreferences(ctx, s.redis.client, customerID)
+	if err != nil {
+		return fmt.Errorf("failed to load preferences: %w", err)
+	}
+	if existing != nil && tenantID != "" && existing.TenantID != "" && existing.TenantID != tenantID {
+		return fmt.Errorf("%w: customer %s belongs to another tenant", ErrInvalidPreferences, customerID)
+	}
+	preferences, err := update(existing)
+	if err != nil {
+		return err
+	}
+	if tenantID != "" {
+		preferences.TenantID = tenantID
+	}
+	if err := validateImportedPreferences(preferences); err != nil {
+		return err
+	}
+	_, err = s.SetPreferences(ctx, preferences, ETag(existing))
+	return err
+}
+
+// ExportPreferences writes the preferences of a tenant's customers to w as CSV or JSONL,
+// in the form imports take, and returns how many customers it wrote. An empty tenantID
+// exports every customer. Customers are read in batches as they are written, in no
+// particular order.
+func (s *CustomerPreferenceService) ExportPreferences(ctx context.Context, tenantID, format string, w io.Writer) (int, error) {
+	var write func(preferences *models.CustomerPreferences) error
+	flush := func() error { return nil }
+	switch format {
+	case PreferenceFormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(preferenceColumns); err != nil {
+			return 0, err
+		}
+		write = func(preferences *models.CustomerPreferences) error {
+			return writer.Write(preferencesRecord(preferences))
+		}
+		flush = func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+	case PreferenceFormatJSONL:
+		encoder := json.NewEncoder(w)
+		write = func(preferences *models.CustomerPreferences) error { return encoder.Encode(preferences) }
+	default:
+		return 0, ErrUnknownPreferencesFormat
+	}
+
+	exported := 0
+	keys := make([]string, 0, exportBatchSize)
+	writeBatch := func() error {
+		if len(keys) == 0 {
+			return nil
+		}
+		values, err := s.redis.client.MGet(ctx, keys...).Result()
+		if err != nil {
+			return fmt.Errorf("failed to load preferences: %w", err)
+		}
+		for i, value := range values {
+			payload, ok := value.(string)
+			if !ok {
+				// Removed since the scan
+				continue
+			}
+			var preferences models.CustomerPreferences
+			if err := json.Unmarshal([]byte(payload), &preferences); err != nil {
+				slog.WarnContext(ctx, "Leaving unreadable preferences out of the export", "preferences.key", keys[i], "error", err)
+				continue
+			}
+			if tenantID != "" && preferences.TenantID != tenantID {
+				continue
+			}
+			if err := write(&preferences); err != nil {
+				return err
+			}
+			exported++
+		}
+		keys = keys[:0]
+		return flush()
+	}
+
+	// A tenant's customers are listed in its set; only an export of every tenant scans
+	iter := s.redis.client.Scan(ctx, 0, preferencesKey("*"), exportBatchSize).Iterator()
+	key := func(value string) string { return value }
+	if tenantID != "" {
+		iter = s.redis.client.SScan(ctx, preferencesTenantKey(tenantID), 0, "", exportBatchSize).Iterator()
+		key = preferencesKey
+	}
+	for iter.Next(ctx) {
+		if keys = append(keys, key(iter.Val())); len(keys) == exportBatchSize {
+			if err := writeBatch(); err != nil {
+				return exported, err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return exported, fmt.Errorf("failed to list preferences: %w", err)
+	}
+	return exported, writeBatch()
+}
+
+// readPreferencesCSV reads a CSV import. A malformed row is reported and skipped; a
+// bad header stops the import.
+func readPreferencesCSV(r io.Reader, row preferenceRow) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w: the CSV header is missing", ErrInvalidPreferenceImport)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPreferenceImport, err)
+	}
+	columns, err := preferencesHeader(header)
+	if err != nil {
+		return err
+	}
+	idColumn := slices.Index(columns, "customer_id")
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			row(parseErr.StartLine, "", nil, parseErr.Err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidPreferenceImport, err)
+		}
+
+		line, _ := reader.FieldPos(0)
+		if len(record) != len(columns) {
+			row(line, "", nil, fmt.Errorf("row has %d fields, the header %d", len(record), len(columns)))
+			continue
+		}
+		row(line, strings.TrimSpace(record[idColumn]), func(existing *models.CustomerPreferences) (*models.CustomerPreferences, error) {
+			return parsePreferencesRecord(columns, record, existing)
+		}, nil)
+	}
+}
+
+// readPreferencesJSONL reads a JSONL import, one preferences object per line; blank
+// lines are skipped
+func readPreferencesJSONL(r io.Reader, row preferenceRow) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxImportLineBytes)
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		var preferences models.CustomerPreferences
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&preferences); err != nil {
+			row(line, "", nil, err)
+			continue
+		}
+		row(line, preferences.CustomerID, func(*models.CustomerPreferences) (*models.CustomerPreferences, error) {
+			return &preferences, nil
+		}, nil)
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return fmt.Errorf("%w: line %d is longer than %d bytes", ErrInvalidPreferenceImport, line+1, maxImportLineBytes)
+		}
+		return fmt.Errorf("%w: %v", ErrInvalidPreferenceImport, err)
+	}
+	return nil
+}
+
+// preferencesHeader checks the columns of a CSV import: known, not repeated, and
+// including customer_id
+func preferencesHeader(header []string) ([]string, error) {
+	known := make(map[string]bool, len(preferenceColumns))
+	for _, column := range preferenceColumns {
+		known[column] = true
+	}
+
+	columns := make([]string, len(header))
+	seen := make(map[string]bool, len(header))
+	for i, column := range header {
+		if i == 0 {
+			// Spreadsheet exports often start with a byte order mark
+			column = strings.TrimPrefix(column, "\ufeff")
+		}
+		column = strings.ToLower(strings.TrimSpace(column))
+		if !known[column] {
+			return nil, fmt.Errorf("%w: unknown column %q (expected %s)", ErrInvalidPreferenceImport, column, strings.Join(preferenceColumns, ", "))
+		}
+		if seen[column] {
+			return nil, fmt.Errorf("%w: column %q appears twice", ErrInvalidPreferenceImport, column)
+		}
+		seen[column] = true
+		columns[i] = column
+	}
+	if !seen["customer_id"] {
+		return nil, fmt.Errorf("%w: the customer_id column is required", ErrInvalidPreferenceImport)
+	}
+	return columns, nil
+}
+
+// parsePreferencesRecord reads one CSV row over the customer's existing preferences,
+// which columns left out of the header keep. Quiet hours are set when any of their
+// columns is, and new ones are enabled unless quiet_hours_enabled says otherwise. Digest
+// preferences are set when any of theirs is. Quiet hours and digests whose columns are
+// all left out stay as they were.
+func parsePreferencesRecord(columns, record []string, existing *models.CustomerPreferences) (*models.CustomerPreferences, error) {
+	preferences := &models.CustomerPreferences{}
+	if existing != nil {
+		*preferences = *existing
+	}
+	quietHours := models.QuietHours{Enabled: true}
+	if preferences.QuietHours != nil {
+		quietHours = *preferences.QuietHours
+	}
+	var digest models.DigestPreferences
+	if preferences.Digest != nil {
+		digest = *preferences.Digest
+	}
+	quietColumns, digestColumns, digestSet := false, false, false
+	for i, column := range columns {
+		value := strings.TrimSpace(record[i])
+		var err error
+		switch column {
+		case "customer_id":
+			preferences.CustomerID = value
+		case "email_enabled":
+			preferences.EmailEnabled, err = parseFlag(value)
+		case "sms_enabled":
+			preferences.SMSEnabled, err = parseFlag(value)
+		case "push_enabled":
+			preferences.PushEnabled, err = parseFlag(value)
+		case "webhook_enabled":
+			preferences.WebhookEnabled, err = parseFlag(value)
+		case "webhook_url":
+			preferences.WebhookURL = value
+		case "email":
+			preferences.Email = value
+		case "phone":
+			preferences.Phone = value
+		case "preferred_types":
+			preferences.PreferredTypes = nil
+			for _, channel := range splitList(value) {
+				preferences.PreferredTypes = append(preferences.PreferredTypes, models.NotificationType(channel))
+			}
+		case "categories":
+			preferences.Categories = nil
+			for _, entry := range splitList(value) {
+				name, flag, found := strings.Cut(entry, "=")
+				if !found {
+					return preferences, fmt.Errorf("categories: %q must be name=true or name=false", entry)
+				}
+				enabled, err := parseFlag(strings.TrimSpace(flag))
+				if err != nil {
+					return preferences, fmt.Errorf("categories: %q must be name=true or name=false", entry)
+				}
+				if preferences.Categories == nil {
+					preferences.Categories = make(map[string]bool)
+				}
+				preferences.Categories[strings.TrimSpace(name)] = enabled
+			}
+		case "language":
+			preferences.Language = value
+		case "timezone":
+			preferences.Timezone = value
+		case "quiet_hours_enabled":
+			if value != "" {
+				quietHours.Enabled, err = parseFlag(value)
+			}
+		case "quiet_hours_start":
+			quietHours.StartTime = value
+			quietColumns = true
+		case "quiet_hours_end":
+			quietHours.EndTime = value
+			quietColumns = true
+		case "quiet_hours_timezone":
+			quietHours.Timezone = value
+			quietColumns = true
+		case "digest_enabled":
+			digest.Enabled, err = parseFlag(value)
+			digestColumns = true
+			digestSet = digestSet || value != ""
+		case "digest_interval_minutes", "digest_max_items":
+			digestColumns = true
+			number := 0
+			if value != "" {
+				if number, err = strconv.Atoi(value); err != nil {
+					return preferences, fmt.Errorf("%s: %q is not a number", column, value)
+				}
+				digestSet = true
+			}
+			if column == "digest_interval_minutes" {
+				digest.IntervalMinutes = number
+			} else {
+				digest.MaxItems = number
+			}
+		}
+		if err != nil {
+			return preferences, fmt.Errorf("%s: %q is not true or false", column, value)
+		}
+	}
+	if quietColumns || preferences.QuietHours != nil {
+		preferences.QuietHours = nil
+		if quietHours.StartTime != "" || quietHours.EndTime != "" || quietHours.Timezone != "" {
+			preferences.QuietHours = &quietHours
+		}
+	}
+	if digestColumns {
+		preferences.Digest = nil
+		if digestSet {
+			preferences.Digest = &digest
+		}
+	} else if preferences.Digest != nil {
+		preferences.Digest = &digest
+	}
+	return preferences, nil
+}
+
+// preferencesRecord writes preferences as a CSV row in preferenceColumns order
+func preferencesRecord(preferences *models.CustomerPreferences) []string {
+	channels := make([]string, len(preferences.PreferredTypes))
+	for i, channel := range preferences.PreferredTypes {
+		channels[i] = string(channel)
+	}
+	categories := make([]string, 0, len(preferences.Categories))
+	for name, enabled := range preferences.Categories {
+		categories = append(categories, name+"="+strconv.FormatBool(enabled))
+	}
+	sort.Strings(categories)
+
+	var quietHours models.QuietHours
+	if preferences.QuietHours != nil {
+		quietHours = *preferences.QuietHours
+	}
+	var digest models.DigestPreferences
+	if preferences.Digest != nil {
+		digest = *preferences.Digest
+	}
+	return []string{
+		preferences.CustomerID,
+		strconv.FormatBool(preferences.EmailEnabled),
+		strconv.FormatBool(preferences.SMSEnabled),
+		strconv.FormatBool(preferences.PushEnabled),
+		strconv.FormatBool(preferences.WebhookEnabled),
+		preferences.WebhookURL,
+		preferences.Email,
+		preferences.Phone,
+		strings.Join(channels, ";"),
+		strings.Join(categories, ";"),
+		preferences.Language,
+		preferences.Timezone,
+		strconv.FormatBool(quietHours.Enabled),
+		quietHours.StartTime,
+		quietHours.EndTime,
+		quietHours.Timezone,
+		strconv.FormatBool(digest.Enabled),
+		optionalInt(digest.IntervalMinutes),
+		optionalInt(digest.MaxItems),
+	}
+}
+
+// optionalInt writes an unset number as an empty CSV field
+func optionalInt(value int) string {
+	if value == 0 {
+		return ""
+	}
+	return strconv.Itoa(value)
+}
+
+// validateImportedPreferences checks what SetPreferences doesn't: imported files are
+// written by hand or other systems, so channels are checked too
+func validateImportedPreferences(preferences *models.CustomerPreferences) error {
+	for _, channel := range preferences.PreferredTypes {
+		switch channel {
+		case models.NotificationTypeEmail, models.NotificationTypeSMS, models.NotificationTypePush,
+			models.NotificationTypeWebSocket, models.NotificationTypeWebhook:
+		default:
+			return fmt.Errorf("%w: unknown preferred type %q", ErrInvalidPreferences, channel)
+		}
+	}
+	return nil
+}
+
+// parseFlag reads a true/false cell; an empty cell is false
+func parseFlag(value string) (bool, error) {
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+// splitList splits a semicolon-separated cell, dropping empty entries
+func splitList(value string) []string {
+	var entries []string
+	for _, entry := range strings.Split(value, ";") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,512 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+	// Quiet hours and local schedules use IANA time zones; the runtime image has no zoneinfo
+	_ "time/tzdata"
+
+	"notification-service/internal/cache"
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// CategoryMetadata is the notification metadata key holding its category, which
+// customers can opt out of in their preferences
+const CategoryMetadata = "category"
+
+// OrderEventsCategory is the category of notifications built from order events
+const OrderEventsCategory = "orders"
+
+var (
+	ErrPreferencesNotFound = errors.New("customer preferences not found")
+	ErrInvalidPreferences  = errors.New("invalid customer preferences")
+	// ErrNoContact means a customer has no address on a channel to send to
+	ErrNoContact = errors.New("customer has no contact on channel")
+)
+
+// CustomerPreferenceService stores customer preferences in Redis and checks
+// notifications against them before they are sent. Channel toggles and category
+// opt-outs suppress a notification. Quiet hours hold email, SMS and push notifications
+// below urgent priority until the window ends, or suppress them with
+// QUIET_HOURS_ACTION=suppress. Customers without preferences get everything, and so
+// does every customer when preferences can't be read. With SERVICE_REGION set, each
+// region also keeps the last preferences it wrote, and reads merge them with
+// mergePreferences, so concurrent writes from two regions are settled the same way in both.
+type CustomerPreferenceService struct {
+	redis          *RedisClient
+	preferences    *cache.Cache[*models.CustomerPreferences]
+	quietAction    models.PreferenceAction
+	defaultZone    *time.Location
+	pastAction     string
+	region         string
+	conflictWindow time.Duration
+	phones         *RecipientNormalizer
+}
+
+func NewCustomerPreferenceService(cfg *config.Config, redis *RedisClient) *CustomerPreferenceService {
+	quietAction := models.PreferenceAction(cfg.QuietHoursAction)
+	if quietAction != models.PreferenceActionDefer && quietAction != models.PreferenceActionSuppress {
+		slog.Warn("Ignoring QUIET_HOURS_ACTION, deferring notifications during quiet hours", "quiet_hours_action", cfg.QuietHoursAction)
+		quietAction = models.PreferenceActionDefer
+	}
+
+	defaultZone, err := time.LoadLocation(cfg.DefaultTimezone)
+	if err != nil {
+		slog.Warn("Ignoring DEFAULT_TIMEZONE, scheduling recipient-local notifications in UTC", "default_timezone", cfg.DefaultTimezone)
+		defaultZone = time.UTC
+	}
+	pastAction := cfg.LocalSchedulePastAction
+	switch pastAction {
+	case LocalPastNextDay, LocalPastSendNow, LocalPastSkip:
+	default:
+		slog.Warn("Ignoring LOCAL_SCHEDULE_PAST_ACTION, sending passed recipient-local notifications the next day", "local_schedule_past_action", pastAction)
+		pastAction = LocalPastNextDay
+	}
+
+	return &CustomerPreferenceService{
+		redis: redis,
+		// Updates reach other replicas within the TTL
+		preferences: cache.New[*models.CustomerPreferences](nil, cache.Options{
+			Name:       "customer-preferences",
+			Mode:       cache.ReadThrough,
+			L1TTL:      time.Duration(max(cfg.PreferencesCacheTTLSeconds, 1)) * time.Second,
+			L1MaxItems: 10000,
+		}),
+		quietAction:    quietAction,
+		defaultZone:    defaultZone,
+		pastAction:     pastAction,
+		region:         cfg.ServiceRegion,
+		conflictWindow: time.Duration(cfg.PreferenceConflictWindowSeconds) * time.Second,
+		phones:         NewRecipientNormalizer(cfg),
+	}
+}
+
+// Preferences returns a customer's preferences
+func (s *CustomerPreferenceService) Preferences(ctx context.Context, customerID string) (*models.CustomerPreferences, error) {
+	preferences, err := s.lookup(ctx, customerID)
+	if err != nil {
+		return nil, err
+	}
+	if preferences == nil {
+		return nil, fmt.Errorf("%w: %s", ErrPreferencesNotFound, customerID)
+	}
+	return preferences, nil
+}
+
+// SetPreferences replaces a customer's preferences, keeping when they were first
+// created and the tenant they belong to, which is set once. A non-empty ifMatch must list the ETag of the current preferences, and fails
+// when the customer has none yet.
+func (s *CustomerPreferenceService) SetPreferences(ctx context.Context, preferences *models.CustomerPreferences, ifMatch string) (*models.CustomerPreferences, error) {
+	if preferences.CustomerID == "" {
+		return nil, fmt.Errorf("%w: customer_id is required", ErrInvalidPreferences)
+	}
+	if q := preferences.QuietHours; q != nil {
+		if _, _, _, err := parseQuietHours(q); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidPreferences, err)
+		}
+	}
+	if preferences.Timezone != "" {
+		if _, err := time.LoadLocation(preferences.Timezone); err != nil {
+			return nil, fmt.Errorf("%w: timezone %q is not a known time zone", ErrInvalidPreferences, preferences.Timezone)
+		}
+	}
+	if preferences.WebhookURL != "" {
+		if _, err := ValidateWebhookURL(preferences.WebhookURL); err != nil {
+			return nil, fmt.Errorf("%w: webhook_url: %v", ErrInvalidPreferences, err)
+		}
+	}
+	if preferences.Email != "" {
+		email, err := NormalizeEmail(preferences.Email)
+		if err != nil {
+			return nil, fmt.Errorf("%w: email: %v", ErrInvalidPreferences, err)
+		}
+		preferences.Email = email
+	}
+	if preferences.Phone != "" {
+		phone, err := s.phones.NormalizePhone(preferences.Phone)
+		if err != nil {
+			return nil, fmt.Errorf("%w: phone: %v", ErrInvalidPreferences, err)
+		}
+		preferences.Phone = phone
+	}
+	if d := preferences.Digest; d != nil {
+		if d.IntervalMinutes < 0 || d.IntervalMinutes > maxDigestIntervalMinutes {
+			return nil, fmt.Errorf("%w: digest interval_minutes must be between 1 and %d", ErrInvalidPreferences, maxDigestIntervalMinutes)
+		}
+		if d.MaxItems < 0 || d.MaxItems > maxDigestItems {
+			return nil, fmt.Errorf("%w: digest max_items must be between 1 and %d", ErrInvalidPreferences, maxDigestItems)
+		}
+	}
+
+	key := preferencesKey(preferences.CustomerID)
+	err := watchKey(ctx, s.redis.client, key, func(tx *redis.Tx) error {
+		existing, err := loadPreferences(ctx, tx, preferences.CustomerID)
+		if err != nil && ifMatch != "" {
+			return err
+		}
+		if err := checkIfMatch(ifMatch, ETag(existing)); err != nil {
+			return err
+		}
+
+		now := time.Now().UTC()
+		preferences.CreatedAt, preferences.UpdatedAt = now, now
+		preferences.UpdatedRegion = s.region
+		if existing != nil {
+			preferences.CreatedAt = existing.CreatedAt
+			if existing.TenantID != "" {
+				preferences.TenantID = existing.TenantID
+			}
+		}
+		payload, err := json.Marshal(preferences)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			s.store(ctx, pipe, preferences.CustomerID, payload)
+			if preferences.TenantID != "" {
+				pipe.SAdd(ctx, preferencesTenantKey(preferences.TenantID), preferences.CustomerID)
+			}
+			return nil
+		}); err != nil {
+			return fmt.Errorf("failed to store preferences: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.preferences.Delete(ctx, preferences.CustomerID)
+	return preferences, nil
+}
+
+// SetSMSEnabled turns a customer's SMS toggle on or off, as when they reply STOP or
+// START. Customers without preferences get the defaults with SMS off on opt-out, and
+// are left without preferences on opt-in, since they already get everything.
+func (s *CustomerPreferenceService) SetSMSEnabled(ctx context.Context, customerID string, enabled bool) error {
+	key := preferencesKey(customerID)
+	err := watchKey(ctx, s.redis.client, key, func(tx *redis.Tx) error {
+		preferences, err := loadPreferences(ctx, tx, customerID)
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		if preferences == nil {
+			if enabled {
+				return nil
+			}
+			preferences = &models.CustomerPreferences{
+				CustomerID:     customerID,
+				EmailEnabled:   true,
+				PushEnabled:    true,
+				WebhookEnabled: true,
+				CreatedAt:      now,
+			}
+		} else if preferences.SMSEnabled == enabled {
+			return nil
+		}
+		preferences.SMSEnabled = enabled
+		preferences.UpdatedAt = now
+		preferences.UpdatedRegion = s.region
+		payload, err := json.Marshal(preferences)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			s.store(ctx, pipe, customerID, payload)
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update SMS preference: %w", err)
+	}
+	s.preferences.Delete(ctx, customerID)
+	return nil
+}
+
+// Address points a notification at its customer on its channel, for notifications
+// addressed by customer rather than by recipient: the email address or phone number in
+// their preferences, every one of their devices for push, and the customer itself for
+// webhooks, whose endpoint is in their preferences, and WebSocket. It fails with
+// ErrNoContact when the customer has no address on the channel.
+func (s *CustomerPreferenceService) Address(ctx context.Context, notification *models.Notification) error {
+	customerID := notification.CustomerID
+	switch notification.Type {
+	case models.NotificationTypeEmail, models.NotificationTypeSMS:
+		preferences, err := s.lookup(ctx, customerID)
+		if err != nil {
+			return fmt.Errorf("failed to load preferences: %w", err)
+		}
+		contact := ""
+		if preferences != nil && notification.Type == models.NotificationTypeEmail {
+			contact = preferences.Email
+		} else if preferences != nil {
+			contact = preferences.Phone
+		}
+		if contact == "" {
+			return fmt.Errorf("%w: %s has no %s contact in their preferences", ErrNoContact, customerID, notification.Type)
+		}
+		notification.Recipient = contact
+	case models.NotificationTypePush:
+		notification.Recipient = customerID
+		if notification.Target == nil {
+			notification.Target = &models.DeviceTarget{}
+		}
+	case models.NotificationTypeWebhook, models.NotificationTypeWebSocket:
+		notification.Recipient = customerID
+	default:
+		return fmt.Errorf("%w: %s notifications can't be addressed to a customer", ErrNoContact, notification.Type)
+	}
+	return nil
+}
+
+// Check decides whether a notification may be sent at the given time under its
+// customer's preferences
+func (s *CustomerPreferenceService) Check(ctx context.Context, notification *models.Notification, at time.Time) models.PreferenceDecision {
+	decision := s.check(ctx, notification, at)
+
+	attributes := []attribute.KeyValue{attribute.String("preferences.action", string(decision.Action))}
+	if decision.Reason != "" {
+		attributes = append(attributes, attribute.String("preferences.reason", decision.Reason))
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attributes...)
+	return decision
+}
+
+func (s *CustomerPreferenceService) check(ctx context.Context, notification *models.Notification, at time.Time) models.PreferenceDecision {
+	send := models.PreferenceDecision{Action: models.PreferenceActionSend}
+	if notification.Type == models.NotificationTypeSMS && notification.Recipient != "" {
+		optedOut, err := checkSMSOptOut(ctx, s.redis.client, s.phones, notification)
+		if err != nil {
+			slog.WarnContext(ctx, "SMS opt-outs unavailable, sending notification", "notification.id", notification.ID, "error", err)
+		} else if optedOut {
+			return models.PreferenceDecision{Action: models.PreferenceActionSuppress, Reason: models.SuppressionSMSOptedOut}
+		}
+	}
+
+	customerID := notification.CustomerID
+	if customerID == "" {
+		customerID = notification.Recipient
+	}
+	if customerID == "" {
+		return send
+	}
+
+	preferences, err := s.lookup(ctx, customerID)
+	if err != nil {
+		slog.WarnContext(ctx, "Preferences unavailable, sending notification", "customer.id", customerID, "notification.id", notification.ID, "error", err)
+		return send
+	}
+	if preferences == nil {
+		return send
+	}
+
+	if !channelEnabled(preferences, notification.Type) {
+		return models.PreferenceDecision{Action: models.PreferenceActionSuppress, Reason: models.SuppressionChannelDisabled}
+	}
+	if category, _ := notification.Metadata[CategoryMetadata].(string); category != "" {
+		if enabled, ok := preferences.Categories[category]; ok && !enabled {
+			return models.PreferenceDecision{Action: models.PreferenceActionSuppress, Reason: models.SuppressionCategoryOptedOut}
+		}
+	}
+
+	if notification.Priority == models.PriorityUrgent || !interruptive(notification.Type) || preferences.QuietHours == nil {
+		return send
+	}
+	until, quiet := quietHoursEnd(preferences.QuietHours, at)
+	if !quiet {
+		return send
+	}
+	decision := models.PreferenceDecision{Action: s.quietAction, Reason: models.SuppressionQuietHours}
+	if s.quietAction == models.PreferenceActionDefer {
+		decision.Until = &until
+	}
+	return decision
+}
+
+// lookup returns a customer's preferences through the cache, or nil when they have none
+func (s *CustomerPreferenceService) lookup(ctx context.Context, customerID string) (*models.CustomerPreferences, error) {
+	return s.preferences.Get(ctx, customerID, func(ctx context.Context) (*models.CustomerPreferences, error) {
+		return s.load(ctx, customerID)
+	})
+}
+
+func (s *CustomerPreferenceService) load(ctx context.Context, customerID string) (*models.CustomerPreferences, error) {
+	preferences, err := loadPreferences(ctx, s.redis.client, customerID)
+	if err != nil || s.region == "" {
+		return preferences, err
+	}
+	return s.reconcile(ctx, customerID, preferences), nil
+}
+
+// store writes a customer's preferences and, in an active-active deployment, this
+// region's copy of them
+func (s *CustomerPreferenceService) store(ctx context.Context, pipe redis.Pipeliner, customerID string, payload []byte) {
+	pipe.Set(ctx, preferencesKey(customerID), payload, 0)
+	if s.region != "" {
+		pipe.HSet(ctx, preferenceWritesKey(customerID), s.region, payload)
+	}
+}
+
+// reconcile merges the copies the regions wrote of a customer's preferences into
+// current, and stores the result when replication kept a different one. A copy that
+// can't be read leaves current as it is.
+func (s *CustomerPreferenceService) reconcile(ctx context.Context, customerID string, current *models.CustomerPreferences) *models.CustomerPreferences {
+	copies, err := s.redis.client.HGetAll(ctx, preferenceWritesKey(customerID)).Result()
+	if err != nil {
+		slog.WarnContext(ctx, "Regional preference writes unavailable", "customer.id", customerID, "error", err)
+		return current
+	}
+	writes := make([]*models.CustomerPreferences, 0, len(copies)+1)
+	if current != nil {
+		writes = append(writes, current)
+	}
+	for region, payload := range copies {
+		var preferences models.CustomerPreferences
+		if err := json.Unmarshal([]byte(payload), &preferences); err != nil {
+			slog.WarnContext(ctx, "Ignoring undecodable regional preference write", "customer.id", customerID, "data.region", region, "error", err)
+			continue
+		}
+		writes = append(writes, &preferences)
+	}
+
+	merged, conflicted := mergePreferences(writes, s.conflictWindow)
+	if merged == nil || ETag(merged) == ETag(current) {
+		return merged
+	}
+	if conflicted {
+		telemetry.RecordPreferenceConflict(ctx)
+		slog.InfoContext(ctx, "Merged concurrent preference writes", "customer.id", customerID)
+	}
+
+	// A write made since current was read wins over the merge; the next read merges it
+	key := preferencesKey(customerID)
+	expected := ETag(current)
+	err = watchKey(ctx, s.redis.client, key, func(tx *redis.Tx) error {
+		stored, err := loadPreferences(ctx, tx, customerID)
+		if err != nil || ETag(stored) != expected {
+			return err
+		}
+		payload, err := json.Marshal(merged)
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, key, payload, 0)
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to store merged preferences", "customer.id", customerID, "error", err)
+	}
+	return merged
+}
+
+func loadPreferences(ctx context.Context, client redis.Cmdable, customerID string) (*models.CustomerPreferences, error) {
+	payload, err := client.Get(ctx, preferencesKey(customerID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var preferences models.CustomerPreferences
+	if err := json.Unmarshal(payload, &preferences); err != nil {
+		return nil, fmt.Errorf("failed to decode preferences for %s: %w", customerID, err)
+	}
+	return &preferences, nil
+}
+
+// channelEnabled applies the per-channel toggles; WebSocket has none
+func channelEnabled(preferences *models.CustomerPreferences, channel models.NotificationType) bool {
+	switch channel {
+	case models.NotificationTypeEmail:
+		return preferences.EmailEnabled
+	case models.NotificationTypeSMS:
+		return preferences.SMSEnabled
+	case models.NotificationTypePush:
+		return preferences.PushEnabled
+	case models.NotificationTypeWebhook:
+		return preferences.WebhookEnabled
+	default:
+		return true
+	}
+}
+
+// interruptive channels reach the customer's device and are held for quiet hours;
+// in-app WebSocket messages and machine-to-machine webhooks are not
+func interruptive(channel models.NotificationType) bool {
+	switch channel {
+	case models.NotificationTypeEmail, models.NotificationTypeSMS, models.NotificationTypePush:
+		return true
+	default:
+		return false
+	}
+}
+
+// quietHoursEnd reports whether at falls in the quiet hours and when they end. Times are
+// wall-clock times in the window's time zone, so the window follows daylight saving
+// changes; a window whose end is before its start runs past midnight.
+func quietHoursEnd(q *models.QuietHours, at time.Time) (time.Time, bool) {
+	if !q.Enabled {
+		return time.Time{}, false
+	}
+	start, end, location, err := parseQuietHours(q)
+	if err != nil || start == end {
+		return time.Time{}, false
+	}
+
+	local := at.In(location)
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, location)
+	clock := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute +
+		time.Duration(local.Second())*time.Second
+	endOn := func(days int) time.Time {
+		day := midnight.AddDate(0, 0, days)
+		return time.Date(day.Year(), day.Month(), day.Day(), int(end/time.Hour), int(end%time.Hour/time.Minute), 0, 0, location).UTC()
+	}
+
+	switch {
+	case start < end && clock >= start && clock < end:
+		return endOn(0), true
+	case start > end && clock >= start:
+		return endOn(1), true
+	case start > end && clock < end:
+		return endOn(0), true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// parseQuietHours reads the window's HH:MM bounds as offsets from midnight and its time
+// zone, UTC when unset
+func parseQuietHours(q *models.QuietHours) (time.Duration, time.Duration, *time.Location, error) {
+	start, err := time.Parse("15:04", q.StartTime)
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("quiet_hours.start_time %q must be HH:MM", q.StartTime)
+	}
+	end, err := time.Parse("15:04", q.EndTime)
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("quiet_hours.end_time %q must be HH:MM", q.EndTime)
+	}
+	location := time.UTC
+	if q.Timezone != "" {
+		if location, err = time.LoadLocation(q.Timezone); err != nil {
+			return 0, 0, nil, fmt.Errorf("quiet_hours.timezone %q is not a known time zone", q.Timezone)
+		}
+	}
+	offset := func(t time.Time) time.Duration {
+		return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	return offset(start), offset(end), location, nil
+}
@@ -0,0 +1,410 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Presence lifecycle event types published when PRESENCE_EVENTS_ENABLED is set
+const (
+	PresenceEventOnline  = "CustomerOnline"
+	PresenceEventOffline = "CustomerOffline"
+)
+
+// presenceLastSeenKey records when each customer last went online or offline
+const presenceLastSeenKey = "presence-last-seen"
+
+// presenceConnectionsKey holds each instance's WebSocket connection count as
+// "<count>:<unix expiry>", refreshed by its heartbeat
+const presenceConnectionsKey = "presence-connections"
+
+// presenceInstancesKey holds every instance that has recorded presence, so the others can
+// notice when one stops heartbeating
+const presenceInstancesKey = "presence-instances"
+
+// presenceAliveKey expires unless the instance's heartbeat refreshes it
+func presenceAliveKey(instanceID string) string {
+	return "presence-alive:" + instanceID
+}
+
+// presenceInstanceCustomersKey holds the customers connected to an instance, whose
+// presence is cleared if it stops heartbeating
+func presenceInstanceCustomersKey(instanceID string) string {
+	return "presence-customers:" + instanceID
+}
+
+// presenceSweepKey is held by the instance clearing the presence of one that stopped
+func presenceSweepKey(instanceID string) string {
+	return "presence-sweep:" + instanceID
+}
+
+// presenceDeliveryChannel is the backplane channel an instance receives targeted
+// WebSocket messages on for the customers connected to it
+func presenceDeliveryChannel(instanceID string) string {
+	return "presence-deliver:" + instanceID
+}
+
+// presenceDelivery is a targeted WebSocket message forwarded over the backplane
+type presenceDelivery struct {
+	CustomerID string          `json:"customer_id"`
+	ID         string          `json:"id,omitempty"`
+	Payload    json.RawMessage `json:"payload"`
+}
+
+// presenceKey holds the instances a customer is connected to, scored by the unix time
+// each entry expires unless refreshed by that instance's heartbeat
+func presenceKey(customerID string) string {
+	return "presence:" + customerID
+}
+
+type presenceChange struct {
+	customerID string
+	online     bool
+}
+
+// PresenceService tracks which customers are connected to any replica. Each instance
+// records its own connections in Redis and refreshes them, with a TTL key of its own, on
+// a heartbeat. When an instance's key expires, another marks its customers offline.
+// Targeted WebSocket messages for customers connected elsewhere are forwarded over a
+// Redis pub/sub backplane to the instances holding their connections.
+type PresenceService struct {
+	redis         *RedisClient
+	hub           *models.Hub
+	producer      *EventHubProducer
+	instanceID    string
+	ttl           time.Duration
+	eventsEnabled bool
+	changes       chan presenceChange
+}
+
+func NewPresenceService(cfg *config.Config, redis *RedisClient, hub *models.Hub, producer *EventHubProducer) *PresenceService {
+	instanceID, err := os.Hostname()
+	if err != nil || instanceID == "" {
+		instanceID = "notification-service"
+	}
+	ttl := time.Duration(cfg.PresenceTTLSeconds) * time.Second
+	if ttl <= 0 {
+		ttl = time.Minute
+	}
+	return &PresenceService{
+		redis:         redis,
+		hub:           hub,
+		producer:      producer,
+		instanceID:    instanceID,
+		ttl:           ttl,
+		eventsEnabled: cfg.PresenceEventsEnabled,
+		changes:       make(chan presenceChange, 1024),
+	}
+}
+
+// Start hooks into the hub and runs the change processor, heartbeat and backplane until
+// ctx ends. Presence left by this instance's previous run is cleared first.
+func (s *PresenceService) Start(ctx context.Context) {
+	s.sweep(ctx, s.instanceID)
+	s.hub.SetRemoteDelivery(s.forward)
+
+	s.hub.SetPresenceHook(func(customerID string, online bool) {
+		select {
+		case s.changes <- presenceChange{customerID: customerID, online: online}:
+		default:
+			// The heartbeat re-announces connected customers; a dropped offline entry expires
+			slog.WarnContext(ctx, "Presence change dropped, queue full", "customer.id", customerID)
+		}
+	})
+
+	pubsub := s.redis.client.Subscribe(ctx, presenceDeliveryChannel(s.instanceID))
+	go func() {
+		<-ctx.Done()
+		pubsub.Close()
+	}()
+	go func() {
+		for message := range pubsub.Channel() {
+			var delivery presenceDelivery
+			if err := json.Unmarshal([]byte(message.Payload), &delivery); err != nil {
+				slog.WarnContext(ctx, "Dropping undecodable forwarded WebSocket message", "error", err)
+				continue
+			}
+			s.hub.DeliverLocal(delivery.CustomerID, delivery.ID, delivery.Payload)
+		}
+	}()
+
+	s.heartbeat(ctx)
+	go func() {
+		ticker := time.NewTicker(s.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case change := <-s.changes:
+				s.apply(ctx, change)
+			case <-ticker.C:
+				s.heartbeat(ctx)
+				s.sweepStopped(ctx)
+			}
+		}
+	}()
+}
+
+// apply records a local presence change and publishes an event when it flips the
+// customer's cluster-wide presence
+func (s *PresenceService) apply(ctx context.Context, change presenceChange) {
+	key := presenceKey(change.customerID)
+	now := time.Now()
+
+	pipe := s.redis.client.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.Unix(), 10))
+	if change.online {
+		pipe.ZAdd(ctx, key, &redis.Z{Score: float64(now.Add(s.ttl).Unix()), Member: s.instanceID})
+	} else {
+		pipe.ZRem(ctx, key, s.instanceID)
+	}
+	pipe.Expire(ctx, key, s.ttl)
+	count := pipe.ZCard(ctx, key)
+	pipe.HSet(ctx, presenceLastSeenKey, change.customerID, now.UTC().Format(time.RFC3339Nano))
+	if change.online {
+		pipe.SAdd(ctx, presenceInstanceCustomersKey(s.instanceID), change.customerID)
+	} else {
+		pipe.SRem(ctx, presenceInstanceCustomersKey(s.instanceID), change.customerID)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.WarnContext(ctx, "Failed to record presence", "customer.id", change.customerID, "error", err)
+		return
+	}
+
+	// Another replica already holding a connection means the cluster-wide state did not change
+	remaining := count.Val()
+	if (change.online && remaining != 1) || (!change.online && remaining != 0) {
+		return
+	}
+	s.announce(ctx, change.customerID, change.online, now)
+}
+
+// announce logs, counts and publishes a flip of the customer's cluster-wide presence
+func (s *PresenceService) announce(ctx context.Context, customerID string, online bool, now time.Time) {
+	slog.InfoContext(ctx, "👤 Customer is now "+presenceStatus(online), "customer.id", customerID)
+	telemetry.RecordPresenceChange(ctx, online)
+
+	if s.eventsEnabled {
+		eventType := PresenceEventOffline
+		if online {
+			eventType = PresenceEventOnline
+		}
+		err := s.producer.Publish(ctx, customerID, LifecycleEvent{
+			EventType:  eventType,
+			CustomerID: customerID,
+			Channel:    string(models.NotificationTypeWebSocket),
+			Status:     presenceStatus(online),
+			Timestamp:  now.UTC().Format(time.RFC3339Nano),
+		})
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to publish presence event", "customer.id", customerID, "error", err)
+		}
+	}
+}
+
+// heartbeat refreshes this instance's TTL key, reports its connection count and extends
+// its entries for every locally connected customer
+func (s *PresenceService) heartbeat(ctx context.Context) {
+	expiry := time.Now().Add(s.ttl).Unix()
+	pipe := s.redis.client.Pipeline()
+	pipe.Set(ctx, presenceAliveKey(s.instanceID), expiry, s.ttl)
+	pipe.SAdd(ctx, presenceInstancesKey, s.instanceID)
+	pipe.HSet(ctx, presenceConnectionsKey, s.instanceID, fmt.Sprintf("%d:%d", s.hub.GetActiveConnections(), expiry))
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.WarnContext(ctx, "Failed to report WebSocket connections", "error", err)
+	}
+	customers := s.hub.ConnectedCustomers()
+	if len(customers) == 0 {
+		return
+	}
+
+	expiresAt := float64(expiry)
+	pipe = s.redis.client.Pipeline()
+	for _, customerID := range customers {
+		pipe.ZAdd(ctx, presenceKey(customerID), &redis.Z{Score: expiresAt, Member: s.instanceID})
+		pipe.Expire(ctx, presenceKey(customerID), s.ttl)
+		pipe.SAdd(ctx, presenceInstanceCustomersKey(s.instanceID), customerID)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.WarnContext(ctx, "Presence heartbeat failed", "error", err)
+	}
+}
+
+// sweepStopped clears the presence of instances whose TTL key expired
+func (s *PresenceService) sweepStopped(ctx context.Context) {
+	instances, err := s.redis.client.SMembers(ctx, presenceInstancesKey).Result()
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to list presence instances", "error", err)
+		return
+	}
+	alive := make(map[string]*redis.IntCmd, len(instances))
+	pipe := s.redis.client.Pipeline()
+	for _, instanceID := range instances {
+		if instanceID != s.instanceID {
+			alive[instanceID] = pipe.Exists(ctx, presenceAliveKey(instanceID))
+		}
+	}
+	if len(alive) == 0 {
+		return
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.WarnContext(ctx, "Failed to check presence instances", "error", err)
+		return
+	}
+	for instanceID, exists := range alive {
+		if exists.Val() == 0 {
+			s.sweep(ctx, instanceID)
+		}
+	}
+}
+
+// sweep marks offline the customers an instance that stopped was holding, unless they
+// are connected elsewhere. One instance sweeps each stopped one.
+func (s *PresenceService) sweep(ctx context.Context, instanceID string) {
+	claimed, err := s.redis.client.SetNX(ctx, presenceSweepKey(instanceID), s.instanceID, s.ttl).Result()
+	if err != nil || !claimed {
+		return
+	}
+	customers, err := s.redis.client.SMembers(ctx, presenceInstanceCustomersKey(instanceID)).Result()
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to load a stopped instance's customers", "instance.id", instanceID, "error", err)
+		return
+	}
+
+	now := time.Now()
+	for _, customerID := range customers {
+		key := presenceKey(customerID)
+		pipe := s.redis.client.TxPipeline()
+		pipe.ZRem(ctx, key, instanceID)
+		pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.Unix(), 10))
+		count := pipe.ZCard(ctx, key)
+		if _, err := pipe.Exec(ctx); err != nil {
+			slog.WarnContext(ctx, "Failed to clear a stopped instance's presence", "instance.id", instanceID, "customer.id", customerID, "error", err)
+			return
+		}
+		if count.Val() == 0 {
+			if err := s.redis.client.HSet(ctx, presenceLastSeenKey, customerID, now.UTC().Format(time.RFC3339Nano)).Err(); err != nil {
+				slog.WarnContext(ctx, "Failed to record last seen", "customer.id", customerID, "error", err)
+			}
+			s.announce(ctx, customerID, false, now)
+		}
+	}
+
+	pipe := s.redis.client.Pipeline()
+	pipe.Del(ctx, presenceInstanceCustomersKey(instanceID))
+	pipe.HDel(ctx, presenceConnectionsKey, instanceID)
+	if instanceID != s.instanceID {
+		pipe.SRem(ctx, presenceInstancesKey, instanceID)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.WarnContext(ctx, "Failed to drop a stopped instance's presence", "instance.id", instanceID, "error", err)
+		return
+	}
+	if len(customers) > 0 {
+		slog.InfoContext(ctx, "👤 Cleared presence of a stopped instance", "instance.id", instanceID, "customers", len(customers))
+	}
+}
+
+// forward publishes a targeted WebSocket message on the backplane channel of each other
+// instance the customer is connected to. With none, it stays buffered for replay.
+func (s *PresenceService) forward(ctx context.Context, customerID, id string, payload []byte) error {
+	presence, err := s.Presence(ctx, customerID)
+	if err != nil {
+		return err
+	}
+	message, err := json.Marshal(presenceDelivery{CustomerID: customerID, ID: id, Payload: payload})
+	if err != nil {
+		return err
+	}
+	for _, instanceID := range presence.Instances {
+		if instanceID == s.instanceID {
+			continue
+		}
+		if err := s.redis.client.Publish(ctx, presenceDeliveryChannel(instanceID), message).Err(); err != nil {
+			return fmt.Errorf("failed to forward WebSocket message: %w", err)
+		}
+	}
+	return nil
+}
+
+// Presence returns a customer's cluster-wide presence
+func (s *PresenceService) Presence(ctx context.Context, customerID string) (*models.CustomerPresence, error) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	instances, err := s.redis.client.ZRangeByScore(ctx, presenceKey(customerID), &redis.ZRangeBy{Min: "(" + now, Max: "+inf"}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load presence: %w", err)
+	}
+
+	presence := &models.CustomerPresence{
+		CustomerID: customerID,
+		Online:     len(instances) > 0,
+		Instances:  instances,
+	}
+
+	lastSeen, err := s.redis.client.HGet(ctx, presenceLastSeenKey, customerID).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("failed to load presence: %w", err)
+	}
+	if parsed, err := time.Parse(time.RFC3339Nano, lastSeen); err == nil {
+		presence.LastSeen = &parsed
+	}
+	return presence, nil
+}
+
+// ClusterConnections returns the number of WebSocket connections across every replica,
+// as of their last heartbeats. Counts of replicas that stopped heartbeating are dropped.
+func (s *PresenceService) ClusterConnections(ctx context.Context) (int, error) {
+	counts, err := s.redis.client.HGetAll(ctx, presenceConnectionsKey).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to load connection counts: %w", err)
+	}
+	now := time.Now().Unix()
+	total := 0
+	var stale []string
+	for instance, value := range counts {
+		countValue, expiryValue, _ := strings.Cut(value, ":")
+		count, _ := strconv.Atoi(countValue)
+		expiry, _ := strconv.ParseInt(expiryValue, 10, 64)
+		if expiry <= now {
+			stale = append(stale, instance)
+			continue
+		}
+		total += count
+	}
+	if len(stale) > 0 {
+		if err := s.redis.client.HDel(ctx, presenceConnectionsKey, stale...).Err(); err != nil {
+			slog.WarnContext(ctx, "Failed to drop stale connection counts", "error", err)
+		}
+	}
+	return total, nil
+}
+
+// IsOnline reports whether a customer is connected to any replica
+func (s *PresenceService) IsOnline(ctx context.Context, customerID string) (bool, error) {
+	presence, err := s.Presence(ctx, customerID)
+	if err != nil {
+		return false, err
+	}
+	return presence.Online, nil
+}
+
+func presenceStatus(online bool) string {
+	if online {
+		return "online"
+	}
+	return "offline"
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+)
+
+// providerPayloadsPerNotification caps how many exchanges (e.g. retries) are kept per notification
+const providerPayloadsPerNotification = 10
+
+const redacted = "[REDACTED]"
+
+// sensitiveHeaders never leave the process in a captured payload
+var sensitiveHeaders = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"cookie":              true,
+	"set-cookie":          true,
+	"x-api-key":           true,
+	"x-signature":         true,
+	"x-twilio-signature":  true,
+	"aeg-sas-key":         true,
+}
+
+// sensitiveField matches form and JSON field names whose values are redacted
+var sensitiveField = regexp.MustCompile(`(?i)(password|secret|token|auth|api_?key|credential)`)
+
+// providerPayloadsKey holds the captured exchanges for a notification
+func providerPayloadsKey(notificationID string) string {
+	return "provider-payloads:" + notificationID
+}
+
+// ProviderPayloadSampler captures the exact requests sent to channel providers and their
+// responses for a sample of notifications. Secrets are redacted, bodies are capped, and
+// captures expire after the retention period. They are kept in the notification's data
+// region.
+type ProviderPayloadSampler struct {
+	residency *DataResidency
+	rate      float64
+	maxBytes  int
+	retention time.Duration
+}
+
+func NewProviderPayloadSampler(cfg *config.Config, residency *DataResidency) *ProviderPayloadSampler {
+	retention := time.Duration(cfg.ProviderSampleRetentionHours) * time.Hour
+	if retention <= 0 {
+		retention = 72 * time.Hour
+	}
+	return &ProviderPayloadSampler{
+		residency: residency,
+		rate:      cfg.ProviderSampleRate,
+		maxBytes:  cfg.ProviderSampleMaxBytes,
+		retention: retention,
+	}
+}
+
+// Sampled reports whether a notification's provider exchanges are captured. The decision
+// hashes the notification ID, so every retry of a sampled notification is captured too.
+func (s *ProviderPayloadSampler) Sampled(notificationID string) bool {
+	if s == nil || s.rate <= 0 {
+		return false
+	}
+	if s.rate >= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(notificationID))
+	return float64(h.Sum32()%10000) < s.rate*10000
+}
+
+// HTTPPayload redacts and caps an HTTP request or response for capture
+func (s *ProviderPayloadSampler) HTTPPayload(header http.Header, body []byte) models.ProviderPayload {
+	headers := make(map[string]string, len(header))
+	for name, values := range header {
+		value := strings.Join(values, ", ")
+		if sensitiveHeaders[strings.ToLower(name)] {
+			value = redacted
+		}
+		headers[name] = value
+	}
+	payload := s.capBody(redactBody(header.Get("Content-Type"), body, sensitiveField.MatchString))
+	payload.Headers = headers
+	return payload
+}
+
+// Payload caps a non-HTTP payload, such as an SMTP message, for capture
+func (s *ProviderPayloadSampler) Payload(headers map[string]string, body []byte) models.ProviderPayload {
+	payload := s.capBody(body)
+	payload.Headers = headers
+	return payload
+}
+
+// Record stores a captured exchange; failures are logged, never returned, so capture
+// can't affect delivery
+func (s *ProviderPayloadSampler) Record(ctx context.Context, notification *models.Notification, exchange models.ProviderExchange) {
+	if exchange.CapturedAt.IsZero() {
+		exchange.CapturedAt = time.Now().UTC()
+	}
+	notificationID := notification.ID
+	data, err := json.Marshal(exchange)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to encode provider payload", "notification.id", notificationID, "error", err)
+		return
+	}
+	client, err := s.residency.RecordClient(notification)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to store provider payload", "notification.id", notificationID, "error", err)
+		return
+	}
+
+	key := providerPayloadsKey(notificationID)
+	pipe := client.TxPipeline()
+	pipe.RPush(ctx, key, data)
+	pipe.LTrim(ctx, key, -providerPayloadsPerNotification, -1)
+	pipe.Expire(ctx, key, s.retention)
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.WarnContext(ctx, "Failed to store provider payload", "notification.id", notificationID, "error", err)
+	}
+}
+
+// ProviderPayloads returns a notification's captured exchanges, oldest first
+func (s *ProviderPayloadSampler) ProviderPayloads(ctx context.Context, notificationID string) ([]models.ProviderExchange, error) {
+	client, err := s.residency.Records(ctx, notificationID)
+	if err != nil {
+		return nil, err
+	}
+	values, err := client.LRange(ctx, providerPayloadsKey(notificationID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	exchanges := make([]models.ProviderExchange, 0, len(values))
+	for _, value := range values {
+		var exchange models.ProviderExchange
+		if err := json.Unmarshal([]byte(value), &exchange); err != nil {
+			return nil, fmt.Errorf("failed to decode provider payload for %s: %w", notificationID, err)
+		}
+		exchanges = append(exchanges, exchange)
+	}
+	return exchanges, nil
+}
+
+func (s *ProviderPayloadSampler) capBody(body []byte) models.ProviderPayload {
+	if s.maxBytes > 0 && len(body) > s.maxBytes {
+		return models.ProviderPayload{Body: string(body[:s.maxBytes]), Truncated: true, Size: len(body)}
+	}
+	return models.ProviderPayload{Body: string(body), Size: len(body)}
+}
+
+// redactBody blanks the fields named sensitive in form and JSON bodies; other bodies are
+// kept as sent
+func redactBody(contentType string, body []byte, sensitive func(name string) bool) []byte {
+	switch {
+	case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"):
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return body
+		}
+		for name := range form {
+			if sensitive(name) {
+				form[name] = []string{redacted}
+			}
+		}
+		return []byte(form.Encode())
+	case strings.Contains(contentType, "json"):
+		var document interface{}
+		if err := json.Unmarshal(body, &document); err != nil {
+			return body
+		}
+		redacted, err := json.Marshal(redactJSON(document, sensitive))
+		if err != nil {
+			return body
+		}
+		return redacted
+	default:
+		return body
+	}
+}
+
+func redactJSON(value interface{}, sensitive func(name string) bool) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for name, field := range typed {
+			if sensitive(name) {
+				typed[name] = redacted
+				continue
+			}
+			typed[name] = redactJSON(field, sensitive)
+		}
+	case []interface{}:
+		for i, item := range typed {
+			typed[i] = redactJSON(item, sensitive)
+		}
+	}
+	return value
+}
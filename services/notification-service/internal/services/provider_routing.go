@@ -0,0 +1,330 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ProviderMetadata is the notification metadata naming the provider a routed channel
+// sent it through
+const ProviderMetadata = "delivery_provider"
+
+// minProviderWeight keeps a degraded provider whose circuit is still closed getting a
+// trickle of sends, so its averages can recover
+const minProviderWeight = 0.02
+
+// RoutedProvider is one of a channel's providers, named for metrics and status
+type RoutedProvider struct {
+	Name   DeliveryProvider
+	Sender ChannelSender
+}
+
+// routedProvider is a provider with the health of its recent sends
+type routedProvider struct {
+	name   string
+	sender ChannelSender
+
+	success   float64 // moving average of healthy sends, starting at 1
+	latency   float64 // moving average of send seconds, 0 before the first send
+	sends     int64
+	failures  int       // consecutive unhealthy sends
+	openUntil time.Time // when an open circuit half-opens; zero while closed
+	probing   bool      // the one send a half-open circuit lets through is running
+}
+
+// state is the provider's circuit state at now
+func (p *routedProvider) state(now time.Time) string {
+	switch {
+	case p.openUntil.IsZero():
+		return models.CircuitClosed
+	case now.Before(p.openUntil):
+		return models.CircuitOpen
+	default:
+		return models.CircuitHalfOpen
+	}
+}
+
+// ProviderRouter sends a channel's notifications through several providers, picking one
+// for each new send in proportion to its recent health: the square of its success rate,
+// scaled down by how much slower it is than the fastest provider. Both are exponentially
+// weighted moving averages, so a provider that starts failing or slowing down loses
+// traffic within a few sends, well before enough consecutive failures open its circuit.
+// An open circuit gets no sends until PROVIDER_CIRCUIT_OPEN_SECONDS pass; it then
+// half-opens for a single probe send, which closes it again or reopens it. Rejections
+// of the message itself don't count against a provider. Health is kept per replica.
+type ProviderRouter struct {
+	channel  models.NotificationType
+	alpha    float64
+	failures int
+	openFor  time.Duration
+
+	mu        sync.Mutex
+	providers []*routedProvider
+}
+
+func NewProviderRouter(cfg *config.Config, channel models.NotificationType, providers ...RoutedProvider) *ProviderRouter {
+	alpha := cfg.ProviderRoutingAlpha
+	if alpha <= 0 || alpha > 1 {
+		alpha = 0.2
+	}
+	router := &ProviderRouter{
+		channel:  channel,
+		alpha:    alpha,
+		failures: cfg.ProviderCircuitFailures,
+		openFor:  time.Duration(cfg.ProviderCircuitOpenSeconds) * time.Second,
+	}
+	for _, provider := range providers {
+		router.providers = append(router.providers, &routedProvider{name: string(provider.Name), sender: provider.Sender, success: 1})
+	}
+	return router
+}
+
+// Send delivers the notification through the provider picked for it. A failed send is
+// not tried on another provider, as the first may have delivered it anyway; retries go
+// through the retry scheduler and are routed afresh.
+func (r *ProviderRouter) Send(ctx context.Context, notification *models.Notification) error {
+	provider := r.pick(ctx)
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("provider.name", provider.name))
+	if notification.Metadata == nil {
+		notification.Metadata = make(map[string]interface{})
+	}
+	notification.Metadata[ProviderMetadata] = provider.name
+
+	start := time.Now()
+	err := provider.sender.Send(ctx, notification)
+	r.record(ctx, provider, err, time.Since(start))
+	return err
+}
+
+// pick chooses the provider of the next send at random by weight. When every circuit
+// is open the send goes to the provider due to half-open first rather than failing.
+func (r *ProviderRouter) pick(ctx context.Context) *routedProvider {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	weights := r.weights(now)
+	total := 0.0
+	for _, weight := range weights {
+		total += weight
+	}
+	if total == 0 {
+		next := r.providers[0]
+		for _, provider := range r.providers[1:] {
+			if provider.openUntil.Before(next.openUntil) {
+				next = provider
+			}
+		}
+		return next
+	}
+
+	var chosen *routedProvider
+	target := rand.Float64() * total
+	for i, weight := range weights {
+		if weight == 0 {
+			continue
+		}
+		chosen = r.providers[i]
+		if target < weight {
+			break
+		}
+		target -= weight
+	}
+	if chosen.state(now) == models.CircuitHalfOpen {
+		chosen.probing = true
+		telemetry.RecordCircuitTransition(ctx, string(r.channel), chosen.name, models.CircuitHalfOpen)
+	}
+	return chosen
+}
+
+// weights returns each provider's routing weight at now, 0 for an open circuit or a
+// half-open one already probing. Callers hold r.mu.
+func (r *ProviderRouter) weights(now time.Time) []float64 {
+	fastest := 0.0
+	for _, provider := range r.providers {
+		if provider.latency > 0 && (fastest == 0 || provider.latency < fastest) {
+			fastest = provider.latency
+		}
+	}
+
+	weights := make([]float64, len(r.providers))
+	for i, provider := range r.providers {
+		switch provider.state(now) {
+		case models.CircuitOpen:
+			continue
+		case models.CircuitHalfOpen:
+			if provider.probing {
+				continue
+			}
+		}
+		weight := provider.success * provider.success
+		if fastest > 0 && provider.latency > 0 {
+			weight *= fastest / provider.latency
+		}
+		weights[i] = max(weight, minProviderWeight)
+	}
+	return weights
+}
+
+// record folds a send into its provider's averages and moves its circuit
+func (r *ProviderRouter) record(ctx context.Context, provider *routedProvider, err error, elapsed time.Duration) {
+	healthy := err == nil || ClassifyError(err) == models.ErrorClassRejected
+
+	r.mu.Lock()
+	now := time.Now()
+	sample := 0.0
+	if healthy {
+		sample = 1
+	}
+	provider.success += r.alpha * (sample - provider.success)
+	if provider.latency == 0 {
+		provider.latency = elapsed.Seconds()
+	} else {
+		provider.latency += r.alpha * (elapsed.Seconds() - provider.latency)
+	}
+	provider.sends++
+	probe := provider.probing
+	provider.probing = false
+
+	transition := ""
+	state := provider.state(now)
+	if healthy {
+		provider.failures = 0
+		if state == models.CircuitHalfOpen {
+			provider.openUntil = time.Time{}
+			transition = models.CircuitClosed
+		}
+	} else {
+		provider.failures++
+		if r.failures > 0 && state != models.CircuitOpen && (probe || provider.failures >= r.failures) {
+			provider.openUntil = now.Add(r.openFor)
+			transition = models.CircuitOpen
+		}
+	}
+	r.mu.Unlock()
+
+	telemetry.RecordProviderSend(ctx, string(r.channel), provider.name, healthy)
+	switch transition {
+	case models.CircuitOpen:
+		slog.WarnContext(ctx, "Provider circuit opened", "notification.channel", r.channel, "provider.name", provider.name,
+			"open_seconds", r.openFor.Seconds(), "error", err)
+	case models.CircuitClosed:
+		slog.InfoContext(ctx, "Provider circuit closed", "notification.channel", r.channel, "provider.name", provider.name)
+	default:
+		return
+	}
+	telemetry.RecordCircuitTransition(ctx, string(r.channel), provider.name, transition)
+}
+
+// Status returns each provider's health and current share of new sends
+func (r *ProviderRouter) Status() []models.ProviderRoute {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	weights := r.weights(now)
+	total := 0.0
+	for _, weight := range weights {
+		total += weight
+	}
+	routes := make([]models.ProviderRoute, len(r.providers))
+	for i, provider := range r.providers {
+		routes[i] = models.ProviderRoute{
+			Channel:             r.channel,
+			Provider:            provider.name,
+			SuccessRate:         provider.success,
+			LatencyMs:           provider.latency * 1000,
+			Sends:               provider.sends,
+			ConsecutiveFailures: provider.failures,
+			Circuit:             provider.state(now),
+		}
+		if total > 0 {
+			routes[i].Weight = weights[i] / total
+		}
+		if routes[i].Circuit == models.CircuitOpen {
+			openUntil := provider.openUntil.UTC()
+			routes[i].OpenUntil = &openUntil
+		}
+	}
+	return routes
+}
+
+// ProviderRouting keeps the routers of the channels with more than one provider
+type ProviderRouting struct {
+	cfg     *config.Config
+	routers []*ProviderRouter
+}
+
+func NewProviderRouting(cfg *config.Config) *ProviderRouting {
+	return &ProviderRouting{cfg: cfg}
+}
+
+// Route returns the sender of a channel: its only provider as is, or a ProviderRouter
+// across several
+func (p *ProviderRouting) Route(channel models.NotificationType, providers ...RoutedProvider) ChannelSender {
+	if len(providers) == 1 {
+		return providers[0].Sender
+	}
+	router := NewProviderRouter(p.cfg, channel, providers...)
+	p.routers = append(p.routers, router)
+	return router
+}
+
+// Status returns the providers of every routed channel
+func (p *ProviderRouting) Status() []models.ProviderRoute {
+	routes := []models.ProviderRoute{}
+	for _, router := range p.routers {
+		routes = append(routes, router.Status()...)
+	}
+	return routes
+}
+
+// Register starts reporting the routing weight gauge; unregister the result on shutdown
+func (p *ProviderRouting) Register() (metric.Registration, error) {
+	return telemetry.RegisterProviderWeightCallback(func() []telemetry.ProviderWeight {
+		routes := p.Status()
+		weights := make([]telemetry.ProviderWeight, len(routes))
+		for i, route := range routes {
+			weights[i] = telemetry.ProviderWeight{Channel: string(route.Channel), Provider: route.Provider, Weight: route.Weight}
+		}
+		return weights
+	})
+}
+
+// SecondarySMTPConfig returns cfg with the SMTP_SECONDARY_* relay in place of the
+// primary one, for a second EmailService; nil when no secondary relay is set
+func SecondarySMTPConfig(cfg *config.Config) *config.Config {
+	if cfg.SMTPSecondaryHost == "" {
+		return nil
+	}
+	secondary := *cfg
+	secondary.SMTPHost = cfg.SMTPSecondaryHost
+	secondary.SMTPPort = cfg.SMTPSecondaryPort
+	secondary.SMTPUsername = cfg.SMTPSecondaryUsername
+	secondary.SMTPPassword = cfg.SMTPSecondaryPassword
+	return &secondary
+}
+
+// SecondaryTwilioConfig returns cfg with the TWILIO_SECONDARY_* account in place of
+// the primary one, for a second SMSService; nil unless all of it is set
+func SecondaryTwilioConfig(cfg *config.Config) *config.Config {
+	if cfg.TwilioSecondaryAccountSID == "" || cfg.TwilioSecondaryAuthToken == "" || cfg.TwilioSecondaryPhoneNumber == "" {
+		return nil
+	}
+	secondary := *cfg
+	secondary.TwilioAccountSID = cfg.TwilioSecondaryAccountSID
+	secondary.TwilioAuthToken = cfg.TwilioSecondaryAuthToken
+	secondary.TwilioPhoneNumber = cfg.TwilioSecondaryPhoneNumber
+	return &secondary
+}
@@ -0,0 +1,384 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+// Providers that can be given their own proxy in PROVIDER_PROXIES
+const (
+	ProviderSMTP             = "smtp"
+	ProviderTwilio           = "twilio"
+	ProviderWebhook          = "webhook"
+	ProviderTeams            = "teams"
+	ProviderContentScreening = "content_screening"
+	ProviderContentSafety    = "content_safety"
+	ProviderLanguage         = "language"
+	ProviderTranslator       = "translator"
+	ProviderOIDC             = "oidc"
+	ProviderTemplateEvents   = "template_events"
+)
+
+// proxyDirect in PROVIDER_PROXIES sends a provider's connections straight out,
+// ignoring HTTPS_PROXY
+const proxyDirect = "direct"
+
+// ProxyError is a provider connection that failed at the egress proxy rather than at
+// the provider: the proxy was unreachable, refused the tunnel or rejected credentials
+type ProxyError struct {
+	Provider string
+	Proxy    string
+	Err      error
+}
+
+func (e *ProxyError) Error() string {
+	return fmt.Sprintf("%s proxy %s: %v", e.Provider, e.Proxy, e.Err)
+}
+
+func (e *ProxyError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ProxyError) ErrorClass() models.ErrorClass {
+	return models.ErrorClassProxy
+}
+
+// providerProxy picks the proxy for one provider's connections: its PROVIDER_PROXIES
+// entry when it has one, otherwise HTTPS_PROXY / HTTP_PROXY subject to NO_PROXY
+type providerProxy struct {
+	provider string
+	fixed    *url.URL
+	direct   bool
+	env      func(*url.URL) (*url.URL, error)
+}
+
+func newProviderProxy(cfg *config.Config, provider string) *providerProxy {
+	p := &providerProxy{provider: provider, env: httpproxy.FromEnvironment().ProxyFunc()}
+	for _, entry := range strings.Split(cfg.ProviderProxies, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || strings.TrimSpace(name) != provider {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if value == proxyDirect {
+			p.direct = true
+			continue
+		}
+		proxyURL, err := url.Parse(value)
+		if err != nil || proxyURL.Host == "" {
+			slog.Warn("Ignoring PROVIDER_PROXIES entry: invalid proxy URL", "provider", provider)
+			continue
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+			p.fixed = proxyURL
+		default:
+			slog.Warn("Ignoring PROVIDER_PROXIES entry: unsupported scheme", "provider", provider, "scheme", proxyURL.Scheme)
+		}
+	}
+	return p
+}
+
+// proxyFor returns the proxy for a connection to target, or nil to connect directly
+func (p *providerProxy) proxyFor(target *url.URL) (*url.URL, error) {
+	switch {
+	case p.direct:
+		return nil, nil
+	case p.fixed != nil:
+		return p.fixed, nil
+	default:
+		return p.env(target)
+	}
+}
+
+// proxyName identifies a proxy in errors and logs without its credentials
+func proxyName(proxyURL *url.URL) string {
+	return proxyURL.Scheme + "://" + proxyURL.Host
+}
+
+// NewHTTPClient returns the client a provider's HTTP calls go through: traced, with the
+// provider's egress proxy, and with failures at the proxy reported as a ProxyError. A
+// zero timeout leaves the deadline to the request context.
+func NewHTTPClient(cfg *config.Config, provider string, timeout time.Duration) *http.Client {
+	return newHTTPClient(cfg, provider, timeout, false)
+}
+
+// NewPublicHTTPClient is NewHTTPClient for calls to URLs customers supply. Direct
+// connections to loopback, private, link-local and other non-public addresses are
+// refused once the host is resolved, so a hostname can't be pointed at the internal
+// network. The egress proxy is dialled wherever it is.
+func NewPublicHTTPClient(cfg *config.Config, provider string, timeout time.Duration) *http.Client {
+	return newHTTPClient(cfg, provider, timeout, true)
+}
+
+func newHTTPClient(cfg *config.Config, provider string, timeout time.Duration, publicOnly bool) *http.Client {
+	p := newProviderProxy(cfg, provider)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if publicOnly {
+		transport.DialContext = p.publicDialer().DialContext
+	}
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		return p.proxyFor(req.URL)
+	}
+	transport.OnProxyConnectResponse = func(_ context.Context, proxyURL *url.URL, _ *http.Request, res *http.Response) error {
+		if res.StatusCode != http.StatusOK {
+			return &ProxyError{Provider: provider, Proxy: proxyName(proxyURL), Err: fmt.Errorf("CONNECT refused: %s", res.Status)}
+		}
+		return nil
+	}
+	return &http.Client{
+		Transport: otelhttp.NewTransport(&proxyTransport{base: transport, proxy: p}),
+		Timeout:   timeout,
+	}
+}
+
+// ErrNonPublicAddress is a connection to a customer-supplied URL refused because its
+// host resolved to an address inside the network
+var ErrNonPublicAddress = errors.New("address is not publicly routable")
+
+// nonPublicAddressError is a refused connection; it is never retried
+type nonPublicAddressError struct {
+	address string
+}
+
+func (e *nonPublicAddressError) Error() string {
+	return fmt.Sprintf("%s: %v", e.address, ErrNonPublicAddress)
+}
+
+func (e *nonPublicAddressError) Is(target error) bool {
+	return target == ErrNonPublicAddress
+}
+
+func (e *nonPublicAddressError) ErrorClass() models.ErrorClass {
+	return models.ErrorClassRejected
+}
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), private in practice
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// IsPublicAddress reports whether addr is a globally routable unicast address: not
+// loopback, private, link-local (which includes cloud metadata endpoints), shared or
+// unspecified
+func IsPublicAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsGlobalUnicast() && !addr.IsPrivate() && !sharedAddressSpace.Contains(addr)
+}
+
+// publicDialer dials only public addresses, checked after resolution so DNS can't
+// rebind a checked name, except for the provider's proxies
+func (p *providerProxy) publicDialer() *publicDialer {
+	proxies := make(map[string]bool)
+	addProxy := func(raw string) {
+		if proxyURL, err := url.Parse(raw); err == nil && proxyURL.Host != "" {
+			proxies[proxyAddress(proxyURL)] = true
+		}
+	}
+	if p.fixed != nil {
+		proxies[proxyAddress(p.fixed)] = true
+	}
+	env := httpproxy.FromEnvironment()
+	addProxy(env.HTTPProxy)
+	addProxy(env.HTTPSProxy)
+	return &publicDialer{proxies: proxies}
+}
+
+type publicDialer struct {
+	proxies map[string]bool
+}
+
+func (d *publicDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if !d.proxies[address] {
+		dialer.Control = func(_, resolved string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(resolved)
+			if err != nil || !IsPublicAddress(addrPort.Addr()) {
+				return &nonPublicAddressError{address: resolved}
+			}
+			return nil
+		}
+	}
+	return dialer.DialContext(ctx, network, address)
+}
+
+// proxyAddress is the host:port a proxy URL is dialled at
+func proxyAddress(proxyURL *url.URL) string {
+	if proxyURL.Port() != "" {
+		return proxyURL.Host
+	}
+	port := "80"
+	switch proxyURL.Scheme {
+	case "https":
+		port = "443"
+	case "socks5", "socks5h":
+		port = "1080"
+	}
+	return net.JoinHostPort(proxyURL.Hostname(), port)
+}
+
+// proxyTransport turns the transport's proxy dial and handshake errors into ProxyErrors
+type proxyTransport struct {
+	base  *http.Transport
+	proxy *providerProxy
+}
+
+func (t *proxyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.base.RoundTrip(req)
+	if err == nil {
+		return res, nil
+	}
+	proxyURL, _ := t.proxy.proxyFor(req.URL)
+	if proxyURL == nil || !isProxyFailure(err) {
+		return nil, err
+	}
+
+	telemetry.RecordProxyFailure(req.Context(), t.proxy.provider, proxyURL.Scheme)
+	var proxyErr *ProxyError
+	if errors.As(err, &proxyErr) {
+		return nil, err
+	}
+	return nil, &ProxyError{Provider: t.proxy.provider, Proxy: proxyName(proxyURL), Err: err}
+}
+
+// isProxyFailure recognises the transport's errors for an unreachable proxy and a
+// failed SOCKS handshake, besides a refused CONNECT
+func isProxyFailure(err error) bool {
+	var proxyErr *ProxyError
+	if errors.As(err, &proxyErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && (opErr.Op == "proxyconnect" || strings.HasPrefix(opErr.Op, "socks "))
+}
+
+// ProviderDialer opens a provider's raw TCP connections, such as SMTP sessions, through
+// its egress proxy: a SOCKS5 proxy, or an HTTP(S) proxy tunnelling with CONNECT
+type ProviderDialer struct {
+	proxy   *providerProxy
+	timeout time.Duration
+}
+
+func NewProviderDialer(cfg *config.Config, provider string, timeout time.Duration) *ProviderDialer {
+	return &ProviderDialer{proxy: newProviderProxy(cfg, provider), timeout: timeout}
+}
+
+// DialContext connects to address, directly or through the provider's proxy. HTTPS_PROXY
+// and NO_PROXY are matched against the address as if it were an https URL.
+func (d *ProviderDialer) DialContext(ctx context.Context, address string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: d.timeout}
+	proxyURL, err := d.proxy.proxyFor(&url.URL{Scheme: "https", Host: address})
+	if err != nil || proxyURL == nil {
+		return dialer.DialContext(ctx, "tcp", address)
+	}
+
+	var conn net.Conn
+	if strings.HasPrefix(proxyURL.Scheme, "socks5") {
+		conn, err = d.dialSOCKS5(ctx, dialer, proxyURL, address)
+	} else {
+		conn, err = d.dialConnect(ctx, dialer, proxyURL, address)
+	}
+	if err != nil {
+		telemetry.RecordProxyFailure(ctx, d.proxy.provider, proxyURL.Scheme)
+		return nil, &ProxyError{Provider: d.proxy.provider, Proxy: proxyName(proxyURL), Err: err}
+	}
+	return conn, nil
+}
+
+func (d *ProviderDialer) dialSOCKS5(ctx context.Context, dialer *net.Dialer, proxyURL *url.URL, address string) (net.Conn, error) {
+	var auth *proxy.Auth
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		auth = &proxy.Auth{User: proxyURL.User.Username(), Password: password}
+	}
+	socks, err := proxy.SOCKS5("tcp", proxyURL.Host, auth, dialer)
+	if err != nil {
+		return nil, err
+	}
+	return socks.(proxy.ContextDialer).DialContext(ctx, "tcp", address)
+}
+
+// dialConnect opens a tunnel to address with an HTTP CONNECT request
+func (d *ProviderDialer) dialConnect(ctx context.Context, dialer *net.Dialer, proxyURL *url.URL, address string) (net.Conn, error) {
+	proxyAddress := proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "80"
+		if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddress = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddress)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname(), MinVersion: tls.VersionTLS12})
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok && d.timeout > 0 {
+		deadline = time.Now().Add(d.timeout)
+	}
+	conn.SetDeadline(deadline)
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	res, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("CONNECT refused: %s", res.Status)
+	}
+
+	conn.SetDeadline(time.Time{})
+	// The provider may speak first, as SMTP servers do, so keep what was read past the response
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+// bufferedConn reads through the buffer that consumed the CONNECT response
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,287 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"notification-service/internal/models"
+)
+
+// ErrInvalidPushContent means a push notification's content breaks a platform limit
+var ErrInvalidPushContent = errors.New("invalid push content")
+
+// Platform limits push content is checked against when a notification is created
+const (
+	// fcmMaxPayloadBytes and apnsMaxPayloadBytes cap the encoded message each platform accepts
+	fcmMaxPayloadBytes  = 4096
+	apnsMaxPayloadBytes = 4096
+	// pushMaxActions is how many buttons Android shows on a notification
+	pushMaxActions = 3
+)
+
+// fcmReservedDataKeys can't be used as FCM data keys; keys starting google. or gcm.
+// are reserved too
+var fcmReservedDataKeys = map[string]bool{
+	"from":         true,
+	"notification": true,
+	"message_type": true,
+}
+
+// Data keys the service adds to both platforms' payloads
+const (
+	pushNotificationIDKey = "notification_id"
+	pushDeepLinkKey       = "deep_link"
+	pushImageURLKey       = "image_url"
+	pushActionsKey        = "actions"
+)
+
+// FCMMessage is the message of an FCM HTTP v1 send request
+type FCMMessage struct {
+	Token        string            `json:"token"`
+	Notification *FCMNotification  `json:"notification,omitempty"`
+	Data         map[string]string `json:"data,omitempty"`
+	Android      *FCMAndroidConfig `json:"android,omitempty"`
+}
+
+type FCMNotification struct {
+	Title string `json:"title,omitempty"`
+	Body  string `json:"body,omitempty"`
+	Image string `json:"image,omitempty"`
+}
+
+type FCMAndroidConfig struct {
+	CollapseKey  string                  `json:"collapse_key,omitempty"`
+	Priority     string                  `json:"priority,omitempty"`
+	Notification *FCMAndroidNotification `json:"notification,omitempty"`
+}
+
+type FCMAndroidNotification struct {
+	ClickAction string `json:"click_action,omitempty"`
+	Sound       string `json:"sound,omitempty"`
+}
+
+// APNsAps is the aps dictionary of an APNs payload. A silent push has only
+// content-available.
+type APNsAps struct {
+	Alert            *APNsAlert `json:"alert,omitempty"`
+	Category         string     `json:"category,omitempty"`
+	Badge            *int       `json:"badge,omitempty"`
+	Sound            string     `json:"sound,omitempty"`
+	MutableContent   int        `json:"mutable-content,omitempty"`
+	ContentAvailable int        `json:"content-available,omitempty"`
+}
+
+type APNsAlert struct {
+	Title string `json:"title,omitempty"`
+	Body  string `json:"body"`
+}
+
+// pushContent returns a notification's push content with title and body defaulted to
+// its subject and message, unless it is silent
+func pushContent(notification *models.Notification) models.PushContent {
+	var content models.PushContent
+	if notification.Push != nil {
+		content = *notification.Push
+	}
+	if content.Silent {
+		return content
+	}
+	if content.Title == "" {
+		content.Title = notification.Subject
+	}
+	if content.Body == "" {
+		content.Body = notification.Message
+	}
+	return content
+}
+
+// pushData is the custom data both platforms deliver to the app: the caller's data
+// plus the notification ID, deep link, image and actions
+func pushData(notification *models.Notification, content models.PushContent) (map[string]string, error) {
+	data := make(map[string]string, len(content.Data)+4)
+	for key, value := range content.Data {
+		data[key] = value
+	}
+	data[pushNotificationIDKey] = notification.ID
+	if content.DeepLink != "" {
+		data[pushDeepLinkKey] = content.DeepLink
+	}
+	if content.ImageURL != "" {
+		data[pushImageURLKey] = content.ImageURL
+	}
+	if len(content.Actions) > 0 {
+		actions, err := json.Marshal(content.Actions)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal push actions: %w", err)
+		}
+		data[pushActionsKey] = string(actions)
+	}
+	return data, nil
+}
+
+// IsSilentPush reports whether a notification is a silent, data-only push
+func IsSilentPush(notification *models.Notification) bool {
+	return notification.Push != nil && notification.Push.Silent
+}
+
+// BuildFCMMessage maps a push notification to an FCM message: title, body and image as
+// the notification, the category as the Android click action, deep link and actions as
+// data, and the collapse key as the Android collapse key. A silent push is a data
+// message at normal priority, which the app handles in the background.
+func BuildFCMMessage(notification *models.Notification) (*FCMMessage, error) {
+	content := pushContent(notification)
+	data, err := pushData(notification, content)
+	if err != nil {
+		return nil, err
+	}
+	if content.Silent {
+		return &FCMMessage{
+			Token:   notification.Recipient,
+			Data:    data,
+			Android: &FCMAndroidConfig{CollapseKey: notification.CollapseKey, Priority: "normal"},
+		}, nil
+	}
+	message := &FCMMessage{
+		Token: notification.Recipient,
+		Notification: &FCMNotification{
+			Title: content.Title,
+			Body:  content.Body,
+			Image: content.ImageURL,
+		},
+		Data: data,
+	}
+	if content.Category != "" || content.Sound != "" || notification.CollapseKey != "" {
+		message.Android = &FCMAndroidConfig{CollapseKey: notification.CollapseKey}
+	}
+	if content.Category != "" || content.Sound != "" {
+		message.Android.Notification = &FCMAndroidNotification{
+			ClickAction: content.Category,
+			Sound:       content.Sound,
+		}
+	}
+	return message, nil
+}
+
+// BuildAPNsHeaders returns the APNs request headers of a push notification; a collapse
+// key becomes apns-collapse-id, so the device shows only the newest notification. APNs
+// only takes a silent push as the background type at priority 5.
+func BuildAPNsHeaders(notification *models.Notification) map[string]string {
+	headers := map[string]string{"apns-push-type": "alert"}
+	if IsSilentPush(notification) {
+		headers["apns-push-type"] = "background"
+		headers["apns-priority"] = "5"
+	}
+	if notification.CollapseKey != "" {
+		headers["apns-collapse-id"] = notification.CollapseKey
+	}
+	return headers
+}
+
+// BuildAPNsPayload maps a push notification to an APNs payload: an alert with the
+// category that selects the app's action buttons, and the data as custom keys. An image
+// sets mutable-content so the app's notification service extension can attach it. A
+// silent push sets content-available instead of an alert.
+func BuildAPNsPayload(notification *models.Notification) (map[string]interface{}, error) {
+	content := pushContent(notification)
+	data, err := pushData(notification, content)
+	if err != nil {
+		return nil, err
+	}
+	aps := APNsAps{
+		Category: content.Category,
+		Badge:    content.Badge,
+		Sound:    content.Sound,
+	}
+	if content.Silent {
+		aps.ContentAvailable = 1
+	} else {
+		aps.Alert = &APNsAlert{Title: content.Title, Body: content.Body}
+	}
+	if content.ImageURL != "" {
+		aps.MutableContent = 1
+	}
+
+	payload := make(map[string]interface{}, len(data)+1)
+	for key, value := range data {
+		payload[key] = value
+	}
+	payload["aps"] = aps
+	return payload, nil
+}
+
+// ValidatePush checks a notification's push content against the platform limits: an
+// https image, at most pushMaxActions uniquely named actions with a category for iOS,
+// no FCM reserved data keys, and a payload that fits both FCM and APNs. Call it after
+// rendering, since title and body default to the rendered subject and message. A silent
+// push may carry nothing the device would show.
+func ValidatePush(notification *models.Notification) error {
+	if notification.Type != models.NotificationTypePush && notification.Push == nil {
+		return nil
+	}
+	content := pushContent(notification)
+	if content.Silent {
+		if notification.Type != models.NotificationTypePush {
+			return fmt.Errorf("%w: silent is only allowed on push notifications", ErrInvalidPushContent)
+		}
+		if content.Title != "" || content.Body != "" || content.ImageURL != "" || len(content.Actions) > 0 ||
+			content.Category != "" || content.Sound != "" || content.Badge != nil {
+			return fmt.Errorf("%w: a silent push carries only data and deep_link", ErrInvalidPushContent)
+		}
+	} else if content.Body == "" {
+		return fmt.Errorf("%w: body or message is required", ErrInvalidPushContent)
+	}
+	if content.ImageURL != "" {
+		if u, err := url.Parse(content.ImageURL); err != nil || u.Scheme != "https" {
+			return fmt.Errorf("%w: image_url must be an https URL", ErrInvalidPushContent)
+		}
+	}
+	if len(content.Actions) > pushMaxActions {
+		return fmt.Errorf("%w: at most %d actions are allowed", ErrInvalidPushContent, pushMaxActions)
+	}
+	if len(content.Actions) > 0 && content.Category == "" {
+		return fmt.Errorf("%w: actions need a category for iOS", ErrInvalidPushContent)
+	}
+	seen := make(map[string]bool, len(content.Actions))
+	for _, action := range content.Actions {
+		if seen[action.ID] {
+			return fmt.Errorf("%w: action %q is listed twice", ErrInvalidPushContent, action.ID)
+		}
+		seen[action.ID] = true
+	}
+	for key := range content.Data {
+		lower := strings.ToLower(key)
+		if fcmReservedDataKeys[lower] || strings.HasPrefix(lower, "google.") || strings.HasPrefix(lower, "gcm.") {
+			return fmt.Errorf("%w: data key %q is reserved by FCM", ErrInvalidPushContent, key)
+		}
+		if key == "aps" || key == pushNotificationIDKey || key == pushDeepLinkKey || key == pushImageURLKey || key == pushActionsKey {
+			return fmt.Errorf("%w: data key %q is set by the service", ErrInvalidPushContent, key)
+		}
+	}
+
+	fcm, err := BuildFCMMessage(notification)
+	if err != nil {
+		return err
+	}
+	if err := checkPayloadSize("FCM", fcm, fcmMaxPayloadBytes); err != nil {
+		return err
+	}
+	apns, err := BuildAPNsPayload(notification)
+	if err != nil {
+		return err
+	}
+	return checkPayloadSize("APNs", apns, apnsMaxPayloadBytes)
+}
+
+func checkPayloadSize(platform string, payload interface{}, limit int) error {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s payload: %w", platform, err)
+	}
+	if len(encoded) > limit {
+		return fmt.Errorf("%w: %s payload is %d bytes, over the %d byte limit", ErrInvalidPushContent, platform, len(encoded), limit)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"notification-service/internal/cache"
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/storage"
+	"notification-service/internal/telemetry"
+
+	"go.opentelemetry.io/otel/metric"
+)
+
+// queueDepthTimeout bounds a queue count, so a slow database doesn't hold up the export
+// of every other metric
+const queueDepthTimeout = 2 * time.Second
+
+// QueueMonitor reports how many notifications wait to be sent through the
+// notification.queue.size, .scheduled and .retrying gauges. Counts come from the
+// notification database, by priority; without one, only the retries scheduled in Redis
+// are reported. They are cached for QUEUE_METRICS_CACHE_SECONDS, since every exporter
+// and every Prometheus scrape collects the gauges.
+type QueueMonitor struct {
+	redis  *RedisClient
+	repo   storage.NotificationRepository
+	depths *cache.Cache[[]telemetry.QueueSize]
+}
+
+// NewQueueMonitor creates the monitor; repo is nil when the service runs on Redis alone
+func NewQueueMonitor(cfg *config.Config, redis *RedisClient, repo storage.NotificationRepository) *QueueMonitor {
+	return &QueueMonitor{
+		redis: redis,
+		repo:  repo,
+		depths: cache.New[[]telemetry.QueueSize](nil, cache.Options{
+			Name:       "queue-depths",
+			Mode:       cache.ReadThrough,
+			L1TTL:      time.Duration(max(cfg.QueueMetricsCacheSeconds, 1)) * time.Second,
+			L1MaxItems: 1,
+		}),
+	}
+}
+
+// Register starts reporting the queue gauges; unregister the result on shutdown
+func (m *QueueMonitor) Register() (metric.Registration, error) {
+	return telemetry.RegisterQueueSizeCallback(m.QueueSizes)
+}
+
+// QueueSizes returns the current queue sizes
+func (m *QueueMonitor) QueueSizes(ctx context.Context) ([]telemetry.QueueSize, error) {
+	return m.depths.Get(ctx, "all", func(ctx context.Context) ([]telemetry.QueueSize, error) {
+		ctx, cancel := context.WithTimeout(ctx, queueDepthTimeout)
+		defer cancel()
+		if m.repo == nil {
+			return m.scheduledRetries(ctx)
+		}
+		return m.queueDepths(ctx)
+	})
+}
+
+// queueDepths counts queued notifications in the database. Every queue and priority is
+// reported, with zero when empty, so a drained queue shows as zero rather than a gap.
+func (m *QueueMonitor) queueDepths(ctx context.Context) ([]telemetry.QueueSize, error) {
+	depths, err := m.repo.QueueDepths(ctx)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[[2]string]int64, len(depths))
+	for _, depth := range depths {
+		counts[[2]string{depth.Queue, string(depth.Priority)}] += depth.Count
+	}
+
+	var sizes []telemetry.QueueSize
+	for _, queue := range []string{telemetry.QueuePending, telemetry.QueueScheduled, telemetry.QueueRetrying} {
+		for _, priority := range []models.Priority{models.PriorityLow, models.PriorityNormal, models.PriorityHigh, models.PriorityUrgent} {
+			key := [2]string{queue, string(priority)}
+			sizes = append(sizes, telemetry.QueueSize{Queue: queue, Priority: string(priority), Count: counts[key]})
+			delete(counts, key)
+		}
+	}
+	// Priorities outside the known ones are still counted
+	for key, count := range counts {
+		sizes = append(sizes, telemetry.QueueSize{Queue: key[0], Priority: key[1], Count: count})
+	}
+	return sizes, nil
+}
+
+// scheduledRetries counts the retries waiting in Redis, which carry no priority
+func (m *QueueMonitor) scheduledRetries(ctx context.Context) ([]telemetry.QueueSize, error) {
+	count, err := m.redis.client.ZCard(ctx, retryScheduleKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to count scheduled retries: %w", err)
+	}
+	return []telemetry.QueueSize{{Queue: telemetry.QueueRetrying, Count: count}}, nil
+}
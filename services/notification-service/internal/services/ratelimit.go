@@ -0,0 +1,166 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// defaultRateLimitGroup covers the routes no other RATE_LIMITS entry matches
+const defaultRateLimitGroup = "default"
+
+// rateLimit is one route group's token bucket: perMinute tokens are added a minute, up
+// to burst
+type rateLimit struct {
+	group     string
+	perMinute int
+	burst     int
+}
+
+// tokenBucketScript takes a token from every bucket in KEYS, or from none when any is
+// empty. Buckets refill on Redis's clock, so every replica sees the same allowance. It
+// returns whether the request is allowed, the index of the emptiest bucket, the tokens
+// left in it and the seconds until it has a token again.
+var tokenBucketScript = redis.NewScript(`
+redis.replicate_commands()
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local clock = redis.call('TIME')
+local now = tonumber(clock[1]) + tonumber(clock[2]) / 1000000
+
+local tokens = {}
+local lowest = 1
+for i, key in ipairs(KEYS) do
+	local state = redis.call('HMGET', key, 'tokens', 'at')
+	local level = tonumber(state[1]) or burst
+	local at = tonumber(state[2]) or now
+	tokens[i] = math.min(burst, level + math.max(0, now - at) * rate)
+	if tokens[i] < tokens[lowest] then
+		lowest = i
+	end
+end
+
+local allowed = tokens[lowest] >= 1
+local ttl = math.ceil(burst / rate) + 1
+for i, key in ipairs(KEYS) do
+	if allowed then
+		tokens[i] = tokens[i] - 1
+	end
+	redis.call('HSET', key, 'tokens', tostring(tokens[i]), 'at', tostring(now))
+	redis.call('EXPIRE', key, ttl)
+end
+
+local wait = 0
+if not allowed then
+	wait = (1 - tokens[lowest]) / rate
+end
+return {allowed and 1 or 0, lowest - 1, tostring(tokens[lowest]), tostring(wait)}
+`)
+
+// RateLimiter enforces RATE_LIMITS with a token bucket per route group and subject kept
+// in Redis, so the limits hold across replicas
+type RateLimiter struct {
+	redis    *RedisClient
+	limits   []rateLimit
+	fallback *rateLimit
+}
+
+func NewRateLimiter(cfg *config.Config, redis *RedisClient) *RateLimiter {
+	l := &RateLimiter{redis: redis}
+	for _, entry := range strings.Split(cfg.RateLimits, ",") {
+		group, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			continue
+		}
+		limit, err := parseRateLimit(strings.Trim(strings.TrimSpace(group), "/"), strings.TrimSpace(value))
+		if err != nil {
+			slog.Warn("Ignoring RATE_LIMITS entry", "entry", entry, "error", err)
+			continue
+		}
+		if limit.group == defaultRateLimitGroup {
+			l.fallback = &limit
+			continue
+		}
+		l.limits = append(l.limits, limit)
+	}
+	// The longest prefix wins, so notifications/bulk is matched before notifications
+	sort.Slice(l.limits, func(i, j int) bool { return len(l.limits[i].group) > len(l.limits[j].group) })
+	return l
+}
+
+func parseRateLimit(group, value string) (rateLimit, error) {
+	perMinute, burst, hasBurst := strings.Cut(value, ":")
+	limit := rateLimit{group: group}
+	var err error
+	if limit.perMinute, err = strconv.Atoi(perMinute); err != nil || limit.perMinute <= 0 {
+		return limit, fmt.Errorf("requests per minute must be a positive number")
+	}
+	limit.burst = limit.perMinute
+	if hasBurst {
+		if limit.burst, err = strconv.Atoi(burst); err != nil || limit.burst <= 0 {
+			return limit, fmt.Errorf("burst must be a positive number")
+		}
+	}
+	return limit, nil
+}
+
+// limitFor returns the limit of the group route belongs to, or nil when it is unlimited
+func (l *RateLimiter) limitFor(route string) *rateLimit {
+	path := strings.Trim(strings.TrimPrefix(route, "/api/v1"), "/")
+	for i, limit := range l.limits {
+		if path == limit.group || strings.HasPrefix(path, limit.group+"/") {
+			return &l.limits[i]
+		}
+	}
+	return l.fallback
+}
+
+// Allow takes one request from the allowance of every subject for route's group. The
+// request is allowed only when all of them have allowance left. An unlimited route is
+// allowed with an empty Group.
+func (l *RateLimiter) Allow(ctx context.Context, route string, subjects []models.RateLimitSubject) (models.RateLimitDecision, error) {
+	limit := l.limitFor(route)
+	if limit == nil || len(subjects) == 0 {
+		return models.RateLimitDecision{Allowed: true}, nil
+	}
+
+	// The group is hash-tagged so a call's buckets are in one cluster slot
+	keys := make([]string, len(subjects))
+	for i, subject := range subjects {
+		keys[i] = "ratelimit:{" + limit.group + "}:" + subject.Kind + ":" + subject.ID
+	}
+	rate := float64(limit.perMinute) / 60
+	result, err := tokenBucketScript.Run(ctx, l.redis.client, keys, rate, limit.burst).Slice()
+	if err != nil {
+		return models.RateLimitDecision{}, fmt.Errorf("failed to check rate limit: %w", err)
+	}
+	if len(result) != 4 {
+		return models.RateLimitDecision{}, fmt.Errorf("unexpected rate limit result %v", result)
+	}
+
+	allowed, _ := result[0].(int64)
+	lowest, _ := result[1].(int64)
+	tokens, _ := strconv.ParseFloat(fmt.Sprint(result[2]), 64)
+	wait, _ := strconv.ParseFloat(fmt.Sprint(result[3]), 64)
+	decision := models.RateLimitDecision{
+		Allowed:   allowed == 1,
+		Group:     limit.group,
+		Limit:     limit.perMinute,
+		Remaining: max(int(math.Floor(tokens)), 0),
+	}
+	if !decision.Allowed && int(lowest) < len(subjects) {
+		decision.Subject = subjects[lowest]
+		decision.RetryAfter = time.Duration(wait * float64(time.Second))
+	}
+	return decision, nil
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/storage"
+)
+
+// readinessCheckTimeout bounds each dependency check, so one hung dependency can't
+// outlast the probe's own timeout
+const readinessCheckTimeout = 2 * time.Second
+
+// dependencyCheck checks one dependency. It returns configured false for a dependency
+// this deployment doesn't use, with detail saying why.
+type dependencyCheck func(ctx context.Context) (configured bool, detail string, err error)
+
+// ReadinessChecker checks the dependencies the service can't work without: Redis, the
+// notification database, the Event Hub consumer and the database schema. Results are
+// reused for READINESS_CACHE_SECONDS, so frequent probes from several sources don't
+// turn into a PING per request.
+type ReadinessChecker struct {
+	checks map[string]dependencyCheck
+	ttl    time.Duration
+
+	mutex sync.Mutex
+	last  *models.ReadinessReport
+}
+
+// NewReadinessChecker checks repo only when the service stores notifications in the
+// database; a nil repo with DATABASE_URL empty means it runs on Redis alone, and with it
+// set that the database couldn't be opened.
+func NewReadinessChecker(cfg *config.Config, redis *RedisClient, repo storage.NotificationRepository, eventHub *EventHubService, schemaGate *storage.SchemaGate) *ReadinessChecker {
+	return &ReadinessChecker{
+		ttl: time.Duration(cfg.ReadinessCacheSeconds) * time.Second,
+		checks: map[string]dependencyCheck{
+			"redis": func(ctx context.Context) (bool, string, error) {
+				return true, "", redis.Ping(ctx)
+			},
+			"database": func(ctx context.Context) (bool, string, error) {
+				if repo == nil && cfg.DatabaseURL != "" {
+					return true, "", errors.New("DATABASE_URL is set but the database couldn't be opened")
+				}
+				if repo == nil {
+					return false, "notifications are stored in Redis only", nil
+				}
+				return true, "", repo.Ping(ctx)
+			},
+			"eventhub": func(ctx context.Context) (bool, string, error) {
+				configured, err := eventHub.ClientState()
+				if !configured {
+					return false, "Event Hub connection string not configured", nil
+				}
+				return true, "", err
+			},
+			"schema": func(ctx context.Context) (bool, string, error) {
+				return true, "", schemaGate.Check(ctx)
+			},
+		},
+	}
+}
+
+// Check returns the latest readiness report, checking the dependencies again once the
+// cached one is older than the TTL
+func (r *ReadinessChecker) Check(ctx context.Context) models.ReadinessReport {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.last != nil && time.Since(r.last.CheckedAt) < r.ttl {
+		return *r.last
+	}
+
+	// A probe that gives up must not leave a cached report of cancelled checks
+	ctx = context.WithoutCancel(ctx)
+	report := models.ReadinessReport{
+		Ready:     true,
+		Checks:    make(map[string]models.DependencyCheck, len(r.checks)),
+		CheckedAt: time.Now(),
+	}
+
+	var (
+		wg      sync.WaitGroup
+		results sync.Mutex
+	)
+	for name, check := range r.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result := runDependencyCheck(ctx, check)
+			results.Lock()
+			report.Checks[name] = result
+			results.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	var down []string
+	for name, result := range report.Checks {
+		if result.Status == models.DependencyDown {
+			report.Ready = false
+			down = append(down, name)
+		}
+	}
+	sort.Strings(down)
+
+	switch {
+	case !report.Ready && (r.last == nil || r.last.Ready):
+		slog.WarnContext(ctx, "Service not ready", "readiness.down", strings.Join(down, ","))
+	case report.Ready && r.last != nil && !r.last.Ready:
+		slog.InfoContext(ctx, "✓ Service ready again")
+	}
+	r.last = &report
+	return report
+}
+
+func runDependencyCheck(ctx context.Context, check dependencyCheck) models.DependencyCheck {
+	ctx, cancel := context.WithTimeout(ctx, readinessCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	configured, detail, err := check(ctx)
+	result := models.DependencyCheck{
+		Status:    models.DependencyUp,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+		Detail:    detail,
+	}
+	switch {
+	case !configured:
+		result.Status = models.DependencyDisabled
+	case err != nil:
+		result.Status = models.DependencyDown
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+
+	"github.com/nyaruka/phonenumbers"
+	"golang.org/x/net/idna"
+)
+
+var ErrInvalidRecipient = errors.New("invalid recipient")
+
+// Reasons a recipient is rejected
+const (
+	RecipientMissingCountryCode = "missing_country_code"
+	RecipientInvalidCountryCode = "invalid_country_code"
+	RecipientNotANumber         = "not_a_number"
+	RecipientTooShort           = "too_short"
+	RecipientTooLong            = "too_long"
+	RecipientInvalidNumber      = "invalid_number"
+	RecipientMalformedAddress   = "malformed_address"
+	RecipientInvalidDomain      = "invalid_domain"
+)
+
+// RecipientError is an email address or phone number that can't be normalized, with
+// the reason and a hint at how to fix it
+type RecipientError struct {
+	Channel   models.NotificationType
+	Recipient string
+	Reason    string
+	Hint      string
+}
+
+func (e *RecipientError) Error() string {
+	return fmt.Sprintf("%s recipient %q is not valid (%s): %s", e.Channel, e.Recipient, e.Reason, e.Hint)
+}
+
+func (e *RecipientError) Unwrap() error {
+	return ErrInvalidRecipient
+}
+
+// RecipientNormalizer brings email and SMS recipients to one form when notifications are
+// created, so the same address or number always matches in opt-out lists, collapse keys
+// and lookups however it was typed. Phone numbers become E.164, parsed with
+// libphonenumber; numbers without a country code are read in PHONE_DEFAULT_REGION.
+// Email addresses lose their display name and are lowercased, with international
+// domain names converted to their ASCII (punycode) form.
+type RecipientNormalizer struct {
+	region string
+}
+
+func NewRecipientNormalizer(cfg *config.Config) *RecipientNormalizer {
+	return &RecipientNormalizer{region: strings.ToUpper(strings.TrimSpace(cfg.PhoneDefaultRegion))}
+}
+
+// Normalize replaces the recipient of an email or SMS notification with its normalized
+// form, keeping the original in RawRecipient. Other channels are left alone.
+func (n *RecipientNormalizer) Normalize(notification *models.Notification) error {
+	if notification.Recipient == "" {
+		return nil
+	}
+	var normalized string
+	var err error
+	switch notification.Type {
+	case models.NotificationTypeEmail:
+		normalized, err = NormalizeEmail(notification.Recipient)
+	case models.NotificationTypeSMS:
+		normalized, err = n.NormalizePhone(notification.Recipient)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	notification.RawRecipient = notification.Recipient
+	notification.Recipient = normalized
+	return nil
+}
+
+// NormalizePhone returns a phone number in E.164 format, such as +14155550123
+func (n *RecipientNormalizer) NormalizePhone(raw string) (string, error) {
+	fail := func(reason, hint string) error {
+		return &RecipientError{Channel: models.NotificationTypeSMS, Recipient: raw, Reason: reason, Hint: hint}
+	}
+	value := strings.TrimSpace(raw)
+	if !strings.HasPrefix(value, "+") && n.region == "" {
+		return "", fail(RecipientMissingCountryCode, "start the number with + and its country code, such as +14155550123")
+	}
+
+	number, err := phonenumbers.Parse(value, n.region)
+	switch {
+	case errors.Is(err, phonenumbers.ErrInvalidCountryCode):
+		return "", fail(RecipientInvalidCountryCode, "start the number with + and a valid country code, such as +44 for the UK")
+	case errors.Is(err, phonenumbers.ErrNotANumber):
+		return "", fail(RecipientNotANumber, "send digits only, optionally with +, spaces, dashes or parentheses")
+	case errors.Is(err, phonenumbers.ErrTooShortNSN), errors.Is(err, phonenumbers.ErrTooShortAfterIDD):
+		return "", fail(RecipientTooShort, "the number is missing digits")
+	case errors.Is(err, phonenumbers.ErrNumTooLong):
+		return "", fail(RecipientTooLong, "the number has too many digits")
+	case err != nil:
+		return "", fail(RecipientNotANumber, err.Error())
+	}
+
+	switch phonenumbers.IsPossibleNumberWithReason(number) {
+	case phonenumbers.TOO_SHORT:
+		return "", fail(RecipientTooShort, "the number is missing digits for its country")
+	case phonenumbers.TOO_LONG:
+		return "", fail(RecipientTooLong, "the number has too many digits for its country")
+	}
+	if !phonenumbers.IsValidNumber(number) {
+		return "", fail(RecipientInvalidNumber, "no phone number in its country has this form; check the country code and area code")
+	}
+	return phonenumbers.Format(number, phonenumbers.E164), nil
+}
+
+// NormalizeEmail returns the lowercased address of an email recipient with its domain in
+// ASCII, dropping any display name: "Ana <Ana@Bücher.example>" becomes
+// ana@xn--bcher-kva.example
+func NormalizeEmail(raw string) (string, error) {
+	fail := func(reason, hint string) error {
+		return &RecipientError{Channel: models.NotificationTypeEmail, Recipient: raw, Reason: reason, Hint: hint}
+	}
+	address, err := mail.ParseAddress(strings.TrimSpace(raw))
+	if err != nil {
+		return "", fail(RecipientMalformedAddress, "use an address such as name@example.com ("+err.Error()+")")
+	}
+
+	at := strings.LastIndex(address.Address, "@")
+	local, domain := address.Address[:at], strings.TrimSuffix(address.Address[at+1:], ".")
+	ascii, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return "", fail(RecipientInvalidDomain, fmt.Sprintf("%q is not a valid domain name", domain))
+	}
+	if !strings.Contains(ascii, ".") {
+		return "", fail(RecipientInvalidDomain, fmt.Sprintf("use a fully qualified domain such as example.com, not %q", domain))
+	}
+	return strings.ToLower(local) + "@" + strings.ToLower(ascii), nil
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"notification-service/internal/storage"
+	"notification-service/internal/telemetry"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// ErrBufferFull is returned when Redis is unavailable and the write-behind buffer is at capacity
+var ErrBufferFull = errors.New("redis unavailable and write-behind buffer is full")
+
+// redisWrite is a single buffered write, replayed against Redis once it is reachable again
+type redisWrite func(ctx context.Context, client *redis.Client) error
+
+// WriteBehindBuffer holds writes while Redis is unavailable and flushes them when it
+// returns. Writes are queued per customer and replayed in order; while a customer has
+// queued writes, new writes for that customer are queued behind them. The background
+// flush holds off while the service is read-only.
+type WriteBehindBuffer struct {
+	client   *redis.Client
+	readOnly *storage.ReadOnlyFlag
+	capacity int
+	interval time.Duration
+
+	mutex  sync.Mutex
+	queues map[string][]redisWrite
+	depth  int
+}
+
+func NewWriteBehindBuffer(client *redis.Client, readOnly *storage.ReadOnlyFlag, capacity int, flushInterval time.Duration) *WriteBehindBuffer {
+	return &WriteBehindBuffer{
+		client:   client,
+		readOnly: readOnly,
+		capacity: capacity,
+		interval: flushInterval,
+		queues:   make(map[string][]redisWrite),
+	}
+}
+
+// Write applies a write directly, or buffers it if Redis is unavailable or the customer
+// already has buffered writes. It reports whether the write was buffered. The check for
+// buffered writes and the capacity check are made under the same lock as the insert, so
+// a flush or another write can't slip in between them.
+func (b *WriteBehindBuffer) Write(ctx context.Context, customerID string, write redisWrite) (bool, error) {
+	b.mutex.Lock()
+	if len(b.queues[customerID]) > 0 {
+		defer b.mutex.Unlock()
+		return true, b.enqueueLocked(ctx, customerID, write)
+	}
+	b.mutex.Unlock()
+
+	err := write(ctx, b.client)
+	if err == nil {
+		return false, nil
+	}
+	if !isRedisUnavailable(err) {
+		return false, err
+	}
+	slog.WarnContext(ctx, "Redis unavailable, buffering write", "customer.id", customerID, "error", err)
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return true, b.enqueueLocked(ctx, customerID, write)
+}
+
+// Depth returns the number of buffered writes
+func (b *WriteBehindBuffer) Depth() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.depth
+}
+
+// Start runs the flush loop until the context is cancelled
+func (b *WriteBehindBuffer) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(b.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if b.Depth() == 0 {
+					continue
+				}
+				if err := b.client.Ping(ctx).Err(); err != nil || b.readOnly.IsReadOnly(ctx) {
+					continue
+				}
+				b.flush(ctx)
+			}
+		}
+	}()
+}
+
+// Flush replays the buffered writes now if Redis is reachable, and returns how many are
+// still buffered
+func (b *WriteBehindBuffer) Flush(ctx context.Context) int {
+	if b.Depth() == 0 {
+		return 0
+	}
+	if err := b.client.Ping(ctx).Err(); err != nil {
+		return b.Depth()
+	}
+	b.flush(ctx)
+	return b.Depth()
+}
+
+// enqueueLocked queues the write if the buffer has room; the caller holds the mutex
+func (b *WriteBehindBuffer) enqueueLocked(ctx context.Context, customerID string, write redisWrite) error {
+	if b.depth >= b.capacity {
+		telemetry.RecordRedisBufferDrop(ctx)
+		return ErrBufferFull
+	}
+
+	b.queues[customerID] = append(b.queues[customerID], write)
+	b.depth++
+	telemetry.RecordRedisBufferDepthChange(ctx, 1)
+	return nil
+}
+
+// flush replays each customer's queue in order, stopping a queue at its first failure
+func (b *WriteBehindBuffer) flush(ctx context.Context) {
+	b.mutex.Lock()
+	customers := make([]string, 0, len(b.queues))
+	for customerID := range b.queues {
+		customers = append(customers, customerID)
+	}
+	b.mutex.Unlock()
+
+	flushed := 0
+	for _, customerID := range customers {
+		for {
+			b.mutex.Lock()
+			queue := b.queues[customerID]
+			if len(queue) == 0 {
+				delete(b.queues, customerID)
+				b.mutex.Unlock()
+				break
+			}
+			write := queue[0]
+			b.mutex.Unlock()
+
+			if err := write(ctx, b.client); err != nil {
+				if isRedisUnavailable(err) {
+					slog.WarnContext(ctx, "Redis became unavailable during flush", "buffer.depth", b.Depth())
+					return
+				}
+				slog.ErrorContext(ctx, "Dropping buffered write", "customer.id", customerID, "error", err)
+			}
+
+			b.mutex.Lock()
+			b.queues[customerID] = b.queues[customerID][1:]
+			b.depth--
+			b.mutex.Unlock()
+			telemetry.RecordRedisBufferDepthChange(ctx, -1)
+			flushed++
+		}
+	}
+
+	if flushed > 0 {
+		slog.InfoContext(ctx, "✓ Flushed buffered Redis writes", "buffer.flushed", flushed)
+	}
+}
+
+// isRedisUnavailable distinguishes connectivity problems from command errors
+func isRedisUnavailable(err error) bool {
+	if err == nil || errors.Is(err, redis.Nil) {
+		return false
+	}
+	var redisErr redis.Error
+	return !errors.As(err, &redisErr)
+}
@@ -0,0 +1,467 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/storage"
+	"notification-service/internal/telemetry"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	ErrRedriveJobNotFound    = errors.New("re-drive job not found")
+	ErrRedriveJobNotPending  = errors.New("re-drive job is not awaiting confirmation")
+	ErrRedriveJobExpired     = errors.New("re-drive job confirmation has expired")
+	ErrRedriveCountMismatch  = errors.New("confirmed count does not match the notifications the job matched")
+	ErrInvalidRedriveFilter  = errors.New("invalid re-drive filter")
+	ErrRedriveTooManyMatches = errors.New("re-drive matches too many notifications")
+)
+
+// redriveRetention is how long jobs and their notification lists are kept after creation
+const redriveRetention = 7 * 24 * time.Hour
+
+// redriveScanPage is how many failed notifications are read from the database at a time
+const redriveScanPage = 500
+
+const (
+	// redriveJobQueue holds the confirmed jobs waiting for a replica to run them
+	redriveJobQueue = "redrive-jobs"
+	// redriveLease is how long a replica that stopped keeps a job from the others
+	redriveLease = time.Minute
+	// redrivePoll is how often each replica looks for confirmed jobs
+	redrivePoll = 5 * time.Second
+)
+
+// Results of re-driving one notification, also the fields of a job's progress hash
+const (
+	redriveRequeued = "requeued"
+	redriveSkipped  = "skipped"
+	redriveFailed   = "failed"
+)
+
+// RedriveService re-drives failed notifications in bulk. A request matches the failed
+// notifications in the database against its filter and parks the result as a job; only
+// when the job is confirmed with its matched count are they moved back to retrying, in
+// the background, with the counts kept as the job's progress. A confirmed job is run by
+// whichever replica claims it, under a lease, so a replica stopping mid-job leaves it to
+// another. Each notification is screened again first; one the screener blocks is skipped.
+type RedriveService struct {
+	redis         *RedisClient
+	notifications *NotificationService
+	screening     ContentScreener
+	queue         *workQueue
+	maxMatches    int
+	ttl           time.Duration
+}
+
+func NewRedriveService(cfg *config.Config, redis *RedisClient, notifications *NotificationService, screening ContentScreener) *RedriveService {
+	ttl := time.Duration(cfg.RedriveConfirmTTLMinutes) * time.Minute
+	if ttl <= 0 {
+		ttl = 15 * time.Minute
+	}
+	return &RedriveService{
+		redis:         redis,
+		notifications: notifications,
+		screening:     screening,
+		queue:         newWorkQueue(redis, redriveJobQueue, redriveLease),
+		maxMatches:    max(cfg.RedriveMaxNotifications, 1),
+		ttl:           ttl,
+	}
+}
+
+func redriveJobKey(id string) string {
+	return "redrive:" + id
+}
+
+// redriveQueueKey lists the notifications a job has yet to re-drive. It and the job's
+// other lists are hash-tagged with the job ID, so they are in one cluster slot.
+func redriveQueueKey(id string) string {
+	return "redrive-queue:{" + id + "}"
+}
+
+// redriveProcessingKey lists the notifications a job has taken off its queue and not yet
+// counted, so a run that stops partway loses none of them
+func redriveProcessingKey(id string) string {
+	return "redrive-processing:{" + id + "}"
+}
+
+func redriveProgressKey(id string) string {
+	return "redrive-progress:{" + id + "}"
+}
+
+// Submit matches the failed notifications for req and holds them in a job awaiting
+// confirmation. A filter matching more than REDRIVE_MAX_NOTIFICATIONS is refused.
+func (s *RedriveService) Submit(ctx context.Context, req models.NotificationRedriveRequest, requestedBy string) (*models.RedriveJob, error) {
+	if req.Status == "" {
+		req.Status = models.NotificationStatusFailed
+	}
+	if req.Status != models.NotificationStatusFailed {
+		return nil, fmt.Errorf("%w: only failed notifications can be re-driven", ErrInvalidRedriveFilter)
+	}
+	if req.From != nil && req.To != nil && !req.From.Before(*req.To) {
+		return nil, fmt.Errorf("%w: from must be before to", ErrInvalidRedriveFilter)
+	}
+
+	ids, err := s.match(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("redrive.matched", len(ids)))
+
+	now := time.Now().UTC()
+	job := &models.RedriveJob{
+		ID:          NewID(),
+		Filter:      req,
+		Matched:     len(ids),
+		Status:      models.RedriveJobPendingConfirmation,
+		RequestedBy: requestedBy,
+		CreatedAt:   now,
+	}
+	// Nothing to confirm
+	if len(ids) == 0 {
+		job.Status = models.RedriveJobCompleted
+		job.Progress = &models.RedriveProgress{}
+		job.CompletedAt = &now
+		if err := s.save(ctx, s.redis.client, job); err != nil {
+			return nil, err
+		}
+		return job, nil
+	}
+
+	expiresAt := now.Add(s.ttl)
+	job.ExpiresAt = &expiresAt
+	members := make([]interface{}, len(ids))
+	for i, id := range ids {
+		members[i] = id
+	}
+	pipe := s.redis.client.TxPipeline()
+	pipe.RPush(ctx, redriveQueueKey(job.ID), members...)
+	pipe.Expire(ctx, redriveQueueKey(job.ID), redriveRetention)
+	if err := s.save(ctx, pipe, job); err != nil {
+		return nil, err
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to save re-drive job: %w", err)
+	}
+	slog.InfoContext(ctx, "🔁 Re-drive awaiting confirmation", "redrive.id", job.ID, "redrive.matched", job.Matched)
+	return job, nil
+}
+
+// match pages through the failed notifications in the database the filter selects,
+// newest first, and returns their IDs
+func (s *RedriveService) match(ctx context.Context, req models.NotificationRedriveRequest) ([]string, error) {
+	var ids []string
+	filter := storage.NotificationFilter{
+		Status:     req.Status,
+		Type:       req.Channel,
+		ErrorClass: req.ErrorClass,
+		Limit:      redriveScanPage,
+	}
+	if req.From != nil {
+		filter.FailedAfter = *req.From
+	}
+	if req.To != nil {
+		filter.FailedBefore = *req.To
+	}
+	for {
+		page, next, err := s.notifications.ListNotifications(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		for _, notification := range page {
+			if len(ids) == s.maxMatches {
+				return nil, fmt.Errorf("%w: more than %d; narrow the filter", ErrRedriveTooManyMatches, s.maxMatches)
+			}
+			ids = append(ids, notification.ID)
+		}
+		if next == "" {
+			return ids, nil
+		}
+		filter.Cursor = next
+	}
+}
+
+// Get returns a re-drive job, with its progress so far while it runs, marking it expired
+// if it wasn't confirmed in time
+func (s *RedriveService) Get(ctx context.Context, id string) (*models.RedriveJob, error) {
+	job, err := s.load(ctx, s.redis.client, id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status == models.RedriveJobPendingConfirmation && s.expired(job) {
+		job.Status = models.RedriveJobExpired
+	}
+	if job.Status == models.RedriveJobRunning {
+		if job.Progress, err = s.progress(ctx, job); err != nil {
+			return nil, err
+		}
+	}
+	return job, nil
+}
+
+// Confirm starts a job awaiting confirmation. matched must repeat the job's matched
+// count, so a re-drive is only started by someone who has seen how big it is.
+func (s *RedriveService) Confirm(ctx context.Context, id, confirmedBy string, matched int) (*models.RedriveJob, error) {
+	// The job is queued first; a replica that claims it before it is confirmed, or after
+	// the confirmation failed, finds it isn't running and drops it
+	if err := s.queue.Add(ctx, s.redis.client, id, time.Now()).Err(); err != nil {
+		return nil, fmt.Errorf("failed to queue re-drive job: %w", err)
+	}
+
+	var confirmed *models.RedriveJob
+	err := s.redis.client.Watch(ctx, func(tx *redis.Tx) error {
+		job, err := s.load(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if job.Status != models.RedriveJobPendingConfirmation {
+			return fmt.Errorf("%w: job is %s", ErrRedriveJobNotPending, job.Status)
+		}
+		if s.expired(job) {
+			return ErrRedriveJobExpired
+		}
+		if matched != job.Matched {
+			return fmt.Errorf("%w: the job matched %d", ErrRedriveCountMismatch, job.Matched)
+		}
+
+		now := time.Now().UTC()
+		job.Status = models.RedriveJobRunning
+		job.ConfirmedBy = confirmedBy
+		job.ConfirmedAt = &now
+		job.ExpiresAt = nil
+		job.Progress = &models.RedriveProgress{Total: job.Matched, Queued: job.Matched}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, redriveProgressKey(id), "total", job.Matched)
+			pipe.Expire(ctx, redriveProgressKey(id), redriveRetention)
+			return s.save(ctx, pipe, job)
+		})
+		confirmed = job
+		return err
+	}, redriveJobKey(id))
+
+	if errors.Is(err, redis.TxFailedErr) {
+		return nil, fmt.Errorf("%w: job changed concurrently", ErrRedriveJobNotPending)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return confirmed, nil
+}
+
+// Start runs confirmed jobs as this replica claims them, until ctx is cancelled
+func (s *RedriveService) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(redrivePoll)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				ids, err := s.queue.Claim(ctx, 1)
+				if err != nil {
+					slog.WarnContext(ctx, "Failed to claim re-drive jobs", "error", err)
+					continue
+				}
+				for _, id := range ids {
+					go s.runClaimed(ctx, id)
+				}
+			}
+		}
+	}()
+}
+
+// runClaimed runs a claimed job while holding its lease. A job that is not running,
+// because its confirmation failed or another replica finished it, is dropped.
+func (s *RedriveService) runClaimed(ctx context.Context, id string) {
+	release := s.queue.Hold(ctx, id)
+	defer release()
+
+	job, err := s.load(ctx, s.redis.client, id)
+	switch {
+	case errors.Is(err, ErrRedriveJobNotFound):
+	case err != nil:
+		slog.WarnContext(ctx, "Failed to load re-drive job", "redrive.id", id, "error", err)
+		if err := s.queue.Retry(ctx, id, time.Now().Add(redrivePoll)); err != nil {
+			slog.WarnContext(ctx, "Failed to requeue re-drive job", "redrive.id", id, "error", err)
+		}
+		return
+	case job.Status == models.RedriveJobRunning:
+		if !s.run(ctx, *job) {
+			// Stopped with the replica; the lease runs out and the job is picked up again
+			return
+		}
+	}
+	if err := s.queue.Done(ctx, id); err != nil {
+		slog.WarnContext(ctx, "Failed to finish re-drive job", "redrive.id", id, "error", err)
+	}
+}
+
+func (s *RedriveService) expired(job *models.RedriveJob) bool {
+	return job.ExpiresAt != nil && time.Now().After(*job.ExpiresAt)
+}
+
+// run re-drives a confirmed job's notifications one at a time. Each is moved from the
+// job's queue to its processing list, and off that list as its result is counted, so
+// the notifications of a run that stopped partway are picked up by the next. A
+// notification that is no longer failed is skipped. run returns false when it stopped
+// before the job was done.
+func (s *RedriveService) run(ctx context.Context, job models.RedriveJob) bool {
+	ctx, span := telemetry.Tracer.Start(ctx, "notification.redrive",
+		trace.WithAttributes(
+			attribute.String("redrive.id", job.ID),
+			attribute.Int("redrive.matched", job.Matched),
+		),
+	)
+	defer span.End()
+
+	// Put back what an earlier run took but didn't count
+	for {
+		err := s.redis.client.LMove(ctx, redriveProcessingKey(job.ID), redriveQueueKey(job.ID), "RIGHT", "LEFT").Err()
+		if errors.Is(err, redis.Nil) {
+			break
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Re-drive stopped", "redrive.id", job.ID, "error", err)
+			span.RecordError(err)
+			return false
+		}
+	}
+
+	for {
+		id, err := s.redis.client.LMove(ctx, redriveQueueKey(job.ID), redriveProcessingKey(job.ID), "LEFT", "RIGHT").Result()
+		if errors.Is(err, redis.Nil) {
+			break
+		}
+		if err != nil {
+			slog.ErrorContext(ctx, "Re-drive stopped", "redrive.id", job.ID, "error", err)
+			span.RecordError(err)
+			return false
+		}
+
+		result := redriveRequeued
+		notification, err := s.requeue(ctx, id)
+		if ctx.Err() != nil {
+			// Left on the processing list for the next run
+			return false
+		}
+		switch {
+		case errors.Is(err, ErrContentBlocked):
+			slog.InfoContext(ctx, "Re-drive skipped notification blocked by content screening", "redrive.id", job.ID, "notification.id", id, "error", err)
+			result = redriveSkipped
+		case errors.Is(err, ErrInvalidStatusTransition), errors.Is(err, ErrNotificationNotFound):
+			result = redriveSkipped
+		case err != nil:
+			slog.WarnContext(ctx, "Re-drive failed to requeue notification", "redrive.id", job.ID, "notification.id", id, "error", err)
+			result = redriveFailed
+		}
+		channel := string(job.Filter.Channel)
+		if notification != nil {
+			channel = string(notification.Type)
+		}
+		_, err = s.redis.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HIncrBy(ctx, redriveProgressKey(job.ID), result, 1)
+			pipe.LRem(ctx, redriveProcessingKey(job.ID), 1, id)
+			pipe.Expire(ctx, redriveProcessingKey(job.ID), redriveRetention)
+			return nil
+		})
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to count re-drive result", "redrive.id", job.ID, "redrive.result", result, "error", err)
+		}
+		telemetry.RecordNotificationRedrive(ctx, channel, result)
+	}
+
+	progress, err := s.progress(ctx, &job)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to read re-drive progress", "redrive.id", job.ID, "error", err)
+		progress = job.Progress
+	}
+	now := time.Now().UTC()
+	job.Progress = progress
+	job.Status = models.RedriveJobCompleted
+	job.CompletedAt = &now
+	span.SetAttributes(
+		attribute.Int("redrive.requeued", progress.Requeued),
+		attribute.Int("redrive.skipped", progress.Skipped),
+		attribute.Int("redrive.failed", progress.Failed),
+	)
+	if err := s.save(ctx, s.redis.client, &job); err != nil {
+		slog.ErrorContext(ctx, "Failed to save re-drive", "redrive.id", job.ID, "error", err)
+	}
+	slog.InfoContext(ctx, "🔁 Re-drive completed", "redrive.id", job.ID, "redrive.requeued", progress.Requeued, "redrive.skipped", progress.Skipped, "redrive.failed", progress.Failed)
+	return true
+}
+
+// requeue screens a failed notification's content again and moves it back to retrying,
+// unless the screener blocks it
+func (s *RedriveService) requeue(ctx context.Context, id string) (*models.Notification, error) {
+	notification, err := s.notifications.GetNotification(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := ScreenNotification(ctx, s.screening, notification); err != nil {
+		return notification, err
+	}
+	return s.notifications.RequeueNotification(ctx, id)
+}
+
+// progress reads a running job's counts
+func (s *RedriveService) progress(ctx context.Context, job *models.RedriveJob) (*models.RedriveProgress, error) {
+	fields, err := s.redis.client.HGetAll(ctx, redriveProgressKey(job.ID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load re-drive progress: %w", err)
+	}
+	if len(fields) == 0 {
+		return job.Progress, nil
+	}
+	field := func(name string) int {
+		value, _ := strconv.Atoi(fields[name])
+		return value
+	}
+	progress := &models.RedriveProgress{
+		Total:    field("total"),
+		Requeued: field(redriveRequeued),
+		Skipped:  field(redriveSkipped),
+		Failed:   field(redriveFailed),
+	}
+	progress.Queued = max(progress.Total-progress.Requeued-progress.Skipped-progress.Failed, 0)
+	return progress, nil
+}
+
+func (s *RedriveService) save(ctx context.Context, client redis.Cmdable, job *models.RedriveJob) error {
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal re-drive job: %w", err)
+	}
+	if err := client.Set(ctx, redriveJobKey(job.ID), payload, redriveRetention).Err(); err != nil {
+		return fmt.Errorf("failed to save re-drive job: %w", err)
+	}
+	return nil
+}
+
+func (s *RedriveService) load(ctx context.Context, client redis.Cmdable, id string) (*models.RedriveJob, error) {
+	payload, err := client.Get(ctx, redriveJobKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrRedriveJobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load re-drive job: %w", err)
+	}
+
+	var job models.RedriveJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return nil, fmt.Errorf("failed to decode re-drive job: %w", err)
+	}
+	return &job, nil
+}
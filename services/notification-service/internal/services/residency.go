@@ -0,0 +1,299 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/storage"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// notificationRegionKey records the data region of a notification stored outside the
+// home region. It holds only the region, so the home region can route reads by ID
+// without keeping any of the notification.
+func notificationRegionKey(id string) string {
+	return "notification-region:" + id
+}
+
+// DataResidency pins tenants to data regions (TENANT_REGIONS). A pinned tenant's
+// notifications, with their edit history, are kept in the Redis (REGION_REDIS_URLS) and
+// Postgres (REGION_DATABASE_URLS) of its region; everyone else's stay in this
+// deployment's own stores, the home region (SERVICE_REGION). The home Redis keeps the
+// indexes of every notification, which hold IDs only, and a pointer to the region of
+// each regional one. A region without a reachable store fails its tenants' writes
+// rather than storing their data elsewhere.
+type DataResidency struct {
+	home      string
+	tenants   map[string]string
+	redis     *RedisClient
+	clients   map[string]*redis.Client
+	databases map[string]storage.NotificationRepository
+	repo      storage.NotificationRepository
+}
+
+// NewDataResidency connects the regional stores. home is this deployment's notification
+// database, nil when it is unavailable; regional databases are migrated like it.
+func NewDataResidency(ctx context.Context, cfg *config.Config, redisClient *RedisClient, home storage.NotificationRepository) *DataResidency {
+	d := &DataResidency{
+		home:      cfg.ServiceRegion,
+		tenants:   parseRegionMap(cfg.TenantRegions),
+		redis:     redisClient,
+		clients:   make(map[string]*redis.Client),
+		databases: make(map[string]storage.NotificationRepository),
+		repo:      home,
+	}
+	for region, url := range parseRegionMap(cfg.RegionRedisURLs) {
+		if region != d.home {
+			d.clients[region] = storage.NewRedisClient(url)
+		}
+	}
+	for region, url := range parseRegionMap(cfg.RegionDatabaseURLs) {
+		if region == d.home {
+			continue
+		}
+		if cfg.DatabaseMigrateOnStartup {
+			if _, err := storage.MigrateSchema(ctx, url); err != nil {
+				slog.Warn("Regional database migration skipped", "data.region", region, "error", err)
+			}
+		}
+		repo, err := storage.NewPostgresNotificationRepository(ctx, url)
+		if err != nil {
+			slog.Error("Regional notification database unavailable, its tenants' notifications will fail", "data.region", region, "error", err)
+			d.databases[region] = nil
+			continue
+		}
+		d.databases[region] = repo
+	}
+	if len(d.databases) > 0 {
+		regions := make(map[string]storage.NotificationRepository, len(d.databases))
+		for region, repo := range d.databases {
+			regions[region] = repo
+		}
+		d.repo = storage.NewRegionalNotificationRepository(home, d.home, regions)
+	}
+	for tenant, region := range d.tenants {
+		if region != d.home && d.clients[region] == nil {
+			slog.Warn("Tenant pinned to a region without REGION_REDIS_URLS, its notifications will fail", "tenant.id", tenant, "data.region", region)
+		}
+	}
+	return d
+}
+
+// regionAttributes tags a notification's spans and metrics with its data region
+func regionAttributes(notification *models.Notification) []attribute.KeyValue {
+	if notification.Region == "" {
+		return nil
+	}
+	return []attribute.KeyValue{attribute.String("data.region", notification.Region)}
+}
+
+// parseRegionMap reads "key=region,..." and "region=url,..." settings
+func parseRegionMap(value string) map[string]string {
+	entries := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		key, region, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if ok && strings.TrimSpace(key) != "" && strings.TrimSpace(region) != "" {
+			entries[strings.TrimSpace(key)] = strings.TrimSpace(region)
+		}
+	}
+	return entries
+}
+
+// Enabled reports whether any tenant is pinned to a region
+func (d *DataResidency) Enabled() bool {
+	return len(d.tenants) > 0
+}
+
+// Repository is the notification repository to use: the home one, routed per region
+// when regional databases are configured
+func (d *DataResidency) Repository() storage.NotificationRepository {
+	return d.repo
+}
+
+// Region returns the data region a notification is stored in: its tenant's, or the
+// home region, which is empty when SERVICE_REGION isn't set
+func (d *DataResidency) Region(notification *models.Notification) string {
+	if region, ok := d.tenants[notificationTenant(notification)]; ok {
+		return region
+	}
+	return d.home
+}
+
+// Regional reports whether region is outside the home region
+func (d *DataResidency) Regional(region string) bool {
+	return region != "" && region != d.home
+}
+
+// client returns the Redis of a data region
+func (d *DataResidency) client(region string) (*redis.Client, error) {
+	if !d.Regional(region) {
+		return d.redis.client, nil
+	}
+	client := d.clients[region]
+	if client == nil {
+		return nil, fmt.Errorf("%w: %s", storage.ErrRegionUnavailable, region)
+	}
+	return client, nil
+}
+
+// RecordClient returns the Redis of the region a notification is stored in, for the
+// data kept alongside it: dead letters, replies and provider payloads
+func (d *DataResidency) RecordClient(notification *models.Notification) (*redis.Client, error) {
+	region := notification.Region
+	if region == "" {
+		region = d.Region(notification)
+	}
+	return d.client(region)
+}
+
+// Records returns the Redis holding a notification and its edit history
+func (d *DataResidency) Records(ctx context.Context, id string) (*redis.Client, error) {
+	if !d.Enabled() {
+		return d.redis.client, nil
+	}
+	region, err := d.redis.client.Get(ctx, notificationRegionKey(id)).Result()
+	if errors.Is(err, redis.Nil) {
+		return d.redis.client, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate notification: %w", err)
+	}
+	return d.client(region)
+}
+
+// StoreRecord writes a regional notification to its region's Redis
+func (d *DataResidency) StoreRecord(ctx context.Context, notification *models.Notification, payload []byte) error {
+	client, err := d.client(notification.Region)
+	if err != nil {
+		return err
+	}
+	if err := client.Set(ctx, notificationKey(notification.ID), payload, 0).Err(); err != nil {
+		return fmt.Errorf("failed to store notification in region %s: %w", notification.Region, err)
+	}
+	return nil
+}
+
+// CacheRecord caches a notification read from its database in its region's Redis,
+// unless a newer copy is already there
+func (d *DataResidency) CacheRecord(ctx context.Context, notification *models.Notification, payload []byte) error {
+	client, err := d.client(notification.Region)
+	if err != nil {
+		return err
+	}
+	if err := client.SetNX(ctx, notificationKey(notification.ID), payload, notificationCacheTTL).Err(); err != nil {
+		return err
+	}
+	if d.Regional(notification.Region) {
+		return d.redis.client.Set(ctx, notificationRegionKey(notification.ID), notification.Region, 0).Err()
+	}
+	return nil
+}
+
+// LoadNotifications reads notifications from the Redis of their regions, in the order
+// of ids, skipping those no longer there
+func (d *DataResidency) LoadNotifications(ctx context.Context, ids []string) ([]*models.Notification, error) {
+	if len(ids) == 0 {
+		return []*models.Notification{}, nil
+	}
+	if !d.Enabled() {
+		return loadNotifications(ctx, d.redis.client, ids)
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = notificationRegionKey(id)
+	}
+	regions, err := d.redis.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate notifications: %w", err)
+	}
+	byRegion := make(map[string][]string)
+	for i, id := range ids {
+		region, _ := regions[i].(string)
+		byRegion[region] = append(byRegion[region], id)
+	}
+
+	loaded := make(map[string]*models.Notification, len(ids))
+	for region, regionIDs := range byRegion {
+		client, err := d.client(region)
+		if err != nil {
+			return nil, err
+		}
+		notifications, err := loadNotifications(ctx, client, regionIDs)
+		if err != nil {
+			return nil, err
+		}
+		for _, notification := range notifications {
+			loaded[notification.ID] = notification
+		}
+	}
+	notifications := make([]*models.Notification, 0, len(loaded))
+	for _, id := range ids {
+		if notification, ok := loaded[id]; ok {
+			notifications = append(notifications, notification)
+		}
+	}
+	return notifications, nil
+}
+
+// Status returns each data region with its tenants and whether its stores answer
+func (d *DataResidency) Status(ctx context.Context) models.DataResidency {
+	tenants := make(map[string][]string)
+	for region := range d.clients {
+		tenants[region] = []string{}
+	}
+	for region := range d.databases {
+		tenants[region] = []string{}
+	}
+	for tenant, region := range d.tenants {
+		if d.Regional(region) {
+			tenants[region] = append(tenants[region], tenant)
+		}
+	}
+
+	status := models.DataResidency{HomeRegion: d.home, Regions: make([]models.RegionResidency, 0, len(tenants))}
+	for region, regionTenants := range tenants {
+		sort.Strings(regionTenants)
+		residency := models.RegionResidency{Region: region, Tenants: regionTenants, Redis: "not_configured", Database: "not_configured"}
+		if client := d.clients[region]; client != nil {
+			residency.Redis = storeStatus(client.Ping(ctx).Err())
+		}
+		if repo, ok := d.databases[region]; ok {
+			if repo == nil {
+				residency.Database = storeStatus(fmt.Errorf("%w: %s", storage.ErrRegionUnavailable, region))
+			} else {
+				residency.Database = storeStatus(repo.Ping(ctx))
+			}
+		}
+		status.Regions = append(status.Regions, residency)
+	}
+	sort.Slice(status.Regions, func(i, j int) bool { return status.Regions[i].Region < status.Regions[j].Region })
+	return status
+}
+
+func storeStatus(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return "ok"
+}
+
+// Close closes the regional stores; the home ones belong to the caller
+func (d *DataResidency) Close() error {
+	var errs []error
+	for _, client := range d.clients {
+		errs = append(errs, client.Close())
+	}
+	if regional, ok := d.repo.(*storage.RegionalNotificationRepository); ok {
+		errs = append(errs, regional.Close())
+	}
+	return errors.Join(errs...)
+}
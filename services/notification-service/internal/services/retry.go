@@ -0,0 +1,408 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"time"
+
+	"notification-service/internal/cache"
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/go-amqp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// retryPoliciesKey holds admin overrides, one JSON policy per channel field
+const retryPoliciesKey = "retry-policies"
+
+// ErrInvalidRetryPolicy is returned for policies that can't be applied
+var ErrInvalidRetryPolicy = errors.New("invalid retry policy")
+
+// Azure throttling: the error code of REST responses, and the AMQP condition Event Hubs
+// reports
+const (
+	azureServerBusy              = "ServerBusy"
+	amqpServerBusy  amqp.ErrCond = "com.microsoft:server-busy"
+)
+
+// classifiedError lets a provider error declare its error class
+type classifiedError interface {
+	ErrorClass() models.ErrorClass
+}
+
+// retryAfterError carries a provider's Retry-After hint
+type retryAfterError interface {
+	RetryAfter() time.Duration
+}
+
+// StatusError is an unsuccessful HTTP response from a provider
+type StatusError struct {
+	StatusCode      int
+	RetryAfterDelay time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("provider returned status %d", e.StatusCode)
+}
+
+func (e *StatusError) ErrorClass() models.ErrorClass {
+	switch {
+	case e.StatusCode == http.StatusTooManyRequests:
+		return models.ErrorClassThrottled
+	case e.StatusCode == http.StatusRequestTimeout, e.StatusCode >= 500:
+		return models.ErrorClassServer
+	default:
+		return models.ErrorClassRejected
+	}
+}
+
+func (e *StatusError) RetryAfter() time.Duration {
+	return e.RetryAfterDelay
+}
+
+// ClassifyError sorts a delivery error into the class retry policies are written against
+func ClassifyError(err error) models.ErrorClass {
+	var classified classifiedError
+	if errors.As(err, &classified) {
+		return classified.ErrorClass()
+	}
+	if errors.Is(err, context.Canceled) {
+		return models.ErrorClassRejected
+	}
+	var azureErr *azcore.ResponseError
+	if errors.As(err, &azureErr) {
+		if azureErr.ErrorCode == azureServerBusy {
+			return models.ErrorClassThrottled
+		}
+		return (&StatusError{StatusCode: azureErr.StatusCode}).ErrorClass()
+	}
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		// SMTP 4xx replies are temporary by definition (greylisting, mailbox busy)
+		if protoErr.Code >= 400 && protoErr.Code < 500 {
+			return models.ErrorClassTransient
+		}
+		return models.ErrorClassRejected
+	}
+	var amqpErr *amqp.Error
+	if errors.As(err, &amqpErr) && amqpErr.Condition == amqpServerBusy {
+		return models.ErrorClassThrottled
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return models.ErrorClassNetwork
+	}
+	return models.ErrorClassUnknown
+}
+
+// retryAfter returns the delay a provider asked for with its error, if any
+func retryAfter(err error) time.Duration {
+	var hinted retryAfterError
+	if errors.As(err, &hinted) {
+		return hinted.RetryAfter()
+	}
+	var azureErr *azcore.ResponseError
+	if errors.As(err, &azureErr) && azureErr.RawResponse != nil {
+		if ms, err := strconv.Atoi(azureErr.RawResponse.Header.Get("Retry-After-Ms")); err == nil && ms > 0 {
+			return time.Duration(ms) * time.Millisecond
+		}
+		return ParseRetryAfter(azureErr.RawResponse.Header.Get("Retry-After"))
+	}
+	return 0
+}
+
+// ParseRetryAfter reads a Retry-After header given in seconds or as an HTTP date
+func ParseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(at); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
+
+// RetryPolicies holds the retry policy for each channel: built-in defaults, overridden by
+// RETRY_POLICIES and then by policies set through the admin API. Throttled errors also
+// slow the whole channel down through the provider throttle, and attempts share the
+// channel across tenants through the fair dispatcher.
+type RetryPolicies struct {
+	redis     *RedisClient
+	throttle  *ProviderThrottle
+	fairness  *FairDispatcher
+	defaults  map[models.NotificationType]models.RetryPolicy
+	overrides *cache.Cache[map[models.NotificationType]models.RetryPolicy]
+}
+
+func NewRetryPolicies(cfg *config.Config, redis *RedisClient, throttle *ProviderThrottle, fairness *FairDispatcher) *RetryPolicies {
+	network := []models.ErrorClass{models.ErrorClassNetwork, models.ErrorClassProxy, models.ErrorClassServer, models.ErrorClassThrottled, models.ErrorClassTransient}
+	defaults := map[models.NotificationType]models.RetryPolicy{
+		models.NotificationTypeEmail: {
+			MaxAttempts: cfg.SMTPMaxRetries + 1, InitialBackoffMs: 1000, MaxBackoffMs: 60000, Multiplier: 2,
+			RetryOn: network, HonorRetryAfter: true,
+		},
+		models.NotificationTypeSMS: {
+			MaxAttempts: 3, InitialBackoffMs: 2000, MaxBackoffMs: 30000, Multiplier: 2, Jitter: 0.2,
+			RetryOn: network, HonorRetryAfter: true,
+		},
+		models.NotificationTypePush: {
+			MaxAttempts: 3, InitialBackoffMs: 1000, MaxBackoffMs: 30000, Multiplier: 2, Jitter: 0.2,
+			RetryOn: network, HonorRetryAfter: true,
+		},
+		models.NotificationTypeWebhook: {
+			MaxAttempts: cfg.WebhookRetries + 1, InitialBackoffMs: 1000, MaxBackoffMs: 60000, Multiplier: 2,
+			RetryOn: network, HonorRetryAfter: true,
+		},
+		models.NotificationTypeTeams: {
+			MaxAttempts: 3, InitialBackoffMs: 1000, MaxBackoffMs: 30000, Multiplier: 2, Jitter: 0.2,
+			RetryOn: network, HonorRetryAfter: true,
+		},
+	}
+
+	if cfg.RetryPolicies != "" {
+		var configured map[models.NotificationType]models.RetryPolicy
+		if err := json.Unmarshal([]byte(cfg.RetryPolicies), &configured); err != nil {
+			slog.Warn("Ignoring RETRY_POLICIES", "error", err)
+		}
+		for channel, policy := range configured {
+			policy.Channel = channel
+			if err := validateRetryPolicy(policy); err != nil {
+				slog.Warn("Ignoring RETRY_POLICIES entry", "notification.channel", channel, "error", err)
+				continue
+			}
+			defaults[channel] = policy
+		}
+	}
+	for channel, policy := range defaults {
+		policy.Channel = channel
+		policy.Source = "config"
+		defaults[channel] = policy
+	}
+
+	return &RetryPolicies{
+		redis:    redis,
+		throttle: throttle,
+		fairness: fairness,
+		defaults: defaults,
+		overrides: cache.New[map[models.NotificationType]models.RetryPolicy](nil, cache.Options{
+			Name:       "retry-policies",
+			Mode:       cache.ReadThrough,
+			L1TTL:      30 * time.Second,
+			L1MaxItems: 1,
+		}),
+	}
+}
+
+// Policy returns a channel's effective policy. Admin overrides reach other replicas
+// within the cache TTL; if they can't be read, the configured policy applies.
+func (r *RetryPolicies) Policy(ctx context.Context, channel models.NotificationType) models.RetryPolicy {
+	overrides, err := r.loadOverrides(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "Using configured retry policy, overrides unavailable", "notification.channel", channel, "error", err)
+	}
+	if policy, ok := overrides[channel]; ok {
+		return policy
+	}
+	if policy, ok := r.defaults[channel]; ok {
+		return policy
+	}
+	return models.RetryPolicy{Channel: channel, MaxAttempts: 1, Multiplier: 1, Source: "config"}
+}
+
+// Policies returns every channel's effective policy
+func (r *RetryPolicies) Policies(ctx context.Context) ([]models.RetryPolicy, error) {
+	if _, err := r.loadOverrides(ctx); err != nil {
+		return nil, err
+	}
+	policies := make([]models.RetryPolicy, 0, len(r.defaults))
+	for channel := range r.defaults {
+		policies = append(policies, r.Policy(ctx, channel))
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].Channel < policies[j].Channel })
+	return policies, nil
+}
+
+// SetPolicy stores an admin override for a channel
+func (r *RetryPolicies) SetPolicy(ctx context.Context, policy models.RetryPolicy) (models.RetryPolicy, error) {
+	if _, ok := r.defaults[policy.Channel]; !ok {
+		return models.RetryPolicy{}, fmt.Errorf("%w: unknown channel %q", ErrInvalidRetryPolicy, policy.Channel)
+	}
+	if err := validateRetryPolicy(policy); err != nil {
+		return models.RetryPolicy{}, err
+	}
+	policy.Source = "admin"
+
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return models.RetryPolicy{}, err
+	}
+	if err := r.redis.client.HSet(ctx, retryPoliciesKey, string(policy.Channel), data).Err(); err != nil {
+		return models.RetryPolicy{}, fmt.Errorf("failed to store retry policy: %w", err)
+	}
+	r.overrides.Delete(ctx, retryPoliciesKey)
+	slog.InfoContext(ctx, "🔁 Retry policy set", "notification.channel", policy.Channel, "retry.max_attempts", policy.MaxAttempts, "retry.on", policy.RetryOn)
+	return policy, nil
+}
+
+// ResetPolicy drops a channel's admin override, restoring the configured policy
+func (r *RetryPolicies) ResetPolicy(ctx context.Context, channel models.NotificationType) error {
+	if _, ok := r.defaults[channel]; !ok {
+		return fmt.Errorf("%w: unknown channel %q", ErrInvalidRetryPolicy, channel)
+	}
+	if err := r.redis.client.HDel(ctx, retryPoliciesKey, string(channel)).Err(); err != nil {
+		return fmt.Errorf("failed to reset retry policy: %w", err)
+	}
+	r.overrides.Delete(ctx, retryPoliciesKey)
+	return nil
+}
+
+func (r *RetryPolicies) loadOverrides(ctx context.Context) (map[models.NotificationType]models.RetryPolicy, error) {
+	return r.overrides.Get(ctx, retryPoliciesKey, func(ctx context.Context) (map[models.NotificationType]models.RetryPolicy, error) {
+		fields, err := r.redis.client.HGetAll(ctx, retryPoliciesKey).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load retry policies: %w", err)
+		}
+		overrides := make(map[models.NotificationType]models.RetryPolicy, len(fields))
+		for channel, data := range fields {
+			var policy models.RetryPolicy
+			if err := json.Unmarshal([]byte(data), &policy); err != nil {
+				slog.WarnContext(ctx, "Skipping unreadable retry policy", "notification.channel", channel, "error", err)
+				continue
+			}
+			overrides[models.NotificationType(channel)] = policy
+		}
+		return overrides, nil
+	})
+}
+
+// Do calls attempt until it succeeds, fails with an error class the channel's policy
+// doesn't retry, or the policy's attempts run out. It returns the attempts made. Each
+// attempt first waits out any throttle on the provider and then for a slot in the
+// tenant fair queue, held only while the attempt runs. A throttled failure
+// throttles the provider for its Retry-After, or the policy's backoff when the
+// provider gave none. A test send makes a single attempt straight away, so the
+// operator sees the provider's answer, and leaves the provider's throttle as it is. A
+// scheduled retry makes a single attempt too: the notification's retry budget already
+// counts it, so the policy's attempts don't multiply with it.
+func (r *RetryPolicies) Do(ctx context.Context, channel models.NotificationType, provider DeliveryProvider, attempt func(attempt int) error) (int, error) {
+	if telemetry.IsTestSend(ctx) {
+		return 1, attempt(1)
+	}
+
+	policy := r.Policy(ctx, channel)
+	if scheduledRetry(ctx) {
+		policy.MaxAttempts = 1
+	}
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.Int("retry.max_attempts", policy.MaxAttempts))
+
+	for n := 1; ; n++ {
+		if _, err := r.throttle.Wait(ctx, provider); err != nil {
+			return n - 1, err
+		}
+		release, err := r.fairness.Acquire(ctx, channel)
+		if err != nil {
+			return n - 1, err
+		}
+		err = attempt(n)
+		release()
+		if err == nil {
+			return n, nil
+		}
+
+		class := ClassifyError(err)
+		if class == models.ErrorClassThrottled {
+			hold := retryAfter(err)
+			if hold <= 0 {
+				hold = backoff(policy, n)
+			}
+			r.throttle.Throttle(ctx, provider, hold, err.Error())
+		}
+		delay, retry := nextDelay(policy, n, class, err)
+		telemetry.RecordDeliveryRetry(ctx, string(channel), string(class), retry)
+		if !retry {
+			span.SetAttributes(attribute.String("error.class", string(class)))
+			return n, err
+		}
+
+		slog.WarnContext(ctx, "Delivery attempt failed, retrying", "notification.channel", channel, "retry.attempt", n, "error.class", class, "retry.delay", delay, "error", err)
+		span.AddEvent(string(channel)+".retry", trace.WithAttributes(
+			attribute.Int("retry.attempt", n),
+			attribute.String("error.class", string(class)),
+			attribute.Int64("retry.delay_ms", delay.Milliseconds()),
+			attribute.String("error.message", err.Error()),
+		))
+		select {
+		case <-ctx.Done():
+			return n, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+func validateRetryPolicy(policy models.RetryPolicy) error {
+	switch {
+	case policy.MaxAttempts < 1 || policy.MaxAttempts > 20:
+		return fmt.Errorf("%w: max_attempts must be between 1 and 20", ErrInvalidRetryPolicy)
+	case policy.InitialBackoffMs < 0 || policy.MaxBackoffMs < policy.InitialBackoffMs:
+		return fmt.Errorf("%w: backoff must satisfy 0 <= initial_backoff_ms <= max_backoff_ms", ErrInvalidRetryPolicy)
+	case policy.Multiplier < 1:
+		return fmt.Errorf("%w: multiplier must be at least 1", ErrInvalidRetryPolicy)
+	case policy.Jitter < 0 || policy.Jitter > 1:
+		return fmt.Errorf("%w: jitter must be between 0 and 1", ErrInvalidRetryPolicy)
+	}
+	for _, class := range policy.RetryOn {
+		if !models.KnownErrorClass(class) {
+			return fmt.Errorf("%w: unknown error class %q", ErrInvalidRetryPolicy, class)
+		}
+	}
+	return nil
+}
+
+// nextDelay decides whether a failed attempt is retried and after how long. When the
+// policy honors Retry-After, a provider's hint replaces the backoff; a hint longer than
+// max_backoff_ms ends the retries rather than retrying before the provider is ready.
+func nextDelay(policy models.RetryPolicy, attempt int, class models.ErrorClass, err error) (time.Duration, bool) {
+	if attempt >= policy.MaxAttempts || !policy.Retries(class) {
+		return 0, false
+	}
+	delay := backoff(policy, attempt)
+	if hint := retryAfter(err); policy.HonorRetryAfter && hint > 0 {
+		if hint > time.Duration(policy.MaxBackoffMs)*time.Millisecond {
+			return 0, false
+		}
+		delay = hint
+	}
+	return delay, true
+}
+
+// backoff returns the randomized exponential delay before retry number attempt
+func backoff(policy models.RetryPolicy, attempt int) time.Duration {
+	delay := float64(policy.InitialBackoffMs) * math.Pow(policy.Multiplier, float64(attempt-1))
+	delay = math.Min(delay, float64(policy.MaxBackoffMs))
+	if policy.Jitter > 0 {
+		delay *= 1 + policy.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(delay) * time.Millisecond
+}
@@ -0,0 +1,294 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/storage"
+	"notification-service/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// retryScheduleKey is the work queue of notifications awaiting a retry
+	retryScheduleKey = "notification-retries"
+	// retryLease is how long a replica that stopped keeps a retry from the others
+	retryLease = time.Minute
+)
+
+// scheduledRetryKey marks a delivery made by the retry scheduler in its context
+type scheduledRetryKey struct{}
+
+// scheduledRetry reports whether ctx carries a scheduled retry's delivery
+func scheduledRetry(ctx context.Context) bool {
+	retry, _ := ctx.Value(scheduledRetryKey{}).(bool)
+	return retry
+}
+
+// RetryOrchestrator drives retries of stored notifications. A failed delivery with retries
+// left moves the notification to retrying and schedules its next attempt after the
+// channel's backoff, scaled for its priority and jittered so retries of a burst spread
+// out. Due retries are picked up by every replica; each is claimed by exactly one under a
+// lease, so a replica stopping mid-retry doesn't lose it, and a replica runs up to
+// RETRY_SCHEDULER_BATCH_SIZE of them at once. A scheduled retry makes a single delivery
+// attempt, so MaxRetries caps the attempts across both layers. No retries are claimed
+// while the service is read-only.
+type RetryOrchestrator struct {
+	queue       *workQueue
+	readOnly    *storage.ReadOnlyFlag
+	policies    *RetryPolicies
+	senders     map[models.NotificationType]ChannelSender
+	preferences *CustomerPreferenceService
+	blackouts   *BlackoutCalendars
+	priorities  map[models.Priority]models.PriorityRetryPolicy
+	interval    time.Duration
+	batch       int64
+	jitter      float64
+}
+
+func NewRetryOrchestrator(cfg *config.Config, redis *RedisClient, policies *RetryPolicies, senders map[models.NotificationType]ChannelSender, preferences *CustomerPreferenceService, blackouts *BlackoutCalendars) *RetryOrchestrator {
+	priorities := map[models.Priority]models.PriorityRetryPolicy{
+		models.PriorityUrgent: {MaxRetries: 5, BackoffScale: 0.5},
+		models.PriorityHigh:   {MaxRetries: 4, BackoffScale: 0.75},
+		models.PriorityNormal: {MaxRetries: 3, BackoffScale: 1},
+		models.PriorityLow:    {MaxRetries: 2, BackoffScale: 2},
+	}
+	if cfg.RetryPriorityPolicies != "" {
+		var configured map[models.Priority]models.PriorityRetryPolicy
+		if err := json.Unmarshal([]byte(cfg.RetryPriorityPolicies), &configured); err != nil {
+			slog.Warn("Ignoring RETRY_PRIORITY_POLICIES", "error", err)
+		}
+		for priority, policy := range configured {
+			if _, ok := priorities[priority]; !ok {
+				slog.Warn("Ignoring RETRY_PRIORITY_POLICIES entry for unknown priority", "notification.priority", priority)
+				continue
+			}
+			if policy.MaxRetries < 0 || policy.MaxRetries > 20 || policy.BackoffScale <= 0 {
+				slog.Warn("Ignoring RETRY_PRIORITY_POLICIES entry: max_retries must be 0-20 and backoff_scale positive", "notification.priority", priority)
+				continue
+			}
+			priorities[priority] = policy
+		}
+	}
+	for priority, policy := range priorities {
+		policy.Priority = priority
+		priorities[priority] = policy
+	}
+
+	return &RetryOrchestrator{
+		queue:       newWorkQueue(redis, retryScheduleKey, retryLease),
+		readOnly:    redis.ReadOnly(),
+		policies:    policies,
+		senders:     senders,
+		preferences: preferences,
+		blackouts:   blackouts,
+		priorities:  priorities,
+		interval:    time.Duration(max(cfg.RetrySchedulerIntervalMs, 100)) * time.Millisecond,
+		batch:       int64(max(cfg.RetrySchedulerBatchSize, 1)),
+		jitter:      min(max(cfg.RetryJitter, 0), 1),
+	}
+}
+
+// PriorityPolicy returns the retry policy for a priority; unknown priorities retry as normal
+func (r *RetryOrchestrator) PriorityPolicy(priority models.Priority) models.PriorityRetryPolicy {
+	if policy, ok := r.priorities[priority]; ok {
+		return policy
+	}
+	return r.priorities[models.PriorityNormal]
+}
+
+// PriorityPolicies returns every priority's retry policy
+func (r *RetryOrchestrator) PriorityPolicies() []models.PriorityRetryPolicy {
+	policies := make([]models.PriorityRetryPolicy, 0, len(r.priorities))
+	for _, policy := range r.priorities {
+		policies = append(policies, policy)
+	}
+	sort.Slice(policies, func(i, j int) bool { return policies[i].Priority < policies[j].Priority })
+	return policies
+}
+
+// MaxRetries is the retry budget new notifications of a priority get
+func (r *RetryOrchestrator) MaxRetries(priority models.Priority) int {
+	return r.PriorityPolicy(priority).MaxRetries
+}
+
+// Retryable reports whether a failed notification gets another retry: it has budget left
+// and the channel's policy retries the error class, when the class is known
+func (r *RetryOrchestrator) Retryable(ctx context.Context, notification *models.Notification, class models.ErrorClass) bool {
+	if notification.RetryCount >= notification.MaxRetries {
+		return false
+	}
+	return class == "" || r.policies.Policy(ctx, notification.Type).Retries(class)
+}
+
+// Delay is the wait before a retrying notification's next attempt: the channel's backoff
+// for its retry count, scaled for its priority, with at least RETRY_JITTER jitter
+func (r *RetryOrchestrator) Delay(ctx context.Context, notification *models.Notification) time.Duration {
+	policy := r.policies.Policy(ctx, notification.Type)
+	policy.Jitter = max(policy.Jitter, r.jitter)
+	delay := backoff(policy, max(notification.RetryCount, 1))
+	return time.Duration(float64(delay) * r.PriorityPolicy(notification.Priority).BackoffScale)
+}
+
+// Schedule queues a retrying notification's next attempt and returns when it is due
+func (r *RetryOrchestrator) Schedule(ctx context.Context, notification *models.Notification) (time.Time, error) {
+	delay := r.Delay(ctx, notification)
+	due := time.Now().UTC().Add(delay)
+	if err := r.queue.Add(ctx, r.queue.redis.client, notification.ID, due).Err(); err != nil {
+		return time.Time{}, err
+	}
+
+	trace.SpanFromContext(ctx).AddEvent("notification.retry.scheduled", trace.WithAttributes(
+		attribute.String("notification.id", notification.ID),
+		attribute.Int("retry.count", notification.RetryCount),
+		attribute.Int("retry.max", notification.MaxRetries),
+		attribute.Int64("retry.delay_ms", delay.Milliseconds()),
+	))
+	telemetry.RecordRetryScheduled(ctx, string(notification.Type), string(notification.Priority))
+	slog.InfoContext(ctx, "🔁 Retry scheduled", "notification.id", notification.ID, "notification.channel", notification.Type,
+		"retry.count", notification.RetryCount, "retry.max", notification.MaxRetries, "retry.delay", delay.Round(time.Millisecond))
+	return due, nil
+}
+
+// Unschedule drops a notification's queued retry, such as when it is cancelled
+func (r *RetryOrchestrator) Unschedule(ctx context.Context, id string) error {
+	return r.queue.Remove(ctx, id)
+}
+
+// Start runs due retries every RETRY_SCHEDULER_INTERVAL_MS until ctx is cancelled,
+// reporting each outcome through notifications so failures are rescheduled or
+// dead-lettered
+func (r *RetryOrchestrator) Start(ctx context.Context, notifications NotificationManager) {
+	slots := make(chan struct{}, r.batch)
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				free := r.batch - int64(len(slots))
+				if free == 0 || r.readOnly.IsReadOnly(ctx) {
+					continue
+				}
+				ids, err := r.queue.Claim(ctx, free)
+				if err != nil {
+					slog.WarnContext(ctx, "Failed to claim due retries", "error", err)
+					continue
+				}
+				for _, id := range ids {
+					slots <- struct{}{}
+					go func() {
+						defer func() { <-slots }()
+						r.runClaimed(ctx, notifications, id)
+					}()
+				}
+			}
+		}
+	}()
+}
+
+// runClaimed holds a claimed retry's lease while it runs and ends it once the outcome
+// is recorded, or queues the retry again when it couldn't run
+func (r *RetryOrchestrator) runClaimed(ctx context.Context, notifications NotificationManager, id string) {
+	release := r.queue.Hold(ctx, id)
+	defer release()
+
+	due, err := r.retry(ctx, notifications, id)
+	if err != nil {
+		slog.WarnContext(ctx, "Retry of notification didn't run, requeueing", "notification.id", id, "error", err)
+	}
+	if !due.IsZero() {
+		err = r.queue.Retry(ctx, id, due)
+	} else {
+		err = r.queue.Done(ctx, id)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to release retry of notification", "notification.id", id, "error", err)
+	}
+}
+
+// retry makes one scheduled delivery attempt and reports its outcome. It returns when
+// the retry is due again if it is to be put back, or the zero time when it is finished.
+func (r *RetryOrchestrator) retry(ctx context.Context, notifications NotificationManager, id string) (time.Time, error) {
+	ctx, span := telemetry.Tracer.Start(ctx, "notification.retry",
+		trace.WithAttributes(attribute.String("notification.id", id)),
+	)
+	defer span.End()
+
+	notification, err := notifications.GetNotification(ctx, id)
+	if errors.Is(err, ErrNotificationNotFound) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Now().Add(r.interval), err
+	}
+	// Cancelled or resolved since the retry was scheduled
+	if notification.Status != models.NotificationStatusRetrying {
+		return time.Time{}, nil
+	}
+	span.SetAttributes(
+		attribute.String("notification.channel", string(notification.Type)),
+		attribute.String("notification.priority", string(notification.Priority)),
+		attribute.Int("retry.count", notification.RetryCount),
+	)
+
+	// Preferences and blackout calendars are checked again at each attempt; a retry held
+	// for quiet hours or a blackout doesn't use up the retry budget
+	now := time.Now().UTC()
+	decision := r.preferences.Check(ctx, notification, now)
+	if decision.Action != models.PreferenceActionSuppress {
+		if until := r.blackouts.Hold(ctx, notification, now); until != nil {
+			r.blackouts.RecordDeferral(ctx, notification)
+			return *until, nil
+		}
+	}
+	if decision.Action == models.PreferenceActionDefer {
+		telemetry.RecordNotificationDeferred(ctx, string(notification.Type))
+		return *decision.Until, nil
+	}
+
+	req := models.UpdateNotificationStatusRequest{Status: models.NotificationStatusSent}
+	sender, ok := r.senders[notification.Type]
+	if decision.Action == models.PreferenceActionSuppress {
+		req = models.UpdateNotificationStatusRequest{
+			Status:       models.NotificationStatusSuppressed,
+			ErrorMessage: "suppressed by customer preferences: " + decision.Reason,
+		}
+		telemetry.RecordNotificationSuppressed(ctx, string(notification.Type), decision.Reason)
+	} else if !ok {
+		req = models.UpdateNotificationStatusRequest{
+			Status:       models.NotificationStatusFailed,
+			ErrorMessage: "notifications can't be retried on channel " + string(notification.Type),
+			ErrorClass:   models.ErrorClassRejected,
+		}
+	} else if err := sender.Send(context.WithValue(ctx, scheduledRetryKey{}, true), notification); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Retry failed")
+		req = models.UpdateNotificationStatusRequest{
+			Status:       models.NotificationStatusRetrying,
+			ErrorMessage: err.Error(),
+			ErrorClass:   ClassifyError(err),
+		}
+	} else if notification.Status == models.NotificationStatusDelivered {
+		req.Status = models.NotificationStatusDelivered
+	}
+
+	// A recorded failure schedules the next retry itself. One that couldn't be recorded
+	// leaves the notification retrying, so this retry is put back for another attempt.
+	if _, err := notifications.UpdateNotificationStatus(ctx, id, req); err != nil {
+		return time.Now().Add(r.Delay(ctx, notification)), fmt.Errorf("failed to record retry: %w", err)
+	}
+	return time.Time{}, nil
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+)
+
+// Routing policies for dispatching order notifications
+const (
+	// RoutingWebSocket sends to WebSocket clients only, whether or not any are connected
+	RoutingWebSocket = "websocket"
+	// RoutingOnlineElseFallback sends over WebSocket when the customer is online, and
+	// otherwise waits and then falls back to the configured channels
+	RoutingOnlineElseFallback = "online_else_fallback"
+)
+
+// RoutingPolicy decides how dispatched notifications reach the customer
+type RoutingPolicy struct {
+	Name             string
+	FallbackWait     time.Duration
+	FallbackChannels []models.NotificationType
+}
+
+// NewRoutingPolicy builds the policy from configuration, defaulting to WebSocket-only
+// for unknown policy names
+func NewRoutingPolicy(cfg *config.Config) RoutingPolicy {
+	policy := RoutingPolicy{
+		Name:         cfg.RoutingPolicy,
+		FallbackWait: time.Duration(cfg.RoutingFallbackWaitMs) * time.Millisecond,
+	}
+	if policy.Name != RoutingWebSocket && policy.Name != RoutingOnlineElseFallback {
+		slog.Warn("Unknown ROUTING_POLICY, using "+RoutingWebSocket, "routing_policy", policy.Name)
+		policy.Name = RoutingWebSocket
+	}
+
+	for _, channel := range strings.Split(cfg.RoutingFallbackChannels, ",") {
+		switch channelType := models.NotificationType(strings.TrimSpace(channel)); channelType {
+		case models.NotificationTypePush, models.NotificationTypeEmail, models.NotificationTypeSMS, models.NotificationTypeWebhook:
+			policy.FallbackChannels = append(policy.FallbackChannels, channelType)
+		case "":
+		default:
+			slog.Warn("Ignoring unknown fallback channel", "notification.channel", channel)
+		}
+	}
+	return policy
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/storage"
+	"notification-service/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// scheduledQueue is the work queue of pending notifications waiting for their
+	// scheduled_at
+	scheduledQueue = "scheduled-notifications"
+	// scheduledLease is how long a replica that stopped keeps a due notification from
+	// the others
+	scheduledLease = time.Minute
+	// scheduledPoll is how often each replica looks for due notifications
+	scheduledPoll = time.Second
+)
+
+// ScheduledDispatcher sends pending notifications once their scheduled_at comes: those
+// scheduled by their producer, in the recipient's local time, or deferred past quiet
+// hours. Each due notification is claimed by one replica under a lease and sent on one
+// of SCHEDULED_DISPATCH_WORKERS workers per replica. A local schedule is resolved again
+// in the customer's time zone, and preferences and blackout calendars are checked again,
+// when it comes due. Due notifications wait while the service is read-only.
+type ScheduledDispatcher struct {
+	queue       *workQueue
+	readOnly    *storage.ReadOnlyFlag
+	hub         RealtimeHub
+	senders     map[models.NotificationType]ChannelSender
+	preferences *CustomerPreferenceService
+	blackouts   *BlackoutCalendars
+	workers     int
+}
+
+func NewScheduledDispatcher(cfg *config.Config, redis *RedisClient, hub RealtimeHub, senders map[models.NotificationType]ChannelSender, preferences *CustomerPreferenceService, blackouts *BlackoutCalendars) *ScheduledDispatcher {
+	return &ScheduledDispatcher{
+		queue:       newWorkQueue(redis, scheduledQueue, scheduledLease),
+		readOnly:    redis.ReadOnly(),
+		hub:         hub,
+		senders:     senders,
+		preferences: preferences,
+		blackouts:   blackouts,
+		workers:     max(cfg.ScheduledDispatchWorkers, 1),
+	}
+}
+
+// Start sends due notifications until ctx is cancelled, reporting each outcome through
+// notifications so failures are retried or dead-lettered
+func (d *ScheduledDispatcher) Start(ctx context.Context, notifications NotificationManager) {
+	slots := make(chan struct{}, d.workers)
+	go func() {
+		ticker := time.NewTicker(scheduledPoll)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				free := d.workers - len(slots)
+				if free == 0 || d.readOnly.IsReadOnly(ctx) {
+					continue
+				}
+				ids, err := d.queue.Claim(ctx, int64(free))
+				if err != nil {
+					slog.WarnContext(ctx, "Failed to claim scheduled notifications", "error", err)
+					continue
+				}
+				for _, id := range ids {
+					slots <- struct{}{}
+					go func() {
+						defer func() { <-slots }()
+						d.runClaimed(ctx, notifications, id)
+					}()
+				}
+			}
+		}
+	}()
+}
+
+// runClaimed holds a claimed notification's lease while it is dispatched, then ends it,
+// or queues the notification again for when it is due
+func (d *ScheduledDispatcher) runClaimed(ctx context.Context, notifications NotificationManager, id string) {
+	release := d.queue.Hold(ctx, id)
+	defer release()
+
+	due, err := d.dispatch(ctx, notifications, id)
+	if err != nil {
+		slog.WarnContext(ctx, "Scheduled notification wasn't dispatched, requeueing", "notification.id", id, "error", err)
+	}
+	if !due.IsZero() {
+		err = d.queue.Retry(ctx, id, due)
+	} else {
+		err = d.queue.Done(ctx, id)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to release scheduled notification", "notification.id", id, "error", err)
+	}
+}
+
+// dispatch sends a due notification and reports its outcome. It returns when the
+// notification is due again if it is to be put back, or the zero time when it is
+// finished. A notification edited, cancelled or sent since it was queued is dropped; one
+// whose new scheduled_at is later was queued again by the edit.
+func (d *ScheduledDispatcher) dispatch(ctx context.Context, notifications NotificationManager, id string) (time.Time, error) {
+	ctx, span := telemetry.Tracer.Start(ctx, "notification.dispatch",
+		trace.WithAttributes(attribute.String("notification.id", id)),
+	)
+	defer span.End()
+
+	notification, err := notifications.GetNotification(ctx, id)
+	if errors.Is(err, ErrNotificationNotFound) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Now().Add(scheduledPoll), err
+	}
+	if notification.Status != models.NotificationStatusPending || notification.ScheduledAt == nil {
+		return time.Time{}, nil
+	}
+	span.SetAttributes(
+		attribute.String("notification.channel", string(notification.Type)),
+		attribute.String("notification.priority", string(notification.Priority)),
+	)
+
+	now := time.Now().UTC()
+	if due := d.preferences.ResolveLocal(ctx, notification); due.After(now) {
+		span.SetAttributes(attribute.String("notification.scheduled_at", due.Format(time.RFC3339)))
+		return due, nil
+	}
+	decision := d.preferences.Check(ctx, notification, now)
+	if decision.Action != models.PreferenceActionSuppress {
+		if until := d.blackouts.Hold(ctx, notification, now); until != nil {
+			d.blackouts.RecordDeferral(ctx, notification)
+			return *until, nil
+		}
+	}
+	if decision.Action == models.PreferenceActionDefer {
+		telemetry.RecordNotificationDeferred(ctx, string(notification.Type))
+		return *decision.Until, nil
+	}
+
+	req := models.UpdateNotificationStatusRequest{Status: models.NotificationStatusSent}
+	if decision.Action == models.PreferenceActionSuppress {
+		req = models.UpdateNotificationStatusRequest{
+			Status:       models.NotificationStatusSuppressed,
+			ErrorMessage: "suppressed by customer preferences: " + decision.Reason,
+		}
+		telemetry.RecordNotificationSuppressed(ctx, string(notification.Type), decision.Reason)
+	} else if err := d.send(ctx, notification); errors.Is(err, ErrChannelNotConfigured) {
+		req = models.UpdateNotificationStatusRequest{
+			Status:       models.NotificationStatusFailed,
+			ErrorMessage: err.Error(),
+			ErrorClass:   models.ErrorClassRejected,
+		}
+	} else if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Dispatch failed")
+		req = models.UpdateNotificationStatusRequest{
+			Status:       models.NotificationStatusRetrying,
+			ErrorMessage: err.Error(),
+			ErrorClass:   ClassifyError(err),
+		}
+	}
+
+	// The send can't be taken back, so a report that can't be recorded isn't followed
+	// by another send
+	if _, err := notifications.UpdateNotificationStatus(ctx, id, req); err != nil {
+		slog.ErrorContext(ctx, "Failed to record scheduled dispatch", "notification.id", id, "notification.status", req.Status, "error", err)
+	}
+	return time.Time{}, nil
+}
+
+func (d *ScheduledDispatcher) send(ctx context.Context, notification *models.Notification) error {
+	if notification.Type == models.NotificationTypeWebSocket {
+		return d.hub.SendToCustomer(ctx, notification.CustomerID, map[string]interface{}{
+			"id":      notification.ID,
+			"type":    "notification",
+			"subject": notification.Subject,
+			"message": notification.Message,
+			"data":    notification.Data,
+		})
+	}
+	sender, ok := d.senders[notification.Type]
+	if !ok {
+		return fmt.Errorf("%s: %w", notification.Type, ErrChannelNotConfigured)
+	}
+	return sender.Send(ctx, notification)
+}
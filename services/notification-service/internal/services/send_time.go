@@ -0,0 +1,249 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"notification-service/internal/cache"
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/storage"
+	"notification-service/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// sendTimeStatsKey holds the experiment counters: assigned:<variant>, delayed:<variant>
+// and engaged:<variant>:<type>
+const sendTimeStatsKey = "send-time-stats"
+
+// Notification metadata written by send-time optimization
+const (
+	SendTimeVariantMetadata = "send_time_variant"
+	SendTimeHourMetadata    = "send_time_hour"
+)
+
+// sendTimeVariantKey remembers a notification's variant so engagements can be credited to it
+func sendTimeVariantKey(notificationID string) string {
+	return "send-time-variant:" + notificationID
+}
+
+// sendTimeEngagedKey holds the engagement types already counted for a notification
+func sendTimeEngagedKey(notificationID string) string {
+	return "send-time-engaged:" + notificationID
+}
+
+// SendTimeOptimizer holds non-urgent notifications until the hour their customer has
+// engaged with most over SEND_TIME_LOOKBACK_DAYS, at most SEND_TIME_MAX_DELAY_HOURS
+// away. SEND_TIME_HOLDOUT_PERCENT of customers form a control group sent immediately,
+// and engagements are credited to each notification's variant to compare the two.
+type SendTimeOptimizer struct {
+	enabled   bool
+	redis     *RedisClient
+	repo      storage.EngagementRepository
+	lookback  time.Duration
+	minEvents int64
+	maxDelay  int
+	holdout   uint32
+	profiles  *cache.Cache[models.SendTimeProfile]
+}
+
+func NewSendTimeOptimizer(cfg *config.Config, redisClient *RedisClient, repo storage.EngagementRepository) *SendTimeOptimizer {
+	maxDelay := min(max(cfg.SendTimeMaxDelayHours, 0), 23)
+	return &SendTimeOptimizer{
+		enabled:   cfg.SendTimeOptimization,
+		redis:     redisClient,
+		repo:      repo,
+		lookback:  time.Duration(cfg.SendTimeLookbackDays) * 24 * time.Hour,
+		minEvents: int64(cfg.SendTimeMinEvents),
+		maxDelay:  maxDelay,
+		holdout:   uint32(min(max(cfg.SendTimeHoldoutPercent, 0), 100)),
+		profiles: cache.New[models.SendTimeProfile](redisClient.client, cache.Options{
+			Name:       "send-time-profiles",
+			Mode:       cache.ReadThrough,
+			L1TTL:      time.Hour,
+			L1MaxItems: 10000,
+			L2TTL:      6 * time.Hour,
+		}),
+	}
+}
+
+// Schedule assigns a new notification to a send-time variant and, in the optimized
+// variant, sets ScheduledAt to its customer's best hour. Urgent and high-priority
+// notifications, notifications already scheduled or blocked, and requests with
+// optimize_send_time false are left alone. The assignment is counted by Record once the
+// notification is saved.
+func (o *SendTimeOptimizer) Schedule(ctx context.Context, notification *models.Notification, optimize *bool) {
+	// Only set here, so Record never counts a variant a client put in the metadata
+	delete(notification.Metadata, SendTimeVariantMetadata)
+	delete(notification.Metadata, SendTimeHourMetadata)
+	if !o.enabled || (optimize != nil && !*optimize) || notification.CustomerID == "" || notification.ScheduledAt != nil {
+		return
+	}
+	switch notification.Priority {
+	case models.PriorityHigh, models.PriorityUrgent:
+		return
+	}
+	if notification.Status == models.NotificationStatusBlocked {
+		return
+	}
+
+	variant := o.variant(notification.CustomerID)
+	now := time.Now().UTC()
+	if variant == models.SendTimeOptimized {
+		profile, err := o.Profile(ctx, notification.CustomerID)
+		if err != nil {
+			slog.WarnContext(ctx, "Send-time profile unavailable, sending immediately", "customer.id", notification.CustomerID, "error", err)
+		} else if sendAt, ok := o.nextSendTime(profile, now); ok && sendAt.After(now) {
+			notification.ScheduledAt = &sendAt
+			notification.Metadata[SendTimeHourMetadata] = sendAt.Hour()
+		}
+	}
+	notification.Metadata[SendTimeVariantMetadata] = string(variant)
+}
+
+// Record counts a saved notification's send-time assignment and remembers its variant
+// for crediting engagements. Notifications Schedule left alone aren't counted.
+func (o *SendTimeOptimizer) Record(ctx context.Context, notification *models.Notification) {
+	variantName, _ := notification.Metadata[SendTimeVariantMetadata].(string)
+	if !o.enabled || variantName == "" {
+		return
+	}
+	variant := models.SendTimeVariant(variantName)
+	var delay time.Duration
+	if _, held := notification.Metadata[SendTimeHourMetadata]; held && notification.ScheduledAt != nil {
+		delay = max(notification.ScheduledAt.Sub(notification.CreatedAt), 0)
+	}
+
+	pipe := o.redis.client.TxPipeline()
+	pipe.Set(ctx, sendTimeVariantKey(notification.ID), string(variant), o.lookback)
+	pipe.HIncrBy(ctx, sendTimeStatsKey, "assigned:"+string(variant), 1)
+	if delay > 0 {
+		pipe.HIncrBy(ctx, sendTimeStatsKey, "delayed:"+string(variant), 1)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.WarnContext(ctx, "Failed to record send-time variant", "notification.id", notification.ID, "error", err)
+	}
+
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("send_time.variant", string(variant)),
+		attribute.Float64("send_time.delay_seconds", delay.Seconds()),
+	)
+	telemetry.RecordSendTimeAssignment(ctx, string(variant), delay > 0, delay.Seconds())
+}
+
+// variant puts SEND_TIME_HOLDOUT_PERCENT of customers in the control group. The split
+// hashes the customer ID, so a customer stays in the same group.
+func (o *SendTimeOptimizer) variant(customerID string) models.SendTimeVariant {
+	h := fnv.New32a()
+	h.Write([]byte(customerID))
+	if h.Sum32()%100 < o.holdout {
+		return models.SendTimeControl
+	}
+	return models.SendTimeOptimized
+}
+
+// nextSendTime is the start of the most engaged hour reachable within the maximum
+// delay, or now when that is the current hour. Ties go to the sooner hour.
+func (o *SendTimeOptimizer) nextSendTime(profile models.SendTimeProfile, now time.Time) (time.Time, bool) {
+	if profile.BestHour < 0 {
+		return time.Time{}, false
+	}
+	best, bestCount := 0, int64(-1)
+	for offset := 0; offset <= o.maxDelay; offset++ {
+		if count := profile.Hours[(now.Hour()+offset)%24]; count > bestCount {
+			best, bestCount = offset, count
+		}
+	}
+	if best == 0 {
+		return now, true
+	}
+	return now.Truncate(time.Hour).Add(time.Duration(best) * time.Hour), true
+}
+
+// Profile returns the customer's hourly engagement, cached for an hour
+func (o *SendTimeOptimizer) Profile(ctx context.Context, customerID string) (models.SendTimeProfile, error) {
+	if o.repo == nil {
+		return models.SendTimeProfile{}, ErrStorageUnavailable
+	}
+	return o.profiles.Get(ctx, customerID, func(ctx context.Context) (models.SendTimeProfile, error) {
+		now := time.Now().UTC()
+		hours, err := o.repo.EngagementHours(ctx, storage.EngagementFilter{
+			CustomerID: customerID,
+			From:       now.Add(-o.lookback),
+		})
+		if err != nil {
+			return models.SendTimeProfile{}, err
+		}
+
+		profile := models.SendTimeProfile{CustomerID: customerID, Hours: hours, BestHour: -1, ComputedAt: now}
+		for hour, count := range hours {
+			profile.Events += count
+			if count > 0 && (profile.BestHour < 0 || count > hours[profile.BestHour]) {
+				profile.BestHour = hour
+			}
+		}
+		if profile.Events < o.minEvents {
+			profile.BestHour = -1
+		}
+		return profile, nil
+	})
+}
+
+// RecordEngagement credits an engagement to the variant of its notification, once per
+// engagement type. Notifications sent outside the experiment are ignored.
+func (o *SendTimeOptimizer) RecordEngagement(ctx context.Context, event *models.EngagementEvent) {
+	variant, err := o.redis.client.Get(ctx, sendTimeVariantKey(event.NotificationID)).Result()
+	if err != nil {
+		return
+	}
+	first, err := o.redis.client.SAdd(ctx, sendTimeEngagedKey(event.NotificationID), string(event.Type)).Result()
+	if err != nil || first == 0 {
+		return
+	}
+	o.redis.client.Expire(ctx, sendTimeEngagedKey(event.NotificationID), o.lookback)
+	if err := o.redis.client.HIncrBy(ctx, sendTimeStatsKey, fmt.Sprintf("engaged:%s:%s", variant, event.Type), 1).Err(); err != nil {
+		slog.WarnContext(ctx, "Failed to record send-time engagement", "notification.id", event.NotificationID, "error", err)
+	}
+	telemetry.RecordSendTimeEngagement(ctx, variant, string(event.Type))
+}
+
+// Stats compares the optimized and control variants
+func (o *SendTimeOptimizer) Stats(ctx context.Context) ([]models.SendTimeVariantStats, error) {
+	counters, err := o.redis.client.HGetAll(ctx, sendTimeStatsKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read send-time stats: %w", err)
+	}
+
+	stats := []models.SendTimeVariantStats{
+		{Variant: models.SendTimeOptimized},
+		{Variant: models.SendTimeControl},
+	}
+	for i := range stats {
+		variant := string(stats[i].Variant)
+		stats[i].Assigned, _ = strconv.ParseInt(counters["assigned:"+variant], 10, 64)
+		stats[i].Delayed, _ = strconv.ParseInt(counters["delayed:"+variant], 10, 64)
+		stats[i].Engaged = map[models.EngagementEventType]int64{}
+		stats[i].EngagementRate = map[models.EngagementEventType]float64{}
+
+		prefix := "engaged:" + variant + ":"
+		for field, value := range counters {
+			if !strings.HasPrefix(field, prefix) {
+				continue
+			}
+			engagementType := models.EngagementEventType(strings.TrimPrefix(field, prefix))
+			count, _ := strconv.ParseInt(value, 10, 64)
+			stats[i].Engaged[engagementType] = count
+			if stats[i].Assigned > 0 {
+				stats[i].EngagementRate[engagementType] = float64(count) / float64(stats[i].Assigned)
+			}
+		}
+	}
+	return stats, nil
+}
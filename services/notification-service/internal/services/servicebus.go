@@ -0,0 +1,212 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/telemetry"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/messaging/azservicebus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ServiceBusService consumes order events that upstream services publish to a Service Bus
+// queue or topic subscription rather than Event Hub. Events go through the same handler
+// as Event Hub events.
+type ServiceBusService struct {
+	connectionString string
+	queueName        string
+	topicName        string
+	subscriptionName string
+	maxMessages      int
+
+	mutex     sync.Mutex
+	client    *azservicebus.Client
+	receiver  *azservicebus.Receiver
+	runCancel context.CancelFunc
+
+	// running tracks the receive loop, so Close can let it settle the batch it is handling
+	running sync.WaitGroup
+}
+
+func NewServiceBusService(cfg *config.Config) *ServiceBusService {
+	maxMessages := cfg.ServiceBusMaxMessages
+	if maxMessages <= 0 {
+		maxMessages = 10
+	}
+	return &ServiceBusService{
+		connectionString: cfg.ServiceBusConnectionString,
+		queueName:        cfg.ServiceBusQueueName,
+		topicName:        cfg.ServiceBusTopicName,
+		subscriptionName: cfg.ServiceBusSubscriptionName,
+		maxMessages:      maxMessages,
+	}
+}
+
+// entity names the queue or topic subscription messages are received from
+func (s *ServiceBusService) entity() string {
+	if s.queueName != "" {
+		return s.queueName
+	}
+	return s.topicName + "/subscriptions/" + s.subscriptionName
+}
+
+// StartProcessing receives messages until ctx ends. A message is completed once the
+// handler succeeds and abandoned when it fails, so Service Bus redelivers it and moves it
+// to the dead-letter queue after the entity's max delivery count.
+func (s *ServiceBusService) StartProcessing(ctx context.Context, handler EventHandler) error {
+	if s.connectionString == "" {
+		slog.InfoContext(ctx, "Service Bus connection string not configured, skipping Service Bus processing")
+		return nil
+	}
+	if s.queueName == "" && (s.topicName == "" || s.subscriptionName == "") {
+		return errors.New("service bus requires SERVICE_BUS_QUEUE_NAME or SERVICE_BUS_TOPIC_NAME with SERVICE_BUS_SUBSCRIPTION_NAME")
+	}
+
+	receiver, runCtx, err := s.connect(ctx)
+	if err != nil {
+		return err
+	}
+	defer s.running.Done()
+	slog.InfoContext(ctx, "✓ Service Bus receiver started", "messaging.destination", s.entity())
+
+	// A received batch is handled and settled to the end, even once shutdown has begun,
+	// rather than left locked until Service Bus redelivers it
+	batchCtx := context.WithoutCancel(runCtx)
+	for {
+		receiveCtx, cancel := context.WithTimeout(runCtx, 30*time.Second)
+		messages, err := receiver.ReceiveMessages(receiveCtx, s.maxMessages, nil)
+		cancel()
+		for _, message := range messages {
+			s.processMessage(batchCtx, receiver, message, handler)
+		}
+		if runCtx.Err() != nil {
+			slog.InfoContext(ctx, "Context cancelled, stopping Service Bus processing", "messaging.destination", s.entity())
+			return nil
+		}
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			slog.ErrorContext(ctx, "Failed to receive messages from Service Bus", "messaging.destination", s.entity(), "error", err)
+			select {
+			case <-runCtx.Done():
+				return nil
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+	}
+}
+
+// connect creates the client and the receiver for the configured entity
+func (s *ServiceBusService) connect(ctx context.Context) (*azservicebus.Receiver, context.Context, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	client, err := azservicebus.NewClientFromConnectionString(s.connectionString, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create Service Bus client: %w", err)
+	}
+
+	var receiver *azservicebus.Receiver
+	if s.queueName != "" {
+		receiver, err = client.NewReceiverForQueue(s.queueName, nil)
+	} else {
+		receiver, err = client.NewReceiverForSubscription(s.topicName, s.subscriptionName, nil)
+	}
+	if err != nil {
+		client.Close(ctx)
+		return nil, nil, fmt.Errorf("failed to create Service Bus receiver for %s: %w", s.entity(), err)
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	s.running.Add(1)
+	s.client = client
+	s.receiver = receiver
+	s.runCancel = cancel
+	return receiver, runCtx, nil
+}
+
+// processMessage runs one message through the handler inside a servicebus.receive span
+// that continues the publisher's trace, then settles it
+func (s *ServiceBusService) processMessage(ctx context.Context, receiver *azservicebus.Receiver, message *azservicebus.ReceivedMessage, handler EventHandler) {
+	if len(message.Body) == 0 {
+		if err := receiver.CompleteMessage(ctx, message, nil); err != nil {
+			slog.ErrorContext(ctx, "Failed to complete empty Service Bus message", "messaging.message_id", message.MessageID, "error", err)
+		}
+		return
+	}
+
+	slog.DebugContext(ctx, "Received message from Service Bus", "messaging.destination", s.entity(), "bytes", len(message.Body))
+
+	messageCtx := extractTraceContext(ctx, message.ApplicationProperties)
+	upstreamSpanContext := trace.SpanContextFromContext(messageCtx)
+
+	spanCtx, span := telemetry.Tracer.Start(messageCtx, "servicebus.receive",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "servicebus"),
+			attribute.String("messaging.destination", s.entity()),
+			attribute.String("messaging.operation", "receive"),
+			attribute.String("messaging.message_id", message.MessageID),
+			attribute.Int("messaging.servicebus.delivery_count", int(message.DeliveryCount)),
+		),
+	)
+	defer span.End()
+
+	// Set Azure Monitor correlation attributes so Application Map connects the publisher
+	if upstreamSpanContext.IsValid() {
+		span.SetAttributes(
+			attribute.String("ai.operation.id", upstreamSpanContext.TraceID().String()),
+			attribute.String("ai.operation.parentId", upstreamSpanContext.SpanID().String()),
+		)
+	}
+
+	if err := handler(context.WithValue(spanCtx, messageIDKey{}, message.MessageID), message.Body); err != nil {
+		slog.ErrorContext(spanCtx, "Handler failed for Service Bus message", "messaging.message_id", message.MessageID, "messaging.servicebus.delivery_count", message.DeliveryCount, "error", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		if err := receiver.AbandonMessage(ctx, message, nil); err != nil {
+			slog.ErrorContext(spanCtx, "Failed to abandon Service Bus message", "messaging.message_id", message.MessageID, "error", err)
+		}
+		return
+	}
+
+	span.SetStatus(codes.Ok, "Message processed successfully")
+	if err := receiver.CompleteMessage(ctx, message, nil); err != nil {
+		// The lock is lost, so Service Bus will redeliver a message that was already handled
+		slog.ErrorContext(spanCtx, "Failed to complete Service Bus message", "messaging.message_id", message.MessageID, "error", err)
+		span.RecordError(err)
+	}
+}
+
+// Close stops receiving and waits, until ctx ends, for the batch being handled to be
+// settled before closing the receiver
+func (s *ServiceBusService) Close(ctx context.Context) error {
+	s.mutex.Lock()
+	if s.runCancel != nil {
+		s.runCancel()
+	}
+	s.mutex.Unlock()
+
+	if err := waitGroupDone(ctx, &s.running); err != nil {
+		slog.WarnContext(ctx, "Service Bus processing did not finish before shutdown", "error", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.receiver != nil {
+		if err := s.receiver.Close(ctx); err != nil {
+			slog.WarnContext(ctx, "Failed to close Service Bus receiver", "error", err)
+		}
+	}
+	if s.client != nil {
+		return s.client.Close(ctx)
+	}
+	return nil
+}
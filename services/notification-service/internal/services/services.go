@@ -3,10 +3,15 @@ package services
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"notification-service/internal/config"
+	"notification-service/internal/faults"
+	"notification-service/internal/models"
+	"notification-service/internal/storage"
 	"notification-service/internal/telemetry"
+	"sync"
 	"time"
 
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
@@ -22,24 +27,190 @@ import (
 type NotificationService struct {
 	redis    *RedisClient
 	eventHub *EventHubService
+	producer *EventHubProducer
+	metadata *MetadataIndex
+	repo     storage.NotificationRepository
+	dlq       *DeadLetterQueue
+	retries   *RetryOrchestrator
+	residency *DataResidency
+	scheduled *workQueue
+	// buffered holds the payloads of notifications whose Redis write is in the
+	// write-behind buffer, so they can be read before it is replayed
+	buffered sync.Map
 }
 
-func NewNotificationService(redis *RedisClient, eventHub *EventHubService) *NotificationService {
+// NewNotificationService creates the notification service. repo is the durable store
+// behind Redis and may be nil, in which case notifications live in Redis only. Reported
+// failures are retried through retries and, once they can't be, dead-lettered to dlq.
+// Notifications of tenants pinned to a data region are kept in its stores through
+// residency, and repo must be residency's repository. Pending notifications with a
+// scheduled_at are queued for the ScheduledDispatcher.
+func NewNotificationService(redis *RedisClient, eventHub *EventHubService, producer *EventHubProducer, metadata *MetadataIndex, repo storage.NotificationRepository, dlq *DeadLetterQueue, retries *RetryOrchestrator, residency *DataResidency) *NotificationService {
 	return &NotificationService{
-		redis:    redis,
-		eventHub: eventHub,
+		redis:     redis,
+		eventHub:  eventHub,
+		producer:  producer,
+		metadata:  metadata,
+		repo:      repo,
+		dlq:       dlq,
+		retries:   retries,
+		residency: residency,
+		scheduled: newWorkQueue(redis, scheduledQueue, scheduledLease),
 	}
 }
 
-type RedisClient struct {
-	client *redis.Client
+// PublishLifecycleEvent queues a lifecycle event for batched publishing, partitioned by customer
+func (s *NotificationService) PublishLifecycleEvent(ctx context.Context, event LifecycleEvent) error {
+	if event.Timestamp == "" {
+		event.Timestamp = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+	return s.producer.Publish(ctx, event.CustomerID, event)
 }
 
-func NewRedisClient(url string) *RedisClient {
-	client := redis.NewClient(&redis.Options{
-		Addr: url,
+// SaveNotification persists a notification to the database and Redis. If Redis is
+// briefly unavailable the Redis write is buffered and replayed later; the returned flag
+// reports whether that happened. Once saved, a notification with a collapse key that
+// will be sent replaces the customer's previous one with the same key on its channel. Every notification joins
+// its conversation, and an email is threaded under the earlier ones of it. The
+// notification is stored in its tenant's data region, and a pending scheduled one is
+// queued to be sent when it is due.
+func (s *NotificationService) SaveNotification(ctx context.Context, notification *models.Notification) (bool, error) {
+	notification.Region = s.residency.Region(notification)
+	if notification.Region != "" {
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("data.region", notification.Region))
+	}
+	notification.ConversationID = ConversationID(notification)
+	s.threadEmail(ctx, notification)
+
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal notification: %w", err)
+	}
+	if s.repo != nil {
+		if err := s.repo.UpsertNotification(ctx, notification); err != nil {
+			return false, err
+		}
+	}
+	indexEntries := s.metadata.IndexEntries(ctx, notification)
+	regional := s.residency.Regional(notification.Region)
+	if regional {
+		if err := s.residency.StoreRecord(ctx, notification, payload); err != nil {
+			return false, err
+		}
+	}
+
+	s.buffered.Store(notification.ID, payload)
+	buffered, err := s.redis.buffer.Write(ctx, notification.CustomerID, func(ctx context.Context, client *redis.Client) error {
+		if err := faults.Inject(ctx, faults.OpRedisSave); err != nil {
+			return err
+		}
+		pipe := client.TxPipeline()
+		if regional {
+			pipe.Set(ctx, notificationRegionKey(notification.ID), notification.Region, 0)
+		} else {
+			pipe.Set(ctx, notificationKey(notification.ID), payload, 0)
+		}
+		pipe.ZAdd(ctx, customerNotificationsKey(notification.CustomerID), &redis.Z{
+			Score:  float64(notification.CreatedAt.UnixNano()),
+			Member: notification.ID,
+		})
+		if notification.OrderID != "" {
+			pipe.SAdd(ctx, orderNotificationsKey(notification.OrderID), notification.ID)
+		}
+		pipe.ZAdd(ctx, conversationKey(notification.ConversationID), &redis.Z{
+			Score:  float64(notification.CreatedAt.UnixNano()),
+			Member: notification.ID,
+		})
+		pipe.Expire(ctx, conversationKey(notification.ConversationID), conversationRetention)
+		for _, entry := range indexEntries {
+			pipe.ZAdd(ctx, entry, &redis.Z{
+				Score:  float64(notification.CreatedAt.UnixNano()),
+				Member: notification.ID,
+			})
+		}
+		if notification.Status == models.NotificationStatusPending && notification.ScheduledAt != nil {
+			s.scheduled.Add(ctx, pipe, notification.ID, *notification.ScheduledAt)
+		}
+		_, err := pipe.Exec(ctx)
+		// Stays readable from the buffer until the write lands or is dropped
+		if err == nil || !isRedisUnavailable(err) {
+			s.buffered.Delete(notification.ID)
+		}
+		return err
 	})
-	return &RedisClient{client: client}
+	if !buffered || err != nil {
+		s.buffered.Delete(notification.ID)
+	}
+	if err == nil {
+		telemetry.RecordNotificationCreated(ctx, string(notification.Type), notification.Region)
+		// A buffered notification isn't in Redis yet to replace anything
+		if notification.CollapseKey != "" && !buffered {
+			s.replace(ctx, notification)
+		}
+	}
+	return buffered, err
+}
+
+// FindNotificationsByMetadata returns the tenant's newest notifications matching indexed
+// metadata filters
+func (s *NotificationService) FindNotificationsByMetadata(ctx context.Context, tenantID string, filters map[string]string, limit int) ([]*models.Notification, error) {
+	return s.metadata.FindNotifications(ctx, tenantID, filters, limit)
+}
+
+func notificationKey(id string) string {
+	return "notification:" + id
+}
+
+func customerNotificationsKey(customerID string) string {
+	return "notifications:customer:" + customerID
+}
+
+func orderNotificationsKey(orderID string) string {
+	return "notifications:order:" + orderID
+}
+
+// EventHubFailoverStatus reports the active namespace for the consumer and producer
+func (s *NotificationService) EventHubFailoverStatus() []FailoverStatus {
+	return []FailoverStatus{
+		s.eventHub.Failover().Status(),
+		s.producer.Failover().Status(),
+	}
+}
+
+type RedisClient struct {
+	client   *redis.Client
+	buffer   *WriteBehindBuffer
+	readOnly *storage.ReadOnlyFlag
+}
+
+func NewRedisClient(cfg *config.Config) *RedisClient {
+	client := storage.NewRedisClient(cfg.RedisURL)
+	readOnly := storage.NewReadOnlyFlag(client)
+	return &RedisClient{
+		client:   client,
+		buffer:   NewWriteBehindBuffer(client, readOnly, cfg.RedisBufferCapacity, time.Duration(cfg.RedisBufferFlushIntervalMs)*time.Millisecond),
+		readOnly: readOnly,
+	}
+}
+
+// ReadOnly returns the cluster-wide maintenance flag set by notifyctl
+func (r *RedisClient) ReadOnly() *storage.ReadOnlyFlag {
+	return r.readOnly
+}
+
+// StartBuffer starts flushing writes buffered during Redis outages
+func (r *RedisClient) StartBuffer(ctx context.Context) {
+	r.buffer.Start(ctx)
+}
+
+// FlushBuffer replays buffered writes before shutdown, returning how many couldn't be
+func (r *RedisClient) FlushBuffer(ctx context.Context) int {
+	return r.buffer.Flush(ctx)
+}
+
+// Ping checks that Redis answers
+func (r *RedisClient) Ping(ctx context.Context) error {
+	return r.client.Ping(ctx).Err()
 }
 
 func (r *RedisClient) Close() error {
@@ -48,43 +219,190 @@ func (r *RedisClient) Close() error {
 
 // EventHubService handles Event Hub message consumption
 type EventHubService struct {
-	connectionString string
-	eventHubName     string
-	consumerClient   *azeventhubs.ConsumerClient
-	consumerGroup    string
+	failover       *NamespaceFailover
+	eventHubName   string
+	consumerClient *azeventhubs.ConsumerClient
+	consumerGroup  string
+	concurrency    int
+
+	mutex     sync.Mutex
+	ctx       context.Context
+	runCancel context.CancelFunc
+	handler   EventHandler
+
+	// partitions tracks the running partition processors, so Close can let them finish
+	// the batch they are handling
+	partitions sync.WaitGroup
+}
+
+// EventHandler processes one Event Hub event. ctx carries the eventhub.receive consumer
+// span, so spans started by the handler nest under the upstream producer's trace.
+type EventHandler func(ctx context.Context, body []byte) error
+
+type partitionIDKey struct{}
+
+// PartitionIDFromContext returns the Event Hub partition an event was received from
+func PartitionIDFromContext(ctx context.Context) string {
+	if partitionID, ok := ctx.Value(partitionIDKey{}).(string); ok {
+		return partitionID
+	}
+	return "unknown"
 }
 
-func NewEventHubService(connectionString, eventHubName string) *EventHubService {
-	return &EventHubService{
-		connectionString: connectionString,
-		eventHubName:     eventHubName,
-		consumerGroup:    azeventhubs.DefaultConsumerGroup,
+type messageIDKey struct{}
+
+// MessageIDFromContext returns the message ID the publisher gave an event, empty when
+// it gave none
+func MessageIDFromContext(ctx context.Context) string {
+	messageID, _ := ctx.Value(messageIDKey{}).(string)
+	return messageID
+}
+
+func NewEventHubService(cfg *config.Config) *EventHubService {
+	e := &EventHubService{
+		eventHubName:  cfg.EventHubName,
+		consumerGroup: azeventhubs.DefaultConsumerGroup,
+		concurrency:   max(cfg.EventHubHandlerConcurrency, 1),
 	}
+	e.failover = NewNamespaceFailover("consumer",
+		cfg.EventHubConnectionString,
+		cfg.EventHubSecondaryConnectionString,
+		cfg.EventHubFailoverThreshold,
+		time.Duration(cfg.EventHubFailbackProbeSeconds)*time.Second,
+		probeConsumerNamespace,
+	)
+	e.failover.OnChange(func(ctx context.Context, _ NamespaceRole) { e.reconnect(ctx) })
+	return e
+}
+
+// Failover exposes the consumer's namespace failover state
+func (e *EventHubService) Failover() *NamespaceFailover {
+	return e.failover
 }
 
-func (e *EventHubService) Close() error {
+// ClientState reports whether Event Hub processing is configured and, when it is, an
+// error while the consumer isn't connected to the active namespace
+func (e *EventHubService) ClientState() (configured bool, err error) {
+	if e.failover.ConnectionString() == "" {
+		return false, nil
+	}
+
+	e.mutex.Lock()
+	connected := e.consumerClient != nil && e.runCancel != nil
+	e.mutex.Unlock()
+	if connected {
+		return true, nil
+	}
+
+	status := e.failover.Status()
+	if status.LastError != "" {
+		return true, fmt.Errorf("consumer not connected to %s namespace: %s", status.Active, status.LastError)
+	}
+	return true, fmt.Errorf("consumer not connected to %s namespace", status.Active)
+}
+
+// Close stops the partition processors and waits, until ctx ends, for each to finish the
+// batch it is handling before closing the consumer
+func (e *EventHubService) Close(ctx context.Context) error {
+	e.mutex.Lock()
+	if e.runCancel != nil {
+		e.runCancel()
+	}
+	e.mutex.Unlock()
+
+	if err := waitGroupDone(ctx, &e.partitions); err != nil {
+		slog.WarnContext(ctx, "Event Hub partition processors did not finish before shutdown", "error", err)
+	} else {
+		slog.InfoContext(ctx, "✓ Event Hub partition processors drained")
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
 	if e.consumerClient != nil {
-		return e.consumerClient.Close(context.Background())
+		return e.consumerClient.Close(ctx)
 	}
 	return nil
 }
 
+// waitGroupDone waits for wg until ctx ends
+func waitGroupDone(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // StartProcessing starts consuming messages from Event Hub
-func (e *EventHubService) StartProcessing(ctx context.Context, handler func([]byte) error) error {
-	if e.connectionString == "" {
-		log.Println("Event Hub connection string not configured, skipping Event Hub processing")
+func (e *EventHubService) StartProcessing(ctx context.Context, handler EventHandler) error {
+	if e.failover.ConnectionString() == "" {
+		slog.InfoContext(ctx, "Event Hub connection string not configured, skipping Event Hub processing")
 		return nil
 	}
 
+	e.mutex.Lock()
+	e.ctx = ctx
+	e.handler = handler
+	e.mutex.Unlock()
+
+	e.failover.StartFailbackProbe(ctx)
+
+	// Without a secondary there is nothing to fail over to, so surface the error as before
+	if !e.failover.Status().SecondaryConfigured {
+		return e.connect()
+	}
+
+	// Connecting can take until the failover threshold is reached; the consumer shows as
+	// down in the health check meanwhile
+	go e.connectWithRetry()
+	return nil
+}
+
+// connectWithRetry keeps connecting to the active namespace until it succeeds, the
+// context ends, or a failover switch hands the job over to reconnect
+func (e *EventHubService) connectWithRetry() {
+	for {
+		role := e.failover.Status().Active
+		err := e.connect()
+		if err == nil {
+			return
+		}
+		slog.ErrorContext(e.ctx, "Event Hub connection failed", "eventhub.namespace", role, "error", err)
+		e.failover.ReportFailure(e.ctx, connectFailoverSource, err)
+		if e.failover.Status().Active != role {
+			return
+		}
+
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// connect creates a consumer client for the active namespace and launches the partition processors
+func (e *EventHubService) connect() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	role := e.failover.Status().Active
+
 	// Log connection details (sanitized)
-	log.Printf("Initializing Event Hub consumer with consumer group: %s", e.consumerGroup)
+	slog.InfoContext(e.ctx, "Initializing Event Hub consumer", "eventhub.consumer_group", e.consumerGroup, "eventhub.namespace", role)
 	
 	// Create consumer client with tracing enabled
 	// The Azure SDK for Go automatically uses the global OpenTelemetry tracer provider
 	// No explicit configuration needed - it will pick up the global otel.SetTracerProvider
 	// If connection string contains EntityPath, use empty string for eventHubName
 	consumerClient, err := azeventhubs.NewConsumerClientFromConnectionString(
-		e.connectionString,
+		e.failover.ConnectionString(),
 		"", // Empty string because connection string contains EntityPath
 		e.consumerGroup,
 		nil,
@@ -94,144 +412,244 @@ func (e *EventHubService) StartProcessing(ctx context.Context, handler func([]by
 	}
 	e.consumerClient = consumerClient
 
-	log.Printf("✓ Event Hub consumer client created successfully for consumer group: %s", e.consumerGroup)
+	slog.InfoContext(e.ctx, "✓ Event Hub consumer client created", "eventhub.consumer_group", e.consumerGroup)
+
+	runCtx, cancel := context.WithCancel(e.ctx)
 
 	// Get partition properties to find partition IDs
-	log.Println("Fetching Event Hub properties...")
-	props, err := consumerClient.GetEventHubProperties(ctx, nil)
+	slog.DebugContext(runCtx, "Fetching Event Hub properties")
+	props, err := consumerClient.GetEventHubProperties(runCtx, nil)
 	if err != nil {
+		cancel()
+		consumerClient.Close(e.ctx)
+		e.consumerClient = nil
 		return fmt.Errorf("failed to get Event Hub properties: %w", err)
 	}
+	e.failover.ReportSuccess(connectFailoverSource)
+	e.runCancel = cancel
 
-	log.Printf("✓ Event Hub has %d partitions: %v", len(props.PartitionIDs), props.PartitionIDs)
-	log.Printf("✓ Event Hub name: %s", props.Name)
+	slog.InfoContext(runCtx, "✓ Connected to Event Hub", "eventhub.name", props.Name, "eventhub.partitions", props.PartitionIDs)
 
 	// Process messages from all partitions
-	log.Println("Starting partition processors...")
+	slog.DebugContext(runCtx, "Starting partition processors")
 	for _, partitionID := range props.PartitionIDs {
-		log.Printf("→ Launching goroutine for partition %s", partitionID)
-		go e.processPartition(ctx, partitionID, handler)
+		slog.DebugContext(runCtx, "→ Launching partition processor", "partition.id", partitionID)
+		e.partitions.Add(1)
+		go func() {
+			defer e.partitions.Done()
+			e.processPartition(runCtx, consumerClient, partitionID, e.handler)
+		}()
 	}
 
-	log.Println("✓ All partition processors launched successfully")
+	slog.InfoContext(runCtx, "✓ All partition processors launched")
 	return nil
 }
 
+// reconnect tears down the current consumer and reconnects to the now-active namespace
+func (e *EventHubService) reconnect(ctx context.Context) {
+	e.mutex.Lock()
+	if e.ctx == nil {
+		e.mutex.Unlock()
+		return
+	}
+	if e.runCancel != nil {
+		e.runCancel()
+		e.runCancel = nil
+	}
+	if e.consumerClient != nil {
+		e.consumerClient.Close(context.WithoutCancel(ctx))
+		e.consumerClient = nil
+	}
+	e.mutex.Unlock()
+
+	go e.connectWithRetry()
+}
+
+// probeConsumerNamespace checks whether a namespace accepts connections
+func probeConsumerNamespace(ctx context.Context, connectionString string) error {
+	client, err := azeventhubs.NewConsumerClientFromConnectionString(connectionString, "", azeventhubs.DefaultConsumerGroup, nil)
+	if err != nil {
+		return err
+	}
+	defer client.Close(ctx)
+	_, err = client.GetEventHubProperties(ctx, nil)
+	return err
+}
+
 // processPartition processes messages from a single partition
-func (e *EventHubService) processPartition(ctx context.Context, partitionID string, handler func([]byte) error) {
-	log.Printf("Starting to process partition: %s", partitionID)
+func (e *EventHubService) processPartition(ctx context.Context, consumerClient *azeventhubs.ConsumerClient, partitionID string, handler EventHandler) {
+	slog.InfoContext(ctx, "Starting to process partition", "partition.id", partitionID)
 
-	partitionClient, err := e.consumerClient.NewPartitionClient(partitionID, &azeventhubs.PartitionClientOptions{
+	partitionClient, err := consumerClient.NewPartitionClient(partitionID, &azeventhubs.PartitionClientOptions{
 		StartPosition: azeventhubs.StartPosition{
 			Latest: to.Ptr(true), // Start from latest - only receive new messages
 		},
 	})
 	if err != nil {
-		log.Printf("ERROR: Failed to create partition client for partition %s: %v", partitionID, err)
+		slog.ErrorContext(ctx, "Failed to create partition client", "partition.id", partitionID, "error", err)
 		return
 	}
-	defer partitionClient.Close(ctx)
+	defer partitionClient.Close(context.WithoutCancel(ctx))
 
-	log.Printf("Partition %s: partition client created, starting to receive events...", partitionID)
+	slog.DebugContext(ctx, "Partition client created, receiving events", "partition.id", partitionID)
 
 	pollCount := 0
 	for {
 		select {
 		case <-ctx.Done():
-			log.Printf("Context cancelled, stopping processing for partition: %s", partitionID)
+			slog.InfoContext(ctx, "Context cancelled, stopping partition processing", "partition.id", partitionID)
 			return
 		default:
 			pollCount++
 			// Log every 10th poll attempt to show we're actively polling
 			if pollCount%10 == 0 {
-				log.Printf("Partition %s: polling attempt #%d (still listening for events)", partitionID, pollCount)
+				slog.DebugContext(ctx, "Still listening for events", "partition.id", partitionID, "poll.count", pollCount)
 			}
 			
 			// Receive batch of events with timeout
 			receiveCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
-			log.Printf("Partition %s: calling ReceiveEvents (max 10 events, 30s timeout)...", partitionID)
+			slog.DebugContext(ctx, "Receiving events (max 10, 30s timeout)", "partition.id", partitionID)
 			events, err := partitionClient.ReceiveEvents(receiveCtx, 10, nil)
 			cancel()
 			
-			if err != nil {
-				log.Printf("ERROR: Failed to receive events from partition %s: %v", partitionID, err)
-				time.Sleep(5 * time.Second)
-				continue
+			if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+				// Cancelled while receiving: handle what arrived, then stop
+				if ctx.Err() == nil {
+					slog.ErrorContext(ctx, "Failed to receive events", "partition.id", partitionID, "error", err)
+					e.failover.ReportFailure(ctx, partitionFailoverSource(partitionID), err)
+					time.Sleep(5 * time.Second)
+					continue
+				}
+			} else {
+				e.failover.ReportSuccess(partitionFailoverSource(partitionID))
 			}
 
-			log.Printf("Partition %s: ReceiveEvents returned %d events", partitionID, len(events))
+			slog.DebugContext(ctx, "ReceiveEvents returned", "partition.id", partitionID, "events", len(events))
 			
 			if len(events) > 0 {
-				log.Printf("Partition %s: PROCESSING %d events", partitionID, len(events))
-			} else {
+				slog.InfoContext(ctx, "Processing events", "partition.id", partitionID, "events", len(events))
+			} else if ctx.Err() == nil {
 				// No events, sleep briefly to avoid tight loop
 				time.Sleep(1 * time.Second)
 			}
 
-			// Process each event
-			for _, event := range events {
-				if event.Body == nil || len(event.Body) == 0 {
-					continue
-				}
+			// A batch that was received is handled to the end, even once shutdown has begun;
+			// with no checkpoints its events would otherwise be lost
+			batchCtx := context.WithoutCancel(ctx)
 
-				log.Printf("Received event from partition %s: %d bytes", partitionID, len(event.Body))
-
-				// Extract distributed tracing context from EventHub message properties
-				propagator := otel.GetTextMapPropagator()
-				carrier := propagation.MapCarrier{}
-				
-				// Map EventHub properties to W3C trace context format
-				if event.Properties != nil {
-					for key, value := range event.Properties {
-						if strValue, ok := value.(string); ok {
-							// Check for Diagnostic-Id (used by Azure SDKs) or traceparent
-							if key == "Diagnostic-Id" || key == "traceparent" {
-								carrier["traceparent"] = strValue
-							} else if key == "tracestate" {
-								carrier["tracestate"] = strValue
-							}
-						}
-					}
-				}
-				
-				// Extract context and create span as child of upstream context
-				// Application Insights uses operation_ParentId for Application Map correlation
-				eventCtx := propagator.Extract(ctx, carrier)
-				upstreamSpanContext := trace.SpanContextFromContext(eventCtx)
-				
-				_, span := telemetry.Tracer.Start(eventCtx, "eventhub.receive",
-					trace.WithSpanKind(trace.SpanKindConsumer),
-					trace.WithAttributes(
-						attribute.String("messaging.system", "eventhub"),
-						attribute.String("messaging.destination", "notification-events"),
-						attribute.String("messaging.operation", "receive"),
-						attribute.String("partition.id", partitionID),
-					),
-				)
-				
-				// CRITICAL: Set Azure Monitor correlation attributes explicitly
-				// This ensures Application Map can connect services through EventHub
-				if upstreamSpanContext.IsValid() {
-					span.SetAttributes(
-						attribute.String("ai.operation.id", upstreamSpanContext.TraceID().String()),
-						attribute.String("ai.operation.parentId", upstreamSpanContext.SpanID().String()),
-					)
-				}
+			e.handleBatch(batchCtx, partitionID, events, handler)
+		}
+	}
+}
 
-				// Call the handler
-				if err := handler(event.Body); err != nil {
-					log.Printf("ERROR: Handler failed for event from partition %s: %v", partitionID, err)
-					span.RecordError(err)
-					span.SetStatus(codes.Error, err.Error())
-					// Continue processing other events even if one fails
-				} else {
-					span.SetStatus(codes.Ok, "Event processed successfully")
-				}
-				
-				span.End()
+// handleBatch handles a received batch, up to EVENT_HUB_HANDLER_CONCURRENCY events at a
+// time, so an event waiting on a slow or busy provider doesn't hold up the rest of the
+// partition. Events with the same ordering key are still handled one after another, in
+// the order they arrived. It returns once every event has been handled.
+func (e *EventHubService) handleBatch(ctx context.Context, partitionID string, events []*azeventhubs.ReceivedEventData, handler EventHandler) {
+	var keys []string
+	ordered := make(map[string][]*azeventhubs.ReceivedEventData)
+	for _, event := range events {
+		if len(event.Body) == 0 {
+			continue
+		}
+		key := eventOrderingKey(event)
+		if _, ok := ordered[key]; !ok {
+			keys = append(keys, key)
+		}
+		ordered[key] = append(ordered[key], event)
+	}
+
+	slots := make(chan struct{}, e.concurrency)
+	var wg sync.WaitGroup
+	for _, key := range keys {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			for _, event := range ordered[key] {
+				e.handleEvent(ctx, partitionID, event, handler)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// eventOrderingKey is the partition key an event was sent with, else its customer, so a
+// customer's events keep their order; events with neither share one key
+func eventOrderingKey(event *azeventhubs.ReceivedEventData) string {
+	if event.PartitionKey != nil && *event.PartitionKey != "" {
+		return *event.PartitionKey
+	}
+	var customer struct {
+		CustomerID string `json:"CustomerId"`
+	}
+	_ = json.Unmarshal(event.Body, &customer)
+	return customer.CustomerID
+}
+
+// handleEvent runs the handler for one event under an eventhub.receive consumer span
+// that continues the upstream trace
+func (e *EventHubService) handleEvent(ctx context.Context, partitionID string, event *azeventhubs.ReceivedEventData, handler EventHandler) {
+	slog.DebugContext(ctx, "Received event", "partition.id", partitionID, "bytes", len(event.Body))
+
+	// Extract context and create span as child of upstream context
+	// Application Insights uses operation_ParentId for Application Map correlation
+	eventCtx := extractTraceContext(ctx, event.Properties)
+	upstreamSpanContext := trace.SpanContextFromContext(eventCtx)
+
+	spanCtx, span := telemetry.Tracer.Start(eventCtx, "eventhub.receive",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "eventhub"),
+			attribute.String("messaging.destination", "notification-events"),
+			attribute.String("messaging.operation", "receive"),
+			attribute.String("partition.id", partitionID),
+		),
+	)
+	defer span.End()
+
+	// CRITICAL: Set Azure Monitor correlation attributes explicitly
+	// This ensures Application Map can connect services through EventHub
+	if upstreamSpanContext.IsValid() {
+		span.SetAttributes(
+			attribute.String("ai.operation.id", upstreamSpanContext.TraceID().String()),
+			attribute.String("ai.operation.parentId", upstreamSpanContext.SpanID().String()),
+		)
+	}
+
+	// Call the handler within the consumer span's context
+	handlerCtx := context.WithValue(spanCtx, partitionIDKey{}, partitionID)
+	if event.MessageID != nil {
+		handlerCtx = context.WithValue(handlerCtx, messageIDKey{}, *event.MessageID)
+	}
+	if err := handler(handlerCtx, event.Body); err != nil {
+		slog.ErrorContext(handlerCtx, "Handler failed for event", "partition.id", partitionID, "error", err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		// Continue processing other events even if one fails
+	} else {
+		span.SetStatus(codes.Ok, "Event processed successfully")
+	}
+}
+
+// extractTraceContext continues the upstream trace carried in message properties. Azure
+// SDKs set Diagnostic-Id to the W3C traceparent; a plain traceparent is accepted too.
+func extractTraceContext(ctx context.Context, properties map[string]any) context.Context {
+	carrier := propagation.MapCarrier{}
+	for key, value := range properties {
+		if strValue, ok := value.(string); ok {
+			if key == "Diagnostic-Id" || key == "traceparent" {
+				carrier["traceparent"] = strValue
+			} else if key == "tracestate" {
+				carrier["tracestate"] = strValue
 			}
 		}
 	}
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
 }
 
 // OrderEvent represents an order event from Event Hub
@@ -273,34 +691,85 @@ func ParseOrderEvent(data []byte) (*OrderEvent, error) {
 	return &event, nil
 }
 
-type EmailService struct {
-	cfg *config.Config
-}
-
-func NewEmailService(cfg *config.Config) *EmailService {
-	return &EmailService{cfg: cfg}
-}
-
-type SMSService struct {
-	cfg *config.Config
-}
-
-func NewSMSService(cfg *config.Config) *SMSService {
-	return &SMSService{cfg: cfg}
-}
+var (
+	// ErrChannelNotImplemented is returned by channels that don't deliver yet
+	ErrChannelNotImplemented = errors.New("channel delivery not implemented")
+	// ErrChannelNotConfigured is returned by channels whose provider settings are missing
+	ErrChannelNotConfigured = errors.New("channel provider not configured")
+)
 
 type PushNotificationService struct {
-	cfg *config.Config
+	cfg     *config.Config
+	silent  *SilentPushLimiter
+	devices DeviceManager
 }
 
-func NewPushNotificationService(cfg *config.Config) *PushNotificationService {
-	return &PushNotificationService{cfg: cfg}
+func NewPushNotificationService(cfg *config.Config, silent *SilentPushLimiter, devices DeviceManager) *PushNotificationService {
+	return &PushNotificationService{cfg: cfg, silent: silent, devices: devices}
 }
 
-type WebhookService struct {
-	cfg *config.Config
+// Send builds the FCM message and APNs payload for a notification; neither provider is
+// called yet, so delivery fails as not implemented. A notification with a target goes
+// to each of its customer's matching devices, iOS ones as APNs payloads and the others
+// as FCM messages. A silent push is first checked against each device's silent push
+// allowance; when Redis can't be reached it is sent regardless.
+func (s *PushNotificationService) Send(ctx context.Context, notification *models.Notification) error {
+	silent := IsSilentPush(notification)
+	if err := faults.Inject(ctx, faults.OpChannelPush); err != nil {
+		telemetry.RecordPushSend(ctx, silent, "failed")
+		return fmt.Errorf("push: %w", err)
+	}
+	if notification.Target == nil {
+		return s.sendToDevice(ctx, notification, "")
+	}
+
+	devices, err := s.devices.Resolve(ctx, notification.CustomerID, notification.Target)
+	if err != nil {
+		telemetry.RecordPushSend(ctx, silent, "failed")
+		return fmt.Errorf("push: %w", err)
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("push.devices", len(devices)))
+	var errs []error
+	for _, device := range devices {
+		deviceNotification := *notification
+		deviceNotification.Recipient = device.Token
+		if err := s.sendToDevice(ctx, &deviceNotification, device.Platform); err != nil {
+			errs = append(errs, fmt.Errorf("device %s: %w", device.ID, err))
+		}
+	}
+	return errors.Join(errs...)
 }
 
-func NewWebhookService(cfg *config.Config) *WebhookService {
-	return &WebhookService{cfg: cfg}
+// sendToDevice sends to the device token in the notification's recipient: the payload
+// of its platform, or both payloads when the platform isn't known
+func (s *PushNotificationService) sendToDevice(ctx context.Context, notification *models.Notification, platform models.DevicePlatform) error {
+	silent := IsSilentPush(notification)
+	if silent {
+		err := s.silent.Allow(ctx, notification.Recipient)
+		var limited *SilentPushLimitError
+		if errors.As(err, &limited) {
+			trace.SpanFromContext(ctx).AddEvent("push.silent.rate_limited", trace.WithAttributes(
+				attribute.Int64("retry.after_ms", limited.RetryAfterDelay.Milliseconds()),
+			))
+			telemetry.RecordPushSend(ctx, silent, "rate_limited")
+			return fmt.Errorf("push: %w", err)
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "Sending silent push without rate limit check", "notification.id", notification.ID, "error", err)
+		}
+	}
+	if platform != models.DevicePlatformIOS {
+		if _, err := BuildFCMMessage(notification); err != nil {
+			telemetry.RecordPushSend(ctx, silent, "failed")
+			return fmt.Errorf("push: %w", err)
+		}
+	}
+	if platform == "" || platform == models.DevicePlatformIOS {
+		if _, err := BuildAPNsPayload(notification); err != nil {
+			telemetry.RecordPushSend(ctx, silent, "failed")
+			return fmt.Errorf("push: %w", err)
+		}
+	}
+	telemetry.RecordPushSend(ctx, silent, "failed")
+	return fmt.Errorf("push: %w", ErrChannelNotImplemented)
 }
@@ -0,0 +1,630 @@
+package services
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"notification-service/internal/cache"
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// signingKeysKey holds every signing key, private halves encrypted, by key ID
+const signingKeysKey = "signing-keys"
+
+// Signing keys are Ed25519 keys, checked for rotation on this interval
+const (
+	signingKeyAlgorithm     = "EdDSA"
+	signingKeyCheckInterval = 15 * time.Minute
+	// signingKeyCacheTTL is how long a replica keeps the keys it read
+	signingKeyCacheTTL = 30 * time.Second
+)
+
+// JWKSMaxAge is how long consumers may cache the JWKS
+const JWKSMaxAge = 5 * time.Minute
+
+// signingKeyLead is how long a key added by hand or by Rotate is published before it
+// signs, so consumers that cached the JWKS before it was added have it by then
+const signingKeyLead = JWKSMaxAge + signingKeyCacheTTL
+
+var (
+	ErrSigningKeyNotFound = errors.New("signing key not found")
+	ErrInvalidSigningKey  = errors.New("invalid signing key")
+	ErrNoSigningKey       = errors.New("no active signing key")
+	ErrSigningKeysLocked  = errors.New("signing keys are unavailable without SIGNING_KEY_ENCRYPTION_KEY")
+)
+
+// storedSigningKey is a signing key as kept in Redis, its private half encrypted with
+// the key encryption key and bound to its ID
+type storedSigningKey struct {
+	models.SigningKey
+	PrivateKey          ed25519.PrivateKey `json:"-"`
+	EncryptedPrivateKey []byte             `json:"encrypted_private_key,omitempty"`
+	// LegacyPrivateKey is a private key stored before keys were encrypted; it is
+	// encrypted the next time the keys are written
+	LegacyPrivateKey ed25519.PrivateKey `json:"private_key,omitempty"`
+}
+
+// SigningKeyService manages the Ed25519 keys webhooks and WebSocket messages are signed
+// with and publishes their public halves as a JWKS. The newest key inside its activation
+// window signs. Keys rotate every SIGNING_KEY_ROTATION_HOURS: the next key is created
+// SIGNING_KEY_OVERLAP_HOURS before the current one expires, so consumers polling the
+// JWKS have it before it is used, and an expired key stays published for as long again
+// so signatures made just before it expired still verify. Replicas race to create keys
+// in a Redis transaction, so only one of them wins. Private keys are encrypted with
+// AES-256-GCM under SIGNING_KEY_ENCRYPTION_KEY before they reach Redis; without it, the
+// published keys are still served but nothing is signed.
+type SigningKeyService struct {
+	redis    *RedisClient
+	kek      cipher.AEAD
+	rotation time.Duration
+	overlap  time.Duration
+	keys     *cache.Cache[map[string]*storedSigningKey]
+}
+
+func NewSigningKeyService(cfg *config.Config, redis *RedisClient) *SigningKeyService {
+	kek, err := keyEncryptionKey(cfg.SigningKeyEncryptionKey)
+	if err != nil {
+		slog.Warn("Ed25519 signing is off: SIGNING_KEY_ENCRYPTION_KEY is unusable", "error", err)
+	}
+
+	rotation := time.Duration(max(cfg.SigningKeyRotationHours, 0)) * time.Hour
+	overlap := time.Duration(max(cfg.SigningKeyOverlapHours, 0)) * time.Hour
+	if rotation > 0 && rotation <= overlap {
+		slog.Warn("Ignoring SIGNING_KEY_ROTATION_HOURS, not longer than the overlap", "configured.hours", cfg.SigningKeyRotationHours, "rotation.interval", 2*overlap)
+		rotation = 2 * overlap
+	}
+
+	return &SigningKeyService{
+		redis:    redis,
+		kek:      kek,
+		rotation: rotation,
+		overlap:  overlap,
+		// New keys reach other replicas within the TTL, well inside the overlap
+		keys: cache.New[map[string]*storedSigningKey](nil, cache.Options{
+			Name:       "signing-keys",
+			Mode:       cache.ReadThrough,
+			L1TTL:      signingKeyCacheTTL,
+			L1MaxItems: 1,
+		}),
+	}
+}
+
+// keyEncryptionKey builds the cipher private keys are encrypted with from a base64
+// 32-byte key
+func keyEncryptionKey(encoded string) (cipher.AEAD, error) {
+	if encoded == "" {
+		return nil, errors.New("not set")
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("not base64: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("%d bytes, want 32", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Start makes sure a key is active and keeps the rotation schedule until ctx ends. Keys
+// stored unencrypted by earlier versions are encrypted by the first check.
+func (s *SigningKeyService) Start(ctx context.Context) {
+	if s.kek == nil {
+		return
+	}
+	if err := s.ensure(ctx); err != nil {
+		slog.WarnContext(ctx, "Failed to check signing keys", "error", err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(signingKeyCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.ensure(ctx); err != nil {
+					slog.WarnContext(ctx, "Failed to rotate signing keys", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// List returns every published key, oldest activation first
+func (s *SigningKeyService) List(ctx context.Context) ([]models.SigningKey, error) {
+	keys, err := s.loadKeys(ctx, s.redis.client)
+	if err != nil {
+		return nil, err
+	}
+	return s.describe(keys, time.Now().UTC()), nil
+}
+
+// Get returns a published key
+func (s *SigningKeyService) Get(ctx context.Context, id string) (*models.SigningKey, error) {
+	keys, err := s.loadKeys(ctx, s.redis.client)
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range s.describe(keys, time.Now().UTC()) {
+		if key.ID == id {
+			return &key, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrSigningKeyNotFound, id)
+}
+
+// Create generates a key for the requested window. It starts once published for the
+// JWKS cache lifetime unless a later ActivatesAt is set, and ends a rotation period
+// later unless ExpiresAt is set.
+func (s *SigningKeyService) Create(ctx context.Context, req models.SigningKeyRequest) (*models.SigningKey, error) {
+	now := time.Now().UTC()
+	activatesAt := now.Add(signingKeyLead)
+	if req.ActivatesAt != nil {
+		activatesAt = req.ActivatesAt.UTC()
+		if activatesAt.Before(now.Add(signingKeyLead)) {
+			return nil, fmt.Errorf("%w: activates_at must be at least %s away, so cached JWKS have the key", ErrInvalidSigningKey, signingKeyLead)
+		}
+	}
+	expiresAt := req.ExpiresAt
+	if expiresAt == nil && s.rotation > 0 {
+		end := activatesAt.Add(s.rotation)
+		expiresAt = &end
+	}
+	if err := validateSigningWindow(activatesAt, expiresAt, now); err != nil {
+		return nil, err
+	}
+
+	key, err := s.newKey(now, activatesAt, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	var created *models.SigningKey
+	err = s.update(ctx, func(keys map[string]*storedSigningKey, now time.Time) error {
+		keys[key.ID] = key
+		created = s.find(keys, key.ID, now)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "🔑 Signing key created", "key.id", key.ID, "key.activates_at", activatesAt)
+	return created, nil
+}
+
+// Update moves a key's activation window; fields left unset keep their value
+func (s *SigningKeyService) Update(ctx context.Context, id string, req models.SigningKeyRequest) (*models.SigningKey, error) {
+	var updated *models.SigningKey
+	err := s.update(ctx, func(keys map[string]*storedSigningKey, now time.Time) error {
+		key, ok := keys[id]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrSigningKeyNotFound, id)
+		}
+		if req.ActivatesAt != nil {
+			activatesAt := req.ActivatesAt.UTC()
+			// A key that doesn't sign yet can't be brought forward past what JWKS caches have
+			if key.ActivatesAt.After(now) && activatesAt.Before(key.ActivatesAt) && activatesAt.Before(key.CreatedAt.Add(signingKeyLead)) {
+				return fmt.Errorf("%w: activates_at must be at least %s after the key was created, so cached JWKS have it", ErrInvalidSigningKey, signingKeyLead)
+			}
+			key.ActivatesAt = activatesAt
+		}
+		if req.ExpiresAt != nil {
+			expiresAt := req.ExpiresAt.UTC()
+			key.ExpiresAt = &expiresAt
+		}
+		if err := validateSigningWindow(key.ActivatesAt, key.ExpiresAt, now); err != nil {
+			return err
+		}
+		updated = s.find(keys, id, now)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// Delete revokes a key at once: it is no longer published, so signatures made with it
+// stop verifying. A new key is created if it was the only one that could sign.
+func (s *SigningKeyService) Delete(ctx context.Context, id string) error {
+	err := s.update(ctx, func(keys map[string]*storedSigningKey, now time.Time) error {
+		if _, ok := keys[id]; !ok {
+			return fmt.Errorf("%w: %s", ErrSigningKeyNotFound, id)
+		}
+		delete(keys, id)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	slog.InfoContext(ctx, "🔑 Signing key revoked", "key.id", id)
+	return s.ensure(ctx)
+}
+
+// Rotate replaces the signing key ahead of schedule, once the new key has been published
+// for the JWKS cache lifetime. The keys in use expire then and stay published through
+// the overlap; keys scheduled but not yet used are dropped.
+func (s *SigningKeyService) Rotate(ctx context.Context) (*models.SigningKey, error) {
+	now := time.Now().UTC()
+	activatesAt := now.Add(signingKeyLead)
+	var expiresAt *time.Time
+	if s.rotation > 0 {
+		end := activatesAt.Add(s.rotation)
+		expiresAt = &end
+	}
+	key, err := s.newKey(now, activatesAt, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	var rotated *models.SigningKey
+	err = s.update(ctx, func(keys map[string]*storedSigningKey, now time.Time) error {
+		for id, existing := range keys {
+			switch {
+			case existing.ActivatesAt.After(now):
+				delete(keys, id)
+			case existing.ExpiresAt == nil || existing.ExpiresAt.After(activatesAt):
+				expired := activatesAt
+				existing.ExpiresAt = &expired
+			}
+		}
+		keys[key.ID] = key
+		rotated = s.find(keys, key.ID, now)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "🔑 Signing keys rotated", "key.id", key.ID)
+	return rotated, nil
+}
+
+// JWKS returns the published public keys, including keys scheduled to sign later
+func (s *SigningKeyService) JWKS(ctx context.Context) (models.JWKSet, error) {
+	keys, err := s.keys.Get(ctx, signingKeysKey, s.load)
+	if err != nil {
+		return models.JWKSet{}, err
+	}
+	set := models.JWKSet{Keys: []models.JWK{}}
+	for _, key := range s.describe(keys, time.Now().UTC()) {
+		set.Keys = append(set.Keys, models.JWK{
+			KeyType:   "OKP",
+			Curve:     "Ed25519",
+			X:         key.PublicKey,
+			KeyID:     key.ID,
+			Algorithm: signingKeyAlgorithm,
+			Use:       "sig",
+		})
+	}
+	return set, nil
+}
+
+// Sign signs payload with the active key, returning the key's ID and the base64url
+// Ed25519 signature
+func (s *SigningKeyService) Sign(ctx context.Context, payload []byte) (string, string, error) {
+	keys, err := s.keys.Get(ctx, signingKeysKey, s.load)
+	if err != nil {
+		return "", "", err
+	}
+	if s.kek == nil {
+		return "", "", ErrSigningKeysLocked
+	}
+	key := activeSigningKey(keys, time.Now().UTC())
+	if key == nil {
+		// The schedule is checked periodically; don't wait for it to fill a gap
+		if err := s.ensure(ctx); err != nil {
+			return "", "", err
+		}
+		if keys, err = s.keys.Get(ctx, signingKeysKey, s.load); err != nil {
+			return "", "", err
+		}
+		if key = activeSigningKey(keys, time.Now().UTC()); key == nil {
+			return "", "", ErrNoSigningKey
+		}
+	}
+	return key.ID, base64.RawURLEncoding.EncodeToString(ed25519.Sign(key.PrivateKey, payload)), nil
+}
+
+// ensure keeps the rotation schedule: it drops keys past their publication, creates a key
+// when none can sign, and creates the next one once the last expiry is within the overlap.
+// A key created because none can sign signs at once: nothing would be signed otherwise.
+func (s *SigningKeyService) ensure(ctx context.Context) error {
+	var created []string
+	err := s.update(ctx, func(keys map[string]*storedSigningKey, now time.Time) error {
+		created = nil
+		for id, key := range keys {
+			if until := s.publishedUntil(key); until != nil && !now.Before(*until) {
+				delete(keys, id)
+			}
+		}
+
+		if activeSigningKey(keys, now) == nil {
+			var expiresAt *time.Time
+			if s.rotation > 0 {
+				end := now.Add(s.rotation)
+				expiresAt = &end
+			}
+			key, err := s.newKey(now, now, expiresAt)
+			if err != nil {
+				return err
+			}
+			keys[key.ID] = key
+			created = append(created, key.ID)
+		}
+		if s.rotation <= 0 {
+			return nil
+		}
+
+		var horizon time.Time
+		for _, key := range keys {
+			if key.ExpiresAt == nil {
+				return nil
+			}
+			if key.ExpiresAt.After(horizon) {
+				horizon = *key.ExpiresAt
+			}
+		}
+		if horizon.Sub(now) > s.overlap {
+			return nil
+		}
+		end := horizon.Add(s.rotation)
+		key, err := s.newKey(now, horizon, &end)
+		if err != nil {
+			return err
+		}
+		keys[key.ID] = key
+		created = append(created, key.ID)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, id := range created {
+		slog.InfoContext(ctx, "🔑 Signing key created by the rotation schedule", "key.id", id)
+	}
+	return nil
+}
+
+// update applies fn to the stored keys in a transaction, writing back what it changed.
+// Keys that can't be decrypted are left as they are.
+func (s *SigningKeyService) update(ctx context.Context, fn func(keys map[string]*storedSigningKey, now time.Time) error) error {
+	if s.kek == nil {
+		return ErrSigningKeysLocked
+	}
+	err := watchKey(ctx, s.redis.client, signingKeysKey, func(tx *redis.Tx) error {
+		entries, err := tx.HGetAll(ctx, signingKeysKey).Result()
+		if err != nil {
+			return fmt.Errorf("failed to load signing keys: %w", err)
+		}
+		keys := s.decodeKeys(ctx, entries)
+		before := make(map[string][]byte, len(keys))
+		for id := range keys {
+			before[id] = []byte(entries[id])
+		}
+
+		if err := fn(keys, time.Now().UTC()); err != nil {
+			return err
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for id := range before {
+				if _, ok := keys[id]; !ok {
+					pipe.HDel(ctx, signingKeysKey, id)
+				}
+			}
+			for id, key := range keys {
+				if key.EncryptedPrivateKey == nil {
+					s.seal(key)
+				}
+				payload, err := json.Marshal(key)
+				if err != nil {
+					return err
+				}
+				if string(payload) != string(before[id]) {
+					pipe.HSet(ctx, signingKeysKey, id, payload)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to store signing keys: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.keys.Delete(ctx, signingKeysKey)
+	return nil
+}
+
+func (s *SigningKeyService) load(ctx context.Context) (map[string]*storedSigningKey, error) {
+	return s.loadKeys(ctx, s.redis.client)
+}
+
+// describe lists the published keys with their status, oldest activation first
+func (s *SigningKeyService) describe(keys map[string]*storedSigningKey, now time.Time) []models.SigningKey {
+	active := activeSigningKey(keys, now)
+	described := make([]models.SigningKey, 0, len(keys))
+	for _, key := range keys {
+		until := s.publishedUntil(key)
+		if until != nil && !now.Before(*until) {
+			continue
+		}
+		description := key.SigningKey
+		description.PublishedUntil = until
+		switch {
+		case key.ActivatesAt.After(now):
+			description.Status = models.SigningKeyScheduled
+		case key.ExpiresAt != nil && !now.Before(*key.ExpiresAt):
+			description.Status = models.SigningKeyExpired
+		case active != nil && key.ID == active.ID:
+			description.Status = models.SigningKeyActive
+		default:
+			description.Status = models.SigningKeySuperseded
+		}
+		described = append(described, description)
+	}
+	sort.Slice(described, func(i, j int) bool {
+		if !described[i].ActivatesAt.Equal(described[j].ActivatesAt) {
+			return described[i].ActivatesAt.Before(described[j].ActivatesAt)
+		}
+		return described[i].ID < described[j].ID
+	})
+	return described
+}
+
+func (s *SigningKeyService) find(keys map[string]*storedSigningKey, id string, now time.Time) *models.SigningKey {
+	for _, key := range s.describe(keys, now) {
+		if key.ID == id {
+			return &key
+		}
+	}
+	return nil
+}
+
+// publishedUntil is when a key leaves the JWKS: the overlap after it expires
+func (s *SigningKeyService) publishedUntil(key *storedSigningKey) *time.Time {
+	if key.ExpiresAt == nil {
+		return nil
+	}
+	until := key.ExpiresAt.Add(s.overlap)
+	return &until
+}
+
+// activeSigningKey is the key that signs at now: of the keys inside their activation
+// window, the one activated last
+func activeSigningKey(keys map[string]*storedSigningKey, now time.Time) *storedSigningKey {
+	var active *storedSigningKey
+	for _, key := range keys {
+		if key.ActivatesAt.After(now) || (key.ExpiresAt != nil && !now.Before(*key.ExpiresAt)) {
+			continue
+		}
+		if active == nil || key.ActivatesAt.After(active.ActivatesAt) ||
+			(key.ActivatesAt.Equal(active.ActivatesAt) && key.CreatedAt.After(active.CreatedAt)) {
+			active = key
+		}
+	}
+	return active
+}
+
+func (s *SigningKeyService) loadKeys(ctx context.Context, client redis.Cmdable) (map[string]*storedSigningKey, error) {
+	entries, err := client.HGetAll(ctx, signingKeysKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load signing keys: %w", err)
+	}
+	return s.decodeKeys(ctx, entries), nil
+}
+
+// decodeKeys decodes stored keys, decrypting their private halves when the key
+// encryption key is set. Keys that can't be decoded or decrypted are skipped.
+func (s *SigningKeyService) decodeKeys(ctx context.Context, entries map[string]string) map[string]*storedSigningKey {
+	keys := make(map[string]*storedSigningKey, len(entries))
+	for id, payload := range entries {
+		var key storedSigningKey
+		if err := json.Unmarshal([]byte(payload), &key); err != nil {
+			slog.WarnContext(ctx, "Ignoring unreadable signing key", "key.id", id)
+			continue
+		}
+		if s.kek != nil {
+			if err := s.open(&key); err != nil {
+				slog.WarnContext(ctx, "Ignoring signing key that can't be decrypted", "key.id", id, "error", err)
+				continue
+			}
+		}
+		keys[id] = &key
+	}
+	return keys
+}
+
+// seal encrypts a key's private half, bound to its ID
+func (s *SigningKeyService) seal(key *storedSigningKey) {
+	nonce := make([]byte, s.kek.NonceSize())
+	_, _ = rand.Read(nonce)
+	key.EncryptedPrivateKey = s.kek.Seal(nonce, nonce, key.PrivateKey, []byte(key.ID))
+	key.LegacyPrivateKey = nil
+}
+
+// open decrypts a key's private half, taking an unencrypted one as it is
+func (s *SigningKeyService) open(key *storedSigningKey) error {
+	private := key.LegacyPrivateKey
+	if key.EncryptedPrivateKey != nil {
+		size := s.kek.NonceSize()
+		if len(key.EncryptedPrivateKey) < size {
+			return errors.New("ciphertext too short")
+		}
+		nonce, ciphertext := key.EncryptedPrivateKey[:size], key.EncryptedPrivateKey[size:]
+		var err error
+		if private, err = s.kek.Open(nil, nonce, ciphertext, []byte(key.ID)); err != nil {
+			return err
+		}
+	}
+	if len(private) != ed25519.PrivateKeySize {
+		return errors.New("no private key")
+	}
+	key.PrivateKey = private
+	return nil
+}
+
+// newKey generates an Ed25519 key whose ID is its JWK thumbprint (RFC 7638), with its
+// private half encrypted
+func (s *SigningKeyService) newKey(now, activatesAt time.Time, expiresAt *time.Time) (*storedSigningKey, error) {
+	if s.kek == nil {
+		return nil, ErrSigningKeysLocked
+	}
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	x := base64.RawURLEncoding.EncodeToString(public)
+	thumbprint := sha256.Sum256([]byte(`{"crv":"Ed25519","kty":"OKP","x":"` + x + `"}`))
+
+	if expiresAt != nil {
+		end := expiresAt.UTC()
+		expiresAt = &end
+	}
+	key := &storedSigningKey{
+		SigningKey: models.SigningKey{
+			ID:          base64.RawURLEncoding.EncodeToString(thumbprint[:]),
+			Algorithm:   signingKeyAlgorithm,
+			PublicKey:   x,
+			CreatedAt:   now,
+			ActivatesAt: activatesAt.UTC(),
+			ExpiresAt:   expiresAt,
+		},
+		PrivateKey: private,
+	}
+	s.seal(key)
+	return key, nil
+}
+
+func validateSigningWindow(activatesAt time.Time, expiresAt *time.Time, now time.Time) error {
+	if expiresAt == nil {
+		return nil
+	}
+	if !expiresAt.After(activatesAt) {
+		return fmt.Errorf("%w: expires_at must be after activates_at", ErrInvalidSigningKey)
+	}
+	if !expiresAt.After(now) {
+		return fmt.Errorf("%w: expires_at must be in the future", ErrInvalidSigningKey)
+	}
+	return nil
+}
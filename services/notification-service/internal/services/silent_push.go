@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+)
+
+// SilentPushLimitError is returned for a silent push over its device's allowance. It is
+// not retried: the app refreshes on the next silent push that gets through, which
+// carries the latest state anyway.
+type SilentPushLimitError struct {
+	RetryAfterDelay time.Duration
+}
+
+func (e *SilentPushLimitError) Error() string {
+	return fmt.Sprintf("silent push rate limit reached for device, next allowed in %s", e.RetryAfterDelay.Round(time.Second))
+}
+
+func (e *SilentPushLimitError) ErrorClass() models.ErrorClass {
+	return models.ErrorClassRejected
+}
+
+func (e *SilentPushLimitError) RetryAfter() time.Duration {
+	return e.RetryAfterDelay
+}
+
+// SilentPushLimiter keeps silent pushes to each device within SILENT_PUSH_PER_HOUR, apart
+// from visible ones. APNs starts dropping background pushes to a device that gets more
+// than a few an hour, and they wake the app on every platform, so they get a budget of
+// their own. It uses the same Redis token buckets as the API rate limits.
+type SilentPushLimiter struct {
+	redis   *RedisClient
+	perHour int
+	burst   int
+}
+
+func NewSilentPushLimiter(cfg *config.Config, redis *RedisClient) *SilentPushLimiter {
+	burst := cfg.SilentPushBurst
+	if burst <= 0 {
+		burst = max(cfg.SilentPushPerHour, 1)
+	}
+	return &SilentPushLimiter{redis: redis, perHour: cfg.SilentPushPerHour, burst: burst}
+}
+
+// Allow takes one silent push from the device's allowance, returning a
+// *SilentPushLimitError when none is left. A limit of zero or less turns it off.
+func (l *SilentPushLimiter) Allow(ctx context.Context, deviceToken string) error {
+	if l == nil || l.perHour <= 0 {
+		return nil
+	}
+	rate := float64(l.perHour) / 3600
+	keys := []string{"silent-push-limit:" + deviceToken}
+	result, err := tokenBucketScript.Run(ctx, l.redis.client, keys, rate, l.burst).Slice()
+	if err != nil {
+		return fmt.Errorf("failed to check silent push limit: %w", err)
+	}
+	if len(result) != 4 {
+		return fmt.Errorf("unexpected silent push limit result %v", result)
+	}
+	if allowed, _ := result[0].(int64); allowed == 1 {
+		return nil
+	}
+	wait, _ := strconv.ParseFloat(fmt.Sprint(result[3]), 64)
+	return &SilentPushLimitError{RetryAfterDelay: time.Duration(wait * float64(time.Second))}
+}
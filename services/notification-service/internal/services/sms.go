@@ -0,0 +1,315 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/faults"
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ProviderError is a delivery failure reported by a channel provider, carrying its error
+// class and the notification status it maps to
+type ProviderError struct {
+	Channel         string
+	Code            int
+	Message         string
+	Class           models.ErrorClass
+	Status          models.NotificationStatus
+	RetryAfterDelay time.Duration
+}
+
+func newProviderError(channel models.NotificationType, code int, message string, class models.ErrorClass) *ProviderError {
+	status := models.NotificationStatusRetrying
+	if class == models.ErrorClassRejected || class == models.ErrorClassUnknown {
+		status = models.NotificationStatusFailed
+	}
+	return &ProviderError{Channel: string(channel), Code: code, Message: message, Class: class, Status: status}
+}
+
+func (e *ProviderError) Error() string {
+	return fmt.Sprintf("%s provider error %d: %s", e.Channel, e.Code, e.Message)
+}
+
+func (e *ProviderError) ErrorClass() models.ErrorClass {
+	return e.Class
+}
+
+func (e *ProviderError) RetryAfter() time.Duration {
+	return e.RetryAfterDelay
+}
+
+// Retryable reports whether the provider expects a later attempt to succeed
+func (e *ProviderError) Retryable() bool {
+	return e.Status == models.NotificationStatusRetrying
+}
+
+// e164Pattern matches the phone number format Twilio accepts for To and From
+var e164Pattern = regexp.MustCompile(`^\+[1-9]\d{1,14}$`)
+
+// twilioErrorClasses classifies the Twilio errors caused by rate limits, outages,
+// queueing or carrier congestion; any other code means the message will never be
+// delivered as sent
+var twilioErrorClasses = map[int]models.ErrorClass{
+	20429: models.ErrorClassThrottled, // too many requests
+	20500: models.ErrorClassServer,    // internal server error
+	20503: models.ErrorClassServer,    // service unavailable
+	30001: models.ErrorClassThrottled, // queue overflow
+	30008: models.ErrorClassTransient, // unknown error
+	30017: models.ErrorClassThrottled, // carrier network congestion
+	30022: models.ErrorClassThrottled, // US A2P 10DLC rate limit exceeded
+}
+
+// twilioMessage is the Messages resource returned by Twilio
+type twilioMessage struct {
+	SID          string `json:"sid"`
+	Status       string `json:"status"`
+	ErrorCode    *int   `json:"error_code"`
+	ErrorMessage string `json:"error_message"`
+}
+
+// twilioError is the body of a non-2xx Twilio response
+type twilioError struct {
+	Code     int    `json:"code"`
+	Message  string `json:"message"`
+	MoreInfo string `json:"more_info"`
+}
+
+// SMSService sends text messages through the Twilio Messages API
+type SMSService struct {
+	cfg     *config.Config
+	client  *http.Client
+	sampler *ProviderPayloadSampler
+	retries *RetryPolicies
+
+	// provider is the Twilio account's name, whose throttling holds its own sends
+	provider DeliveryProvider
+}
+
+func NewSMSService(cfg *config.Config, provider DeliveryProvider, sampler *ProviderPayloadSampler, retries *RetryPolicies) *SMSService {
+	return &SMSService{
+		cfg:      cfg,
+		provider: provider,
+		sampler:  sampler,
+		retries:  retries,
+		client:   NewHTTPClient(cfg, ProviderTwilio, 15*time.Second),
+	}
+}
+
+// Send submits the notification to Twilio, retrying under the sms retry policy, and moves
+// it to the status Twilio's final answer maps to: sent once accepted, retrying for rate
+// limits and outages, failed otherwise. Provider rejections are returned as *ProviderError.
+func (s *SMSService) Send(ctx context.Context, notification *models.Notification) error {
+	if err := faults.Inject(ctx, faults.OpChannelSMS); err != nil {
+		return fmt.Errorf("sms: %w", err)
+	}
+	if s.cfg.TwilioAccountSID == "" || s.cfg.TwilioAuthToken == "" || s.cfg.TwilioPhoneNumber == "" {
+		return fmt.Errorf("sms: %w", ErrChannelNotConfigured)
+	}
+
+	ctx, span := telemetry.Tracer.Start(ctx, "sms.send",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("notification.id", notification.ID),
+			attribute.String("notification.channel", string(models.NotificationTypeSMS)),
+			attribute.String("sms.provider", "twilio"),
+		),
+	)
+	defer span.End()
+	span.SetAttributes(regionAttributes(notification)...)
+
+	start := time.Now()
+	var message *twilioMessage
+	attempts, err := s.retries.Do(ctx, models.NotificationTypeSMS, s.provider, func(attempt int) error {
+		var err error
+		message, err = s.send(ctx, notification, attempt)
+		return err
+	})
+	span.SetAttributes(attribute.Int("sms.attempts", attempts))
+	notification.RetryCount = max(attempts-1, 0)
+	telemetry.RecordChannelDelivery(ctx, string(models.NotificationTypeSMS), notification.Region, err == nil, time.Since(start).Seconds())
+
+	now := time.Now().UTC()
+	if err != nil {
+		notification.Status = models.NotificationStatusFailed
+		var providerErr *ProviderError
+		if errors.As(err, &providerErr) {
+			notification.Status = providerErr.Status
+			span.SetAttributes(attribute.Int("twilio.error_code", providerErr.Code))
+		}
+		if notification.Status == models.NotificationStatusFailed {
+			notification.FailedAt = &now
+		}
+		notification.ErrorMessage = err.Error()
+		span.SetAttributes(attribute.String("notification.status", string(notification.Status)))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "SMS delivery failed")
+		return fmt.Errorf("sms: %w", err)
+	}
+
+	notification.Status = models.NotificationStatusSent
+	notification.SentAt = &now
+	if message.Status == "delivered" {
+		notification.Status = models.NotificationStatusDelivered
+		notification.DeliveredAt = &now
+	}
+	if notification.Metadata == nil {
+		notification.Metadata = map[string]interface{}{}
+	}
+	notification.Metadata["twilio_message_sid"] = message.SID
+	span.SetAttributes(
+		attribute.String("twilio.message_sid", message.SID),
+		attribute.String("twilio.status", message.Status),
+		attribute.String("notification.status", string(notification.Status)),
+	)
+	return nil
+}
+
+func (s *SMSService) send(ctx context.Context, notification *models.Notification, attempt int) (*twilioMessage, error) {
+	if !e164Pattern.MatchString(notification.Recipient) {
+		return nil, newProviderError(models.NotificationTypeSMS, 21211,
+			fmt.Sprintf("recipient %q is not an E.164 phone number", notification.Recipient), models.ErrorClassRejected)
+	}
+
+	endpoint := fmt.Sprintf("%s/2010-04-01/Accounts/%s/Messages.json",
+		strings.TrimRight(s.cfg.TwilioAPIBaseURL, "/"), url.PathEscape(s.cfg.TwilioAccountSID))
+	form := url.Values{
+		"To":   {notification.Recipient},
+		"From": {s.cfg.TwilioPhoneNumber},
+		"Body": {notification.Message},
+	}
+	requestBody := []byte(form.Encode())
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(requestBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(s.cfg.TwilioAccountSID, s.cfg.TwilioAuthToken)
+
+	start := time.Now()
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.capture(ctx, notification, attempt, req, requestBody, nil, nil, err, start)
+		return nil, newProviderError(models.NotificationTypeSMS, 0, err.Error(), ClassifyError(err))
+	}
+	defer resp.Body.Close()
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	s.capture(ctx, notification, attempt, req, requestBody, resp, body, err, start)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		var apiErr twilioError
+		if err := json.Unmarshal(body, &apiErr); err != nil || apiErr.Code == 0 {
+			apiErr = twilioError{Code: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		}
+		providerErr := newProviderError(models.NotificationTypeSMS, apiErr.Code, apiErr.Message, twilioClass(apiErr.Code, resp.StatusCode))
+		providerErr.RetryAfterDelay = ParseRetryAfter(resp.Header.Get("Retry-After"))
+		return nil, providerErr
+	}
+
+	var message twilioMessage
+	if err := json.Unmarshal(body, &message); err != nil {
+		return nil, fmt.Errorf("decoding Twilio response: %w", err)
+	}
+	if message.Status == "failed" || message.Status == "undelivered" {
+		code := 0
+		if message.ErrorCode != nil {
+			code = *message.ErrorCode
+		}
+		return nil, newProviderError(models.NotificationTypeSMS, code, message.ErrorMessage, twilioClass(code, resp.StatusCode))
+	}
+	return &message, nil
+}
+
+// capture records the Twilio exchange when the notification is sampled
+func (s *SMSService) capture(ctx context.Context, notification *models.Notification, attempt int, req *http.Request, requestBody []byte,
+	resp *http.Response, responseBody []byte, err error, start time.Time) {
+	if !s.sampler.Sampled(notification.ID) {
+		return
+	}
+
+	exchange := models.ProviderExchange{
+		Channel:    models.NotificationTypeSMS,
+		Provider:   "twilio",
+		Endpoint:   req.Method + " " + req.URL.String(),
+		Attempt:    attempt,
+		Request:    s.sampler.HTTPPayload(req.Header, requestBody),
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if resp != nil {
+		response := s.sampler.HTTPPayload(resp.Header, responseBody)
+		exchange.Response = &response
+		exchange.StatusCode = resp.StatusCode
+	}
+	if err != nil {
+		exchange.Error = err.Error()
+	}
+	s.sampler.Record(ctx, notification, exchange)
+}
+
+// twilioClass classifies a Twilio error code, falling back to the HTTP status
+func twilioClass(code, httpStatus int) models.ErrorClass {
+	if class, ok := twilioErrorClasses[code]; ok {
+		return class
+	}
+	switch {
+	case httpStatus == http.StatusTooManyRequests:
+		return models.ErrorClassThrottled
+	case httpStatus >= 500:
+		return models.ErrorClassServer
+	default:
+		return models.ErrorClassRejected
+	}
+}
+
+// VerifyTwilioSignature checks the X-Twilio-Signature of a request Twilio posted to
+// webhookURL: the base64 HMAC-SHA1, keyed with the auth token, of the URL followed by
+// each form parameter's name and value in name order
+func VerifyTwilioSignature(authToken, webhookURL string, params url.Values, signature string) bool {
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var data strings.Builder
+	data.WriteString(webhookURL)
+	for _, name := range names {
+		values := append([]string(nil), params[name]...)
+		sort.Strings(values)
+		for _, value := range values {
+			data.WriteString(name)
+			data.WriteString(value)
+		}
+	}
+
+	mac := hmac.New(sha1.New, []byte(authToken))
+	mac.Write([]byte(data.String()))
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
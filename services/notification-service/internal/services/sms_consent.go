@@ -0,0 +1,287 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Redis keys of the SMS opt-out list
+const (
+	// smsOptOutsKey holds the opt-out of each phone number that replied STOP
+	smsOptOutsKey = "sms-opt-outs"
+	// smsRecipientsKey mapped every phone number ever sent an SMS to its customer; it is
+	// only read, and emptied, for numbers not yet in an smsRecipientKey
+	smsRecipientsKey = "sms-recipients"
+)
+
+// smsRecipientTTL is how long a STOP reply can still reach the preferences of the
+// customer last sent an SMS at the number
+const smsRecipientTTL = 90 * 24 * time.Hour
+
+// smsRecipientKey holds the customer last sent an SMS at a phone number, so a STOP reply
+// can turn off that customer's SMS preference
+func smsRecipientKey(number string) string {
+	return "sms-recipient:" + number
+}
+
+// smsConsentStatsTTL keeps the hourly keyword counts for the longest statistics range
+const smsConsentStatsTTL = 8 * 24 * time.Hour
+
+// smsKeywords maps the keywords carriers and CTIA require to be honored to what they do.
+// A message is a keyword only when it is nothing else, so "stop sending me deals, I
+// moved" is left to a person.
+var smsKeywords = map[string]models.SMSKeyword{
+	"STOP":        models.SMSKeywordStop,
+	"STOPALL":     models.SMSKeywordStop,
+	"UNSUBSCRIBE": models.SMSKeywordStop,
+	"CANCEL":      models.SMSKeywordStop,
+	"END":         models.SMSKeywordStop,
+	"QUIT":        models.SMSKeywordStop,
+	"OPTOUT":      models.SMSKeywordStop,
+	"REVOKE":      models.SMSKeywordStop,
+	"START":       models.SMSKeywordStart,
+	"UNSTOP":      models.SMSKeywordStart,
+	"YES":         models.SMSKeywordStart,
+	"OPTIN":       models.SMSKeywordStart,
+	"HELP":        models.SMSKeywordHelp,
+	"INFO":        models.SMSKeywordHelp,
+}
+
+// smsConsentStatsKey holds the keyword counts of the hour starting at t
+func smsConsentStatsKey(t time.Time) string {
+	return "sms-consent-stats:" + t.UTC().Format("2006010215")
+}
+
+// SMSConsentService processes the keywords customers reply to SMS with. STOP puts the
+// number on the SMS opt-out list, which suppresses every later SMS to it, and turns off
+// the SMS preference of the customer last sent one there; START takes it off both. STOP,
+// START and HELP get the confirmation replies carriers require, and each keyword is
+// counted for the opt-out rate in the delivery statistics.
+type SMSConsentService struct {
+	redis       *RedisClient
+	preferences *CustomerPreferenceService
+	phones      *RecipientNormalizer
+	brand       string
+	helpContact string
+	replies     bool
+}
+
+func NewSMSConsentService(cfg *config.Config, redis *RedisClient, preferences *CustomerPreferenceService) *SMSConsentService {
+	return &SMSConsentService{
+		redis:       redis,
+		preferences: preferences,
+		phones:      NewRecipientNormalizer(cfg),
+		brand:       cfg.SMSBrandName,
+		helpContact: cfg.SMSHelpContact,
+		replies:     cfg.SMSKeywordReplies,
+	}
+}
+
+// ParseSMSKeyword returns the keyword a message consists of, ignoring case, spaces and
+// trailing punctuation, or "" when it is anything else
+func ParseSMSKeyword(body string) models.SMSKeyword {
+	word := strings.ToUpper(strings.TrimRight(strings.TrimSpace(body), ".!"))
+	word = strings.ReplaceAll(word, " ", "")
+	return smsKeywords[word]
+}
+
+// HandleInbound applies the keyword of an inbound SMS and returns the reply to send back,
+// which is empty for messages that aren't keywords or when SMS_KEYWORD_REPLIES is off
+func (s *SMSConsentService) HandleInbound(ctx context.Context, message models.InboundSMS) (*models.SMSKeywordResult, error) {
+	result := &models.SMSKeywordResult{Keyword: ParseSMSKeyword(message.Body)}
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.String("sms.keyword", string(result.Keyword)))
+	if result.Keyword == "" {
+		slog.InfoContext(ctx, "Received SMS reply without a keyword", "sms.message_sid", message.MessageSID)
+		return result, nil
+	}
+
+	number := smsNumber(s.phones, message.From)
+	customerID, err := s.recipient(ctx, number, message.From)
+	if err != nil {
+		return nil, err
+	}
+	result.CustomerID = customerID
+
+	switch result.Keyword {
+	case models.SMSKeywordStop:
+		optOut, err := json.Marshal(models.SMSOptOut{
+			PhoneNumber: number,
+			CustomerID:  customerID,
+			Keyword:     strings.ToUpper(strings.TrimSpace(message.Body)),
+			OptedOutAt:  time.Now().UTC(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := s.redis.client.HSet(ctx, smsOptOutsKey, number, optOut).Err(); err != nil {
+			return nil, fmt.Errorf("failed to record SMS opt-out: %w", err)
+		}
+	case models.SMSKeywordStart:
+		if err := s.redis.client.HDel(ctx, smsOptOutsKey, number, message.From).Err(); err != nil {
+			return nil, fmt.Errorf("failed to remove SMS opt-out: %w", err)
+		}
+	}
+	if customerID != "" && result.Keyword != models.SMSKeywordHelp {
+		if err := s.preferences.SetSMSEnabled(ctx, customerID, result.Keyword == models.SMSKeywordStart); err != nil {
+			// The opt-out list already keeps SMS from the number either way
+			slog.WarnContext(ctx, "Failed to update SMS preference from keyword", "customer.id", customerID, "sms.keyword", result.Keyword, "error", err)
+		}
+	}
+
+	s.count(ctx, result.Keyword)
+	telemetry.RecordSMSKeyword(ctx, string(result.Keyword))
+	slog.InfoContext(ctx, "📵 Processed SMS keyword", "sms.keyword", result.Keyword, "customer.id", customerID, "sms.message_sid", message.MessageSID)
+	if s.replies {
+		result.Reply = s.reply(result.Keyword)
+	}
+	return result, nil
+}
+
+// recipient returns the customer last sent an SMS at number, moving an entry of the old
+// unbounded map to its own expiring key
+func (s *SMSConsentService) recipient(ctx context.Context, number, raw string) (string, error) {
+	customerID, err := s.redis.client.Get(ctx, smsRecipientKey(number)).Result()
+	if err == nil {
+		return customerID, nil
+	}
+	if !errors.Is(err, redis.Nil) {
+		return "", fmt.Errorf("failed to look up SMS recipient: %w", err)
+	}
+
+	values, err := s.redis.client.HMGet(ctx, smsRecipientsKey, number, raw).Result()
+	if err != nil {
+		return "", fmt.Errorf("failed to look up SMS recipient: %w", err)
+	}
+	for _, value := range values {
+		if customerID, _ = value.(string); customerID != "" {
+			break
+		}
+	}
+	if customerID != "" {
+		_, err = s.redis.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, smsRecipientKey(number), customerID, smsRecipientTTL)
+			pipe.HDel(ctx, smsRecipientsKey, number, raw)
+			return nil
+		})
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to move SMS recipient", "customer.id", customerID, "error", err)
+		}
+	}
+	return customerID, nil
+}
+
+// SMSConsentStats counts the keywords received in the last 1h, 24h or 7d. The opt-out
+// rate is left to the caller, which knows how many SMS were sent.
+func (s *SMSConsentService) SMSConsentStats(ctx context.Context, timeRange string) (models.SMSConsentStats, error) {
+	window, ok := deliveryStatsRanges[timeRange]
+	if !ok {
+		return models.SMSConsentStats{}, fmt.Errorf("%w %q (expected 1h, 24h or 7d)", ErrInvalidTimeRange, timeRange)
+	}
+
+	now := time.Now().UTC().Truncate(time.Hour)
+	hours := int(window / time.Hour)
+	cmds := make([]*redis.SliceCmd, 0, hours)
+	_, err := s.redis.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i := 0; i < hours; i++ {
+			cmds = append(cmds, pipe.HMGet(ctx, smsConsentStatsKey(now.Add(-time.Duration(i)*time.Hour)),
+				string(models.SMSKeywordStop), string(models.SMSKeywordStart), string(models.SMSKeywordHelp)))
+		}
+		return nil
+	})
+	if err != nil {
+		return models.SMSConsentStats{}, fmt.Errorf("failed to read SMS keyword counts: %w", err)
+	}
+
+	var stats models.SMSConsentStats
+	for _, cmd := range cmds {
+		values := cmd.Val()
+		if len(values) != 3 {
+			continue
+		}
+		stats.OptOuts += parseCount(values[0])
+		stats.OptIns += parseCount(values[1])
+		stats.HelpRequests += parseCount(values[2])
+	}
+	return stats, nil
+}
+
+// count adds a keyword to the current hour's counts
+func (s *SMSConsentService) count(ctx context.Context, keyword models.SMSKeyword) {
+	key := smsConsentStatsKey(time.Now())
+	_, err := s.redis.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HIncrBy(ctx, key, string(keyword), 1)
+		pipe.Expire(ctx, key, smsConsentStatsTTL)
+		return nil
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to count SMS keyword", "sms.keyword", keyword, "error", err)
+	}
+}
+
+// reply is the confirmation carriers expect for each keyword: the brand, how to opt back
+// in or out, and for HELP where to get help
+func (s *SMSConsentService) reply(keyword models.SMSKeyword) string {
+	switch keyword {
+	case models.SMSKeywordStop:
+		return fmt.Sprintf("%s: You are unsubscribed and will receive no further messages. Reply START to resubscribe.", s.brand)
+	case models.SMSKeywordStart:
+		return fmt.Sprintf("%s: You are resubscribed to messages. Msg & data rates may apply. Reply HELP for help, STOP to unsubscribe.", s.brand)
+	case models.SMSKeywordHelp:
+		help := fmt.Sprintf("%s: Msg & data rates may apply. Reply STOP to unsubscribe.", s.brand)
+		if s.helpContact != "" {
+			help = fmt.Sprintf("%s: For help contact %s. Msg & data rates may apply. Reply STOP to unsubscribe.", s.brand, s.helpContact)
+		}
+		return help
+	default:
+		return ""
+	}
+}
+
+// smsNumber is the form the opt-out list keeps phone numbers in: E.164, as Twilio sends
+// them and RecipientNormalizer stores recipients, or the number as given when it can't
+// be parsed
+func smsNumber(phones *RecipientNormalizer, raw string) string {
+	if number, err := phones.NormalizePhone(raw); err == nil {
+		return number
+	}
+	return strings.TrimSpace(raw)
+}
+
+// checkSMSOptOut reports whether an SMS notification's number replied STOP, and
+// remembers its customer for smsRecipientTTL so a later STOP can reach their preferences
+func checkSMSOptOut(ctx context.Context, client *redis.Client, phones *RecipientNormalizer, notification *models.Notification) (bool, error) {
+	number := smsNumber(phones, notification.Recipient)
+	var exists *redis.BoolCmd
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		exists = pipe.HExists(ctx, smsOptOutsKey, number)
+		if notification.CustomerID != "" {
+			pipe.Set(ctx, smsRecipientKey(number), notification.CustomerID, smsRecipientTTL)
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return exists.Val(), nil
+}
+
+func parseCount(value interface{}) int64 {
+	text, _ := value.(string)
+	count, _ := strconv.ParseInt(text, 10, 64)
+	return count
+}
@@ -0,0 +1,97 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"notification-service/internal/models"
+)
+
+// statusTransitions lists the statuses a notification may move to from each status.
+// Delivered, cancelled, blocked, suppressed and duplicate_suppressed notifications are
+// settled and never change again. A failed one only leaves failed when it is retried or
+// re-driven.
+var statusTransitions = map[models.NotificationStatus][]models.NotificationStatus{
+	models.NotificationStatusPending: {
+		models.NotificationStatusSent,
+		models.NotificationStatusFailed,
+		models.NotificationStatusRetrying,
+		models.NotificationStatusSuppressed,
+		models.NotificationStatusCancelled,
+	},
+	// A batched notification goes out with its digest, or stays behind when cancelled
+	models.NotificationStatusBatched: {
+		models.NotificationStatusSent,
+		models.NotificationStatusFailed,
+		models.NotificationStatusRetrying,
+		models.NotificationStatusSuppressed,
+		models.NotificationStatusCancelled,
+	},
+	// A retry reports what the provider answered, which may already be a delivery
+	models.NotificationStatusRetrying: {
+		models.NotificationStatusSent,
+		models.NotificationStatusDelivered,
+		models.NotificationStatusFailed,
+		models.NotificationStatusRetrying,
+		models.NotificationStatusSuppressed,
+		models.NotificationStatusCancelled,
+	},
+	// A provider can still bounce a notification it accepted
+	models.NotificationStatusSent: {
+		models.NotificationStatusDelivered,
+		models.NotificationStatusFailed,
+		models.NotificationStatusRetrying,
+	},
+	// Requeued, or re-driven from the dead-letter queue
+	models.NotificationStatusFailed: {
+		models.NotificationStatusRetrying,
+		models.NotificationStatusSent,
+		models.NotificationStatusDelivered,
+	},
+}
+
+// canTransition reports whether a notification may move from one status to another
+func canTransition(from, to models.NotificationStatus) bool {
+	for _, next := range statusTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTransition returns ErrInvalidStatusTransition when a notification may not move
+// from one status to another
+func checkTransition(from, to models.NotificationStatus) error {
+	if canTransition(from, to) {
+		return nil
+	}
+	if len(statusTransitions[from]) == 0 {
+		return fmt.Errorf("%w: notification is already %s", ErrInvalidStatusTransition, from)
+	}
+	return fmt.Errorf("%w: %s notification can't become %s", ErrInvalidStatusTransition, from, to)
+}
+
+// stampStatus moves notification to status, recording when it was sent, delivered or
+// failed. A notification delivered without being reported sent first is stamped sent
+// at the same time; one sent again after failing no longer carries the failure time.
+func stampStatus(notification *models.Notification, status models.NotificationStatus, now time.Time) {
+	notification.Status = status
+	switch status {
+	case models.NotificationStatusSent:
+		notification.SentAt = &now
+		notification.FailedAt = nil
+	case models.NotificationStatusDelivered:
+		if notification.SentAt == nil {
+			notification.SentAt = &now
+		}
+		notification.DeliveredAt = &now
+		notification.FailedAt = nil
+	case models.NotificationStatusFailed:
+		notification.FailedAt = &now
+	case models.NotificationStatusRetrying:
+		notification.FailedAt = nil
+	case models.NotificationStatusCancelled:
+		notification.CancelledAt = &now
+	}
+}
@@ -0,0 +1,360 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/faults"
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrInvalidCard means a notification's Adaptive Card can't be posted to Teams
+var ErrInvalidCard = errors.New("invalid card")
+
+const (
+	adaptiveCardType        = "AdaptiveCard"
+	adaptiveCardContentType = "application/vnd.microsoft.card.adaptive"
+	adaptiveCardVersion     = "1.4"
+	adaptiveCardSchema      = "http://adaptivecards.io/schemas/adaptive-card.json"
+
+	// teamsMaxMessageBytes is the largest message Teams accepts from a webhook
+	teamsMaxMessageBytes = 28 << 10
+)
+
+// teamsMessage is the body an incoming webhook takes: a message with card attachments
+type teamsMessage struct {
+	Type        string            `json:"type"`
+	Attachments []teamsAttachment `json:"attachments"`
+}
+
+type teamsAttachment struct {
+	ContentType string          `json:"contentType"`
+	ContentURL  *string         `json:"contentUrl"`
+	Content     json.RawMessage `json:"content"`
+}
+
+// TeamsService posts notifications as Adaptive Cards to Microsoft Teams channels. A
+// teams notification's recipient names one of the incoming webhooks in
+// TEAMS_WEBHOOK_URLS; no other URL is ever posted to. The card is the notification's
+// own, rendered from its template's card layout, or else a default one showing the
+// subject and message.
+type TeamsService struct {
+	webhooks map[string]string
+	client   *http.Client
+	sampler  *ProviderPayloadSampler
+	retries  *RetryPolicies
+}
+
+func NewTeamsService(cfg *config.Config, sampler *ProviderPayloadSampler, retries *RetryPolicies) *TeamsService {
+	webhooks := make(map[string]string)
+	for _, entry := range strings.Split(cfg.TeamsWebhookURLs, ",") {
+		channel, target, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		channel, target = strings.TrimSpace(channel), strings.TrimSpace(target)
+		if !ok || channel == "" {
+			continue
+		}
+		if parsed, err := url.Parse(target); err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+			slog.Warn("Ignoring Teams webhook without an https URL", "teams.channel", channel)
+			continue
+		}
+		webhooks[channel] = target
+	}
+	return &TeamsService{
+		webhooks: webhooks,
+		client:   NewHTTPClient(cfg, ProviderTeams, 15*time.Second),
+		sampler:  sampler,
+		retries:  retries,
+	}
+}
+
+// Configured reports whether any Teams channel has a webhook
+func (s *TeamsService) Configured() bool {
+	return len(s.webhooks) > 0
+}
+
+// Send posts the notification's card to the Teams channel it is addressed to, retrying
+// under the teams retry policy. Teams keeps no delivery receipts, so a posted card is
+// delivered. Rejections are returned as *ProviderError.
+func (s *TeamsService) Send(ctx context.Context, notification *models.Notification) error {
+	if err := faults.Inject(ctx, faults.OpChannelTeams); err != nil {
+		return fmt.Errorf("teams: %w", err)
+	}
+	if !s.Configured() {
+		return fmt.Errorf("teams: %w", ErrChannelNotConfigured)
+	}
+
+	ctx, span := telemetry.Tracer.Start(ctx, "teams.send",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("notification.id", notification.ID),
+			attribute.String("notification.channel", string(models.NotificationTypeTeams)),
+			attribute.String("teams.channel", notification.Recipient),
+		),
+	)
+	defer span.End()
+	span.SetAttributes(regionAttributes(notification)...)
+
+	start := time.Now()
+	attempts := 0
+	body, err := s.message(notification)
+	if err == nil {
+		target := s.webhooks[notification.Recipient]
+		span.SetAttributes(attribute.String("server.address", hostname(target)))
+		attempts, err = s.retries.Do(ctx, models.NotificationTypeTeams, DeliveryTeams, func(attempt int) error {
+			return s.post(ctx, notification, target, body, attempt)
+		})
+	}
+	span.SetAttributes(attribute.Int("teams.attempts", attempts))
+	notification.RetryCount = max(attempts-1, 0)
+	telemetry.RecordChannelDelivery(ctx, string(models.NotificationTypeTeams), notification.Region, err == nil, time.Since(start).Seconds())
+
+	now := time.Now().UTC()
+	if err != nil {
+		notification.Status = models.NotificationStatusFailed
+		var providerErr *ProviderError
+		if errors.As(err, &providerErr) {
+			notification.Status = providerErr.Status
+		}
+		if notification.Status == models.NotificationStatusFailed {
+			notification.FailedAt = &now
+		}
+		notification.ErrorMessage = err.Error()
+		span.SetAttributes(attribute.String("notification.status", string(notification.Status)))
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Teams delivery failed")
+		return fmt.Errorf("teams: %w", err)
+	}
+
+	notification.Status = models.NotificationStatusDelivered
+	notification.SentAt = &now
+	notification.DeliveredAt = &now
+	span.SetAttributes(attribute.String("notification.status", string(notification.Status)))
+	return nil
+}
+
+// message builds the webhook body for a notification addressed to a configured channel
+func (s *TeamsService) message(notification *models.Notification) ([]byte, error) {
+	if _, ok := s.webhooks[notification.Recipient]; !ok {
+		return nil, newProviderError(models.NotificationTypeTeams, http.StatusNotFound,
+			fmt.Sprintf("no Teams webhook for channel %q", notification.Recipient), models.ErrorClassRejected)
+	}
+
+	card := notification.Card
+	if len(card) == 0 {
+		card = defaultCard(notification)
+	} else if !isAdaptiveCard(card) {
+		return nil, newProviderError(models.NotificationTypeTeams, http.StatusBadRequest,
+			"card must be a JSON object of type "+adaptiveCardType, models.ErrorClassRejected)
+	}
+
+	body, err := teamsBody(card)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > teamsMaxMessageBytes {
+		return nil, newProviderError(models.NotificationTypeTeams, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("message of %d bytes exceeds the Teams limit of %d", len(body), teamsMaxMessageBytes), models.ErrorClassRejected)
+	}
+	return body, nil
+}
+
+func teamsBody(card json.RawMessage) ([]byte, error) {
+	return json.Marshal(teamsMessage{
+		Type:        "message",
+		Attachments: []teamsAttachment{{ContentType: adaptiveCardContentType, Content: card}},
+	})
+}
+
+func isAdaptiveCard(card json.RawMessage) bool {
+	var layout map[string]interface{}
+	return json.Unmarshal(card, &layout) == nil && layout["type"] == adaptiveCardType
+}
+
+// ValidateCard checks the card a notification was given, or rendered from its template,
+// when it is created: a JSON object of type AdaptiveCard, on a teams notification,
+// whose message fits the Teams limit
+func ValidateCard(notification *models.Notification) error {
+	if len(notification.Card) == 0 {
+		return nil
+	}
+	if notification.Type != models.NotificationTypeTeams {
+		return fmt.Errorf("%w: only teams notifications take a card", ErrInvalidCard)
+	}
+	if !isAdaptiveCard(notification.Card) {
+		return fmt.Errorf("%w: must be a JSON object of type %s", ErrInvalidCard, adaptiveCardType)
+	}
+	body, err := teamsBody(notification.Card)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidCard, err)
+	}
+	if len(body) > teamsMaxMessageBytes {
+		return fmt.Errorf("%w: message of %d bytes exceeds the Teams limit of %d", ErrInvalidCard, len(body), teamsMaxMessageBytes)
+	}
+	return nil
+}
+
+// cardTextProperties are the Adaptive Card properties holding text a reader sees
+var cardTextProperties = map[string]bool{
+	"text": true, "title": true, "value": true, "label": true, "altText": true,
+	"placeholder": true, "fallbackText": true, "errorMessage": true, "speak": true,
+}
+
+// CardText returns the text a reader of a card sees, one property per line, so it can be
+// screened with the rest of the notification's content
+func CardText(card json.RawMessage) string {
+	if len(card) == 0 {
+		return ""
+	}
+	var layout interface{}
+	if err := json.Unmarshal(card, &layout); err != nil {
+		return ""
+	}
+	var text []string
+	var walk func(value interface{})
+	walk = func(value interface{}) {
+		switch value := value.(type) {
+		case map[string]interface{}:
+			for key, child := range value {
+				if s, ok := child.(string); ok {
+					if cardTextProperties[key] && s != "" {
+						text = append(text, s)
+					}
+					continue
+				}
+				walk(child)
+			}
+		case []interface{}:
+			for _, child := range value {
+				walk(child)
+			}
+		}
+	}
+	walk(layout)
+	return strings.Join(text, "\n")
+}
+
+// defaultCard lays out a notification without a card of its own: the subject as a
+// heading, the message, and the order and priority as facts
+func defaultCard(notification *models.Notification) json.RawMessage {
+	body := []map[string]interface{}{}
+	if notification.Subject != "" {
+		body = append(body, map[string]interface{}{"type": "TextBlock", "text": notification.Subject, "weight": "Bolder", "size": "Medium", "wrap": true})
+	}
+	body = append(body, map[string]interface{}{"type": "TextBlock", "text": notification.Message, "wrap": true})
+
+	facts := []map[string]string{}
+	if notification.OrderID != "" {
+		facts = append(facts, map[string]string{"title": "Order", "value": notification.OrderID})
+	}
+	if notification.Priority != "" {
+		facts = append(facts, map[string]string{"title": "Priority", "value": string(notification.Priority)})
+	}
+	if len(facts) > 0 {
+		body = append(body, map[string]interface{}{"type": "FactSet", "facts": facts})
+	}
+
+	card, _ := json.Marshal(map[string]interface{}{
+		"type":    adaptiveCardType,
+		"$schema": adaptiveCardSchema,
+		"version": adaptiveCardVersion,
+		"body":    body,
+	})
+	return card
+}
+
+func (s *TeamsService) post(ctx context.Context, notification *models.Notification, target string, body []byte, attempt int) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	start := time.Now()
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.capture(ctx, notification, attempt, req, body, nil, nil, err, start)
+		return newProviderError(models.NotificationTypeTeams, 0, err.Error(), ClassifyError(err))
+	}
+	defer resp.Body.Close()
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+
+	responseBody, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	s.capture(ctx, notification, attempt, req, body, resp, responseBody, err, start)
+	if err != nil {
+		return err
+	}
+
+	status := resp.StatusCode
+	// Connector webhooks answer 200 with the error in the body when Teams rejects a message
+	if status < 300 && strings.Contains(string(responseBody), "HTTP error 429") {
+		status = http.StatusTooManyRequests
+	}
+	if status < 300 {
+		return nil
+	}
+	message := strings.TrimSpace(string(responseBody))
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	class := models.ErrorClassRejected
+	switch {
+	case status == http.StatusTooManyRequests:
+		class = models.ErrorClassThrottled
+	case status >= 500:
+		class = models.ErrorClassServer
+	}
+	providerErr := newProviderError(models.NotificationTypeTeams, status, message, class)
+	providerErr.RetryAfterDelay = ParseRetryAfter(resp.Header.Get("Retry-After"))
+	return providerErr
+}
+
+// capture records the Teams exchange when the notification is sampled. Webhook URLs
+// carry their own credentials, so only the host is recorded.
+func (s *TeamsService) capture(ctx context.Context, notification *models.Notification, attempt int, req *http.Request, requestBody []byte,
+	resp *http.Response, responseBody []byte, err error, start time.Time) {
+	if !s.sampler.Sampled(notification.ID) {
+		return
+	}
+
+	exchange := models.ProviderExchange{
+		Channel:    models.NotificationTypeTeams,
+		Provider:   "teams",
+		Endpoint:   req.Method + " " + req.URL.Host,
+		Attempt:    attempt,
+		Request:    s.sampler.HTTPPayload(req.Header, requestBody),
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if resp != nil {
+		response := s.sampler.HTTPPayload(resp.Header, responseBody)
+		exchange.Response = &response
+		exchange.StatusCode = resp.StatusCode
+	}
+	if err != nil {
+		exchange.Error = err.Error()
+	}
+	s.sampler.Record(ctx, notification, exchange)
+}
+
+// hostname returns the host of a URL, which unlike the URL itself is safe to record
+func hostname(target string) string {
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return ""
+	}
+	return parsed.Hostname()
+}
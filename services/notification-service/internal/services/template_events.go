@@ -0,0 +1,140 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/telemetry"
+
+	"github.com/google/uuid"
+)
+
+// Template change event types, published as CloudEvents so both plain webhooks and
+// Event Grid topics can receive them
+const (
+	TemplateEventCreated          = "com.otel-demo.notification.template.created"
+	TemplateEventPublishRequested = "com.otel-demo.notification.template.publish_requested"
+	TemplateEventPublished        = "com.otel-demo.notification.template.published"
+	TemplateEventRejected         = "com.otel-demo.notification.template.rejected"
+	TemplateEventRolledBack       = "com.otel-demo.notification.template.rolled_back"
+)
+
+// CloudEvent is a CloudEvents 1.0 envelope
+type CloudEvent struct {
+	SpecVersion     string      `json:"specversion"`
+	Type            string      `json:"type"`
+	Source          string      `json:"source"`
+	ID              string      `json:"id"`
+	Time            time.Time   `json:"time"`
+	Subject         string      `json:"subject"`
+	DataContentType string      `json:"datacontenttype"`
+	Data            interface{} `json:"data"`
+}
+
+// TemplateEventPublisher posts template change events to an external webhook or Event Grid topic
+type TemplateEventPublisher struct {
+	url          string
+	secret       string
+	eventGridKey string
+	client       *http.Client
+}
+
+func NewTemplateEventPublisher(cfg *config.Config) *TemplateEventPublisher {
+	return &TemplateEventPublisher{
+		url:          cfg.TemplateEventsWebhookURL,
+		secret:       cfg.TemplateEventsWebhookSecret,
+		eventGridKey: cfg.TemplateEventsEventGridKey,
+		client:       NewHTTPClient(cfg, ProviderTemplateEvents, 10*time.Second),
+	}
+}
+
+// Publish sends an event in the background; delivery never blocks template changes
+func (p *TemplateEventPublisher) Publish(ctx context.Context, eventType, templateID string, data interface{}) {
+	if p.url == "" {
+		return
+	}
+
+	event := CloudEvent{
+		SpecVersion:     "1.0",
+		Type:            eventType,
+		Source:          "/notification-service/templates",
+		ID:              uuid.New().String(),
+		Time:            time.Now().UTC(),
+		Subject:         "templates/" + templateID,
+		DataContentType: "application/json",
+		Data:            data,
+	}
+
+	go p.deliver(context.WithoutCancel(ctx), event)
+}
+
+func (p *TemplateEventPublisher) deliver(ctx context.Context, event CloudEvent) {
+	// Event Grid's CloudEvents endpoint expects a batch array
+	body, err := json.Marshal([]CloudEvent{event})
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to marshal template event", "error", err)
+		return
+	}
+
+	backoff := time.Second
+	for attempt := 1; attempt <= 3; attempt++ {
+		err = p.post(ctx, body)
+		if err == nil {
+			break
+		}
+		slog.WarnContext(ctx, "Template event delivery attempt failed", "event.type", event.Type, "retry.attempt", attempt, "error", err)
+		if attempt < 3 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+
+	telemetry.RecordTemplateEvent(ctx, event.Type, err == nil)
+}
+
+func (p *TemplateEventPublisher) post(ctx context.Context, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/cloudevents-batch+json; charset=utf-8")
+	if p.secret != "" {
+		req.Header.Set("X-Signature", "sha256="+SignPayload(p.secret, body))
+	}
+	if p.eventGridKey != "" {
+		req.Header.Set("aeg-sas-key", p.eventGridKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// SignPayload returns the hex HMAC-SHA256 of a payload
+func SignPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks an "sha256=<hex>" signature header against a payload
+func VerifySignature(secret string, payload []byte, header string) bool {
+	expected := "sha256=" + SignPayload(secret, payload)
+	return hmac.Equal([]byte(expected), []byte(header))
+}
@@ -0,0 +1,321 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"notification-service/internal/cache"
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	// ErrInvalidTemplateSyntax is returned when a template's subject or body does not parse
+	ErrInvalidTemplateSyntax = errors.New("invalid template syntax")
+	// ErrTemplateInactive is returned when a notification uses a template that was never published
+	ErrTemplateInactive = errors.New("template has not been published")
+)
+
+// TemplateRenderError reports why a notification's data could not fill its template:
+// declared variables missing from data, or a reference the data does not satisfy
+type TemplateRenderError struct {
+	TemplateID       string   `json:"template_id"`
+	MissingVariables []string `json:"missing_variables,omitempty"`
+	Reason           string   `json:"reason,omitempty"`
+}
+
+func (e *TemplateRenderError) Error() string {
+	if len(e.MissingVariables) > 0 {
+		return fmt.Sprintf("template %s is missing variables: %s", e.TemplateID, strings.Join(e.MissingVariables, ", "))
+	}
+	return fmt.Sprintf("template %s failed to render: %s", e.TemplateID, e.Reason)
+}
+
+// parsedTemplate is the parsed subject and body of one template version, with its
+// localizations parsed the same way, and its Adaptive Card layout with each string
+// value that holds an action parsed
+type parsedTemplate struct {
+	subject   *template.Template
+	body      *template.Template
+	localized map[string]*parsedTemplate
+	card      interface{}
+}
+
+// newRenderCache holds parsed templates in memory only, like compiled schemas
+func newRenderCache() *cache.Cache[*parsedTemplate] {
+	return cache.New[*parsedTemplate](nil, cache.Options{
+		Name:       "template-render",
+		Mode:       cache.ReadThrough,
+		L1TTL:      10 * time.Minute,
+		L1MaxItems: 1000,
+	})
+}
+
+// parseTemplate parses the subject and body as Go text/template, with {{.variable}}
+// resolving against the notification's data. Referencing data the notification lacks
+// is an error rather than rendering "<no value>".
+func parseTemplate(tmpl *models.NotificationTemplate) (*parsedTemplate, error) {
+	parsed, err := parseContent(tmpl.Subject, tmpl.Body)
+	if err != nil {
+		return nil, err
+	}
+	if len(tmpl.Localizations) > 0 {
+		parsed.localized = make(map[string]*parsedTemplate, len(tmpl.Localizations))
+	}
+	for locale, localization := range tmpl.Localizations {
+		localized, err := parseContent(localization.Subject, localization.Body)
+		if err != nil {
+			return nil, fmt.Errorf("locale %s: %w", locale, err)
+		}
+		parsed.localized[locale] = localized
+	}
+	if len(tmpl.Card) > 0 {
+		if parsed.card, err = parseCard(tmpl.Card); err != nil {
+			return nil, err
+		}
+	}
+	return parsed, nil
+}
+
+// parseCard parses an Adaptive Card layout: a JSON object of type AdaptiveCard whose
+// string values may hold template actions
+func parseCard(card json.RawMessage) (interface{}, error) {
+	var layout map[string]interface{}
+	if err := json.Unmarshal(card, &layout); err != nil {
+		return nil, fmt.Errorf("%w: card: %v", ErrInvalidTemplateSyntax, err)
+	}
+	if layout["type"] != adaptiveCardType {
+		return nil, fmt.Errorf("%w: card: type must be %s", ErrInvalidTemplateSyntax, adaptiveCardType)
+	}
+	return parseCardValue(layout)
+}
+
+func parseCardValue(value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case string:
+		if !strings.Contains(value, "{{") {
+			return value, nil
+		}
+		parsed, err := template.New("card").Option("missingkey=error").Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: card: %v", ErrInvalidTemplateSyntax, err)
+		}
+		return parsed, nil
+	case map[string]interface{}:
+		parsed := make(map[string]interface{}, len(value))
+		for key, field := range value {
+			var err error
+			if parsed[key], err = parseCardValue(field); err != nil {
+				return nil, err
+			}
+		}
+		return parsed, nil
+	case []interface{}:
+		parsed := make([]interface{}, len(value))
+		for i, item := range value {
+			var err error
+			if parsed[i], err = parseCardValue(item); err != nil {
+				return nil, err
+			}
+		}
+		return parsed, nil
+	default:
+		return value, nil
+	}
+}
+
+// renderCardValue fills a parsed card layout with data
+func renderCardValue(value interface{}, data map[string]interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case *template.Template:
+		return renderText(value, data)
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(value))
+		for key, field := range value {
+			var err error
+			if rendered[key], err = renderCardValue(field, data); err != nil {
+				return nil, err
+			}
+		}
+		return rendered, nil
+	case []interface{}:
+		rendered := make([]interface{}, len(value))
+		for i, item := range value {
+			var err error
+			if rendered[i], err = renderCardValue(item, data); err != nil {
+				return nil, err
+			}
+		}
+		return rendered, nil
+	default:
+		return value, nil
+	}
+}
+
+func parseContent(subjectText, bodyText string) (*parsedTemplate, error) {
+	subject, err := template.New("subject").Option("missingkey=error").Parse(subjectText)
+	if err != nil {
+		return nil, fmt.Errorf("%w: subject: %v", ErrInvalidTemplateSyntax, err)
+	}
+	body, err := template.New("body").Option("missingkey=error").Parse(bodyText)
+	if err != nil {
+		return nil, fmt.Errorf("%w: body: %v", ErrInvalidTemplateSyntax, err)
+	}
+	return &parsedTemplate{subject: subject, body: body}, nil
+}
+
+// RenderNotification fills a notification's subject and message from its template and
+// data, from the version the notification is pinned to or else the current one, and
+// records the version used. The template must be published, and every declared
+// variable present in data.
+// A subject or message given explicitly on the request is kept, and so is the card of
+// a teams notification, which is otherwise rendered from the template's card. Notifications without
+// a template are left as they are. Localized templates render in the locale closest to
+// the customer's language, which is recorded on the notification. With machine
+// translation on, a template none of whose locales speak the customer's language is
+// translated into it, and the notification's metadata says so.
+func (s *TemplateService) RenderNotification(ctx context.Context, notification *models.Notification) error {
+	if notification.TemplateID == "" {
+		return nil
+	}
+
+	ctx, span := telemetry.Tracer.Start(ctx, "template.render")
+	defer span.End()
+	span.SetAttributes(attribute.String("template.id", notification.TemplateID))
+
+	tmpl, key, err := s.notificationTemplate(ctx, notification)
+	if err != nil {
+		return err
+	}
+	span.SetAttributes(attribute.Int("template.version", tmpl.Version))
+	if !tmpl.IsActive {
+		return fmt.Errorf("%w: %s", ErrTemplateInactive, tmpl.ID)
+	}
+
+	if missing := missingVariables(tmpl.Variables, notification.Data); len(missing) > 0 {
+		span.SetStatus(codes.Error, "missing template variables")
+		span.SetAttributes(attribute.StringSlice("template.missing_variables", missing))
+		return &TemplateRenderError{TemplateID: tmpl.ID, MissingVariables: missing}
+	}
+
+	parsed, err := s.rendered.Get(ctx, key, func(context.Context) (*parsedTemplate, error) {
+		return parseTemplate(tmpl)
+	})
+	if err != nil {
+		return err
+	}
+	card := parsed.card
+
+	if len(tmpl.Localizations) > 0 || s.translations.Enabled() {
+		language, source := s.languages.CustomerLanguage(ctx, notification.CustomerID, languageDetectionText(notification))
+		notification.Locale = closestLocale(tmpl, language)
+		if localized, ok := parsed.localized[notification.Locale]; ok {
+			parsed = localized
+		}
+		if translated := s.machineTranslation(ctx, tmpl, key, notification.Locale, language); translated != nil {
+			parsed = translated
+			if notification.Metadata == nil {
+				notification.Metadata = map[string]interface{}{}
+			}
+			notification.Metadata[MachineTranslatedMetadata] = true
+			notification.Metadata[TranslatedFromMetadata] = templateLocale(tmpl)
+			notification.Locale = language
+		}
+		span.SetAttributes(
+			attribute.String("template.locale", notification.Locale),
+			attribute.String("language.source", source),
+		)
+	}
+
+	data := notification.Data
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	subject, err := renderText(parsed.subject, data)
+	if err != nil {
+		span.SetStatus(codes.Error, "template render failed")
+		return &TemplateRenderError{TemplateID: tmpl.ID, Reason: err.Error()}
+	}
+	body, err := renderText(parsed.body, data)
+	if err != nil {
+		span.SetStatus(codes.Error, "template render failed")
+		return &TemplateRenderError{TemplateID: tmpl.ID, Reason: err.Error()}
+	}
+
+	if notification.Type == models.NotificationTypeTeams && card != nil && len(notification.Card) == 0 {
+		rendered, err := renderCardValue(card, data)
+		if err != nil {
+			span.SetStatus(codes.Error, "template render failed")
+			return &TemplateRenderError{TemplateID: tmpl.ID, Reason: "card: " + err.Error()}
+		}
+		if notification.Card, err = json.Marshal(rendered); err != nil {
+			return err
+		}
+	}
+
+	notification.TemplateVersion = tmpl.Version
+	if notification.Subject == "" {
+		notification.Subject = subject
+	}
+	if notification.Message == "" {
+		notification.Message = body
+	}
+	return nil
+}
+
+// machineTranslation returns the template machine-translated into language when
+// translation is on and the closest locale found doesn't speak it, or nil to render in
+// that locale. A failed translation is logged and the notification goes out untranslated.
+func (s *TemplateService) machineTranslation(ctx context.Context, tmpl *models.NotificationTemplate, key, locale, language string) *parsedTemplate {
+	if !s.translations.Enabled() || language == "" || primaryLanguage(locale) == primaryLanguage(language) {
+		return nil
+	}
+	ctx, span := telemetry.Tracer.Start(ctx, "template.translate", trace.WithAttributes(attribute.String("translation.to", language)))
+	defer span.End()
+
+	parsed, err := s.rendered.Get(ctx, key+":translated:"+strings.ToLower(language), func(ctx context.Context) (*parsedTemplate, error) {
+		translation, err := s.translations.Translate(ctx, tmpl, language)
+		if err != nil {
+			return nil, err
+		}
+		return parseContent(translation.Subject, translation.Body)
+	})
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "template translation failed")
+		slog.WarnContext(ctx, "Rendering template untranslated", "template.id", tmpl.ID, "template.locale", locale, "translation.to", language, "error", err)
+		return nil
+	}
+	return parsed
+}
+
+func renderText(tmpl *template.Template, data map[string]interface{}) (string, error) {
+	var out strings.Builder
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// missingVariables lists the declared variables absent from data, sorted
+func missingVariables(variables []string, data map[string]interface{}) []string {
+	var missing []string
+	for _, variable := range variables {
+		if value, ok := data[variable]; !ok || value == nil {
+			missing = append(missing, variable)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
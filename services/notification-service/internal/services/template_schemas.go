@@ -0,0 +1,150 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"notification-service/internal/cache"
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// ErrInvalidTemplateSchema is returned when a template declares a schema that does not compile
+var ErrInvalidTemplateSchema = errors.New("invalid template schema")
+
+// SchemaViolation is one failed rule, located by a JSON pointer into the field
+type SchemaViolation struct {
+	Field   string `json:"field"`
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// SchemaValidationError lists every way a notification's data or metadata fails its
+// template's declared schemas
+type SchemaValidationError struct {
+	TemplateID string            `json:"template_id"`
+	Violations []SchemaViolation `json:"violations"`
+}
+
+func (e *SchemaValidationError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		messages = append(messages, fmt.Sprintf("%s%s: %s", v.Field, v.Path, v.Message))
+	}
+	return fmt.Sprintf("notification does not match template %s schema: %s", e.TemplateID, strings.Join(messages, "; "))
+}
+
+// templateSchemas are the compiled schemas of one template version
+type templateSchemas struct {
+	data     *jsonschema.Schema
+	metadata *jsonschema.Schema
+}
+
+// newSchemaCache holds compiled schemas in memory only; they are cheap to rebuild and
+// not serializable
+func newSchemaCache() *cache.Cache[*templateSchemas] {
+	return cache.New[*templateSchemas](nil, cache.Options{
+		Name:       "template-schemas",
+		Mode:       cache.ReadThrough,
+		L1TTL:      10 * time.Minute,
+		L1MaxItems: 1000,
+	})
+}
+
+func compileTemplateSchemas(template *models.NotificationTemplate) (*templateSchemas, error) {
+	data, err := compileSchema("data", template.DataSchema)
+	if err != nil {
+		return nil, err
+	}
+	metadata, err := compileSchema("metadata", template.MetadataSchema)
+	if err != nil {
+		return nil, err
+	}
+	return &templateSchemas{data: data, metadata: metadata}, nil
+}
+
+func compileSchema(field string, raw json.RawMessage) (*jsonschema.Schema, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	url := field + ".schema.json"
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(url, bytes.NewReader(raw)); err != nil {
+		return nil, fmt.Errorf("%w: %s_schema: %v", ErrInvalidTemplateSchema, field, err)
+	}
+	schema, err := compiler.Compile(url)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s_schema: %v", ErrInvalidTemplateSchema, field, err)
+	}
+	return schema, nil
+}
+
+// ValidateNotification checks a notification's data and metadata against the schemas
+// declared by its template. Notifications without a template are not checked.
+func (s *TemplateService) ValidateNotification(ctx context.Context, notification *models.Notification) error {
+	if notification.TemplateID == "" {
+		return nil
+	}
+
+	template, key, err := s.notificationTemplate(ctx, notification)
+	if err != nil {
+		return err
+	}
+	schemas, err := s.schemas.Get(ctx, key, func(context.Context) (*templateSchemas, error) {
+		return compileTemplateSchemas(template)
+	})
+	if err != nil {
+		return err
+	}
+
+	var violations []SchemaViolation
+	violations = append(violations, validateField(ctx, template.ID, "data", schemas.data, notification.Data)...)
+	violations = append(violations, validateField(ctx, template.ID, "metadata", schemas.metadata, notification.Metadata)...)
+	if len(violations) > 0 {
+		return &SchemaValidationError{TemplateID: template.ID, Violations: violations}
+	}
+	return nil
+}
+
+func validateField(ctx context.Context, templateID, field string, schema *jsonschema.Schema, value map[string]interface{}) []SchemaViolation {
+	if schema == nil {
+		return nil
+	}
+
+	var instance interface{} = map[string]interface{}{}
+	if value != nil {
+		instance = value
+	}
+
+	err := schema.Validate(instance)
+	if err == nil {
+		return nil
+	}
+
+	telemetry.RecordSchemaRejection(ctx, templateID, field)
+
+	var validationErr *jsonschema.ValidationError
+	if !errors.As(err, &validationErr) {
+		return []SchemaViolation{{Field: field, Path: "", Message: err.Error()}}
+	}
+	return leafViolations(field, validationErr, nil)
+}
+
+// leafViolations flattens the error tree to its leaves, which name the specific failures
+func leafViolations(field string, err *jsonschema.ValidationError, violations []SchemaViolation) []SchemaViolation {
+	if len(err.Causes) == 0 {
+		return append(violations, SchemaViolation{Field: field, Path: err.InstanceLocation, Message: err.Message})
+	}
+	for _, cause := range err.Causes {
+		violations = leafViolations(field, cause, violations)
+	}
+	return violations
+}
@@ -0,0 +1,315 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"notification-service/internal/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	ErrTemplateVersionNotFound     = errors.New("template version not found")
+	ErrTemplateVersionNotPublished = errors.New("template version was never published")
+)
+
+// templateDiffMaxLines bounds the subject and body line diffs; longer texts are only
+// reported as changed
+const templateDiffMaxLines = 2000
+
+// templateVersionsKey holds a template's versions by number, kept outside the template:
+// prefix so storage scans only see current templates
+func templateVersionsKey(id string) string {
+	return "template-versions:" + id
+}
+
+// Versions returns a template's versions, newest first, marking the published one
+func (s *TemplateService) Versions(ctx context.Context, id string) ([]*models.TemplateVersion, error) {
+	template, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	values, err := s.redis.client.HGetAll(ctx, templateVersionsKey(id)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load template versions: %w", err)
+	}
+
+	versions := make([]*models.TemplateVersion, 0, len(values))
+	for field, value := range values {
+		var version models.TemplateVersion
+		if err := json.Unmarshal([]byte(value), &version); err != nil {
+			return nil, fmt.Errorf("failed to decode template version %s: %w", field, err)
+		}
+		version.Published = version.Version == template.PublishedVersion
+		versions = append(versions, &version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version > versions[j].Version })
+	return versions, nil
+}
+
+// isPublishedVersion reports whether a version of the template was ever published
+func isPublishedVersion(ctx context.Context, client redis.Cmdable, template *models.NotificationTemplate, number int) (bool, error) {
+	if number == template.PublishedVersion {
+		return true, nil
+	}
+	err := client.ZScore(ctx, templatePublishedKey(template.ID), strconv.Itoa(number)).Err()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to load published template versions: %w", err)
+	}
+	return true, nil
+}
+
+// backfillVersion gives a template saved before versioning its version 1, published
+// when the template was active, so its history, diffs and rollbacks work
+func (s *TemplateService) backfillVersion(ctx context.Context, id string) (*models.NotificationTemplate, error) {
+	var template *models.NotificationTemplate
+	err := watchKey(ctx, s.redis.client, templateKey(id), func(tx *redis.Tx) error {
+		var err error
+		if template, err = loadTemplate(ctx, tx, templateKey(id)); err != nil || template.Version != 0 {
+			return err
+		}
+		template.Version = 1
+		if !template.IsActive {
+			return saveTemplateVersion(ctx, tx, template, 0, nil)
+		}
+		if template.PublishedAt == nil {
+			publishedAt := template.UpdatedAt
+			template.PublishedAt = &publishedAt
+		}
+		template.State = models.TemplateStatePublished
+		template.PublishedVersion = 1
+		return saveTemplateVersion(ctx, tx, template, 0, func(pipe redis.Pipeliner) {
+			recordPublished(ctx, pipe, template)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return template, nil
+}
+
+// Version returns one version of a template
+func (s *TemplateService) Version(ctx context.Context, id string, number int) (*models.TemplateVersion, error) {
+	return loadTemplateVersion(ctx, s.redis.client, id, number)
+}
+
+func loadTemplateVersion(ctx context.Context, client redis.Cmdable, id string, number int) (*models.TemplateVersion, error) {
+	payload, err := client.HGet(ctx, templateVersionsKey(id), strconv.Itoa(number)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("%w: %s version %d", ErrTemplateVersionNotFound, id, number)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load template version: %w", err)
+	}
+	var version models.TemplateVersion
+	if err := json.Unmarshal(payload, &version); err != nil {
+		return nil, fmt.Errorf("failed to decode template version: %w", err)
+	}
+	return &version, nil
+}
+
+// Diff compares two versions of a template. A to of 0 is the current version, and a
+// from of 0 the version before to.
+func (s *TemplateService) Diff(ctx context.Context, id string, from, to int) (*models.TemplateDiff, error) {
+	if to == 0 {
+		template, err := s.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		to = template.Version
+	}
+	if from == 0 {
+		from = to - 1
+	}
+	older, err := s.Version(ctx, id, from)
+	if err != nil {
+		return nil, err
+	}
+	newer, err := s.Version(ctx, id, to)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &models.TemplateDiff{TemplateID: id, From: from, To: to, Changes: map[string]models.FieldChange{}}
+	before, after := templateContent(older), templateContent(newer)
+	for field := range before {
+		if _, ok := after[field]; !ok {
+			after[field] = nil
+		}
+	}
+	for field, value := range after {
+		if !reflect.DeepEqual(before[field], value) {
+			diff.Changes[field] = models.FieldChange{From: before[field], To: value}
+		}
+	}
+	if older.Subject != newer.Subject {
+		diff.SubjectDiff = lineDiff(older.Subject, newer.Subject)
+	}
+	if older.Body != newer.Body {
+		diff.BodyDiff = lineDiff(older.Body, newer.Body)
+	}
+	return diff, nil
+}
+
+// notificationTemplate returns the template a notification renders from, with the key
+// its compiled forms are cached under: the version the notification is pinned to, or
+// else the published version, so edits awaiting approval don't go live. Only versions
+// that were published can be pinned. A template that was never published is returned
+// as is, and is inactive.
+func (s *TemplateService) notificationTemplate(ctx context.Context, notification *models.Notification) (*models.NotificationTemplate, string, error) {
+	template, err := s.Get(ctx, notification.TemplateID)
+	if err != nil {
+		return nil, "", err
+	}
+	number := notification.TemplateVersion
+	if number == 0 {
+		number = template.PublishedVersion
+	} else if published, err := isPublishedVersion(ctx, s.redis.client, template, number); err != nil {
+		return nil, "", err
+	} else if !published {
+		return nil, "", fmt.Errorf("%w: %s version %d", ErrTemplateVersionNotPublished, template.ID, number)
+	}
+	if number == 0 || number == template.Version {
+		// Keyed by update time so a published edit takes effect immediately
+		return template, fmt.Sprintf("%s:%d", template.ID, template.UpdatedAt.UnixNano()), nil
+	}
+
+	version, err := s.Version(ctx, template.ID, number)
+	if err != nil {
+		return nil, "", err
+	}
+	pinned := *template
+	applyTemplateVersion(&pinned, version)
+	pinned.Version = version.Version
+	// Versions never change, so their number is enough
+	return &pinned, fmt.Sprintf("%s:v%d", template.ID, version.Version), nil
+}
+
+// templateSnapshot is the version a template's current content is saved as
+func templateSnapshot(template *models.NotificationTemplate, rolledBackFrom int) *models.TemplateVersion {
+	return &models.TemplateVersion{
+		TemplateID:     template.ID,
+		Version:        template.Version,
+		Name:           template.Name,
+		Type:           template.Type,
+		Subject:        template.Subject,
+		Body:           template.Body,
+		Variables:      template.Variables,
+		Metadata:       template.Metadata,
+		DataSchema:     template.DataSchema,
+		MetadataSchema: template.MetadataSchema,
+		Locale:         template.Locale,
+		Localizations:  template.Localizations,
+		Card:           template.Card,
+		CreatedAt:      template.UpdatedAt,
+		RolledBackFrom: rolledBackFrom,
+	}
+}
+
+// applyTemplateVersion gives a template the content of one of its versions
+func applyTemplateVersion(template *models.NotificationTemplate, version *models.TemplateVersion) {
+	template.Name = version.Name
+	template.Type = version.Type
+	template.Subject = version.Subject
+	template.Body = version.Body
+	template.Variables = version.Variables
+	template.Metadata = version.Metadata
+	template.DataSchema = version.DataSchema
+	template.MetadataSchema = version.MetadataSchema
+	template.Locale = version.Locale
+	template.Localizations = version.Localizations
+	template.Card = version.Card
+}
+
+// saveTemplateVersion saves a template together with its current version in tx, and
+// whatever write adds. Versions are never overwritten: a number already taken fails
+// with ErrTemplateChanged.
+func saveTemplateVersion(ctx context.Context, tx *redis.Tx, template *models.NotificationTemplate, rolledBackFrom int, write func(pipe redis.Pipeliner)) error {
+	field := strconv.Itoa(template.Version)
+	taken, err := tx.HExists(ctx, templateVersionsKey(template.ID), field).Result()
+	if err != nil {
+		return fmt.Errorf("failed to load template versions: %w", err)
+	}
+	if taken {
+		return fmt.Errorf("%w: version %d already exists", ErrTemplateChanged, template.Version)
+	}
+	version, err := json.Marshal(templateSnapshot(template, rolledBackFrom))
+	if err != nil {
+		return err
+	}
+	return storeTemplate(ctx, tx, template, func(pipe redis.Pipeliner) {
+		pipe.HSet(ctx, templateVersionsKey(template.ID), field, version)
+		if write != nil {
+			write(pipe)
+		}
+	})
+}
+
+// templateContent is a version's content fields as they appear in JSON, for comparison
+func templateContent(version *models.TemplateVersion) map[string]interface{} {
+	payload, _ := json.Marshal(version)
+	var content map[string]interface{}
+	_ = json.Unmarshal(payload, &content)
+	for _, field := range []string{"template_id", "version", "created_at", "rolled_back_from", "published"} {
+		delete(content, field)
+	}
+	return content
+}
+
+// lineDiff lists the lines of a and b along their longest common subsequence: lines only
+// in a are prefixed "-", lines only in b "+", and shared lines " "
+func lineDiff(a, b string) []string {
+	before, after := strings.Split(a, "\n"), strings.Split(b, "\n")
+	if len(before) > templateDiffMaxLines || len(after) > templateDiffMaxLines {
+		return nil
+	}
+
+	// common[i][j] is the length of the longest common subsequence of before[i:] and after[j:]
+	common := make([][]int, len(before)+1)
+	for i := range common {
+		common[i] = make([]int, len(after)+1)
+	}
+	for i := len(before) - 1; i >= 0; i-- {
+		for j := len(after) - 1; j >= 0; j-- {
+			if before[i] == after[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+
+	lines := make([]string, 0, len(before)+len(after))
+	i, j := 0, 0
+	for i < len(before) && j < len(after) {
+		switch {
+		case before[i] == after[j]:
+			lines = append(lines, " "+before[i])
+			i++
+			j++
+		case common[i+1][j] >= common[i][j+1]:
+			lines = append(lines, "-"+before[i])
+			i++
+		default:
+			lines = append(lines, "+"+after[j])
+			j++
+		}
+	}
+	for ; i < len(before); i++ {
+		lines = append(lines, "-"+before[i])
+	}
+	for ; j < len(after); j++ {
+		lines = append(lines, "+"+after[j])
+	}
+	return lines
+}
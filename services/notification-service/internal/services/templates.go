@@ -0,0 +1,477 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"notification-service/internal/cache"
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var (
+	ErrTemplateNotFound   = errors.New("template not found")
+	ErrTemplateNotPending = errors.New("template is not awaiting approval")
+	ErrNoPreviousVersion  = errors.New("template has no previously published version")
+	ErrTemplateChanged    = errors.New("template changed while it was being published")
+)
+
+// TemplateService stores templates in Redis with the history of their versions and
+// drives the publish/approve/rollback workflow, emitting change events for external
+// CI/CD and approval systems
+type TemplateService struct {
+	redis            *RedisClient
+	events           *TemplateEventPublisher
+	approvalRequired bool
+	schemas          *cache.Cache[*templateSchemas]
+	rendered         *cache.Cache[*parsedTemplate]
+	languages        *LanguageResolver
+	translations     *TemplateTranslator
+}
+
+func NewTemplateService(cfg *config.Config, redis *RedisClient, events *TemplateEventPublisher, languages *LanguageResolver, translations *TemplateTranslator) *TemplateService {
+	return &TemplateService{
+		redis:            redis,
+		events:           events,
+		approvalRequired: cfg.TemplateApprovalRequired,
+		schemas:          newSchemaCache(),
+		rendered:         newRenderCache(),
+		languages:        languages,
+		translations:     translations,
+	}
+}
+
+func templateKey(id string) string {
+	return "template:" + id
+}
+
+// templatePreviousKey held the last published snapshot before published versions were
+// recorded; templates published since then are rolled back through templatePublishedKey
+func templatePreviousKey(id string) string {
+	return "template-previous:" + id
+}
+
+// templatePublishedKey records every version of a template that was published, scored
+// by when, so rollbacks can step back through all of them
+func templatePublishedKey(id string) string {
+	return "template-published:" + id
+}
+
+const templateIndexKey = "templates"
+
+func (s *TemplateService) Create(ctx context.Context, req models.TemplateRequest) (*models.NotificationTemplate, error) {
+	now := time.Now().UTC()
+	template := &models.NotificationTemplate{
+		ID:        NewID(),
+		Name:      req.Name,
+		Type:      req.Type,
+		Subject:   req.Subject,
+		Body:      req.Body,
+		Variables: req.Variables,
+		Metadata:  req.Metadata,
+		CreatedAt: now,
+		UpdatedAt: now,
+		State:     models.TemplateStateDraft,
+		Version:   1,
+
+		DataSchema:     req.DataSchema,
+		MetadataSchema: req.MetadataSchema,
+
+		Locale:        req.Locale,
+		Localizations: req.Localizations,
+
+		Card: req.Card,
+	}
+	if _, err := compileTemplateSchemas(template); err != nil {
+		return nil, err
+	}
+	if _, err := parseTemplate(template); err != nil {
+		return nil, err
+	}
+
+	payload, err := json.Marshal(template)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal template: %w", err)
+	}
+	version, err := json.Marshal(templateSnapshot(template, 0))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.redis.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, templateKey(template.ID), payload, 0)
+		pipe.HSet(ctx, templateVersionsKey(template.ID), strconv.Itoa(template.Version), version)
+		pipe.SAdd(ctx, templateIndexKey, template.ID)
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("failed to save template: %w", err)
+	}
+
+	s.events.Publish(ctx, TemplateEventCreated, template.ID, template)
+	return template, nil
+}
+
+// Get returns a template, first giving one saved before versioning its version 1
+func (s *TemplateService) Get(ctx context.Context, id string) (*models.NotificationTemplate, error) {
+	template, err := s.load(ctx, templateKey(id))
+	if err != nil || template.Version != 0 {
+		return template, err
+	}
+	return s.backfillVersion(ctx, id)
+}
+
+func (s *TemplateService) List(ctx context.Context) ([]*models.NotificationTemplate, error) {
+	ids, err := s.redis.client.SMembers(ctx, templateIndexKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list templates: %w", err)
+	}
+
+	templates := make([]*models.NotificationTemplate, 0, len(ids))
+	for _, id := range ids {
+		template, err := s.Get(ctx, id)
+		if errors.Is(err, ErrTemplateNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, template)
+	}
+	// A stable order keeps the list's ETag stable
+	sort.Slice(templates, func(i, j int) bool {
+		if !templates[i].CreatedAt.Equal(templates[j].CreatedAt) {
+			return templates[i].CreatedAt.Before(templates[j].CreatedAt)
+		}
+		return templates[i].ID < templates[j].ID
+	})
+	return templates, nil
+}
+
+// Update edits the working copy, saving it as a new version. A published template goes
+// back to draft and must be published again; until then notifications keep rendering
+// its published version. A non-empty ifMatch must list the template's current ETag.
+func (s *TemplateService) Update(ctx context.Context, id string, req models.TemplateRequest, ifMatch string) (*models.NotificationTemplate, error) {
+	var template *models.NotificationTemplate
+	err := s.watchTemplate(ctx, id, func(tx *redis.Tx) error {
+		var err error
+		if template, err = loadTemplate(ctx, tx, templateKey(id)); err != nil {
+			return err
+		}
+		if err := checkIfMatch(ifMatch, ETag(template)); err != nil {
+			return err
+		}
+
+		template.Name = req.Name
+		template.Type = req.Type
+		template.Subject = req.Subject
+		template.Body = req.Body
+		template.Variables = req.Variables
+		template.Metadata = req.Metadata
+		template.DataSchema = req.DataSchema
+		template.MetadataSchema = req.MetadataSchema
+		template.Locale = req.Locale
+		template.Localizations = req.Localizations
+		template.Card = req.Card
+		template.UpdatedAt = time.Now().UTC()
+		template.State = models.TemplateStateDraft
+		template.Version++
+		if _, err := compileTemplateSchemas(template); err != nil {
+			return err
+		}
+		if _, err := parseTemplate(template); err != nil {
+			return err
+		}
+		return saveTemplateVersion(ctx, tx, template, 0, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return template, nil
+}
+
+// Delete removes a template and its version history. A non-empty ifMatch
+// must list the template's current ETag.
+func (s *TemplateService) Delete(ctx context.Context, id string, ifMatch string) error {
+	return watchKey(ctx, s.redis.client, templateKey(id), func(tx *redis.Tx) error {
+		template, err := loadTemplate(ctx, tx, templateKey(id))
+		if err != nil {
+			return err
+		}
+		if err := checkIfMatch(ifMatch, ETag(template)); err != nil {
+			return err
+		}
+
+		if _, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, templateKey(id))
+			pipe.Del(ctx, templatePreviousKey(id))
+			pipe.Del(ctx, templatePublishedKey(id))
+			pipe.Del(ctx, templateVersionsKey(id))
+			pipe.SRem(ctx, templateIndexKey, id)
+			return nil
+		}); err != nil {
+			return fmt.Errorf("failed to delete template: %w", err)
+		}
+		return nil
+	})
+}
+
+// RequestPublish publishes a template, or parks it for approval when the policy requires one
+func (s *TemplateService) RequestPublish(ctx context.Context, id string) (*models.NotificationTemplate, error) {
+	if !s.approvalRequired {
+		return s.publish(ctx, id, nil)
+	}
+
+	template, err := s.transition(ctx, id, func(template *models.NotificationTemplate) error {
+		template.State = models.TemplateStatePendingApproval
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.events.Publish(ctx, TemplateEventPublishRequested, template.ID, template)
+	return template, nil
+}
+
+// Decide applies an approval decision to a template awaiting approval. An edit made
+// after publishing was requested sends the template back to draft, so only the
+// version the approval was asked for can be approved.
+func (s *TemplateService) Decide(ctx context.Context, id string, decision models.TemplateApprovalRequest) (*models.NotificationTemplate, error) {
+	pending := func(template *models.NotificationTemplate) error {
+		if template.State != models.TemplateStatePendingApproval {
+			return ErrTemplateNotPending
+		}
+		return nil
+	}
+	if decision.Approved {
+		return s.publish(ctx, id, pending)
+	}
+
+	template, err := s.transition(ctx, id, func(template *models.NotificationTemplate) error {
+		if err := pending(template); err != nil {
+			return err
+		}
+		template.State = models.TemplateStateDraft
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.events.Publish(ctx, TemplateEventRejected, template.ID, map[string]interface{}{
+		"template": template,
+		"approver": decision.Approver,
+		"comment":  decision.Comment,
+	})
+	return template, nil
+}
+
+// Rollback publishes the content of an earlier published version as a new version, or
+// of the version published before the current one when version is 0. Every published
+// version is recorded, so repeated rollbacks step further back; drafts that were never
+// approved can't be rolled back to. History is never rewritten, so a rollback can
+// itself be rolled back.
+func (s *TemplateService) Rollback(ctx context.Context, id string, version int) (*models.NotificationTemplate, error) {
+	var template *models.NotificationTemplate
+	err := s.watchTemplate(ctx, id, func(tx *redis.Tx) error {
+		var err error
+		if template, err = loadTemplate(ctx, tx, templateKey(id)); err != nil {
+			return err
+		}
+		var target *models.TemplateVersion
+		legacy := false
+		if version == 0 {
+			target, legacy, err = previousPublishedVersion(ctx, tx, template)
+		} else if target, err = loadTemplateVersion(ctx, tx, id, version); err == nil {
+			var published bool
+			if published, err = isPublishedVersion(ctx, tx, template, version); err == nil && !published {
+				err = fmt.Errorf("%w: %s version %d", ErrTemplateVersionNotPublished, id, version)
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		applyTemplateVersion(template, target)
+		template.Version++
+		markPublished(template)
+		return saveTemplateVersion(ctx, tx, template, target.Version, func(pipe redis.Pipeliner) {
+			recordPublished(ctx, pipe, template)
+			if legacy {
+				pipe.Del(ctx, templatePreviousKey(id))
+			}
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.events.Publish(ctx, TemplateEventPublished, template.ID, template)
+	s.events.Publish(ctx, TemplateEventRolledBack, template.ID, template)
+	return template, nil
+}
+
+// previousPublishedVersion is the version published before the content the template
+// currently publishes: before the version it was rolled back from, when it was, so
+// repeated rollbacks step back through history rather than between two versions.
+// Templates last published before published versions were recorded fall back to the
+// snapshot kept then, reported as legacy so the rollback can retire it.
+func previousPublishedVersion(ctx context.Context, tx *redis.Tx, template *models.NotificationTemplate) (version *models.TemplateVersion, legacy bool, err error) {
+	origin := template.PublishedVersion
+	if current, err := loadTemplateVersion(ctx, tx, template.ID, origin); err == nil && current.RolledBackFrom != 0 {
+		origin = current.RolledBackFrom
+	}
+	score, err := tx.ZScore(ctx, templatePublishedKey(template.ID), strconv.Itoa(origin)).Result()
+	if err != nil && err != redis.Nil {
+		return nil, false, fmt.Errorf("failed to load published template versions: %w", err)
+	}
+	if err == nil {
+		earlier, err := tx.ZRevRangeByScore(ctx, templatePublishedKey(template.ID), &redis.ZRangeBy{
+			Max:   "(" + strconv.FormatFloat(score, 'f', -1, 64),
+			Min:   "-inf",
+			Count: 1,
+		}).Result()
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to load published template versions: %w", err)
+		}
+		if len(earlier) > 0 {
+			number, err := strconv.Atoi(earlier[0])
+			if err != nil {
+				return nil, false, fmt.Errorf("invalid published template version %q", earlier[0])
+			}
+			version, err := loadTemplateVersion(ctx, tx, template.ID, number)
+			return version, false, err
+		}
+	}
+
+	previous, err := loadTemplate(ctx, tx, templatePreviousKey(template.ID))
+	if errors.Is(err, ErrTemplateNotFound) {
+		return nil, false, ErrNoPreviousVersion
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return templateSnapshot(previous, 0), true, nil
+}
+
+// publish makes the template's current version its live content, after check passes on
+// the template as stored. It runs in a transaction on the template, so an edit made
+// meanwhile is never published unseen: the publish is retried against the edit.
+func (s *TemplateService) publish(ctx context.Context, id string, check func(*models.NotificationTemplate) error) (*models.NotificationTemplate, error) {
+	var template *models.NotificationTemplate
+	err := s.watchTemplate(ctx, id, func(tx *redis.Tx) error {
+		var err error
+		if template, err = loadTemplate(ctx, tx, templateKey(id)); err != nil {
+			return err
+		}
+		if check != nil {
+			if err := check(template); err != nil {
+				return err
+			}
+		}
+		markPublished(template)
+		return storeTemplate(ctx, tx, template, func(pipe redis.Pipeliner) {
+			recordPublished(ctx, pipe, template)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.events.Publish(ctx, TemplateEventPublished, template.ID, template)
+	return template, nil
+}
+
+// transition changes a template's state in a transaction on its key
+func (s *TemplateService) transition(ctx context.Context, id string, change func(*models.NotificationTemplate) error) (*models.NotificationTemplate, error) {
+	var template *models.NotificationTemplate
+	err := s.watchTemplate(ctx, id, func(tx *redis.Tx) error {
+		var err error
+		if template, err = loadTemplate(ctx, tx, templateKey(id)); err != nil {
+			return err
+		}
+		if err := change(template); err != nil {
+			return err
+		}
+		template.UpdatedAt = time.Now().UTC()
+		return storeTemplate(ctx, tx, template, nil)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return template, nil
+}
+
+// watchTemplate runs fn in a transaction on a template, failing with ErrTemplateChanged
+// when the template keeps changing under it. The template is read through Get first,
+// so fn never sees one saved before versioning.
+func (s *TemplateService) watchTemplate(ctx context.Context, id string, fn func(tx *redis.Tx) error) error {
+	if _, err := s.Get(ctx, id); err != nil {
+		return err
+	}
+	err := watchKey(ctx, s.redis.client, templateKey(id), fn)
+	if errors.Is(err, redis.TxFailedErr) {
+		return ErrTemplateChanged
+	}
+	return err
+}
+
+// markPublished makes a template's current version its published one
+func markPublished(template *models.NotificationTemplate) {
+	now := time.Now().UTC()
+	template.State = models.TemplateStatePublished
+	template.IsActive = true
+	template.PublishedAt = &now
+	template.PublishedVersion = template.Version
+	template.UpdatedAt = now
+}
+
+// recordPublished adds a template's published version to its published versions
+func recordPublished(ctx context.Context, pipe redis.Pipeliner, template *models.NotificationTemplate) {
+	pipe.ZAdd(ctx, templatePublishedKey(template.ID), &redis.Z{
+		Score:  float64(template.PublishedAt.UnixMilli()),
+		Member: strconv.Itoa(template.PublishedVersion),
+	})
+}
+
+// storeTemplate saves a template in tx, together with whatever write adds
+func storeTemplate(ctx context.Context, tx *redis.Tx, template *models.NotificationTemplate, write func(pipe redis.Pipeliner)) error {
+	payload, err := json.Marshal(template)
+	if err != nil {
+		return fmt.Errorf("failed to marshal template: %w", err)
+	}
+	if _, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, templateKey(template.ID), payload, 0)
+		if write != nil {
+			write(pipe)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to save template: %w", err)
+	}
+	return nil
+}
+
+func (s *TemplateService) load(ctx context.Context, key string) (*models.NotificationTemplate, error) {
+	return loadTemplate(ctx, s.redis.client, key)
+}
+
+func loadTemplate(ctx context.Context, client redis.Cmdable, key string) (*models.NotificationTemplate, error) {
+	payload, err := client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrTemplateNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load template: %w", err)
+	}
+
+	var template models.NotificationTemplate
+	if err := json.Unmarshal(payload, &template); err != nil {
+		return nil, fmt.Errorf("failed to decode template: %w", err)
+	}
+	return &template, nil
+}
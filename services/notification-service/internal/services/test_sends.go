@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrInvalidTestSend means a test notification has nobody to go to
+var ErrInvalidTestSend = errors.New("invalid test send")
+
+// testSendMessage is sent when the operator gives no message of their own
+const testSendMessage = "Test notification from notification-service"
+
+// TestSendService sends operator test notifications straight to a channel, to check
+// during an incident that the channel works. A test notification skips preferences,
+// quiet hours, send-time scheduling, the provider throttle, the tenant fair queue and
+// retries, but not content screening: a blocked one fails without being sent. It isn't
+// stored, so it stays out of delivery statistics, and it is marked notification.test in
+// traces and metrics.
+type TestSendService struct {
+	senders   map[models.NotificationType]ChannelSender
+	hub       RealtimeHub
+	screening ContentScreener
+}
+
+func NewTestSendService(senders map[models.NotificationType]ChannelSender, hub RealtimeHub, screening ContentScreener) *TestSendService {
+	return &TestSendService{senders: senders, hub: hub, screening: screening}
+}
+
+// Send delivers a test notification and returns the channel's answer; a channel that
+// fails is reported in the result, not as an error
+func (s *TestSendService) Send(ctx context.Context, req models.TestSendRequest, requestedBy string) (*models.TestSendResult, error) {
+	if (req.Channel == models.NotificationTypeWebSocket || req.Channel == models.NotificationTypeWebhook) && req.CustomerID == "" {
+		return nil, fmt.Errorf("%w: a %s test needs customer_id", ErrInvalidTestSend, req.Channel)
+	}
+	if req.Channel != models.NotificationTypeWebSocket && req.Recipient == "" && req.CustomerID == "" {
+		return nil, fmt.Errorf("%w: recipient or customer_id is required", ErrInvalidTestSend)
+	}
+
+	now := time.Now().UTC()
+	notification := &models.Notification{
+		ID:         "test-" + NewID(),
+		Type:       req.Channel,
+		Recipient:  req.Recipient,
+		CustomerID: req.CustomerID,
+		Subject:    req.Subject,
+		Message:    req.Message,
+		Priority:   models.PriorityHigh,
+		Status:     models.NotificationStatusPending,
+		CreatedAt:  now,
+		Metadata:   map[string]interface{}{"test": true, "test.requested_by": requestedBy},
+		Version:    1,
+	}
+	if notification.Message == "" {
+		notification.Message = testSendMessage
+	}
+	if notification.Subject == "" {
+		notification.Subject = testSendMessage
+	}
+
+	ctx, span := telemetry.Tracer.Start(telemetry.WithTestSend(ctx), "notification.test_send",
+		trace.WithAttributes(
+			attribute.String("notification.id", notification.ID),
+			attribute.String("notification.channel", string(req.Channel)),
+			attribute.Bool("notification.test", true),
+			attribute.String("enduser.id", requestedBy),
+		),
+	)
+	defer span.End()
+
+	start := time.Now()
+	err := ScreenNotification(ctx, s.screening, notification)
+	if err == nil {
+		err = s.deliver(ctx, notification)
+	}
+	result := &models.TestSendResult{
+		ID:          notification.ID,
+		Channel:     req.Channel,
+		Delivered:   err == nil,
+		LatencyMs:   float64(time.Since(start).Microseconds()) / 1000,
+		SentAt:      now,
+		RequestedBy: requestedBy,
+	}
+	telemetry.RecordTestSend(ctx, string(req.Channel), err == nil)
+
+	if err != nil {
+		result.Error = err.Error()
+		result.ErrorClass = ClassifyError(err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Test notification failed")
+		slog.WarnContext(ctx, "🧪 Test notification failed", "notification.id", notification.ID, "notification.channel", req.Channel, "test.requested_by", requestedBy, "error", err)
+		return result, nil
+	}
+	slog.InfoContext(ctx, "🧪 Test notification delivered", "notification.id", notification.ID, "notification.channel", req.Channel, "test.requested_by", requestedBy, "latency_ms", result.LatencyMs)
+	return result, nil
+}
+
+func (s *TestSendService) deliver(ctx context.Context, notification *models.Notification) error {
+	if notification.Type == models.NotificationTypeWebSocket {
+		return s.hub.SendToCustomer(ctx, notification.CustomerID, map[string]interface{}{
+			"id":      notification.ID,
+			"type":    "test",
+			"subject": notification.Subject,
+			"message": notification.Message,
+			"test":    true,
+		})
+	}
+	sender, ok := s.senders[notification.Type]
+	if !ok {
+		return fmt.Errorf("%s: %w", notification.Type, ErrChannelNotConfigured)
+	}
+	return sender.Send(ctx, notification)
+}
@@ -0,0 +1,238 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"notification-service/internal/cache"
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DeliveryProvider names a provider deliveries are sent through. Provider routing spreads
+// a channel's sends across its providers, and each is throttled on its own.
+type DeliveryProvider string
+
+const (
+	DeliverySMTPPrimary     DeliveryProvider = "smtp-primary"
+	DeliverySMTPSecondary   DeliveryProvider = "smtp-secondary"
+	DeliveryTwilioPrimary   DeliveryProvider = "twilio-primary"
+	DeliveryTwilioSecondary DeliveryProvider = "twilio-secondary"
+	DeliveryWebhook         DeliveryProvider = "webhook"
+	DeliveryTeams           DeliveryProvider = "teams"
+)
+
+// throttledProviders are the providers that can throttle deliveries, with their channel
+var throttledProviders = []struct {
+	provider DeliveryProvider
+	channel  models.NotificationType
+}{
+	{DeliverySMTPPrimary, models.NotificationTypeEmail},
+	{DeliverySMTPSecondary, models.NotificationTypeEmail},
+	{DeliveryTwilioPrimary, models.NotificationTypeSMS},
+	{DeliveryTwilioSecondary, models.NotificationTypeSMS},
+	{DeliveryWebhook, models.NotificationTypeWebhook},
+	{DeliveryTeams, models.NotificationTypeTeams},
+}
+
+// providerChannel returns the channel a provider delivers
+func providerChannel(provider DeliveryProvider) models.NotificationType {
+	for _, entry := range throttledProviders {
+		if entry.provider == provider {
+			return entry.channel
+		}
+	}
+	return ""
+}
+
+// providerThrottleKey holds a provider's throttle while it lasts; the key expires with it
+func providerThrottleKey(provider DeliveryProvider) string {
+	return "provider-throttle:" + string(provider)
+}
+
+// ProviderThrottle slows a provider down once it starts throttling: until its
+// Retry-After passes, every delivery through it on every replica waits instead of
+// adding to the pressure. The channel's other provider keeps sending.
+type ProviderThrottle struct {
+	redis   *RedisClient
+	maxHold time.Duration
+	states  *cache.Cache[models.ProviderThrottleState]
+}
+
+func NewProviderThrottle(cfg *config.Config, redis *RedisClient) *ProviderThrottle {
+	maxHold := time.Duration(cfg.ProviderThrottleMaxSeconds) * time.Second
+	if maxHold <= 0 {
+		maxHold = 5 * time.Minute
+	}
+	return &ProviderThrottle{
+		redis:   redis,
+		maxHold: maxHold,
+		// Re-read at most every second so other replicas' throttles are picked up quickly
+		states: cache.New[models.ProviderThrottleState](nil, cache.Options{
+			Name:       "provider-throttle",
+			Mode:       cache.ReadThrough,
+			L1TTL:      time.Second,
+			L1MaxItems: len(throttledProviders),
+		}),
+	}
+}
+
+// Throttle holds the provider for delay, capped at PROVIDER_THROTTLE_MAX_SECONDS. An
+// existing throttle that lasts longer is kept.
+func (t *ProviderThrottle) Throttle(ctx context.Context, provider DeliveryProvider, delay time.Duration, reason string) {
+	if t == nil || delay <= 0 {
+		return
+	}
+	if delay > t.maxHold {
+		delay = t.maxHold
+	}
+
+	now := time.Now().UTC()
+	until := now.Add(delay)
+	current := t.State(ctx, provider)
+	if current.Throttled && current.Until.After(until) {
+		return
+	}
+	channel := providerChannel(provider)
+	state := models.ProviderThrottleState{Provider: string(provider), Channel: channel, Throttled: true, Since: &now, Until: &until, Reason: reason}
+	if current.Throttled {
+		state.Since = current.Since
+	}
+
+	telemetry.RecordProviderThrottle(ctx, string(channel), string(provider))
+	data, err := json.Marshal(state)
+	if err == nil {
+		err = t.redis.client.Set(ctx, providerThrottleKey(provider), data, delay).Err()
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to share provider throttle", "provider.name", provider, "error", err)
+	}
+	t.states.Put(ctx, string(provider), state)
+	if !current.Throttled {
+		slog.WarnContext(ctx, "🐢 Provider throttled, holding deliveries", "notification.channel", channel, "provider.name", provider, "throttle.delay", delay, "throttle.reason", reason)
+	}
+}
+
+// Wait blocks until the provider's throttle lifts or ctx ends, returning how long it waited
+func (t *ProviderThrottle) Wait(ctx context.Context, provider DeliveryProvider) (time.Duration, error) {
+	if t == nil {
+		return 0, nil
+	}
+	state := t.State(ctx, provider)
+	if !state.Throttled {
+		return 0, nil
+	}
+
+	channel := string(state.Channel)
+	wait := time.Until(*state.Until)
+	trace.SpanFromContext(ctx).AddEvent("provider.throttle.wait", trace.WithAttributes(
+		attribute.String("notification.channel", channel),
+		attribute.String("provider.name", string(provider)),
+		attribute.Int64("throttle.wait_ms", wait.Milliseconds()),
+	))
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	start := time.Now()
+	select {
+	case <-ctx.Done():
+		telemetry.RecordProviderThrottleWait(ctx, channel, string(provider), time.Since(start).Seconds())
+		return time.Since(start), ctx.Err()
+	case <-timer.C:
+	}
+	telemetry.RecordProviderThrottleWait(ctx, channel, string(provider), wait.Seconds())
+	return wait, nil
+}
+
+// State returns the provider's current throttle; failures to read it count as unthrottled
+func (t *ProviderThrottle) State(ctx context.Context, provider DeliveryProvider) models.ProviderThrottleState {
+	state, err := t.states.Get(ctx, string(provider), func(ctx context.Context) (models.ProviderThrottleState, error) {
+		return t.load(ctx, provider)
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to read provider throttle", "provider.name", provider, "error", err)
+		return unthrottled(provider)
+	}
+	if state.Until == nil || !time.Now().Before(*state.Until) {
+		return unthrottled(provider)
+	}
+	state.RemainingMs = time.Until(*state.Until).Milliseconds()
+	return state
+}
+
+// ChannelThrottles returns the current throttles of the channel's providers
+func (t *ProviderThrottle) ChannelThrottles(ctx context.Context, channel models.NotificationType) []models.ProviderThrottleState {
+	var throttles []models.ProviderThrottleState
+	for _, entry := range throttledProviders {
+		if entry.channel != channel {
+			continue
+		}
+		if state := t.State(ctx, entry.provider); state.Throttled {
+			throttles = append(throttles, state)
+		}
+	}
+	return throttles
+}
+
+// Throttles returns every provider's throttle state
+func (t *ProviderThrottle) Throttles(ctx context.Context) ([]models.ProviderThrottleState, error) {
+	states := make([]models.ProviderThrottleState, 0, len(throttledProviders))
+	for _, entry := range throttledProviders {
+		state, err := t.load(ctx, entry.provider)
+		if err != nil {
+			return nil, err
+		}
+		if state.Until == nil || !time.Now().Before(*state.Until) {
+			state = unthrottled(entry.provider)
+		} else {
+			state.RemainingMs = time.Until(*state.Until).Milliseconds()
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// Register starts reporting the throttle gauge; unregister the result on shutdown
+func (t *ProviderThrottle) Register() (metric.Registration, error) {
+	return telemetry.RegisterProviderThrottleCallback(func(ctx context.Context) []telemetry.ProviderThrottleLevel {
+		levels := make([]telemetry.ProviderThrottleLevel, len(throttledProviders))
+		for i, entry := range throttledProviders {
+			state := t.State(ctx, entry.provider)
+			levels[i] = telemetry.ProviderThrottleLevel{
+				Channel:   string(entry.channel),
+				Provider:  string(entry.provider),
+				Remaining: time.Duration(state.RemainingMs) * time.Millisecond,
+			}
+		}
+		return levels
+	})
+}
+
+func (t *ProviderThrottle) load(ctx context.Context, provider DeliveryProvider) (models.ProviderThrottleState, error) {
+	data, err := t.redis.client.Get(ctx, providerThrottleKey(provider)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return unthrottled(provider), nil
+	}
+	if err != nil {
+		return models.ProviderThrottleState{}, fmt.Errorf("failed to load provider throttle: %w", err)
+	}
+	var state models.ProviderThrottleState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return models.ProviderThrottleState{}, fmt.Errorf("failed to decode provider throttle: %w", err)
+	}
+	return state, nil
+}
+
+// unthrottled is the state of a provider that isn't throttling
+func unthrottled(provider DeliveryProvider) models.ProviderThrottleState {
+	return models.ProviderThrottleState{Provider: string(provider), Channel: providerChannel(provider)}
+}
@@ -0,0 +1,86 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"html"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"notification-service/internal/config"
+)
+
+// trackedLink matches the href of an <a> tag pointing at an http(s) URL
+var trackedLink = regexp.MustCompile(`(?i)(<a\b[^>]*?\bhref\s*=\s*)(["'])(https?://[^"']+)(["'])`)
+
+// LinkTracker instruments HTML emails with an open pixel and click-tracking links, and
+// verifies the tracking URLs when mail clients follow them. The URLs are signed with
+// TRACKING_SECRET, so the click endpoint can't be used as an open redirect and opens and
+// clicks can't be forged for other notifications.
+type LinkTracker struct {
+	baseURL string
+	secret  []byte
+}
+
+func NewLinkTracker(cfg *config.Config) *LinkTracker {
+	tracker := &LinkTracker{baseURL: strings.TrimSuffix(cfg.TrackingBaseURL, "/"), secret: []byte(cfg.TrackingSecret)}
+	if tracker.baseURL != "" && len(tracker.secret) == 0 {
+		slog.Warn("Email tracking is off: TRACKING_BASE_URL is set without TRACKING_SECRET")
+	}
+	return tracker
+}
+
+// Enabled reports whether emails are instrumented
+func (t *LinkTracker) Enabled() bool {
+	return t != nil && t.baseURL != "" && len(t.secret) > 0
+}
+
+// InstrumentHTML routes the http(s) links of an HTML email body through the click
+// endpoint and adds the open pixel at the end of the body
+func (t *LinkTracker) InstrumentHTML(notificationID, body string) string {
+	if !t.Enabled() {
+		return body
+	}
+
+	body = trackedLink.ReplaceAllStringFunc(body, func(link string) string {
+		match := trackedLink.FindStringSubmatch(link)
+		target := html.UnescapeString(match[3])
+		return match[1] + match[2] + html.EscapeString(t.ClickURL(notificationID, target)) + match[4]
+	})
+
+	pixel := `<img src="` + html.EscapeString(t.OpenURL(notificationID)) + `" width="1" height="1" alt="" style="display:none">`
+	if end := strings.LastIndex(strings.ToLower(body), "</body>"); end >= 0 {
+		return body[:end] + pixel + body[end:]
+	}
+	return body + pixel
+}
+
+// OpenURL is the open pixel of a notification
+func (t *LinkTracker) OpenURL(notificationID string) string {
+	return t.baseURL + "/t/open/" + url.PathEscape(notificationID) + "?sig=" + t.sign("open", notificationID)
+}
+
+// ClickURL is a tracked link to target from a notification
+func (t *LinkTracker) ClickURL(notificationID, target string) string {
+	return t.baseURL + "/t/click/" + url.PathEscape(notificationID) +
+		"?url=" + url.QueryEscape(target) + "&sig=" + t.sign("click", notificationID, target)
+}
+
+// VerifyOpen checks the signature of an open pixel
+func (t *LinkTracker) VerifyOpen(notificationID, signature string) bool {
+	return t.Enabled() && hmac.Equal([]byte(signature), []byte(t.sign("open", notificationID)))
+}
+
+// VerifyClick checks the signature of a tracked link
+func (t *LinkTracker) VerifyClick(notificationID, target, signature string) bool {
+	return t.Enabled() && hmac.Equal([]byte(signature), []byte(t.sign("click", notificationID, target)))
+}
+
+func (t *LinkTracker) sign(parts ...string) string {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(strings.Join(parts, "\n")))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"notification-service/internal/cache"
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Metadata set on notifications rendered from a machine-translated template
+const (
+	MachineTranslatedMetadata = "machine_translated"
+	TranslatedFromMetadata    = "translated_from"
+)
+
+const azureTranslatorAPIVersion = "3.0"
+
+var (
+	// templateActions are the {{...}} actions of a template, which are never translated
+	templateActions = regexp.MustCompile(`(?s)\{\{.*?\}\}`)
+	// notranslateSpan is the markup actions are protected with while translating
+	notranslateSpan = regexp.MustCompile(`(?s)<span class="notranslate">(.*?)</span>`)
+)
+
+// TemplateTranslator machine-translates templates into languages none of their locales
+// speak. Translations are cached per template version and language for
+// TRANSLATION_CACHE_TTL_HOURS, in Redis so every replica shares them; a new version is
+// translated again. Without TRANSLATION_ENABLED, or a Translator key, it is disabled.
+type TemplateTranslator struct {
+	translator   Translator
+	translations *cache.Cache[models.TemplateLocalization]
+}
+
+func NewTemplateTranslator(cfg *config.Config, redisClient *RedisClient) *TemplateTranslator {
+	t := &TemplateTranslator{
+		translations: cache.New[models.TemplateLocalization](redisClient.client, cache.Options{
+			Name:       "template-translations",
+			Mode:       cache.ReadThrough,
+			L1TTL:      time.Hour,
+			L1MaxItems: 1000,
+			L2TTL:      time.Duration(max(cfg.TranslationCacheTTLHours, 1)) * time.Hour,
+		}),
+	}
+	if cfg.TranslationEnabled {
+		if cfg.TranslatorKey == "" {
+			slog.Warn("TRANSLATION_ENABLED without TRANSLATOR_KEY, machine translation disabled")
+		} else {
+			t.translator = NewAzureTranslator(cfg)
+		}
+	}
+	return t
+}
+
+// Enabled reports whether templates are machine-translated
+func (t *TemplateTranslator) Enabled() bool {
+	return t != nil && t.translator != nil
+}
+
+// Translate returns a template's subject and body translated from its own locale into
+// language. Template actions are kept as they are, so the translation renders with the
+// same data.
+func (t *TemplateTranslator) Translate(ctx context.Context, tmpl *models.NotificationTemplate, language string) (models.TemplateLocalization, error) {
+	key := fmt.Sprintf("%s:%d:%s", tmpl.ID, tmpl.Version, strings.ToLower(language))
+	return t.translations.Get(ctx, key, func(ctx context.Context) (models.TemplateLocalization, error) {
+		texts, err := t.translator.Translate(ctx, []string{protectActions(tmpl.Subject), protectActions(tmpl.Body)}, primaryLanguage(templateLocale(tmpl)), language)
+		if err != nil {
+			telemetry.RecordTranslation(ctx, language, "error")
+			return models.TemplateLocalization{}, err
+		}
+		if len(texts) != 2 {
+			telemetry.RecordTranslation(ctx, language, "error")
+			return models.TemplateLocalization{}, fmt.Errorf("translator returned %d texts for 2", len(texts))
+		}
+		telemetry.RecordTranslation(ctx, language, "translated")
+		return models.TemplateLocalization{Subject: restoreActions(texts[0]), Body: restoreActions(texts[1])}, nil
+	})
+}
+
+// protectActions writes template text as HTML with its actions marked notranslate
+func protectActions(text string) string {
+	var out strings.Builder
+	last := 0
+	for _, action := range templateActions.FindAllStringIndex(text, -1) {
+		out.WriteString(html.EscapeString(text[last:action[0]]))
+		out.WriteString(`<span class="notranslate">`)
+		out.WriteString(html.EscapeString(text[action[0]:action[1]]))
+		out.WriteString(`</span>`)
+		last = action[1]
+	}
+	out.WriteString(html.EscapeString(text[last:]))
+	return out.String()
+}
+
+// restoreActions turns translated HTML from protectActions back into template text
+func restoreActions(translated string) string {
+	return html.UnescapeString(notranslateSpan.ReplaceAllString(translated, "$1"))
+}
+
+// AzureTranslator translates with the Azure AI Translator text translation API
+type AzureTranslator struct {
+	endpoint string
+	key      string
+	region   string
+	client   *http.Client
+}
+
+func NewAzureTranslator(cfg *config.Config) *AzureTranslator {
+	return &AzureTranslator{
+		endpoint: strings.TrimSuffix(cfg.TranslatorEndpoint, "/"),
+		key:      cfg.TranslatorKey,
+		region:   cfg.TranslatorRegion,
+		client:   NewHTTPClient(cfg, ProviderTranslator, 10*time.Second),
+	}
+}
+
+type azureTranslateText struct {
+	Text string `json:"Text"`
+}
+
+type azureTranslateResult struct {
+	Translations []struct {
+		Text string `json:"text"`
+		To   string `json:"to"`
+	} `json:"translations"`
+}
+
+// Translate translates HTML texts from one language to another, in order
+func (t *AzureTranslator) Translate(ctx context.Context, texts []string, from, to string) ([]string, error) {
+	ctx, span := telemetry.Tracer.Start(ctx, "translator.translate",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("server.address", t.endpoint),
+			attribute.String("translation.from", from),
+			attribute.String("translation.to", to),
+		),
+	)
+	defer span.End()
+
+	translated, err := t.translate(ctx, texts, from, to)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "translation failed")
+	}
+	return translated, err
+}
+
+func (t *AzureTranslator) translate(ctx context.Context, texts []string, from, to string) ([]string, error) {
+	request := make([]azureTranslateText, len(texts))
+	for i, text := range texts {
+		request[i] = azureTranslateText{Text: text}
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{"api-version": {azureTranslatorAPIVersion}, "from": {from}, "to": {to}, "textType": {"html"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint+"/translate?"+query.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Ocp-Apim-Subscription-Key", t.key)
+	if t.region != "" {
+		req.Header.Set("Ocp-Apim-Subscription-Region", t.region)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, &StatusError{StatusCode: resp.StatusCode, RetryAfterDelay: ParseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+
+	var results []azureTranslateResult
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&results); err != nil {
+		return nil, fmt.Errorf("failed to decode translation response: %w", err)
+	}
+	translated := make([]string, len(results))
+	for i, result := range results {
+		if len(result.Translations) == 0 {
+			return nil, fmt.Errorf("translation of text %d returned nothing", i)
+		}
+		translated[i] = result.Translations[0].Text
+	}
+	return translated, nil
+}
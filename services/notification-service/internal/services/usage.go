@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"notification-service/internal/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Usage is kept per minute for two days and per hour for thirty days
+const (
+	usageMinuteRetention = 48 * time.Hour
+	usageHourRetention   = 30 * 24 * time.Hour
+	usageKeysKey         = "apikey-usage:keys"
+)
+
+// Usage samples wait in a bounded queue and are written in batches by one writer, so a
+// burst of requests doesn't start a Redis round trip, or a goroutine, per request
+const (
+	usageQueueSize = 10000
+	usageBatchSize = 100
+)
+
+var ErrInvalidUsageRange = errors.New("invalid usage range")
+
+var usageResolutions = map[string]time.Duration{
+	"minute": time.Minute,
+	"hour":   time.Hour,
+}
+
+// UsageTracker records per-API-key request and notification volumes in time buckets
+type UsageTracker struct {
+	redis *RedisClient
+	queue chan usageRecord
+}
+
+// usageRecord is one request's usage waiting to be written
+type usageRecord struct {
+	keyID  string
+	sample models.UsageSample
+	at     time.Time
+}
+
+func NewUsageTracker(redis *RedisClient) *UsageTracker {
+	return &UsageTracker{redis: redis, queue: make(chan usageRecord, usageQueueSize)}
+}
+
+func usageBucketKey(keyID, resolution string, bucket time.Time) string {
+	return fmt.Sprintf("apikey-usage:%s:%s:%d", keyID, resolution, bucket.Unix())
+}
+
+// RecordUsage queues one request's usage for the key's minute and hour buckets. It never
+// blocks: when the queue is full the sample is dropped.
+func (t *UsageTracker) RecordUsage(ctx context.Context, keyID string, sample models.UsageSample) {
+	select {
+	case t.queue <- usageRecord{keyID: keyID, sample: sample, at: time.Now().UTC()}:
+	default:
+		slog.WarnContext(ctx, "Usage queue full, dropping sample", "api_key.id", keyID)
+	}
+}
+
+// Start writes queued usage until ctx ends, then what is still queued
+func (t *UsageTracker) Start(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				t.drain(context.WithoutCancel(ctx))
+				return
+			case record := <-t.queue:
+				t.write(ctx, t.batch(record))
+			}
+		}
+	}()
+}
+
+// batch takes the queued records behind first, up to usageBatchSize
+func (t *UsageTracker) batch(first usageRecord) []usageRecord {
+	batch := []usageRecord{first}
+	for len(batch) < usageBatchSize {
+		select {
+		case record := <-t.queue:
+			batch = append(batch, record)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+func (t *UsageTracker) drain(ctx context.Context) {
+	for {
+		select {
+		case record := <-t.queue:
+			t.write(ctx, t.batch(record))
+		default:
+			return
+		}
+	}
+}
+
+// write adds a batch of usage to the buckets in one pipeline
+func (t *UsageTracker) write(ctx context.Context, batch []usageRecord) {
+	pipe := t.redis.client.Pipeline()
+	for _, record := range batch {
+		t.add(ctx, pipe, record)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.WarnContext(ctx, "Failed to record usage", "usage.samples", len(batch), "error", err)
+	}
+}
+
+func (t *UsageTracker) add(ctx context.Context, pipe redis.Pipeliner, record usageRecord) {
+	keyID, sample := record.keyID, record.sample
+	for resolution, size := range usageResolutions {
+		key := usageBucketKey(keyID, resolution, record.at.Truncate(size))
+		pipe.HIncrBy(ctx, key, "requests", 1)
+		if sample.Error {
+			pipe.HIncrBy(ctx, key, "errors", 1)
+		}
+		pipe.HIncrBy(ctx, key, "bytes_in", sample.BytesIn)
+		pipe.HIncrBy(ctx, key, "bytes_out", sample.BytesOut)
+		if sample.Notifications > 0 {
+			pipe.HIncrBy(ctx, key, "notifications", int64(sample.Notifications))
+		}
+
+		retention := usageMinuteRetention
+		if resolution == "hour" {
+			retention = usageHourRetention
+		}
+		pipe.Expire(ctx, key, retention)
+	}
+	pipe.SAdd(ctx, usageKeysKey, keyID)
+}
+
+// APIKeys lists the IDs of keys with recorded usage
+func (t *UsageTracker) APIKeys(ctx context.Context) ([]string, error) {
+	keys, err := t.redis.client.SMembers(ctx, usageKeysKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	return keys, nil
+}
+
+// UsageSeries returns one bucket per resolution step between from and to, including
+// empty buckets so the series can be charted directly
+func (t *UsageTracker) UsageSeries(ctx context.Context, keyID string, from, to time.Time, resolution string) ([]models.UsageBucket, error) {
+	size, ok := usageResolutions[resolution]
+	if !ok {
+		return nil, fmt.Errorf("%w: resolution must be minute or hour", ErrInvalidUsageRange)
+	}
+	from, to = from.UTC().Truncate(size), to.UTC().Truncate(size)
+	if to.Before(from) {
+		return nil, fmt.Errorf("%w: from must be before to", ErrInvalidUsageRange)
+	}
+	if steps := int(to.Sub(from)/size) + 1; steps > 1440 {
+		return nil, fmt.Errorf("%w: %d buckets requested, at most 1440 allowed", ErrInvalidUsageRange, steps)
+	}
+
+	var starts []time.Time
+	pipe := t.redis.client.Pipeline()
+	var cmds []*redis.StringStringMapCmd
+	for bucket := from; !bucket.After(to); bucket = bucket.Add(size) {
+		starts = append(starts, bucket)
+		cmds = append(cmds, pipe.HGetAll(ctx, usageBucketKey(keyID, resolution, bucket)))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("failed to load usage: %w", err)
+	}
+
+	series := make([]models.UsageBucket, len(starts))
+	for i, cmd := range cmds {
+		fields := cmd.Val()
+		bucket := models.UsageBucket{
+			Start:         starts[i],
+			Requests:      usageField(fields, "requests"),
+			Errors:        usageField(fields, "errors"),
+			Notifications: usageField(fields, "notifications"),
+			BytesIn:       usageField(fields, "bytes_in"),
+			BytesOut:      usageField(fields, "bytes_out"),
+		}
+		if bucket.Requests > 0 {
+			bucket.ErrorRate = float64(bucket.Errors) / float64(bucket.Requests)
+		}
+		series[i] = bucket
+	}
+	return series, nil
+}
+
+func usageField(fields map[string]string, name string) int64 {
+	value, _ := strconv.ParseInt(fields[name], 10, 64)
+	return value
+}
@@ -0,0 +1,447 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/faults"
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Webhook attempt history kept per notification
+const (
+	webhookAttemptsPerNotification = 20
+	webhookAttemptsTTL             = 7 * 24 * time.Hour
+)
+
+// webhookStatsKey aggregates webhook outcomes for the analytics endpoint
+const webhookStatsKey = "webhook-delivery-stats"
+
+// ErrNoWebhookURL is returned when a webhook notification has no URL to post to
+var ErrNoWebhookURL = errors.New("no webhook URL for recipient")
+
+// ErrInvalidWebhookURL is a webhook URL that may not be registered: not http(s), or
+// naming an address inside the network
+var ErrInvalidWebhookURL = errors.New("invalid webhook URL")
+
+// webhookAttemptsKey holds a notification's webhook delivery attempts, oldest first
+func webhookAttemptsKey(notificationID string) string {
+	return "webhook-attempts:" + notificationID
+}
+
+// preferencesKey is where the storage backend keeps a customer's preferences
+func preferencesKey(customerID string) string {
+	return "preferences:" + customerID
+}
+
+// preferencesTenantKey is the set of the customers whose preferences belong to a tenant
+func preferencesTenantKey(tenantID string) string {
+	return "preferences-tenant:" + tenantID
+}
+
+// WebhookService posts webhook notifications to the endpoint each customer registered,
+// signing "<timestamp>.<body>" with an X-Signature: sha256=<hmac> header and with the
+// active Ed25519 signing key, and retrying failures under the webhook retry policy.
+// Each notification's attempt history is kept in its data region.
+type WebhookService struct {
+	cfg       *config.Config
+	redis     *RedisClient
+	residency *DataResidency
+	client    *http.Client
+	sampler   *ProviderPayloadSampler
+	retries   *RetryPolicies
+	keys      *SigningKeyService
+	prefs     *CustomerPreferenceService
+	timeout   time.Duration
+}
+
+func NewWebhookService(cfg *config.Config, redis *RedisClient, residency *DataResidency, sampler *ProviderPayloadSampler, retries *RetryPolicies, keys *SigningKeyService, preferences *CustomerPreferenceService) *WebhookService {
+	timeout := time.Duration(cfg.WebhookTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	return &WebhookService{
+		cfg:       cfg,
+		redis:     redis,
+		residency: residency,
+		client:    NewPublicHTTPClient(cfg, ProviderWebhook, 0),
+		sampler:   sampler,
+		retries:   retries,
+		keys:      keys,
+		prefs:     preferences,
+		timeout:   timeout,
+	}
+}
+
+// Send posts the notification to the webhook_url registered in its customer's
+// preferences, which must have webhook_enabled. The recipient is never posted to, so
+// API callers can't make the service request URLs of their choosing. The body is the
+// notification's data when set, and the notification itself otherwise.
+func (s *WebhookService) Send(ctx context.Context, notification *models.Notification) error {
+	if err := faults.Inject(ctx, faults.OpChannelWebhook); err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+
+	target, err := s.webhookURL(ctx, notification)
+	if err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	return s.send(ctx, notification, target)
+}
+
+// SendTo posts a notification to a URL the operator configured, such as the Teams
+// webhook receiving digests. The body is shaped as in Send, so callers control the
+// payload through data (Teams digests post {"text": ...}).
+func (s *WebhookService) SendTo(ctx context.Context, notification *models.Notification, target string) error {
+	if err := faults.Inject(ctx, faults.OpChannelWebhook); err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	parsed, err := url.Parse(target)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("webhook: %w: not an http(s) URL", ErrInvalidWebhookURL)
+	}
+	return s.send(ctx, notification, parsed)
+}
+
+func (s *WebhookService) send(ctx context.Context, notification *models.Notification, target *url.URL) error {
+	var body []byte
+	var err error
+	if len(notification.Data) > 0 {
+		body, err = json.Marshal(notification.Data)
+	} else {
+		body, err = json.Marshal(notification)
+	}
+	if err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+
+	ctx, span := telemetry.Tracer.Start(ctx, "webhook.send",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("notification.id", notification.ID),
+			attribute.String("notification.channel", string(models.NotificationTypeWebhook)),
+			attribute.String("server.address", target.Hostname()),
+		),
+	)
+	defer span.End()
+	span.SetAttributes(regionAttributes(notification)...)
+
+	start := time.Now()
+	attempts, err := s.deliver(ctx, notification, target.String(), body)
+	telemetry.RecordChannelDelivery(ctx, string(models.NotificationTypeWebhook), notification.Region, err == nil, time.Since(start).Seconds())
+	s.recordOutcome(ctx, attempts, err == nil)
+	span.SetAttributes(attribute.Int("webhook.attempts", attempts))
+	notification.RetryCount = max(attempts-1, 0)
+
+	now := time.Now().UTC()
+	if err != nil {
+		notification.Status = models.NotificationStatusFailed
+		notification.FailedAt = &now
+		notification.ErrorMessage = err.Error()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "Webhook delivery failed")
+		return fmt.Errorf("webhook: %w", err)
+	}
+	notification.Status = models.NotificationStatusDelivered
+	notification.SentAt = &now
+	notification.DeliveredAt = &now
+	return nil
+}
+
+// deliver posts under the webhook retry policy, each attempt bounded by WebhookTimeout,
+// and returns how many attempts were made
+func (s *WebhookService) deliver(ctx context.Context, notification *models.Notification, target string, body []byte) (int, error) {
+	return s.retries.Do(ctx, models.NotificationTypeWebhook, DeliveryWebhook, func(attempt int) error {
+		record, err := s.post(ctx, notification, target, body, attempt)
+		s.recordAttempt(ctx, notification, record)
+		return err
+	})
+}
+
+// sign signs "<timestamp>.<body>", with the time in X-Signature-Timestamp: with an
+// X-Signature HMAC-SHA256 when WEBHOOK_SIGNING_SECRET is set, and with an Ed25519
+// signature by the key named in X-Signature-Key-Id, checkable against the published
+// JWKS. Consumers reject old timestamps to stop replays. A webhook is never sent with
+// neither signature; the attempt fails and is retried instead.
+func (s *WebhookService) sign(ctx context.Context, req *http.Request, notificationID string, body []byte) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signed := append([]byte(timestamp+"."), body...)
+	req.Header.Set("X-Signature-Timestamp", timestamp)
+	hmacSigned := s.cfg.WebhookSigningSecret != ""
+	if hmacSigned {
+		req.Header.Set("X-Signature", "sha256="+SignPayload(s.cfg.WebhookSigningSecret, signed))
+	}
+
+	if s.keys == nil {
+		if !hmacSigned {
+			return newProviderError(models.NotificationTypeWebhook, 0, "no signing secret or key to sign the webhook with", models.ErrorClassRejected)
+		}
+		return nil
+	}
+	keyID, signature, err := s.keys.Sign(ctx, signed)
+	if err != nil {
+		if !hmacSigned {
+			return newProviderError(models.NotificationTypeWebhook, 0, "webhook can't be signed: "+err.Error(), models.ErrorClassTransient)
+		}
+		slog.WarnContext(ctx, "Webhook sent without an Ed25519 signature", "notification.id", notificationID, "error", err)
+		return nil
+	}
+	req.Header.Set("X-Signature-Key-Id", keyID)
+	req.Header.Set("X-Signature-Ed25519", signature)
+	return nil
+}
+
+func (s *WebhookService) post(ctx context.Context, notification *models.Notification, target string, body []byte, attempt int) (models.WebhookAttempt, error) {
+	notificationID := notification.ID
+	record := models.WebhookAttempt{Attempt: attempt, URL: target, AttemptedAt: time.Now().UTC()}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		record.Error = err.Error()
+		return record, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "notification-service-webhooks/1.0")
+	req.Header.Set("X-Webhook-Attempt", strconv.Itoa(attempt))
+	if notificationID != "" {
+		req.Header.Set("X-Webhook-Id", notificationID)
+	}
+	if err := s.sign(ctx, req, notificationID, body); err != nil {
+		record.Error = err.Error()
+		return record, err
+	}
+
+	start := time.Now()
+	resp, err := s.client.Do(req)
+	var responseBody []byte
+	if err == nil {
+		responseBody, _ = io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+	}
+	record.DurationMs = time.Since(start).Milliseconds()
+	s.capture(ctx, notification, attempt, req, body, resp, responseBody, err, start)
+
+	if err != nil {
+		record.Error = err.Error()
+		return record, err
+	}
+	record.StatusCode = resp.StatusCode
+
+	if resp.StatusCode < 300 {
+		return record, nil
+	}
+	err = &StatusError{StatusCode: resp.StatusCode, RetryAfterDelay: ParseRetryAfter(resp.Header.Get("Retry-After"))}
+	record.Error = err.Error()
+	return record, err
+}
+
+// webhookURL resolves the endpoint the notification's customer registered. Its host
+// must resolve to public addresses only; the client checks again when it dials, which
+// also covers DNS answers that change between the two.
+func (s *WebhookService) webhookURL(ctx context.Context, notification *models.Notification) (*url.URL, error) {
+	customerID := notification.CustomerID
+	if customerID == "" {
+		return nil, fmt.Errorf("%w: webhook notifications need a customer_id", ErrNoWebhookURL)
+	}
+	preferences, err := s.prefs.Preferences(ctx, customerID)
+	if errors.Is(err, ErrPreferencesNotFound) {
+		return nil, fmt.Errorf("%w %q", ErrNoWebhookURL, customerID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !preferences.WebhookEnabled || preferences.WebhookURL == "" {
+		return nil, fmt.Errorf("%w %q", ErrNoWebhookURL, customerID)
+	}
+
+	target, err := ValidateWebhookURL(preferences.WebhookURL)
+	if err != nil {
+		return nil, newProviderError(models.NotificationTypeWebhook, 0, err.Error(), models.ErrorClassRejected)
+	}
+	addresses, err := net.DefaultResolver.LookupNetIP(ctx, "ip", target.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	for _, address := range addresses {
+		if !IsPublicAddress(address) {
+			return nil, newProviderError(models.NotificationTypeWebhook, 0,
+				fmt.Sprintf("%s resolves to %s: %v", target.Hostname(), address, ErrNonPublicAddress), models.ErrorClassRejected)
+		}
+	}
+	return target, nil
+}
+
+// ValidateWebhookURL checks a webhook URL a customer registers: http(s), with a host
+// that isn't localhost or an address inside the network. Names are checked again
+// whenever the webhook is sent.
+func ValidateWebhookURL(raw string) (*url.URL, error) {
+	target, err := url.Parse(raw)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" || target.User != nil {
+		return nil, fmt.Errorf("%w: %q must be an http(s) URL without credentials", ErrInvalidWebhookURL, raw)
+	}
+	host := strings.ToLower(strings.TrimSuffix(target.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") || strings.HasSuffix(host, ".internal") {
+		return nil, fmt.Errorf("%w: %q names a local host", ErrInvalidWebhookURL, raw)
+	}
+	if address, err := netip.ParseAddr(host); err == nil && !IsPublicAddress(address) {
+		return nil, fmt.Errorf("%w: %q is not a public address", ErrInvalidWebhookURL, raw)
+	}
+	return target, nil
+}
+
+// recordAttempt appends to the notification's attempt history; digests and other
+// notifications without an ID are only counted in the totals. Test sends are left
+// out of both.
+func (s *WebhookService) recordAttempt(ctx context.Context, notification *models.Notification, attempt models.WebhookAttempt) {
+	if telemetry.IsTestSend(ctx) {
+		return
+	}
+	pipe := s.redis.client.Pipeline()
+	pipe.HIncrBy(ctx, webhookStatsKey, "attempts", 1)
+	if attempt.StatusCode != 0 {
+		pipe.HIncrBy(ctx, webhookStatsKey, "status:"+strconv.Itoa(attempt.StatusCode), 1)
+	} else {
+		pipe.HIncrBy(ctx, webhookStatsKey, "status:error", 1)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.WarnContext(ctx, "Failed to record webhook attempt", "notification.id", notification.ID, "error", err)
+	}
+	if notification.ID == "" {
+		return
+	}
+
+	data, err := json.Marshal(attempt)
+	if err != nil {
+		return
+	}
+	client, err := s.residency.RecordClient(notification)
+	if err == nil {
+		key := webhookAttemptsKey(notification.ID)
+		_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.RPush(ctx, key, data)
+			pipe.LTrim(ctx, key, -webhookAttemptsPerNotification, -1)
+			pipe.Expire(ctx, key, webhookAttemptsTTL)
+			return nil
+		})
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to record webhook attempt", "notification.id", notification.ID, "error", err)
+	}
+}
+
+func (s *WebhookService) recordOutcome(ctx context.Context, attempts int, delivered bool) {
+	if telemetry.IsTestSend(ctx) {
+		return
+	}
+	field := "failed"
+	if delivered {
+		field = "delivered"
+	}
+	pipe := s.redis.client.Pipeline()
+	pipe.HIncrBy(ctx, webhookStatsKey, field, 1)
+	if delivered && attempts > 1 {
+		pipe.HIncrBy(ctx, webhookStatsKey, "delivered_after_retry", 1)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		slog.WarnContext(ctx, "Failed to record webhook outcome", "error", err)
+	}
+}
+
+// WebhookAttempts returns a notification's webhook delivery attempts, oldest first
+func (s *WebhookService) WebhookAttempts(ctx context.Context, notificationID string) ([]models.WebhookAttempt, error) {
+	client, err := s.residency.Records(ctx, notificationID)
+	if err != nil {
+		return nil, err
+	}
+	values, err := client.LRange(ctx, webhookAttemptsKey(notificationID), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	attempts := make([]models.WebhookAttempt, 0, len(values))
+	for _, value := range values {
+		var attempt models.WebhookAttempt
+		if err := json.Unmarshal([]byte(value), &attempt); err != nil {
+			return nil, fmt.Errorf("failed to decode webhook attempt for %s: %w", notificationID, err)
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts, nil
+}
+
+// WebhookStats returns webhook delivery totals since the counters were created
+func (s *WebhookService) WebhookStats(ctx context.Context) (*models.WebhookStats, error) {
+	fields, err := s.redis.client.HGetAll(ctx, webhookStatsKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	stats := &models.WebhookStats{ByStatus: map[string]int64{}}
+	for field, value := range fields {
+		count, _ := strconv.ParseInt(value, 10, 64)
+		switch field {
+		case "attempts":
+			stats.Attempts = count
+		case "delivered":
+			stats.Delivered = count
+		case "failed":
+			stats.Failed = count
+		case "delivered_after_retry":
+			stats.DeliveredAfterRetry = count
+		default:
+			if status, ok := strings.CutPrefix(field, "status:"); ok {
+				stats.ByStatus[status] = count
+			}
+		}
+	}
+	if total := stats.Delivered + stats.Failed; total > 0 {
+		stats.SuccessRate = float64(stats.Delivered) / float64(total)
+	}
+	return stats, nil
+}
+
+// capture records the webhook exchange when the notification is sampled
+func (s *WebhookService) capture(ctx context.Context, notification *models.Notification, attempt int, req *http.Request, requestBody []byte,
+	resp *http.Response, responseBody []byte, err error, start time.Time) {
+	if notification.ID == "" || !s.sampler.Sampled(notification.ID) {
+		return
+	}
+
+	exchange := models.ProviderExchange{
+		Channel:    models.NotificationTypeWebhook,
+		Provider:   "webhook",
+		Endpoint:   req.Method + " " + req.URL.String(),
+		Attempt:    attempt,
+		Request:    s.sampler.HTTPPayload(req.Header, requestBody),
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if resp != nil {
+		response := s.sampler.HTTPPayload(resp.Header, responseBody)
+		exchange.Response = &response
+		exchange.StatusCode = resp.StatusCode
+	}
+	if err != nil {
+		exchange.Error = err.Error()
+	}
+	s.sampler.Record(context.WithoutCancel(ctx), notification, exchange)
+}
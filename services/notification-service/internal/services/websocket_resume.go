@@ -0,0 +1,139 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+	"notification-service/internal/telemetry"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// ErrResumeTokenInvalid is returned when a resume token is unknown, expired or belongs to
+// another customer; the client starts a fresh session
+var ErrResumeTokenInvalid = errors.New("resume token invalid or expired")
+
+// wsReplayKey is the capped stream of recent WebSocket messages for a customer
+func wsReplayKey(customerID string) string {
+	return "ws-replay:" + customerID
+}
+
+// wsResumeKey maps a resume token to its customer and the stream position it was issued at
+func wsResumeKey(token string) string {
+	return "ws-resume:" + token
+}
+
+// WebSocketMessageBuffer keeps each customer's recent WebSocket messages in a capped Redis
+// stream. Because tokens and messages live in Redis, a client can resume on any replica,
+// including after the pod it was connected to restarts.
+type WebSocketMessageBuffer struct {
+	redis  *RedisClient
+	maxLen int64
+	ttl    time.Duration
+}
+
+func NewWebSocketMessageBuffer(cfg *config.Config, redis *RedisClient) *WebSocketMessageBuffer {
+	ttl := time.Duration(cfg.WebSocketResumeTTLSeconds) * time.Second
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+	return &WebSocketMessageBuffer{
+		redis:  redis,
+		maxLen: int64(cfg.WebSocketReplayBufferSize),
+		ttl:    ttl,
+	}
+}
+
+// NewReconnectBackoff returns the reconnect schedule sent to clients in session messages
+func NewReconnectBackoff(cfg *config.Config) models.ReconnectBackoff {
+	return models.ReconnectBackoff{
+		InitialDelayMs: cfg.WebSocketReconnectInitialMs,
+		MaxDelayMs:     cfg.WebSocketReconnectMaxMs,
+		Multiplier:     2,
+		Jitter:         0.5,
+	}
+}
+
+// Append adds a message to the customer's stream and returns its ID
+func (b *WebSocketMessageBuffer) Append(ctx context.Context, customerID string, payload []byte) (string, error) {
+	key := wsReplayKey(customerID)
+	pipe := b.redis.client.TxPipeline()
+	add := pipe.XAdd(ctx, &redis.XAddArgs{
+		Stream:       key,
+		MaxLenApprox: b.maxLen,
+		Values:       map[string]interface{}{"message": payload},
+	})
+	pipe.Expire(ctx, key, b.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+	return add.Val(), nil
+}
+
+// IssueToken creates a resume token positioned at the newest buffered message, so a
+// resume without a last message ID replays everything sent after the token was issued
+func (b *WebSocketMessageBuffer) IssueToken(ctx context.Context, customerID string) (string, error) {
+	from := "0-0"
+	latest, err := b.redis.client.XRevRangeN(ctx, wsReplayKey(customerID), "+", "-", 1).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return "", err
+	}
+	if len(latest) > 0 {
+		from = latest[0].ID
+	}
+
+	token := uuid.New().String()
+	pipe := b.redis.client.TxPipeline()
+	pipe.HSet(ctx, wsResumeKey(token), "customer", customerID, "from", from)
+	pipe.Expire(ctx, wsResumeKey(token), b.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Replay redeems a resume token and returns the messages after the client's last message
+// ID, or after the token's issue position when none is given. The token's TTL is renewed.
+func (b *WebSocketMessageBuffer) Replay(ctx context.Context, customerID string, resume models.ResumeRequest) ([]models.ReplayedMessage, error) {
+	session, err := b.redis.client.HGetAll(ctx, wsResumeKey(resume.Token)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if session["customer"] != customerID {
+		telemetry.RecordWebSocketResume(ctx, false, 0)
+		return nil, ErrResumeTokenInvalid
+	}
+
+	from := session["from"]
+	if resume.LastMessageID != "" {
+		from = resume.LastMessageID
+	}
+
+	// The range start is inclusive, so the client's own last message is dropped below
+	entries, err := b.redis.client.XRangeN(ctx, wsReplayKey(customerID), from, "+", b.maxLen+1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("reading replay buffer from %q: %w", from, err)
+	}
+	if err := b.redis.client.Expire(ctx, wsResumeKey(resume.Token), b.ttl).Err(); err != nil {
+		return nil, err
+	}
+
+	messages := make([]models.ReplayedMessage, 0, len(entries))
+	for _, entry := range entries {
+		if entry.ID == from {
+			continue
+		}
+		payload, ok := entry.Values["message"].(string)
+		if !ok {
+			continue
+		}
+		messages = append(messages, models.ReplayedMessage{ID: entry.ID, Payload: []byte(payload)})
+	}
+	telemetry.RecordWebSocketResume(ctx, true, len(messages))
+	return messages, nil
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// claimWorkScript hands out due jobs under a lease. Jobs whose lease ran out, because
+// the replica working on them stopped, are put back first and handed out again.
+var claimWorkScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local expired = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', now, 'LIMIT', 0, 100)
+for _, id in ipairs(expired) do
+	redis.call('ZREM', KEYS[2], id)
+	redis.call('ZADD', KEYS[1], now, id)
+end
+local due = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', now, 'LIMIT', 0, tonumber(ARGV[3]))
+for _, id in ipairs(due) do
+	redis.call('ZREM', KEYS[1], id)
+	redis.call('ZADD', KEYS[2], now + tonumber(ARGV[2]), id)
+end
+return due
+`)
+
+// workQueue is a queue of job IDs shared by every replica. A sorted set holds the jobs
+// scored by the Unix millisecond they are due; claiming a job moves it to a second set
+// scored by when its lease runs out. The worker extends the lease while it runs and
+// finishes the job when it is done. A job whose lease runs out is handed out again, so
+// a replica stopping mid-job doesn't lose it. The lease set's key is hash-tagged with
+// the queue's, so both are in one cluster slot.
+type workQueue struct {
+	redis  *RedisClient
+	queue  string
+	leases string
+	lease  time.Duration
+}
+
+func newWorkQueue(redis *RedisClient, queue string, lease time.Duration) *workQueue {
+	return &workQueue{redis: redis, queue: queue, leases: "{" + queue + "}:leases", lease: lease}
+}
+
+// Add queues a job, due at due
+func (q *workQueue) Add(ctx context.Context, client redis.Cmdable, id string, due time.Time) *redis.IntCmd {
+	return client.ZAdd(ctx, q.queue, &redis.Z{Score: float64(due.UnixMilli()), Member: id})
+}
+
+// Claim leases up to count due jobs to the caller
+func (q *workQueue) Claim(ctx context.Context, count int64) ([]string, error) {
+	now := time.Now().UnixMilli()
+	ids, err := claimWorkScript.Run(ctx, q.redis.client, []string{q.queue, q.leases},
+		now, q.lease.Milliseconds(), count).StringSlice()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, fmt.Errorf("failed to claim from %s: %w", q.queue, err)
+	}
+	return ids, nil
+}
+
+// Hold extends a claimed job's lease every third of it until the returned function is
+// called, for jobs that may run longer than one lease
+func (q *workQueue) Hold(ctx context.Context, id string) func() {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(q.lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				expiresAt := float64(time.Now().Add(q.lease).UnixMilli())
+				if err := q.redis.client.ZAddXX(ctx, q.leases, &redis.Z{Score: expiresAt, Member: id}).Err(); err != nil {
+					slog.WarnContext(ctx, "Failed to extend job lease", "queue", q.queue, "job.id", id, "error", err)
+				}
+			}
+		}
+	}()
+	return cancel
+}
+
+// Done ends a claimed job's lease; the job isn't handed out again
+func (q *workQueue) Done(ctx context.Context, id string) error {
+	return q.redis.client.ZRem(ctx, q.leases, id).Err()
+}
+
+// Remove drops a job, whether it is waiting or claimed; a claimed one's worker still
+// finishes it
+func (q *workQueue) Remove(ctx context.Context, id string) error {
+	_, err := q.redis.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, q.queue, id)
+		pipe.ZRem(ctx, q.leases, id)
+		return nil
+	})
+	return err
+}
+
+// Retry ends a claimed job's lease and queues it again, due at due
+func (q *workQueue) Retry(ctx context.Context, id string, due time.Time) error {
+	_, err := q.redis.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, q.leases, id)
+		q.Add(ctx, pipe, id, due)
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// backfillBatchSize is how many rows each backfill statement updates
+const backfillBatchSize = 1000
+
+// notificationFiltersBackfill fills the order_id and priority columns added by migration
+// 0004 from the payload of rows written before them
+const notificationFiltersBackfill = "notification_filters"
+
+// backfillSchema fills columns added by migrations for rows that predate them. Rows are
+// updated a batch at a time in ID order, each batch its own statement, so no lock is
+// held on the whole table. Progress is kept in schema_backfills, so a backfill that was
+// interrupted resumes where it stopped and one that finished isn't run again. Replicas
+// starting together may fill the same batch twice, which writes the same values.
+func backfillSchema(ctx context.Context, databaseURL string) error {
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	var lastID string
+	var done bool
+	err = db.QueryRowContext(ctx, `SELECT last_id, done FROM schema_backfills WHERE name = $1`,
+		notificationFiltersBackfill).Scan(&lastID, &done)
+	if errors.Is(err, sql.ErrNoRows) || done {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read backfill progress: %w", err)
+	}
+
+	filled := 0
+	for {
+		var next sql.NullString
+		err := db.QueryRowContext(ctx, `WITH batch AS (
+				SELECT id FROM notifications WHERE id > $1 ORDER BY id LIMIT $2
+			), filled AS (
+				UPDATE notifications n SET
+					order_id = COALESCE(n.payload->>'order_id', ''),
+					priority = COALESCE(NULLIF(n.payload->>'priority', ''), 'normal')
+				FROM batch WHERE n.id = batch.id
+			)
+			SELECT max(id) FROM batch`, lastID, backfillBatchSize).Scan(&next)
+		if err != nil {
+			return fmt.Errorf("failed to backfill %s: %w", notificationFiltersBackfill, err)
+		}
+		if next.Valid {
+			lastID = next.String
+			filled += backfillBatchSize
+		}
+		if _, err := db.ExecContext(ctx, `UPDATE schema_backfills SET last_id = $2, done = $3 WHERE name = $1`,
+			notificationFiltersBackfill, lastID, !next.Valid); err != nil {
+			return fmt.Errorf("failed to save backfill progress: %w", err)
+		}
+		if !next.Valid {
+			log.Printf("✓ Backfilled %s", notificationFiltersBackfill)
+			return nil
+		}
+		if filled%(100*backfillBatchSize) == 0 {
+			log.Printf("  %s: %d rows backfilled", notificationFiltersBackfill, filled)
+		}
+	}
+}
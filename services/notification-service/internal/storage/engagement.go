@@ -0,0 +1,259 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"notification-service/internal/models"
+)
+
+// EngagementFilter narrows an engagement event query. Empty fields match everything;
+// From is inclusive and To exclusive on the time the event occurred.
+type EngagementFilter struct {
+	CustomerID     string
+	NotificationID string
+	Type           models.EngagementEventType
+	From           time.Time
+	To             time.Time
+	Cursor         string
+	Limit          int
+}
+
+// EngagementRepository is the append-only engagement event store. Events list in the
+// order they were recorded so exports can page through with the cursor; an empty next
+// cursor means done.
+type EngagementRepository interface {
+	AppendEngagementEvent(ctx context.Context, event *models.EngagementEvent) error
+	ListEngagementEvents(ctx context.Context, filter EngagementFilter) ([]*models.EngagementEvent, string, error)
+	EngagementRollup(ctx context.Context, filter EngagementFilter) ([]models.EngagementRollup, error)
+	EngagementHours(ctx context.Context, filter EngagementFilter) ([24]int64, error)
+	EngagementRates(ctx context.Context, filter EngagementFilter, channel models.NotificationType) ([]models.EngagementRates, error)
+	Close() error
+}
+
+var _ EngagementRepository = (*PostgresEngagementRepository)(nil)
+
+// PostgresEngagementRepository stores engagement events in the engagement_events table,
+// which rejects updates and deletes
+type PostgresEngagementRepository struct {
+	db *sql.DB
+}
+
+func NewPostgresEngagementRepository(ctx context.Context, databaseURL string) (*PostgresEngagementRepository, error) {
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	return &PostgresEngagementRepository{db: db}, nil
+}
+
+func (r *PostgresEngagementRepository) Close() error {
+	return r.db.Close()
+}
+
+// AppendEngagementEvent inserts the event and fills in its ID and recorded time
+func (r *PostgresEngagementRepository) AppendEngagementEvent(ctx context.Context, event *models.EngagementEvent) error {
+	attributes := []byte("{}")
+	if len(event.Attributes) > 0 {
+		encoded, err := json.Marshal(event.Attributes)
+		if err != nil {
+			return fmt.Errorf("failed to marshal engagement attributes: %w", err)
+		}
+		attributes = encoded
+	}
+
+	err := r.db.QueryRowContext(ctx, `INSERT INTO engagement_events (type, notification_id, customer_id, channel, occurred_at, attributes)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, recorded_at`,
+		string(event.Type), event.NotificationID, event.CustomerID, string(event.Channel), event.OccurredAt, attributes,
+	).Scan(&event.ID, &event.RecordedAt)
+	if err != nil {
+		return fmt.Errorf("failed to store engagement event: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresEngagementRepository) ListEngagementEvents(ctx context.Context, filter EngagementFilter) ([]*models.EngagementEvent, string, error) {
+	conditions, args := engagementConditions(filter)
+	if filter.Cursor != "" {
+		after, err := strconv.ParseInt(filter.Cursor, 10, 64)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w %q", ErrInvalidCursor, filter.Cursor)
+		}
+		args = append(args, after)
+		conditions = append(conditions, "id > $"+strconv.Itoa(len(args)))
+	}
+
+	query := `SELECT id, type, notification_id, customer_id, channel, occurred_at, recorded_at, attributes FROM engagement_events`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	args = append(args, filter.Limit)
+	query += ` ORDER BY id LIMIT $` + strconv.Itoa(len(args))
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list engagement events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*models.EngagementEvent
+	for rows.Next() {
+		var event models.EngagementEvent
+		var eventType, channel string
+		var attributes []byte
+		if err := rows.Scan(&event.ID, &eventType, &event.NotificationID, &event.CustomerID, &channel,
+			&event.OccurredAt, &event.RecordedAt, &attributes); err != nil {
+			return nil, "", err
+		}
+		event.Type = models.EngagementEventType(eventType)
+		event.Channel = models.NotificationType(channel)
+		if err := json.Unmarshal(attributes, &event.Attributes); err != nil {
+			return nil, "", fmt.Errorf("failed to decode engagement event %d: %w", event.ID, err)
+		}
+		events = append(events, &event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	if len(events) < filter.Limit {
+		return events, "", nil
+	}
+	return events, strconv.FormatInt(events[len(events)-1].ID, 10), nil
+}
+
+// EngagementRollup counts events, distinct customers and distinct notifications per type
+func (r *PostgresEngagementRepository) EngagementRollup(ctx context.Context, filter EngagementFilter) ([]models.EngagementRollup, error) {
+	conditions, args := engagementConditions(filter)
+
+	query := `SELECT type, count(*), count(DISTINCT customer_id), count(DISTINCT notification_id) FROM engagement_events`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	query += ` GROUP BY type ORDER BY type`
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate engagement events: %w", err)
+	}
+	defer rows.Close()
+
+	var rollups []models.EngagementRollup
+	for rows.Next() {
+		var rollup models.EngagementRollup
+		var eventType string
+		if err := rows.Scan(&eventType, &rollup.Events, &rollup.Customers, &rollup.Notifications); err != nil {
+			return nil, err
+		}
+		rollup.Type = models.EngagementEventType(eventType)
+		rollups = append(rollups, rollup)
+	}
+	return rollups, rows.Err()
+}
+
+// EngagementHours counts positive interactions (everything but snoozes) per UTC hour of
+// the day they occurred
+func (r *PostgresEngagementRepository) EngagementHours(ctx context.Context, filter EngagementFilter) ([24]int64, error) {
+	var hours [24]int64
+	conditions, args := engagementConditions(filter)
+	args = append(args, string(models.EngagementSnoozed))
+	conditions = append(conditions, "type <> $"+strconv.Itoa(len(args)))
+
+	query := `SELECT EXTRACT(HOUR FROM occurred_at AT TIME ZONE 'UTC')::int, count(*) FROM engagement_events
+		WHERE ` + strings.Join(conditions, " AND ") + ` GROUP BY 1`
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return hours, fmt.Errorf("failed to aggregate engagement hours: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hour int
+		var count int64
+		if err := rows.Scan(&hour, &count); err != nil {
+			return hours, err
+		}
+		if hour >= 0 && hour < 24 {
+			hours[hour] = count
+		}
+	}
+	return hours, rows.Err()
+}
+
+// EngagementRates counts, per template, the notifications on channel created in the
+// filter's window that were sent, and how many of them were opened or clicked at any
+// time since. Notifications without a template have an empty template ID.
+func (r *PostgresEngagementRepository) EngagementRates(ctx context.Context, filter EngagementFilter, channel models.NotificationType) ([]models.EngagementRates, error) {
+	args := []interface{}{string(channel), string(models.NotificationStatusSent), string(models.NotificationStatusDelivered),
+		string(models.EngagementOpened), string(models.EngagementClicked)}
+	conditions := []string{"n.type = $1", "n.status IN ($2, $3)"}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if filter.CustomerID != "" {
+		conditions = append(conditions, "n.customer_id = "+arg(filter.CustomerID))
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "n.created_at >= "+arg(filter.From))
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "n.created_at < "+arg(filter.To))
+	}
+
+	query := `SELECT template_id, count(*), count(*) FILTER (WHERE opened OR clicked), count(*) FILTER (WHERE clicked)
+		FROM (SELECT COALESCE(n.payload->>'template_id', '') AS template_id,
+				EXISTS (SELECT 1 FROM engagement_events e WHERE e.notification_id = n.id AND e.type = $4) AS opened,
+				EXISTS (SELECT 1 FROM engagement_events e WHERE e.notification_id = n.id AND e.type = $5) AS clicked
+			FROM notifications n WHERE ` + strings.Join(conditions, " AND ") + `) sent
+		GROUP BY template_id ORDER BY count(*) DESC, template_id`
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate engagement rates: %w", err)
+	}
+	defer rows.Close()
+
+	var rates []models.EngagementRates
+	for rows.Next() {
+		var rate models.EngagementRates
+		if err := rows.Scan(&rate.TemplateID, &rate.Sent, &rate.Opened, &rate.Clicked); err != nil {
+			return nil, err
+		}
+		rates = append(rates, rate)
+	}
+	return rates, rows.Err()
+}
+
+func engagementConditions(filter EngagementFilter) ([]string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if filter.CustomerID != "" {
+		conditions = append(conditions, "customer_id = "+arg(filter.CustomerID))
+	}
+	if filter.NotificationID != "" {
+		conditions = append(conditions, "notification_id = "+arg(filter.NotificationID))
+	}
+	if filter.Type != "" {
+		conditions = append(conditions, "type = "+arg(string(filter.Type)))
+	}
+	if !filter.From.IsZero() {
+		conditions = append(conditions, "occurred_at >= "+arg(filter.From))
+	}
+	if !filter.To.IsZero() {
+		conditions = append(conditions, "occurred_at < "+arg(filter.To))
+	}
+	return conditions, args
+}
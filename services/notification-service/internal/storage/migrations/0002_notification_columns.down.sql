@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS notifications_status_created_idx;
+DROP INDEX IF EXISTS notifications_customer_created_idx;
+DROP INDEX IF EXISTS notifications_created_idx;
+
+ALTER TABLE notifications DROP COLUMN IF EXISTS created_at;
+ALTER TABLE notifications DROP COLUMN IF EXISTS type;
+ALTER TABLE notifications DROP COLUMN IF EXISTS status;
+ALTER TABLE notifications DROP COLUMN IF EXISTS customer_id;
@@ -0,0 +1,15 @@
+-- Queryable columns for notification listing; payload stays the full record
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS customer_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT '';
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS type TEXT NOT NULL DEFAULT '';
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+UPDATE notifications SET
+    customer_id = COALESCE(payload->>'customer_id', ''),
+    status = COALESCE(payload->>'status', ''),
+    type = COALESCE(payload->>'type', ''),
+    created_at = COALESCE((payload->>'created_at')::timestamptz, updated_at);
+
+CREATE INDEX IF NOT EXISTS notifications_created_idx ON notifications (created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS notifications_customer_created_idx ON notifications (customer_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS notifications_status_created_idx ON notifications (status, created_at DESC, id DESC);
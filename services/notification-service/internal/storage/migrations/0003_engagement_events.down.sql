@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS engagement_events_append_only ON engagement_events;
+DROP FUNCTION IF EXISTS engagement_events_append_only();
+DROP TABLE IF EXISTS engagement_events;
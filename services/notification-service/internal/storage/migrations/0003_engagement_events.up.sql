@@ -0,0 +1,26 @@
+-- Append-only stream of recipient interactions with delivered notifications
+CREATE TABLE IF NOT EXISTS engagement_events (
+    id BIGSERIAL PRIMARY KEY,
+    type TEXT NOT NULL,
+    notification_id TEXT NOT NULL,
+    customer_id TEXT NOT NULL,
+    channel TEXT NOT NULL DEFAULT '',
+    occurred_at TIMESTAMPTZ NOT NULL,
+    recorded_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    attributes JSONB NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX IF NOT EXISTS engagement_events_customer_idx ON engagement_events (customer_id, id);
+CREATE INDEX IF NOT EXISTS engagement_events_notification_idx ON engagement_events (notification_id, id);
+CREATE INDEX IF NOT EXISTS engagement_events_occurred_idx ON engagement_events (occurred_at);
+
+CREATE OR REPLACE FUNCTION engagement_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'engagement_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS engagement_events_append_only ON engagement_events;
+CREATE TRIGGER engagement_events_append_only
+    BEFORE UPDATE OR DELETE ON engagement_events
+    FOR EACH ROW EXECUTE FUNCTION engagement_events_append_only();
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS notifications_priority_created_idx;
+DROP INDEX IF EXISTS notifications_order_created_idx;
+
+DROP TABLE IF EXISTS schema_backfills;
+
+ALTER TABLE notifications DROP COLUMN IF EXISTS priority;
+ALTER TABLE notifications DROP COLUMN IF EXISTS order_id;
@@ -0,0 +1,15 @@
+-- Order and priority columns so notification listings can filter on them. Existing rows
+-- are filled from their payload in batches once the migrations have run (backfill.go),
+-- so no statement rewrites the whole table at once.
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS order_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE notifications ADD COLUMN IF NOT EXISTS priority TEXT NOT NULL DEFAULT 'normal';
+
+CREATE TABLE IF NOT EXISTS schema_backfills (
+    name TEXT PRIMARY KEY,
+    last_id TEXT NOT NULL DEFAULT '',
+    done BOOLEAN NOT NULL DEFAULT false
+);
+INSERT INTO schema_backfills (name) VALUES ('notification_filters') ON CONFLICT (name) DO NOTHING;
+
+CREATE INDEX IF NOT EXISTS notifications_order_created_idx ON notifications (order_id, created_at DESC, id DESC) WHERE order_id <> '';
+CREATE INDEX IF NOT EXISTS notifications_priority_created_idx ON notifications (priority, created_at DESC, id DESC);
@@ -0,0 +1,379 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"notification-service/internal/models"
+)
+
+var (
+	// ErrNotFound means the requested record does not exist
+	ErrNotFound = errors.New("record not found")
+	// ErrInvalidCursor means a list cursor was not produced by this repository
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// NotificationFilter narrows a notification listing. Empty fields match everything.
+type NotificationFilter struct {
+	CustomerID string
+	Status     models.NotificationStatus
+	Type       models.NotificationType
+	OrderID    string
+	Priority   models.Priority
+	// CreatedAfter and CreatedBefore bound created_at, inclusive and exclusive
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// ErrorClass, FailedAfter and FailedBefore select failed notifications by their
+	// error class and when they failed, inclusive and exclusive
+	ErrorClass   models.ErrorClass
+	FailedAfter  time.Time
+	FailedBefore time.Time
+	Cursor       string
+	Limit        int
+	// Ascending pages oldest first instead of newest first
+	Ascending bool
+	// ExcludeReplaced leaves out notifications a newer one replaced through its collapse key
+	ExcludeReplaced bool
+}
+
+// NotificationRepository is the durable notification store. Listing pages by creation
+// time with an opaque cursor, which carries the sort order of the listing it continues;
+// an empty next cursor means done.
+type NotificationRepository interface {
+	GetNotification(ctx context.Context, id string) (*models.Notification, error)
+	ListNotifications(ctx context.Context, filter NotificationFilter) ([]*models.Notification, string, error)
+	CountNotifications(ctx context.Context, filter NotificationFilter) (int64, error)
+	UpsertNotification(ctx context.Context, notification *models.Notification) error
+	DeleteNotification(ctx context.Context, id string) error
+	DeliveryRollup(ctx context.Context, since time.Time) ([]models.DeliveryRollup, error)
+	TemplateFailures(ctx context.Context, since time.Time, limit int) ([]models.TemplateFailures, error)
+	QueueDepths(ctx context.Context) ([]models.QueueDepth, error)
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+var _ NotificationRepository = (*PostgresNotificationRepository)(nil)
+
+// PostgresNotificationRepository keeps the full record as JSONB alongside the columns
+// used for filtering and ordering
+type PostgresNotificationRepository struct {
+	db *sql.DB
+}
+
+// NewPostgresNotificationRepository connects to the database; the schema is owned by
+// the embedded migrations, which main applies on startup
+func NewPostgresNotificationRepository(ctx context.Context, databaseURL string) (*PostgresNotificationRepository, error) {
+	repo, err := OpenPostgresNotificationRepository(databaseURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := repo.Ping(ctx); err != nil {
+		repo.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	return repo, nil
+}
+
+// OpenPostgresNotificationRepository opens the database without waiting for it. The
+// connection pool connects on first use and again after a failure, so a database that
+// is down at startup is used once it comes back.
+func OpenPostgresNotificationRepository(databaseURL string) (*PostgresNotificationRepository, error) {
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return &PostgresNotificationRepository{db: db}, nil
+}
+
+// Ping checks that the database is reachable
+func (r *PostgresNotificationRepository) Ping(ctx context.Context) error {
+	return r.db.PingContext(ctx)
+}
+
+func (r *PostgresNotificationRepository) Close() error {
+	return r.db.Close()
+}
+
+func (r *PostgresNotificationRepository) GetNotification(ctx context.Context, id string) (*models.Notification, error) {
+	var payload []byte
+	err := r.db.QueryRowContext(ctx, `SELECT payload FROM notifications WHERE id = $1`, id).Scan(&payload)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load notification: %w", err)
+	}
+
+	var notification models.Notification
+	if err := json.Unmarshal(payload, &notification); err != nil {
+		return nil, fmt.Errorf("failed to decode notification %s: %w", id, err)
+	}
+	return &notification, nil
+}
+
+// notificationConditions builds the WHERE conditions of a filter, leaving out the cursor
+func notificationConditions(filter NotificationFilter, arg func(value interface{}) string) []string {
+	var conditions []string
+	if filter.CustomerID != "" {
+		conditions = append(conditions, "customer_id = "+arg(filter.CustomerID))
+	}
+	if filter.Status != "" {
+		conditions = append(conditions, "status = "+arg(string(filter.Status)))
+	}
+	if filter.Type != "" {
+		conditions = append(conditions, "type = "+arg(string(filter.Type)))
+	}
+	if filter.OrderID != "" {
+		conditions = append(conditions, "order_id = "+arg(filter.OrderID))
+	}
+	if filter.Priority != "" {
+		conditions = append(conditions, "priority = "+arg(string(filter.Priority)))
+	}
+	if !filter.CreatedAfter.IsZero() {
+		conditions = append(conditions, "created_at >= "+arg(filter.CreatedAfter))
+	}
+	if !filter.CreatedBefore.IsZero() {
+		conditions = append(conditions, "created_at < "+arg(filter.CreatedBefore))
+	}
+	if filter.ErrorClass != "" {
+		conditions = append(conditions, "payload->>'error_class' = "+arg(string(filter.ErrorClass)))
+	}
+	if !filter.FailedAfter.IsZero() {
+		conditions = append(conditions, "(payload->>'failed_at')::timestamptz >= "+arg(filter.FailedAfter))
+	}
+	if !filter.FailedBefore.IsZero() {
+		conditions = append(conditions, "(payload->>'failed_at')::timestamptz < "+arg(filter.FailedBefore))
+	}
+	if filter.ExcludeReplaced {
+		conditions = append(conditions, "COALESCE(payload->>'replaced_by', '') = ''")
+	}
+	return conditions
+}
+
+func (r *PostgresNotificationRepository) ListNotifications(ctx context.Context, filter NotificationFilter) ([]*models.Notification, string, error) {
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+	conditions := notificationConditions(filter, arg)
+	var createdAt time.Time
+	var id string
+	if filter.Cursor != "" {
+		var err error
+		if createdAt, id, filter.Ascending, err = decodeNotificationCursor(filter.Cursor); err != nil {
+			return nil, "", err
+		}
+	}
+	comparison, order := "<", "DESC"
+	if filter.Ascending {
+		comparison, order = ">", "ASC"
+	}
+	if filter.Cursor != "" {
+		conditions = append(conditions, fmt.Sprintf("(created_at, id) %s (%s, %s)", comparison, arg(createdAt), arg(id)))
+	}
+
+	query := `SELECT payload, created_at FROM notifications`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	query += fmt.Sprintf(` ORDER BY created_at %s, id %s LIMIT %s`, order, order, arg(filter.Limit))
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list notifications: %w", err)
+	}
+	defer rows.Close()
+
+	var notifications []*models.Notification
+	// The cursor carries the row's created_at as stored, which Postgres keeps to the
+	// microsecond, rather than the payload's nanosecond time
+	var lastCreatedAt time.Time
+	for rows.Next() {
+		var payload []byte
+		if err := rows.Scan(&payload, &lastCreatedAt); err != nil {
+			return nil, "", err
+		}
+		var notification models.Notification
+		if err := json.Unmarshal(payload, &notification); err != nil {
+			return nil, "", fmt.Errorf("failed to decode notification: %w", err)
+		}
+		notifications = append(notifications, &notification)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	if len(notifications) < filter.Limit {
+		return notifications, "", nil
+	}
+	last := notifications[len(notifications)-1]
+	return notifications, encodeNotificationCursor(lastCreatedAt, last.ID, filter.Ascending), nil
+}
+
+// CountNotifications counts every notification the filter matches, ignoring its cursor
+// and limit
+func (r *PostgresNotificationRepository) CountNotifications(ctx context.Context, filter NotificationFilter) (int64, error) {
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return "$" + strconv.Itoa(len(args))
+	}
+	query := `SELECT count(*) FROM notifications`
+	if conditions := notificationConditions(filter, arg); len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	var count int64
+	if err := r.db.QueryRowContext(ctx, query, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count notifications: %w", err)
+	}
+	return count, nil
+}
+
+func (r *PostgresNotificationRepository) UpsertNotification(ctx context.Context, notification *models.Notification) error {
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %w", err)
+	}
+	priority := notification.Priority
+	if priority == "" {
+		priority = models.PriorityNormal
+	}
+	_, err = r.db.ExecContext(ctx, `INSERT INTO notifications (id, payload, customer_id, status, type, order_id, priority, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, now())
+		ON CONFLICT (id) DO UPDATE SET payload = EXCLUDED.payload, customer_id = EXCLUDED.customer_id,
+			status = EXCLUDED.status, type = EXCLUDED.type, order_id = EXCLUDED.order_id,
+			priority = EXCLUDED.priority, updated_at = now()`,
+		notification.ID, payload, notification.CustomerID, string(notification.Status), string(notification.Type),
+		notification.OrderID, string(priority), notification.CreatedAt.Truncate(time.Microsecond))
+	if err != nil {
+		return fmt.Errorf("failed to store notification: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresNotificationRepository) DeleteNotification(ctx context.Context, id string) error {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM notifications WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete notification: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DeliveryRollup counts the notifications created since the given time per type,
+// priority and status. A notification is due when it was created, or at its scheduled
+// time, and took until it was delivered, or sent when there is no delivery receipt.
+func (r *PostgresNotificationRepository) DeliveryRollup(ctx context.Context, since time.Time) ([]models.DeliveryRollup, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT type, COALESCE(NULLIF(payload->>'priority', ''), 'normal'), status, count(*),
+			count(COALESCE(payload->>'delivered_at', payload->>'sent_at')),
+			COALESCE(sum(GREATEST(EXTRACT(EPOCH FROM
+				COALESCE(payload->>'delivered_at', payload->>'sent_at')::timestamptz
+				- GREATEST(created_at, COALESCE((payload->>'scheduled_at')::timestamptz, created_at))), 0)), 0)::float8
+		FROM notifications WHERE created_at >= $1
+		GROUP BY 1, 2, 3`, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate notifications: %w", err)
+	}
+	defer rows.Close()
+
+	var rollups []models.DeliveryRollup
+	for rows.Next() {
+		var rollup models.DeliveryRollup
+		var notificationType, priority, status string
+		if err := rows.Scan(&notificationType, &priority, &status, &rollup.Count, &rollup.Timed, &rollup.DeliverySeconds); err != nil {
+			return nil, err
+		}
+		rollup.Type = models.NotificationType(notificationType)
+		rollup.Priority = models.Priority(priority)
+		rollup.Status = models.NotificationStatus(status)
+		rollups = append(rollups, rollup)
+	}
+	return rollups, rows.Err()
+}
+
+// TemplateFailures counts the notifications created since the given time that failed,
+// per template, for the limit templates failing most
+func (r *PostgresNotificationRepository) TemplateFailures(ctx context.Context, since time.Time, limit int) ([]models.TemplateFailures, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT payload->>'template_id', count(*)
+		FROM notifications
+		WHERE created_at >= $1 AND status = $2 AND COALESCE(payload->>'template_id', '') <> ''
+		GROUP BY 1 ORDER BY 2 DESC, 1 LIMIT $3`, since, models.NotificationStatusFailed, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count template failures: %w", err)
+	}
+	defer rows.Close()
+
+	var failures []models.TemplateFailures
+	for rows.Next() {
+		var failure models.TemplateFailures
+		if err := rows.Scan(&failure.TemplateID, &failure.Failures); err != nil {
+			return nil, err
+		}
+		failures = append(failures, failure)
+	}
+	return failures, rows.Err()
+}
+
+// QueueDepths counts the notifications waiting to be sent by priority: pending ones due
+// now, pending ones scheduled for later, and those waiting for a retry
+func (r *PostgresNotificationRepository) QueueDepths(ctx context.Context) ([]models.QueueDepth, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT CASE
+				WHEN status = 'retrying' THEN 'retrying'
+				WHEN (payload->>'scheduled_at')::timestamptz > now() THEN 'scheduled'
+				ELSE 'pending' END,
+			COALESCE(NULLIF(payload->>'priority', ''), 'normal'), count(*)
+		FROM notifications WHERE status IN ('pending', 'retrying')
+		GROUP BY 1, 2`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count queued notifications: %w", err)
+	}
+	defer rows.Close()
+
+	var depths []models.QueueDepth
+	for rows.Next() {
+		var depth models.QueueDepth
+		var priority string
+		if err := rows.Scan(&depth.Queue, &priority, &depth.Count); err != nil {
+			return nil, err
+		}
+		depth.Priority = models.Priority(priority)
+		depths = append(depths, depth)
+	}
+	return depths, rows.Err()
+}
+
+// Cursors are "<asc|desc>_<created_at unix nanos>_<id>": the sort order of the listing
+// and the keyset of the last row returned, so the next page continues in the same order
+// whatever order it asks for. created_at is written and encoded to the microsecond, as
+// Postgres keeps it, so the next page starts exactly after the row.
+func encodeNotificationCursor(createdAt time.Time, id string, ascending bool) string {
+	order := "desc"
+	if ascending {
+		order = "asc"
+	}
+	createdAt = createdAt.Truncate(time.Microsecond)
+	return order + "_" + strconv.FormatInt(createdAt.UnixNano(), 10) + "_" + id
+}
+
+func decodeNotificationCursor(cursor string) (time.Time, string, bool, error) {
+	order, keyset, _ := strings.Cut(cursor, "_")
+	nanos, id, ok := strings.Cut(keyset, "_")
+	if !ok || (order != "asc" && order != "desc") {
+		return time.Time{}, "", false, fmt.Errorf("%w %q", ErrInvalidCursor, cursor)
+	}
+	value, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return time.Time{}, "", false, fmt.Errorf("%w %q", ErrInvalidCursor, cursor)
+	}
+	return time.Unix(0, value).UTC(), id, order == "asc", nil
+}
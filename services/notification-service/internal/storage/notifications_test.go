@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNotificationCursor(t *testing.T) {
+	createdAt := time.Date(2026, 3, 14, 9, 26, 53, 589793238, time.UTC)
+	for _, ascending := range []bool{false, true} {
+		cursor := encodeNotificationCursor(createdAt, "n-1", ascending)
+		decodedAt, id, decodedAscending, err := decodeNotificationCursor(cursor)
+		if err != nil {
+			t.Fatalf("decode %q: %v", cursor, err)
+		}
+		// Postgres keeps microseconds, so the cursor must point at the row as stored
+		if want := createdAt.Truncate(time.Microsecond); !decodedAt.Equal(want) {
+			t.Errorf("created_at = %v, want %v", decodedAt, want)
+		}
+		if id != "n-1" || decodedAscending != ascending {
+			t.Errorf("cursor %q decoded to id %q, ascending %t", cursor, id, decodedAscending)
+		}
+	}
+
+	// IDs may contain the separator; only the first two split the cursor
+	if _, id, _, err := decodeNotificationCursor(encodeNotificationCursor(createdAt, "order_42_email", false)); err != nil || id != "order_42_email" {
+		t.Errorf("id = %q, err = %v, want order_42_email", id, err)
+	}
+}
+
+func TestDecodeNotificationCursorRejectsForeignCursors(t *testing.T) {
+	for _, cursor := range []string{"", "n-1", "up_1_n-1", "desc_soon_n-1", "desc_1"} {
+		if _, _, _, err := decodeNotificationCursor(cursor); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("cursor %q: error = %v, want ErrInvalidCursor", cursor, err)
+		}
+	}
+}
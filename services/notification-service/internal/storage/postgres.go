@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"notification-service/internal/models"
+
+	_ "github.com/lib/pq"
+)
+
+// PostgresBackend stores each record as JSONB keyed by its ID
+type PostgresBackend struct {
+	db *sql.DB
+}
+
+func NewPostgresBackend(ctx context.Context, databaseURL string) (*PostgresBackend, error) {
+	db, err := sql.Open("postgres", databaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	// The schema is owned by the embedded migrations
+	if _, err := MigrateSchema(ctx, databaseURL); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &PostgresBackend{db: db}, nil
+}
+
+func (p *PostgresBackend) Name() string {
+	return "postgres"
+}
+
+func (p *PostgresBackend) Close() error {
+	return p.db.Close()
+}
+
+func (p *PostgresBackend) ListNotifications(ctx context.Context, cursor string, limit int) ([]*models.Notification, string, error) {
+	var out []*models.Notification
+	next, err := p.list(ctx, "notifications", cursor, limit, func(value []byte) error {
+		var notification models.Notification
+		if err := json.Unmarshal(value, &notification); err != nil {
+			return err
+		}
+		out = append(out, &notification)
+		return nil
+	})
+	return out, next, err
+}
+
+// PutNotification goes through the repository so the query columns are filled in
+func (p *PostgresBackend) PutNotification(ctx context.Context, notification *models.Notification) error {
+	return (&PostgresNotificationRepository{db: p.db}).UpsertNotification(ctx, notification)
+}
+
+func (p *PostgresBackend) ListTemplates(ctx context.Context, cursor string, limit int) ([]*models.NotificationTemplate, string, error) {
+	var out []*models.NotificationTemplate
+	next, err := p.list(ctx, "notification_templates", cursor, limit, func(value []byte) error {
+		var template models.NotificationTemplate
+		if err := json.Unmarshal(value, &template); err != nil {
+			return err
+		}
+		out = append(out, &template)
+		return nil
+	})
+	return out, next, err
+}
+
+func (p *PostgresBackend) PutTemplate(ctx context.Context, template *models.NotificationTemplate) error {
+	return p.put(ctx, "notification_templates", template.ID, template)
+}
+
+func (p *PostgresBackend) ListPreferences(ctx context.Context, cursor string, limit int) ([]*models.CustomerPreferences, string, error) {
+	var out []*models.CustomerPreferences
+	next, err := p.list(ctx, "customer_preferences", cursor, limit, func(value []byte) error {
+		var preferences models.CustomerPreferences
+		if err := json.Unmarshal(value, &preferences); err != nil {
+			return err
+		}
+		out = append(out, &preferences)
+		return nil
+	})
+	return out, next, err
+}
+
+func (p *PostgresBackend) PutPreferences(ctx context.Context, preferences *models.CustomerPreferences) error {
+	return p.put(ctx, "customer_preferences", preferences.CustomerID, preferences)
+}
+
+func (p *PostgresBackend) put(ctx context.Context, table, id string, value interface{}) error {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = p.db.ExecContext(ctx, fmt.Sprintf(`INSERT INTO %s (id, payload, updated_at) VALUES ($1, $2, now())
+		ON CONFLICT (id) DO UPDATE SET payload = EXCLUDED.payload, updated_at = now()`, table), id, payload)
+	return err
+}
+
+// list pages through a table in ID order; the cursor is the last ID returned
+func (p *PostgresBackend) list(ctx context.Context, table, cursor string, limit int, decode func([]byte) error) (string, error) {
+	rows, err := p.db.QueryContext(ctx, fmt.Sprintf(`SELECT id, payload FROM %s WHERE id > $1 ORDER BY id LIMIT $2`, table), cursor, limit)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var lastID string
+	count := 0
+	for rows.Next() {
+		var payload []byte
+		if err := rows.Scan(&lastID, &payload); err != nil {
+			return "", err
+		}
+		if err := decode(payload); err != nil {
+			return "", fmt.Errorf("failed to decode %s row %s: %w", table, lastID, err)
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	if count < limit {
+		return "", nil
+	}
+	return lastID, nil
+}
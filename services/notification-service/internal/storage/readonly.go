@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// readOnlyKey holds the maintenance flag shared by every replica
+const readOnlyKey = "notification-service:read-only"
+
+// ReadOnlyFlag is a cluster-wide read-only switch stored in Redis. Checks are cached
+// briefly so request paths don't hit Redis on every call.
+type ReadOnlyFlag struct {
+	client   *redis.Client
+	cacheTTL time.Duration
+
+	mutex     sync.Mutex
+	cached    bool
+	checkedAt time.Time
+}
+
+func NewReadOnlyFlag(client *redis.Client) *ReadOnlyFlag {
+	return &ReadOnlyFlag{client: client, cacheTTL: 2 * time.Second}
+}
+
+// Enable puts the service into read-only mode with a reason visible to callers. The
+// flag expires after ttl unless extended, so a holder that dies doesn't leave the
+// service read-only for good.
+func (f *ReadOnlyFlag) Enable(ctx context.Context, reason string, ttl time.Duration) error {
+	return f.client.Set(ctx, readOnlyKey, reason, ttl).Err()
+}
+
+// Extend keeps read-only mode on for another ttl. It fails with redis.Nil if the flag
+// has already expired or been cleared.
+func (f *ReadOnlyFlag) Extend(ctx context.Context, ttl time.Duration) error {
+	extended, err := f.client.Expire(ctx, readOnlyKey, ttl).Result()
+	if err == nil && !extended {
+		return redis.Nil
+	}
+	return err
+}
+
+// Disable returns the service to normal operation
+func (f *ReadOnlyFlag) Disable(ctx context.Context) error {
+	return f.client.Del(ctx, readOnlyKey).Err()
+}
+
+// IsReadOnly reports whether mutations are currently blocked. If Redis can't be
+// reached the flag is treated as off; the write path has its own outage handling.
+func (f *ReadOnlyFlag) IsReadOnly(ctx context.Context) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if time.Since(f.checkedAt) < f.cacheTTL {
+		return f.cached
+	}
+
+	exists, err := f.client.Exists(ctx, readOnlyKey).Result()
+	f.cached = err == nil && exists > 0
+	f.checkedAt = time.Now()
+	return f.cached
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestReadOnlyFlag(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	flag := NewReadOnlyFlag(NewRedisClient(server.Addr()))
+	flag.cacheTTL = 0
+
+	if flag.IsReadOnly(ctx) {
+		t.Fatal("read-only before the flag was set")
+	}
+	if err := flag.Enable(ctx, "schema migration", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if !flag.IsReadOnly(ctx) {
+		t.Error("not read-only after Enable")
+	}
+	if reason, _ := server.Get(readOnlyKey); reason != "schema migration" {
+		t.Errorf("reason = %q, want schema migration", reason)
+	}
+
+	// A holder that stops extending the flag doesn't leave the service read-only
+	server.FastForward(50 * time.Second)
+	if err := flag.Extend(ctx, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	server.FastForward(50 * time.Second)
+	if !flag.IsReadOnly(ctx) {
+		t.Error("extended flag expired early")
+	}
+	server.FastForward(20 * time.Second)
+	if flag.IsReadOnly(ctx) {
+		t.Error("still read-only after the flag expired")
+	}
+	if err := flag.Extend(ctx, time.Minute); !errors.Is(err, redis.Nil) {
+		t.Errorf("Extend of an expired flag = %v, want redis.Nil", err)
+	}
+
+	if err := flag.Enable(ctx, "backfill", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := flag.Disable(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if flag.IsReadOnly(ctx) {
+		t.Error("still read-only after Disable")
+	}
+}
+
+func TestReadOnlyFlagCachesChecks(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	flag := NewReadOnlyFlag(NewRedisClient(server.Addr()))
+
+	if flag.IsReadOnly(ctx) {
+		t.Fatal("read-only before the flag was set")
+	}
+	// Set behind the cached answer, as another replica would
+	if err := server.Set(readOnlyKey, "migration"); err != nil {
+		t.Fatal(err)
+	}
+	if flag.IsReadOnly(ctx) {
+		t.Error("cached check went to Redis")
+	}
+	flag.checkedAt = time.Now().Add(-flag.cacheTTL)
+	if !flag.IsReadOnly(ctx) {
+		t.Error("expired check didn't see the flag")
+	}
+
+	// With Redis unreachable the flag reads as off; writes have their own outage handling
+	server.Close()
+	flag.checkedAt = time.Time{}
+	if flag.IsReadOnly(ctx) {
+		t.Error("read-only while Redis is unreachable")
+	}
+}
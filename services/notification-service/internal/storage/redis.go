@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"notification-service/internal/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Key layout shared with the notification service
+const (
+	notificationKeyPrefix = "notification:"
+	templateKeyPrefix     = "template:"
+	preferencesKeyPrefix  = "preferences:"
+)
+
+// RedisBackend stores each record as a JSON string under a prefixed key
+type RedisBackend struct {
+	client *redis.Client
+}
+
+func NewRedisBackend(url string) *RedisBackend {
+	return &RedisBackend{client: NewRedisClient(url)}
+}
+
+// NewRedisClient accepts either a redis:// URL or a plain host:port address
+func NewRedisClient(url string) *redis.Client {
+	options, err := redis.ParseURL(url)
+	if err != nil {
+		options = &redis.Options{Addr: url}
+	}
+	return redis.NewClient(options)
+}
+
+func (r *RedisBackend) Name() string {
+	return "redis"
+}
+
+func (r *RedisBackend) Close() error {
+	return r.client.Close()
+}
+
+func (r *RedisBackend) ListNotifications(ctx context.Context, cursor string, limit int) ([]*models.Notification, string, error) {
+	var out []*models.Notification
+	next, err := r.scan(ctx, notificationKeyPrefix, cursor, limit, func(value []byte) error {
+		var notification models.Notification
+		if err := json.Unmarshal(value, &notification); err != nil {
+			return err
+		}
+		out = append(out, &notification)
+		return nil
+	})
+	return out, next, err
+}
+
+func (r *RedisBackend) PutNotification(ctx context.Context, notification *models.Notification) error {
+	return r.put(ctx, notificationKeyPrefix+notification.ID, notification)
+}
+
+func (r *RedisBackend) ListTemplates(ctx context.Context, cursor string, limit int) ([]*models.NotificationTemplate, string, error) {
+	var out []*models.NotificationTemplate
+	next, err := r.scan(ctx, templateKeyPrefix, cursor, limit, func(value []byte) error {
+		var template models.NotificationTemplate
+		if err := json.Unmarshal(value, &template); err != nil {
+			return err
+		}
+		out = append(out, &template)
+		return nil
+	})
+	return out, next, err
+}
+
+func (r *RedisBackend) PutTemplate(ctx context.Context, template *models.NotificationTemplate) error {
+	return r.put(ctx, templateKeyPrefix+template.ID, template)
+}
+
+func (r *RedisBackend) ListPreferences(ctx context.Context, cursor string, limit int) ([]*models.CustomerPreferences, string, error) {
+	var out []*models.CustomerPreferences
+	next, err := r.scan(ctx, preferencesKeyPrefix, cursor, limit, func(value []byte) error {
+		var preferences models.CustomerPreferences
+		if err := json.Unmarshal(value, &preferences); err != nil {
+			return err
+		}
+		out = append(out, &preferences)
+		return nil
+	})
+	return out, next, err
+}
+
+func (r *RedisBackend) PutPreferences(ctx context.Context, preferences *models.CustomerPreferences) error {
+	return r.put(ctx, preferencesKeyPrefix+preferences.CustomerID, preferences)
+}
+
+func (r *RedisBackend) put(ctx context.Context, key string, value interface{}) error {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return r.client.Set(ctx, key, payload, 0).Err()
+}
+
+// scan pages through string keys with a prefix using SCAN, decoding each value
+func (r *RedisBackend) scan(ctx context.Context, prefix, cursor string, limit int, decode func([]byte) error) (string, error) {
+	var position uint64
+	if cursor != "" {
+		if _, err := fmt.Sscan(cursor, &position); err != nil {
+			return "", fmt.Errorf("invalid cursor %q: %w", cursor, err)
+		}
+	}
+
+	keys, position, err := r.client.Scan(ctx, position, prefix+"*", int64(limit)).Result()
+	if err != nil {
+		return "", err
+	}
+
+	for _, key := range keys {
+		// Only plain string keys hold records; skip indexes sharing the prefix
+		if keyType, err := r.client.Type(ctx, key).Result(); err != nil || keyType != "string" {
+			continue
+		}
+		value, err := r.client.Get(ctx, key).Bytes()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if err := decode(value); err != nil {
+			return "", fmt.Errorf("failed to decode %s: %w", key, err)
+		}
+	}
+
+	if position == 0 {
+		return "", nil
+	}
+	return fmt.Sprint(position), nil
+}
@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"notification-service/internal/models"
+)
+
+// ErrRegionUnavailable means a notification's data region has no reachable store, so it
+// can't be kept anywhere without leaving its region
+var ErrRegionUnavailable = errors.New("data region store unavailable")
+
+var _ NotificationRepository = (*RegionalNotificationRepository)(nil)
+
+// RegionalNotificationRepository keeps each notification in the database of its Region,
+// and those of the home region (or without one) in the home database. Reads by ID look
+// in the home database first and then in each region; listings merge every database in
+// order, so the keyset cursor pages across all of them.
+type RegionalNotificationRepository struct {
+	home       NotificationRepository
+	homeRegion string
+	regions    map[string]NotificationRepository
+}
+
+// NewRegionalNotificationRepository routes notifications over home, which may be nil
+// when the home database is unavailable, and the regional databases. A region whose
+// database is nil is configured but unreachable; writes to it fail.
+func NewRegionalNotificationRepository(home NotificationRepository, homeRegion string, regions map[string]NotificationRepository) *RegionalNotificationRepository {
+	return &RegionalNotificationRepository{home: home, homeRegion: homeRegion, regions: regions}
+}
+
+// Region returns the repository of a data region, nil for the home region when there
+// is no home database
+func (r *RegionalNotificationRepository) Region(region string) (NotificationRepository, error) {
+	if region == "" || region == r.homeRegion {
+		return r.home, nil
+	}
+	repo, ok := r.regions[region]
+	if !ok || repo == nil {
+		return nil, fmt.Errorf("%w: %s", ErrRegionUnavailable, region)
+	}
+	return repo, nil
+}
+
+// all returns every reachable repository, home first
+func (r *RegionalNotificationRepository) all() []NotificationRepository {
+	repos := make([]NotificationRepository, 0, len(r.regions)+1)
+	if r.home != nil {
+		repos = append(repos, r.home)
+	}
+	regions := make([]string, 0, len(r.regions))
+	for region := range r.regions {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	for _, region := range regions {
+		if repo := r.regions[region]; repo != nil {
+			repos = append(repos, repo)
+		}
+	}
+	return repos
+}
+
+func (r *RegionalNotificationRepository) GetNotification(ctx context.Context, id string) (*models.Notification, error) {
+	for _, repo := range r.all() {
+		notification, err := repo.GetNotification(ctx, id)
+		if !errors.Is(err, ErrNotFound) {
+			return notification, err
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *RegionalNotificationRepository) ListNotifications(ctx context.Context, filter NotificationFilter) ([]*models.Notification, string, error) {
+	if filter.Cursor != "" {
+		var err error
+		if _, _, filter.Ascending, err = decodeNotificationCursor(filter.Cursor); err != nil {
+			return nil, "", err
+		}
+	}
+	var merged []*models.Notification
+	more := false
+	for _, repo := range r.all() {
+		notifications, next, err := repo.ListNotifications(ctx, filter)
+		if err != nil {
+			return nil, "", err
+		}
+		merged = append(merged, notifications...)
+		more = more || next != ""
+	}
+
+	sort.Slice(merged, func(i, j int) bool {
+		a, b := merged[i], merged[j]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt) == filter.Ascending
+		}
+		return (a.ID < b.ID) == filter.Ascending
+	})
+	if len(merged) > filter.Limit {
+		merged, more = merged[:filter.Limit], true
+	}
+	if !more || len(merged) == 0 {
+		return merged, "", nil
+	}
+	last := merged[len(merged)-1]
+	return merged, encodeNotificationCursor(last.CreatedAt, last.ID, filter.Ascending), nil
+}
+
+func (r *RegionalNotificationRepository) CountNotifications(ctx context.Context, filter NotificationFilter) (int64, error) {
+	var total int64
+	for _, repo := range r.all() {
+		count, err := repo.CountNotifications(ctx, filter)
+		if err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// UpsertNotification stores a notification in its region's database. Without a home
+// database, home notifications live in Redis only, as they would without this routing.
+func (r *RegionalNotificationRepository) UpsertNotification(ctx context.Context, notification *models.Notification) error {
+	repo, err := r.Region(notification.Region)
+	if err != nil || repo == nil {
+		return err
+	}
+	return repo.UpsertNotification(ctx, notification)
+}
+
+func (r *RegionalNotificationRepository) DeleteNotification(ctx context.Context, id string) error {
+	for _, repo := range r.all() {
+		if err := repo.DeleteNotification(ctx, id); !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return ErrNotFound
+}
+
+// DeliveryRollup returns the rollups of every database; callers sum them by key
+func (r *RegionalNotificationRepository) DeliveryRollup(ctx context.Context, since time.Time) ([]models.DeliveryRollup, error) {
+	var rollups []models.DeliveryRollup
+	for _, repo := range r.all() {
+		regional, err := repo.DeliveryRollup(ctx, since)
+		if err != nil {
+			return nil, err
+		}
+		rollups = append(rollups, regional...)
+	}
+	return rollups, nil
+}
+
+// TemplateFailures returns the template failures of every database, each database's
+// limit templates failing most; callers sum them by template
+func (r *RegionalNotificationRepository) TemplateFailures(ctx context.Context, since time.Time, limit int) ([]models.TemplateFailures, error) {
+	var failures []models.TemplateFailures
+	for _, repo := range r.all() {
+		regional, err := repo.TemplateFailures(ctx, since, limit)
+		if err != nil {
+			return nil, err
+		}
+		failures = append(failures, regional...)
+	}
+	return failures, nil
+}
+
+// QueueDepths returns the queue depths of every database; callers sum them by key
+func (r *RegionalNotificationRepository) QueueDepths(ctx context.Context) ([]models.QueueDepth, error) {
+	var depths []models.QueueDepth
+	for _, repo := range r.all() {
+		regional, err := repo.QueueDepths(ctx)
+		if err != nil {
+			return nil, err
+		}
+		depths = append(depths, regional...)
+	}
+	return depths, nil
+}
+
+// Ping checks the home database only, if there is one: a region being down affects
+// just the tenants pinned to it, so it shouldn't take the whole service out of rotation
+func (r *RegionalNotificationRepository) Ping(ctx context.Context) error {
+	if r.home == nil {
+		return nil
+	}
+	return r.home.Ping(ctx)
+}
+
+// Close closes the regional databases; the home one belongs to the caller
+func (r *RegionalNotificationRepository) Close() error {
+	var errs []error
+	for _, repo := range r.regions {
+		if repo != nil {
+			errs = append(errs, repo.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
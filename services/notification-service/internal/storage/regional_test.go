@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"notification-service/internal/models"
+)
+
+// keysetRepository lists its notifications by keyset cursor the way the Postgres
+// repository's query does; the methods it doesn't override aren't used
+type keysetRepository struct {
+	NotificationRepository
+	notifications []*models.Notification
+}
+
+func (r *keysetRepository) ListNotifications(_ context.Context, filter NotificationFilter) ([]*models.Notification, string, error) {
+	var createdAt time.Time
+	var id string
+	if filter.Cursor != "" {
+		var err error
+		if createdAt, id, filter.Ascending, err = decodeNotificationCursor(filter.Cursor); err != nil {
+			return nil, "", err
+		}
+	}
+	after := func(n *models.Notification) bool {
+		if !n.CreatedAt.Equal(createdAt) {
+			return n.CreatedAt.After(createdAt) == filter.Ascending
+		}
+		return n.ID != id && (n.ID > id) == filter.Ascending
+	}
+
+	var page []*models.Notification
+	for _, n := range r.notifications {
+		if filter.Cursor == "" || after(n) {
+			page = append(page, n)
+		}
+	}
+	sort.Slice(page, func(i, j int) bool {
+		if !page[i].CreatedAt.Equal(page[j].CreatedAt) {
+			return page[i].CreatedAt.Before(page[j].CreatedAt) == filter.Ascending
+		}
+		return (page[i].ID < page[j].ID) == filter.Ascending
+	})
+	if len(page) < filter.Limit {
+		return page, "", nil
+	}
+	page = page[:filter.Limit]
+	last := page[len(page)-1]
+	return page, encodeNotificationCursor(last.CreatedAt, last.ID, filter.Ascending), nil
+}
+
+func TestRegionalListingPagesAcrossRegions(t *testing.T) {
+	start := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	notification := func(minute int, id string) *models.Notification {
+		return &models.Notification{ID: id, CreatedAt: start.Add(time.Duration(minute) * time.Minute)}
+	}
+	// n-3 and n-4 share a created_at, so the ID breaks the tie across databases
+	home := &keysetRepository{notifications: []*models.Notification{notification(1, "n-1"), notification(3, "n-3"), notification(5, "n-6")}}
+	emea := &keysetRepository{notifications: []*models.Notification{notification(2, "n-2"), notification(3, "n-4"), notification(4, "n-5")}}
+	repo := NewRegionalNotificationRepository(home, "us", map[string]NotificationRepository{"emea": emea, "apac": nil})
+
+	tests := []struct {
+		ascending bool
+		want      string
+	}{
+		{ascending: false, want: "[n-6 n-5] [n-4 n-3] [n-2 n-1]"},
+		{ascending: true, want: "[n-1 n-2] [n-3 n-4] [n-5 n-6]"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("ascending=%t", tt.ascending), func(t *testing.T) {
+			filter := NotificationFilter{Limit: 2, Ascending: tt.ascending}
+			var pages []string
+			for {
+				notifications, next, err := repo.ListNotifications(context.Background(), filter)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ids := make([]string, len(notifications))
+				for i, n := range notifications {
+					ids[i] = n.ID
+				}
+				if len(ids) > 0 {
+					pages = append(pages, fmt.Sprint(ids))
+				}
+				if next == "" {
+					break
+				}
+				if len(pages) > 5 {
+					t.Fatalf("paging did not end: %v", pages)
+				}
+				// The cursor keeps the order, so later pages needn't ask for it again
+				filter = NotificationFilter{Limit: 2, Cursor: next}
+			}
+			if got := fmt.Sprint(pages); got != "["+tt.want+"]" {
+				t.Errorf("pages = %s, want [%s]", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+// SchemaVersion is the highest migration this binary ships. Bump it with every new
+// file in migrations/.
+const SchemaVersion uint = 4
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// ErrSchemaTooNew means the database was migrated by a newer release than this binary
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary supports")
+
+// MigrateSchema applies pending migrations. golang-migrate holds a Postgres advisory
+// lock while migrating, so replicas starting together apply each migration once. Rows
+// that predate a migration's columns are then backfilled in batches.
+func MigrateSchema(ctx context.Context, databaseURL string) (uint, error) {
+	source, err := iofs.New(migrationFiles, "migrations")
+	if err != nil {
+		return 0, fmt.Errorf("failed to load embedded migrations: %w", err)
+	}
+
+	m, err := migrate.NewWithSourceInstance("iofs", source, databaseURL)
+	if err != nil {
+		return 0, fmt.Errorf("failed to initialize migrations: %w", err)
+	}
+	defer m.Close()
+
+	version, dirty, err := m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if dirty {
+		return version, fmt.Errorf("schema version %d is dirty; fix it manually before restarting", version)
+	}
+	if version > SchemaVersion {
+		return version, fmt.Errorf("%w: database at %d, binary expects %d", ErrSchemaTooNew, version, SchemaVersion)
+	}
+
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return version, fmt.Errorf("failed to apply migrations: %w", err)
+	}
+
+	version, _, err = m.Version()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	log.Printf("✓ Database schema at version %d", version)
+	if err := backfillSchema(ctx, databaseURL); err != nil {
+		return version, err
+	}
+	return version, nil
+}
+
+// SchemaGate reports whether the database schema is compatible with this binary.
+// The version is re-read periodically so replicas of an older release go unready once
+// a newer release has migrated the database.
+type SchemaGate struct {
+	databaseURL string
+	interval    time.Duration
+
+	mutex     sync.Mutex
+	db        *sql.DB
+	lastErr   error
+	checkedAt time.Time
+}
+
+func NewSchemaGate(databaseURL string) *SchemaGate {
+	return &SchemaGate{databaseURL: databaseURL, interval: 10 * time.Second}
+}
+
+// Check returns an error if the schema is newer than SchemaVersion or left dirty.
+// An unreachable database is not a schema problem and is not reported here.
+func (g *SchemaGate) Check(ctx context.Context) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.databaseURL == "" || time.Since(g.checkedAt) < g.interval {
+		return g.lastErr
+	}
+	g.checkedAt = time.Now()
+
+	if g.db == nil {
+		db, err := sql.Open("postgres", g.databaseURL)
+		if err != nil {
+			g.lastErr = nil
+			return nil
+		}
+		g.db = db
+	}
+
+	var version uint
+	var dirty bool
+	err := g.db.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	switch {
+	case err != nil:
+		g.lastErr = nil
+	case dirty:
+		g.lastErr = fmt.Errorf("database schema version %d is dirty", version)
+	case version > SchemaVersion:
+		g.lastErr = fmt.Errorf("%w: database at %d, binary expects %d", ErrSchemaTooNew, version, SchemaVersion)
+	default:
+		g.lastErr = nil
+	}
+	return g.lastErr
+}
+
+// Close releases the gate's database connection
+func (g *SchemaGate) Close() error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.db != nil {
+		return g.db.Close()
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+
+	"notification-service/internal/config"
+	"notification-service/internal/models"
+)
+
+// Backend is a storage backend for notifications, templates and customer preferences.
+// List methods page through records with an opaque cursor; an empty next cursor means done.
+type Backend interface {
+	Name() string
+
+	ListNotifications(ctx context.Context, cursor string, limit int) ([]*models.Notification, string, error)
+	PutNotification(ctx context.Context, notification *models.Notification) error
+
+	ListTemplates(ctx context.Context, cursor string, limit int) ([]*models.NotificationTemplate, string, error)
+	PutTemplate(ctx context.Context, template *models.NotificationTemplate) error
+
+	ListPreferences(ctx context.Context, cursor string, limit int) ([]*models.CustomerPreferences, string, error)
+	PutPreferences(ctx context.Context, preferences *models.CustomerPreferences) error
+
+	Close() error
+}
+
+// Open creates a backend by name ("redis" or "postgres") from the service configuration
+func Open(ctx context.Context, name string, cfg *config.Config) (Backend, error) {
+	switch name {
+	case "redis":
+		return NewRedisBackend(cfg.RedisURL), nil
+	case "postgres":
+		return NewPostgresBackend(ctx, cfg.DatabaseURL)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q (expected redis or postgres)", name)
+	}
+}
@@ -0,0 +1,746 @@
+package telemetry
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"notification-service/internal/config"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	otellog "go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Values of TELEMETRY_EXPORTER
+const (
+	ExporterOTLP         = "otlp"
+	ExporterAzureMonitor = "azuremonitor"
+	ExporterBoth         = "both"
+)
+
+// azureMonitorMetricInterval is how often metrics are sent to Application Insights,
+// which aggregates them per minute
+const azureMonitorMetricInterval = 60 * time.Second
+
+// defaultIngestionEndpoint receives telemetry for connection strings without an
+// IngestionEndpoint
+const defaultIngestionEndpoint = "https://dc.services.visualstudio.com/"
+
+// defaultIngestionAudience is the Entra ID audience of the ingestion API for connection
+// strings without an AADAudience
+const defaultIngestionAudience = "https://monitor.azure.com/"
+
+// azureMonitorAttempts is how many times an item is sent before it is dropped; the SDK
+// leaves retrying to exporters
+const azureMonitorAttempts = 3
+
+// retryableIngestionStatus reports whether the ingestion API answering a batch, or one
+// item of it, with status means it may be accepted when sent again
+func retryableIngestionStatus(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusServiceUnavailable:
+		return true
+	}
+	return false
+}
+
+// azureMonitorClient sends telemetry to the Application Insights ingestion API of the
+// resource an APPLICATIONINSIGHTS_CONNECTION_STRING names. Spans, metrics and logs are
+// converted to Application Insights envelopes: server and consumer spans become
+// requests, other spans dependencies, span exceptions exceptions, and log records traces.
+// With Entra ID auth each batch carries a bearer token for the ingestion audience.
+type azureMonitorClient struct {
+	instrumentationKey string
+	trackURL           string
+	http               *http.Client
+	credential         azcore.TokenCredential
+	scope              string
+	// backoff is the wait before the first retry, doubled before each later one
+	backoff time.Duration
+}
+
+// selectExporters resolves TELEMETRY_EXPORTER into whether the OTLP exporters run and
+// the client telemetry is sent to Application Insights with, nil when it isn't. An
+// unknown mode falls back to OTLP; Azure Monitor without a usable connection string is
+// skipped with a warning.
+func selectExporters(cfg *config.Config) (bool, *azureMonitorClient) {
+	mode := strings.ToLower(strings.TrimSpace(cfg.TelemetryExporter))
+	switch mode {
+	case "", ExporterOTLP:
+		return true, nil
+	case ExporterAzureMonitor, ExporterBoth:
+	default:
+		slog.Warn("Ignoring TELEMETRY_EXPORTER, exporting over OTLP", "telemetry.exporter", cfg.TelemetryExporter)
+		return true, nil
+	}
+
+	useOTLP := mode == ExporterBoth
+	if cfg.ApplicationInsightsConnectionString == "" {
+		slog.Warn("TELEMETRY_EXPORTER needs APPLICATIONINSIGHTS_CONNECTION_STRING, not exporting to Azure Monitor", "telemetry.exporter", mode)
+		return useOTLP, nil
+	}
+	client, err := newAzureMonitorClient(cfg.ApplicationInsightsConnectionString)
+	if err != nil {
+		slog.Warn("Invalid APPLICATIONINSIGHTS_CONNECTION_STRING, not exporting to Azure Monitor", "error", err)
+		return useOTLP, nil
+	}
+	if err := client.authenticate(cfg.ApplicationInsightsAuthenticationString); err != nil {
+		slog.Warn("Invalid APPLICATIONINSIGHTS_AUTHENTICATION_STRING, not exporting to Azure Monitor", "error", err)
+		return useOTLP, nil
+	}
+	return useOTLP, client
+}
+
+// newAzureMonitorClient parses a connection string such as
+// "InstrumentationKey=...;IngestionEndpoint=https://...;..."
+func newAzureMonitorClient(connectionString string) (*azureMonitorClient, error) {
+	settings := make(map[string]string)
+	for _, part := range strings.Split(connectionString, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if ok {
+			settings[strings.ToLower(name)] = value
+		}
+	}
+	key := settings["instrumentationkey"]
+	if key == "" {
+		return nil, errors.New("connection string has no InstrumentationKey")
+	}
+	endpoint := settings["ingestionendpoint"]
+	if endpoint == "" {
+		endpoint = defaultIngestionEndpoint
+	}
+	audience := settings["aadaudience"]
+	if audience == "" {
+		audience = defaultIngestionAudience
+	}
+	return &azureMonitorClient{
+		instrumentationKey: key,
+		trackURL:           strings.TrimSuffix(endpoint, "/") + "/v2.1/track",
+		http:               &http.Client{Timeout: 30 * time.Second},
+		scope:              audience + "/.default",
+		backoff:            time.Second,
+	}, nil
+}
+
+// authenticate sets up Entra ID auth from an authentication string such as
+// "Authorization=AAD;ClientId=...", for resources with local auth disabled. A ClientId
+// picks a user-assigned managed identity; without one the default Azure credential
+// chain is used. An empty string leaves the client on the instrumentation key alone.
+func (c *azureMonitorClient) authenticate(authentication string) error {
+	if strings.TrimSpace(authentication) == "" {
+		return nil
+	}
+	settings := make(map[string]string)
+	for _, part := range strings.Split(authentication, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if ok {
+			settings[strings.ToLower(name)] = value
+		}
+	}
+	if !strings.EqualFold(settings["authorization"], "AAD") {
+		return errors.New("authentication string must set Authorization=AAD")
+	}
+
+	var err error
+	if clientID := settings["clientid"]; clientID != "" {
+		c.credential, err = azidentity.NewManagedIdentityCredential(&azidentity.ManagedIdentityCredentialOptions{
+			ID: azidentity.ClientID(clientID),
+		})
+	} else {
+		c.credential, err = azidentity.NewDefaultAzureCredential(nil)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create Entra ID credential: %w", err)
+	}
+	return nil
+}
+
+// envelope is one Application Insights telemetry item
+type envelope struct {
+	Name string            `json:"name"`
+	Time string            `json:"time"`
+	IKey string            `json:"iKey"`
+	Tags map[string]string `json:"tags,omitempty"`
+	Data envelopeData      `json:"data"`
+}
+
+type envelopeData struct {
+	BaseType string      `json:"baseType"`
+	BaseData interface{} `json:"baseData"`
+}
+
+type requestData struct {
+	Ver          int               `json:"ver"`
+	ID           string            `json:"id"`
+	Name         string            `json:"name"`
+	Duration     string            `json:"duration"`
+	ResponseCode string            `json:"responseCode"`
+	Success      bool              `json:"success"`
+	URL          string            `json:"url,omitempty"`
+	Properties   map[string]string `json:"properties,omitempty"`
+}
+
+type dependencyData struct {
+	Ver        int               `json:"ver"`
+	ID         string            `json:"id"`
+	Name       string            `json:"name"`
+	Duration   string            `json:"duration"`
+	ResultCode string            `json:"resultCode,omitempty"`
+	Success    bool              `json:"success"`
+	Type       string            `json:"type,omitempty"`
+	Target     string            `json:"target,omitempty"`
+	Data       string            `json:"data,omitempty"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+type exceptionData struct {
+	Ver        int                `json:"ver"`
+	Exceptions []exceptionDetails `json:"exceptions"`
+	Properties map[string]string  `json:"properties,omitempty"`
+}
+
+type exceptionDetails struct {
+	TypeName     string `json:"typeName"`
+	Message      string `json:"message"`
+	HasFullStack bool   `json:"hasFullStack"`
+	Stack        string `json:"stack,omitempty"`
+}
+
+type messageData struct {
+	Ver           int               `json:"ver"`
+	Message       string            `json:"message"`
+	SeverityLevel int               `json:"severityLevel"`
+	Properties    map[string]string `json:"properties,omitempty"`
+}
+
+type metricData struct {
+	Ver        int               `json:"ver"`
+	Metrics    []metricPoint     `json:"metrics"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+type metricPoint struct {
+	Name  string   `json:"name"`
+	Value float64  `json:"value"`
+	Count *int64   `json:"count,omitempty"`
+	Min   *float64 `json:"min,omitempty"`
+	Max   *float64 `json:"max,omitempty"`
+}
+
+// trackResponse is the ingestion API's answer; errors list the items it rejected
+type trackResponse struct {
+	ItemsReceived int `json:"itemsReceived"`
+	ItemsAccepted int `json:"itemsAccepted"`
+	Errors        []struct {
+		Index      int    `json:"index"`
+		StatusCode int    `json:"statusCode"`
+		Message    string `json:"message"`
+	} `json:"errors"`
+}
+
+// newEnvelope stamps an item with the instrumentation key and the cloud role of the
+// service that produced it
+func (c *azureMonitorClient) newEnvelope(name string, at time.Time, res *resource.Resource, baseType string, baseData interface{}) envelope {
+	tags := make(map[string]string)
+	if res != nil {
+		if value, ok := res.Set().Value(semconv.ServiceNameKey); ok {
+			tags["ai.cloud.role"] = value.AsString()
+		}
+		if value, ok := res.Set().Value(semconv.ServiceInstanceIDKey); ok {
+			tags["ai.cloud.roleInstance"] = value.AsString()
+		}
+	}
+	return envelope{
+		Name: "Microsoft.ApplicationInsights." + name,
+		Time: at.UTC().Format(time.RFC3339Nano),
+		IKey: c.instrumentationKey,
+		Tags: tags,
+		Data: envelopeData{BaseType: baseType, BaseData: baseData},
+	}
+}
+
+// send posts envelopes, retrying throttling, server errors and network failures. When
+// the ingestion API accepts only part of a batch, the items it answered with a
+// retryable status are sent again on their own. Items rejected for good, or still
+// failing after azureMonitorAttempts, are reported in the error.
+func (c *azureMonitorClient) send(ctx context.Context, envelopes []envelope) error {
+	var rejected []error
+	pending := envelopes
+	backoff := c.backoff
+	for attempt := 1; len(pending) > 0; attempt++ {
+		retry, err := c.post(ctx, pending)
+		// An error settling some of the items is about those rejected for good
+		if err != nil && len(retry) < len(pending) {
+			rejected = append(rejected, err)
+			err = nil
+		}
+		if len(retry) == 0 {
+			break
+		}
+		if attempt == azureMonitorAttempts {
+			if err == nil {
+				err = errors.New("azure monitor: ingestion kept answering with retryable errors")
+			}
+			return errors.Join(append(rejected, fmt.Errorf("%w; dropped %d items after %d attempts", err, len(retry), attempt))...)
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Join(append(rejected, err, ctx.Err())...)
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		pending = retry
+	}
+	return errors.Join(rejected...)
+}
+
+// post sends one batch. It returns the items worth sending again: the whole batch after
+// a network failure or a retryable answer, or the items a partial success answered with
+// a retryable status.
+func (c *azureMonitorClient) post(ctx context.Context, envelopes []envelope) ([]envelope, error) {
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	if err := json.NewEncoder(gz).Encode(envelopes); err != nil {
+		return nil, fmt.Errorf("azure monitor: failed to encode telemetry: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("azure monitor: failed to compress telemetry: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.trackURL, &body)
+	if err != nil {
+		return nil, fmt.Errorf("azure monitor: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	if c.credential != nil {
+		token, err := c.credential.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{c.scope}})
+		if err != nil {
+			return envelopes, fmt.Errorf("azure monitor: failed to get Entra ID token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token.Token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return envelopes, fmt.Errorf("azure monitor: %w", err)
+	}
+	defer resp.Body.Close()
+	payload, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return nil, nil
+	case resp.StatusCode == http.StatusPartialContent:
+		var result trackResponse
+		if err := json.Unmarshal(payload, &result); err != nil {
+			return nil, fmt.Errorf("azure monitor: ingestion accepted only part of %d items", len(envelopes))
+		}
+		var retry []envelope
+		var rejected []string
+		for _, itemErr := range result.Errors {
+			if itemErr.Index < 0 || itemErr.Index >= len(envelopes) {
+				continue
+			}
+			if retryableIngestionStatus(itemErr.StatusCode) {
+				retry = append(retry, envelopes[itemErr.Index])
+			} else {
+				rejected = append(rejected, fmt.Sprintf("%d %s", itemErr.StatusCode, itemErr.Message))
+			}
+		}
+		if len(rejected) == 0 {
+			return retry, nil
+		}
+		return retry, fmt.Errorf("azure monitor: %d of %d items rejected: %s", len(rejected), len(envelopes), rejected[0])
+	case retryableIngestionStatus(resp.StatusCode):
+		return envelopes, fmt.Errorf("azure monitor: ingestion answered %d", resp.StatusCode)
+	default:
+		return nil, fmt.Errorf("azure monitor: ingestion answered %d: %s", resp.StatusCode, strings.TrimSpace(string(payload)))
+	}
+}
+
+// requestURL is the full URL a server span answered, from url.full or else put together
+// from its scheme, host, port, path and query, under the current or the older HTTP
+// semantic conventions
+func requestURL(attrs attribute.Set) string {
+	if value, ok := attrs.Value(semconv.URLFullKey); ok {
+		return value.AsString()
+	}
+	path := ""
+	if value, ok := attrs.Value(semconv.URLPathKey); ok {
+		path = value.AsString()
+		if query, ok := attrs.Value(semconv.URLQueryKey); ok && query.AsString() != "" {
+			path += "?" + query.AsString()
+		}
+	} else if value, ok := attrs.Value("http.target"); ok {
+		path = value.AsString()
+	}
+	if path == "" {
+		return ""
+	}
+
+	scheme := "http"
+	if value, ok := attrs.Value(semconv.URLSchemeKey); ok {
+		scheme = value.AsString()
+	} else if value, ok := attrs.Value("http.scheme"); ok {
+		scheme = value.AsString()
+	}
+	host := ""
+	if value, ok := attrs.Value(semconv.ServerAddressKey); ok {
+		host = value.AsString()
+	} else if value, ok := attrs.Value("net.host.name"); ok {
+		host = value.AsString()
+	}
+	if host == "" {
+		return path
+	}
+	port := int64(0)
+	if value, ok := attrs.Value(semconv.ServerPortKey); ok {
+		port = value.AsInt64()
+	} else if value, ok := attrs.Value("net.host.port"); ok {
+		port = value.AsInt64()
+	}
+	if port > 0 && !(scheme == "http" && port == 80) && !(scheme == "https" && port == 443) {
+		host += ":" + strconv.FormatInt(port, 10)
+	}
+	return scheme + "://" + host + path
+}
+
+// statusCode is a span's HTTP response status code, under the current or the older
+// HTTP semantic conventions
+func statusCode(attrs attribute.Set) (string, bool) {
+	value, ok := attrs.Value(semconv.HTTPResponseStatusCodeKey)
+	if !ok {
+		value, ok = attrs.Value("http.status_code")
+	}
+	if !ok {
+		return "", false
+	}
+	return strconv.FormatInt(value.AsInt64(), 10), true
+}
+
+// aiDuration formats a duration the way Application Insights expects, d.hh:mm:ss.ffffff
+func aiDuration(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	hours := d / time.Hour
+	d -= hours * time.Hour
+	minutes := d / time.Minute
+	d -= minutes * time.Minute
+	seconds := d / time.Second
+	d -= seconds * time.Second
+	return fmt.Sprintf("%d.%02d:%02d:%02d.%06d", days, hours, minutes, seconds, d/time.Microsecond)
+}
+
+// regionProperty adds the region of the resource, which Application Insights has no tag
+// for, to an item's properties, so the regions of an active-active deployment can be
+// told apart
+func regionProperty(properties map[string]string, res *resource.Resource) map[string]string {
+	if res == nil {
+		return properties
+	}
+	value, ok := res.Set().Value(semconv.CloudRegionKey)
+	if !ok {
+		return properties
+	}
+	if properties == nil {
+		properties = make(map[string]string, 1)
+	}
+	properties[string(semconv.CloudRegionKey)] = value.AsString()
+	return properties
+}
+
+func attributeProperties(attrs []attribute.KeyValue) map[string]string {
+	if len(attrs) == 0 {
+		return nil
+	}
+	properties := make(map[string]string, len(attrs))
+	for _, attr := range attrs {
+		properties[string(attr.Key)] = attr.Value.Emit()
+	}
+	return properties
+}
+
+// operationTags correlate an item with its trace in Application Insights
+func operationTags(tags map[string]string, traceID trace.TraceID, parentID trace.SpanID) {
+	if traceID.IsValid() {
+		tags["ai.operation.id"] = traceID.String()
+	}
+	if parentID.IsValid() {
+		tags["ai.operation.parentId"] = parentID.String()
+	}
+}
+
+// azureMonitorSpanExporter exports spans as requests, dependencies and exceptions
+type azureMonitorSpanExporter struct {
+	client *azureMonitorClient
+}
+
+func (e *azureMonitorSpanExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	envelopes := make([]envelope, 0, len(spans))
+	for _, span := range spans {
+		envelopes = append(envelopes, e.spanEnvelopes(span)...)
+	}
+	return e.client.send(ctx, envelopes)
+}
+
+func (e *azureMonitorSpanExporter) spanEnvelopes(span sdktrace.ReadOnlySpan) []envelope {
+	spanContext := span.SpanContext()
+	attrs := attribute.NewSet(span.Attributes()...)
+	properties := regionProperty(attributeProperties(span.Attributes()), span.Resource())
+	success := span.Status().Code != codes.Error
+	duration := aiDuration(span.EndTime().Sub(span.StartTime()))
+
+	var item envelope
+	switch span.SpanKind() {
+	case trace.SpanKindServer, trace.SpanKindConsumer:
+		code, ok := statusCode(attrs)
+		if !ok {
+			code = "0"
+		}
+		item = e.client.newEnvelope("Request", span.StartTime(), span.Resource(), "RequestData", requestData{
+			Ver:          2,
+			ID:           spanContext.SpanID().String(),
+			Name:         span.Name(),
+			Duration:     duration,
+			ResponseCode: code,
+			Success:      success,
+			URL:          requestURL(attrs),
+			Properties:   properties,
+		})
+		item.Tags["ai.operation.name"] = span.Name()
+	default:
+		dependencyType := "InProc"
+		switch span.SpanKind() {
+		case trace.SpanKindClient:
+			dependencyType = "HTTP"
+			if value, ok := attrs.Value(semconv.DBSystemKey); ok {
+				dependencyType = value.AsString()
+			} else if value, ok := attrs.Value(semconv.MessagingSystemKey); ok {
+				dependencyType = value.AsString()
+			}
+		case trace.SpanKindProducer:
+			dependencyType = "Queue Message"
+		}
+		target := ""
+		if value, ok := attrs.Value(semconv.ServerAddressKey); ok {
+			target = value.AsString()
+		}
+		code, _ := statusCode(attrs)
+		item = e.client.newEnvelope("RemoteDependency", span.StartTime(), span.Resource(), "RemoteDependencyData", dependencyData{
+			Ver:        2,
+			ID:         spanContext.SpanID().String(),
+			Name:       span.Name(),
+			Duration:   duration,
+			ResultCode: code,
+			Success:    success,
+			Type:       dependencyType,
+			Target:     target,
+			Properties: properties,
+		})
+	}
+	operationTags(item.Tags, spanContext.TraceID(), span.Parent().SpanID())
+	envelopes := []envelope{item}
+
+	// Errors recorded on the span are shown as exceptions under it
+	for _, event := range span.Events() {
+		if event.Name != semconv.ExceptionEventName {
+			continue
+		}
+		eventAttrs := attribute.NewSet(event.Attributes...)
+		details := exceptionDetails{TypeName: "error"}
+		if value, ok := eventAttrs.Value(semconv.ExceptionTypeKey); ok {
+			details.TypeName = value.AsString()
+		}
+		if value, ok := eventAttrs.Value(semconv.ExceptionMessageKey); ok {
+			details.Message = value.AsString()
+		}
+		if value, ok := eventAttrs.Value(semconv.ExceptionStacktraceKey); ok {
+			details.Stack = value.AsString()
+			details.HasFullStack = true
+		}
+		exception := e.client.newEnvelope("Exception", event.Time, span.Resource(), "ExceptionData", exceptionData{
+			Ver:        2,
+			Exceptions: []exceptionDetails{details},
+			Properties: regionProperty(nil, span.Resource()),
+		})
+		operationTags(exception.Tags, spanContext.TraceID(), spanContext.SpanID())
+		envelopes = append(envelopes, exception)
+	}
+	return envelopes
+}
+
+func (e *azureMonitorSpanExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+// azureMonitorMetricExporter exports each data point as a metric; counters and
+// histograms are sent as deltas, so each item covers one export interval
+type azureMonitorMetricExporter struct {
+	client *azureMonitorClient
+}
+
+func (e *azureMonitorMetricExporter) Temporality(kind sdkmetric.InstrumentKind) metricdata.Temporality {
+	switch kind {
+	case sdkmetric.InstrumentKindUpDownCounter, sdkmetric.InstrumentKindObservableUpDownCounter, sdkmetric.InstrumentKindObservableGauge:
+		return metricdata.CumulativeTemporality
+	default:
+		return metricdata.DeltaTemporality
+	}
+}
+
+func (e *azureMonitorMetricExporter) Aggregation(kind sdkmetric.InstrumentKind) sdkmetric.Aggregation {
+	return sdkmetric.DefaultAggregationSelector(kind)
+}
+
+func (e *azureMonitorMetricExporter) Export(ctx context.Context, metrics *metricdata.ResourceMetrics) error {
+	var envelopes []envelope
+	add := func(name string, at time.Time, attrs attribute.Set, point metricPoint) {
+		point.Name = name
+		envelopes = append(envelopes, e.client.newEnvelope("Metric", at, metrics.Resource, "MetricData", metricData{
+			Ver:        2,
+			Metrics:    []metricPoint{point},
+			Properties: regionProperty(attributeProperties(attrs.ToSlice()), metrics.Resource),
+		}))
+	}
+
+	for _, scope := range metrics.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				for _, dp := range data.DataPoints {
+					add(m.Name, dp.Time, dp.Attributes, metricPoint{Value: float64(dp.Value)})
+				}
+			case metricdata.Sum[float64]:
+				for _, dp := range data.DataPoints {
+					add(m.Name, dp.Time, dp.Attributes, metricPoint{Value: dp.Value})
+				}
+			case metricdata.Gauge[int64]:
+				for _, dp := range data.DataPoints {
+					add(m.Name, dp.Time, dp.Attributes, metricPoint{Value: float64(dp.Value)})
+				}
+			case metricdata.Gauge[float64]:
+				for _, dp := range data.DataPoints {
+					add(m.Name, dp.Time, dp.Attributes, metricPoint{Value: dp.Value})
+				}
+			case metricdata.Histogram[int64]:
+				for _, dp := range data.DataPoints {
+					add(m.Name, dp.Time, dp.Attributes, histogramPoint(float64(dp.Sum), dp.Count, dp.Min, dp.Max))
+				}
+			case metricdata.Histogram[float64]:
+				for _, dp := range data.DataPoints {
+					add(m.Name, dp.Time, dp.Attributes, histogramPoint(dp.Sum, dp.Count, dp.Min, dp.Max))
+				}
+			}
+		}
+	}
+	return e.client.send(ctx, envelopes)
+}
+
+// histogramPoint sends a histogram as an aggregate: the sum with count, min and max
+func histogramPoint[N int64 | float64](sum float64, count uint64, minimum, maximum metricdata.Extrema[N]) metricPoint {
+	n := int64(count)
+	point := metricPoint{Value: sum, Count: &n}
+	if value, ok := minimum.Value(); ok {
+		v := float64(value)
+		point.Min = &v
+	}
+	if value, ok := maximum.Value(); ok {
+		v := float64(value)
+		point.Max = &v
+	}
+	return point
+}
+
+func (e *azureMonitorMetricExporter) ForceFlush(context.Context) error {
+	return nil
+}
+
+func (e *azureMonitorMetricExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+// azureMonitorLogExporter exports log records as Application Insights traces
+type azureMonitorLogExporter struct {
+	client *azureMonitorClient
+}
+
+func (e *azureMonitorLogExporter) Export(ctx context.Context, records []sdklog.Record) error {
+	envelopes := make([]envelope, 0, len(records))
+	for _, record := range records {
+		properties := make(map[string]string)
+		record.WalkAttributes(func(kv otellog.KeyValue) bool {
+			properties[kv.Key] = logValueString(kv.Value)
+			return true
+		})
+		at := record.Timestamp()
+		if at.IsZero() {
+			at = record.ObservedTimestamp()
+		}
+		resource := record.Resource()
+		item := e.client.newEnvelope("Message", at, &resource, "MessageData", messageData{
+			Ver:           2,
+			Message:       logValueString(record.Body()),
+			SeverityLevel: severityLevel(record.Severity()),
+			Properties:    regionProperty(properties, &resource),
+		})
+		operationTags(item.Tags, record.TraceID(), record.SpanID())
+		envelopes = append(envelopes, item)
+	}
+	return e.client.send(ctx, envelopes)
+}
+
+func (e *azureMonitorLogExporter) ForceFlush(context.Context) error {
+	return nil
+}
+
+func (e *azureMonitorLogExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+func logValueString(value otellog.Value) string {
+	if value.Kind() == otellog.KindString {
+		return value.AsString()
+	}
+	return value.String()
+}
+
+// severityLevel maps an OpenTelemetry severity to Application Insights' Verbose (0)
+// through Critical (4)
+func severityLevel(severity otellog.Severity) int {
+	switch {
+	case severity >= otellog.SeverityFatal:
+		return 4
+	case severity >= otellog.SeverityError:
+		return 3
+	case severity >= otellog.SeverityWarn:
+		return 2
+	case severity >= otellog.SeverityInfo:
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,252 @@
+package telemetry
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestNewAzureMonitorClient(t *testing.T) {
+	client, err := newAzureMonitorClient("InstrumentationKey=ikey;IngestionEndpoint=https://westeurope-5.in.applicationinsights.azure.com/;AADAudience=https://monitor.azure.us/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.instrumentationKey != "ikey" {
+		t.Errorf("instrumentation key = %q, want ikey", client.instrumentationKey)
+	}
+	if want := "https://westeurope-5.in.applicationinsights.azure.com/v2.1/track"; client.trackURL != want {
+		t.Errorf("track URL = %q, want %q", client.trackURL, want)
+	}
+	if want := "https://monitor.azure.us//.default"; client.scope != want {
+		t.Errorf("scope = %q, want %q", client.scope, want)
+	}
+
+	if _, err := newAzureMonitorClient("IngestionEndpoint=https://example.com/"); err == nil {
+		t.Error("connection string without InstrumentationKey was accepted")
+	}
+	if err := client.authenticate("Authorization=Basic"); err == nil {
+		t.Error("authentication string without Authorization=AAD was accepted")
+	}
+}
+
+func TestSpanEnvelopes(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(recorder),
+		sdktrace.WithResource(resource.NewSchemaless(
+			semconv.ServiceName("notification-service"),
+			semconv.CloudRegion("westeurope"),
+		)),
+	)
+	_, span := provider.Tracer("test").Start(context.Background(), "POST /api/v1/notifications",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.scheme", "https"),
+			attribute.String("net.host.name", "api.example.com"),
+			attribute.Int("net.host.port", 8443),
+			attribute.String("http.target", "/api/v1/notifications?customer_id=c1"),
+			attribute.Int("http.status_code", 201),
+		),
+	)
+	span.RecordError(errors.New("redis unavailable"))
+	span.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("recorded %d spans, want 1", len(spans))
+	}
+	exporter := &azureMonitorSpanExporter{client: &azureMonitorClient{instrumentationKey: "ikey"}}
+	envelopes := exporter.spanEnvelopes(spans[0])
+	if len(envelopes) != 2 {
+		t.Fatalf("got %d envelopes, want a request and an exception", len(envelopes))
+	}
+
+	request := envelopes[0]
+	if request.Name != "Microsoft.ApplicationInsights.Request" || request.Data.BaseType != "RequestData" {
+		t.Errorf("first envelope is %s/%s, want a request", request.Name, request.Data.BaseType)
+	}
+	if request.IKey != "ikey" {
+		t.Errorf("iKey = %q, want ikey", request.IKey)
+	}
+	if request.Tags["ai.cloud.role"] != "notification-service" {
+		t.Errorf("ai.cloud.role = %q, want notification-service", request.Tags["ai.cloud.role"])
+	}
+	spanContext := spans[0].SpanContext()
+	if request.Tags["ai.operation.id"] != spanContext.TraceID().String() {
+		t.Errorf("ai.operation.id = %q, want the trace ID", request.Tags["ai.operation.id"])
+	}
+	data := request.Data.BaseData.(requestData)
+	if want := "https://api.example.com:8443/api/v1/notifications?customer_id=c1"; data.URL != want {
+		t.Errorf("url = %q, want %q", data.URL, want)
+	}
+	if data.ResponseCode != "201" {
+		t.Errorf("responseCode = %q, want 201", data.ResponseCode)
+	}
+	if data.ID != spanContext.SpanID().String() {
+		t.Errorf("id = %q, want the span ID", data.ID)
+	}
+	if data.Properties["cloud.region"] != "westeurope" {
+		t.Errorf("cloud.region property = %q, want westeurope", data.Properties["cloud.region"])
+	}
+
+	exception := envelopes[1]
+	if exception.Data.BaseType != "ExceptionData" {
+		t.Errorf("second envelope is %s, want an exception", exception.Data.BaseType)
+	}
+	if exception.Tags["ai.operation.parentId"] != spanContext.SpanID().String() {
+		t.Errorf("exception parent = %q, want the span", exception.Tags["ai.operation.parentId"])
+	}
+	if message := exception.Data.BaseData.(exceptionData).Exceptions[0].Message; message != "redis unavailable" {
+		t.Errorf("exception message = %q, want redis unavailable", message)
+	}
+}
+
+func TestRequestURL(t *testing.T) {
+	tests := []struct {
+		name  string
+		attrs []attribute.KeyValue
+		want  string
+	}{
+		{"full", []attribute.KeyValue{semconv.URLFull("https://api.example.com/health")}, "https://api.example.com/health"},
+		{"current", []attribute.KeyValue{semconv.URLScheme("https"), semconv.ServerAddress("api.example.com"), semconv.ServerPort(443), semconv.URLPath("/ready"), semconv.URLQuery("verbose=1")}, "https://api.example.com/ready?verbose=1"},
+		{"path only", []attribute.KeyValue{semconv.URLPath("/ready")}, "/ready"},
+		{"none", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := requestURL(attribute.NewSet(tt.attrs...)); got != tt.want {
+				t.Errorf("requestURL = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// ingestion fakes the track endpoint, answering each batch with the next response
+type ingestion struct {
+	mu        sync.Mutex
+	batches   [][]envelope
+	responses []func(w http.ResponseWriter)
+}
+
+func (i *ingestion) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	gz, err := gzip.NewReader(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var batch []envelope
+	if err := json.NewDecoder(gz).Decode(&batch); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.batches = append(i.batches, batch)
+	if len(i.responses) == 0 {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	respond := i.responses[0]
+	i.responses = i.responses[1:]
+	respond(w)
+}
+
+func newIngestionClient(t *testing.T, ingest *ingestion) *azureMonitorClient {
+	server := httptest.NewServer(ingest)
+	t.Cleanup(server.Close)
+	client, err := newAzureMonitorClient("InstrumentationKey=ikey;IngestionEndpoint=" + server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.backoff = time.Millisecond
+	return client
+}
+
+func messages(client *azureMonitorClient, texts ...string) []envelope {
+	envelopes := make([]envelope, len(texts))
+	for i, text := range texts {
+		envelopes[i] = client.newEnvelope("Message", time.Now(), nil, "MessageData", messageData{Ver: 2, Message: text})
+	}
+	return envelopes
+}
+
+func messageText(item envelope) string {
+	data, _ := item.Data.BaseData.(map[string]interface{})
+	text, _ := data["message"].(string)
+	return text
+}
+
+func TestSendRetriesRetryableItems(t *testing.T) {
+	ingest := &ingestion{responses: []func(w http.ResponseWriter){
+		func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte(`{"itemsReceived": 4, "itemsAccepted": 1, "errors": [
+				{"index": 1, "statusCode": 500, "message": "Internal Server Error"},
+				{"index": 2, "statusCode": 400, "message": "Invalid telemetry"},
+				{"index": 3, "statusCode": 429, "message": "Too Many Requests"}]}`))
+		},
+		func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusOK)
+		},
+	}}
+	client := newIngestionClient(t, ingest)
+
+	err := client.send(context.Background(), messages(client, "accepted", "server error", "invalid", "throttled"))
+	if err == nil || !strings.Contains(err.Error(), "1 of 4 items rejected") {
+		t.Fatalf("send error = %v, want the invalid item reported", err)
+	}
+	if len(ingest.batches) != 2 {
+		t.Fatalf("sent %d batches, want 2", len(ingest.batches))
+	}
+	retried := ingest.batches[1]
+	if len(retried) != 2 || messageText(retried[0]) != "server error" || messageText(retried[1]) != "throttled" {
+		t.Errorf("retried %d items, want the server error and throttled ones", len(retried))
+	}
+}
+
+func TestSendDropsItemsAfterAttempts(t *testing.T) {
+	unavailable := func(w http.ResponseWriter) { w.WriteHeader(http.StatusServiceUnavailable) }
+	ingest := &ingestion{responses: []func(w http.ResponseWriter){unavailable, unavailable, unavailable}}
+	client := newIngestionClient(t, ingest)
+
+	err := client.send(context.Background(), messages(client, "one", "two"))
+	if err == nil || !strings.Contains(err.Error(), "dropped 2 items after 3 attempts") {
+		t.Fatalf("send error = %v, want the items dropped", err)
+	}
+	if len(ingest.batches) != azureMonitorAttempts {
+		t.Errorf("sent %d batches, want %d", len(ingest.batches), azureMonitorAttempts)
+	}
+}
+
+func TestSendDoesNotRetryRejectedBatch(t *testing.T) {
+	ingest := &ingestion{responses: []func(w http.ResponseWriter){
+		func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid instrumentation key"))
+		},
+	}}
+	client := newIngestionClient(t, ingest)
+
+	err := client.send(context.Background(), messages(client, "one"))
+	if err == nil || !strings.Contains(err.Error(), "400") {
+		t.Fatalf("send error = %v, want the 400 reported", err)
+	}
+	if len(ingest.batches) != 1 {
+		t.Errorf("sent %d batches, want 1", len(ingest.batches))
+	}
+}
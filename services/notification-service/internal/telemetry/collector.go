@@ -0,0 +1,209 @@
+package telemetry
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"notification-service/internal/config"
+)
+
+// OTLP HTTP paths used when a signal has no endpoint of its own
+const (
+	otlpTracesPath  = "/v1/traces"
+	otlpMetricsPath = "/v1/metrics"
+	otlpLogsPath    = "/v1/logs"
+)
+
+// collectorDialTimeout bounds each connection attempt to a sidecar collector
+const collectorDialTimeout = 5 * time.Second
+
+var (
+	relaysMutex sync.Mutex
+	relays      = map[string]*collectorRelay{}
+)
+
+// collectorEndpoint returns the URL a signal's exporter sends to, or "" when the signal
+// isn't exported. A signal without its own endpoint uses OTEL_EXPORTER_OTLP_ENDPOINT with
+// the signal's path. Collectors on OTEL_EXPORTER_OTLP_SOCKET or on a plain-HTTP loopback
+// address are sidecars: the exporter is pointed at a relay instead, so the app starts
+// whether or not the sidecar is up and the connection to it is tracked.
+func collectorEndpoint(cfg *config.Config, endpoint, signalPath string) (string, error) {
+	if endpoint == "" && cfg.OTLPEndpoint != "" {
+		endpoint = strings.TrimSuffix(cfg.OTLPEndpoint, "/") + signalPath
+	}
+	if endpoint == "" && cfg.OTLPSocket != "" {
+		endpoint = "http://localhost" + signalPath
+	}
+	if endpoint == "" {
+		return "", nil
+	}
+
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+	path := parsed.Path
+	if path == "" || path == "/" {
+		path = signalPath
+	}
+
+	var relay *collectorRelay
+	switch {
+	case cfg.OTLPSocket != "":
+		relay, err = startCollectorRelay("unix", cfg.OTLPSocket)
+	case parsed.Scheme == "http" && isLoopback(parsed.Hostname()):
+		port := parsed.Port()
+		if port == "" {
+			port = "80"
+		}
+		relay, err = startCollectorRelay("tcp", net.JoinHostPort(parsed.Hostname(), port))
+	default:
+		return endpoint, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return "http://" + relay.listener.Addr().String() + path, nil
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// collectorRelay pipes exporter connections from a loopback port to a sidecar collector,
+// on a Unix socket or a local TCP port. The OTLP HTTP exporters only dial TCP; the relay
+// also sees every connection attempt, so it reports when the collector goes away and
+// comes back. While the collector is down, exports fail and are retried by the exporters.
+type collectorRelay struct {
+	network  string
+	address  string
+	listener net.Listener
+
+	mutex sync.Mutex
+	// connected is nil until the first connection attempt
+	connected *bool
+}
+
+// startCollectorRelay returns the relay for a collector address, starting it on first use
+func startCollectorRelay(network, address string) (*collectorRelay, error) {
+	relaysMutex.Lock()
+	defer relaysMutex.Unlock()
+
+	key := network + ":" + address
+	if relay, ok := relays[key]; ok {
+		return relay, nil
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	relay := &collectorRelay{network: network, address: address, listener: listener}
+	relays[key] = relay
+	go relay.serve()
+	log.Printf("  - OTLP sidecar collector at %s %s, relayed from %s", network, address, listener.Addr())
+	return relay, nil
+}
+
+func (r *collectorRelay) serve() {
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+		go r.relay(conn)
+	}
+}
+
+func (r *collectorRelay) relay(conn net.Conn) {
+	defer conn.Close()
+	upstream, err := net.DialTimeout(r.network, r.address, collectorDialTimeout)
+	if err != nil {
+		r.setConnected(false, err)
+		return
+	}
+	defer upstream.Close()
+	r.setConnected(true, nil)
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, conn)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, upstream)
+		done <- struct{}{}
+	}()
+	// Either side closing ends the exchange
+	<-done
+}
+
+// setConnected records a connection attempt, logging and counting changes of state
+func (r *collectorRelay) setConnected(connected bool, err error) {
+	ctx := context.Background()
+	r.mutex.Lock()
+	previous := r.connected
+	r.connected = &connected
+	r.mutex.Unlock()
+
+	if !connected {
+		RecordCollectorConnectionFailure(ctx, r.network)
+		if previous == nil || *previous {
+			log.Printf("WARN: OTLP collector at %s %s is unreachable, exports will be retried: %v", r.network, r.address, err)
+		}
+		return
+	}
+	if previous != nil && !*previous {
+		RecordCollectorReconnect(ctx, r.network)
+		log.Printf("✓ OTLP collector at %s %s is reachable again", r.network, r.address)
+	}
+}
+
+// waitForCollectors gives sidecar collectors up to wait to accept a connection, so early
+// telemetry isn't only retried; the service starts either way
+func waitForCollectors(wait time.Duration) {
+	if wait <= 0 {
+		return
+	}
+	relaysMutex.Lock()
+	pending := make([]*collectorRelay, 0, len(relays))
+	for _, relay := range relays {
+		pending = append(pending, relay)
+	}
+	relaysMutex.Unlock()
+
+	deadline := time.Now().Add(wait)
+	for _, relay := range pending {
+		for {
+			conn, err := net.DialTimeout(relay.network, relay.address, collectorDialTimeout)
+			if err == nil {
+				conn.Close()
+				break
+			}
+			if time.Now().After(deadline) {
+				log.Printf("WARN: OTLP collector at %s %s not up after %s, starting anyway", relay.network, relay.address, wait)
+				break
+			}
+			time.Sleep(500 * time.Millisecond)
+		}
+	}
+}
+
+// closeCollectorRelays stops the relays once the exporters have flushed
+func closeCollectorRelays() {
+	relaysMutex.Lock()
+	defer relaysMutex.Unlock()
+	for key, relay := range relays {
+		relay.listener.Close()
+		delete(relays, key)
+	}
+}
@@ -0,0 +1,110 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	otellog "go.opentelemetry.io/otel/log"
+)
+
+// Limits keep a single request from flooding the log pipeline
+const (
+	maxDemoLogCount = 10000
+	maxDemoLogRate  = 1000
+)
+
+var ErrInvalidDemoLogs = errors.New("invalid demo logs")
+
+var demoSeverities = map[string]otellog.Severity{
+	"trace": otellog.SeverityTrace,
+	"debug": otellog.SeverityDebug,
+	"info":  otellog.SeverityInfo,
+	"warn":  otellog.SeverityWarn,
+	"error": otellog.SeverityError,
+	"fatal": otellog.SeverityFatal,
+}
+
+// DemoLogOptions shapes a burst of synthetic log records. Severities are cycled in order,
+// so ["info","info","error"] yields one error per three records.
+type DemoLogOptions struct {
+	Count         int
+	RatePerSecond float64
+	Severities    []string
+	Message       string
+	Attributes    map[string]string
+}
+
+// EmitDemoLogs validates opts and emits the records in the background through the OTel
+// log pipeline, correlated with the span in ctx. It returns how long emission will take.
+func EmitDemoLogs(ctx context.Context, opts DemoLogOptions) (time.Duration, error) {
+	if Logger == nil {
+		return 0, errors.New("telemetry is not initialized")
+	}
+	if opts.Count < 1 || opts.Count > maxDemoLogCount {
+		return 0, fmt.Errorf("%w: count must be between 1 and %d", ErrInvalidDemoLogs, maxDemoLogCount)
+	}
+	if opts.RatePerSecond < 0 || opts.RatePerSecond > maxDemoLogRate {
+		return 0, fmt.Errorf("%w: rate_per_second must be between 0 (no pacing) and %d", ErrInvalidDemoLogs, maxDemoLogRate)
+	}
+	if len(opts.Severities) == 0 {
+		opts.Severities = []string{"info"}
+	}
+	severities := make([]otellog.Severity, len(opts.Severities))
+	for i, name := range opts.Severities {
+		severity, ok := demoSeverities[strings.ToLower(name)]
+		if !ok {
+			return 0, fmt.Errorf("%w: unknown severity %q (expected trace, debug, info, warn, error or fatal)", ErrInvalidDemoLogs, name)
+		}
+		severities[i] = severity
+	}
+	if opts.Message == "" {
+		opts.Message = "Synthetic demo log record"
+	}
+
+	var interval time.Duration
+	if opts.RatePerSecond > 0 {
+		interval = time.Duration(float64(time.Second) / opts.RatePerSecond)
+	}
+
+	keys := make([]string, 0, len(opts.Attributes))
+	for key := range opts.Attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	attrs := make([]otellog.KeyValue, 0, len(keys)+2)
+	for _, key := range keys {
+		attrs = append(attrs, otellog.String(key, opts.Attributes[key]))
+	}
+	attrs = append(attrs, otellog.Bool("demo.synthetic", true))
+
+	go emitDemoLogs(context.WithoutCancel(ctx), opts, severities, attrs, interval)
+	return interval * time.Duration(opts.Count), nil
+}
+
+func emitDemoLogs(ctx context.Context, opts DemoLogOptions, severities []otellog.Severity, attrs []otellog.KeyValue, interval time.Duration) {
+	var ticker *time.Ticker
+	if interval > 0 {
+		ticker = time.NewTicker(interval)
+		defer ticker.Stop()
+	}
+
+	for i := 0; i < opts.Count; i++ {
+		if ticker != nil && i > 0 {
+			<-ticker.C
+		}
+
+		severity := severities[i%len(severities)]
+		var record otellog.Record
+		record.SetTimestamp(time.Now())
+		record.SetSeverity(severity)
+		record.SetSeverityText(severity.String())
+		record.SetBody(otellog.StringValue(opts.Message))
+		record.AddAttributes(attrs...)
+		record.AddAttributes(otellog.Int("demo.sequence", i))
+		Logger.Emit(ctx, record)
+	}
+}
@@ -0,0 +1,172 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Instrument kinds accepted by the demo metrics API
+const (
+	DemoCounter       = "counter"
+	DemoUpDownCounter = "updowncounter"
+	DemoGauge         = "gauge"
+	DemoHistogram     = "histogram"
+)
+
+// Demo metrics are bounded so a presenter can't flood the metrics backend: the number of
+// instruments, the attributes on a value, and the attribute sets, so series, of each
+// instrument
+const (
+	maxDemoInstruments = 100
+	maxDemoAttributes  = 5
+	maxDemoSeries      = 20
+)
+
+// DemoMetricPrefix starts the name of every demo instrument, keeping them apart from the
+// service's own metrics
+const DemoMetricPrefix = "demo."
+
+var (
+	ErrInvalidDemoMetric = errors.New("invalid demo metric")
+	ErrDemoMetricLimit   = errors.New("demo metric limit reached")
+)
+
+// OpenTelemetry instrument name syntax, leaving room for the prefix
+var instrumentNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_./-]{0,249}$`)
+
+// DemoMetricName is the name a demo metric is recorded under
+func DemoMetricName(name string) string {
+	return DemoMetricPrefix + name
+}
+
+// demoInstrument is an ad-hoc instrument created through the demo metrics API
+type demoInstrument struct {
+	kind      string
+	counter   metric.Float64Counter
+	upDown    metric.Float64UpDownCounter
+	gauge     metric.Float64Gauge
+	histogram metric.Float64Histogram
+	// series holds the attribute sets recorded so far, guarded by demoMutex
+	series map[attribute.Distinct]struct{}
+}
+
+var (
+	demoMutex       sync.Mutex
+	demoInstruments = make(map[string]*demoInstrument)
+)
+
+// RecordDemoMetric records a value on an ad-hoc instrument named DemoMetricName(name),
+// creating it through the service Meter on first use. A name keeps the kind it was
+// first created with.
+func RecordDemoMetric(ctx context.Context, kind, name, unit, description string, value float64, attrs map[string]string) error {
+	if !instrumentNamePattern.MatchString(name) {
+		return fmt.Errorf("%w: name %q is not a valid instrument name", ErrInvalidDemoMetric, name)
+	}
+	if kind == DemoCounter && value < 0 {
+		return fmt.Errorf("%w: counter values must not be negative", ErrInvalidDemoMetric)
+	}
+	if len(attrs) > maxDemoAttributes {
+		return fmt.Errorf("%w: at most %d attributes", ErrInvalidDemoMetric, maxDemoAttributes)
+	}
+
+	instrument, err := demoInstrumentFor(kind, DemoMetricName(name), unit, description)
+	if err != nil {
+		return err
+	}
+
+	// Sort keys so identical attribute sets map to the same series
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	kvs := make([]attribute.KeyValue, 0, len(keys))
+	for _, key := range keys {
+		kvs = append(kvs, attribute.String(key, attrs[key]))
+	}
+	set := attribute.NewSet(kvs...)
+	if !instrument.admit(set) {
+		return fmt.Errorf("%w: %s already has %d attribute sets", ErrDemoMetricLimit, DemoMetricName(name), maxDemoSeries)
+	}
+	opt := metric.WithAttributeSet(set)
+
+	switch instrument.kind {
+	case DemoCounter:
+		instrument.counter.Add(ctx, value, opt)
+	case DemoUpDownCounter:
+		instrument.upDown.Add(withoutExemplar(ctx), value, opt)
+	case DemoGauge:
+		instrument.gauge.Record(ctx, value, opt)
+	case DemoHistogram:
+		instrument.histogram.Record(ctx, value, opt)
+	}
+	return nil
+}
+
+func demoInstrumentFor(kind, name, unit, description string) (*demoInstrument, error) {
+	demoMutex.Lock()
+	defer demoMutex.Unlock()
+
+	if instrument, ok := demoInstruments[name]; ok {
+		if instrument.kind != kind {
+			return nil, fmt.Errorf("%w: %s already exists as a %s", ErrInvalidDemoMetric, name, instrument.kind)
+		}
+		return instrument, nil
+	}
+
+	if Meter == nil {
+		return nil, errors.New("telemetry is not initialized")
+	}
+	if len(demoInstruments) >= maxDemoInstruments {
+		return nil, fmt.Errorf("%w: %d instruments", ErrDemoMetricLimit, maxDemoInstruments)
+	}
+
+	if description == "" {
+		description = "Ad-hoc demo metric"
+	}
+
+	instrument := &demoInstrument{kind: kind, series: make(map[attribute.Distinct]struct{})}
+	var err error
+	switch kind {
+	case DemoCounter:
+		instrument.counter, err = Meter.Float64Counter(name, metric.WithDescription(description), metric.WithUnit(unit))
+	case DemoUpDownCounter:
+		instrument.upDown, err = Meter.Float64UpDownCounter(name, metric.WithDescription(description), metric.WithUnit(unit))
+	case DemoGauge:
+		instrument.gauge, err = Meter.Float64Gauge(name, metric.WithDescription(description), metric.WithUnit(unit))
+	case DemoHistogram:
+		instrument.histogram, err = Meter.Float64Histogram(name, metric.WithDescription(description), metric.WithUnit(unit))
+	default:
+		return nil, fmt.Errorf("%w: unknown type %q (expected counter, updowncounter, gauge or histogram)", ErrInvalidDemoMetric, kind)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create demo %s %s: %w", kind, name, err)
+	}
+
+	demoInstruments[name] = instrument
+	return instrument, nil
+}
+
+// admit reports whether values with the attribute set can be recorded: sets seen before
+// can, and new ones until the instrument has maxDemoSeries
+func (i *demoInstrument) admit(set attribute.Set) bool {
+	demoMutex.Lock()
+	defer demoMutex.Unlock()
+
+	key := set.Equivalent()
+	if _, ok := i.series[key]; ok {
+		return true
+	}
+	if len(i.series) >= maxDemoSeries {
+		return false
+	}
+	i.series[key] = struct{}{}
+	return true
+}
@@ -0,0 +1,180 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Limits keep a single request from generating an unbounded trace
+const (
+	maxDemoTraceDepth  = 6
+	maxDemoTraceFanOut = 10
+	maxDemoTraceSpans  = 500
+)
+
+// Fake dependency types for leaf spans, chosen so each shows up as its own node on the
+// Application Map
+var demoDependencies = map[string]func(index int) (string, trace.SpanKind, []attribute.KeyValue){
+	"http": func(index int) (string, trace.SpanKind, []attribute.KeyValue) {
+		target := fmt.Sprintf("demo-api-%d", index%3)
+		return "GET /api/items", trace.SpanKindClient, []attribute.KeyValue{
+			attribute.String("http.request.method", "GET"),
+			attribute.String("url.full", "http://"+target+"/api/items"),
+			attribute.String("server.address", target),
+			attribute.Int("http.response.status_code", 200),
+		}
+	},
+	"sql": func(index int) (string, trace.SpanKind, []attribute.KeyValue) {
+		return "SELECT orders", trace.SpanKindClient, []attribute.KeyValue{
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.namespace", "demo"),
+			attribute.String("db.query.text", "SELECT * FROM orders WHERE id = $1"),
+			attribute.String("server.address", "demo-postgres"),
+		}
+	},
+	"redis": func(index int) (string, trace.SpanKind, []attribute.KeyValue) {
+		return "GET", trace.SpanKindClient, []attribute.KeyValue{
+			attribute.String("db.system", "redis"),
+			attribute.String("db.query.text", fmt.Sprintf("GET demo:%d", index)),
+			attribute.String("server.address", "demo-redis"),
+		}
+	},
+	"eventhub": func(index int) (string, trace.SpanKind, []attribute.KeyValue) {
+		return "demo-events send", trace.SpanKindProducer, []attribute.KeyValue{
+			attribute.String("messaging.system", "eventhubs"),
+			attribute.String("messaging.destination.name", "demo-events"),
+			attribute.String("messaging.operation", "publish"),
+			attribute.String("server.address", "demo-namespace.servicebus.windows.net"),
+		}
+	},
+	"servicebus": func(index int) (string, trace.SpanKind, []attribute.KeyValue) {
+		return "demo-queue send", trace.SpanKindProducer, []attribute.KeyValue{
+			attribute.String("messaging.system", "servicebus"),
+			attribute.String("messaging.destination.name", "demo-queue"),
+			attribute.String("messaging.operation", "publish"),
+			attribute.String("server.address", "demo-namespace.servicebus.windows.net"),
+		}
+	},
+}
+
+var ErrInvalidDemoTrace = errors.New("invalid demo trace")
+
+// DemoTraceOptions shapes a synthetic trace. Internal spans form a tree Depth levels
+// deep with FanOut children each; the last level are dependency calls.
+type DemoTraceOptions struct {
+	Depth            int
+	FanOut           int
+	SpanLatency      time.Duration
+	ErrorProbability float64
+	Dependencies     []string
+	Seed             int64
+}
+
+// DemoTraceResult identifies the generated trace
+type DemoTraceResult struct {
+	TraceID    string `json:"trace_id"`
+	SpanCount  int    `json:"span_count"`
+	ErrorCount int    `json:"error_count"`
+}
+
+type demoTraceGenerator struct {
+	opts   DemoTraceOptions
+	rand   *rand.Rand
+	result DemoTraceResult
+}
+
+// GenerateDemoTrace synthesizes a trace under the span in ctx. Span timestamps are set
+// explicitly rather than slept, so the trace starts now and may end after the request
+// returns. The same Seed always yields the same shape and errors.
+func GenerateDemoTrace(ctx context.Context, opts DemoTraceOptions) (DemoTraceResult, error) {
+	if opts.Depth < 1 || opts.Depth > maxDemoTraceDepth {
+		return DemoTraceResult{}, fmt.Errorf("%w: depth must be between 1 and %d", ErrInvalidDemoTrace, maxDemoTraceDepth)
+	}
+	if opts.FanOut < 1 || opts.FanOut > maxDemoTraceFanOut {
+		return DemoTraceResult{}, fmt.Errorf("%w: fan_out must be between 1 and %d", ErrInvalidDemoTrace, maxDemoTraceFanOut)
+	}
+	if opts.ErrorProbability < 0 || opts.ErrorProbability > 1 {
+		return DemoTraceResult{}, fmt.Errorf("%w: error_probability must be between 0 and 1", ErrInvalidDemoTrace)
+	}
+	if len(opts.Dependencies) == 0 {
+		opts.Dependencies = []string{"http", "sql", "redis"}
+	}
+	for _, dependency := range opts.Dependencies {
+		if _, ok := demoDependencies[dependency]; !ok {
+			return DemoTraceResult{}, fmt.Errorf("%w: unknown dependency type %q (expected http, sql, redis, eventhub or servicebus)", ErrInvalidDemoTrace, dependency)
+		}
+	}
+
+	spans, level := 1, 1
+	for i := 1; i < opts.Depth; i++ {
+		level *= opts.FanOut
+		spans += level
+	}
+	if spans > maxDemoTraceSpans {
+		return DemoTraceResult{}, fmt.Errorf("%w: depth %d with fan_out %d would create %d spans (limit %d)", ErrInvalidDemoTrace, opts.Depth, opts.FanOut, spans, maxDemoTraceSpans)
+	}
+	if opts.Seed == 0 {
+		opts.Seed = time.Now().UnixNano()
+	}
+
+	g := &demoTraceGenerator{opts: opts, rand: rand.New(rand.NewSource(opts.Seed))}
+	g.span(ctx, "demo.operation", 1, 0, time.Now())
+	return g.result, nil
+}
+
+// span emits one span starting at start and its subtree, returning its end time and
+// whether it failed. Children run one after another inside their parent.
+func (g *demoTraceGenerator) span(ctx context.Context, name string, level, index int, start time.Time) (time.Time, bool) {
+	kind := trace.SpanKindInternal
+	var attrs []attribute.KeyValue
+	leaf := level == g.opts.Depth && level > 1
+	if leaf {
+		dependency := g.opts.Dependencies[index%len(g.opts.Dependencies)]
+		name, kind, attrs = demoDependencies[dependency](index)
+		attrs = append(attrs, attribute.String("demo.dependency.type", dependency))
+	}
+	attrs = append(attrs, attribute.Bool("demo.synthetic", true), attribute.Int("demo.level", level))
+
+	spanCtx, span := Tracer.Start(ctx, name,
+		trace.WithSpanKind(kind),
+		trace.WithTimestamp(start),
+		trace.WithAttributes(attrs...),
+	)
+	if g.result.TraceID == "" {
+		g.result.TraceID = span.SpanContext().TraceID().String()
+	}
+	g.result.SpanCount++
+
+	end := start.Add(g.opts.SpanLatency / 2)
+	failed := false
+	if level < g.opts.Depth {
+		for child := 0; child < g.opts.FanOut; child++ {
+			childName := fmt.Sprintf("demo.step.%d.%d", level+1, child)
+			childEnd, childFailed := g.span(spanCtx, childName, level+1, index*g.opts.FanOut+child, end)
+			end = childEnd
+			failed = failed || childFailed
+		}
+	}
+	end = end.Add(g.opts.SpanLatency - g.opts.SpanLatency/2)
+
+	if level == g.opts.Depth && g.rand.Float64() < g.opts.ErrorProbability {
+		failed = true
+		span.RecordError(errors.New("synthetic dependency failure"), trace.WithTimestamp(end))
+	}
+	if failed {
+		g.result.ErrorCount++
+		span.SetStatus(codes.Error, "synthetic failure")
+	} else {
+		span.SetStatus(codes.Ok, "")
+	}
+
+	span.End(trace.WithTimestamp(end))
+	return end, failed
+}
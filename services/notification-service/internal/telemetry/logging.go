@@ -0,0 +1,174 @@
+package telemetry
+
+import (
+	"context"
+	"errors"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"notification-service/internal/config"
+
+	"go.opentelemetry.io/contrib/bridges/otelslog"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// stdlogLevels maps the prefixes of standard log lines to the level they are logged at
+var stdlogLevels = []struct {
+	prefix string
+	level  slog.Level
+}{
+	{"ERROR:", slog.LevelError},
+	{"Error", slog.LevelError},
+	{"WARN:", slog.LevelWarn},
+	{"Warning:", slog.LevelWarn},
+}
+
+// InitLogging makes slog's default logger write to stderr at LOG_LEVEL, as text or JSON
+// (LOG_FORMAT), and to the OpenTelemetry log pipeline once InitTelemetry has set it up.
+// Records logged with a context carry the trace_id and span_id of its span. Output of
+// the standard log package goes through it as well.
+func InitLogging(cfg *config.Config) {
+	var level slog.Level
+	levelErr := level.UnmarshalText([]byte(cfg.LogLevel))
+	if levelErr != nil {
+		level = slog.LevelInfo
+	}
+
+	options := &slog.HandlerOptions{Level: level}
+	var console slog.Handler = slog.NewTextHandler(os.Stderr, options)
+	if strings.EqualFold(cfg.LogFormat, "json") {
+		console = slog.NewJSONHandler(os.Stderr, options)
+	}
+
+	// The bridge logs through the global provider, which forwards to the one
+	// InitTelemetry sets and drops records until then
+	bridge := levelHandler{
+		Handler: otelslog.NewHandler("notification-service",
+			otelslog.WithVersion("1.0.0"),
+			otelslog.WithSchemaURL(semconv.SchemaURL),
+		),
+		level: level,
+	}
+
+	logger := slog.New(fanoutHandler{traceHandler{console}, bridge})
+	slog.SetDefault(logger)
+	log.SetFlags(0)
+	log.SetOutput(stdlogWriter{logger.Handler()})
+
+	if levelErr != nil {
+		slog.Warn("Ignoring LOG_LEVEL, logging at info", "log_level", cfg.LogLevel, "error", levelErr)
+	}
+}
+
+// fanoutHandler passes each record to every handler that is enabled for its level
+type fanoutHandler []slog.Handler
+
+func (h fanoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range h {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h fanoutHandler) Handle(ctx context.Context, record slog.Record) error {
+	var errs []error
+	for _, handler := range h {
+		if handler.Enabled(ctx, record.Level) {
+			errs = append(errs, handler.Handle(ctx, record.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (h fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(fanoutHandler, len(h))
+	for i, handler := range h {
+		handlers[i] = handler.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (h fanoutHandler) WithGroup(name string) slog.Handler {
+	handlers := make(fanoutHandler, len(h))
+	for i, handler := range h {
+		handlers[i] = handler.WithGroup(name)
+	}
+	return handlers
+}
+
+// traceHandler adds the trace_id and span_id of the context's span to console records
+type traceHandler struct {
+	slog.Handler
+}
+
+func (h traceHandler) Handle(ctx context.Context, record slog.Record) error {
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		record.AddAttrs(
+			slog.String("trace_id", spanContext.TraceID().String()),
+			slog.String("span_id", spanContext.SpanID().String()),
+		)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h traceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h traceHandler) WithGroup(name string) slog.Handler {
+	return traceHandler{h.Handler.WithGroup(name)}
+}
+
+// levelHandler drops records below level, which the OpenTelemetry bridge doesn't filter
+// itself
+type levelHandler struct {
+	slog.Handler
+	level slog.Level
+}
+
+func (h levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level && h.Handler.Enabled(ctx, level)
+}
+
+func (h levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return levelHandler{h.Handler.WithAttrs(attrs), h.level}
+}
+
+func (h levelHandler) WithGroup(name string) slog.Handler {
+	return levelHandler{h.Handler.WithGroup(name), h.level}
+}
+
+// stdlogWriter turns standard log output into slog records at the level named by the
+// line's prefix, so lines logged without a context are exported with a severity too.
+// The standard log package has no context to take a trace from, so code serving a
+// request logs with slog's Context functions instead.
+type stdlogWriter struct {
+	handler slog.Handler
+}
+
+func (w stdlogWriter) Write(p []byte) (int, error) {
+	message := strings.TrimSuffix(string(p), "\n")
+	level := slog.LevelInfo
+	for _, entry := range stdlogLevels {
+		if strings.HasPrefix(message, entry.prefix) {
+			level = entry.level
+			// "ERROR: x" becomes "x"; "Error closing x" stays as it is
+			if strings.HasSuffix(entry.prefix, ":") {
+				message = strings.TrimSpace(message[len(entry.prefix):])
+			}
+			break
+		}
+	}
+
+	ctx := context.Background()
+	if !w.handler.Enabled(ctx, level) {
+		return len(p), nil
+	}
+	return len(p), w.handler.Handle(ctx, slog.NewRecord(time.Now(), level, message, 0))
+}
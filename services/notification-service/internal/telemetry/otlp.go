@@ -0,0 +1,202 @@
+package telemetry
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+
+	"notification-service/internal/config"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc/credentials"
+)
+
+// Values of OTEL_EXPORTER_OTLP_PROTOCOL
+const (
+	ProtocolHTTP = "http/protobuf"
+	ProtocolGRPC = "grpc"
+)
+
+// otlpTransport is how the OTLP exporters reach the collector: over HTTP or gRPC, with
+// the headers sent with every export and, for https endpoints, a TLS configuration when
+// a CA or client certificate is set
+type otlpTransport struct {
+	grpc    bool
+	headers map[string]string
+	tls     *tls.Config
+}
+
+// newOTLPTransport reads the OTLP protocol, headers and certificates. An unknown
+// protocol falls back to HTTP; certificates that can't be loaded are an error, since
+// an authenticated collector would refuse every export.
+func newOTLPTransport(cfg *config.Config) (*otlpTransport, error) {
+	transport := &otlpTransport{headers: parseOTLPHeaders(cfg.OTLPHeaders)}
+	switch protocol := strings.ToLower(strings.TrimSpace(cfg.OTLPProtocol)); protocol {
+	case "", ProtocolHTTP:
+	case ProtocolGRPC:
+		transport.grpc = true
+	default:
+		log.Printf("Warning: Unsupported OTEL_EXPORTER_OTLP_PROTOCOL %q, exporting over %s", cfg.OTLPProtocol, ProtocolHTTP)
+	}
+
+	tlsConfig, err := otlpTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	transport.tls = tlsConfig
+	return transport, nil
+}
+
+// parseOTLPHeaders reads OTEL_EXPORTER_OTLP_HEADERS, comma-separated key=value pairs
+// with URL-encoded values
+func parseOTLPHeaders(value string) map[string]string {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		key, raw, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			continue
+		}
+		decoded, err := url.PathUnescape(strings.TrimSpace(raw))
+		if err != nil {
+			decoded = strings.TrimSpace(raw)
+		}
+		headers[key] = decoded
+	}
+	return headers
+}
+
+// otlpTLSConfig trusts the CA of OTEL_EXPORTER_OTLP_CERTIFICATE and presents the client
+// certificate for mutual TLS, or returns nil to use the system roots
+func otlpTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	if cfg.OTLPCertificate == "" && cfg.OTLPClientCertificate == "" && cfg.OTLPClientKey == "" {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.OTLPCertificate != "" {
+		pem, err := os.ReadFile(cfg.OTLPCertificate)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read OTLP CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in OTLP CA certificate %s", cfg.OTLPCertificate)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.OTLPClientCertificate != "" || cfg.OTLPClientKey != "" {
+		if cfg.OTLPClientCertificate == "" || cfg.OTLPClientKey == "" {
+			return nil, errors.New("OTEL_EXPORTER_OTLP_CLIENT_CERTIFICATE and OTEL_EXPORTER_OTLP_CLIENT_KEY must be set together")
+		}
+		certificate, err := tls.LoadX509KeyPair(cfg.OTLPClientCertificate, cfg.OTLPClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load OTLP client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
+
+// protocol names the transport for the startup log
+func (t *otlpTransport) protocol() string {
+	if t.grpc {
+		return ProtocolGRPC
+	}
+	return ProtocolHTTP
+}
+
+// signalPath is the path appended to OTEL_EXPORTER_OTLP_ENDPOINT for a signal; gRPC
+// endpoints have none
+func (t *otlpTransport) signalPath(path string) string {
+	if t.grpc {
+		return ""
+	}
+	return path
+}
+
+// tlsCredentials returns the gRPC credentials of an https endpoint with a custom TLS
+// configuration, or nil to leave the choice to the endpoint's scheme
+func (t *otlpTransport) tlsCredentials(endpoint string) credentials.TransportCredentials {
+	if t.tls == nil || !strings.HasPrefix(endpoint, "https://") {
+		return nil
+	}
+	return credentials.NewTLS(t.tls)
+}
+
+// newSpanExporter creates the OTLP trace exporter for the full endpoint URL
+func (t *otlpTransport) newSpanExporter(ctx context.Context, endpoint string) (sdktrace.SpanExporter, error) {
+	if t.grpc {
+		options := []otlptracegrpc.Option{otlptracegrpc.WithEndpointURL(endpoint), otlptracegrpc.WithHeaders(t.headers)}
+		if creds := t.tlsCredentials(endpoint); creds != nil {
+			options = append(options, otlptracegrpc.WithTLSCredentials(creds))
+		}
+		return otlptracegrpc.New(ctx, options...)
+	}
+	// Use minimal configuration for Azure Monitor compatibility
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpointURL(endpoint), otlptracehttp.WithHeaders(t.headers)}
+	if t.tls != nil {
+		options = append(options, otlptracehttp.WithTLSClientConfig(t.tls))
+	}
+	return otlptracehttp.New(ctx, options...)
+}
+
+// newMetricExporter creates the OTLP metric exporter for the full endpoint URL
+func (t *otlpTransport) newMetricExporter(ctx context.Context, endpoint string) (sdkmetric.Exporter, error) {
+	if t.grpc {
+		options := []otlpmetricgrpc.Option{otlpmetricgrpc.WithEndpointURL(endpoint), otlpmetricgrpc.WithHeaders(t.headers)}
+		if creds := t.tlsCredentials(endpoint); creds != nil {
+			options = append(options, otlpmetricgrpc.WithTLSCredentials(creds))
+		}
+		return otlpmetricgrpc.New(ctx, options...)
+	}
+	options := []otlpmetrichttp.Option{otlpmetrichttp.WithEndpointURL(endpoint), otlpmetrichttp.WithHeaders(t.headers)}
+	if t.tls != nil {
+		options = append(options, otlpmetrichttp.WithTLSClientConfig(t.tls))
+	}
+	return otlpmetrichttp.New(ctx, options...)
+}
+
+// newLogExporter creates the OTLP log exporter for the full endpoint URL
+func (t *otlpTransport) newLogExporter(ctx context.Context, endpoint string) (sdklog.Exporter, error) {
+	if t.grpc {
+		options := []otlploggrpc.Option{otlploggrpc.WithEndpointURL(endpoint), otlploggrpc.WithHeaders(t.headers)}
+		if creds := t.tlsCredentials(endpoint); creds != nil {
+			options = append(options, otlploggrpc.WithTLSCredentials(creds))
+		}
+		return otlploggrpc.New(ctx, options...)
+	}
+
+	// Parse endpoint to extract host:port and path
+	// Azure Monitor injects complete URL like "http://10.0.2.62:28331/v1/logs"
+	// But Go OTLP HTTP exporter WithEndpoint() expects just "host:port" and WithURLPath() for path
+	parsedURL, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse logs endpoint: %w", err)
+	}
+	options := []otlploghttp.Option{
+		otlploghttp.WithEndpoint(parsedURL.Host), // Just host:port
+		otlploghttp.WithURLPath(parsedURL.Path),  // Explicit path
+		otlploghttp.WithCompression(otlploghttp.GzipCompression),
+		otlploghttp.WithHeaders(t.headers),
+	}
+	if parsedURL.Scheme != "https" {
+		options = append(options, otlploghttp.WithInsecure())
+	} else if t.tls != nil {
+		options = append(options, otlploghttp.WithTLSClientConfig(t.tls))
+	}
+	return otlploghttp.New(ctx, options...)
+}
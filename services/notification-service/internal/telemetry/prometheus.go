@@ -0,0 +1,46 @@
+package telemetry
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	otelprometheus "go.opentelemetry.io/otel/exporters/prometheus"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+// ErrPrometheusDisabled is returned when the meter provider has no Prometheus reader
+var ErrPrometheusDisabled = errors.New("prometheus metrics are disabled")
+
+// prometheusRegistry holds the metrics the Prometheus exporter collects from the meter
+// provider on each scrape; it is nil while the exporter is off
+var prometheusRegistry *prometheus.Registry
+
+// newPrometheusReader creates the OTel Prometheus exporter, a reader collecting the same
+// data the OTLP exporter sends, registered on a registry of its own so only the
+// service's instruments are exposed. Names follow the OTel conventions: dots become
+// underscores, the unit is appended and monotonic sums end in _total. Resource
+// attributes are exposed once on target_info.
+func newPrometheusReader() (sdkmetric.Reader, error) {
+	registry := prometheus.NewRegistry()
+	exporter, err := otelprometheus.New(
+		otelprometheus.WithRegisterer(registry),
+		otelprometheus.WithoutScopeInfo(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Prometheus exporter: %w", err)
+	}
+	prometheusRegistry = registry
+	return exporter, nil
+}
+
+// PrometheusHandler serves the meter provider's metrics in the Prometheus exposition
+// format, negotiated with the scraper
+func PrometheusHandler() (http.Handler, error) {
+	if prometheusRegistry == nil {
+		return nil, ErrPrometheusDisabled
+	}
+	return promhttp.HandlerFor(prometheusRegistry, promhttp.HandlerOpts{}), nil
+}
@@ -0,0 +1,57 @@
+package telemetry_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"notification-service/internal/config"
+	"notification-service/internal/handlers"
+	"notification-service/internal/middleware"
+	"notification-service/internal/router"
+	"notification-service/internal/telemetry"
+)
+
+// TestMetricsScrapeAfterTracedRequest scrapes /metrics after a traced request has moved
+// the in-flight gauge. An exemplar from the request's span on that gauge made promhttp
+// fail the scrape with a 500.
+func TestMetricsScrapeAfterTracedRequest(t *testing.T) {
+	shutdown, err := telemetry.InitTelemetry(&config.Config{
+		ServiceName:       "notification-service",
+		TelemetryExporter: telemetry.ExporterAzureMonitor,
+		PrometheusEnabled: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = shutdown(context.Background()) })
+
+	for _, mode := range []string{router.ModeGin, router.ModeStdlib} {
+		t.Run(mode, func(t *testing.T) {
+			routes, err := router.New(mode, "notification-service")
+			if err != nil {
+				t.Fatal(err)
+			}
+			routes.Use(middleware.MetricsMiddleware())
+			routes.GET("/ping", func(c *router.Context) { c.Status(http.StatusOK) })
+			routes.GET("/metrics", handlers.MetricsHandler)
+
+			ping := httptest.NewRecorder()
+			routes.Handler().ServeHTTP(ping, httptest.NewRequest(http.MethodGet, "/ping", nil))
+			if ping.Code != http.StatusOK {
+				t.Fatalf("GET /ping = %d, want 200", ping.Code)
+			}
+
+			scrape := httptest.NewRecorder()
+			routes.Handler().ServeHTTP(scrape, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			if scrape.Code != http.StatusOK {
+				t.Fatalf("GET /metrics = %d, want 200: %s", scrape.Code, scrape.Body.String())
+			}
+			if !strings.Contains(scrape.Body.String(), "http_server_active_requests") {
+				t.Error("scrape is missing http_server_active_requests")
+			}
+		})
+	}
+}
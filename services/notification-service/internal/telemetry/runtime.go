@@ -0,0 +1,29 @@
+package telemetry
+
+import (
+	"fmt"
+
+	"notification-service/internal/config"
+
+	"go.opentelemetry.io/contrib/instrumentation/host"
+	"go.opentelemetry.io/contrib/instrumentation/runtime"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// startRuntimeMetrics reports Go runtime and host metrics through the meter provider, so
+// they reach every exporter the notification metrics do: goroutines, GC pauses, heap and
+// memory stats from the runtime, and process and system CPU, memory and network from the
+// host. Both are observed at collection time and cost nothing between exports.
+func startRuntimeMetrics(cfg *config.Config, provider metric.MeterProvider) error {
+	if cfg.RuntimeMetricsEnabled {
+		if err := runtime.Start(runtime.WithMeterProvider(provider)); err != nil {
+			return fmt.Errorf("failed to start runtime metrics: %w", err)
+		}
+	}
+	if cfg.HostMetricsEnabled {
+		if err := host.Start(host.WithMeterProvider(provider)); err != nil {
+			return fmt.Errorf("failed to start host metrics: %w", err)
+		}
+	}
+	return nil
+}
@@ -4,18 +4,18 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"net/url"
 	"os"
 	"runtime"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"notification-service/internal/config"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
-	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
-	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	otellog "go.opentelemetry.io/otel/log"
+	"go.opentelemetry.io/otel/log/global"
 	"go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/instrumentation"
@@ -28,9 +28,10 @@ import (
 )
 
 var (
-	// Global tracer and meter
+	// Global tracer, meter and OpenTelemetry logger
 	Tracer trace.Tracer
 	Meter  metric.Meter
+	Logger otellog.Logger
 
 	// Custom metrics - Counters
 	NotificationsSentCounter    metric.Int64Counter
@@ -40,22 +41,96 @@ var (
 	EventHubProcessingErrors    metric.Int64Counter
 	WebSocketMessagesSent       metric.Int64Counter
 	WebSocketMessagesErrors     metric.Int64Counter
+	EventHubPublishErrors       metric.Int64Counter
+	EventHubFailoverCounter     metric.Int64Counter
+	EventHubEventsDropped       metric.Int64Counter
+	RedisBufferDropped          metric.Int64Counter
+	TemplateEventsPublished     metric.Int64Counter
+	BroadcastDecisions          metric.Int64Counter
+	BroadcastDeliveries         metric.Int64Counter
+	CacheLookups                metric.Int64Counter
+	CacheEvictions              metric.Int64Counter
+	SchemaValidationRejections  metric.Int64Counter
+	FaultsInjected              metric.Int64Counter
+	FaultsWouldInject           metric.Int64Counter
+	NotificationCancellations   metric.Int64Counter
+	IdempotentReplays           metric.Int64Counter
+	RateLimitHits               metric.Int64Counter
+	NotificationRedrives        metric.Int64Counter
+	NotificationsCreated        metric.Int64Counter
+	PresenceChanges             metric.Int64Counter
+	RoutingOutcomes             metric.Int64Counter
+	WebSocketCoalesced          metric.Int64Counter
+	WebSocketResumes            metric.Int64Counter
+	WebSocketReplayed           metric.Int64Counter
+	EngagementEvents            metric.Int64Counter
+	DeliveryRetries             metric.Int64Counter
+	ProviderThrottles           metric.Int64Counter
+	ContentScreenings           metric.Int64Counter
+	LanguageDetections          metric.Int64Counter
+	SendTimeAssignments         metric.Int64Counter
+	SendTimeEngagements         metric.Int64Counter
+	HTTPServerRequests          metric.Int64Counter
+	TenantStarvations           metric.Int64Counter
+	ProxyFailures               metric.Int64Counter
+	DeadLetters                 metric.Int64Counter
+	DeadLetterRedrives          metric.Int64Counter
+	RetriesScheduled            metric.Int64Counter
+	CollectorConnectFailures    metric.Int64Counter
+	CollectorReconnects         metric.Int64Counter
+	NotificationsSuppressed     metric.Int64Counter
+	NotificationsDeferred       metric.Int64Counter
+	DeprecatedRequests          metric.Int64Counter
+	TestSends                   metric.Int64Counter
+	StatusTransitions           metric.Int64Counter
+	NotificationReplacements    metric.Int64Counter
+	NotificationPersistFailures metric.Int64Counter
+	PushSends                   metric.Int64Counter
+	SMSKeywords                 metric.Int64Counter
+	BlackoutDeferrals           metric.Int64Counter
+	EmailReplies                metric.Int64Counter
+	NotificationsBatched        metric.Int64Counter
+	Translations                metric.Int64Counter
+	DuplicateEvents             metric.Int64Counter
+	PreferenceConflicts         metric.Int64Counter
+	ProviderSends               metric.Int64Counter
+	CircuitTransitions          metric.Int64Counter
 
 	// Custom metrics - Histograms
 	NotificationDeliveryHist    metric.Float64Histogram
 	EventProcessingDuration     metric.Float64Histogram
 	EventHubConsumeDuration     metric.Float64Histogram
 	WebSocketDeliveryDuration   metric.Float64Histogram
+	EventHubPublishDuration     metric.Float64Histogram
+	EventHubPublishBatchSize    metric.Int64Histogram
+	ProviderThrottleWait        metric.Float64Histogram
+	SendTimeDelay               metric.Float64Histogram
+	HTTPServerDuration          metric.Float64Histogram
+	TenantQueueWait             metric.Float64Histogram
+	NotificationRetryCount      metric.Int64Histogram
+	CustomerDigestSize          metric.Int64Histogram
 
 	// Custom metrics - UpDownCounters
 	ActiveWebSocketConnections  metric.Int64UpDownCounter
 	EventHubActivePartitions    metric.Int64UpDownCounter
+	RedisBufferDepth            metric.Int64UpDownCounter
+	HTTPServerActiveRequests    metric.Int64UpDownCounter
+	TenantQueueDepth            metric.Int64UpDownCounter
 
 	// Custom metrics - Observable Gauges
 	QueueSizeGauge             metric.Int64ObservableGauge
+	ScheduledQueueGauge        metric.Int64ObservableGauge
+	RetryingQueueGauge         metric.Int64ObservableGauge
+	RegisteredDevicesGauge     metric.Int64ObservableGauge
+	ProviderWeightGauge        metric.Float64ObservableGauge
+	ProviderThrottleGauge      metric.Float64ObservableGauge
+
+	// deviceCounts is the last count of registered devices by platform, reported by
+	// RegisteredDevicesGauge
+	deviceCounts atomic.Pointer[map[string]int64]
 )
 
-// InitTelemetry initializes OpenTelemetry with OTLP exporters
+// InitTelemetry initializes OpenTelemetry with OTLP and/or Azure Monitor exporters
 func InitTelemetry(cfg *config.Config) (func(context.Context) error, error) {
 	ctx := context.Background()
 
@@ -67,25 +142,40 @@ func InitTelemetry(cfg *config.Config) (func(context.Context) error, error) {
 		res = resource.Default()
 	}
 
+	// TELEMETRY_EXPORTER picks OTLP, Azure Monitor or both
+	useOTLP, azureMonitor := selectExporters(cfg)
+	var otlp *otlpTransport
+	if useOTLP {
+		if otlp, err = newOTLPTransport(cfg); err != nil {
+			return nil, fmt.Errorf("failed to configure OTLP exporters: %w", err)
+		}
+	}
+
 	// Initialize trace provider
-	traceProvider, err := newTraceProvider(ctx, cfg, res)
+	traceProvider, err := newTraceProvider(ctx, cfg, res, otlp, azureMonitor)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create trace provider: %w", err)
 	}
 	otel.SetTracerProvider(traceProvider)
 
 	// Initialize metric provider
-	meterProvider, err := newMeterProvider(ctx, cfg, res)
+	meterProvider, err := newMeterProvider(ctx, cfg, res, otlp, azureMonitor)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create meter provider: %w", err)
 	}
 	otel.SetMeterProvider(meterProvider)
 
 	// Initialize log provider
-	logProvider, err := newLogProvider(ctx, cfg, res)
+	logProvider, err := newLogProvider(ctx, cfg, res, otlp, azureMonitor)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create log provider: %w", err)
 	}
+	if logProvider != nil {
+		global.SetLoggerProvider(logProvider)
+	}
+	if otlp != nil {
+		waitForCollectors(time.Duration(cfg.OTLPStartupWaitSeconds) * time.Second)
+	}
 
 	// Set text map propagator for distributed tracing
 	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
@@ -104,16 +194,32 @@ func InitTelemetry(cfg *config.Config) (func(context.Context) error, error) {
 		metric.WithSchemaURL(semconv.SchemaURL),
 	)
 
+	Logger = global.Logger("notification-service",
+		otellog.WithInstrumentationVersion("1.0.0"),
+		otellog.WithSchemaURL(semconv.SchemaURL),
+	)
+
 	// Initialize custom metrics
 	if err := initMetrics(); err != nil {
 		return nil, fmt.Errorf("failed to initialize metrics: %w", err)
 	}
+	if err := startRuntimeMetrics(cfg, meterProvider); err != nil {
+		return nil, err
+	}
 
 	log.Println("✓ OpenTelemetry initialized successfully")
 	log.Printf("  - Service: %s", cfg.ServiceName)
+	log.Printf("  - Exporters: OTLP=%t Azure Monitor=%t", otlp != nil, azureMonitor != nil)
+	if otlp != nil {
+		log.Printf("  - OTLP Protocol: %s", otlp.protocol())
+	}
 	log.Printf("  - OTLP Traces Endpoint: %s", cfg.OTLPTracesEndpoint)
 	log.Printf("  - OTLP Metrics Endpoint: %s", cfg.OTLPMetricsEndpoint)
 	log.Printf("  - OTLP Logs Endpoint: %s", cfg.OTLPLogsEndpoint)
+	if cfg.OTLPSocket != "" {
+		log.Printf("  - OTLP Collector Socket: %s", cfg.OTLPSocket)
+	}
+	log.Printf("  - Runtime Metrics: %t Host Metrics: %t", cfg.RuntimeMetricsEnabled, cfg.HostMetricsEnabled)
 	log.Printf("  - Environment: %s", cfg.Environment)
 
 	// Return shutdown function
@@ -137,6 +243,7 @@ func InitTelemetry(cfg *config.Config) (func(context.Context) error, error) {
 				log.Printf("Error shutting down log provider: %v", err)
 			}
 		}
+		closeCollectorRelays()
 		
 		log.Println("✓ OpenTelemetry shutdown complete")
 		return nil
@@ -178,71 +285,70 @@ func newResource(cfg *config.Config) (*resource.Resource, error) {
 		attribute.String("microsoft.applicationId", applicationId),
 	)
 	
+	// Active-active deployments tell their regions apart by cloud.region
+	if cfg.ServiceRegion != "" {
+		regionRes := resource.NewWithAttributes(semconv.SchemaURL, semconv.CloudRegion(cfg.ServiceRegion))
+		var err error
+		if serviceRes, err = resource.Merge(serviceRes, regionRes); err != nil {
+			return nil, err
+		}
+	}
+
 	// Merge with service attributes taking precedence (listed second)
 	return resource.Merge(defaultRes, serviceRes)
 }
 
-// newTraceProvider creates a trace provider with OTLP HTTP exporter
-func newTraceProvider(ctx context.Context, cfg *config.Config, res *resource.Resource) (*sdktrace.TracerProvider, error) {
-	// If no OTLP endpoint configured, use noop provider
-	if cfg.OTLPTracesEndpoint == "" {
-		log.Println("Warning: No OTLP traces endpoint configured, traces will not be exported")
-		return sdktrace.NewTracerProvider(
-			sdktrace.WithResource(res),
-		), nil
-	}
-
-	// Create OTLP HTTP trace exporter
-	// Use minimal configuration for Azure Monitor compatibility
-	traceExporter, err := otlptracehttp.New(
-		ctx,
-		otlptracehttp.WithEndpointURL(cfg.OTLPTracesEndpoint), // Use full URL directly
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
+// newTraceProvider creates a trace provider with an OTLP exporter and, when
+// TELEMETRY_EXPORTER says so, an Azure Monitor one
+func newTraceProvider(ctx context.Context, cfg *config.Config, res *resource.Resource, otlp *otlpTransport, azureMonitor *azureMonitorClient) (*sdktrace.TracerProvider, error) {
+	options := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.AlwaysSample()), // Sample all traces for demo
 	}
-
-	// Create trace provider with batch processor
-	tp := sdktrace.NewTracerProvider(
-		sdktrace.WithBatcher(traceExporter,
+	if azureMonitor != nil {
+		options = append(options, sdktrace.WithBatcher(&azureMonitorSpanExporter{client: azureMonitor},
 			sdktrace.WithMaxExportBatchSize(512),
 			sdktrace.WithBatchTimeout(5*time.Second),
 			sdktrace.WithMaxQueueSize(2048),
-		),
-		sdktrace.WithResource(res),
-		sdktrace.WithSampler(sdktrace.AlwaysSample()), // Sample all traces for demo
-	)
+		))
+	}
+	if otlp == nil {
+		return sdktrace.NewTracerProvider(options...), nil
+	}
 
-	return tp, nil
-}
+	endpoint, err := collectorEndpoint(cfg, cfg.OTLPTracesEndpoint, otlp.signalPath(otlpTracesPath))
+	if err != nil {
+		log.Printf("Warning: Invalid OTLP traces endpoint, traces will not be exported: %v", err)
+	}
 
-// newMeterProvider creates a meter provider with OTLP HTTP exporter
-func newMeterProvider(ctx context.Context, cfg *config.Config, res *resource.Resource) (*sdkmetric.MeterProvider, error) {
-	// If no OTLP endpoint configured, use noop provider
-	if cfg.OTLPMetricsEndpoint == "" {
-		log.Println("Warning: No OTLP metrics endpoint configured, metrics will not be exported")
-		return sdkmetric.NewMeterProvider(
-			sdkmetric.WithResource(res),
-		), nil
+	// If no OTLP endpoint configured, spans only go to Azure Monitor, if anywhere
+	if endpoint == "" {
+		if err == nil {
+			log.Println("Warning: No OTLP traces endpoint configured, traces will not be exported")
+		}
+		return sdktrace.NewTracerProvider(options...), nil
 	}
 
-	// Create OTLP HTTP metric exporter
-	// Use minimal configuration for Azure Monitor compatibility
-	metricExporter, err := otlpmetrichttp.New(
-		ctx,
-		otlpmetrichttp.WithEndpointURL(cfg.OTLPMetricsEndpoint), // Use full URL directly
-	)
+	// Create OTLP trace exporter over HTTP or gRPC
+	traceExporter, err := otlp.newSpanExporter(ctx, endpoint)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create metric exporter: %w", err)
+		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
 	}
 
-	// Create meter provider with periodic reader
-	mp := sdkmetric.NewMeterProvider(
-		sdkmetric.WithReader(
-			sdkmetric.NewPeriodicReader(metricExporter,
-				sdkmetric.WithInterval(15*time.Second), // Export every 15 seconds
-			),
-		),
+	// Create trace provider with batch processor
+	options = append(options, sdktrace.WithBatcher(traceExporter,
+		sdktrace.WithMaxExportBatchSize(512),
+		sdktrace.WithBatchTimeout(5*time.Second),
+		sdktrace.WithMaxQueueSize(2048),
+	))
+	return sdktrace.NewTracerProvider(options...), nil
+}
+
+// newMeterProvider creates a meter provider with an OTLP exporter, an Azure Monitor
+// one when TELEMETRY_EXPORTER says so and, unless PROMETHEUS_METRICS_ENABLED is off, the
+// reader GET /metrics is served from
+func newMeterProvider(ctx context.Context, cfg *config.Config, res *resource.Resource, otlp *otlpTransport, azureMonitor *azureMonitorClient) (*sdkmetric.MeterProvider, error) {
+	options := []sdkmetric.Option{
 		sdkmetric.WithResource(res),
 		// Define custom histogram buckets for latency metrics
 		sdkmetric.WithView(
@@ -265,46 +371,93 @@ func newMeterProvider(ctx context.Context, cfg *config.Config, res *resource.Res
 				},
 			),
 		),
-	)
+	}
+
+	if cfg.PrometheusEnabled {
+		reader, err := newPrometheusReader()
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, sdkmetric.WithReader(reader))
+	}
+	if azureMonitor != nil {
+		options = append(options, sdkmetric.WithReader(
+			sdkmetric.NewPeriodicReader(&azureMonitorMetricExporter{client: azureMonitor},
+				sdkmetric.WithInterval(azureMonitorMetricInterval),
+			),
+		))
+	}
+	if otlp == nil {
+		return sdkmetric.NewMeterProvider(options...), nil
+	}
+
+	endpoint, err := collectorEndpoint(cfg, cfg.OTLPMetricsEndpoint, otlp.signalPath(otlpMetricsPath))
+	if err != nil {
+		log.Printf("Warning: Invalid OTLP metrics endpoint, metrics will not be exported: %v", err)
+	}
+
+	// If no OTLP endpoint configured, metrics are only served for scraping
+	if endpoint == "" {
+		if err == nil {
+			log.Println("Warning: No OTLP metrics endpoint configured, metrics will not be exported")
+		}
+		return sdkmetric.NewMeterProvider(options...), nil
+	}
 
-	return mp, nil
+	// Create OTLP metric exporter over HTTP or gRPC
+	metricExporter, err := otlp.newMetricExporter(ctx, endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create metric exporter: %w", err)
+	}
+
+	// Add a periodic reader alongside the Prometheus one
+	options = append(options, sdkmetric.WithReader(
+		sdkmetric.NewPeriodicReader(metricExporter,
+			sdkmetric.WithInterval(15*time.Second), // Export every 15 seconds
+		),
+	))
+	return sdkmetric.NewMeterProvider(options...), nil
 }
 
-// newLogProvider creates a log provider with OTLP HTTP exporter
-func newLogProvider(ctx context.Context, cfg *config.Config, res *resource.Resource) (*sdklog.LoggerProvider, error) {
-	// If no OTLP endpoint configured, return nil (logs won't be exported)
-	if cfg.OTLPLogsEndpoint == "" {
-		log.Println("Warning: No OTLP logs endpoint configured, logs will not be exported")
-		return nil, nil
+// newLogProvider creates a log provider with an OTLP exporter and, when
+// TELEMETRY_EXPORTER says so, an Azure Monitor one. It returns nil when logs go nowhere.
+func newLogProvider(ctx context.Context, cfg *config.Config, res *resource.Resource, otlp *otlpTransport, azureMonitor *azureMonitorClient) (*sdklog.LoggerProvider, error) {
+	options := []sdklog.LoggerProviderOption{sdklog.WithResource(res)}
+	if azureMonitor != nil {
+		options = append(options, sdklog.WithProcessor(sdklog.NewBatchProcessor(&azureMonitorLogExporter{client: azureMonitor})))
+	}
+	if otlp == nil {
+		if azureMonitor == nil {
+			return nil, nil
+		}
+		return sdklog.NewLoggerProvider(options...), nil
 	}
 
-	// Parse endpoint to extract host:port and path
-	// Azure Monitor injects complete URL like "http://10.0.2.62:28331/v1/logs"
-	// But Go OTLP HTTP exporter WithEndpoint() expects just "host:port" and WithURLPath() for path
-	parsedURL, err := url.Parse(cfg.OTLPLogsEndpoint)
+	endpoint, err := collectorEndpoint(cfg, cfg.OTLPLogsEndpoint, otlp.signalPath(otlpLogsPath))
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse logs endpoint: %w", err)
+		log.Printf("Warning: Invalid OTLP logs endpoint, logs will not be exported: %v", err)
 	}
 
-	// Create OTLP HTTP log exporter
-	logExporter, err := otlploghttp.New(
-		ctx,
-		otlploghttp.WithEndpoint(parsedURL.Host),  // Just host:port
-		otlploghttp.WithURLPath(parsedURL.Path),   // Explicit path
-		otlploghttp.WithInsecure(),
-		otlploghttp.WithCompression(otlploghttp.GzipCompression),
-	)
+	// If no OTLP endpoint configured, logs only go to Azure Monitor, if anywhere
+	if endpoint == "" {
+		if err == nil {
+			log.Println("Warning: No OTLP logs endpoint configured, logs will not be exported")
+		}
+		if azureMonitor == nil {
+			return nil, nil
+		}
+		return sdklog.NewLoggerProvider(options...), nil
+	}
+
+	// Create OTLP log exporter over HTTP or gRPC
+	logExporter, err := otlp.newLogExporter(ctx, endpoint)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create log exporter: %w", err)
 	}
 
 	// Create log provider with batch processor
-	lp := sdklog.NewLoggerProvider(
-		sdklog.WithProcessor(sdklog.NewBatchProcessor(logExporter)),
-		sdklog.WithResource(res),
-	)
-
-	return lp, nil
+	options = append(options, sdklog.WithProcessor(sdklog.NewBatchProcessor(logExporter)))
+	return sdklog.NewLoggerProvider(options...), nil
 }
 
 func initMetrics() error {
@@ -378,157 +531,1643 @@ func initMetrics() error {
 		return fmt.Errorf("failed to create websocket_messages_errors counter: %w", err)
 	}
 
-	// === Histograms ===
-	
-	NotificationDeliveryHist, err = Meter.Float64Histogram(
-		"notification.delivery.duration",
-		metric.WithDescription("Notification delivery duration from creation to completion"),
-		metric.WithUnit("s"),
+	EventHubPublishErrors, err = Meter.Int64Counter(
+		"eventhub.publish.errors.total",
+		metric.WithDescription("Total number of Event Hub batches that failed to publish"),
+		metric.WithUnit("{error}"),
 	)
 	if err != nil {
-		return fmt.Errorf("failed to create notification_delivery_duration histogram: %w", err)
+		return fmt.Errorf("failed to create eventhub_publish_errors counter: %w", err)
 	}
 
-	EventProcessingDuration, err = Meter.Float64Histogram(
-		"event.processing.duration",
-		metric.WithDescription("Event Hub message processing duration"),
-		metric.WithUnit("s"),
+	EventHubFailoverCounter, err = Meter.Int64Counter(
+		"eventhub.failover.total",
+		metric.WithDescription("Total number of Event Hub namespace failover and failback switches"),
+		metric.WithUnit("{switch}"),
 	)
 	if err != nil {
-		return fmt.Errorf("failed to create event_processing_duration histogram: %w", err)
+		return fmt.Errorf("failed to create eventhub_failover counter: %w", err)
 	}
 
-	EventHubConsumeDuration, err = Meter.Float64Histogram(
-		"eventhub.consume.duration",
-		metric.WithDescription("Time to consume messages from Event Hub partition"),
-		metric.WithUnit("s"),
+	EventHubEventsDropped, err = Meter.Int64Counter(
+		"eventhub.events.dropped.total",
+		metric.WithDescription("Total number of outbound Event Hub events dropped without being sent"),
+		metric.WithUnit("{event}"),
 	)
 	if err != nil {
-		return fmt.Errorf("failed to create eventhub_consume_duration histogram: %w", err)
+		return fmt.Errorf("failed to create eventhub_events_dropped counter: %w", err)
 	}
 
-	WebSocketDeliveryDuration, err = Meter.Float64Histogram(
-		"websocket.delivery.duration",
-		metric.WithDescription("WebSocket message delivery duration"),
-		metric.WithUnit("s"),
+	RedisBufferDropped, err = Meter.Int64Counter(
+		"redis.buffer.dropped.total",
+		metric.WithDescription("Total number of writes rejected because the Redis write-behind buffer was full"),
+		metric.WithUnit("{write}"),
 	)
 	if err != nil {
-		return fmt.Errorf("failed to create websocket_delivery_duration histogram: %w", err)
+		return fmt.Errorf("failed to create redis_buffer_dropped counter: %w", err)
 	}
 
-	// === UpDownCounters ===
-	
-	ActiveWebSocketConnections, err = Meter.Int64UpDownCounter(
-		"websocket.connections.active",
-		metric.WithDescription("Number of active WebSocket connections"),
-		metric.WithUnit("{connection}"),
+	TemplateEventsPublished, err = Meter.Int64Counter(
+		"template.events.published.total",
+		metric.WithDescription("Total number of template change events delivered to external subscribers"),
+		metric.WithUnit("{event}"),
 	)
 	if err != nil {
-		return fmt.Errorf("failed to create active_websocket_connections counter: %w", err)
+		return fmt.Errorf("failed to create template_events_published counter: %w", err)
 	}
 
-	EventHubActivePartitions, err = Meter.Int64UpDownCounter(
-		"eventhub.partitions.active",
-		metric.WithDescription("Number of active Event Hub partitions being processed"),
-		metric.WithUnit("{partition}"),
+	BroadcastDecisions, err = Meter.Int64Counter(
+		"broadcast.decisions.total",
+		metric.WithDescription("Total number of approval decisions on broadcasts above the recipient threshold"),
+		metric.WithUnit("{decision}"),
 	)
 	if err != nil {
-		return fmt.Errorf("failed to create eventhub_active_partitions counter: %w", err)
+		return fmt.Errorf("failed to create broadcast_decisions counter: %w", err)
 	}
 
-	// === Observable Gauges ===
-	
-	QueueSizeGauge, err = Meter.Int64ObservableGauge(
-		"notification.queue.size",
-		metric.WithDescription("Current notification queue size"),
+	BroadcastDeliveries, err = Meter.Int64Counter(
+		"broadcast.deliveries.total",
+		metric.WithDescription("Total number of broadcast deliveries by channel and result"),
+		metric.WithUnit("{delivery}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create broadcast_deliveries counter: %w", err)
+	}
+
+	CacheLookups, err = Meter.Int64Counter(
+		"cache.lookups.total",
+		metric.WithDescription("Total number of cache lookups by cache, tier and result"),
+		metric.WithUnit("{lookup}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create cache_lookups counter: %w", err)
+	}
+
+	CacheEvictions, err = Meter.Int64Counter(
+		"cache.evictions.total",
+		metric.WithDescription("Total number of in-memory cache evictions by cache and reason"),
+		metric.WithUnit("{eviction}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create cache_evictions counter: %w", err)
+	}
+
+	SchemaValidationRejections, err = Meter.Int64Counter(
+		"notification.schema.rejections.total",
+		metric.WithDescription("Total number of notifications rejected because data or metadata failed the template schema"),
 		metric.WithUnit("{notification}"),
 	)
 	if err != nil {
-		return fmt.Errorf("failed to create notification_queue_size gauge: %w", err)
+		return fmt.Errorf("failed to create schema_validation_rejections counter: %w", err)
 	}
 
-	log.Println("✓ Custom metrics initialized successfully")
-	return nil
-}
+	FaultsInjected, err = Meter.Int64Counter(
+		"faults.injected.total",
+		metric.WithDescription("Total number of faults injected at internal fault points"),
+		metric.WithUnit("{fault}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create faults_injected counter: %w", err)
+	}
 
-// GetScope returns the current instrumentation scope
-func GetScope() instrumentation.Scope {
-	return instrumentation.Scope{
-		Name:      "notification-service",
-		Version:   "1.0.0",
-		SchemaURL: semconv.SchemaURL,
+	FaultsWouldInject, err = Meter.Int64Counter(
+		"faults.would_inject.total",
+		metric.WithDescription("Total number of faults a failure injection dry run held back"),
+		metric.WithUnit("{fault}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create faults_would_inject counter: %w", err)
 	}
-}
 
-// RecordNotificationSent records a successful notification delivery
-func RecordNotificationSent(ctx context.Context, notificationType string, channel string) {
-	if NotificationsSentCounter != nil {
-		NotificationsSentCounter.Add(ctx, 1,
-			metric.WithAttributes(
-				attribute.String("notification.type", notificationType),
-				attribute.String("notification.channel", channel),
-			),
-		)
+	NotificationCancellations, err = Meter.Int64Counter(
+		"notifications.cancellations.total",
+		metric.WithDescription("Total number of notification cancellation requests by outcome"),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create notification_cancellations counter: %w", err)
 	}
-}
 
-// RecordNotificationError records a notification delivery error
-func RecordNotificationError(ctx context.Context, notificationType string, channel string, errorType string) {
-	if NotificationErrorsCounter != nil {
-		NotificationErrorsCounter.Add(ctx, 1,
-			metric.WithAttributes(
-				attribute.String("notification.type", notificationType),
-				attribute.String("notification.channel", channel),
-				attribute.String("error.type", errorType),
-			),
-		)
+	IdempotentReplays, err = Meter.Int64Counter(
+		"notifications.idempotent_replays.total",
+		metric.WithDescription("Total number of creates answered with the notification their idempotency key created"),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create idempotent_replays counter: %w", err)
 	}
-}
 
-// RecordEventHubMessage records Event Hub message metrics
-func RecordEventHubMessage(ctx context.Context, partitionID string, eventType string, success bool, duration float64) {
-	attrs := []attribute.KeyValue{
-		attribute.String("eventhub.partition_id", partitionID),
-		attribute.String("event.type", eventType),
+	RateLimitHits, err = Meter.Int64Counter(
+		"http.server.rate_limit.hits.total",
+		metric.WithDescription("Total number of requests rejected by the rate limiter"),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create rate_limit_hits counter: %w", err)
 	}
 
-	if EventHubMessagesReceived != nil {
-		EventHubMessagesReceived.Add(ctx, 1, metric.WithAttributes(attrs...))
+	NotificationRedrives, err = Meter.Int64Counter(
+		"notifications.redrives.total",
+		metric.WithDescription("Total number of failed notifications handled by bulk re-drive jobs"),
+		metric.WithUnit("{notification}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create notification_redrives counter: %w", err)
 	}
 
-	if success {
-		if EventHubMessagesProcessed != nil {
-			EventHubMessagesProcessed.Add(ctx, 1, metric.WithAttributes(attrs...))
-		}
-	} else {
-		if EventHubProcessingErrors != nil {
-			EventHubProcessingErrors.Add(ctx, 1, metric.WithAttributes(attrs...))
-		}
+	NotificationsCreated, err = Meter.Int64Counter(
+		"notifications.created.total",
+		metric.WithDescription("Total number of notifications created, with indexed metadata keys as attributes"),
+		metric.WithUnit("{notification}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create notifications_created counter: %w", err)
 	}
 
-	if EventProcessingDuration != nil && duration > 0 {
-		EventProcessingDuration.Record(ctx, duration, metric.WithAttributes(attrs...))
+	PresenceChanges, err = Meter.Int64Counter(
+		"websocket.presence.changes.total",
+		metric.WithDescription("Total number of customers coming online or going offline across replicas"),
+		metric.WithUnit("{change}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create presence_changes counter: %w", err)
 	}
-}
 
-// RecordWebSocketMessage records WebSocket message metrics
-func RecordWebSocketMessage(ctx context.Context, customerID string, messageType string, success bool, duration float64) {
-	attrs := []attribute.KeyValue{
-		attribute.String("message.type", messageType),
-		attribute.Bool("delivery.success", success),
+	RoutingOutcomes, err = Meter.Int64Counter(
+		"notifications.routing.outcomes.total",
+		metric.WithDescription("Total number of routing policy decisions by outcome"),
+		metric.WithUnit("{decision}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create routing_outcomes counter: %w", err)
 	}
 
-	if success {
-		if WebSocketMessagesSent != nil {
-			WebSocketMessagesSent.Add(ctx, 1, metric.WithAttributes(attrs...))
-		}
-	} else {
-		if WebSocketMessagesErrors != nil {
-			WebSocketMessagesErrors.Add(ctx, 1, metric.WithAttributes(attrs...))
-		}
+	WebSocketCoalesced, err = Meter.Int64Counter(
+		"websocket.messages.coalesced.total",
+		metric.WithDescription("Total number of WebSocket updates merged into a later message by the coalescing window"),
+		metric.WithUnit("{message}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create websocket_coalesced counter: %w", err)
 	}
 
-	if WebSocketDeliveryDuration != nil && duration > 0 {
-		WebSocketDeliveryDuration.Record(ctx, duration, metric.WithAttributes(attrs...))
+	WebSocketResumes, err = Meter.Int64Counter(
+		"websocket.resumes.total",
+		metric.WithDescription("Total number of WebSocket reconnects presenting a resume token"),
+		metric.WithUnit("{resume}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create websocket_resumes counter: %w", err)
+	}
+
+	WebSocketReplayed, err = Meter.Int64Counter(
+		"websocket.messages.replayed.total",
+		metric.WithDescription("Total number of buffered WebSocket messages replayed to resuming clients"),
+		metric.WithUnit("{message}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create websocket_replayed counter: %w", err)
+	}
+
+	EngagementEvents, err = Meter.Int64Counter(
+		"notification.engagement.events.total",
+		metric.WithDescription("Total number of engagement events (opens, clicks, acks, reads, snoozes) recorded"),
+		metric.WithUnit("{event}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create engagement_events counter: %w", err)
+	}
+
+	DeliveryRetries, err = Meter.Int64Counter(
+		"notification.delivery.failures.total",
+		metric.WithDescription("Total number of failed provider delivery attempts by error class and whether they were retried"),
+		metric.WithUnit("{attempt}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create delivery_retries counter: %w", err)
+	}
+
+	ProviderThrottles, err = Meter.Int64Counter(
+		"notification.provider.throttles.total",
+		metric.WithDescription("Total number of times a provider's throttling held its deliveries"),
+		metric.WithUnit("{throttle}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create provider_throttles counter: %w", err)
+	}
+
+	ContentScreenings, err = Meter.Int64Counter(
+		"notification.screening.decisions.total",
+		metric.WithDescription("Total number of content screening decisions by outcome"),
+		metric.WithUnit("{decision}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create content_screenings counter: %w", err)
+	}
+
+	LanguageDetections, err = Meter.Int64Counter(
+		"notification.language.detections.total",
+		metric.WithDescription("Total number of content language detections by outcome"),
+		metric.WithUnit("{detection}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create language_detections counter: %w", err)
+	}
+
+	SendTimeAssignments, err = Meter.Int64Counter(
+		"notification.send_time.assignments.total",
+		metric.WithDescription("Total number of notifications assigned to a send-time variant"),
+		metric.WithUnit("{notification}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create send_time_assignments counter: %w", err)
+	}
+
+	SendTimeEngagements, err = Meter.Int64Counter(
+		"notification.send_time.engagements.total",
+		metric.WithDescription("Total number of first engagements per type with notifications in a send-time variant"),
+		metric.WithUnit("{engagement}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create send_time_engagements counter: %w", err)
+	}
+
+	HTTPServerRequests, err = Meter.Int64Counter(
+		"http.server.requests.total",
+		metric.WithDescription("Total number of HTTP requests served by route, method and status"),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create http_server_requests counter: %w", err)
+	}
+
+	TenantStarvations, err = Meter.Int64Counter(
+		"notification.fairness.starvations.total",
+		metric.WithDescription("Deliveries that queued behind other tenants for longer than the starvation threshold"),
+		metric.WithUnit("{delivery}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create tenant_starvations counter: %w", err)
 	}
+
+	ProxyFailures, err = Meter.Int64Counter(
+		"notification.proxy.failures.total",
+		metric.WithDescription("Provider connections that failed at the egress proxy"),
+		metric.WithUnit("{failure}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create proxy_failures counter: %w", err)
+	}
+
+	DeadLetters, err = Meter.Int64Counter(
+		"notification.dead_letters.total",
+		metric.WithDescription("Notifications moved to the dead-letter queue"),
+		metric.WithUnit("{notification}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create dead_letters counter: %w", err)
+	}
+
+	DeadLetterRedrives, err = Meter.Int64Counter(
+		"notification.dead_letter.redrives.total",
+		metric.WithDescription("Re-drives of dead-lettered notifications by outcome"),
+		metric.WithUnit("{redrive}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create dead_letter_redrives counter: %w", err)
+	}
+
+	RetriesScheduled, err = Meter.Int64Counter(
+		"notification.retries.scheduled.total",
+		metric.WithDescription("Retries of stored notifications scheduled with backoff"),
+		metric.WithUnit("{retry}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create retries_scheduled counter: %w", err)
+	}
+
+	CollectorConnectFailures, err = Meter.Int64Counter(
+		"otel.exporter.connection.failures.total",
+		metric.WithDescription("Failed connections from the OTLP exporters to a sidecar collector"),
+		metric.WithUnit("{connection}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create collector_connect_failures counter: %w", err)
+	}
+
+	CollectorReconnects, err = Meter.Int64Counter(
+		"otel.exporter.reconnections.total",
+		metric.WithDescription("Times the OTLP exporters reconnected to a sidecar collector after losing it"),
+		metric.WithUnit("{reconnection}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create collector_reconnects counter: %w", err)
+	}
+
+	NotificationsSuppressed, err = Meter.Int64Counter(
+		"notifications.suppressed.total",
+		metric.WithDescription("Notifications not sent because of the customer's preferences"),
+		metric.WithUnit("{notification}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create notifications_suppressed counter: %w", err)
+	}
+
+	NotificationsDeferred, err = Meter.Int64Counter(
+		"notifications.deferred.total",
+		metric.WithDescription("Notifications held until the end of the customer's quiet hours"),
+		metric.WithUnit("{notification}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create notifications_deferred counter: %w", err)
+	}
+
+	DeprecatedRequests, err = Meter.Int64Counter(
+		"http.deprecated_requests.total",
+		metric.WithDescription("Total number of calls to deprecated endpoints by route and caller"),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create http_deprecated_requests counter: %w", err)
+	}
+
+	TestSends, err = Meter.Int64Counter(
+		"notifications.test_sends.total",
+		metric.WithDescription("Total number of operator test notifications by channel and result"),
+		metric.WithUnit("{notification}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create notifications_test_sends counter: %w", err)
+	}
+
+	StatusTransitions, err = Meter.Int64Counter(
+		"notifications.status_transitions.total",
+		metric.WithDescription("Total number of notification status changes by previous and new status"),
+		metric.WithUnit("{transition}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create notifications_status_transitions counter: %w", err)
+	}
+
+	NotificationReplacements, err = Meter.Int64Counter(
+		"notifications.replaced.total",
+		metric.WithDescription("Total number of notifications replaced by a newer one with the same collapse key"),
+		metric.WithUnit("{notification}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create notifications_replaced counter: %w", err)
+	}
+
+	NotificationPersistFailures, err = Meter.Int64Counter(
+		"notifications.persist_failures.total",
+		metric.WithDescription("Total number of notification changes that failed to be written through to the database"),
+		metric.WithUnit("{notification}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create notifications_persist_failures counter: %w", err)
+	}
+
+	PushSends, err = Meter.Int64Counter(
+		"push.sends.total",
+		metric.WithDescription("Total number of push sends by mode, silent or alert, and outcome"),
+		metric.WithUnit("{notification}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create push_sends counter: %w", err)
+	}
+
+	SMSKeywords, err = Meter.Int64Counter(
+		"sms.keywords.total",
+		metric.WithDescription("Inbound SMS keywords processed: opt-outs, opt-ins and help requests"),
+		metric.WithUnit("{message}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create sms_keywords counter: %w", err)
+	}
+
+	BlackoutDeferrals, err = Meter.Int64Counter(
+		"notifications.blackout_deferred.total",
+		metric.WithDescription("Notifications held until the end of their tenant's blackout window"),
+		metric.WithUnit("{notification}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create blackout_deferrals counter: %w", err)
+	}
+
+	EmailReplies, err = Meter.Int64Counter(
+		"email.replies.total",
+		metric.WithDescription("Inbound emails received, by how they were matched to the notification they reply to"),
+		metric.WithUnit("{message}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create email_replies counter: %w", err)
+	}
+
+	NotificationsBatched, err = Meter.Int64Counter(
+		"notifications.batched.total",
+		metric.WithDescription("Low-priority notifications held for their customer's digest"),
+		metric.WithUnit("{notification}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create notifications_batched counter: %w", err)
+	}
+
+	Translations, err = Meter.Int64Counter(
+		"template.translations.total",
+		metric.WithDescription("Templates machine-translated into a language, by outcome; cached translations aren't counted"),
+		metric.WithUnit("{translation}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create translations counter: %w", err)
+	}
+
+	DuplicateEvents, err = Meter.Int64Counter(
+		"events.duplicates.total",
+		metric.WithDescription("Order events skipped because a region already handled them"),
+		metric.WithUnit("{event}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create duplicate events counter: %w", err)
+	}
+
+	PreferenceConflicts, err = Meter.Int64Counter(
+		"preferences.conflicts.total",
+		metric.WithDescription("Concurrent preference writes from different regions merged on read"),
+		metric.WithUnit("{conflict}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create preference conflicts counter: %w", err)
+	}
+
+	ProviderSends, err = Meter.Int64Counter(
+		"notification.provider.sends.total",
+		metric.WithDescription("Sends routed to each provider of a channel, by outcome"),
+		metric.WithUnit("{send}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create provider sends counter: %w", err)
+	}
+
+	CircuitTransitions, err = Meter.Int64Counter(
+		"notification.provider.circuit.transitions.total",
+		metric.WithDescription("Provider circuits opening, half-opening for a probe and closing again"),
+		metric.WithUnit("{transition}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create circuit transitions counter: %w", err)
+	}
+
+	// === Histograms ===
+	
+	NotificationDeliveryHist, err = Meter.Float64Histogram(
+		"notification.delivery.duration",
+		metric.WithDescription("Notification delivery duration from creation to completion"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create notification_delivery_duration histogram: %w", err)
+	}
+
+	EventProcessingDuration, err = Meter.Float64Histogram(
+		"event.processing.duration",
+		metric.WithDescription("Event Hub message processing duration"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create event_processing_duration histogram: %w", err)
+	}
+
+	EventHubConsumeDuration, err = Meter.Float64Histogram(
+		"eventhub.consume.duration",
+		metric.WithDescription("Time to consume messages from Event Hub partition"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create eventhub_consume_duration histogram: %w", err)
+	}
+
+	WebSocketDeliveryDuration, err = Meter.Float64Histogram(
+		"websocket.delivery.duration",
+		metric.WithDescription("WebSocket message delivery duration"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create websocket_delivery_duration histogram: %w", err)
+	}
+
+	EventHubPublishDuration, err = Meter.Float64Histogram(
+		"eventhub.publish.duration",
+		metric.WithDescription("Event Hub batch publish latency including throttling retries"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create eventhub_publish_duration histogram: %w", err)
+	}
+
+	EventHubPublishBatchSize, err = Meter.Int64Histogram(
+		"eventhub.publish.batch.size",
+		metric.WithDescription("Number of events per published Event Hub batch"),
+		metric.WithUnit("{event}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create eventhub_publish_batch_size histogram: %w", err)
+	}
+
+	ProviderThrottleWait, err = Meter.Float64Histogram(
+		"notification.provider.throttle.wait",
+		metric.WithDescription("Time deliveries waited for a throttled provider"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create provider_throttle_wait histogram: %w", err)
+	}
+
+	SendTimeDelay, err = Meter.Float64Histogram(
+		"notification.send_time.delay",
+		metric.WithDescription("How long send-time optimization held notifications back"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create send_time_delay histogram: %w", err)
+	}
+
+	HTTPServerDuration, err = Meter.Float64Histogram(
+		"http.server.request.duration",
+		metric.WithDescription("Duration of HTTP requests by route, method and status"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(0.005, 0.01, 0.025, 0.05, 0.075, 0.1, 0.25, 0.5, 0.75, 1, 2.5, 5, 7.5, 10),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create http_server_duration histogram: %w", err)
+	}
+
+	TenantQueueWait, err = Meter.Float64Histogram(
+		"notification.fairness.queue.wait",
+		metric.WithDescription("Time deliveries waited in the tenant fair queue for a provider slot"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create tenant_queue_wait histogram: %w", err)
+	}
+
+	NotificationRetryCount, err = Meter.Int64Histogram(
+		"notification.retry.count",
+		metric.WithDescription("Retries a notification took before it was sent or failed"),
+		metric.WithUnit("{retry}"),
+		metric.WithExplicitBucketBoundaries(0, 1, 2, 3, 5, 8, 13, 20),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create notification_retry_count histogram: %w", err)
+	}
+
+	CustomerDigestSize, err = Meter.Int64Histogram(
+		"customer_digest.size",
+		metric.WithDescription("Notifications per customer digest flushed, by channel and whether it was sent"),
+		metric.WithUnit("{notification}"),
+		metric.WithExplicitBucketBoundaries(1, 2, 5, 10, 20, 50, 100, 500),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create customer_digest_size histogram: %w", err)
+	}
+
+	// === UpDownCounters ===
+	
+	ActiveWebSocketConnections, err = Meter.Int64UpDownCounter(
+		"websocket.connections.active",
+		metric.WithDescription("Number of active WebSocket connections"),
+		metric.WithUnit("{connection}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create active_websocket_connections counter: %w", err)
+	}
+
+	EventHubActivePartitions, err = Meter.Int64UpDownCounter(
+		"eventhub.partitions.active",
+		metric.WithDescription("Number of active Event Hub partitions being processed"),
+		metric.WithUnit("{partition}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create eventhub_active_partitions counter: %w", err)
+	}
+
+	HTTPServerActiveRequests, err = Meter.Int64UpDownCounter(
+		"http.server.active_requests",
+		metric.WithDescription("Number of HTTP requests in flight"),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create http_server_active_requests counter: %w", err)
+	}
+
+	TenantQueueDepth, err = Meter.Int64UpDownCounter(
+		"notification.fairness.queued",
+		metric.WithDescription("Deliveries waiting in the tenant fair queue"),
+		metric.WithUnit("{delivery}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create tenant_queue_depth counter: %w", err)
+	}
+
+	RedisBufferDepth, err = Meter.Int64UpDownCounter(
+		"redis.buffer.depth",
+		metric.WithDescription("Number of writes buffered while Redis is unavailable"),
+		metric.WithUnit("{write}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create redis_buffer_depth counter: %w", err)
+	}
+
+	// === Observable Gauges ===
+	
+	QueueSizeGauge, err = Meter.Int64ObservableGauge(
+		"notification.queue.size",
+		metric.WithDescription("Pending notifications due now, by priority"),
+		metric.WithUnit("{notification}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create notification_queue_size gauge: %w", err)
+	}
+
+	ScheduledQueueGauge, err = Meter.Int64ObservableGauge(
+		"notification.queue.scheduled",
+		metric.WithDescription("Pending notifications scheduled for later, by priority"),
+		metric.WithUnit("{notification}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create notification_queue_scheduled gauge: %w", err)
+	}
+
+	RetryingQueueGauge, err = Meter.Int64ObservableGauge(
+		"notification.queue.retrying",
+		metric.WithDescription("Notifications waiting for a retry, by priority"),
+		metric.WithUnit("{notification}"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create notification_queue_retrying gauge: %w", err)
+	}
+
+	RegisteredDevicesGauge, err = Meter.Int64ObservableGauge(
+		"devices.registered",
+		metric.WithDescription("Current number of registered push devices by platform"),
+		metric.WithUnit("{device}"),
+		metric.WithInt64Callback(func(_ context.Context, observer metric.Int64Observer) error {
+			counts := deviceCounts.Load()
+			if counts == nil {
+				return nil
+			}
+			for platform, count := range *counts {
+				observer.Observe(count, metric.WithAttributes(attribute.String("device.platform", platform)))
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create devices_registered gauge: %w", err)
+	}
+
+	ProviderWeightGauge, err = Meter.Float64ObservableGauge(
+		"notification.provider.routing.weight",
+		metric.WithDescription("Share of a channel's new sends routed to each provider"),
+		metric.WithUnit("1"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create provider_routing_weight gauge: %w", err)
+	}
+
+	ProviderThrottleGauge, err = Meter.Float64ObservableGauge(
+		"notification.provider.throttle.remaining",
+		metric.WithDescription("Time left on each provider's throttle, 0 while it isn't throttled"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create provider_throttle_remaining gauge: %w", err)
+	}
+
+	log.Println("✓ Custom metrics initialized successfully")
+	return nil
+}
+
+// GetScope returns the current instrumentation scope
+func GetScope() instrumentation.Scope {
+	return instrumentation.Scope{
+		Name:      "notification-service",
+		Version:   "1.0.0",
+		SchemaURL: semconv.SchemaURL,
+	}
+}
+
+// RecordNotificationSent records a successful notification delivery
+func RecordNotificationSent(ctx context.Context, notificationType string, channel string) {
+	if NotificationsSentCounter != nil {
+		NotificationsSentCounter.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("notification.type", notificationType),
+				attribute.String("notification.channel", channel),
+			),
+		)
+	}
+}
+
+// RecordNotificationError records a notification delivery error
+func RecordNotificationError(ctx context.Context, notificationType string, channel string, errorType string) {
+	if NotificationErrorsCounter != nil {
+		NotificationErrorsCounter.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("notification.type", notificationType),
+				attribute.String("notification.channel", channel),
+				attribute.String("error.type", errorType),
+			),
+		)
+	}
+}
+
+// RecordEventHubMessage records Event Hub message metrics
+func RecordEventHubMessage(ctx context.Context, partitionID string, eventType string, success bool, duration float64) {
+	attrs := []attribute.KeyValue{
+		attribute.String("eventhub.partition_id", partitionID),
+		attribute.String("event.type", eventType),
+	}
+
+	if EventHubMessagesReceived != nil {
+		EventHubMessagesReceived.Add(ctx, 1, metric.WithAttributes(attrs...))
+	}
+
+	if success {
+		if EventHubMessagesProcessed != nil {
+			EventHubMessagesProcessed.Add(ctx, 1, metric.WithAttributes(attrs...))
+		}
+	} else {
+		if EventHubProcessingErrors != nil {
+			EventHubProcessingErrors.Add(ctx, 1, metric.WithAttributes(attrs...))
+		}
+	}
+
+	if EventProcessingDuration != nil && duration > 0 {
+		EventProcessingDuration.Record(ctx, duration, metric.WithAttributes(attrs...))
+	}
+}
+
+// RecordWebSocketMessage records WebSocket message metrics
+func RecordWebSocketMessage(ctx context.Context, customerID string, messageType string, success bool, duration float64) {
+	attrs := []attribute.KeyValue{
+		attribute.String("message.type", messageType),
+		attribute.Bool("delivery.success", success),
+	}
+
+	if success {
+		if WebSocketMessagesSent != nil {
+			WebSocketMessagesSent.Add(ctx, 1, metric.WithAttributes(attrs...))
+		}
+	} else {
+		if WebSocketMessagesErrors != nil {
+			WebSocketMessagesErrors.Add(ctx, 1, metric.WithAttributes(attrs...))
+		}
+	}
+
+	if WebSocketDeliveryDuration != nil && duration > 0 {
+		WebSocketDeliveryDuration.Record(ctx, duration, metric.WithAttributes(attrs...))
+	}
+}
+
+// RecordEventHubPublish records metrics for an outbound Event Hub batch
+func RecordEventHubPublish(ctx context.Context, eventHubName string, batchSize int, success bool, duration float64) {
+	attrs := []attribute.KeyValue{
+		attribute.String("eventhub.name", eventHubName),
+		attribute.Bool("publish.success", success),
+	}
+
+	if EventHubPublishBatchSize != nil {
+		EventHubPublishBatchSize.Record(ctx, int64(batchSize), metric.WithAttributes(attrs...))
+	}
+
+	if EventHubPublishDuration != nil && duration > 0 {
+		EventHubPublishDuration.Record(ctx, duration, metric.WithAttributes(attrs...))
+	}
+
+	if !success && EventHubPublishErrors != nil {
+		EventHubPublishErrors.Add(ctx, 1, metric.WithAttributes(attrs...))
+	}
+}
+
+// RecordEventHubEventDrop records an outbound event dropped unsent: too_large for any
+// batch, or buffer_full while the producer's buffer is at capacity
+func RecordEventHubEventDrop(ctx context.Context, eventHubName string, reason string) {
+	if EventHubEventsDropped != nil {
+		EventHubEventsDropped.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("eventhub.name", eventHubName),
+				attribute.String("drop.reason", reason),
+			),
+		)
+	}
+}
+
+// RecordEventHubFailover records a switch between Event Hub namespaces
+func RecordEventHubFailover(ctx context.Context, client string, from string, to string) {
+	if EventHubFailoverCounter != nil {
+		EventHubFailoverCounter.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("eventhub.client", client),
+				attribute.String("failover.from", from),
+				attribute.String("failover.to", to),
+			),
+		)
+	}
+}
+
+// RecordRedisBufferDepthChange tracks writes entering and leaving the Redis write-behind buffer
+func RecordRedisBufferDepthChange(ctx context.Context, delta int64) {
+	if RedisBufferDepth != nil {
+		RedisBufferDepth.Add(withoutExemplar(ctx), delta)
+	}
+}
+
+// RecordRedisBufferDrop records a write rejected because the write-behind buffer was full
+func RecordRedisBufferDrop(ctx context.Context) {
+	if RedisBufferDropped != nil {
+		RedisBufferDropped.Add(ctx, 1)
+	}
+}
+
+// RecordTemplateEvent records delivery of a template change event to the configured subscribers
+func RecordTemplateEvent(ctx context.Context, eventType string, success bool) {
+	if TemplateEventsPublished != nil {
+		TemplateEventsPublished.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("event.type", eventType),
+				attribute.Bool("success", success),
+			),
+		)
+	}
+}
+
+// RecordBroadcastDecision records an approve or reject decision on a held broadcast
+func RecordBroadcastDecision(ctx context.Context, decision string) {
+	if BroadcastDecisions != nil {
+		BroadcastDecisions.Add(ctx, 1, metric.WithAttributes(attribute.String("decision", decision)))
+	}
+}
+
+// RecordCacheLookup records a hit or miss on one tier of a named cache
+func RecordCacheLookup(ctx context.Context, cache string, tier string, hit bool) {
+	if CacheLookups != nil {
+		result := "miss"
+		if hit {
+			result = "hit"
+		}
+		CacheLookups.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("cache.name", cache),
+				attribute.String("cache.tier", tier),
+				attribute.String("cache.result", result),
+			),
+		)
+	}
+}
+
+// RecordCacheEviction records an entry evicted from a named cache's in-memory tier
+func RecordCacheEviction(ctx context.Context, cache string, reason string) {
+	if CacheEvictions != nil {
+		CacheEvictions.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("cache.name", cache),
+				attribute.String("eviction.reason", reason),
+			),
+		)
+	}
+}
+
+// RecordSchemaRejection records a notification field failing its template's schema
+func RecordSchemaRejection(ctx context.Context, templateID string, field string) {
+	if SchemaValidationRejections != nil {
+		SchemaValidationRejections.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("template.id", templateID),
+				attribute.String("schema.field", field),
+			),
+		)
+	}
+}
+
+// RecordFaultInjected records a fault injected at an internal fault point, labelled with
+// the chaos experiment that caused it when one is running
+func RecordFaultInjected(ctx context.Context, operation string, faultType string, experimentID string) {
+	if FaultsInjected != nil {
+		attrs := []attribute.KeyValue{
+			attribute.String("fault.operation", operation),
+			attribute.String("fault.type", faultType),
+		}
+		if experimentID != "" {
+			attrs = append(attrs, attribute.String("chaos.experiment.id", experimentID))
+		}
+		FaultsInjected.Add(ctx, 1, metric.WithAttributes(attrs...))
+	}
+}
+
+// RecordFaultWouldInject records a fault that a dry run evaluated as firing but did not
+// inject
+func RecordFaultWouldInject(ctx context.Context, operation string, faultType string, experimentID string) {
+	if FaultsWouldInject != nil {
+		attrs := []attribute.KeyValue{
+			attribute.String("fault.operation", operation),
+			attribute.String("fault.type", faultType),
+		}
+		if experimentID != "" {
+			attrs = append(attrs, attribute.String("chaos.experiment.id", experimentID))
+		}
+		FaultsWouldInject.Add(ctx, 1, metric.WithAttributes(attrs...))
+	}
+}
+
+// RecordNotificationCancel records whether a cancellation won the race against delivery
+func RecordNotificationCancel(ctx context.Context, cancelled bool) {
+	if NotificationCancellations != nil {
+		outcome := "cancelled"
+		if !cancelled {
+			outcome = "too_late"
+		}
+		NotificationCancellations.Add(ctx, 1, metric.WithAttributes(attribute.String("outcome", outcome)))
+	}
+}
+
+// RecordIdempotentReplay records a create answered with an earlier notification instead
+// of sending again
+func RecordIdempotentReplay(ctx context.Context, notificationType string) {
+	if IdempotentReplays != nil {
+		IdempotentReplays.Add(ctx, 1, metric.WithAttributes(attribute.String("notification.type", notificationType)))
+	}
+}
+
+// RecordRateLimitHit records a request rejected with 429 because subject, "api_key" or
+// "customer", used up its allowance for the route group
+func RecordRateLimitHit(ctx context.Context, group, subject string) {
+	if RateLimitHits != nil {
+		RateLimitHits.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("ratelimit.group", group),
+			attribute.String("ratelimit.subject", subject),
+		))
+	}
+}
+
+// RecordNotificationRedrive records one notification of a bulk re-drive job: requeued,
+// skipped because it was no longer failed, or failed to requeue
+func RecordNotificationRedrive(ctx context.Context, channel, result string) {
+	if NotificationRedrives != nil {
+		NotificationRedrives.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("notification.channel", channel),
+			attribute.String("redrive.result", result),
+		))
+	}
+}
+
+// RecordNotificationCreated records a created notification with its data region
+func RecordNotificationCreated(ctx context.Context, notificationType string, region string) {
+	if NotificationsCreated != nil {
+		attrs := []attribute.KeyValue{attribute.String("notification.type", notificationType)}
+		if region != "" {
+			attrs = append(attrs, attribute.String("data.region", region))
+		}
+		NotificationsCreated.Add(ctx, 1, metric.WithAttributes(attrs...))
+	}
+}
+
+// RecordPresenceChange records a customer's cluster-wide presence flipping online or offline
+func RecordPresenceChange(ctx context.Context, online bool) {
+	if PresenceChanges != nil {
+		status := "offline"
+		if online {
+			status = "online"
+		}
+		PresenceChanges.Add(ctx, 1, metric.WithAttributes(attribute.String("presence.status", status)))
+	}
+}
+
+// RecordRoutingOutcome records how a routing policy delivered (or deferred) a notification
+func RecordRoutingOutcome(ctx context.Context, policy string, outcome string) {
+	if RoutingOutcomes != nil {
+		RoutingOutcomes.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("routing.policy", policy),
+				attribute.String("routing.outcome", outcome),
+			),
+		)
+	}
+}
+
+// RecordWebSocketCoalesced records updates that were merged into a single WebSocket message
+func RecordWebSocketCoalesced(ctx context.Context, eventType string, merged int) {
+	if WebSocketCoalesced != nil {
+		WebSocketCoalesced.Add(ctx, int64(merged), metric.WithAttributes(attribute.String("event.type", eventType)))
+	}
+}
+
+// RecordChannelDelivery records how long a provider took to accept a notification, with
+// its data region when it has one. Test sends are marked with notification.test, so
+// dashboards can leave them out.
+func RecordChannelDelivery(ctx context.Context, channel string, region string, success bool, duration float64) {
+	if NotificationDeliveryHist != nil {
+		attrs := []attribute.KeyValue{
+			attribute.String("notification.channel", channel),
+			attribute.Bool("delivery.success", success),
+		}
+		if region != "" {
+			attrs = append(attrs, attribute.String("data.region", region))
+		}
+		if IsTestSend(ctx) {
+			attrs = append(attrs, attribute.Bool("notification.test", true))
+		}
+		NotificationDeliveryHist.Record(ctx, duration, metric.WithAttributes(attrs...))
+	}
+}
+
+// RecordWebSocketResume records a resume attempt and how many messages it replayed
+func RecordWebSocketResume(ctx context.Context, success bool, replayed int) {
+	if WebSocketResumes != nil {
+		WebSocketResumes.Add(ctx, 1, metric.WithAttributes(attribute.Bool("resume.success", success)))
+	}
+	if WebSocketReplayed != nil && replayed > 0 {
+		WebSocketReplayed.Add(ctx, int64(replayed))
+	}
+}
+
+// RecordEngagementEvent records an engagement event appended to the event store
+func RecordEngagementEvent(ctx context.Context, eventType string, channel string) {
+	if EngagementEvents != nil {
+		EngagementEvents.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("engagement.type", eventType),
+				attribute.String("notification.channel", channel),
+			),
+		)
+	}
+}
+
+// RecordDeliveryRetry records a failed delivery attempt and whether the retry policy retried it
+func RecordDeliveryRetry(ctx context.Context, channel string, errorClass string, retried bool) {
+	if DeliveryRetries != nil {
+		DeliveryRetries.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("notification.channel", channel),
+				attribute.String("error.class", errorClass),
+				attribute.Bool("retry.scheduled", retried),
+			),
+		)
+	}
+}
+
+// RecordProviderThrottle records a provider throttling deliveries
+func RecordProviderThrottle(ctx context.Context, channel, provider string) {
+	if ProviderThrottles != nil {
+		ProviderThrottles.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("notification.channel", channel),
+				attribute.String("provider.name", provider),
+			),
+		)
+	}
+}
+
+// RecordProviderThrottleWait records how long a delivery waited for a throttled provider
+func RecordProviderThrottleWait(ctx context.Context, channel, provider string, seconds float64) {
+	if ProviderThrottleWait != nil {
+		ProviderThrottleWait.Record(ctx, seconds,
+			metric.WithAttributes(
+				attribute.String("notification.channel", channel),
+				attribute.String("provider.name", provider),
+			),
+		)
+	}
+}
+
+// RecordContentScreening records a content screening decision
+func RecordContentScreening(ctx context.Context, screener, action, channel string) {
+	if ContentScreenings != nil {
+		ContentScreenings.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("screening.screener", screener),
+				attribute.String("screening.action", action),
+				attribute.String("notification.channel", channel),
+			),
+		)
+	}
+}
+
+// RecordLanguageDetection records a language detection and whether its confidence was
+// high enough to use
+func RecordLanguageDetection(ctx context.Context, detector, language, outcome string) {
+	if LanguageDetections != nil {
+		LanguageDetections.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("language.detector", detector),
+				attribute.String("language.code", language),
+				attribute.String("language.outcome", outcome),
+			),
+		)
+	}
+}
+
+// RecordSendTimeAssignment records a notification's send-time variant and, for delayed
+// notifications, how long it was held back
+func RecordSendTimeAssignment(ctx context.Context, variant string, delayed bool, delaySeconds float64) {
+	attrs := metric.WithAttributes(
+		attribute.String("send_time.variant", variant),
+		attribute.Bool("send_time.delayed", delayed),
+	)
+	if SendTimeAssignments != nil {
+		SendTimeAssignments.Add(ctx, 1, attrs)
+	}
+	if delayed && SendTimeDelay != nil {
+		SendTimeDelay.Record(ctx, delaySeconds, metric.WithAttributes(attribute.String("send_time.variant", variant)))
+	}
+}
+
+// RecordSendTimeEngagement records the first engagement of a type with a notification
+// in a send-time variant
+func RecordSendTimeEngagement(ctx context.Context, variant, engagementType string) {
+	if SendTimeEngagements != nil {
+		SendTimeEngagements.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("send_time.variant", variant),
+				attribute.String("engagement.type", engagementType),
+			),
+		)
+	}
+}
+
+// RecordHTTPRequest counts a served HTTP request
+func RecordHTTPRequest(ctx context.Context, method, route string, status int) {
+	if HTTPServerRequests != nil {
+		HTTPServerRequests.Add(ctx, 1, metric.WithAttributes(httpServerAttributes(method, route, status)...))
+	}
+}
+
+// RecordHTTPRequestDuration records how long a served HTTP request took
+func RecordHTTPRequestDuration(ctx context.Context, method, route string, status int, seconds float64) {
+	if HTTPServerDuration != nil {
+		HTTPServerDuration.Record(ctx, seconds, metric.WithAttributes(httpServerAttributes(method, route, status)...))
+	}
+}
+
+// httpServerAttributes follows the HTTP semantic conventions; server errors carry
+// error.type so failure rates can be split from the request rate
+func httpServerAttributes(method, route string, status int) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("http.request.method", method),
+		attribute.String("http.route", route),
+		attribute.Int("http.response.status_code", status),
+	}
+	if status >= 500 {
+		attrs = append(attrs, attribute.String("error.type", strconv.Itoa(status)))
+	}
+	return attrs
+}
+
+// withoutExemplar detaches ctx from its span for UpDownCounter updates. Prometheus
+// exposes UpDownCounters as gauges, and an exemplar taken from a sampled span would make
+// promhttp fail the whole scrape.
+func withoutExemplar(ctx context.Context) context.Context {
+	return trace.ContextWithSpanContext(ctx, trace.SpanContext{})
+}
+
+// AddHTTPActiveRequests moves the in-flight request count for a route by delta
+func AddHTTPActiveRequests(ctx context.Context, method, route string, delta int64) {
+	if HTTPServerActiveRequests != nil {
+		HTTPServerActiveRequests.Add(withoutExemplar(ctx), delta,
+			metric.WithAttributes(
+				attribute.String("http.request.method", method),
+				attribute.String("http.route", route),
+			),
+		)
+	}
+}
+
+// RecordTenantQueueWait records how long a delivery waited for a provider slot, counting
+// it as starved when the wait passed the starvation threshold
+func RecordTenantQueueWait(ctx context.Context, channel, tier string, seconds float64, starved bool) {
+	attrs := metric.WithAttributes(
+		attribute.String("notification.channel", channel),
+		attribute.String("tenant.tier", tier),
+	)
+	if TenantQueueWait != nil {
+		TenantQueueWait.Record(ctx, seconds, attrs)
+	}
+	if starved && TenantStarvations != nil {
+		TenantStarvations.Add(ctx, 1, attrs)
+	}
+}
+
+// AddTenantQueueDepth moves the number of deliveries queued for a channel and tier by delta
+func AddTenantQueueDepth(ctx context.Context, channel, tier string, delta int64) {
+	if TenantQueueDepth != nil {
+		TenantQueueDepth.Add(withoutExemplar(ctx), delta,
+			metric.WithAttributes(
+				attribute.String("notification.channel", channel),
+				attribute.String("tenant.tier", tier),
+			),
+		)
+	}
+}
+
+// RecordProxyFailure records a provider connection failing at its egress proxy
+func RecordProxyFailure(ctx context.Context, provider, proxyScheme string) {
+	if ProxyFailures != nil {
+		ProxyFailures.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("provider", provider),
+				attribute.String("proxy.scheme", proxyScheme),
+			),
+		)
+	}
+}
+
+// RecordDeadLetter records a notification moved to the dead-letter queue, with its data
+// region when it has one
+func RecordDeadLetter(ctx context.Context, channel, reason, errorClass, region string) {
+	if DeadLetters != nil {
+		attrs := []attribute.KeyValue{
+			attribute.String("notification.channel", channel),
+			attribute.String("dead_letter.reason", reason),
+			attribute.String("error.class", errorClass),
+		}
+		if region != "" {
+			attrs = append(attrs, attribute.String("data.region", region))
+		}
+		DeadLetters.Add(ctx, 1, metric.WithAttributes(attrs...))
+	}
+}
+
+// RecordDeadLetterRedrive records a re-drive of a dead-lettered notification
+func RecordDeadLetterRedrive(ctx context.Context, channel string, success bool) {
+	if DeadLetterRedrives != nil {
+		DeadLetterRedrives.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("notification.channel", channel),
+				attribute.Bool("success", success),
+			),
+		)
+	}
+}
+
+// RecordRetryScheduled records a stored notification's retry being scheduled
+func RecordRetryScheduled(ctx context.Context, channel, priority string) {
+	if RetriesScheduled != nil {
+		RetriesScheduled.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("notification.channel", channel),
+				attribute.String("notification.priority", priority),
+			),
+		)
+	}
+}
+
+// RecordNotificationRetries records how many retries a notification took once it was
+// sent or failed for good
+func RecordNotificationRetries(ctx context.Context, channel, priority, outcome string, retries int) {
+	if NotificationRetryCount != nil {
+		NotificationRetryCount.Record(ctx, int64(retries),
+			metric.WithAttributes(
+				attribute.String("notification.channel", channel),
+				attribute.String("notification.priority", priority),
+				attribute.String("outcome", outcome),
+			),
+		)
+	}
+}
+
+// RecordCollectorConnectionFailure records an exporter connection a sidecar collector refused
+func RecordCollectorConnectionFailure(ctx context.Context, transport string) {
+	if CollectorConnectFailures != nil {
+		CollectorConnectFailures.Add(ctx, 1,
+			metric.WithAttributes(attribute.String("collector.transport", transport)),
+		)
+	}
+}
+
+// RecordCollectorReconnect records the exporters reaching a sidecar collector again
+func RecordCollectorReconnect(ctx context.Context, transport string) {
+	if CollectorReconnects != nil {
+		CollectorReconnects.Add(ctx, 1,
+			metric.WithAttributes(attribute.String("collector.transport", transport)),
+		)
+	}
+}
+
+// RecordNotificationSuppressed records a notification the customer's preferences kept
+// from being sent: channel_disabled, category_opted_out or quiet_hours
+func RecordNotificationSuppressed(ctx context.Context, channel, reason string) {
+	if NotificationsSuppressed != nil {
+		NotificationsSuppressed.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("notification.channel", channel),
+				attribute.String("suppression.reason", reason),
+			),
+		)
+	}
+}
+
+// RecordNotificationDeferred records a notification held for the customer's quiet hours
+func RecordNotificationDeferred(ctx context.Context, channel string) {
+	if NotificationsDeferred != nil {
+		NotificationsDeferred.Add(ctx, 1,
+			metric.WithAttributes(attribute.String("notification.channel", channel)),
+		)
+	}
+}
+
+// RecordBroadcastDelivery records one broadcast delivery to a recipient on a channel:
+// sent, failed or suppressed
+func RecordBroadcastDelivery(ctx context.Context, channel, result string) {
+	if BroadcastDeliveries != nil {
+		BroadcastDeliveries.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("notification.channel", channel),
+				attribute.String("broadcast.result", result),
+			),
+		)
+	}
+}
+
+// RecordDeprecatedRequest records a call to a deprecated route, so each caller's
+// migration off it can be followed
+func RecordDeprecatedRequest(ctx context.Context, method, route, caller string) {
+	if DeprecatedRequests != nil {
+		DeprecatedRequests.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("http.request.method", method),
+				attribute.String("http.route", route),
+				attribute.String("caller.id", caller),
+			),
+		)
+	}
+}
+
+// RecordTestSend records an operator test notification and whether the channel took it
+func RecordTestSend(ctx context.Context, channel string, success bool) {
+	if TestSends != nil {
+		TestSends.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("notification.channel", channel),
+				attribute.Bool("delivery.success", success),
+			),
+		)
+	}
+}
+
+// RecordStatusTransition records a notification moving from one status to another
+func RecordStatusTransition(ctx context.Context, channel, from, to string) {
+	if StatusTransitions != nil {
+		StatusTransitions.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("notification.channel", channel),
+				attribute.String("notification.status.from", from),
+				attribute.String("notification.status.to", to),
+			),
+		)
+	}
+}
+
+// RecordNotificationReplaced records a notification replaced by a newer one with the same
+// collapse key
+func RecordNotificationReplaced(ctx context.Context, channel string) {
+	if NotificationReplacements != nil {
+		NotificationReplacements.Add(ctx, 1, metric.WithAttributes(attribute.String("notification.channel", channel)))
+	}
+}
+
+// RecordNotificationPersistFailure records a notification change that failed to reach
+// the database; retried is set when it was a retry of an earlier failure
+func RecordNotificationPersistFailure(ctx context.Context, retried bool) {
+	if NotificationPersistFailures != nil {
+		NotificationPersistFailures.Add(ctx, 1, metric.WithAttributes(attribute.Bool("retried", retried)))
+	}
+}
+
+// RecordPushSend records a push send as silent (data-only) or alert, so background
+// refreshes are counted apart from notifications users see. outcome is sent, failed or
+// rate_limited.
+func RecordPushSend(ctx context.Context, silent bool, outcome string) {
+	if PushSends != nil {
+		mode := "alert"
+		if silent {
+			mode = "silent"
+		}
+		PushSends.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("push.mode", mode),
+				attribute.String("push.outcome", outcome),
+			),
+		)
+	}
+}
+
+// RecordSMSKeyword records an inbound SMS keyword: stop, start or help
+func RecordSMSKeyword(ctx context.Context, keyword string) {
+	if SMSKeywords != nil {
+		SMSKeywords.Add(ctx, 1, metric.WithAttributes(attribute.String("sms.keyword", keyword)))
+	}
+}
+
+// RecordBlackoutDeferral records a notification held by a blackout window of a kind
+// (holiday or change_freeze)
+func RecordBlackoutDeferral(ctx context.Context, channel, kind string) {
+	if BlackoutDeferrals != nil {
+		BlackoutDeferrals.Add(ctx, 1,
+			metric.WithAttributes(
+				attribute.String("notification.channel", channel),
+				attribute.String("blackout.kind", kind),
+			),
+		)
+	}
+}
+
+// RecordEmailReply records an inbound email by how it was correlated: reply_to,
+// in_reply_to or unmatched
+func RecordEmailReply(ctx context.Context, correlation string) {
+	if EmailReplies != nil {
+		EmailReplies.Add(ctx, 1, metric.WithAttributes(attribute.String("email.reply.correlation", correlation)))
+	}
+}
+
+// RecordNotificationBatched records a notification held for its customer's digest
+func RecordNotificationBatched(ctx context.Context, channel string) {
+	if NotificationsBatched != nil {
+		NotificationsBatched.Add(ctx, 1, metric.WithAttributes(attribute.String("notification.channel", channel)))
+	}
+}
+
+// RecordCustomerDigest records a flushed customer digest and how many notifications it
+// combined
+func RecordCustomerDigest(ctx context.Context, channel string, sent bool, size int) {
+	if CustomerDigestSize != nil {
+		CustomerDigestSize.Record(ctx, int64(size), metric.WithAttributes(
+			attribute.String("notification.channel", channel),
+			attribute.Bool("digest.sent", sent),
+		))
+	}
+}
+
+// RecordTranslation records a template translated into language: translated or error
+func RecordTranslation(ctx context.Context, language, outcome string) {
+	if Translations != nil {
+		Translations.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("translation.to", language),
+			attribute.String("translation.outcome", outcome),
+		))
+	}
+}
+
+// RecordDuplicateEvent records an order event skipped as one already handled, in the
+// region that handled it first
+func RecordDuplicateEvent(ctx context.Context, handledBy string) {
+	if DuplicateEvents != nil {
+		DuplicateEvents.Add(ctx, 1, metric.WithAttributes(attribute.String("event.handled_by", handledBy)))
+	}
+}
+
+// RecordPreferenceConflict records concurrent preference writes from different regions
+func RecordPreferenceConflict(ctx context.Context) {
+	if PreferenceConflicts != nil {
+		PreferenceConflicts.Add(ctx, 1)
+	}
+}
+
+// RecordProviderSend records a send routed to one of a channel's providers
+func RecordProviderSend(ctx context.Context, channel, provider string, success bool) {
+	if ProviderSends != nil {
+		outcome := "success"
+		if !success {
+			outcome = "failure"
+		}
+		ProviderSends.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("notification.channel", channel),
+			attribute.String("provider.name", provider),
+			attribute.String("provider.outcome", outcome),
+		))
+	}
+}
+
+// RecordCircuitTransition records a provider's circuit entering state
+func RecordCircuitTransition(ctx context.Context, channel, provider, state string) {
+	if CircuitTransitions != nil {
+		CircuitTransitions.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("notification.channel", channel),
+			attribute.String("provider.name", provider),
+			attribute.String("circuit.state", state),
+		))
+	}
+}
+
+// ProviderWeight is the share of a channel's sends routed to one provider
+type ProviderWeight struct {
+	Channel  string
+	Provider string
+	Weight   float64
+}
+
+// RegisterProviderWeightCallback reports the weights read returns through the routing
+// weight gauge each time metrics are collected
+func RegisterProviderWeightCallback(read func() []ProviderWeight) (metric.Registration, error) {
+	if Meter == nil || ProviderWeightGauge == nil {
+		return nil, fmt.Errorf("provider weight gauge is not initialized")
+	}
+	return Meter.RegisterCallback(func(_ context.Context, observer metric.Observer) error {
+		for _, weight := range read() {
+			observer.ObserveFloat64(ProviderWeightGauge, weight.Weight, metric.WithAttributes(
+				attribute.String("notification.channel", weight.Channel),
+				attribute.String("provider.name", weight.Provider),
+			))
+		}
+		return nil
+	}, ProviderWeightGauge)
+}
+
+// ProviderThrottleLevel is the time left on one provider's throttle
+type ProviderThrottleLevel struct {
+	Channel   string
+	Provider  string
+	Remaining time.Duration
+}
+
+// RegisterProviderThrottleCallback reports the throttles read returns through the
+// throttle gauge each time metrics are collected
+func RegisterProviderThrottleCallback(read func(ctx context.Context) []ProviderThrottleLevel) (metric.Registration, error) {
+	if Meter == nil || ProviderThrottleGauge == nil {
+		return nil, fmt.Errorf("provider throttle gauge is not initialized")
+	}
+	return Meter.RegisterCallback(func(ctx context.Context, observer metric.Observer) error {
+		for _, level := range read(ctx) {
+			observer.ObserveFloat64(ProviderThrottleGauge, level.Remaining.Seconds(), metric.WithAttributes(
+				attribute.String("notification.channel", level.Channel),
+				attribute.String("provider.name", level.Provider),
+			))
+		}
+		return nil
+	}, ProviderThrottleGauge)
+}
+
+// Queues reported by the queue gauges
+const (
+	QueuePending   = "pending"
+	QueueScheduled = "scheduled"
+	QueueRetrying  = "retrying"
+)
+
+// QueueSize is the number of notifications in one queue with one priority; an empty
+// priority is reported without the attribute
+type QueueSize struct {
+	Queue    string
+	Priority string
+	Count    int64
+}
+
+// RegisterQueueSizeCallback reports the sizes read returns through the pending, scheduled
+// and retrying queue gauges each time metrics are collected. read is called once per
+// collection for all three; a failed read reports nothing rather than zeros.
+func RegisterQueueSizeCallback(read func(ctx context.Context) ([]QueueSize, error)) (metric.Registration, error) {
+	if Meter == nil || QueueSizeGauge == nil {
+		return nil, fmt.Errorf("queue gauges are not initialized")
+	}
+	gauges := map[string]metric.Int64ObservableGauge{
+		QueuePending:   QueueSizeGauge,
+		QueueScheduled: ScheduledQueueGauge,
+		QueueRetrying:  RetryingQueueGauge,
+	}
+	return Meter.RegisterCallback(func(ctx context.Context, observer metric.Observer) error {
+		sizes, err := read(ctx)
+		if err != nil {
+			return err
+		}
+		for _, size := range sizes {
+			gauge, ok := gauges[size.Queue]
+			if !ok {
+				continue
+			}
+			if size.Priority == "" {
+				observer.ObserveInt64(gauge, size.Count)
+				continue
+			}
+			observer.ObserveInt64(gauge, size.Count, metric.WithAttributes(attribute.String("notification.priority", size.Priority)))
+		}
+		return nil
+	}, QueueSizeGauge, ScheduledQueueGauge, RetryingQueueGauge)
+}
+
+// RecordDeviceCounts sets the registered device counts by platform that the
+// devices.registered gauge reports until the next call
+func RecordDeviceCounts(counts map[string]int64) {
+	deviceCounts.Store(&counts)
+}
+
+type testSendKey struct{}
+
+// WithTestSend marks ctx as carrying an operator test notification. Delivery code skips
+// queues and delivery statistics for it, and metrics mark it with notification.test.
+func WithTestSend(ctx context.Context) context.Context {
+	return context.WithValue(ctx, testSendKey{}, true)
+}
+
+// IsTestSend reports whether ctx carries an operator test notification
+func IsTestSend(ctx context.Context) bool {
+	test, _ := ctx.Value(testSendKey{}).(bool)
+	return test
 }
@@ -2,29 +2,37 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"notification-service/internal/config"
+	"notification-service/internal/faults"
+	"notification-service/internal/grpcapi"
 	"notification-service/internal/handlers"
 	"notification-service/internal/middleware"
 	"notification-service/internal/models"
+	"notification-service/internal/router"
 	"notification-service/internal/services"
+	"notification-service/internal/storage"
 	"notification-service/internal/telemetry"
 
 	"github.com/gin-gonic/gin"
-	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"google.golang.org/grpc"
 )
 
 func main() {
 	// Load configuration
 	cfg := config.Load()
 
+	// Structured logging, exported through OpenTelemetry once it is initialized
+	telemetry.InitLogging(cfg)
+
 	// Initialize OpenTelemetry
 	shutdown, err := telemetry.InitTelemetry(cfg)
 	if err != nil {
@@ -36,109 +44,519 @@ func main() {
 		}
 	}()
 
+	// Internal fault points for chaos scenarios
+	if err := faults.Configure(cfg.FailureInjectionEnabled, cfg.FaultPoints); err != nil {
+		log.Printf("ERROR: Ignoring invalid FAULT_POINTS entries: %v", err)
+	}
+	faults.SetDryRun(cfg.FailureInjectionDryRun)
+
+	// IDs minted here carry this deployment's region
+	services.SetIDRegion(cfg.ServiceRegion)
+
+	// Apply database migrations; a schema newer than this binary keeps readiness failing
+	schemaGate := storage.NewSchemaGate(cfg.DatabaseURL)
+	defer schemaGate.Close()
+	if cfg.DatabaseMigrateOnStartup {
+		if _, err := storage.MigrateSchema(context.Background(), cfg.DatabaseURL); err != nil {
+			log.Printf("Database migration skipped: %v", err)
+		}
+	}
+
+	// Background work runs until shutdown cancels this context
+	runCtx, stopRunning := context.WithCancel(context.Background())
+	defer stopRunning()
+
 	// Initialize services
-	redisClient := services.NewRedisClient(cfg.RedisURL)
+	redisClient := services.NewRedisClient(cfg)
 	defer redisClient.Close()
+	redisClient.StartBuffer(runCtx)
+
+	eventHubService := services.NewEventHubService(cfg)
+	serviceBusService := services.NewServiceBusService(cfg)
+
+	eventHubProducer := services.NewEventHubProducer(cfg)
+	if err := eventHubProducer.Start(context.Background()); err != nil {
+		log.Printf("Error starting Event Hub producer: %v", err)
+	}
+
+	// Postgres is the durable notification store behind Redis; with DATABASE_URL empty the
+	// service runs on Redis alone. A database that is down at startup keeps readiness
+	// failing until the connection pool reaches it.
+	var notificationRepo storage.NotificationRepository
+	if cfg.DatabaseURL != "" {
+		if repo, err := storage.OpenPostgresNotificationRepository(cfg.DatabaseURL); err != nil {
+			log.Printf("Notification database unavailable, storing notifications in Redis only: %v", err)
+		} else {
+			defer repo.Close()
+			if err := repo.Ping(context.Background()); err != nil {
+				log.Printf("Notification database unreachable, reconnecting on use: %v", err)
+			}
+			notificationRepo = repo
+		}
+	}
+
+	// Tenants pinned to a data region keep their notifications in its Redis and Postgres
+	dataResidency := services.NewDataResidency(runCtx, cfg, redisClient, notificationRepo)
+	defer dataResidency.Close()
+	notificationRepo = dataResidency.Repository()
+
+	var engagementRepo storage.EngagementRepository
+	if repo, err := storage.NewPostgresEngagementRepository(context.Background(), cfg.DatabaseURL); err != nil {
+		log.Printf("Engagement event store unavailable: %v", err)
+	} else {
+		defer repo.Close()
+		engagementRepo = repo
+	}
+
+	// Queue gauges read the queued notification counts whenever metrics are collected
+	if registration, err := services.NewQueueMonitor(cfg, redisClient, notificationRepo).Register(); err != nil {
+		log.Printf("Queue size gauges unavailable: %v", err)
+	} else {
+		defer registration.Unregister()
+	}
+
+	metadataIndex := services.NewMetadataIndex(cfg, redisClient, dataResidency)
+	payloadSampler := services.NewProviderPayloadSampler(cfg, dataResidency)
+	payloadLogger := services.NewPayloadLogger(cfg, redisClient)
+	providerThrottle := services.NewProviderThrottle(cfg, redisClient)
+	fairDispatcher := services.NewFairDispatcher(cfg)
+	retryPolicies := services.NewRetryPolicies(cfg, redisClient, providerThrottle, fairDispatcher)
+	linkTracker := services.NewLinkTracker(cfg)
+	replyAddresses := services.NewReplyAddresses(cfg)
+	emailService := services.NewEmailService(cfg, services.DeliverySMTPPrimary, payloadSampler, retryPolicies, linkTracker, replyAddresses)
+	smsService := services.NewSMSService(cfg, services.DeliveryTwilioPrimary, payloadSampler, retryPolicies)
+	deviceRegistry := services.NewDeviceRegistry(cfg, redisClient)
+	deviceRegistry.Start(runCtx)
+	pushService := services.NewPushNotificationService(cfg, services.NewSilentPushLimiter(cfg, redisClient), deviceRegistry)
+	signingKeys := services.NewSigningKeyService(cfg, redisClient)
+	signingKeys.Start(runCtx)
+	preferenceService := services.NewCustomerPreferenceService(cfg, redisClient)
+	webhookService := services.NewWebhookService(cfg, redisClient, dataResidency, payloadSampler, retryPolicies, signingKeys, preferenceService)
+	teamsService := services.NewTeamsService(cfg, payloadSampler, retryPolicies)
+
+	// Channels with a secondary provider spread their sends across both by health
+	providerRouting := services.NewProviderRouting(cfg)
+	emailProviders := []services.RoutedProvider{{Name: services.DeliverySMTPPrimary, Sender: emailService}}
+	if secondary := services.SecondarySMTPConfig(cfg); secondary != nil {
+		emailProviders = append(emailProviders, services.RoutedProvider{Name: services.DeliverySMTPSecondary,
+			Sender: services.NewEmailService(secondary, services.DeliverySMTPSecondary, payloadSampler, retryPolicies, linkTracker, replyAddresses)})
+	}
+	smsProviders := []services.RoutedProvider{{Name: services.DeliveryTwilioPrimary, Sender: smsService}}
+	if secondary := services.SecondaryTwilioConfig(cfg); secondary != nil {
+		smsProviders = append(smsProviders, services.RoutedProvider{Name: services.DeliveryTwilioSecondary,
+			Sender: services.NewSMSService(secondary, services.DeliveryTwilioSecondary, payloadSampler, retryPolicies)})
+	}
+	emailSender := providerRouting.Route(models.NotificationTypeEmail, emailProviders...)
+	smsSender := providerRouting.Route(models.NotificationTypeSMS, smsProviders...)
+	if registration, err := providerRouting.Register(); err != nil {
+		log.Printf("Provider routing weight gauge unavailable: %v", err)
+	} else {
+		defer registration.Unregister()
+	}
+	if registration, err := providerThrottle.Register(); err != nil {
+		log.Printf("Provider throttle gauge unavailable: %v", err)
+	} else {
+		defer registration.Unregister()
+	}
 
-	eventHubService := services.NewEventHubService(cfg.EventHubConnectionString, cfg.EventHubName)
-	defer eventHubService.Close()
+	channelSenders := map[models.NotificationType]services.ChannelSender{
+		models.NotificationTypeEmail:   emailSender,
+		models.NotificationTypeSMS:     smsSender,
+		models.NotificationTypePush:    pushService,
+		models.NotificationTypeWebhook: webhookService,
+		models.NotificationTypeTeams:   teamsService,
+	}
+	smsConsentService := services.NewSMSConsentService(cfg, redisClient, preferenceService)
+	blackoutCalendars := services.NewBlackoutCalendars(cfg, redisClient)
+	// Content screening applies to every path that sends content
+	contentScreening := services.NewContentScreening(cfg)
+	deadLetterQueue := services.NewDeadLetterQueue(cfg, redisClient, channelSenders, preferenceService, blackoutCalendars, dataResidency, contentScreening)
+	retryOrchestrator := services.NewRetryOrchestrator(cfg, redisClient, retryPolicies, channelSenders, preferenceService, blackoutCalendars)
+	notificationService := services.NewNotificationService(redisClient, eventHubService, eventHubProducer, metadataIndex, notificationRepo, deadLetterQueue, retryOrchestrator, dataResidency)
+	retryOrchestrator.Start(runCtx, notificationService)
+	notificationService.Start(runCtx)
 
-	notificationService := services.NewNotificationService(redisClient, eventHubService)
-	emailService := services.NewEmailService(cfg)
-	smsService := services.NewSMSService(cfg)
-	pushService := services.NewPushNotificationService(cfg)
-	webhookService := services.NewWebhookService(cfg)
+	templateEvents := services.NewTemplateEventPublisher(cfg)
+	templateService := services.NewTemplateService(cfg, redisClient, templateEvents, services.NewLanguageResolver(cfg, redisClient, preferenceService), services.NewTemplateTranslator(cfg, redisClient))
 
 	// Initialize WebSocket hub
 	wsHub := models.NewWebSocketHub()
+	wsHub.SetReconnectBackoff(services.NewReconnectBackoff(cfg))
+	var messageBuffer *services.WebSocketMessageBuffer
+	if cfg.WebSocketReplayBufferSize > 0 {
+		messageBuffer = services.NewWebSocketMessageBuffer(cfg, redisClient)
+		wsHub.SetMessageBuffer(messageBuffer)
+	}
+	if cfg.WebSocketSigningEnabled {
+		wsHub.SetMessageSigner(signingKeys)
+	}
 	go wsHub.Run()
 
+	presenceService := services.NewPresenceService(cfg, redisClient, wsHub, eventHubProducer)
+	presenceService.Start(runCtx)
+
+	contentDeduper := services.NewContentDeduper(cfg, redisClient)
+	broadcastService := services.NewBroadcastService(cfg, redisClient, wsHub, channelSenders, preferenceService, contentDeduper, deadLetterQueue, presenceService, contentScreening)
+	broadcastService.Start(runCtx)
+	scheduledDispatcher := services.NewScheduledDispatcher(cfg, redisClient, wsHub, channelSenders, preferenceService, blackoutCalendars)
+	scheduledDispatcher.Start(runCtx, notificationService)
+	announcementService := services.NewAnnouncementService(redisClient, wsHub)
+	announcementService.Start(runCtx)
+	usageTracker := services.NewUsageTracker(redisClient)
+	usageTracker.Start(runCtx)
+	apiKeys := middleware.NewAPIKeys(cfg.APIKeys)
+
+	digestService := services.NewDigestService(cfg, notificationRepo, redisClient.ReadOnly(), deadLetterQueue, emailSender, webhookService)
+	digestService.Start(runCtx)
+
+	customerDigests := services.NewCustomerDigests(cfg, redisClient, notificationService, preferenceService, wsHub, emailSender)
+	customerDigests.Start(runCtx)
+	sendTimeOptimizer := services.NewSendTimeOptimizer(cfg, redisClient, engagementRepo)
+	coalescer := services.NewCoalescer(time.Duration(cfg.WebSocketCoalesceWindowMs) * time.Millisecond)
+
+	fallbackQueue := services.NewFallbackQueue(cfg, redisClient)
+
 	// Initialize handlers
 	notificationHandler := handlers.NewNotificationHandler(
 		notificationService,
-		emailService,
-		smsService,
+		emailSender,
+		smsSender,
 		pushService,
 		webhookService,
 		wsHub,
+		templateService,
+		presenceService,
+		services.NewRoutingPolicy(cfg),
+		fallbackQueue,
+		coalescer,
+		contentScreening,
+		sendTimeOptimizer,
+		deadLetterQueue,
+		retryOrchestrator,
+		preferenceService,
+		services.NewIdempotencyStore(cfg, redisClient),
+		contentDeduper,
+		deviceRegistry,
+		services.NewRecipientNormalizer(cfg),
+		blackoutCalendars,
+		customerDigests,
+		cfg.BulkWorkers,
 	)
+	fallbackQueue.Start(runCtx, notificationHandler.Fallback)
+	if cfg.TemplateApprovalRequired && cfg.TemplateApprovalSecret == "" {
+		log.Printf("TEMPLATE_APPROVAL_SECRET is not set: template approval callbacks will be refused")
+	}
+	templateHandler := handlers.NewTemplateHandler(templateService, cfg.TemplateApprovalSecret)
+	broadcastHandler := handlers.NewBroadcastHandler(broadcastService)
+	announcementHandler := handlers.NewAnnouncementHandler(announcementService)
+	digestHandler := handlers.NewDigestHandler(digestService)
+	usageHandler := handlers.NewUsageHandler(usageTracker)
+	metadataIndexHandler := handlers.NewMetadataIndexHandler(metadataIndex)
+	dataResidencyHandler := handlers.NewDataResidencyHandler(dataResidency)
+	providerRoutingHandler := handlers.NewProviderRoutingHandler(providerRouting)
+	presenceHandler := handlers.NewPresenceHandler(presenceService)
+	engagementService := services.NewEngagementService(engagementRepo, sendTimeOptimizer)
+	engagementHandler := handlers.NewEngagementHandler(engagementService)
+	trackingHandler := handlers.NewTrackingHandler(linkTracker, notificationService, engagementService)
+	providerPayloadHandler := handlers.NewProviderPayloadHandler(payloadSampler)
+	webhookHandler := handlers.NewWebhookHandler(webhookService)
+	retryPolicyHandler := handlers.NewRetryPolicyHandler(retryPolicies, providerThrottle, retryOrchestrator)
+	payloadLoggingHandler := handlers.NewPayloadLoggingHandler(payloadLogger)
+	blackoutHandler := handlers.NewBlackoutHandler(blackoutCalendars)
+	sendTimeHandler := handlers.NewSendTimeHandler(sendTimeOptimizer)
+	deadLetterHandler := handlers.NewDeadLetterHandler(deadLetterQueue, notificationService)
+	redriveService := services.NewRedriveService(cfg, redisClient, notificationService, contentScreening)
+	redriveService.Start(runCtx)
+	redriveHandler := handlers.NewRedriveHandler(redriveService)
+	testSendHandler := handlers.NewTestSendHandler(services.NewTestSendService(channelSenders, wsHub, contentScreening))
+	preferenceTransferHandler := handlers.NewPreferenceTransferHandler(preferenceService)
+	signingKeyHandler := handlers.NewSigningKeyHandler(signingKeys)
+	deviceHandler := handlers.NewDeviceHandler(deviceRegistry)
+	capabilitiesHandler := handlers.NewCapabilitiesHandler(services.NewCapabilityReporter(cfg, providerThrottle, fairDispatcher, payloadSampler, deadLetterQueue, messageBuffer))
+	deliveryStatsHandler := handlers.NewDeliveryStatsHandler(services.NewDeliveryAnalytics(cfg, redisClient, notificationRepo), notificationService, smsConsentService)
+	// Inbound SMS to either Twilio account's numbers is signed with that account's token
+	twilioAuthTokens := []string{cfg.TwilioAuthToken}
+	if secondary := services.SecondaryTwilioConfig(cfg); secondary != nil {
+		twilioAuthTokens = append(twilioAuthTokens, secondary.TwilioAuthToken)
+	}
+	smsInboundHandler := handlers.NewSMSInboundHandler(smsConsentService, twilioAuthTokens, cfg.TwilioInboundWebhookURL)
+	emailReplyService := services.NewEmailReplyService(cfg, redisClient, replyAddresses, notificationService, engagementService)
+	emailInboundHandler := handlers.NewEmailInboundHandler(emailReplyService, cfg.InboundEmailToken)
+	customerDigestHandler := handlers.NewCustomerDigestHandler(customerDigests)
+	conversationHandler := handlers.NewConversationHandler(services.NewConversationService(redisClient, notificationService, emailReplyService))
 
-	// Setup Gin router
+	// Setup router; gin's mode only matters in gin router mode
 	if cfg.Environment == "production" {
 		gin.SetMode(gin.ReleaseMode)
 	}
 
-	router := gin.New()
+	readinessChecker := services.NewReadinessChecker(cfg, redisClient, notificationRepo, eventHubService, schemaGate)
+
+	listeners, err := services.Listen(cfg)
+	if err != nil {
+		log.Fatalf("Failed to start server: %v", err)
+	}
+	serviceInfo := models.ServiceInfo{
+		Service:     cfg.ServiceName,
+		Version:     "1.0.0",
+		Environment: cfg.Environment,
+		Listeners:   services.DescribeListeners(listeners),
+	}
+
+	routes, err := router.New(cfg.RouterMode, "notification-service")
+	if err != nil {
+		log.Fatalf("Failed to set up router: %v", err)
+	}
 
 	// Middleware
-	router.Use(gin.Logger())
-	router.Use(gin.Recovery())
-	router.Use(otelgin.Middleware("notification-service"))
-	router.Use(middleware.CORSMiddleware())
-	router.Use(middleware.FailureInjectionMiddleware(cfg))
-	router.Use(middleware.MetricsMiddleware())
+	routes.Use(middleware.MetricsMiddleware())
+	routes.Use(middleware.PayloadLoggingMiddleware(payloadLogger))
+	routes.Use(middleware.CORSMiddleware())
+
+	// Email tracking links are followed by mail clients, which have no token; their
+	// signature authenticates them
+	routes.GET("/t/open/:id", trackingHandler.TrackOpen)
+	routes.GET("/t/click/:id", trackingHandler.TrackClick)
+
+	// Twilio posts inbound SMS without a token; its signature authenticates them, so
+	// without TWILIO_AUTH_TOKEN there is no route to post to
+	if cfg.TwilioAuthToken != "" {
+		routes.POST("/sms/inbound", smsInboundHandler.ReceiveSMS)
+	} else {
+		log.Printf("TWILIO_AUTH_TOKEN is not set: inbound SMS keywords are not received")
+	}
+
+	// Inbound parse relays post replies without a JWT; INBOUND_EMAIL_TOKEN authenticates
+	// them, so without it there is no route to post to
+	if cfg.InboundEmailToken != "" {
+		routes.POST("/email/inbound", emailInboundHandler.ReceiveEmail)
+	} else {
+		log.Printf("INBOUND_EMAIL_TOKEN is not set: email replies are not received")
+	}
+
+	// Webhook and WebSocket consumers fetch the public signing keys without a token
+	routes.GET("/.well-known/jwks.json", signingKeyHandler.GetJWKS)
+
+	if cfg.AuthEnabled && (cfg.AuthIssuer == "" || cfg.AuthAudience == "") {
+		log.Fatalf("AUTH_ENABLED needs AUTH_ISSUER and AUTH_AUDIENCE, so tokens for other issuers or APIs are refused")
+	}
+	if cfg.AuthEnabled {
+		var allowlist []string
+		for _, path := range strings.Split(cfg.AuthAllowlist, ",") {
+			if path = strings.TrimSpace(path); path != "" {
+				allowlist = append(allowlist, path)
+			}
+		}
+		routes.Use(middleware.AuthMiddleware(services.NewJWTVerifier(cfg), allowlist))
+	}
+	// After authentication, so fault override headers are only honored from admins
+	routes.Use(middleware.FailureInjectionMiddleware(cfg))
 
 	// Health check endpoints
-	router.GET("/health", handlers.HealthCheck)
-	router.GET("/health/ready", handlers.ReadinessCheck)
-	router.GET("/health/live", handlers.LivenessCheck)
+	routes.GET("/health", handlers.HealthCheck)
+	routes.GET("/health/ready", handlers.ReadinessCheck(readinessChecker, serviceInfo))
+	routes.GET("/health/live", handlers.LivenessCheck)
+	routes.GET("/info", handlers.InfoHandler(serviceInfo))
 
 	// Metrics endpoint
-	router.GET("/metrics", handlers.MetricsHandler)
+	routes.GET("/metrics", handlers.MetricsHandler)
 
-	// API routes
-	api := router.Group("/api/v1")
+	// API routes; a deprecated route takes middleware.Deprecated ahead of its handler
+	api := routes.Group("/api/v1")
+	api.Use(middleware.ReadOnlyMiddleware(redisClient.ReadOnly()))
+	api.Use(middleware.APIKeyMiddleware(apiKeys))
+	api.Use(middleware.UsageMiddleware(usageTracker))
+	if cfg.RateLimitEnabled {
+		api.Use(middleware.RateLimitMiddleware(services.NewRateLimiter(cfg, redisClient)))
+	}
 	{
+		// What this deployment supports
+		api.GET("/capabilities", capabilitiesHandler.GetCapabilities)
+
 		// Notification endpoints
 		api.POST("/notifications", notificationHandler.CreateNotification)
 		api.GET("/notifications", notificationHandler.GetNotifications)
 		api.GET("/notifications/:id", notificationHandler.GetNotification)
+		api.PATCH("/notifications/:id", notificationHandler.PatchNotification)
+		api.GET("/notifications/:id/edits", notificationHandler.GetNotificationEdits)
+		api.GET("/notifications/:id/provider-payloads", providerPayloadHandler.GetProviderPayloads)
+		api.GET("/notifications/:id/webhook-attempts", webhookHandler.GetWebhookAttempts)
+		api.GET("/notifications/:id/replies", middleware.Deprecated(middleware.Deprecation{
+			Since:     time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC),
+			Sunset:    time.Date(2027, 4, 1, 0, 0, 0, 0, time.UTC),
+			Successor: "/api/v1/conversations/:id",
+		}), emailInboundHandler.GetReplies)
+		api.POST("/notifications/:id/cancel", notificationHandler.CancelNotification)
+		api.POST("/notifications/:id/resend", notificationHandler.ResendNotification)
+		api.POST("/notifications/cancel", notificationHandler.CancelOrderNotifications)
 		api.PUT("/notifications/:id/status", notificationHandler.UpdateNotificationStatus)
 		api.DELETE("/notifications/:id", notificationHandler.DeleteNotification)
 
+		// Conversation endpoints
+		api.GET("/conversations/:id", conversationHandler.GetConversation)
+
 		// Template endpoints
-		api.POST("/templates", notificationHandler.CreateTemplate)
-		api.GET("/templates", notificationHandler.GetTemplates)
-		api.GET("/templates/:id", notificationHandler.GetTemplate)
-		api.PUT("/templates/:id", notificationHandler.UpdateTemplate)
-		api.DELETE("/templates/:id", notificationHandler.DeleteTemplate)
+		api.POST("/templates", templateHandler.CreateTemplate)
+		api.GET("/templates", templateHandler.GetTemplates)
+		api.GET("/templates/:id", templateHandler.GetTemplate)
+		api.PUT("/templates/:id", templateHandler.UpdateTemplate)
+		api.DELETE("/templates/:id", templateHandler.DeleteTemplate)
+		api.POST("/templates/:id/publish", templateHandler.PublishTemplate)
+		api.POST("/templates/:id/approval", templateHandler.ApproveTemplate)
+		api.POST("/templates/:id/rollback", templateHandler.RollbackTemplate)
+		api.GET("/templates/:id/versions", templateHandler.GetTemplateVersions)
+		api.GET("/templates/:id/versions/:version", templateHandler.GetTemplateVersion)
+		api.GET("/templates/:id/diff", templateHandler.DiffTemplateVersions)
 
 		// Bulk operations
 		api.POST("/notifications/bulk", notificationHandler.SendBulkNotifications)
-		api.POST("/notifications/broadcast", notificationHandler.BroadcastNotification)
+		api.POST("/notifications/broadcast", broadcastHandler.BroadcastNotification)
+		api.POST("/notifications/redrive", middleware.RequireRole(handlers.AdminRole), redriveHandler.RedriveNotifications)
+		api.GET("/redrives/:id", middleware.RequireRole(handlers.AdminRole), redriveHandler.GetRedrive)
+		api.POST("/redrives/:id/confirm", middleware.RequireRole(handlers.AdminRole), redriveHandler.ConfirmRedrive)
+
+		// Broadcast approvals
+		api.GET("/broadcasts/:id", broadcastHandler.GetBroadcast)
+		api.GET("/broadcasts/:id/audit", broadcastHandler.GetBroadcastAudit)
+		api.POST("/broadcasts/:id/approve", middleware.RequireRole(handlers.BroadcastApproverRole), broadcastHandler.ApproveBroadcast)
+		api.POST("/broadcasts/:id/reject", middleware.RequireRole(handlers.BroadcastApproverRole), broadcastHandler.RejectBroadcast)
+
+		// System announcements
+		api.GET("/announcements", announcementHandler.ListAnnouncements)
+		api.POST("/announcements", middleware.RequireRole(handlers.AdminRole), announcementHandler.CreateAnnouncement)
+		api.DELETE("/announcements/:id", middleware.RequireRole(handlers.AdminRole), announcementHandler.EndAnnouncement)
 
 		// Customer preferences
 		api.GET("/customers/:customerId/preferences", notificationHandler.GetCustomerPreferences)
 		api.PUT("/customers/:customerId/preferences", notificationHandler.UpdateCustomerPreferences)
+		api.POST("/customers/preferences/import", middleware.RequireRole(handlers.AdminRole), preferenceTransferHandler.ImportPreferences)
+		api.GET("/customers/preferences/export", middleware.RequireRole(handlers.AdminRole), preferenceTransferHandler.ExportPreferences)
+
+		// Customer devices
+		api.GET("/customers/:customerId/devices", deviceHandler.ListDevices)
+		api.PUT("/customers/:customerId/devices/:deviceId", deviceHandler.RegisterDevice)
+		api.DELETE("/customers/:customerId/devices/:deviceId", deviceHandler.DeleteDevice)
+		api.GET("/customers/:customerId/presence", presenceHandler.GetCustomerPresence)
+		api.GET("/customers/:customerId/send-time-profile", sendTimeHandler.GetSendTimeProfile)
+		api.GET("/customers/:customerId/digests", customerDigestHandler.GetCustomerDigests)
+		api.POST("/customers/:customerId/digests/flush", customerDigestHandler.FlushCustomerDigests)
 
 		// Analytics
-		api.GET("/analytics/delivery-stats", notificationHandler.GetDeliveryStats)
-		api.GET("/analytics/engagement-metrics", notificationHandler.GetEngagementMetrics)
+		api.GET("/analytics/delivery-stats", deliveryStatsHandler.GetDeliveryStats)
+		api.GET("/analytics/engagement-metrics", engagementHandler.GetEngagementMetrics)
+		api.GET("/analytics/webhook-deliveries", webhookHandler.GetWebhookStats)
+
+		// Engagement events
+		api.POST("/engagement/events", engagementHandler.RecordEngagementEvent)
+		api.GET("/engagement/events", engagementHandler.GetEngagementEvents)
+
+		// Admin
+		api.GET("/admin/eventhub/failover", middleware.RequireRole(handlers.AdminRole), notificationHandler.GetEventHubFailoverStatus)
+		api.POST("/admin/test-sends", middleware.RequireRole(handlers.AdminRole), testSendHandler.SendTestNotification)
+		api.GET("/admin/digests/preview", middleware.RequireRole(handlers.AdminRole), digestHandler.PreviewDigest)
+		api.POST("/admin/digests", middleware.RequireRole(handlers.AdminRole), digestHandler.SendDigest)
+		api.GET("/admin/faults", middleware.RequireRole(handlers.AdminRole), handlers.GetFaultRules)
+		api.PUT("/admin/faults", middleware.RequireRole(handlers.AdminRole), handlers.SetFaultRules)
+		api.DELETE("/admin/faults", middleware.RequireRole(handlers.AdminRole), handlers.ClearFaultRules)
+		api.GET("/admin/faults/dry-run", middleware.RequireRole(handlers.AdminRole), handlers.GetFaultDryRun)
+		api.PUT("/admin/faults/dry-run", middleware.RequireRole(handlers.AdminRole), handlers.SetFaultDryRun)
+		api.GET("/admin/metadata-indexes/:tenantId", middleware.RequireRole(handlers.AdminRole), metadataIndexHandler.GetIndexedKeys)
+		api.POST("/admin/metadata-indexes/:tenantId", middleware.RequireRole(handlers.AdminRole), metadataIndexHandler.RegisterIndexedKey)
+		api.DELETE("/admin/metadata-indexes/:tenantId/:key", middleware.RequireRole(handlers.AdminRole), metadataIndexHandler.UnregisterIndexedKey)
+		api.GET("/admin/retry-policies", middleware.RequireRole(handlers.AdminRole), retryPolicyHandler.GetRetryPolicies)
+		api.PUT("/admin/retry-policies/:channel", middleware.RequireRole(handlers.AdminRole), retryPolicyHandler.SetRetryPolicy)
+		api.DELETE("/admin/retry-policies/:channel", middleware.RequireRole(handlers.AdminRole), retryPolicyHandler.ResetRetryPolicy)
+		api.GET("/admin/provider-throttles", middleware.RequireRole(handlers.AdminRole), retryPolicyHandler.GetProviderThrottles)
+		api.GET("/admin/provider-routing", middleware.RequireRole(handlers.AdminRole), providerRoutingHandler.GetProviderRouting)
+		api.GET("/admin/payload-logging", middleware.RequireRole(handlers.AdminRole), payloadLoggingHandler.GetPayloadLogging)
+		api.PUT("/admin/payload-logging", middleware.RequireRole(handlers.AdminRole), payloadLoggingHandler.SetPayloadLogging)
+		api.DELETE("/admin/payload-logging", middleware.RequireRole(handlers.AdminRole), payloadLoggingHandler.ResetPayloadLogging)
+		api.GET("/admin/dead-letters", middleware.RequireRole(handlers.AdminRole), deadLetterHandler.GetDeadLetters)
+		api.GET("/admin/dead-letters/:id", middleware.RequireRole(handlers.AdminRole), deadLetterHandler.GetDeadLetter)
+		api.POST("/admin/dead-letters/:id/redrive", middleware.RequireRole(handlers.AdminRole), deadLetterHandler.RedriveDeadLetter)
+		api.DELETE("/admin/dead-letters/:id", middleware.RequireRole(handlers.AdminRole), deadLetterHandler.DiscardDeadLetter)
+		api.GET("/admin/send-time/stats", middleware.RequireRole(handlers.AdminRole), sendTimeHandler.GetSendTimeStats)
+		api.GET("/admin/blackouts", middleware.RequireRole(handlers.AdminRole), blackoutHandler.GetBlackoutCalendars)
+		api.GET("/admin/blackouts/:tenantId", middleware.RequireRole(handlers.AdminRole), blackoutHandler.GetBlackoutCalendar)
+		api.PUT("/admin/blackouts/:tenantId", middleware.RequireRole(handlers.AdminRole), blackoutHandler.SetBlackoutCalendar)
+		api.DELETE("/admin/blackouts/:tenantId", middleware.RequireRole(handlers.AdminRole), blackoutHandler.DeleteBlackoutCalendar)
+		api.GET("/admin/data-residency", middleware.RequireRole(handlers.AdminRole), dataResidencyHandler.GetDataResidency)
+		api.GET("/admin/apikeys", middleware.RequireRole(handlers.AdminRole), usageHandler.GetAPIKeys)
+		api.GET("/admin/apikeys/:id/usage", middleware.RequireRole(handlers.AdminRole), usageHandler.GetAPIKeyUsage)
+		api.GET("/admin/signing-keys", middleware.RequireRole(handlers.AdminRole), signingKeyHandler.GetSigningKeys)
+		api.POST("/admin/signing-keys", middleware.RequireRole(handlers.AdminRole), signingKeyHandler.CreateSigningKey)
+		api.POST("/admin/signing-keys/rotate", middleware.RequireRole(handlers.AdminRole), signingKeyHandler.RotateSigningKeys)
+		api.GET("/admin/signing-keys/:id", middleware.RequireRole(handlers.AdminRole), signingKeyHandler.GetSigningKey)
+		api.PATCH("/admin/signing-keys/:id", middleware.RequireRole(handlers.AdminRole), signingKeyHandler.UpdateSigningKey)
+		api.DELETE("/admin/signing-keys/:id", middleware.RequireRole(handlers.AdminRole), signingKeyHandler.DeleteSigningKey)
+
+		// Chaos experiments
+		api.GET("/chaos/experiments", middleware.RequireRole(handlers.AdminRole), handlers.GetChaosExperiments)
+		api.POST("/chaos/experiments", middleware.RequireRole(handlers.AdminRole), handlers.StartChaosExperiment)
+		api.POST("/chaos/experiments/:id/stop", middleware.RequireRole(handlers.AdminRole), handlers.StopChaosExperiment)
+	}
+
+	// Demo endpoints emit synthetic telemetry for presentations
+	if cfg.DemoEndpointsEnabled {
+		demo := api.Group("/demo")
+		demo.POST("/metrics", handlers.EmitDemoMetric)
+		demo.POST("/traces", handlers.GenerateDemoTrace)
+		demo.POST("/logs", handlers.GenerateDemoLogs)
 	}
 
 	// WebSocket endpoint
-	router.GET("/ws", notificationHandler.HandleWebSocket)
+	routes.GET("/ws", notificationHandler.HandleWebSocket)
 
-	// Start event processing in background
+	// Start event processing in background; an event reaching both regions is handled once
+	processEvent := services.NewEventDeduplicator(cfg, redisClient).Wrap(notificationHandler.ProcessEventHubMessage)
 	go func() {
-		if err := eventHubService.StartProcessing(context.Background(), notificationHandler.ProcessEventHubMessage); err != nil {
+		if err := eventHubService.StartProcessing(runCtx, processEvent); err != nil {
 			log.Printf("Error starting event processing: %v", err)
 		}
 	}()
+	go func() {
+		if err := serviceBusService.StartProcessing(runCtx, processEvent); err != nil {
+			log.Printf("Error starting Service Bus processing: %v", err)
+		}
+	}()
 
 	// Start HTTP server
 	server := &http.Server{
-		Addr:    fmt.Sprintf(":%s", cfg.Port),
-		Handler: router,
+		Handler: routes.Handler(),
 	}
 
-	// Start server in goroutine
-	go func() {
-		log.Printf("Notification service starting on port %s", cfg.Port)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Failed to start server: %v", err)
+	// Serve each listener in its own goroutine; Shutdown closes them all
+	for i, listener := range listeners {
+		go func() {
+			log.Printf("Notification service listening on %s %s", serviceInfo.Listeners[i].Network, serviceInfo.Listeners[i].Address)
+			if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Failed to start server: %v", err)
+			}
+		}()
+	}
+
+	// gRPC API for internal callers, on the same service layer as the REST handlers
+	var grpcServer *grpc.Server
+	if cfg.GRPCPort != "" {
+		options := grpcapi.ServerOptions{ReadOnly: redisClient.ReadOnly(), APIKeys: apiKeys, Usage: usageTracker}
+		if cfg.AuthEnabled {
+			options.Verifier = services.NewJWTVerifier(cfg)
 		}
-	}()
+		if cfg.RateLimitEnabled {
+			options.RateLimit = services.NewRateLimiter(cfg, redisClient)
+		}
+		grpcServer = grpcapi.NewServer(grpcapi.NewNotificationServer(cfg, notificationHandler, notificationService), options)
+		grpcListener, err := net.Listen("tcp", ":"+cfg.GRPCPort)
+		if err != nil {
+			log.Fatalf("Failed to start gRPC server: %v", err)
+		}
+		go func() {
+			log.Printf("Notification gRPC API listening on %s", grpcListener.Addr())
+			if err := grpcServer.Serve(grpcListener); err != nil {
+				log.Fatalf("Failed to start gRPC server: %v", err)
+			}
+		}()
+	}
 
 	// Wait for interrupt signal to gracefully shutdown the server
 	quit := make(chan os.Signal, 1)
@@ -147,12 +565,50 @@ func main() {
 	log.Println("Shutting down notification service...")
 
 	// Graceful shutdown with timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeoutSeconds)*time.Second)
 	defer cancel()
 
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
+	if grpcServer != nil {
+		// Open status streams would hold off a graceful stop until the deadline
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			grpcServer.Stop()
+		}
+	}
+
+	// Stop background work and take no more events; partition processors and the Service
+	// Bus receiver finish the batch they are handling
+	stopRunning()
+	if err := eventHubService.Close(ctx); err != nil {
+		log.Printf("Error closing Event Hub consumer: %v", err)
+	}
+	if err := serviceBusService.Close(ctx); err != nil {
+		log.Printf("Error closing Service Bus consumer: %v", err)
+	}
+
+	// Flush notifications still held back: coalesced WebSocket updates, writes buffered
+	// during a Redis outage and batched lifecycle events
+	coalescer.Flush()
+	if pending := redisClient.FlushBuffer(ctx); pending > 0 {
+		log.Printf("WARN: %d buffered Redis writes could not be flushed before shutdown", pending)
+	}
+	if err := eventHubProducer.Close(ctx); err != nil {
+		log.Printf("Error closing Event Hub producer: %v", err)
+	}
+
+	// Clients are sent what is queued for them, then a going-away close frame
+	if err := wsHub.Close(ctx); err != nil {
+		log.Printf("Error closing WebSocket connections: %v", err)
+	}
 
 	log.Println("Notification service stopped")
 }
\ No newline at end of file